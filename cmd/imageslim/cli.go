@@ -0,0 +1,639 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/config"
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+	"github.com/brunovpinheiro/ImageSlim/internal/job"
+)
+
+// ---------------------------------------------------------------------------
+// Subcommands
+// ---------------------------------------------------------------------------
+
+// usageText is the overview printed for -h and for a malformed command
+// line; see help.go.
+var usageText = usage()
+
+// runSubcommand dispatches the non-interactive subcommands and returns the
+// process exit code.
+func runSubcommand(args []string) int {
+	switch args[0] {
+	case "run":
+		return cmdRun(args[1:])
+
+	case "edit":
+		return cmdTUI(args[1:])
+
+	case "batch":
+		return cmdBatch(args[1:])
+
+	case "restore":
+		return cmdRestore(args[1:])
+
+	case "undo":
+		return cmdUndo(args[1:])
+
+	case "orient":
+		return cmdOrient(args[1:])
+
+	case "alpha":
+		return cmdAlpha(args[1:])
+
+	case "approval":
+		return cmdApproval(args[1:])
+
+	case "serve":
+		return cmdServe(args[1:])
+
+	case "replay":
+		return cmdReplay(args[1:])
+
+	case "metrics":
+		return cmdMetrics(args[1:])
+
+	case "formats":
+		return cmdFormats(args[1:])
+
+	case "inspect":
+		return cmdInspect(args[1:])
+
+	case "config":
+		return cmdConfig(args[1:])
+
+	case "gen-testdata":
+		return cmdGenTestdata(args[1:])
+
+	case "help":
+		return cmdHelp(args[1:])
+
+	case "man":
+		return cmdMan(args[1:])
+	}
+
+	fmt.Fprintf(os.Stderr, "imageslim: unknown command %q\n\n%s", args[0], usageText)
+	return 2
+}
+
+// cmdTUI parses the interface flags and starts the TUI, or the line-based
+// plain mode when -plain is given.  A single positional
+// argument names a job file to pre-fill the form with ("imageslim edit").
+func cmdTUI(args []string) int {
+	var ui uiOptions
+	fs := flag.NewFlagSet("imageslim", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageText) }
+	ui.register(fs)
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+	if err := ui.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 2
+	}
+	ui.safeMode = safeMode(ui.safeMode)
+
+	var j *job.Job
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		var err error
+		if j, err = job.Load(fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+			return 1
+		}
+		if ui.safeMode {
+			if err := refuseUnsafe(j); err != nil {
+				fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+				return 1
+			}
+		}
+	default:
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+
+	if ui.plain {
+		return runPlain(os.Stdin, os.Stdout, ui, j)
+	}
+	m := initialModel(ui)
+	if j != nil {
+		m = m.withJob(j)
+	} else if !config.Exists() {
+		path, _ := config.Path() // known: Exists reports true without one
+		m = m.startSetup(path)
+	}
+	return runTUI(m)
+}
+
+// interruptible returns a context that Ctrl+C and SIGTERM cancel, so that
+// a headless run stops its gm processes and reports, rather than leaving
+// them running behind it.
+func interruptible() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// runArgs are the flags of "run", which "batch" shares.
+type runArgs struct {
+	force     bool
+	safeMode  bool
+	gmPath    string
+	gmVersion string
+	over      jobOverrides
+}
+
+// register adds the flags to fs.
+func (a *runArgs) register(fs *flag.FlagSet) {
+	fs.BoolVar(&a.force, "force", false, "reprocess files an earlier run already converted")
+	registerSafeMode(fs, &a.safeMode)
+	registerGMPath(fs, &a.gmPath)
+	registerGMVersion(fs, &a.gmVersion)
+	a.over.register(fs)
+}
+
+// validate checks the flag values before any job is loaded.
+func (a runArgs) validate() error {
+	if err := a.over.validate(); err != nil {
+		return err
+	}
+	_, err := gm.ParseVersion(a.gmVersion)
+	return err
+}
+
+// apply sets the flags on a loaded job.
+func (a runArgs) apply(j *job.Job) {
+	j.Force = j.Force || a.force
+	j.GMPath = a.gmPath
+	if a.gmVersion != "" {
+		j.GMVersion = a.gmVersion
+	}
+	a.over.apply(j)
+}
+
+// cmdRun executes a job file headless, streaming hook output and printing the
+// gm command and its output once finished.
+func cmdRun(args []string) int {
+	var a runArgs
+	fs := newFlagSet("run", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+	if err := a.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 2
+	}
+
+	j, err := job.Load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	a.apply(j)
+	if safeMode(a.safeMode) {
+		if err := refuseUnsafe(j); err != nil {
+			fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+			return 1
+		}
+	}
+	if !checkGM(j.Options()) {
+		return 1
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	o := job.Run(ctx, j, os.Stdout)
+	recordRun("run", j.Name, j.Options(), o.Started, o.Duration, o.Result, o.Err)
+	if o.Result.Command != "" {
+		fmt.Println(o.Result.Command)
+	}
+	if out := strings.TrimSpace(o.Result.Output()); out != "" {
+		fmt.Println(out)
+	}
+	if o.Err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %s: %v\n", j.Label(), o.Err)
+		if s := o.Result.Breakdown(); s != "" {
+			fmt.Fprintln(os.Stderr, "  "+strings.ReplaceAll(s, "\n", "\n  "))
+		}
+		r := o.Result
+		r.Err = o.Err
+		for _, t := range gm.Suggest(r) {
+			fmt.Fprintf(os.Stderr, "  hint: %s\n", t)
+		}
+		return 1
+	}
+	fmt.Printf("✓ %s finished in %s\n  %s\n", j.Label(), humanize.Duration(o.Duration), o.Result.Summary())
+	if s := o.Result.Breakdown(); s != "" {
+		fmt.Println("  " + strings.ReplaceAll(s, "\n", "\n  "))
+	}
+	if o.Result.Failed > 0 {
+		// The run went on past failed files, but scripts still need to
+		// know that some were not converted.
+		for _, e := range o.Result.Errors {
+			fmt.Fprintf(os.Stderr, "imageslim: %s: %v\n", j.Label(), e.Err)
+		}
+		for _, t := range gm.Suggest(o.Result) {
+			fmt.Fprintf(os.Stderr, "  hint: %s\n", t)
+		}
+		return 1
+	}
+	return 0
+}
+
+// batchArgs are the flags of "batch".
+type batchArgs struct {
+	runArgs
+	parallel int
+	failFast bool
+}
+
+// register adds the flags to fs.
+func (a *batchArgs) register(fs *flag.FlagSet) {
+	fs.IntVar(&a.parallel, "parallel", 1, "`number` of jobs to run at the same time")
+	fs.BoolVar(&a.failFast, "fail-fast", false, "stop starting new jobs after the first failure")
+	a.runArgs.register(fs)
+}
+
+// cmdBatch loads every job file named in args (glob patterns are expanded
+// for shells that don't) and runs them with job.RunBatch.  All files are
+// validated before the first job starts.
+func cmdBatch(args []string) int {
+	var a batchArgs
+	fs := newFlagSet("batch", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if err := a.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 2
+	}
+	if !checkGM(gm.Options{GMPath: a.gmPath, GMVersion: a.gmVersion}) {
+		return 1
+	}
+
+	var paths []string
+	for _, arg := range fs.Args() {
+		matches, err := filepath.Glob(arg)
+		if err != nil || len(matches) == 0 {
+			matches = []string{arg}
+		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+
+	safe := safeMode(a.safeMode)
+	jobs := make([]*job.Job, 0, len(paths))
+	for _, p := range paths {
+		j, err := job.Load(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+			return 1
+		}
+		a.apply(j)
+		if safe {
+			if err := refuseUnsafe(j); err != nil {
+				fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+				return 1
+			}
+		}
+		jobs = append(jobs, j)
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	results := job.RunBatch(ctx, jobs, job.BatchOptions{Parallel: a.parallel, FailFast: a.failFast}, os.Stdout)
+	for _, r := range results {
+		if !r.Skipped {
+			recordRun("batch", r.Job.Name, r.Job.Options(), r.Started, r.Duration, r.Result, r.Err)
+		}
+	}
+	fmt.Println()
+	if job.WriteReport(os.Stdout, results) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// restoreArgs are the flags of "restore".
+type restoreArgs struct {
+	backupDir string
+	keep      bool
+	safeMode  bool
+}
+
+// register adds the flags to fs.
+func (a *restoreArgs) register(fs *flag.FlagSet) {
+	fs.StringVar(&a.backupDir, "backup-dir", "", "backup `dir`ectory (default DIR/"+gm.DefaultBackupDir+")")
+	fs.BoolVar(&a.keep, "keep", false, "keep the backup directory after restoring")
+	registerSafeMode(fs, &a.safeMode)
+}
+
+// cmdRestore copies backed-up originals back over the files that overwrite
+// mode modified.  That writes over the files in DIR and removes the backup,
+// so safe mode refuses it.
+func cmdRestore(args []string) int {
+	var a restoreArgs
+	fs := newFlagSet("restore", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+	if safeMode(a.safeMode) {
+		fmt.Fprintln(os.Stderr, "imageslim: restore writes over the files in DIR and removes the backup, which safe mode forbids")
+		return 1
+	}
+
+	opts := gm.Options{Dir: expandHome(fs.Arg(0)), BackupDir: a.backupDir}
+	n, err := gm.Restore(opts, a.keep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	fmt.Printf("✓ Restored %d file(s) in %s\n", n, opts.Dir)
+	return 0
+}
+
+// undoArgs are the flags of "undo".
+type undoArgs struct {
+	safeMode bool
+}
+
+// register adds the flags to fs.
+func (a *undoArgs) register(fs *flag.FlagSet) {
+	registerSafeMode(fs, &a.safeMode)
+}
+
+// cmdUndo puts back the originals the last overwrite-mode run with the
+// trash on replaced.  Like restore, safe mode refuses it.
+func cmdUndo(args []string) int {
+	var a undoArgs
+	fs := newFlagSet("undo", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+	if safeMode(a.safeMode) {
+		fmt.Fprintln(os.Stderr, "imageslim: undo writes over the files in DIR and removes the run from the trash, which safe mode forbids")
+		return 1
+	}
+
+	opts := gm.Options{Dir: expandHome(fs.Arg(0))}
+	started, n, err := gm.Undo(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	fmt.Printf("✓ Undid the run of %s: restored %d file(s) in %s\n", started.Format("2006-01-02 15:04:05"), n, opts.Dir)
+	return 0
+}
+
+// orientArgs are the flags of "orient".
+type orientArgs struct {
+	flat      bool
+	backup    bool
+	backupDir string
+	dryRun    bool
+	safeMode  bool
+}
+
+// register adds the flags to fs.
+func (a *orientArgs) register(fs *flag.FlagSet) {
+	fs.BoolVar(&a.flat, "flat", false, "only the files directly in DIR, not its subfolders")
+	fs.BoolVar(&a.backup, "backup", true, "copy each original to the backup directory first; -backup=false for none")
+	fs.StringVar(&a.backupDir, "backup-dir", "", "backup `dir`ectory (default DIR/"+gm.DefaultBackupDir+")")
+	fs.BoolVar(&a.dryRun, "dry-run", false, "list the files that would be turned, and change nothing")
+	registerSafeMode(fs, &a.safeMode)
+}
+
+// cmdOrient turns sideways JPEGs upright without re-encoding them.  It
+// changes the originals, so safe mode only allows -dry-run.
+func cmdOrient(args []string) int {
+	var a orientArgs
+	fs := newFlagSet("orient", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+	if !a.dryRun && safeMode(a.safeMode) {
+		fmt.Fprintln(os.Stderr, "imageslim: orient turns the originals in place, which safe mode forbids; -dry-run lists them")
+		return 1
+	}
+
+	opts := gm.Options{Dir: expandHome(fs.Arg(0)), Recursive: !a.flat, Backup: a.backup, BackupDir: a.backupDir}
+	res, err := gm.Orient(opts, a.dryRun)
+	verb := "Turned"
+	if a.dryRun {
+		verb = "Would turn"
+	}
+	for _, rel := range res.Rotated {
+		fmt.Println("  " + rel)
+	}
+	for _, rel := range res.Imperfect {
+		fmt.Printf("  %s  left alone: its size is not a whole number of JPEG blocks\n", rel)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	fmt.Printf("✓ %s %d file(s) upright in %s; %d already were\n", verb, len(res.Rotated), opts.Dir, res.Upright)
+	return 0
+}
+
+// alphaArgs are the flags of "alpha".
+type alphaArgs struct {
+	flat   bool
+	all    bool
+	gmPath string
+}
+
+// register adds the flags to fs.
+func (a *alphaArgs) register(fs *flag.FlagSet) {
+	fs.BoolVar(&a.flat, "flat", false, "only the files directly in DIR, not its subfolders")
+	fs.BoolVar(&a.all, "all", false, "also list the files that use transparency")
+	registerGMPath(fs, &a.gmPath)
+}
+
+// cmdAlpha lists the PNGs and WebPs whose alpha channel is fully opaque,
+// for drop_alpha to remove.
+func cmdAlpha(args []string) int {
+	var a alphaArgs
+	fs := newFlagSet("alpha", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+
+	opts := gm.Options{Dir: expandHome(fs.Arg(0)), Recursive: !a.flat, GMPath: a.gmPath}
+	res, err := gm.AuditAlpha(opts)
+	for _, rel := range res.Opaque {
+		fmt.Printf("  %s  opaque alpha channel\n", rel)
+	}
+	if a.all {
+		for _, rel := range res.Transparent {
+			fmt.Printf("  %s  uses transparency\n", rel)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	fmt.Printf("✓ %d file(s) in %s have an alpha channel that is fully opaque; %d use transparency, %d have none\n",
+		len(res.Opaque), opts.Dir, len(res.Transparent), res.NoAlpha)
+	if len(res.Opaque) > 0 {
+		fmt.Println("  drop_alpha: true in a job (or -drop-alpha) removes it when they are converted")
+	}
+	return 0
+}
+
+// approvalArgs are the flags of "approval".
+type approvalArgs struct {
+	count     int
+	zip       bool
+	gmPath    string
+	gmVersion string
+	over      jobOverrides
+}
+
+// register adds the flags to fs.
+func (a *approvalArgs) register(fs *flag.FlagSet) {
+	fs.IntVar(&a.count, "count", 0, fmt.Sprintf("`number` of before/after pairs to pick (default: the job's sample_size, or %d)", gm.DefaultApprovalCount))
+	fs.BoolVar(&a.zip, "zip", false, "also pack the folder into "+gm.ApprovalDir+".zip, to send as one file")
+	registerGMPath(fs, &a.gmPath)
+	registerGMVersion(fs, &a.gmVersion)
+	a.over.register(fs)
+}
+
+// cmdApproval exports before/after pairs of a job's files, converted with
+// its settings, for a client to approve before the job is run.
+func cmdApproval(args []string) int {
+	var a approvalArgs
+	fs := newFlagSet("approval", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+	if a.count < 0 {
+		fmt.Fprintln(os.Stderr, "imageslim: -count must be at least 1")
+		return 2
+	}
+	if err := (runArgs{gmVersion: a.gmVersion, over: a.over}).validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 2
+	}
+
+	j, err := job.Load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	runArgs{gmPath: a.gmPath, gmVersion: a.gmVersion, over: a.over}.apply(j)
+	if !checkGM(j.Options()) {
+		return 1
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	ap, err := gm.Approve(ctx, j.Options(), a.count, a.zip)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %s: %v\n", j.Label(), err)
+		if out := strings.TrimSpace(ap.Result.Output()); out != "" {
+			fmt.Fprintln(os.Stderr, out)
+		}
+		return 1
+	}
+	fmt.Printf("✓ %d before/after pair(s) in %s\n", len(ap.Files), ap.Dir)
+	for _, rel := range ap.Files {
+		fmt.Println("  " + rel)
+	}
+	if ap.Zip != "" {
+		fmt.Printf("  packed into %s\n", ap.Zip)
+	}
+	return 0
+}
+
+// checkGM validates the gm binary opts names, and its version when opts
+// pins one, before a headless run starts, printing the problem and
+// returning false when it is unusable.
+func checkGM(opts gm.Options) bool {
+	if _, _, err := gm.Check(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		for _, t := range gm.Suggest(gm.Result{Err: err}) {
+			fmt.Fprintf(os.Stderr, "  hint: %s\n", t)
+		}
+		return false
+	}
+	return true
+}
+
+// replayArgs are the flags of "replay".
+type replayArgs struct {
+	view bool
+}
+
+// register adds the flags to fs.
+func (a *replayArgs) register(fs *flag.FlagSet) {
+	fs.BoolVar(&a.view, "view", false, "print the final screen after replaying")
+}
+
+// cmdReplay replays a recorded session and reports whether the current code
+// still behaves as recorded.
+func cmdReplay(args []string) int {
+	var a replayArgs
+	fs := newFlagSet("replay", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	m, err := replayTrace(f, os.Stdout, nil)
+	if a.view {
+		fmt.Println()
+		fmt.Println(m.View())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/config"
+)
+
+// ---------------------------------------------------------------------------
+// config show: the effective configuration and where it came from
+// ---------------------------------------------------------------------------
+
+// registerSet adds the repeatable -set flag to fs, appending each value to
+// *p.
+func registerSet(fs *flag.FlagSet, p *[]string) {
+	fs.Func("set", "override a configuration `setting=value`, e.g. output=ask or theme.accent=#005FAF; repeat for several", func(s string) error {
+		*p = append(*p, s)
+		return nil
+	})
+}
+
+// configArgs are the flags of "config show".
+type configArgs struct {
+	origin bool
+	sets   []string
+}
+
+// register adds the flags to fs.
+func (a *configArgs) register(fs *flag.FlagSet) {
+	fs.BoolVar(&a.origin, "origin", false, "list every setting with the file, variable or flag it came from")
+	registerSet(fs, &a.sets)
+}
+
+// cmdConfig prints the configuration the TUI would use: the layers merged
+// as YAML, or with -origin every setting and where it came from.
+func cmdConfig(args []string) int {
+	if len(args) == 0 {
+		args = []string{"show"}
+	}
+	if args[0] != "show" {
+		fmt.Fprintf(os.Stderr, "imageslim: unknown config command %q\n\n%s", args[0], usageText)
+		return 2
+	}
+	var a configArgs
+	fs := newFlagSet("config show", a.register)
+	if err := fs.Parse(args[1:]); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+	if _, err := config.FlagLayer(a.sets); err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 2
+	}
+	layers, err := config.Layers(a.sets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	c, origins := config.Merge(layers)
+	if !a.origin {
+		data, err := yaml.Marshal(c)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+			return 1
+		}
+		os.Stdout.Write(data)
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tFROM")
+	for _, o := range origins {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", o.Setting, o.Value, o.From)
+	}
+	w.Flush()
+	return 0
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+	"github.com/brunovpinheiro/ImageSlim/internal/job"
+)
+
+// ---------------------------------------------------------------------------
+// Pre-scan: how many files a run from the form would touch, confirmed
+// before it starts
+// ---------------------------------------------------------------------------
+
+// confirmRun validates the form and walks the tree its run would convert,
+// so that the confirm screen can say how many files and bytes it found
+// before anything is written.  Jobs on S3 have nothing on disk to walk yet
+// and start straight away.
+func (m model) confirmRun() (tea.Model, tea.Cmd) {
+	if err := m.validateForm(); err != nil {
+		m.status = "✗ " + err.Error()
+		return m, nil
+	}
+	opts := m.buildOptions()
+	if m.job != nil && job.IsS3(m.job.ResolveDir()) {
+		return m.startRun()
+	}
+	m.status = ""
+	m.state = stateConfirm
+	m.picks, m.picked, m.pickCursor = nil, nil, 0
+	return m, scanFilesCmd(opts)
+}
+
+// updateConfirm handles key events on the confirm screen: Enter starts or
+// queues the run once the scan is in, Esc goes back to the form.
+func (m model) updateConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Back, m.keys.Quit):
+		m.state, m.status = stateForm, ""
+	case key.Matches(msg, m.keys.Run):
+		if m.picks == nil || m.picks.Err != "" || len(m.picks.Files) == 0 {
+			return m, nil
+		}
+		return m.startRun()
+	}
+	return m, nil
+}
+
+// viewConfirm renders what the scan found with the keys to go on or back.
+func (m model) viewConfirm() string {
+	var b strings.Builder
+	back := "[" + keyHelp(m.keys.Back, m.keys.Quit) + "] back"
+	b.WriteString(titleStyle.Render("Start the run?"))
+	b.WriteString("\n")
+	b.WriteString(subtitleStyle.Render(truncate(m.buildOptions().Dir, max(viewportWidth(m.width), 10))))
+	b.WriteString("\n\n")
+	switch {
+	case m.picks == nil:
+		b.WriteString(subtitleStyle.Render("Scanning…"))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render(back))
+		return b.String()
+	case m.picks.Err != "":
+		b.WriteString(errorStyle.Render("✗  " + m.picks.Err))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render(back))
+		return b.String()
+	case len(m.picks.Files) == 0:
+		b.WriteString(subtitleStyle.Render("No files match the form's settings."))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render(back))
+		return b.String()
+	}
+
+	b.WriteString(found(m.picks.Files))
+	b.WriteString("\n\n")
+	if need := totalSize(m.picks.Files); m.picks.Free > 0 && need > m.picks.Free {
+		b.WriteString(warningStyle.Render(fmt.Sprintf("⚠  output/ may need up to %s, but only %s is free on its disk",
+			humanize.Bytes(need), humanize.Bytes(m.picks.Free))))
+		b.WriteString("\n\n")
+	}
+	start := "start"
+	if m.running > 0 {
+		start = "queue"
+	}
+	b.WriteString(helpStyle.Render(fmt.Sprintf("[%s] %s   %s", keyHelp(m.keys.Run), start, back)))
+	return b.String()
+}
+
+// found says how many files a scan found and how large they are together,
+// e.g. "Found 3,482 files, 12.4 GB".
+func found(files []pickFile) string {
+	noun := "files"
+	if len(files) == 1 {
+		noun = "file"
+	}
+	return fmt.Sprintf("Found %s %s, %s", humanize.Count(len(files)), noun, humanize.Bytes(totalSize(files)))
+}
+
+// totalSize adds up the sizes of files.
+func totalSize(files []pickFile) int64 {
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	return total
+}
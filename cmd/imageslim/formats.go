@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+)
+
+// ---------------------------------------------------------------------------
+// Format capability matrix
+// ---------------------------------------------------------------------------
+
+// formatsHint explains what a missing format means.
+const formatsHint = "Formats marked \"no\" need a GraphicsMagick build with the matching\ndelegate library (e.g. libheif for HEIC, libavif for AVIF, libjxl for JPEG XL)."
+
+// formatsMsg carries the gm format list back to the Update loop.
+type formatsMsg struct {
+	Version string      `json:"version,omitempty"`
+	Formats []gm.Format `json:"formats,omitempty"`
+	Err     string      `json:"error,omitempty"`
+}
+
+// loadFormatsCmd returns a Bubble Tea command that asks gm for its formats.
+func loadFormatsCmd(gmPath string) tea.Cmd {
+	return func() tea.Msg {
+		opts := gm.Options{GMPath: gmPath}
+		var msg formatsMsg
+		_, version, err := gm.Check(opts)
+		if err == nil {
+			msg.Version = version
+			msg.Formats, err = gm.ListFormats(opts)
+		}
+		if err != nil {
+			msg.Err = err.Error()
+		}
+		return msg
+	}
+}
+
+// updateFormats handles key events on the formats screen: anything that
+// closes it returns to the form.
+func (m model) updateFormats(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	k := m.keys
+	if key.Matches(msg, k.Back, k.Run, k.Formats, k.Quit) {
+		m.state = stateForm
+	}
+	return m, nil
+}
+
+// viewFormats renders which common formats this gm build can read and write.
+func (m model) viewFormats() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("Supported formats"))
+	b.WriteString("\n")
+	switch {
+	case m.formats == nil:
+		b.WriteString(subtitleStyle.Render("Asking GraphicsMagick…"))
+		b.WriteString("\n\n")
+	case m.formats.Err != "":
+		b.WriteString("\n")
+		b.WriteString(errorStyle.Render("✗  " + m.formats.Err))
+		b.WriteString("\n\n")
+	default:
+		b.WriteString(subtitleStyle.Render(m.formats.Version))
+		b.WriteString("\n\n")
+		b.WriteString(fmt.Sprintf("  %-11s %-7s %s\n", "FORMAT", "READ", "WRITE"))
+		for _, c := range gm.Capabilities(m.formats.Formats) {
+			read := lipgloss.NewStyle().Width(8).Render(capabilityMark(c.Read))
+			b.WriteString(fmt.Sprintf("  %-11s %s%s\n", c.Label, read, capabilityMark(c.Write)))
+		}
+		b.WriteString("\n")
+		b.WriteString(helpStyle.Render(formatsHint))
+		b.WriteString("\n\n")
+	}
+	b.WriteString(helpStyle.Render("[" + keyHelp(m.keys.Back, m.keys.Quit) + "] back to the form"))
+
+	return b.String()
+}
+
+// capabilityMark renders one cell of the formats screen.
+func capabilityMark(ok bool) string {
+	if ok {
+		return successStyle.Render("✓ yes")
+	}
+	return errorStyle.Render("✗ no")
+}
+
+// formatsArgs are the flags of "formats".
+type formatsArgs struct {
+	all    bool
+	gmPath string
+}
+
+// register adds the flags to fs.
+func (a *formatsArgs) register(fs *flag.FlagSet) {
+	fs.BoolVar(&a.all, "all", false, "list every format gm supports")
+	registerGMPath(fs, &a.gmPath)
+}
+
+// cmdFormats prints the format capability matrix, or with -all every format
+// gm reports.
+func cmdFormats(args []string) int {
+	var a formatsArgs
+	fs := newFlagSet("formats", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+
+	opts := gm.Options{GMPath: a.gmPath}
+	_, version, err := gm.Check(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	formats, err := gm.ListFormats(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Using %s\n\n", version)
+	if a.all {
+		writeAllFormats(os.Stdout, formats)
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FORMAT\tREAD\tWRITE")
+	for _, c := range gm.Capabilities(formats) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Label, yesNo(c.Read), yesNo(c.Write))
+	}
+	tw.Flush()
+	fmt.Printf("\n%s\n", formatsHint)
+	return 0
+}
+
+// writeAllFormats lists every format with its read/write flags.
+func writeAllFormats(w io.Writer, formats []gm.Format) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FORMAT\tREAD\tWRITE\tDESCRIPTION")
+	for _, f := range formats {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Name, yesNo(f.Read), yesNo(f.Write), f.Description)
+	}
+	tw.Flush()
+}
+
+// yesNo renders a capability in plain text output.
+func yesNo(ok bool) string {
+	if ok {
+		return "yes"
+	}
+	return "no"
+}
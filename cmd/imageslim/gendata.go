@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/demo"
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+)
+
+// ---------------------------------------------------------------------------
+// gen-testdata: sample images to try ImageSlim on
+// ---------------------------------------------------------------------------
+
+// genArgs are the flags of "gen-testdata".
+type genArgs struct {
+	seed  uint64
+	quiet bool
+}
+
+// register adds the flags to fs.
+func (a *genArgs) register(fs *flag.FlagSet) {
+	fs.Uint64Var(&a.seed, "seed", 1, "draw the images from `number`; the same seed gives the same files")
+	fs.BoolVar(&a.quiet, "quiet", false, "print only the summary line, not every file")
+}
+
+// cmdGenTestdata writes the sample images into a new directory.
+func cmdGenTestdata(args []string) int {
+	var a genArgs
+	fs := newFlagSet("gen-testdata", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+
+	dir := expandHome(fs.Arg(0))
+	files, err := demo.Generate(dir, a.seed, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	fmt.Printf("✓ Wrote %s files, %s, to %s\n", humanize.Count(len(files)), humanize.Bytes(total), dir)
+	if !a.quiet {
+		fmt.Println()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, f := range files {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", f.Path, humanize.Bytes(f.Size), f.Note)
+		}
+		tw.Flush()
+		fmt.Println()
+	}
+	fmt.Printf("Try: imageslim run %s\n", filepath.Join(dir, demo.JobName))
+	return 0
+}
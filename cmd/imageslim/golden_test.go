@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files instead of comparing with them")
+
+// TestGolden replays every session in testdata/sessions and compares the
+// screen at every state transition with the golden files in
+// testdata/golden, named after the session:
+//
+//	testdata/golden/<session>.<nn>.<state>.golden
+//
+// With -update the golden files are (re)written instead.
+func TestGolden(t *testing.T) {
+	// Golden files must not depend on the terminal, locale, time zone or
+	// directory running the test.
+	lipgloss.SetColorProfile(termenv.Ascii)
+	humanize.SetLocale(humanize.Lookup("C"))
+	time.Local = time.UTC
+	workingDir = func() (string, error) { return "/photos", nil }
+
+	sessions, err := filepath.Glob(filepath.Join("testdata", "sessions", "*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) == 0 {
+		t.Fatal("no sessions in testdata/sessions")
+	}
+	for _, path := range sessions {
+		base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		t.Run(base, func(t *testing.T) {
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			n := 0
+			frame := func(m model) {
+				name := filepath.Join("testdata", "golden", fmt.Sprintf("%s.%02d.%s.golden", base, n, m.state))
+				n++
+				got := []byte(m.View() + "\n")
+
+				if *update {
+					if err := os.WriteFile(name, got, 0o644); err != nil {
+						t.Error(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(name)
+				switch {
+				case err != nil:
+					t.Errorf("%v (run with -update to create it)", err)
+				case !bytes.Equal(got, want):
+					t.Errorf("%s differs:\n%s", name, lineDiff(string(want), string(got)))
+				}
+			}
+			if _, err := replayTrace(f, io.Discard, frame); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// lineDiff renders a minimal line-by-line diff of two screens: lines that
+// differ are shown as "-want" / "+got" pairs with their line number.
+func lineDiff(want, got string) string {
+	wl := strings.Split(want, "\n")
+	gl := strings.Split(got, "\n")
+	var b strings.Builder
+	for i := 0; i < len(wl) || i < len(gl); i++ {
+		var a, c string
+		if i < len(wl) {
+			a = wl[i]
+		}
+		if i < len(gl) {
+			c = gl[i]
+		}
+		if a != c {
+			fmt.Fprintf(&b, "  %3d - %s\n  %3d + %s\n", i+1, a, i+1, c)
+		}
+	}
+	return b.String()
+}
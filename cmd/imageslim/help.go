@@ -0,0 +1,525 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/config"
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+	"github.com/brunovpinheiro/ImageSlim/internal/history"
+	"github.com/brunovpinheiro/ImageSlim/internal/metrics"
+)
+
+// ---------------------------------------------------------------------------
+// Help and manual page, generated from the flag definitions
+// ---------------------------------------------------------------------------
+
+// command describes a subcommand for the help text and the manual page.
+// Its flags come from the same register functions the subcommand parses
+// with, so the documentation cannot drift from the code.
+type command struct {
+	name    string              // words after "imageslim"; empty for the TUI
+	args    string              // positional arguments, after the flags
+	summary string              // what it does, in a line or two
+	flags   func(*flag.FlagSet) // registers every flag it accepts, or nil
+	groups  []*flagGroup        // shared flags, shown as [NAME] in the synopsis
+}
+
+// flagGroup is a set of flags several commands accept, documented once.
+type flagGroup struct {
+	name     string // as in the synopsis, e.g. "OVERRIDES"
+	about    string // completes "NAME ..."
+	register func(*flag.FlagSet)
+}
+
+var (
+	overrideFlags = &flagGroup{
+		name:     "OVERRIDES",
+		about:    "replace a setting of every job being run",
+		register: func(fs *flag.FlagSet) { new(jobOverrides).register(fs) },
+	}
+	uiFlags = &flagGroup{
+		name:     "UI FLAGS",
+		about:    "change how the interactive form looks and behaves",
+		register: func(fs *flag.FlagSet) { new(uiOptions).register(fs) },
+	}
+)
+
+// commands are the ways to start imageslim, in the order the help lists them.
+var commands = []command{
+	{
+		summary: "open the interactive form",
+		flags:   func(fs *flag.FlagSet) { new(uiOptions).register(fs) },
+		groups:  []*flagGroup{uiFlags},
+	},
+	{
+		name:    "edit",
+		args:    "JOB.yaml",
+		summary: "open the form pre-filled from a job file; Ctrl+S saves it back",
+		flags:   func(fs *flag.FlagSet) { new(uiOptions).register(fs) },
+		groups:  []*flagGroup{uiFlags},
+	},
+	{
+		name:    "run",
+		args:    "JOB.yaml",
+		summary: "run a job file without the TUI",
+		flags:   func(fs *flag.FlagSet) { new(runArgs).register(fs) },
+		groups:  []*flagGroup{overrideFlags},
+	},
+	{
+		name:    "batch",
+		args:    "JOB.yaml...",
+		summary: "run several job files and print a report",
+		flags:   func(fs *flag.FlagSet) { new(batchArgs).register(fs) },
+		groups:  []*flagGroup{overrideFlags},
+	},
+	{
+		name:    "restore",
+		args:    "DIR",
+		summary: "put back the originals backed up by overwrite mode",
+		flags:   func(fs *flag.FlagSet) { new(restoreArgs).register(fs) },
+	},
+	{
+		name:    "undo",
+		args:    "DIR",
+		summary: "put back the originals the last overwrite-mode run with the trash on replaced",
+		flags:   func(fs *flag.FlagSet) { new(undoArgs).register(fs) },
+	},
+	{
+		name:    "orient",
+		args:    "DIR",
+		summary: "turn sideways JPEGs upright by their EXIF orientation, losslessly with jpegtran, and change nothing else",
+		flags:   func(fs *flag.FlagSet) { new(orientArgs).register(fs) },
+	},
+	{
+		name:    "alpha",
+		args:    "DIR",
+		summary: "list the PNGs and WebPs whose alpha channel is fully opaque, and so wasted, and those that use transparency",
+		flags:   func(fs *flag.FlagSet) { new(alphaArgs).register(fs) },
+	},
+	{
+		name:    "approval",
+		args:    "JOB.yaml",
+		summary: "convert a sample of a job's files, picked across folders and sizes, into approval/ as before/after pairs for a client",
+		flags:   func(fs *flag.FlagSet) { new(approvalArgs).register(fs) },
+		groups:  []*flagGroup{overrideFlags},
+	},
+	{
+		name:    "serve",
+		summary: "accept jobs over HTTP, e.g. from other machines, and report their progress and results",
+		flags:   func(fs *flag.FlagSet) { new(serveArgs).register(fs) },
+	},
+	{
+		name:    "replay",
+		args:    "TRACE",
+		summary: "replay a session recorded with -record",
+		flags:   func(fs *flag.FlagSet) { new(replayArgs).register(fs) },
+	},
+	{
+		name:    "inspect",
+		args:    "DIR",
+		summary: "list each image's size, pixels, format, colour space and EXIF summary, as gm identify reports them, before choosing settings",
+		flags:   func(fs *flag.FlagSet) { new(inspectArgs).register(fs) },
+	},
+	{
+		name:    "formats",
+		summary: "show which image formats gm can read and write",
+		flags:   func(fs *flag.FlagSet) { new(formatsArgs).register(fs) },
+	},
+	{
+		name:    "config show",
+		summary: "print the configuration in effect, merged from the system, user and project files, the environment and -set; with -origin, where each setting came from",
+		flags:   func(fs *flag.FlagSet) { new(configArgs).register(fs) },
+	},
+	{
+		name:    "gen-testdata",
+		args:    "DIR",
+		summary: "write sample images in many formats, sizes and orientations, with awkward names, and a job for them into a new DIR, to try settings on safely",
+		flags:   func(fs *flag.FlagSet) { new(genArgs).register(fs) },
+	},
+	{
+		name:    "metrics show",
+		summary: "summarise the opt-in usage statistics kept on this machine only",
+		flags:   func(fs *flag.FlagSet) { registerSince(fs, new(time.Duration)) },
+	},
+	{
+		name:    "metrics",
+		args:    "enable|disable|reset|path",
+		summary: "start or stop recording them, clear them, or print where they are kept",
+	},
+	{
+		name:    "help",
+		args:    "[COMMAND]",
+		summary: "show this help, or every flag of a command",
+	},
+	{
+		name:    "man",
+		summary: "print the manual page, e.g. imageslim man > imageslim.1",
+	},
+}
+
+// environment lists the variables imageslim reads, and those it sets for
+// job hooks.
+var environment = []struct{ name, text string }{
+	{gm.PathEnv, "gm executable to use, as with -gm-path"},
+	{gm.VersionEnv, "GraphicsMagick version to insist on, as with -gm-version"},
+	{"IMAGESLIM_REDUCED_MOTION", "1 turns on -reduced-motion"},
+	{"IMAGESLIM_SPINNER", "default progress animation for -spinner"},
+	{"PAGER", "pager -pager shows the results in, with its flags; less when unset"},
+	{config.PathEnv, "user configuration file with presets, naming schemes, colours and key bindings; the first run creates it"},
+	{config.SystemPathEnv, "system-wide configuration file, instead of /etc/imageslim/config.yaml"},
+	{"IMAGESLIM_OUTPUT, IMAGESLIM_REPORT_FORMAT, IMAGESLIM_THEME_*", "override the configuration files' output, report_format and theme settings"},
+	{history.PathEnv, "run history file, or off to keep no history"},
+	{metrics.PathEnv, "usage statistics file, e.g. on a share a team aggregates from"},
+	{tokenEnv, "secret that clients of serve must send as Authorization: Bearer TOKEN"},
+	{"IMAGESLIM_JOB, IMAGESLIM_STATUS, IMAGESLIM_ERROR", "set for a job's hooks and notify command: the job's name, ok or failed, and the error"},
+}
+
+// helpColumn is where descriptions start in the help text, and helpWidth
+// where lines wrap.
+const (
+	helpColumn = 29
+	helpWidth  = 79
+)
+
+// newFlagSet returns a flag set for the named command with its flags
+// registered.  -h prints the command's help.
+func newFlagSet(name string, register func(*flag.FlagSet)) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if register != nil {
+		register(fs)
+	}
+	fs.Usage = func() {
+		if c := findCommand(name); c != nil {
+			writeCommandHelp(fs.Output(), *c)
+		}
+	}
+	return fs
+}
+
+// flagExit maps a flag parsing error to an exit code: asking for help is
+// not a failure.
+func flagExit(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
+}
+
+// findCommand returns the command with the given name, or nil.
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// flagDoc is one flag as documented: "-name METAVAR" and what it does.
+type flagDoc struct {
+	name, metavar, text string
+}
+
+// docFlags returns the flags register adds, alphabetically, leaving out
+// those of groups.
+func docFlags(register func(*flag.FlagSet), groups []*flagGroup) []flagDoc {
+	if register == nil {
+		return nil
+	}
+	shared := map[string]bool{}
+	for _, g := range groups {
+		gs := flag.NewFlagSet("", flag.ContinueOnError)
+		g.register(gs)
+		gs.VisitAll(func(f *flag.Flag) { shared[f.Name] = true })
+	}
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	register(fs)
+	var docs []flagDoc
+	fs.VisitAll(func(f *flag.Flag) {
+		if shared[f.Name] {
+			return
+		}
+		metavar, text := flag.UnquoteUsage(f)
+		switch f.DefValue {
+		case "", "0", "0s", "false":
+		default:
+			text += fmt.Sprintf(" (default %s)", f.DefValue)
+		}
+		if metavar == strings.ToLower(metavar) {
+			metavar = strings.ToUpper(metavar) // but WxH stays as written
+		}
+		docs = append(docs, flagDoc{name: f.Name, metavar: metavar, text: text})
+	})
+	return docs
+}
+
+// term is the flag as typed, e.g. "-workers NUMBER".
+func (d flagDoc) term() string {
+	if d.metavar == "" {
+		return "-" + d.name
+	}
+	return "-" + d.name + " " + d.metavar
+}
+
+// synopsis returns c's command line after "imageslim", as separate words:
+// bracketed flags and groups, then the positional arguments.
+func (c command) synopsis() []string {
+	var words []string
+	if c.name != "" {
+		words = append(words, c.name)
+	}
+	for _, d := range docFlags(c.flags, c.groups) {
+		words = append(words, "["+d.term()+"]")
+	}
+	for _, g := range c.groups {
+		words = append(words, "["+g.name+"]")
+	}
+	if c.args != "" {
+		words = append(words, c.args)
+	}
+	return words
+}
+
+// wrap joins words into lines of at most width runes.
+func wrap(words []string, width int) []string {
+	var lines []string
+	line := ""
+	for _, w := range words {
+		switch {
+		case line == "":
+			line = w
+		case len([]rune(line))+1+len([]rune(w)) <= width:
+			line += " " + w
+		default:
+			lines = append(lines, line)
+			line = w
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// writeItem writes term and its description, which starts in helpColumn,
+// or on the next line when term is too long.
+func writeItem(w io.Writer, term, text string) {
+	lines := wrap(strings.Fields(text), helpWidth-helpColumn)
+	pad := strings.Repeat(" ", helpColumn)
+	if len([]rune(term)) >= helpColumn-1 {
+		fmt.Fprintln(w, term)
+	} else if len(lines) > 0 {
+		fmt.Fprint(w, term+strings.Repeat(" ", helpColumn-len([]rune(term))))
+		fmt.Fprintln(w, lines[0])
+		lines = lines[1:]
+	}
+	for _, l := range lines {
+		fmt.Fprintln(w, pad+l)
+	}
+}
+
+// writeSynopsis writes c's command line, wrapped under its first argument,
+// except for the last line, which it returns for the caller to finish.
+func writeSynopsis(w io.Writer, indent string, c command) string {
+	words := c.synopsis()
+	head := indent + "imageslim "
+	if c.name != "" {
+		head += c.name + " "
+		words = words[1:]
+	}
+	lines := wrap(words, helpWidth-len(head))
+	if len(lines) == 0 {
+		return strings.TrimRight(head, " ")
+	}
+	for _, l := range lines[:len(lines)-1] {
+		fmt.Fprintln(w, head+l)
+		head = strings.Repeat(" ", len(head))
+	}
+	return head + lines[len(lines)-1]
+}
+
+// writeUsage writes the overview printed by -h and "imageslim help": every
+// command, the shared flags and the environment.
+func writeUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage:")
+	for _, c := range commands {
+		writeItem(w, writeSynopsis(w, "  ", c), c.summary)
+	}
+	for _, g := range []*flagGroup{overrideFlags, uiFlags} {
+		fmt.Fprintf(w, "\n%s %s:\n", g.name, g.about)
+		for _, d := range docFlags(g.register, nil) {
+			writeItem(w, "  "+d.term(), d.text)
+		}
+	}
+	fmt.Fprintln(w, "\nEnvironment:")
+	for _, e := range environment {
+		writeItem(w, "  "+e.name, e.text)
+	}
+	fmt.Fprintln(w, "\nRun \"imageslim help COMMAND\" for the flags of a command, or read the")
+	fmt.Fprintln(w, "manual page printed by \"imageslim man\".")
+}
+
+// writeCommandHelp writes the synopsis and every flag of c.
+func writeCommandHelp(w io.Writer, c command) {
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, writeSynopsis(w, "  ", c))
+	fmt.Fprintf(w, "\n%s.\n", capitalize(c.summary))
+	if docs := docFlags(c.flags, c.groups); len(docs) > 0 {
+		fmt.Fprintln(w, "\nFlags:")
+		for _, d := range docs {
+			writeItem(w, "  "+d.term(), d.text)
+		}
+	}
+	for _, g := range c.groups {
+		fmt.Fprintf(w, "\n%s %s:\n", g.name, g.about)
+		for _, d := range docFlags(g.register, nil) {
+			writeItem(w, "  "+d.term(), d.text)
+		}
+	}
+}
+
+// usage returns the overview written by writeUsage.
+func usage() string {
+	var b strings.Builder
+	writeUsage(&b)
+	return b.String()
+}
+
+// cmdHelp prints the overview, or the help of the commands named in args.
+func cmdHelp(args []string) int {
+	if len(args) == 0 {
+		writeUsage(os.Stdout)
+		return 0
+	}
+	name := strings.Join(args, " ")
+	found := false
+	for _, c := range commands {
+		if c.name == name || strings.HasPrefix(c.name, name+" ") {
+			if found {
+				fmt.Println()
+			}
+			writeCommandHelp(os.Stdout, c)
+			found = true
+		}
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "imageslim: unknown command %q\n", name)
+		return 2
+	}
+	return 0
+}
+
+// capitalize upper-cases the first letter of s.
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// ---------------------------------------------------------------------------
+// Manual page
+// ---------------------------------------------------------------------------
+
+// manDescription opens the manual page.
+const manDescription = `ImageSlim resizes and recompresses the images in a directory tree with
+GraphicsMagick (gm).  Without a command it opens an interactive form; job
+files store the same settings as YAML for unattended runs with "imageslim
+run" and "imageslim batch".  In preserve mode the originals stay untouched
+and converted copies go to an output directory; overwrite mode replaces
+them, after backing them up unless told not to.`
+
+// roff escapes s for the body of a manual page.  hyphens turns "-" into
+// the minus sign that flags are typed with.
+func roff(s string, hyphens bool) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	if hyphens {
+		s = strings.ReplaceAll(s, "-", `\-`)
+	}
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// manFlag writes one flag as a tagged paragraph.
+func manFlag(w io.Writer, d flagDoc) {
+	fmt.Fprintln(w, ".TP")
+	if d.metavar == "" {
+		fmt.Fprintf(w, "\\fB%s\\fR\n", roff("-"+d.name, true))
+	} else {
+		fmt.Fprintf(w, "\\fB%s\\fR \\fI%s\\fR\n", roff("-"+d.name, true), roff(d.metavar, false))
+	}
+	fmt.Fprintln(w, roff(d.text, false))
+}
+
+// writeMan writes the manual page in roff, for man(1).
+func writeMan(w io.Writer) {
+	fmt.Fprintln(w, `.TH IMAGESLIM 1 "" "ImageSlim" "User Commands"`)
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintln(w, `imageslim \- batch image resize and compression with GraphicsMagick`)
+
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	for i, c := range commands {
+		if i > 0 {
+			fmt.Fprintln(w, ".br")
+		}
+		fmt.Fprintf(w, "\\fBimageslim\\fR %s\n", roff(strings.Join(c.synopsis(), " "), true))
+	}
+
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	for _, l := range strings.Split(manDescription, "\n") {
+		fmt.Fprintln(w, roff(l, false))
+	}
+
+	fmt.Fprintln(w, ".SH COMMANDS")
+	for _, c := range commands {
+		fmt.Fprintln(w, ".TP")
+		fmt.Fprintf(w, "\\fBimageslim %s\\fR\n", roff(strings.Join(c.synopsis(), " "), true))
+		fmt.Fprintln(w, roff(capitalize(c.summary)+".", false))
+		if docs := docFlags(c.flags, c.groups); len(docs) > 0 {
+			fmt.Fprintln(w, ".RS")
+			for _, d := range docs {
+				manFlag(w, d)
+			}
+			fmt.Fprintln(w, ".RE")
+		}
+	}
+
+	for _, g := range []*flagGroup{overrideFlags, uiFlags} {
+		fmt.Fprintf(w, ".SH %s\n", g.name)
+		fmt.Fprintln(w, roff(g.name+" "+g.about+".", false))
+		for _, d := range docFlags(g.register, nil) {
+			manFlag(w, d)
+		}
+	}
+
+	fmt.Fprintln(w, ".SH ENVIRONMENT")
+	for _, e := range environment {
+		fmt.Fprintln(w, ".TP")
+		fmt.Fprintf(w, "\\fB%s\\fR\n", roff(e.name, false))
+		fmt.Fprintln(w, roff(e.text, false))
+	}
+
+	fmt.Fprintln(w, ".SH EXIT STATUS")
+	fmt.Fprintln(w, "0 when everything succeeded, 1 when a run, job or check failed, and 2 for")
+	fmt.Fprintln(w, "an invalid command line.")
+	fmt.Fprintln(w, ".SH SEE ALSO")
+	fmt.Fprintln(w, ".BR gm (1)")
+}
+
+// cmdMan prints the manual page.
+func cmdMan(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+	writeMan(os.Stdout)
+	return 0
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+	"github.com/brunovpinheiro/ImageSlim/internal/history"
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+	"github.com/brunovpinheiro/ImageSlim/internal/job"
+)
+
+// ---------------------------------------------------------------------------
+// Run history
+// ---------------------------------------------------------------------------
+
+// recordRun notes a finished run in the history and, when the user opted in,
+// in the usage metrics.  command says how ImageSlim was used (tui, plain, run
+// or batch) and name is the job's name, if the run came from a job file.
+// Failing to record never affects the run itself.  A run that went on past
+// failed files is recorded as failed with the first of them.
+func recordRun(command, name string, opts gm.Options, started time.Time, d time.Duration, r gm.Result, err error) {
+	if err == nil && len(r.Errors) > 0 {
+		err = r.Errors[0].Err
+	}
+	recordMetrics(command, opts, started, d, r, err)
+
+	// Store an absolute directory so the run can be repeated from anywhere.
+	if abs, aerr := filepath.Abs(opts.Dir); aerr == nil {
+		opts.Dir = abs
+	}
+	e := history.Entry{
+		Time:       started.UTC(),
+		Command:    command,
+		Name:       name,
+		Options:    opts,
+		Duration:   d.Milliseconds(),
+		Processed:  r.Processed,
+		Skipped:    r.Skipped,
+		Small:      r.Small,
+		OverTarget: r.OverTarget,
+		LowPSNR:    r.LowPSNR,
+		Animated:   r.Animated,
+		BytesIn:    r.BytesIn,
+		BytesOut:   r.BytesOut,
+	}
+	if err != nil {
+		e.Err = err.Error()
+	}
+	_ = history.Add(e)
+}
+
+// historyMsg carries the run history, newest first, back to the Update loop.
+type historyMsg struct {
+	Entries []history.Entry `json:"entries,omitempty"`
+	Err     string          `json:"error,omitempty"`
+}
+
+// loadHistoryCmd returns a Bubble Tea command that reads the run history.
+func loadHistoryCmd() tea.Cmd {
+	return func() tea.Msg {
+		entries, err := history.Load()
+		if err != nil {
+			return historyMsg{Err: err.Error()}
+		}
+		slices.Reverse(entries)
+		return historyMsg{Entries: entries}
+	}
+}
+
+// openHistory switches to the history screen, which returns to the current
+// screen when closed.
+func (m model) openHistory() (tea.Model, tea.Cmd) {
+	m.historyBack = m.state
+	m.state = stateHistory
+	m.history = nil
+	m.historyCursor = 0
+	return m, loadHistoryCmd()
+}
+
+// updateHistory handles key events on the history screen.
+func (m model) updateHistory(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var entries []history.Entry
+	if m.history != nil {
+		entries = m.history.Entries
+	}
+	k := m.keys
+	switch {
+	case key.Matches(msg, k.Back, k.Quit, k.History):
+		m.state = m.historyBack
+	case key.Matches(msg, k.Up):
+		if m.historyCursor > 0 {
+			m.historyCursor--
+		}
+	case key.Matches(msg, k.Down):
+		if m.historyCursor < len(entries)-1 {
+			m.historyCursor++
+		}
+	case key.Matches(msg, k.Run):
+		if m.historyCursor < len(entries) {
+			return m.repeat(entries[m.historyCursor], true)
+		}
+	case key.Matches(msg, k.Edit):
+		if m.historyCursor < len(entries) {
+			return m.repeat(entries[m.historyCursor], false)
+		}
+	}
+	return m, nil
+}
+
+// repeat fills a fresh form with the options of a past run and, with run
+// set, starts it right away.  The past options are loaded as a job, so
+// settings the form cannot show, such as a watermark, are kept.
+func (m model) repeat(e history.Entry, run bool) (tea.Model, tea.Cmd) {
+	name := cmp.Or(e.Name, filepath.Base(e.Options.Dir))
+	nm := m.handOver(initialModel(m.ui).withJob(job.FromOptions(name, e.Options)))
+	if !run {
+		return nm, nm.ui.blink()
+	}
+	return nm.startRun()
+}
+
+// viewHistory renders the list of past runs with the selected one marked.
+func (m model) viewHistory() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("Run history"))
+	b.WriteString("\n")
+	switch {
+	case m.history == nil:
+		b.WriteString(subtitleStyle.Render("Loading…"))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render("[" + keyHelp(m.keys.Back, m.keys.Quit) + "] back"))
+		return b.String()
+	case m.history.Err != "":
+		b.WriteString("\n")
+		b.WriteString(errorStyle.Render("✗  " + m.history.Err))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render("[" + keyHelp(m.keys.Back, m.keys.Quit) + "] back"))
+		return b.String()
+	case len(m.history.Entries) == 0:
+		b.WriteString(subtitleStyle.Render("No runs yet — finished runs are listed here."))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render("[" + keyHelp(m.keys.Back, m.keys.Quit) + "] back"))
+		return b.String()
+	}
+
+	entries := m.history.Entries
+	rows := historyRows(m.height)
+	first := max(m.historyCursor-rows+1, 0)
+	last := min(first+rows, len(entries))
+	if last-first < len(entries) {
+		b.WriteString(subtitleStyle.Render(fmt.Sprintf("Runs %s–%s of %s, newest first",
+			humanize.Count(first+1), humanize.Count(last), humanize.Count(len(entries)))))
+	} else {
+		b.WriteString(subtitleStyle.Render(fmt.Sprintf("%s run(s), newest first", humanize.Count(len(entries)))))
+	}
+	b.WriteString("\n\n")
+
+	for i := first; i < last; i++ {
+		b.WriteString(m.renderHistoryEntry(entries[i], i == m.historyCursor))
+	}
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render(fmt.Sprintf("[%s] select   [%s] run again   [%s] edit first   [%s] back",
+		keysHelp(m.keys.Up, m.keys.Down), keyHelp(m.keys.Run), keyHelp(m.keys.Edit), keyHelp(m.keys.Back, m.keys.Quit))))
+
+	return b.String()
+}
+
+// historyRows is how many entries fit on the history screen.  Each entry
+// takes three lines.
+func historyRows(termHeight int) int {
+	if termHeight == 0 {
+		return 5 // size not known yet
+	}
+	return max((termHeight-6)/3, 1)
+}
+
+// renderHistoryEntry renders one past run: when and where, its options and
+// its outcome.
+func (m model) renderHistoryEntry(e history.Entry, selected bool) string {
+	var b strings.Builder
+	width := viewportWidth(m.width)
+
+	mark := successStyle.Render("✓")
+	if e.Failed() {
+		mark = errorStyle.Render("✗")
+	}
+	head := fmt.Sprintf("%s  %s", e.Time.Local().Format("2006-01-02 15:04"), e.Options.Dir)
+	if e.Name != "" && e.Name != filepath.Base(e.Options.Dir) {
+		head += "  (" + e.Name + ")"
+	}
+	head = truncate(head, width-4)
+	if selected {
+		b.WriteString(selectedModeStyle.Render("› " + head))
+	} else {
+		b.WriteString("  " + head)
+	}
+	b.WriteString(" " + mark + "\n")
+
+	b.WriteString(helpStyle.Render("    " + truncate(describeOptions(e.Options), width-4)))
+	b.WriteString("\n")
+
+	outcome := gm.Result{Processed: e.Processed, Skipped: e.Skipped, Small: e.Small, OverTarget: e.OverTarget, LowPSNR: e.LowPSNR, Animated: e.Animated, BytesIn: e.BytesIn, BytesOut: e.BytesOut}.Summary()
+	if e.Failed() {
+		outcome = "Failed: " + strings.SplitN(e.Err, "\n", 2)[0]
+	}
+	outcome += " · " + humanize.Duration(time.Duration(e.Duration)*time.Millisecond)
+	b.WriteString(subtitleStyle.Render("    " + truncate(outcome, width-4)))
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// describeOptions summarises the options of a run on one line, e.g.
+// "1200x1200 fit · quality 80 · preserve · recursive".
+func describeOptions(o gm.Options) string {
+	parts := []string{
+		fmt.Sprintf("%s %s", o.Resize, cmp.Or(o.ResizeMode, "fit")),
+		fmt.Sprintf("quality %d", o.Quality),
+	}
+	if o.SmartQuality {
+		parts[1] = fmt.Sprintf("smart quality up to %d", o.Quality)
+	}
+	if o.Lossless {
+		parts = []string{"lossless"} // neither resized nor re-encoded
+	}
+	if o.Exec != "" {
+		parts = []string{"exec " + o.Exec}
+	}
+	if len(o.Steps) > 0 {
+		var ops []string
+		for _, s := range o.Steps {
+			ops = append(ops, s.Op)
+		}
+		parts = []string{"steps " + strings.Join(ops, " → "), fmt.Sprintf("quality %d", o.Quality)}
+	}
+	if o.TargetSize > 0 {
+		parts = append(parts, "under "+humanize.Bytes(o.TargetSize))
+	}
+	if o.Overwrite {
+		parts = append(parts, "overwrite")
+	} else {
+		parts = append(parts, "preserve")
+	}
+	if o.Recursive {
+		parts = append(parts, "recursive")
+	} else {
+		parts = append(parts, "this folder only")
+	}
+	if o.Interlace != "" {
+		parts = append(parts, "progressive")
+	}
+	if o.AutoOrient {
+		parts = append(parts, "auto-orient")
+	}
+	if o.Sharpen != "" {
+		parts = append(parts, "sharpen")
+	}
+	if o.OptimizePNG != "" {
+		parts = append(parts, "png "+o.OptimizePNG)
+	}
+	if o.MaxColors > 0 {
+		parts = append(parts, fmt.Sprintf("%d colours", o.MaxColors))
+		if o.Dither {
+			parts[len(parts)-1] += " dithered"
+		}
+	}
+	if o.DropAlpha {
+		parts = append(parts, "drop opaque alpha")
+	}
+	if o.OutputFormat != "" {
+		parts = append(parts, o.OutputFormat)
+	}
+	if o.HEIC {
+		parts = append(parts, "heic")
+	}
+	if o.AnimatedGIF == gm.AnimatedSkip {
+		parts = append(parts, "no animated GIFs")
+	}
+	if o.Watermark.Enabled() {
+		parts = append(parts, "watermark "+filepath.Base(o.Watermark.Image))
+	}
+	if o.NameTemplate != "" {
+		parts = append(parts, "names "+o.NameTemplate)
+	}
+	if o.MinFileSize > 0 {
+		parts = append(parts, "min "+humanize.Bytes(o.MinFileSize))
+	}
+	if d := describeDimensions(o.MinWidth, o.MinHeight); d != "" {
+		parts = append(parts, "min "+d)
+	}
+	if !o.ModifiedAfter.IsZero() {
+		parts = append(parts, "modified since "+gm.FormatDate(o.ModifiedAfter))
+	}
+	if !o.ModifiedBefore.IsZero() {
+		parts = append(parts, "modified before "+gm.FormatDate(o.ModifiedBefore))
+	}
+	if o.Files != nil {
+		parts = append(parts, humanize.Count(len(o.Files))+" picked file(s)")
+	}
+	if o.Force {
+		parts = append(parts, "force")
+	}
+	return strings.Join(parts, " · ")
+}
+
+// truncate shortens s to at most n characters, marking the cut with "…".
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:max(n-1, 0)]) + "…"
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+)
+
+// ---------------------------------------------------------------------------
+// Inspect: each image's size, format, colour space and EXIF data, before
+// settings are chosen
+// ---------------------------------------------------------------------------
+
+// inspectMsg carries what gm identify said about the form's files back to
+// the Update loop.
+type inspectMsg struct {
+	Dir   string         `json:"dir"`
+	Files []gm.ImageInfo `json:"files,omitempty"`
+	Err   string         `json:"error,omitempty"`
+}
+
+// inspectCmd returns a Bubble Tea command that inspects the files a run
+// with opts would look at.  Like a run it goes through tuiRuns, so that
+// quitting the TUI stops gm.
+func inspectCmd(opts gm.Options) tea.Cmd {
+	return func() tea.Msg {
+		msg := inspectMsg{Dir: opts.Dir}
+		if !tuiRuns.do(func(ctx context.Context) {
+			var err error
+			if msg.Files, err = gm.Inspect(ctx, opts); err != nil {
+				msg.Err = err.Error()
+			}
+		}) {
+			return nil // the TUI is exiting
+		}
+		return msg
+	}
+}
+
+// openInspect validates the form and switches to the inspect screen for
+// the files its settings pick.
+func (m model) openInspect() (tea.Model, tea.Cmd) {
+	if err := m.validateForm(); err != nil {
+		m.status = "✗ " + err.Error()
+		return m, nil
+	}
+	m.status = ""
+	m.state = stateInspect
+	m.inspect, m.inspectCursor = nil, 0
+	return m, inspectCmd(m.buildOptions())
+}
+
+// updateInspect handles key events on the inspect screen.
+func (m model) updateInspect(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	n := 0
+	if m.inspect != nil {
+		n = len(m.inspect.Files)
+	}
+	page := pickRows(m.height)
+	k := m.keys
+	switch {
+	case key.Matches(msg, k.Back, k.Quit, k.Inspect):
+		m.state = stateForm
+	case key.Matches(msg, k.Up):
+		m.inspectCursor = max(m.inspectCursor-1, 0)
+	case key.Matches(msg, k.Down):
+		m.inspectCursor = max(min(m.inspectCursor+1, n-1), 0)
+	case key.Matches(msg, k.PageUp):
+		m.inspectCursor = max(m.inspectCursor-page, 0)
+	case key.Matches(msg, k.PageDown):
+		m.inspectCursor = max(min(m.inspectCursor+page, n-1), 0)
+	}
+	return m, nil
+}
+
+// inspectColumns head the inspect screen's table and cmdInspect's.
+var inspectColumns = []string{"FILE", "SIZE", "PIXELS", "FORMAT", "COLOUR", "EXIF"}
+
+// inspectCells returns info as the cells under inspectColumns.  A file gm
+// could not identify is marked unreadable; its error is shown apart.
+func inspectCells(info gm.ImageInfo) []string {
+	if info.Err != "" {
+		return []string{info.Path, humanize.Bytes(info.Size), "", "unreadable", "", ""}
+	}
+	colour := info.Colorspace
+	if info.Alpha {
+		colour += "+alpha"
+	}
+	return []string{info.Path, humanize.Bytes(info.Size), fmt.Sprintf("%d×%d", info.Width, info.Height), info.Format, colour, info.EXIF()}
+}
+
+// viewInspect renders the inspected files as a table with the cursor
+// marked, as many rows as fit.
+func (m model) viewInspect() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("Inspect"))
+	b.WriteString("\n")
+	back := helpStyle.Render("[" + keyHelp(m.keys.Back, m.keys.Quit) + "] back to the form")
+	switch {
+	case m.inspect == nil:
+		b.WriteString(subtitleStyle.Render("Asking GraphicsMagick…"))
+		b.WriteString("\n\n" + back)
+		return b.String()
+	case m.inspect.Err != "":
+		b.WriteString("\n")
+		b.WriteString(errorStyle.Render("✗  " + m.inspect.Err))
+		b.WriteString("\n\n" + back)
+		return b.String()
+	case len(m.inspect.Files) == 0:
+		b.WriteString(subtitleStyle.Render("No files match the form's settings."))
+		b.WriteString("\n\n" + back)
+		return b.String()
+	}
+
+	files := m.inspect.Files
+	var total int64
+	failed := 0
+	for _, f := range files {
+		total += f.Size
+		if f.Err != "" {
+			failed++
+		}
+	}
+	sub := fmt.Sprintf("%s file(s) in %s · %s", humanize.Count(len(files)), m.inspect.Dir, humanize.Bytes(total))
+	if failed > 0 {
+		sub += fmt.Sprintf(" · %s unreadable", humanize.Count(failed))
+	}
+	b.WriteString(subtitleStyle.Render(sub))
+	b.WriteString("\n\n")
+
+	rows := pickRows(m.height)
+	first := max(m.inspectCursor-rows+1, 0)
+	last := min(first+rows, len(files))
+	cells := [][]string{inspectColumns}
+	for _, f := range files[first:last] {
+		cells = append(cells, inspectCells(f))
+	}
+	// Columns are as wide as their widest cell on screen; the file name
+	// gives way when the table would not fit.
+	widths := make([]int, len(inspectColumns))
+	for _, row := range cells {
+		for i, c := range row {
+			widths[i] = max(widths[i], len([]rune(c)))
+		}
+	}
+	rest := 0
+	for _, w := range widths[1:] {
+		rest += w + 2
+	}
+	widths[0] = max(min(widths[0], viewportWidth(m.width)-rest-2), 10)
+	for r, row := range cells {
+		var line strings.Builder
+		for i, c := range row {
+			c = truncate(c, widths[i])
+			if i < len(row)-1 {
+				c += strings.Repeat(" ", widths[i]-len([]rune(c))+2)
+			}
+			line.WriteString(c)
+		}
+		switch {
+		case r == 0:
+			b.WriteString("  " + helpStyle.Render(line.String()))
+		case first+r-1 == m.inspectCursor:
+			b.WriteString(selectedModeStyle.Render("› " + line.String()))
+		case files[first+r-1].Err != "":
+			b.WriteString("  " + errorStyle.Render(line.String()))
+		default:
+			b.WriteString("  " + line.String())
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	if last-first < len(files) {
+		b.WriteString(helpStyle.Render(fmt.Sprintf("Files %s–%s of %s   [%s] page",
+			humanize.Count(first+1), humanize.Count(last), humanize.Count(len(files)), keysHelp(m.keys.PageUp, m.keys.PageDown))))
+		b.WriteString("\n")
+	}
+	if f := files[m.inspectCursor]; f.Err != "" {
+		b.WriteString(errorStyle.Render(truncate("✗ "+f.Err, max(viewportWidth(m.width), 10))))
+		b.WriteString("\n")
+	}
+	b.WriteString(helpStyle.Render(fmt.Sprintf("[%s] move   [%s] back to the form",
+		keysHelp(m.keys.Up, m.keys.Down), keyHelp(m.keys.Back, m.keys.Quit))))
+
+	return b.String()
+}
+
+// inspectArgs are the flags of "inspect".
+type inspectArgs struct {
+	flat   bool
+	gmPath string
+}
+
+// register adds the flags to fs.
+func (a *inspectArgs) register(fs *flag.FlagSet) {
+	fs.BoolVar(&a.flat, "flat", false, "only the files directly in DIR, not its subfolders")
+	registerGMPath(fs, &a.gmPath)
+}
+
+// cmdInspect prints the inspect screen's table for the images in a
+// directory.
+func cmdInspect(args []string) int {
+	var a inspectArgs
+	fs := newFlagSet("inspect", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+
+	opts := gm.Options{Dir: expandHome(fs.Arg(0)), Patterns: gm.DefaultPatterns, Recursive: !a.flat, GMPath: a.gmPath}
+	ctx, stop := interruptible()
+	defer stop()
+	files, err := gm.Inspect(ctx, opts)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(inspectColumns, "\t"))
+	for _, f := range files {
+		fmt.Fprintln(tw, strings.Join(inspectCells(f), "\t"))
+	}
+	tw.Flush()
+	code := 0
+	for _, f := range files {
+		if f.Err != "" {
+			fmt.Fprintf(os.Stderr, "imageslim: %s: %s\n", f.Path, f.Err)
+			code = 1
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	return code
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/key"
+)
+
+// ---------------------------------------------------------------------------
+// Key bindings: every screen matches keys through a keyMap, so the keys
+// section of the configuration file can rebind them
+// ---------------------------------------------------------------------------
+
+// keyMap holds the TUI's key bindings.  One action can mean different
+// things on different screens; Run, for instance, starts the form's run,
+// runs the selected files or history entry, and closes the done screen.
+type keyMap struct {
+	Up, Down, Left, Right key.Binding
+	PageUp, PageDown      key.Binding
+	Next, Prev            key.Binding // form focus
+	Toggle                key.Binding // checkbox, or file on the file list
+	Run                   key.Binding
+	Back                  key.Binding // leave a screen; quits from the form
+	Quit                  key.Binding
+	ForceQuit             key.Binding // quits from any screen
+	History               key.Binding
+	Queue                 key.Binding // runs started this session
+	Pick                  key.Binding
+	Save                  key.Binding
+	Formats               key.Binding
+	Inspect               key.Binding // the form's files as gm identify sees them
+	Again                 key.Binding // back to the form after a run
+	Export                key.Binding // per-file report after a run
+	Retry                 key.Binding // the files a run failed on
+	Edit                  key.Binding // history entry onto the form
+	All                   key.Binding // select all or none on the file list
+	Preserve, Overwrite   key.Binding // answers when the form asks for the output mode
+}
+
+// keyAction names one of the keyMap's bindings for the configuration file.
+type keyAction struct {
+	name    string
+	keys    []string // default keys, as bubbletea names them
+	binding func(*keyMap) *key.Binding
+}
+
+// keyActions are the actions the configuration file can rebind.
+var keyActions = []keyAction{
+	{"up", []string{"up"}, func(k *keyMap) *key.Binding { return &k.Up }},
+	{"down", []string{"down"}, func(k *keyMap) *key.Binding { return &k.Down }},
+	{"left", []string{"left"}, func(k *keyMap) *key.Binding { return &k.Left }},
+	{"right", []string{"right"}, func(k *keyMap) *key.Binding { return &k.Right }},
+	{"page_up", []string{"pgup"}, func(k *keyMap) *key.Binding { return &k.PageUp }},
+	{"page_down", []string{"pgdown"}, func(k *keyMap) *key.Binding { return &k.PageDown }},
+	{"next", []string{"tab"}, func(k *keyMap) *key.Binding { return &k.Next }},
+	{"prev", []string{"shift+tab"}, func(k *keyMap) *key.Binding { return &k.Prev }},
+	{"toggle", []string{" "}, func(k *keyMap) *key.Binding { return &k.Toggle }},
+	{"run", []string{"enter"}, func(k *keyMap) *key.Binding { return &k.Run }},
+	{"back", []string{"esc"}, func(k *keyMap) *key.Binding { return &k.Back }},
+	{"quit", []string{"q"}, func(k *keyMap) *key.Binding { return &k.Quit }},
+	{"force_quit", []string{"ctrl+c"}, func(k *keyMap) *key.Binding { return &k.ForceQuit }},
+	{"history", []string{"h"}, func(k *keyMap) *key.Binding { return &k.History }},
+	{"queue", []string{"ctrl+o"}, func(k *keyMap) *key.Binding { return &k.Queue }},
+	{"pick", []string{"ctrl+p"}, func(k *keyMap) *key.Binding { return &k.Pick }},
+	{"save", []string{"ctrl+s"}, func(k *keyMap) *key.Binding { return &k.Save }},
+	{"formats", []string{"ctrl+f"}, func(k *keyMap) *key.Binding { return &k.Formats }},
+	{"inspect", []string{"ctrl+l"}, func(k *keyMap) *key.Binding { return &k.Inspect }},
+	{"again", []string{"r"}, func(k *keyMap) *key.Binding { return &k.Again }},
+	{"export", []string{"e"}, func(k *keyMap) *key.Binding { return &k.Export }},
+	{"retry", []string{"f"}, func(k *keyMap) *key.Binding { return &k.Retry }},
+	{"edit", []string{"e"}, func(k *keyMap) *key.Binding { return &k.Edit }},
+	{"all", []string{"a"}, func(k *keyMap) *key.Binding { return &k.All }},
+	{"preserve", []string{"p"}, func(k *keyMap) *key.Binding { return &k.Preserve }},
+	{"overwrite", []string{"o"}, func(k *keyMap) *key.Binding { return &k.Overwrite }},
+}
+
+// defaultKeyMap returns the bindings the TUI has without a configuration
+// file.
+func defaultKeyMap() keyMap {
+	var k keyMap
+	for _, a := range keyActions {
+		*a.binding(&k) = key.NewBinding(key.WithKeys(a.keys...))
+	}
+	return k
+}
+
+// rebind replaces the keys of the actions in keys, as read from the
+// configuration file.  It changes nothing when an action is unknown.
+func (k *keyMap) rebind(keys map[string][]string) error {
+	var unknown []string
+	for name := range keys {
+		if !slices.ContainsFunc(keyActions, func(a keyAction) bool { return a.name == name }) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return fmt.Errorf("keys: unknown action %q", unknown[0])
+	}
+	for _, a := range keyActions {
+		if ks, ok := keys[a.name]; ok {
+			ks = slices.Clone(ks)
+			for i, s := range ks {
+				if s == "space" {
+					ks[i] = " " // how bubbletea names the space bar
+				}
+			}
+			*a.binding(k) = key.NewBinding(key.WithKeys(ks...))
+		}
+	}
+	return nil
+}
+
+// changed returns the actions whose keys differ from the defaults, in the
+// form rebind takes, for session recordings.
+func (k keyMap) changed() map[string][]string {
+	var keys map[string][]string
+	for _, a := range keyActions {
+		if ks := a.binding(&k).Keys(); !slices.Equal(ks, a.keys) {
+			if keys == nil {
+				keys = map[string][]string{}
+			}
+			keys[a.name] = ks
+		}
+	}
+	return keys
+}
+
+// keyLabels spells bubbletea key names the way the help lines show them.
+var keyLabels = map[string]string{
+	"up": "↑", "down": "↓", "left": "←", "right": "→",
+	"pgup": "PgUp", "pgdown": "PgDn",
+	"tab": "Tab", "shift+tab": "Shift+Tab",
+	"enter": "Enter", "esc": "Esc", " ": "Space",
+}
+
+// keyLabel returns how the help lines show the key named s.
+func keyLabel(s string) string {
+	if l, ok := keyLabels[s]; ok {
+		return l
+	}
+	mod, rest, ok := strings.Cut(s, "+")
+	if ok && rest != "" && (mod == "ctrl" || mod == "alt") {
+		return capitalize(mod) + "+" + strings.ToUpper(rest)
+	}
+	return s
+}
+
+// keyHelp lists every key of the bindings for a help line, as in
+// "Ctrl+C / q".
+func keyHelp(bs ...key.Binding) string {
+	var labels []string
+	for _, b := range bs {
+		for _, s := range b.Keys() {
+			labels = append(labels, keyLabel(s))
+		}
+	}
+	return strings.Join(labels, " / ")
+}
+
+// keysHelp shows the first key of each binding, run together when they are
+// all single characters, as in "↑↓←→".
+func keysHelp(bs ...key.Binding) string {
+	var labels []string
+	short := true
+	for _, b := range bs {
+		if ks := b.Keys(); len(ks) > 0 {
+			l := keyLabel(ks[0])
+			labels = append(labels, l)
+			short = short && utf8.RuneCountInString(l) == 1
+		}
+	}
+	if short {
+		return strings.Join(labels, "")
+	}
+	return strings.Join(labels, " ")
+}
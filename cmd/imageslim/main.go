@@ -8,21 +8,30 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
+	"github.com/brunovpinheiro/ImageSlim/internal/config"
+	"github.com/brunovpinheiro/ImageSlim/internal/geometry"
 	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+	"github.com/brunovpinheiro/ImageSlim/internal/job"
+	"github.com/brunovpinheiro/ImageSlim/internal/sysload"
 )
 
 // ---------------------------------------------------------------------------
@@ -37,23 +46,112 @@ const (
 	stateRunning                 // GraphicsMagick is running
 	stateDone                    // Command completed successfully
 	stateError                   // Command failed
+	stateFormats                 // Format capability matrix
+	stateHistory                 // Past runs
+	stateFiles                   // File list to pick from before a run
+	stateSetup                   // First-run setup
+	stateQueue                   // Runs queued this session
+	stateConfirm                 // File count and size before a run starts
+	stateInspect                 // What gm identify says about each file
 )
 
+// String names the state in session traces.
+func (s appState) String() string {
+	switch s {
+	case stateForm:
+		return "form"
+	case stateRunning:
+		return "running"
+	case stateDone:
+		return "done"
+	case stateError:
+		return "error"
+	case stateFormats:
+		return "formats"
+	case stateHistory:
+		return "history"
+	case stateFiles:
+		return "files"
+	case stateSetup:
+		return "setup"
+	case stateQueue:
+		return "queue"
+	case stateConfirm:
+		return "confirm"
+	case stateInspect:
+		return "inspect"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
 // ---------------------------------------------------------------------------
 // Form focus positions
 // ---------------------------------------------------------------------------
 
-// Focus indices for the form screen.  0–2 are the text inputs; 3 and 4 are
-// radio selectors (which use arrow keys instead of text entry).
+// Focus indices for the form screen.  0–2 are text inputs; 3–11 are radio
+// selectors (which use arrow keys instead of text entry) and checkboxes
+// (toggled with Space); 12 and 13 are the date filter inputs, 14 the output
+// format selector, 15 the preset selector and 16 the naming selector, which
+// come last so that the positions of the older fields never change.  Tab
+// follows focusOrder instead.
 const (
-	focusDir     = 0
-	focusResize  = 1
-	focusQuality = 2
-	focusMode    = 3 // output mode selector (preserve / overwrite)
-	focusScope   = 4 // scope selector (this folder / this folder + subfolders)
-	maxFocus     = 4
+	focusDir        = 0
+	focusResize     = 1
+	focusQuality    = 2
+	focusResizeMode = 3  // resize mode selector (fit / fill / pad)
+	focusGravity    = 4  // gravity grid (fill and pad only)
+	focusMode       = 5  // output mode selector (preserve / overwrite)
+	focusScope      = 6  // scope selector (this folder / this folder + subfolders)
+	focusResume     = 7  // resume selector (skip already processed / force)
+	focusBackup     = 8  // backup selector (overwrite mode only)
+	focusInterlace  = 9  // progressive JPEG checkbox
+	focusAutoOrient = 10 // EXIF auto-orient checkbox
+	focusSharpen    = 11 // post-resize sharpening checkbox
+	focusAfter      = 12 // modified-after date input
+	focusBefore     = 13 // modified-before date input
+	focusFormat     = 14 // output format selector (original / WebP / AVIF)
+	focusPreset     = 15 // preset selector (custom / presets from the config file)
+	focusNaming     = 16 // naming selector (original names / schemes from the config file)
 )
 
+// focusOrder is the order Tab moves through the form: top to bottom, except
+// that the preset selector, shown above them all, comes last, so that
+// Shift+Tab from the directory reaches it.
+var focusOrder = []int{
+	focusDir, focusResize, focusQuality, focusResizeMode, focusGravity,
+	focusMode, focusScope, focusResume, focusBackup, focusInterlace,
+	focusAutoOrient, focusSharpen, focusAfter, focusBefore, focusFormat,
+	focusNaming, focusPreset,
+}
+
+// Indices into model.inputs.  The first three match their focus positions.
+const (
+	inputAfter  = 3
+	inputBefore = 4
+)
+
+// inputFocus is the focus position of each text input, in model.inputs order.
+var inputFocus = []int{focusDir, focusResize, focusQuality, focusAfter, focusBefore}
+
+// ---------------------------------------------------------------------------
+// Resize mode options
+// ---------------------------------------------------------------------------
+
+// resizeModes maps the resize mode selector to gm.Options.ResizeMode.
+var resizeModes = []string{gm.ResizeFit, gm.ResizeFill, gm.ResizePad}
+
+var resizeModeLabels = []string{
+	"Fit inside the box  →  keep the whole image",
+	"Fill and crop  →  exactly W×H, overflow cut off",
+	"Pad  →  exactly W×H, white borders",
+}
+
+// gravityCenter is the index of gm.DefaultGravity in gm.Gravities, which the
+// gravity grid shows as a 3×3 compass.
+const gravityCenter = 4
+
+var gravityLabels = []string{"NW", "N", "NE", "W", "Center", "E", "SW", "S", "SE"}
+
 // ---------------------------------------------------------------------------
 // Output mode options
 // ---------------------------------------------------------------------------
@@ -79,72 +177,36 @@ var scopeLabels = []string{
 	"This folder only  (non-recursive)",
 }
 
-// ---------------------------------------------------------------------------
-// Lipgloss styles
-// ---------------------------------------------------------------------------
-
+// Resume selector options.
 const (
-	accentColor  = "#7D56F4"
-	successColor = "#04B575"
-	errorColor   = "#FF4672"
-	mutedColor   = "#626262"
-	warnColor    = "#FFAA00"
-	dimColor     = "#3D3D3D"
+	resumeSkip  = 0 // skip files an earlier run already converted
+	resumeForce = 1 // reprocess everything
 )
 
-var (
-	titleStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color(accentColor))
-
-	subtitleStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color(mutedColor))
-
-	labelStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color(mutedColor))
-
-	focusedLabelStyle = lipgloss.NewStyle().
-				Bold(true).
-				Foreground(lipgloss.Color(accentColor))
-
-	// Left-border highlight for the focused text input.
-	focusedInputStyle = lipgloss.NewStyle().
-				Border(lipgloss.NormalBorder(), false, false, false, true).
-				BorderForeground(lipgloss.Color(accentColor)).
-				PaddingLeft(1)
-
-	// Dim left border for blurred text inputs.
-	blurredInputStyle = lipgloss.NewStyle().
-				Border(lipgloss.NormalBorder(), false, false, false, true).
-				BorderForeground(lipgloss.Color(dimColor)).
-				PaddingLeft(1)
-
-	selectedModeStyle = lipgloss.NewStyle().
-				Bold(true).
-				Foreground(lipgloss.Color(accentColor))
-
-	unselectedModeStyle = lipgloss.NewStyle().
-				Foreground(lipgloss.Color(mutedColor))
-
-	successStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color(successColor))
+var resumeLabels = []string{
+	"Skip files already processed  (resume)",
+	"Reprocess everything  (force)",
+}
 
-	errorStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color(errorColor))
+// Backup selector options.  Backups only apply to overwrite mode.
+const (
+	backupOn  = 0 // copy originals to .imageslim-backup/ before mogrify
+	backupOff = 1
+)
 
-	warningStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color(warnColor))
+var backupLabels = []string{
+	"Back up originals to " + gm.DefaultBackupDir + "/ first",
+	"No backup",
+}
 
-	helpStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color(mutedColor))
+// outputFormats maps the output format selector to gm.Options.OutputFormat.
+var outputFormats = []string{"", gm.OutputWebP, gm.OutputAVIF}
 
-	cmdStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color(mutedColor)).
-			Italic(true)
-)
+var formatLabels = []string{
+	"Keep each file's format",
+	"WebP  →  cwebp, or gm without it",
+	"AVIF  →  avifenc, or gm without it",
+}
 
 // ---------------------------------------------------------------------------
 // Bubble Tea message types
@@ -154,6 +216,26 @@ var (
 // command finishes.
 type resultMsg gm.Result
 
+// powerMsg reports the battery and thermal state during a power-aware run.
+type powerMsg sysload.Power
+
+// outputMsg carries the lines run, the index into the queue plus one,
+// printed since the last outputMsg, and how far it has got when that
+// changed.  The next lines come from more, until it is nil.
+type outputMsg struct {
+	run      int
+	lines    []string
+	progress *gm.Progress
+	more     <-chan runUpdate
+}
+
+// runUpdate is a line a run printed or, with progress set, how far it has
+// got; runs send them to the TUI as they go.
+type runUpdate struct {
+	line     string
+	progress *gm.Progress
+}
+
 // ---------------------------------------------------------------------------
 // Model
 // ---------------------------------------------------------------------------
@@ -161,32 +243,111 @@ type resultMsg gm.Result
 // model is the single Bubble Tea application model.  It holds state for all
 // screens; only the fields relevant to the current appState are meaningful.
 type model struct {
-	state      appState
-	inputs     []textinput.Model // form inputs: dir, resize, quality
-	focus      int               // which form element is focused (0–4)
-	outputMode int               // 0 = preserve, 1 = overwrite
-	scope      int               // 0 = recursive, 1 = flat (this folder only)
-	result     gm.Result         // populated after command finishes
-	spinner    spinner.Model     // animated spinner shown during running state
-	viewport   viewport.Model   // scrollable output shown in done/error states
-	vpReady    bool              // true once viewport has been initialised
-	width      int               // terminal width (updated via WindowSizeMsg)
-	height     int               // terminal height (updated via WindowSizeMsg)
-	gmFound    bool              // whether "gm" binary was found in PATH
+	state         appState
+	inputs        []textinput.Model // form inputs: dir, resize, quality, modified after, modified before
+	focus         int               // which form element is focused (see focusDir…)
+	presets       []config.Preset   // offered by the preset selector
+	preset        int               // 0 = custom, else index into presets + 1
+	namings       []config.Naming   // offered by the naming selector
+	keys          keyMap            // key bindings, with the config file's changes
+	askOutput     bool              // ask preserve or overwrite before runs from the form, per the config file
+	asking        bool              // the form waits for that answer
+	setup         *setupState       // the first-run setup while it is shown
+	resizeMode    int               // index into resizeModes
+	gravity       int               // index into gm.Gravities (3×3 compass)
+	outputMode    int               // 0 = preserve, 1 = overwrite
+	scope         int               // 0 = recursive, 1 = flat (this folder only)
+	resume        int               // 0 = skip already processed, 1 = force
+	backup        int               // 0 = back up before overwrite, 1 = no backup
+	interlace     bool              // write progressive JPEGs (gm -interlace)
+	autoOrient    bool              // rotate pixels per EXIF orientation (gm -auto-orient)
+	sharpen       bool              // unsharp mask after resizing (gm -unsharp)
+	format        int               // index into outputFormats
+	effort        int               // WebP/AVIF encoding effort, from the job file
+	heic          bool              // also convert HEIC photos, from the job file; preserve mode only
+	animatedGIF   string            // what happens to animated GIFs, from the job file
+	report        string            // per-file report file, from the job file
+	reportFormat  string            // its format, from the job file
+	exportFormat  string            // format of the reports exportReport saves, per the config file
+	baseline      string            // check against or save the directory's baseline, from the job file
+	tolerance     int               // allowed deviation from the baseline, from the job file
+	watermark     gm.Watermark      // overlay from the job file; not editable on the form
+	steps         []string          // pipeline of steps, from the preset or the job file
+	nameTmpl      string            // output name template, from the naming selector or the job file; preserve mode only
+	workers       int               // files converted at once, from the job file
+	perDirectory  int               // files converted at once per directory, from the job file
+	symlinks      string            // what scans do with symbolic links, from the job file
+	targetSize    int64             // target JPEG size in bytes, from the job file
+	optimizePNG   string            // PNG optimisation level, from the job file
+	minQuality    int               // lowest quality tried for targetSize, from the job file
+	minSize       int64             // minimum file size in bytes, from the job file
+	minWidth      int               // minimum image width, from the job file
+	minHeight     int               // minimum image height, from the job file
+	powerAware    bool              // throttle on battery or when hot, from the job file
+	gmVersion     string            // GraphicsMagick version the job file pins
+	power         sysload.Power     // latest power state while a power-aware run goes on
+	result        gm.Result         // populated after command finishes
+	shown         int               // index into queue of the run whose result is shown
+	spinner       spinner.Model     // animated spinner shown during running state
+	viewport      viewport.Model    // scrollable output shown in done/error states
+	vpReady       bool              // true once viewport has been initialised
+	live          viewport.Model    // output of the running run, as it comes
+	liveLines     []string          // the lines in live, at most maxLiveLines
+	pace          gm.Progress       // how far the running run has got
+	width         int               // terminal width (updated via WindowSizeMsg)
+	height        int               // terminal height (updated via WindowSizeMsg)
+	gmErr         error             // why the gm binary is unusable; nil when it was found
+	job           *job.Job          // job file the form was loaded from, if any
+	formats       *formatsMsg       // gm's format list once loaded for the formats screen
+	history       *historyMsg       // past runs once loaded for the history screen
+	historyCursor int               // selected entry on the history screen
+	historyBack   appState          // screen the history screen returns to
+	picks         *filesMsg         // matching files once scanned for the file list
+	picked        []bool            // which of picks.Files are selected
+	pickCursor    int               // file under the cursor on the file list
+	inspect       *inspectMsg       // the form's files once described for the inspect screen
+	inspectCursor int               // file under the cursor on the inspect screen
+	files         []string          // files picked for the run; nil for every matching file
+	queue         []queuedRun       // runs started this session, oldest first
+	running       int               // 0 = none, else index into queue + 1
+	queueCursor   int               // selected run on the queue screen
+	queueBack     appState          // screen the queue screen returns to
+	status        string            // one-line feedback shown under the form
+	ui            uiOptions         // launch-time interface settings
 }
 
 // ---------------------------------------------------------------------------
 // Initialisation
 // ---------------------------------------------------------------------------
 
+// gmCheck reports why the gm binary opts names is unusable, or not the
+// version opts pins, or nil.  Replay swaps it out so recorded sessions
+// render the same on every machine.
+var gmCheck = func(opts gm.Options) error {
+	_, _, err := gm.Check(opts)
+	return err
+}
+
+// loadConfig returns the configuration's presets, theme and key bindings,
+// with the values of -set flags on top, and why a configuration file could
+// not be used, if one could not.  Replay swaps it out like gmCheck.
+var loadConfig = config.Load
+
+// clock tells the time for dates relative to today, such as "30d".  Replay
+// pins it to when the session was recorded.
+var clock = time.Now
+
+// workingDir returns the directory the form starts in.  The golden-file
+// test pins it, as the screens show it.
+var workingDir = os.Getwd
+
 // initialModel builds the starting model with sensible defaults.
-func initialModel() model {
-	// Detect whether the gm binary is installed.
-	_, err := exec.LookPath("gm")
-	gmFound := err == nil
+func initialModel(ui uiOptions) model {
+	// Detect whether the gm binary is installed and actually runs.
+	gmErr := gmCheck(gm.Options{GMPath: ui.gmPath, GMVersion: ui.gmVersion})
 
 	// Default base directory: wherever the user opened the terminal.
-	defaultDir, err := os.Getwd()
+	defaultDir, err := workingDir()
 	if err != nil {
 		defaultDir = "."
 	}
@@ -200,7 +361,7 @@ func initialModel() model {
 	dir.Focus()
 
 	resize := textinput.New()
-	resize.Placeholder = "e.g. 1200x1200"
+	resize.Placeholder = "e.g. 1200x1200, 50% or longedge:1600"
 	resize.SetValue("1200x1200")
 	resize.Width = 20
 
@@ -210,22 +371,153 @@ func initialModel() model {
 	quality.CharLimit = 3
 	quality.Width = 10
 
+	after := textinput.New()
+	after.Placeholder = "e.g. 2026-09-15 or 30d"
+	after.Width = 24
+
+	before := textinput.New()
+	before.Placeholder = "e.g. 2026-10-01"
+	before.Width = 24
+
+	inputs := []textinput.Model{dir, resize, quality, after, before}
+	for i := range inputs {
+		ui.applyInput(&inputs[i])
+	}
+
+	// --- config and theme ---
+
+	c, err := loadConfig(ui.sets...)
+	applyTheme(c.Theme)
+
 	// --- spinner ---
 
 	sp := spinner.New()
-	sp.Spinner = spinner.Spinner{
-		Frames: []string{"⣾", "⣽", "⣻", "⢿", "⡿", "⣟", "⣯", "⣷"},
-		FPS:    time.Second / 10,
-	}
-	sp.Style = lipgloss.NewStyle().Foreground(lipgloss.Color(accentColor))
+	sp.Spinner = spinnerStyles[ui.spinner]
+	sp.Style = spinnerStyle
 
-	return model{
+	m := model{
 		state:   stateForm,
-		inputs:  []textinput.Model{dir, resize, quality},
+		inputs:  inputs,
 		focus:   focusDir,
+		gravity: gravityCenter,
 		spinner: sp,
-		gmFound: gmFound,
+		gmErr:   gmErr,
+		ui:      ui,
+	}
+	m.presets, m.namings, m.keys = c.Presets, c.Naming, defaultKeyMap()
+	// Safe mode never asks: overwrite is not an answer it allows.
+	m.ui.safeMode = ui.safeMode || c.SafeMode
+	m.askOutput = c.Output == config.OutputAsk && !m.ui.safeMode
+	m.exportFormat = cmp.Or(c.ReportFormat, config.DefaultReportFormat)
+	if err == nil {
+		err = m.keys.rebind(c.Keys)
+	}
+	if err != nil {
+		m.status = "✗ " + err.Error()
+	}
+	return m
+}
+
+// withPreset returns a copy of m with the fields p sets filled in.  The
+// steps are always p's, so that a preset without any converts in one go;
+// a preset with steps switches off orientation and sharpening unless it
+// sets them, as those are steps of their own.
+func (m model) withPreset(p config.Preset) model {
+	m.steps = p.Steps
+	if len(p.Steps) > 0 {
+		m.autoOrient, m.sharpen = false, false
+	}
+	if p.Resize != "" {
+		m.inputs[focusResize].SetValue(p.Resize)
+	}
+	if p.Quality != 0 {
+		m.inputs[focusQuality].SetValue(strconv.Itoa(p.Quality))
+	}
+	if p.ResizeMode != "" {
+		mode, _ := gm.ParseResizeMode(p.ResizeMode) // checked by config.Load
+		m.resizeMode = max(slices.Index(resizeModes, mode), 0)
+	}
+	if p.Format != "" {
+		format, _ := gm.ParseOutputFormat(p.Format)
+		m.format = max(slices.Index(outputFormats, format), 0)
+	}
+	if p.Interlace != nil {
+		m.interlace = *p.Interlace
 	}
+	if p.AutoOrient != nil {
+		m.autoOrient = *p.AutoOrient
+	}
+	if p.Sharpen != nil {
+		m.sharpen = *p.Sharpen
+	}
+	return m
+}
+
+// withJob returns a copy of m with the form fields filled from j.  The job is
+// remembered so that Ctrl+S writes back to the same file and "run again"
+// returns to the job's values rather than the built-in defaults.
+func (m model) withJob(j *job.Job) model {
+	opts := j.Options()
+	m.inputs[focusDir].SetValue(opts.Dir)
+	m.inputs[focusResize].SetValue(opts.Resize)
+	m.inputs[focusQuality].SetValue(strconv.Itoa(opts.Quality))
+	if opts.SmartQuality {
+		m.inputs[focusQuality].SetValue(gm.QualitySmart)
+	}
+	// The dates are shown as written in the job, so "30d" stays relative.
+	m.inputs[inputAfter].SetValue(j.ModifiedAfter)
+	m.inputs[inputBefore].SetValue(j.ModifiedBefore)
+	m.resizeMode = max(slices.Index(resizeModes, opts.ResizeMode), 0)
+	m.gravity = gravityCenter
+	if i := slices.Index(gm.Gravities, opts.Gravity); i >= 0 {
+		m.gravity = i
+	}
+	m.outputMode = modePreserve
+	if opts.Overwrite {
+		m.outputMode = modeOverwrite
+	}
+	m.scope = scopeRecursive
+	if !opts.Recursive {
+		m.scope = scopeFlat
+	}
+	m.resume = resumeSkip
+	if opts.Force {
+		m.resume = resumeForce
+	}
+	m.backup = backupOff
+	if opts.Backup {
+		m.backup = backupOn
+	}
+	m.interlace = opts.Interlace != ""
+	m.autoOrient = opts.AutoOrient
+	m.sharpen = opts.Sharpen != ""
+	m.watermark = opts.Watermark
+	m.steps = gm.FormatSteps(opts.Steps)
+	m.symlinks = opts.FollowSymlinks
+	m.nameTmpl = opts.NameTemplate
+	if m.nameTmpl != "" && m.namingIndex() == 0 {
+		m.namings = append(slices.Clone(m.namings), config.Naming{Name: "From the job file", Template: m.nameTmpl})
+	}
+	m.workers = opts.Workers
+	m.perDirectory = opts.PerDirectory
+	m.targetSize, m.minQuality = opts.TargetSize, opts.MinQuality
+	m.optimizePNG = opts.OptimizePNG
+	m.format = max(slices.Index(outputFormats, opts.OutputFormat), 0)
+	m.effort = opts.Effort
+	m.heic = opts.HEIC
+	m.animatedGIF = opts.AnimatedGIF
+	m.report, m.reportFormat = opts.Report, opts.ReportFormat
+	m.baseline, m.tolerance = opts.Baseline, opts.BaselineTolerance
+	m.files = opts.Files
+	m.minSize, m.minWidth, m.minHeight = opts.MinFileSize, opts.MinWidth, opts.MinHeight
+	m.powerAware = opts.PowerAware
+	m.gmVersion = opts.GMVersion
+	if m.gmVersion != "" && m.ui.gmVersion == "" {
+		// The job pins a version the startup check did not know about.
+		m.gmErr = gmCheck(gm.Options{GMPath: m.ui.gmPath, GMVersion: m.gmVersion})
+	}
+	m.job = j
+	return m
 }
 
 // ---------------------------------------------------------------------------
@@ -233,7 +525,7 @@ func initialModel() model {
 // ---------------------------------------------------------------------------
 
 func (m model) Init() tea.Cmd {
-	return textinput.Blink
+	return m.ui.blink()
 }
 
 // ---------------------------------------------------------------------------
@@ -248,29 +540,38 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.width, m.height = msg.Width, msg.Height
 		if m.vpReady {
 			m.viewport.Width = viewportWidth(m.width)
-			m.viewport.Height = viewportHeight(m.height)
+			m.viewport.Height = m.resultViewportHeight()
 		}
+		m.live.Width, m.live.Height = viewportWidth(m.width), viewportHeight(m.height)
+		m.setLive()
 		return m, nil
 
-	// The background gm command has finished; switch to done or error screen.
+	// The background gm command has finished; start the next queued run,
+	// and switch to the done or error screen after the last.
 	case resultMsg:
-		m.result = gm.Result(msg)
-		if m.result.Err != nil {
-			m.state = stateError
-		} else {
-			m.state = stateDone
-		}
-		// Initialise the scrollable viewport with the combined command output.
-		content := buildOutputContent(m.result)
-		vp := viewport.New(viewportWidth(m.width), viewportHeight(m.height))
-		vp.SetContent(content)
-		m.viewport = vp
-		m.vpReady = true
-		return m, nil
+		return m.finishRun(gm.Result(msg))
 
-	// Spinner tick: keep the spinner running while processing.
+	// The running run printed something; lines of a run that has finished
+	// since are dropped.
+	case outputMsg:
+		var cmd tea.Cmd
+		if msg.more != nil {
+			cmd = waitOutputCmd(msg.run, msg.more)
+		}
+		if msg.run == m.running {
+			if msg.progress != nil {
+				m.pace = *msg.progress
+			}
+			if len(msg.lines) > 0 {
+				m = m.addOutput(msg.lines)
+			}
+		}
+		return m, cmd
+
+	// Spinner tick: keep the spinner running while processing, on every
+	// screen, since the queue screen shows it too.
 	case spinner.TickMsg:
-		if m.state == stateRunning {
+		if m.running > 0 {
 			var cmd tea.Cmd
 			m.spinner, cmd = m.spinner.Update(msg)
 			return m, cmd
@@ -280,7 +581,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// Key events are routed to the active screen's handler.
 	case tea.KeyMsg:
 		// Ctrl+C always quits, regardless of which screen is active.
-		if msg.Type == tea.KeyCtrlC {
+		if key.Matches(msg, m.keys.ForceQuit) {
 			return m, tea.Quit
 		}
 		switch m.state {
@@ -290,7 +591,53 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m.updateRunning(msg)
 		case stateDone, stateError:
 			return m.updateDoneOrError(msg)
+		case stateFormats:
+			return m.updateFormats(msg)
+		case stateHistory:
+			return m.updateHistory(msg)
+		case stateFiles:
+			return m.updatePicker(msg)
+		case stateSetup:
+			return m.updateSetup(msg)
+		case stateQueue:
+			return m.updateQueue(msg)
+		case stateConfirm:
+			return m.updateConfirm(msg)
+		case stateInspect:
+			return m.updateInspect(msg)
 		}
+
+	// gm's format list for the formats screen has arrived.
+	case formatsMsg:
+		m.formats = &msg
+		return m, nil
+
+	// gm identify has described the files for the inspect screen.
+	case inspectMsg:
+		m.inspect = &msg
+		return m, nil
+
+	// The run history for the history screen has been read.
+	case historyMsg:
+		m.history = &msg
+		return m, nil
+
+	// The files for the file list have been found; all start selected.
+	case filesMsg:
+		m.picks = &msg
+		m.picked = make([]bool, len(msg.Files))
+		for i := range m.picked {
+			m.picked[i] = true
+		}
+		return m, nil
+
+	// A power-aware run keeps the throttled badge up to date.
+	case powerMsg:
+		if m.running == 0 || !polls(m.queue[m.running-1].opts) {
+			return m, nil
+		}
+		m.power = sysload.Power(msg)
+		return m, checkPowerCmd(gm.PowerInterval)
 	}
 
 	// Forward non-key messages to the viewport so mouse-wheel scrolling works.
@@ -299,33 +646,64 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.viewport, cmd = m.viewport.Update(msg)
 		return m, cmd
 	}
+	if m.state == stateRunning {
+		var cmd tea.Cmd
+		m.live, cmd = m.live.Update(msg)
+		return m, cmd
+	}
 
 	return m, nil
 }
 
 // updateForm handles key events on the configuration form screen.
 func (m model) updateForm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.Type {
+	if m.asking {
+		return m.answerOutputMode(msg)
+	}
+
+	// A focused text input takes every printable key for normal editing,
+	// so bindings such as 'q' or 'h' only work on selectors.
+	typing := m.focusedInput() >= 0 && (msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace)
+
+	switch {
+	case typing: // straight to the text input
 
-	case tea.KeyEsc:
+	case key.Matches(msg, m.keys.Back):
 		return m, tea.Quit
 
-	// Tab / Shift+Tab cycle focus through the four form elements.
-	case tea.KeyTab, tea.KeyShiftTab:
-		if msg.Type == tea.KeyShiftTab {
-			m.focus--
-			if m.focus < 0 {
-				m.focus = maxFocus
-			}
+	// Ctrl+S exports the current form as a shareable job file.
+	case key.Matches(msg, m.keys.Save):
+		m.status = m.saveJob()
+		return m, nil
+
+	// Ctrl+P lists the matching files to pick from before running.
+	case key.Matches(msg, m.keys.Pick):
+		return m.openPicker()
+
+	// Ctrl+F shows which formats the installed gm can read and write.
+	case key.Matches(msg, m.keys.Formats):
+		m.state = stateFormats
+		if m.formats == nil || m.formats.Err != "" {
+			m.formats = nil
+			return m, loadFormatsCmd(m.ui.gmPath)
+		}
+		return m, nil
+
+	// Ctrl+L describes the matching files: size, format, colour space, EXIF.
+	case key.Matches(msg, m.keys.Inspect):
+		return m.openInspect()
+
+	// Tab / Shift+Tab cycle focus through the form elements.
+	case key.Matches(msg, m.keys.Next, m.keys.Prev):
+		i, n := slices.Index(focusOrder, m.focus), len(focusOrder)
+		if key.Matches(msg, m.keys.Prev) {
+			m.focus = focusOrder[(i+n-1)%n]
 		} else {
-			m.focus++
-			if m.focus > maxFocus {
-				m.focus = 0
-			}
+			m.focus = focusOrder[(i+1)%n]
 		}
 		var cmds []tea.Cmd
 		for i := range m.inputs {
-			if i == m.focus {
+			if inputFocus[i] == m.focus {
 				cmds = append(cmds, m.inputs[i].Focus())
 			} else {
 				m.inputs[i].Blur()
@@ -333,17 +711,28 @@ func (m model) updateForm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 		return m, tea.Batch(cmds...)
 
-	// Enter starts processing from any focus position.
-	case tea.KeyEnter:
-		m.state = stateRunning
-		return m, tea.Batch(
-			runCmd(m.buildOptions()),
-			m.spinner.Tick,
-		)
+	// Enter starts processing from any focus position, once the files it
+	// would touch have been counted and confirmed.  With output: ask in the
+	// config file a valid form first asks for the output mode; forms
+	// opened from a job file keep the job's.
+	case key.Matches(msg, m.keys.Run):
+		if m.askOutput && m.job == nil && m.validateForm() == nil {
+			return m.askOutputMode(), nil
+		}
+		return m.confirmRun()
 
-	// Arrow keys change the focused selector's value.
-	case tea.KeyUp:
+	// Arrow keys change the focused selector's value.  The gravity grid
+	// moves in all four directions.
+	case key.Matches(msg, m.keys.Up):
 		switch m.focus {
+		case focusResizeMode:
+			if m.resizeMode > 0 {
+				m.resizeMode--
+			}
+		case focusGravity:
+			if m.gravity >= 3 {
+				m.gravity -= 3
+			}
 		case focusMode:
 			if m.outputMode > 0 {
 				m.outputMode--
@@ -352,65 +741,264 @@ func (m model) updateForm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			if m.scope > 0 {
 				m.scope--
 			}
+		case focusResume:
+			if m.resume > 0 {
+				m.resume--
+			}
+		case focusBackup:
+			if m.backup > 0 {
+				m.backup--
+			}
+		case focusFormat:
+			if m.format > 0 {
+				m.format--
+			}
+		case focusPreset:
+			if m.preset > 0 {
+				m.preset--
+				return m.choosePreset(), nil
+			}
+		case focusNaming:
+			m = m.chooseNaming(m.namingIndex() - 1)
 		}
 		return m, nil
 
-	case tea.KeyDown:
+	case key.Matches(msg, m.keys.Down):
 		switch m.focus {
+		case focusResizeMode:
+			if m.resizeMode < len(resizeModeLabels)-1 {
+				m.resizeMode++
+			}
+		case focusGravity:
+			if m.gravity < 6 {
+				m.gravity += 3
+			}
 		case focusMode:
-			if m.outputMode < len(modeLabels)-1 {
+			if m.ui.safeMode {
+				m.status = "✗ safe mode: originals cannot be overwritten"
+			} else if m.outputMode < len(modeLabels)-1 {
 				m.outputMode++
 			}
 		case focusScope:
 			if m.scope < len(scopeLabels)-1 {
 				m.scope++
 			}
+		case focusResume:
+			if m.resume < len(resumeLabels)-1 {
+				m.resume++
+			}
+		case focusBackup:
+			if m.backup < len(backupLabels)-1 {
+				m.backup++
+			}
+		case focusFormat:
+			if m.format < len(formatLabels)-1 {
+				m.format++
+			}
+		case focusPreset:
+			if m.preset < len(m.presets) {
+				m.preset++
+				return m.choosePreset(), nil
+			}
+		case focusNaming:
+			m = m.chooseNaming(m.namingIndex() + 1)
 		}
 		return m, nil
 
-	case tea.KeyRunes:
-		// 'q' quits only when a selector is focused, because text
-		// inputs capture all rune keys for normal editing.
-		if string(msg.Runes) == "q" && (m.focus == focusMode || m.focus == focusScope) {
-			return m, tea.Quit
+	// Left and right only mean something on the gravity grid; elsewhere
+	// they move the text cursor, or fall through to other bindings.
+	case m.focus == focusGravity && key.Matches(msg, m.keys.Left, m.keys.Right):
+		if key.Matches(msg, m.keys.Left) && m.gravity%3 > 0 {
+			m.gravity--
+		} else if key.Matches(msg, m.keys.Right) && m.gravity%3 < 2 {
+			m.gravity++
 		}
+		return m, nil
+
+	// Space toggles the focused checkbox.
+	case key.Matches(msg, m.keys.Toggle) && m.toggle():
+		return m, nil
+
+	case key.Matches(msg, m.keys.Quit):
+		return m, tea.Quit
+
+	case key.Matches(msg, m.keys.History):
+		return m.openHistory()
+
+	case key.Matches(msg, m.keys.Queue):
+		return m.openQueue()
 	}
 
-	// All other key events go to the currently focused text input.
-	// focusMode and focusScope are selectors, not text inputs.
-	if m.focus < focusMode {
+	// All other key events go to the currently focused text input, if any.
+	if i := m.focusedInput(); i >= 0 {
 		var cmd tea.Cmd
-		m.inputs[m.focus], cmd = m.inputs[m.focus].Update(msg)
+		m.inputs[i], cmd = m.inputs[i].Update(msg)
 		return m, cmd
 	}
 
 	return m, nil
 }
 
+// toggle flips the focused checkbox, and reports whether one was focused.
+func (m *model) toggle() bool {
+	switch m.focus {
+	case focusInterlace:
+		m.interlace = !m.interlace
+	case focusAutoOrient:
+		m.autoOrient = !m.autoOrient
+	case focusSharpen:
+		m.sharpen = !m.sharpen
+	default:
+		return false
+	}
+	return true
+}
+
+// choosePreset applies the preset just selected.  Custom leaves the fields
+// as they are.
+func (m model) choosePreset() model {
+	if m.preset == 0 {
+		return m
+	}
+	return m.withPreset(m.presets[m.preset-1])
+}
+
+// presetInputs are the form fields a preset can set, in form order, with
+// the names the preset selector lists them under once they differ from it.
+var presetInputs = []struct {
+	focus int
+	name  string
+}{
+	{focusResize, "resize"}, {focusQuality, "quality"}, {focusResizeMode, "resize mode"},
+	{focusInterlace, "encoding"}, {focusAutoOrient, "orientation"}, {focusSharpen, "sharpening"},
+	{focusFormat, "format"},
+}
+
+// modified reports whether the field at focus position f no longer shows
+// the value the selected preset gives it.  Fields the preset leaves out,
+// and every field under Custom, are never modified.
+func (m model) modified(f int) bool {
+	if m.preset == 0 {
+		return false
+	}
+	p := m.presets[m.preset-1]
+	switch f {
+	case focusResize:
+		return p.Resize != "" && strings.TrimSpace(m.inputs[focusResize].Value()) != p.Resize
+	case focusQuality:
+		q, err := strconv.Atoi(strings.TrimSpace(m.inputs[focusQuality].Value()))
+		return p.Quality != 0 && (err != nil || q != p.Quality)
+	case focusResizeMode:
+		mode, _ := gm.ParseResizeMode(p.ResizeMode)
+		return p.ResizeMode != "" && resizeModes[m.resizeMode] != mode
+	case focusFormat:
+		format, _ := gm.ParseOutputFormat(p.Format)
+		return p.Format != "" && outputFormats[m.format] != format
+	case focusInterlace:
+		return p.Interlace != nil && m.interlace != *p.Interlace
+	case focusAutoOrient:
+		return p.AutoOrient != nil && m.autoOrient != *p.AutoOrient
+	case focusSharpen:
+		return p.Sharpen != nil && m.sharpen != *p.Sharpen
+	}
+	return false
+}
+
+// modifiedFields names the fields changed since the selected preset filled
+// them in.
+func (m model) modifiedFields() []string {
+	var names []string
+	for _, f := range presetInputs {
+		if m.modified(f.focus) {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// fieldTitle renders the title of the field at focus position f, marked
+// when the field differs from the selected preset.
+func (m model) fieldTitle(f int, title string) string {
+	lbl := labelStyle.Render(title)
+	if m.focus == f {
+		lbl = focusedLabelStyle.Render(title)
+	}
+	if m.modified(f) {
+		lbl += warningStyle.Render("  ✎ modified")
+	}
+	return lbl
+}
+
+// namingIndex returns the naming selector's position: 0 for the original
+// names, else the index into namings + 1.
+func (m model) namingIndex() int {
+	if m.nameTmpl == "" {
+		return 0
+	}
+	return slices.IndexFunc(m.namings, func(n config.Naming) bool { return n.Template == m.nameTmpl }) + 1
+}
+
+// chooseNaming moves the naming selector to position i, when there is one.
+func (m model) chooseNaming(i int) model {
+	switch {
+	case i == 0:
+		m.nameTmpl = ""
+	case i > 0 && i <= len(m.namings):
+		m.nameTmpl = m.namings[i-1].Template
+	}
+	return m
+}
+
+// focusedInput returns the index in m.inputs of the focused text input, or
+// -1 when a selector or checkbox has the focus.
+func (m model) focusedInput() int {
+	return slices.Index(inputFocus, m.focus)
+}
+
 // updateRunning handles key events while GraphicsMagick is processing.
-// The user can only quit; all other input is ignored.
+// The user can go back to the form, whose runs wait for this one, look at
+// the queue or quit; other keys scroll the live output.
 func (m model) updateRunning(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	if msg.String() == "q" || msg.Type == tea.KeyEsc {
+	switch {
+	case key.Matches(msg, m.keys.Back):
+		m.state = stateForm
+		return m, nil
+	case key.Matches(msg, m.keys.Queue):
+		return m.openQueue()
+	case key.Matches(msg, m.keys.Quit):
 		return m, tea.Quit
 	}
-	return m, nil
+	var cmd tea.Cmd
+	m.live, cmd = m.live.Update(msg)
+	return m, cmd
 }
 
 // updateDoneOrError handles key events on the done and error screens.
 func (m model) updateDoneOrError(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.Type {
-	case tea.KeyEsc, tea.KeyEnter:
+	switch {
+	// With several runs this session, leaving a result goes back to them.
+	case key.Matches(msg, m.keys.Back) && len(m.queue) > 1:
+		m.state, m.queueBack = stateQueue, stateForm
+		return m, nil
+	case key.Matches(msg, m.keys.Back, m.keys.Run, m.keys.Quit):
 		return m, tea.Quit
-	case tea.KeyRunes:
-		switch string(msg.Runes) {
-		case "q":
-			return m, tea.Quit
-		case "r":
-			// Return to the form so the user can run another job.
-			nm := initialModel()
-			nm.width, nm.height = m.width, m.height
-			return nm, textinput.Blink
+	case key.Matches(msg, m.keys.History):
+		return m.openHistory()
+	case key.Matches(msg, m.keys.Queue):
+		return m.openQueue()
+	case key.Matches(msg, m.keys.Export):
+		m.status = m.exportReport()
+		return m, nil
+	case key.Matches(msg, m.keys.Retry) && len(m.result.Errors) > 0:
+		return m.retryFailed()
+	case key.Matches(msg, m.keys.Again):
+		// Return to the form so the user can run another job.
+		nm := initialModel(m.ui)
+		if m.job != nil {
+			nm = nm.withJob(m.job)
 		}
+		nm = m.handOver(nm)
+		return nm, nm.ui.blink()
 	}
 	// Forward other keys to the viewport (arrow keys, page-up/down, etc.).
 	var cmd tea.Cmd
@@ -432,6 +1020,20 @@ func (m model) View() string {
 		return m.viewDone()
 	case stateError:
 		return m.viewError()
+	case stateFormats:
+		return m.viewFormats()
+	case stateHistory:
+		return m.viewHistory()
+	case stateFiles:
+		return m.viewPicker()
+	case stateInspect:
+		return m.viewInspect()
+	case stateSetup:
+		return m.viewSetup()
+	case stateQueue:
+		return m.viewQueue()
+	case stateConfirm:
+		return m.viewConfirm()
 	}
 	return ""
 }
@@ -442,49 +1044,134 @@ func (m model) viewForm() string {
 
 	b.WriteString(titleStyle.Render("GM TUI — Batch Image Resize & Compress"))
 	b.WriteString("\n")
-	b.WriteString(subtitleStyle.Render("Powered by GraphicsMagick"))
+	subtitle := "Powered by GraphicsMagick"
+	if m.ui.safeMode {
+		subtitle += " · safe mode"
+	}
+	b.WriteString(subtitleStyle.Render(subtitle))
 	b.WriteString("\n\n")
 
-	// Show a warning banner if gm is not installed.
-	if !m.gmFound {
-		b.WriteString(warningStyle.Render("⚠  'gm' not found in PATH — install GraphicsMagick first"))
+	// Show a warning banner if gm is missing or the configured path is broken.
+	if m.gmErr != nil {
+		b.WriteString(warningStyle.Render("⚠  " + m.gmErr.Error()))
 		b.WriteString("\n")
-		b.WriteString(warningStyle.Render("   macOS: brew install graphicsmagick"))
+		switch {
+		case gm.Category(m.gmErr) == gm.FailGMVersion:
+			b.WriteString(warningStyle.Render("   check -gm-path, or run anyway with -gm-version any"))
+		case m.ui.gmPath == "" && os.Getenv(gm.PathEnv) == "":
+			b.WriteString(warningStyle.Render("   macOS: brew install graphicsmagick"))
+		default:
+			b.WriteString(warningStyle.Render("   check -gm-path / " + gm.PathEnv))
+		}
 		b.WriteString("\n\n")
 	}
 
+	b.WriteString(m.renderPresetSelector())
+	b.WriteString("\n")
 	b.WriteString(m.renderTextField(focusDir, "Base directory"))
 	b.WriteString("\n\n")
 	b.WriteString(m.renderTextField(focusResize, "Resize  (W×H)"))
 	b.WriteString("\n\n")
-	b.WriteString(m.renderTextField(focusQuality, "JPEG quality  (1–100)"))
+	b.WriteString(m.renderTextField(focusQuality, "JPEG quality  (1–100 or smart)"))
 	b.WriteString("\n\n")
+	b.WriteString(m.renderResizeModeSelector())
+	b.WriteString("\n")
+	b.WriteString(m.renderGravityGrid())
+	b.WriteString("\n")
 	b.WriteString(m.renderModeSelector())
 	b.WriteString("\n")
 	b.WriteString(m.renderScopeSelector())
 	b.WriteString("\n")
-	b.WriteString(helpStyle.Render("[Tab] next field   [↑↓] change option   [Enter] run   [Ctrl+C / q] quit"))
+	b.WriteString(m.renderResumeSelector())
+	b.WriteString("\n")
+	b.WriteString(m.renderBackupSelector())
+	b.WriteString("\n")
+	b.WriteString(m.renderInterlaceCheckbox())
+	b.WriteString("\n")
+	b.WriteString(m.renderAutoOrientCheckbox())
+	b.WriteString("\n")
+	b.WriteString(m.renderSharpenCheckbox())
+	b.WriteString("\n")
+	b.WriteString(m.renderTextField(inputAfter, "Only files modified after  (date, or days ago like 30d)"))
+	b.WriteString("\n\n")
+	b.WriteString(m.renderTextField(inputBefore, "Only files modified before"))
+	b.WriteString("\n\n")
+	b.WriteString(m.renderFormatSelector())
+	b.WriteString("\n")
+	b.WriteString(m.renderNamingSelector())
+	b.WriteString("\n")
+	if m.watermark.Enabled() {
+		b.WriteString(helpStyle.Render("Watermark: " + filepath.Base(m.watermark.Image) + " (from the job file)"))
+		b.WriteString("\n")
+	}
+	if len(m.steps) > 0 {
+		b.WriteString(helpStyle.Render("Steps: " + strings.Join(m.steps, " → ")))
+		b.WriteString("\n")
+	}
+	if m.targetSize > 0 {
+		b.WriteString(helpStyle.Render(fmt.Sprintf("Target size: %s per JPEG, quality %s down to %d (from the job file)",
+			humanize.Bytes(m.targetSize), m.inputs[focusQuality].Value(), cmp.Or(m.minQuality, gm.DefaultMinQuality))))
+		b.WriteString("\n")
+	}
+	if m.optimizePNG != "" {
+		b.WriteString(helpStyle.Render("PNG optimisation: " + m.optimizePNG + " (from the job file)"))
+		b.WriteString("\n")
+	}
+	if m.effort > 0 && m.outputFormat() != "" {
+		b.WriteString(helpStyle.Render(fmt.Sprintf("Encoding effort: %d of %d (from the job file)", m.effort, gm.MaxEffort)))
+		b.WriteString("\n")
+	}
+	if m.includesHEIC() {
+		b.WriteString(helpStyle.Render("Including HEIC/HEIF photos, saved as " + cmp.Or(strings.ToUpper(m.outputFormat()), "JPEG") + " (from the job file)"))
+		b.WriteString("\n")
+	}
+	if s := m.minimumSize(); s != "" {
+		b.WriteString(helpStyle.Render("Skipping files below " + s + " (from the job file)"))
+		b.WriteString("\n")
+	}
+	if m.files != nil {
+		b.WriteString(helpStyle.Render("Converting only the " + humanize.Count(len(m.files)) + " file(s) picked for the repeated run; [" + keyHelp(m.keys.Pick) + "] to pick again"))
+		b.WriteString("\n")
+	}
+	if m.watermark.Enabled() || len(m.steps) > 0 || m.targetSize > 0 || m.optimizePNG != "" ||
+		(m.effort > 0 && m.outputFormat() != "") || m.includesHEIC() || m.minimumSize() != "" || m.files != nil {
+		b.WriteString("\n")
+	}
+	k := m.keys
+	if q := m.queueSummary(); q != "" {
+		b.WriteString(warningStyle.Render(fmt.Sprintf("Queue: %s — [%s] shows it, [%s] queues this form's run", q, keyHelp(k.Queue), keyHelp(k.Run))))
+		b.WriteString("\n")
+	}
+	b.WriteString(helpStyle.Render(fmt.Sprintf("[%s] next field   [%s] change option   [%s] toggle   [%s] run   [%s] pick files   [%s] inspect   [%s] save job   [%s] formats   [%s] history   [%s] quit",
+		keyHelp(k.Next), keysHelp(k.Up, k.Down, k.Left, k.Right), keyHelp(k.Toggle), keyHelp(k.Run), keyHelp(k.Pick), keyHelp(k.Inspect), keyHelp(k.Save), keyHelp(k.Formats), keyHelp(k.History), keyHelp(k.ForceQuit, k.Quit))))
+	if m.status != "" {
+		b.WriteString("\n")
+		b.WriteString(subtitleStyle.Render(m.status))
+	}
 
 	return b.String()
 }
 
-// renderTextField renders a labelled text input, highlighting it when focused.
+// renderTextField renders a labelled text input, highlighting it when focused,
+// with what is wrong with its value underneath.  idx is the input's index in
+// m.inputs.
 func (m model) renderTextField(idx int, label string) string {
 	var b strings.Builder
+	focused := m.focus == inputFocus[idx]
 
-	lbl := labelStyle.Render(label)
-	if m.focus == idx {
-		lbl = focusedLabelStyle.Render(label)
-	}
-	b.WriteString(lbl)
+	b.WriteString(m.fieldTitle(inputFocus[idx], label))
 	b.WriteString("\n")
 
 	inp := m.inputs[idx].View()
-	if m.focus == idx {
+	if focused {
 		b.WriteString(focusedInputStyle.Render(inp))
 	} else {
 		b.WriteString(blurredInputStyle.Render(inp))
 	}
+	if msg := m.fieldError(idx); msg != "" {
+		b.WriteString("\n")
+		b.WriteString(errorStyle.Render(truncate("✗ "+msg, max(viewportWidth(m.width), 10))))
+	}
 
 	return b.String()
 }
@@ -495,12 +1182,17 @@ func (m model) renderTextField(idx int, label string) string {
 func (m model) renderSelector(focusIdx int, title string, labels []string, selected int) string {
 	var b strings.Builder
 
-	lbl := labelStyle.Render(title)
-	if m.focus == focusIdx {
-		lbl = focusedLabelStyle.Render(title)
-	}
-	b.WriteString(lbl)
+	b.WriteString(m.fieldTitle(focusIdx, title))
 	b.WriteString("\n")
+	b.WriteString(renderOptions(labels, selected, m.focus == focusIdx))
+
+	return b.String()
+}
+
+// renderOptions renders a selector's radio buttons, highlighted when the
+// selector has the focus.
+func renderOptions(labels []string, selected int, focused bool) string {
+	var b strings.Builder
 
 	for i, label := range labels {
 		radio := "○"
@@ -509,7 +1201,6 @@ func (m model) renderSelector(focusIdx int, title string, labels []string, selec
 		}
 		line := fmt.Sprintf("  %s  %s", radio, label)
 
-		focused := m.focus == focusIdx
 		isSelected := i == selected
 
 		switch {
@@ -528,6 +1219,64 @@ func (m model) renderSelector(focusIdx int, title string, labels []string, selec
 	return b.String()
 }
 
+// renderPresetSelector renders the presets, after "Custom" for the form as
+// the user fills it in.  The selected preset lists the fields changed since
+// it filled them in.
+func (m model) renderPresetSelector() string {
+	labels := []string{"Custom"}
+	for _, p := range m.presets {
+		labels = append(labels, p.Name)
+	}
+	if names := m.modifiedFields(); len(names) > 0 {
+		labels[m.preset] += "  (modified: " + strings.Join(names, ", ") + ")"
+	}
+	return m.renderSelector(focusPreset, "Preset", labels, m.preset)
+}
+
+// renderResizeModeSelector renders the resize mode (fit / fill / pad) radio
+// buttons.
+func (m model) renderResizeModeSelector() string {
+	return m.renderSelector(focusResizeMode, "Resize mode", resizeModeLabels, m.resizeMode)
+}
+
+// renderGravityGrid renders the gravity choice as a 3×3 compass, moved
+// through with the arrow keys.
+func (m model) renderGravityGrid() string {
+	var b strings.Builder
+
+	title := "Gravity  (fill and pad only)"
+	focused := m.focus == focusGravity
+	if focused {
+		b.WriteString(focusedLabelStyle.Render(title))
+	} else {
+		b.WriteString(labelStyle.Render(title))
+	}
+	b.WriteString("\n")
+
+	for row := 0; row < 3; row++ {
+		b.WriteString(" ")
+		for col := 0; col < 3; col++ {
+			i := row*3 + col
+			radio := "○"
+			if i == m.gravity {
+				radio = "●"
+			}
+			cell := fmt.Sprintf(" %s %-7s", radio, gravityLabels[i])
+			switch {
+			case focused && i == m.gravity:
+				b.WriteString(selectedModeStyle.Render(cell))
+			case i == m.gravity:
+				b.WriteString(lipgloss.NewStyle().Bold(true).Render(cell))
+			default:
+				b.WriteString(unselectedModeStyle.Render(cell))
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
 // renderModeSelector renders the output-mode radio buttons.
 func (m model) renderModeSelector() string {
 	return m.renderSelector(focusMode, "Output mode", modeLabels, m.outputMode)
@@ -538,39 +1287,154 @@ func (m model) renderScopeSelector() string {
 	return m.renderSelector(focusScope, "Scope", scopeLabels, m.scope)
 }
 
-// viewRunning renders the "processing" screen with a live spinner.
+// renderResumeSelector renders the resume (skip processed vs force) radio buttons.
+func (m model) renderResumeSelector() string {
+	return m.renderSelector(focusResume, "Already processed files", resumeLabels, m.resume)
+}
+
+// renderBackupSelector renders the backup radio buttons.
+func (m model) renderBackupSelector() string {
+	return m.renderSelector(focusBackup, "Backups  (overwrite mode only)", backupLabels, m.backup)
+}
+
+// renderFormatSelector renders the output format radio buttons.
+func (m model) renderFormatSelector() string {
+	return m.renderSelector(focusFormat, "Output format  (preserve mode only)", formatLabels, m.format)
+}
+
+// renderNamingSelector renders the output naming schemes, after the
+// original names.
+func (m model) renderNamingSelector() string {
+	labels := []string{"Original names"}
+	for _, n := range m.namings {
+		labels = append(labels, n.Name+"  →  "+n.Template)
+	}
+	return m.renderSelector(focusNaming, "Output names  (preserve mode only)", labels, m.namingIndex())
+}
+
+// renderCheckbox renders a labelled on/off option.  focusIdx is the focus
+// index that activates it.
+func (m model) renderCheckbox(focusIdx int, title, label string, checked bool) string {
+	var b strings.Builder
+
+	b.WriteString(m.fieldTitle(focusIdx, title))
+	b.WriteString("\n")
+
+	box := "[ ]"
+	if checked {
+		box = "[x]"
+	}
+	line := fmt.Sprintf("  %s  %s", box, label)
+	switch {
+	case m.focus == focusIdx:
+		b.WriteString(selectedModeStyle.Render(line))
+	case checked:
+		b.WriteString(lipgloss.NewStyle().Bold(true).Render(line))
+	default:
+		b.WriteString(unselectedModeStyle.Render(line))
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// renderInterlaceCheckbox renders the progressive JPEG checkbox.
+func (m model) renderInterlaceCheckbox() string {
+	return m.renderCheckbox(focusInterlace, "JPEG encoding",
+		"Progressive (interlaced) JPEGs for the web  →  gm -interlace", m.interlace)
+}
+
+// renderAutoOrientCheckbox renders the EXIF auto-orient checkbox.
+func (m model) renderAutoOrientCheckbox() string {
+	return m.renderCheckbox(focusAutoOrient, "Orientation",
+		"Rotate according to EXIF orientation  →  gm -auto-orient", m.autoOrient)
+}
+
+// renderSharpenCheckbox renders the post-resize sharpening checkbox.
+func (m model) renderSharpenCheckbox() string {
+	return m.renderCheckbox(focusSharpen, "Sharpening",
+		"Sharpen after resizing  →  gm -unsharp", m.sharpen)
+}
+
+// viewRunning renders the "processing" screen with a live spinner, or a
+// static status marker when motion is reduced.
 func (m model) viewRunning() string {
 	var b strings.Builder
 
 	b.WriteString(titleStyle.Render("Processing…"))
+	if m.power.Throttle() {
+		b.WriteString("  ")
+		b.WriteString(warningStyle.Render("[throttled: " + m.power.Reason() + "]"))
+	}
 	b.WriteString("\n\n")
-	b.WriteString(m.spinner.View())
+	if m.ui.reducedMotion {
+		b.WriteString(m.spinner.Style.Render("●"))
+	} else {
+		b.WriteString(m.spinner.View())
+	}
 	b.WriteString("  ")
-	b.WriteString(subtitleStyle.Render("Running GraphicsMagick — please wait…"))
+	b.WriteString(subtitleStyle.Render(truncate(paceLine(m.pace), max(viewportWidth(m.width)-3, 10))))
+	if n := m.waiting(); n > 0 {
+		b.WriteString("\n")
+		b.WriteString(helpStyle.Render(fmt.Sprintf("   %s more run(s) waiting", humanize.Count(n))))
+	}
 	b.WriteString("\n\n")
-	b.WriteString(helpStyle.Render("[q / Ctrl+C] cancel"))
+	if len(m.liveLines) > 0 {
+		b.WriteString(m.live.View())
+		b.WriteString("\n")
+		b.WriteString(helpStyle.Render(scrollHint(m.live)))
+		b.WriteString("\n")
+	}
+	k := m.keys
+	b.WriteString(helpStyle.Render(fmt.Sprintf("[%s] back to the form   [%s] queue   [%s] cancel",
+		keyHelp(k.Back), keyHelp(k.Queue), keyHelp(k.Quit, k.ForceQuit))))
+	if m.status != "" {
+		b.WriteString("\n")
+		b.WriteString(subtitleStyle.Render(m.status))
+	}
 
 	return b.String()
 }
 
+// paceLine says how far a run has got, how fast it goes and when it should
+// be done, once its first file has been dealt with:
+// "12 of 3,482 files · 2.4 files/s · 8.1 MB/s · about 24 min 10 s left".
+func paceLine(p gm.Progress) string {
+	if p.Total == 0 {
+		return "Running GraphicsMagick — please wait…"
+	}
+	parts := []string{fmt.Sprintf("%s of %s files", humanize.Count(p.Done), humanize.Count(p.Total))}
+	if p.Attempted > 0 {
+		parts = append(parts, humanize.Number(p.FilesPerSecond())+" files/s",
+			humanize.Bytes(int64(p.BytesPerSecond()))+"/s")
+	}
+	if d, ok := p.Remaining(); ok && p.Done < p.Total {
+		parts = append(parts, "about "+humanize.Duration(max(d, time.Second).Round(time.Second))+" left")
+	}
+	return strings.Join(parts, " · ")
+}
+
 // viewDone renders the success screen with scrollable command output.
 func (m model) viewDone() string {
 	var b strings.Builder
 
 	b.WriteString(successStyle.Render("✓  Done!"))
 	b.WriteString("\n")
-	b.WriteString(subtitleStyle.Render("All files processed successfully."))
+	b.WriteString(subtitleStyle.Render(m.result.Summary()))
 	b.WriteString("\n\n")
+	if s := m.result.Breakdown(); s != "" {
+		b.WriteString(helpStyle.Render(s))
+		b.WriteString("\n\n")
+	}
 
-	if m.vpReady {
-		b.WriteString(m.viewport.View())
-		b.WriteString("\n")
-		b.WriteString(helpStyle.Render(scrollHint(m.viewport)))
+	b.WriteString(m.renderOutput())
+
+	b.WriteString(helpStyle.Render(m.doneHelp("run again")))
+	if m.status != "" {
 		b.WriteString("\n")
+		b.WriteString(subtitleStyle.Render(m.status))
 	}
 
-	b.WriteString(helpStyle.Render("[r] run again   [Enter / q] quit"))
-
 	return b.String()
 }
 
@@ -584,19 +1448,87 @@ func (m model) viewError() string {
 		b.WriteString(subtitleStyle.Render(m.result.Err.Error()))
 	}
 	b.WriteString("\n\n")
+	if s := m.result.Breakdown(); s != "" {
+		b.WriteString(helpStyle.Render(s))
+		b.WriteString("\n\n")
+	}
+	if s := m.renderSuggestions(); s != "" {
+		b.WriteString(s)
+		b.WriteString("\n\n")
+	}
 
-	if m.vpReady {
-		b.WriteString(m.viewport.View())
-		b.WriteString("\n")
-		b.WriteString(helpStyle.Render(scrollHint(m.viewport)))
+	b.WriteString(m.renderOutput())
+
+	b.WriteString(helpStyle.Render(m.doneHelp("try again")))
+	if m.status != "" {
 		b.WriteString("\n")
+		b.WriteString(subtitleStyle.Render(m.status))
 	}
 
-	b.WriteString(helpStyle.Render("[r] try again   [Enter / q] quit"))
+	return b.String()
+}
 
+// doneHelp is the key help under the done and error screens; again says
+// what going back to the form is for.
+func (m model) doneHelp(again string) string {
+	k := m.keys
+	retry := ""
+	if n := len(m.result.Errors); n > 0 {
+		retry = fmt.Sprintf("[%s] retry %s failed   ", keyHelp(k.Retry), humanize.Count(n))
+	}
+	export := exportNames[cmp.Or(m.exportFormat, config.DefaultReportFormat)]
+	if len(m.queue) > 1 {
+		return fmt.Sprintf("%s[%s] %s   [%s] export %s   [%s] history   [%s] queue   [%s] quit",
+			retry, keyHelp(k.Again), again, keyHelp(k.Export), export, keyHelp(k.History), keyHelp(k.Back, k.Queue), keyHelp(k.Run, k.Quit))
+	}
+	return fmt.Sprintf("%s[%s] %s   [%s] export %s   [%s] history   [%s] quit",
+		retry, keyHelp(k.Again), again, keyHelp(k.Export), export, keyHelp(k.History), keyHelp(k.Run, k.Quit))
+}
+
+// renderOutput renders the result viewport with its scroll hint, or with
+// -pager a note saying where the output went instead.
+func (m model) renderOutput() string {
+	if m.ui.pager {
+		return helpStyle.Render("The full output opens in your pager when you quit.") + "\n\n"
+	}
+	if !m.vpReady {
+		return ""
+	}
+	return m.viewport.View() + "\n" + helpStyle.Render(scrollHint(m.viewport)) + "\n"
+}
+
+// renderSuggestions lists gm.Suggest's advice for the failed run, wrapped to
+// the viewport width, or returns "" when there is none.
+func (m model) renderSuggestions() string {
+	tips := gm.Suggest(m.result)
+	if len(tips) == 0 {
+		return ""
+	}
+	wrap := lipgloss.NewStyle().Width(viewportWidth(m.width) - 2)
+	var b strings.Builder
+	b.WriteString(warningStyle.Render("What to try:"))
+	for _, t := range tips {
+		b.WriteString("\n")
+		b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, "• ", wrap.Render(t)))
+	}
 	return b.String()
 }
 
+// resultViewportHeight is viewportHeight minus the lines taken by
+// suggestions on the error screen.
+func (m model) resultViewportHeight() int {
+	h := viewportHeight(m.height)
+	if s := m.result.Breakdown(); s != "" {
+		h -= lipgloss.Height(s) + 1
+	}
+	if m.state == stateError {
+		if s := m.renderSuggestions(); s != "" {
+			h -= lipgloss.Height(s) + 1
+		}
+	}
+	return max(h, 3)
+}
+
 // scrollHint returns a "X% scrolled" hint when the viewport has overflow.
 func scrollHint(vp viewport.Model) string {
 	if vp.AtBottom() && vp.AtTop() {
@@ -622,19 +1554,284 @@ func (m model) buildOptions() gm.Options {
 		resize = "1200x1200"
 	}
 
-	quality, err := strconv.Atoi(strings.TrimSpace(m.inputs[focusQuality].Value()))
-	if err != nil || quality < 1 || quality > 100 {
+	// Smart quality tries up to SmartMaxQuality, or to the quality of the
+	// job file the form was loaded from.
+	q := strings.TrimSpace(m.inputs[focusQuality].Value())
+	smart := strings.EqualFold(q, gm.QualitySmart)
+	quality, err := strconv.Atoi(q)
+	switch {
+	case smart && m.job != nil && m.job.SmartQuality && m.job.Quality != 0:
+		quality = m.job.Quality
+	case smart:
+		quality = gm.SmartMaxQuality
+	case err != nil || quality < 1 || quality > 100:
 		quality = 80
 	}
 
+	// Checkboxes pick the default parameters, unless the job file the form
+	// was loaded from asked for others.
+	interlace := ""
+	if m.interlace {
+		interlace = gm.InterlaceLine
+		if m.job != nil {
+			if il, _ := gm.ParseInterlace(m.job.Interlace); il != "" {
+				interlace = il
+			}
+		}
+	}
+	sharpen := ""
+	if m.sharpen {
+		sharpen = gm.DefaultSharpen
+		if m.job != nil {
+			if sh, _ := gm.ParseSharpen(m.job.Sharpen); sh != "" {
+				sharpen = sh
+			}
+		}
+	}
+
+	// Dates that do not parse are reported by validateForm.
+	now := clock()
+	after, _ := gm.ParseDate(m.inputs[inputAfter].Value(), now)
+	before, _ := gm.ParseDate(m.inputs[inputBefore].Value(), now)
+
+	// Watermark steps name images relative to the directory, or with ~ and
+	// $VARS.
+	steps, _ := gm.ParseSteps(m.steps) // checked by config.Load and job.Validate
+	for i, s := range steps {
+		if s.Op == gm.StepWatermark && s.Value != "" {
+			steps[i].Value = expandHome(os.ExpandEnv(s.Value))
+		}
+	}
+
+	// Gravity only matters when the image is cropped or padded.
+	gravity := ""
+	if resizeModes[m.resizeMode] != gm.ResizeFit && m.gravity != gravityCenter {
+		gravity = gm.Gravities[m.gravity]
+	}
+
 	return gm.Options{
-		Dir:       dir,
-		Patterns:  []string{"*.jpg", "*.jpeg", "*.png"},
-		Resize:    resize,
-		Quality:   quality,
-		Overwrite: m.outputMode == modeOverwrite,
-		Recursive: m.scope == scopeRecursive,
+		Dir:               dir,
+		Patterns:          gm.DefaultPatterns,
+		Resize:            resize,
+		ResizeMode:        resizeModes[m.resizeMode],
+		Gravity:           gravity,
+		Quality:           quality,
+		SmartQuality:      smart,
+		TargetSize:        m.targetSize,
+		MinQuality:        m.minQuality,
+		OptimizePNG:       m.optimizePNG,
+		OutputFormat:      m.outputFormat(),
+		Effort:            m.effort,
+		HEIC:              m.includesHEIC(),
+		AnimatedGIF:       m.animatedGIF,
+		Report:            m.report,
+		ReportFormat:      m.reportFormat,
+		Baseline:          m.baseline,
+		BaselineTolerance: m.tolerance,
+		Interlace:         interlace,
+		AutoOrient:        m.autoOrient,
+		Sharpen:           sharpen,
+		Watermark:         m.watermark,
+		Steps:             steps,
+		Overwrite:         m.outputMode == modeOverwrite,
+		NameTemplate:      m.nameTemplate(),
+		Recursive:         m.scope == scopeRecursive,
+		FollowSymlinks:    m.symlinks,
+		Workers:           m.workers,
+		PerDirectory:      m.perDirectory,
+		MinFileSize:       m.minSize,
+		MinWidth:          m.minWidth,
+		MinHeight:         m.minHeight,
+		PowerAware:        m.powerAware,
+		ModifiedAfter:     after,
+		ModifiedBefore:    before,
+		Files:             m.files,
+		Force:             m.resume == resumeForce,
+		Backup:            m.backup == backupOn,
+		GMPath:            m.ui.gmPath,
+		GMVersion:         cmp.Or(m.ui.gmVersion, m.gmVersion),
+	}
+}
+
+// nameTemplate returns the job's output name template when it applies, i.e.
+// in preserve mode.
+func (m model) nameTemplate() string {
+	if m.outputMode == modeOverwrite {
+		return ""
+	}
+	return m.nameTmpl
+}
+
+// outputFormat returns the chosen output format when it applies, i.e. in
+// preserve mode.
+func (m model) outputFormat() string {
+	if m.outputMode == modeOverwrite {
+		return ""
+	}
+	return outputFormats[m.format]
+}
+
+// includesHEIC reports whether the job's HEIC photos are converted, which
+// only happens in preserve mode.
+func (m model) includesHEIC() bool {
+	return m.heic && m.outputMode != modeOverwrite
+}
+
+// minimumSize describes the job's minimum file size and dimensions, e.g.
+// "500 kB or 2000 px wide", or returns "" when there are none.
+func (m model) minimumSize() string {
+	var parts []string
+	if m.minSize > 0 {
+		parts = append(parts, humanize.Bytes(m.minSize))
+	}
+	if d := describeDimensions(m.minWidth, m.minHeight); d != "" {
+		parts = append(parts, d)
+	}
+	return strings.Join(parts, " or ")
+}
+
+// describeDimensions renders a minimum width and height for people:
+// "2000 px wide", "1000 px high" or "2000×1000 px".
+func describeDimensions(width, height int) string {
+	switch {
+	case width > 0 && height > 0:
+		return fmt.Sprintf("%d×%d px", width, height)
+	case width > 0:
+		return fmt.Sprintf("%d px wide", width)
+	case height > 0:
+		return fmt.Sprintf("%d px high", height)
+	}
+	return ""
+}
+
+// validateForm checks the form before it is run or saved.  Empty fields are
+// fine (they fall back to defaults) but anything typed must be valid.  In
+// safe mode the form must not ask for what job.Job.Destructive lists.
+func (m model) validateForm() error {
+	for _, f := range []struct {
+		idx  int
+		name string
+	}{{focusResize, "resize"}, {focusQuality, "quality"}, {inputAfter, "modified after"}, {inputBefore, "modified before"}} {
+		if msg := m.fieldError(f.idx); msg != "" {
+			return fmt.Errorf("%s: %s", f.name, msg)
+		}
+	}
+	if m.ui.safeMode {
+		if what := m.formJob().Destructive(); len(what) > 0 {
+			return fmt.Errorf("safe mode forbids %s", strings.Join(what, ", "))
+		}
+	}
+	return m.buildOptions().Validate()
+}
+
+// fieldError returns what is wrong with text input idx as typed, or "" when
+// it is fine.  The form shows it under the field while typing, since
+// buildOptions would otherwise quietly run with the default instead.
+// Empty fields take their defaults and are fine.
+func (m model) fieldError(idx int) string {
+	v := strings.TrimSpace(m.inputs[idx].Value())
+	if v == "" {
+		return ""
+	}
+	switch idx {
+	case focusResize:
+		g, err := geometry.Parse(v)
+		if err != nil {
+			return err.Error()
+		}
+		if mode := resizeModes[m.resizeMode]; mode != gm.ResizeFit && (g.Width == 0 || g.Height == 0 || g.Percent || g.Area) {
+			return fmt.Sprintf("%s mode needs a width and a height in pixels, e.g. 400x400", mode)
+		}
+	case focusQuality:
+		if n, err := strconv.Atoi(v); (err != nil || n < 1 || n > 100) && !strings.EqualFold(v, gm.QualitySmart) {
+			return fmt.Sprintf("must be a whole number from 1 to 100 or %s, got %q", gm.QualitySmart, v)
+		}
+	case inputAfter, inputBefore:
+		if _, err := gm.ParseDate(v, clock()); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// formJob converts the current form into a job.  When the form was opened
+// from a job file, that job's name, hooks, notifications, S3 upload prefix,
+// timeout, lossless mode, exec command, colour reduction, alpha dropping,
+// sRGB conversion, the tone, rotation and flips, chroma subsampling, disk
+// space check, fallback, verification, the PSNR check, conflict policy,
+// linking of skipped files, continuing on errors, the hash cache, the
+// trash, upscaling, the pad background and sampling are carried over.
+// Dates are kept as typed, so that "30d" stays relative to the day the job
+// runs.
+func (m model) formJob() *job.Job {
+	opts := m.buildOptions()
+	j := job.FromOptions(filepath.Base(opts.Dir), opts)
+	j.ModifiedAfter = strings.TrimSpace(m.inputs[inputAfter].Value())
+	j.ModifiedBefore = strings.TrimSpace(m.inputs[inputBefore].Value())
+	if m.job != nil {
+		j.Name, j.Hooks, j.Notify = m.job.Name, m.job.Hooks, m.job.Notify
+		j.Upload, j.Timeout = m.job.Upload, m.job.Timeout
+		j.Lossless, j.DropAlpha = m.job.Lossless, m.job.DropAlpha
+		j.Exec = m.job.Exec
+		j.ConvertToSRGB, j.Tone = m.job.ConvertToSRGB, m.job.Tone
+		j.Rotate, j.Flip, j.Flop = m.job.Rotate, m.job.Flip, m.job.Flop
+		j.Subsampling = m.job.Subsampling
+		j.MaxColors, j.Dither = m.job.MaxColors, m.job.Dither
+		j.IgnoreDiskSpace, j.Fallback = m.job.IgnoreDiskSpace, m.job.Fallback
+		j.Verify, j.OnConflict = m.job.Verify, m.job.OnConflict
+		j.MinPSNR = m.job.MinPSNR
+		j.LinkSkipped = m.job.LinkSkipped
+		j.StopOnError, j.HashCache = m.job.StopOnError, m.job.HashCache
+		j.Trash, j.Upscale = m.job.Trash, m.job.Upscale
+		j.Background = m.job.Background
+		j.SampleSize, j.Seed = m.job.SampleSize, m.job.Seed
+	}
+	return j
+}
+
+// saveJob writes the current form to a job file and returns a status line.
+// Jobs opened with "imageslim edit" are saved back to their file; otherwise
+// the job is written next to the images as imageslim-job.yaml.
+func (m model) saveJob() string {
+	if err := m.validateForm(); err != nil {
+		return "✗ " + err.Error()
 	}
+	j := m.formJob()
+	j.GMVersion = m.gmVersion // the job's pin, not a -gm-version for this session
+	path := filepath.Join(m.buildOptions().Dir, "imageslim-job.yaml")
+	if m.job != nil && m.job.Path() != "" {
+		path = m.job.Path()
+	}
+	if err := j.Save(path); err != nil {
+		return "✗ " + err.Error()
+	}
+	return "✓ Saved job to " + path
+}
+
+// exportNames names the report formats in the done screen's help.
+var exportNames = map[string]string{
+	gm.ReportCSV:      "CSV",
+	gm.ReportTSV:      "TSV",
+	gm.ReportJSON:     "JSON",
+	gm.ReportMarkdown: "Markdown",
+	gm.ReportHTML:     "HTML",
+	gm.ReportJUnit:    "JUnit",
+}
+
+// exportReport writes the finished run's per-file report in the image
+// directory, in the format the config file picks, and returns a status
+// message for the done or error screen.
+func (m model) exportReport() string {
+	if len(m.result.Files) == 0 {
+		return "✗ No files to report"
+	}
+	format := cmp.Or(m.exportFormat, config.DefaultReportFormat)
+	name := "imageslim-report-" + clock().Format("20060102-150405") + gm.ReportExt(format)
+	path := filepath.Join(m.buildOptions().Dir, name)
+	if err := gm.ExportReport(path, format, m.result.Files); err != nil {
+		return "✗ " + err.Error()
+	}
+	return "✓ Saved report to " + path
 }
 
 // expandHome replaces a leading "~/" with the user's actual home directory.
@@ -648,10 +1845,129 @@ func expandHome(path string) string {
 }
 
 // runCmd returns a Bubble Tea command that executes gm.Run in a goroutine and
-// sends the result back to the Update loop as a resultMsg.
-func runCmd(opts gm.Options) tea.Cmd {
+// sends the result back to the Update loop as a resultMsg.  What gm prints
+// goes to out as it comes, for waitOutputCmd; out is closed at the end.
+func runCmd(opts gm.Options, out chan<- runUpdate) tea.Cmd {
 	return func() tea.Msg {
-		return resultMsg(gm.Run(opts))
+		defer close(out)
+		var r gm.Result
+		ok := tuiRuns.do(func(ctx context.Context) {
+			opts.Output, opts.Progress = sendOutput(ctx, out), sendProgress(ctx, out)
+			started := time.Now()
+			r = gm.Run(ctx, opts)
+			recordRun("tui", "", opts, started, time.Since(started), r, r.Err)
+		})
+		if !ok {
+			return nil // the TUI is exiting
+		}
+		return resultMsg(r)
+	}
+}
+
+// checkPowerCmd returns a Bubble Tea command that reads the power state
+// after delay.  When the platform has no power figures nothing is sent, which
+// ends the polling.
+func checkPowerCmd(delay time.Duration) tea.Cmd {
+	read := func(time.Time) tea.Msg {
+		p, err := sysload.ReadPower()
+		if err != nil {
+			return nil
+		}
+		return powerMsg(p)
+	}
+	if delay == 0 {
+		return func() tea.Msg { return read(time.Time{}) }
+	}
+	return tea.Tick(delay, read)
+}
+
+// sendOutput returns an Options.Output that sends each line to out, giving
+// up once ctx is done: the TUI may have stopped listening.
+func sendOutput(ctx context.Context, out chan<- runUpdate) func(string) {
+	return func(line string) {
+		select {
+		case out <- runUpdate{line: line}:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// sendProgress is like sendOutput for Options.Progress.
+func sendProgress(ctx context.Context, out chan<- runUpdate) func(gm.Progress) {
+	return func(p gm.Progress) {
+		select {
+		case out <- runUpdate{progress: &p}:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// outputInterval is how long an outputMsg gathers updates: a run that
+// converts thousands of small files a second redraws the running screen at
+// most ten times a second rather than for every line, which would keep the
+// Update loop busy rendering instead of the run converting.
+const outputInterval = 100 * time.Millisecond
+
+// outputBuffer is how many updates a run sends before it waits for the
+// running screen to take them, enough for the lines of an outputInterval.
+const outputBuffer = 1000
+
+// waitOutputCmd returns a Bubble Tea command that waits for the next
+// update of run on ch and sends it, together with the others that arrive
+// within outputInterval, as an outputMsg: the lines, and the latest
+// progress.  Once ch is closed nothing more is sent.
+func waitOutputCmd(run int, ch <-chan runUpdate) tea.Cmd {
+	return func() tea.Msg {
+		u, ok := <-ch
+		if !ok {
+			return nil
+		}
+		msg := outputMsg{run: run, more: ch}
+		done := time.NewTimer(outputInterval)
+		defer done.Stop()
+		for {
+			if u.progress != nil {
+				msg.progress = u.progress
+			} else {
+				msg.lines = append(msg.lines, u.line)
+			}
+			select {
+			case u, ok = <-ch:
+				if !ok {
+					msg.more = nil
+					return msg
+				}
+			case <-done.C:
+				return msg
+			}
+		}
+	}
+}
+
+// runJobCmd is like runCmd but runs a whole job, hooks and notifications
+// included.  Hook output is prepended to the gm output so it shows up in the
+// result viewport.
+func runJobCmd(j *job.Job, out chan<- runUpdate) tea.Cmd {
+	return func() tea.Msg {
+		defer close(out)
+		var log bytes.Buffer
+		var o job.Outcome
+		ok := tuiRuns.do(func(ctx context.Context) {
+			j := *j
+			j.Output, j.Progress = sendOutput(ctx, out), sendProgress(ctx, out)
+			o = job.Run(ctx, &j, &log)
+			recordRun("tui", j.Name, j.Options(), o.Started, o.Duration, o.Result, o.Err)
+		})
+		if !ok {
+			return nil // the TUI is exiting
+		}
+		r := o.Result
+		if r.Command == "" {
+			r.Command = fmt.Sprintf("(in %s)\n%s", j.ResolveDir(), j.Label())
+		}
+		r.Log = append(gm.TextLog(log.String(), gm.LogStdout, o.Started), r.Log...)
+		r.Err = o.Err
+		return resultMsg(r)
 	}
 }
 
@@ -662,8 +1978,18 @@ func buildOutputContent(result gm.Result) string {
 	b.WriteString(cmdStyle.Render(result.Command))
 	b.WriteString("\n\n")
 
-	if strings.TrimSpace(result.Output) != "" {
-		b.WriteString(result.Output)
+	if strings.TrimSpace(result.Output()) != "" {
+		for _, e := range result.Log {
+			switch e.Level {
+			case gm.LevelError:
+				b.WriteString(errorStyle.Render(e.Text))
+			case gm.LevelWarning:
+				b.WriteString(warningStyle.Render(e.Text))
+			default:
+				b.WriteString(e.Text)
+			}
+			b.WriteByte('\n')
+		}
 	} else {
 		b.WriteString(subtitleStyle.Render("(no output)"))
 	}
@@ -696,10 +2022,51 @@ func viewportHeight(termHeight int) int {
 // ---------------------------------------------------------------------------
 
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		os.Exit(runSubcommand(args))
+	}
+	os.Exit(cmdTUI(args))
+}
+
+// runTUI runs the Bubble Tea program starting from m and returns the process
+// exit code.  With -record the session is captured to a trace file.
+func runTUI(m model) int {
+	var root tea.Model = m
+	if m.ui.record != "" {
+		t, err := newTraceWriter(m.ui.record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+			return 1
+		}
+		defer func() {
+			if err := t.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "imageslim: recording: %v\n", err)
+			}
+		}()
+		root = newRecorder(m, t)
+	}
+
 	// tea.WithAltScreen() takes over the full terminal and restores it on exit.
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	p := tea.NewProgram(root, tea.WithAltScreen())
+	final, err := p.Run()
+	tuiRuns.stop() // runs still going on end with the TUI
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error running gm-tui: %v\n", err)
-		os.Exit(1)
+		return 1
+	}
+	// The alternate screen is gone with everything the TUI showed; leave
+	// the numbers in the scrollback.
+	if r, ok := final.(recorder); ok {
+		final = r.model
+	}
+	if m, ok := final.(model); ok {
+		if text := m.pagerText(); m.ui.pager && text != "" {
+			page(text)
+		}
+		if s := m.sessionSummary(); s != "" {
+			fmt.Println("ImageSlim: " + s)
+		}
 	}
+	return 0
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+	"github.com/brunovpinheiro/ImageSlim/internal/metrics"
+)
+
+// recordMetrics appends a usage record for a finished run when the user
+// opted in to local metrics.  command says how ImageSlim was used (tui,
+// plain, run or batch).  Failing to record never affects the run itself.
+func recordMetrics(command string, opts gm.Options, started time.Time, d time.Duration, r gm.Result, err error) {
+	if !metrics.Enabled() {
+		return
+	}
+	rec := metrics.Record{
+		Time:      started.UTC(),
+		Command:   command,
+		Duration:  d.Milliseconds(),
+		Processed: r.Processed,
+		Skipped:   r.Skipped,
+		BytesIn:   r.BytesIn,
+		BytesOut:  r.BytesOut,
+		Status:    "ok",
+	}
+	if _, version, cerr := gm.Check(opts); cerr == nil {
+		rec.Backend = metrics.Backend(version)
+	}
+	if err != nil {
+		rec.Status = "failed"
+		rec.Failure = gm.Category(err)
+	}
+	_ = metrics.Add(rec)
+}
+
+// registerSince adds the -since flag of "metrics show" to fs.
+func registerSince(fs *flag.FlagSet, p *time.Duration) {
+	fs.DurationVar(p, "since", 0, "only include runs from the last `duration`, e.g. 720h")
+}
+
+// cmdMetrics manages and shows the opt-in local usage statistics.
+func cmdMetrics(args []string) int {
+	if len(args) == 0 {
+		args = []string{"show"}
+	}
+	switch args[0] {
+	case "show":
+		var since time.Duration
+		fs := newFlagSet("metrics show", func(fs *flag.FlagSet) { registerSince(fs, &since) })
+		if err := fs.Parse(args[1:]); err != nil {
+			return flagExit(err)
+		}
+		records, err := metrics.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+			return 1
+		}
+		if since > 0 {
+			cutoff := time.Now().Add(-since)
+			kept := records[:0]
+			for _, r := range records {
+				if r.Time.After(cutoff) {
+					kept = append(kept, r)
+				}
+			}
+			records = kept
+		}
+		metrics.WriteSummary(os.Stdout, records)
+		return 0
+
+	case "enable":
+		p, err := metrics.Enable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+			return 1
+		}
+		fmt.Printf("✓ Recording usage statistics to %s\n  Nothing leaves this machine; run \"imageslim metrics disable\" to stop.\n", p)
+		return 0
+
+	case "disable", "reset":
+		if args[0] == "reset" && !metrics.Enabled() {
+			fmt.Fprintln(os.Stderr, "imageslim: metrics are not enabled (run: imageslim metrics enable)")
+			return 1
+		}
+		p, err := metrics.Disable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+			return 1
+		}
+		if args[0] == "reset" {
+			if _, err := metrics.Enable(); err != nil {
+				fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+				return 1
+			}
+			fmt.Printf("✓ Cleared %s\n", p)
+			return 0
+		}
+		fmt.Printf("✓ Stopped recording and deleted %s\n", p)
+		return 0
+
+	case "path":
+		p, err := metrics.Path()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+			return 1
+		}
+		fmt.Println(p)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "imageslim: unknown metrics command %q\n\n%s", args[0], usageText)
+	return 2
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// -pager: the results handed to $PAGER on exit, for its search and
+// navigation, and for output too long to scroll through on the done screen
+// ---------------------------------------------------------------------------
+
+// defaultPager is run when $PAGER is not set.
+const defaultPager = "less"
+
+// pagerText returns the results of the session's finished runs as plain
+// text: for each, its summary or error, the breakdown, the command and
+// everything gm printed, then every failed file, not just those Breakdown
+// names.  It is "" when no run has finished.
+func (m model) pagerText() string {
+	var done []queuedRun
+	for _, r := range m.queue {
+		if r.done {
+			done = append(done, r)
+		}
+	}
+	var b strings.Builder
+	for i, r := range done {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if len(done) > 1 {
+			fmt.Fprintf(&b, "== %s ==\n", r.label)
+		}
+		res := r.result
+		if res.Err != nil {
+			fmt.Fprintf(&b, "✗ %v\n", res.Err)
+		} else {
+			fmt.Fprintf(&b, "✓ %s\n", res.Summary())
+		}
+		if s := res.Breakdown(); s != "" {
+			b.WriteString(s + "\n")
+		}
+		b.WriteString("\n" + res.Command + "\n\n")
+		if out := strings.TrimSpace(res.Output()); out != "" {
+			b.WriteString(out + "\n")
+		} else {
+			b.WriteString("(no output)\n")
+		}
+		if len(res.Errors) > 0 {
+			fmt.Fprintf(&b, "\nFailed files (%d):\n", len(res.Errors))
+			for _, e := range res.Errors {
+				fmt.Fprintf(&b, "  %s: %v\n", e.Path, e.Err)
+			}
+		}
+	}
+	return b.String()
+}
+
+// page shows text in $PAGER, or less.  The pager runs through the shell, so
+// that a $PAGER such as "less -S" keeps its flags.  When stdout is not a
+// terminal, or the pager cannot be run, text is printed instead.  A pager
+// that ran and failed, say one quit with an interrupt, has shown it.
+func page(text string) {
+	if fi, err := os.Stdout.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		fmt.Print(text)
+		return
+	}
+	cmd := exec.Command("sh", "-c", cmp.Or(os.Getenv("PAGER"), defaultPager))
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if err == nil || errors.As(err, &exit) && exit.ExitCode() != 127 { // 127: not found
+		return
+	}
+	fmt.Fprintf(os.Stderr, "imageslim: pager %s could not be run\n", cmp.Or(os.Getenv("PAGER"), defaultPager))
+	fmt.Print(text)
+}
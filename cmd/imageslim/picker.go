@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+)
+
+// ---------------------------------------------------------------------------
+// File list: pick which of the matching files to convert
+// ---------------------------------------------------------------------------
+
+// pickFile is one file on the file list.
+type pickFile struct {
+	Path string `json:"path"` // relative to the base directory
+	Size int64  `json:"size"`
+}
+
+// filesMsg carries the files the form's settings match back to the Update
+// loop, with the free space on the disk output/ goes to in preserve mode.
+type filesMsg struct {
+	Files []pickFile `json:"files,omitempty"`
+	Free  int64      `json:"free,omitempty"`
+	Err   string     `json:"error,omitempty"`
+}
+
+// scanFilesCmd returns a Bubble Tea command that lists the files a run with
+// opts would look at.
+func scanFilesCmd(opts gm.Options) tea.Cmd {
+	return func() tea.Msg {
+		files, err := scanFiles(opts)
+		if err != nil {
+			return filesMsg{Err: err.Error()}
+		}
+		msg := filesMsg{Files: files}
+		if !opts.Overwrite {
+			msg.Free, _ = gm.FreeSpace(filepath.Join(opts.Dir, gm.OutputDir))
+		}
+		return msg
+	}
+}
+
+// scanFiles lists the files a run with opts would look at, with their sizes.
+func scanFiles(opts gm.Options) ([]pickFile, error) {
+	paths, err := gm.Scan(opts)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]pickFile, len(paths))
+	for i, rel := range paths {
+		files[i].Path = rel
+		if fi, err := os.Stat(filepath.Join(opts.Dir, rel)); err == nil {
+			files[i].Size = fi.Size()
+		}
+	}
+	return files, nil
+}
+
+// openPicker validates the form and switches to the file list, with every
+// matching file selected.
+func (m model) openPicker() (tea.Model, tea.Cmd) {
+	if err := m.validateForm(); err != nil {
+		m.status = "✗ " + err.Error()
+		return m, nil
+	}
+	m.status = ""
+	m.state = stateFiles
+	m.picks, m.picked, m.pickCursor = nil, nil, 0
+	opts := m.buildOptions()
+	opts.Files = nil
+	return m, scanFilesCmd(opts)
+}
+
+// updatePicker handles key events on the file list.
+func (m model) updatePicker(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var files []pickFile
+	if m.picks != nil {
+		files = m.picks.Files
+	}
+	page := pickRows(m.height)
+	m.status = ""
+	k := m.keys
+	switch {
+	case key.Matches(msg, k.Back, k.Quit):
+		m.state, m.status = stateForm, ""
+	case key.Matches(msg, k.Up):
+		m.pickCursor = max(m.pickCursor-1, 0)
+	case key.Matches(msg, k.Down):
+		m.pickCursor = max(min(m.pickCursor+1, len(files)-1), 0)
+	case key.Matches(msg, k.PageUp):
+		m.pickCursor = max(m.pickCursor-page, 0)
+	case key.Matches(msg, k.PageDown):
+		m.pickCursor = max(min(m.pickCursor+page, len(files)-1), 0)
+	case key.Matches(msg, k.Toggle):
+		if m.pickCursor < len(m.picked) {
+			m.picked[m.pickCursor] = !m.picked[m.pickCursor]
+		}
+	case key.Matches(msg, k.Run):
+		return m.runPicked()
+	case key.Matches(msg, k.All):
+		// Select everything, or nothing when everything already is.
+		all := m.countPicked() < len(m.picked)
+		for i := range m.picked {
+			m.picked[i] = all
+		}
+	}
+	return m, nil
+}
+
+// runPicked starts the run with the selected files.  With every file
+// selected the run is not limited, as if started from the form.
+func (m model) runPicked() (tea.Model, tea.Cmd) {
+	if m.picks == nil || len(m.picks.Files) == 0 {
+		return m, nil
+	}
+	n := m.countPicked()
+	if n == 0 {
+		m.status = "✗ Select at least one file"
+		return m, nil
+	}
+	m.files = nil
+	if n < len(m.picked) {
+		m.files = make([]string, 0, n)
+		for i, f := range m.picks.Files {
+			if m.picked[i] {
+				m.files = append(m.files, f.Path)
+			}
+		}
+	}
+	return m.startRun()
+}
+
+// countPicked returns how many files on the list are selected.
+func (m model) countPicked() int {
+	n := 0
+	for _, p := range m.picked {
+		if p {
+			n++
+		}
+	}
+	return n
+}
+
+// viewPicker renders the file list with the cursor and selection marked.
+func (m model) viewPicker() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("Pick files to convert"))
+	b.WriteString("\n")
+	switch {
+	case m.picks == nil:
+		b.WriteString(subtitleStyle.Render("Scanning…"))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render("[" + keyHelp(m.keys.Back, m.keys.Quit) + "] back"))
+		return b.String()
+	case m.picks.Err != "":
+		b.WriteString("\n")
+		b.WriteString(errorStyle.Render("✗  " + m.picks.Err))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render("[" + keyHelp(m.keys.Back, m.keys.Quit) + "] back"))
+		return b.String()
+	case len(m.picks.Files) == 0:
+		b.WriteString(subtitleStyle.Render("No files match the form's settings."))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render("[" + keyHelp(m.keys.Back, m.keys.Quit) + "] back"))
+		return b.String()
+	}
+
+	files := m.picks.Files
+	var total, selected int64
+	for i, f := range files {
+		total += f.Size
+		if m.picked[i] {
+			selected += f.Size
+		}
+	}
+	b.WriteString(subtitleStyle.Render(fmt.Sprintf("%s of %s selected · %s of %s",
+		humanize.Count(m.countPicked()), humanize.Count(len(files)), humanize.Bytes(selected), humanize.Bytes(total))))
+	b.WriteString("\n\n")
+
+	rows := pickRows(m.height)
+	first := max(m.pickCursor-rows+1, 0)
+	last := min(first+rows, len(files))
+	// Sizes line up after the longest name on screen, leaving room for them.
+	nameWidth := 0
+	for _, f := range files[first:last] {
+		nameWidth = max(nameWidth, len([]rune(f.Path)))
+	}
+	nameWidth = min(nameWidth, max(viewportWidth(m.width)-18, 10))
+	for i := first; i < last; i++ {
+		box := "[ ]"
+		if m.picked[i] {
+			box = "[x]"
+		}
+		name := truncate(files[i].Path, nameWidth)
+		name += strings.Repeat(" ", nameWidth-len([]rune(name)))
+		line := box + " " + name
+		if i == m.pickCursor {
+			b.WriteString(selectedModeStyle.Render("› " + line))
+		} else {
+			b.WriteString("  " + line)
+		}
+		b.WriteString("  " + helpStyle.Render(humanize.Bytes(files[i].Size)) + "\n")
+	}
+	b.WriteString("\n")
+	if last-first < len(files) {
+		b.WriteString(helpStyle.Render(fmt.Sprintf("Files %s–%s of %s   [%s] page",
+			humanize.Count(first+1), humanize.Count(last), humanize.Count(len(files)), keysHelp(m.keys.PageUp, m.keys.PageDown))))
+		b.WriteString("\n")
+	}
+	b.WriteString(helpStyle.Render(fmt.Sprintf("[%s] move   [%s] toggle   [%s] all / none   [%s] run selected   [%s] back",
+		keysHelp(m.keys.Up, m.keys.Down), keyHelp(m.keys.Toggle), keyHelp(m.keys.All), keyHelp(m.keys.Run), keyHelp(m.keys.Back, m.keys.Quit))))
+	if m.status != "" {
+		b.WriteString("\n")
+		b.WriteString(subtitleStyle.Render(m.status))
+	}
+
+	return b.String()
+}
+
+// pickRows is how many files fit on the file list.
+func pickRows(termHeight int) int {
+	if termHeight == 0 {
+		return 15 // size not known yet
+	}
+	return max(termHeight-8, 1)
+}
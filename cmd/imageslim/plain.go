@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/geometry"
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+	"github.com/brunovpinheiro/ImageSlim/internal/job"
+)
+
+// ---------------------------------------------------------------------------
+// Plain mode
+// ---------------------------------------------------------------------------
+
+// plainSession is the line-based alternative to the TUI, started with
+// -plain.  It asks the same questions as the form, one per line, and prints
+// results as plain text: no colours, no cursor movement, no box drawing, so
+// screen readers and braille displays can follow along.
+type plainSession struct {
+	in  *bufio.Scanner
+	out io.Writer
+	ui  uiOptions
+}
+
+// runPlain runs plain-mode sessions until the user declines another run or
+// stdin is closed.  j, when non-nil, provides the default answers.
+func runPlain(in io.Reader, out io.Writer, ui uiOptions, j *job.Job) int {
+	s := plainSession{in: bufio.NewScanner(in), out: out, ui: ui}
+
+	fmt.Fprintln(out, "ImageSlim: batch image resize and compression, plain mode.")
+	fmt.Fprintln(out, "Press Enter to accept the default shown in brackets.")
+	if ui.safeMode {
+		fmt.Fprintln(out, "Safe mode: the originals are preserved.")
+	}
+	defaults := plainDefaults(j)
+	check := gm.Options{GMPath: ui.gmPath, GMVersion: cmp.Or(ui.gmVersion, defaults.GMVersion)}
+	if _, version, err := gm.Check(check); err != nil {
+		fmt.Fprintf(out, "Warning: %v\n", err)
+	} else {
+		fmt.Fprintf(out, "Using %s\n", version)
+	}
+
+	for {
+		fmt.Fprintln(out)
+		opts, ok := s.ask(defaults)
+		if !ok {
+			return 0
+		}
+		// Count what the run would touch, to catch a wrong directory.
+		if files, err := scanFiles(opts); err == nil {
+			fmt.Fprintf(out, "%s in %s.\n", found(files), opts.Dir)
+		}
+		if !s.confirm("Start processing?", true) {
+			fmt.Fprintln(out, "Cancelled.")
+		} else {
+			fmt.Fprintln(out, "Running GraphicsMagick, please wait.")
+			started := time.Now()
+			ctx, stop := interruptible() // Ctrl+C stops the run, not the session
+			r := gm.Run(ctx, opts)
+			stop()
+			recordRun("plain", "", opts, started, time.Since(started), r, r.Err)
+			s.report(r)
+			defaults = opts
+		}
+		if !s.confirm("Run another job?", false) {
+			return 0
+		}
+	}
+}
+
+// plainDefaults returns the initial answers: the job's settings, or the same
+// defaults the TUI form starts with.
+func plainDefaults(j *job.Job) gm.Options {
+	if j != nil {
+		return j.Options()
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		dir = "."
+	}
+	return gm.Options{
+		Dir:       dir,
+		Patterns:  gm.DefaultPatterns,
+		Resize:    job.DefaultResize,
+		Quality:   job.DefaultQuality,
+		Recursive: true,
+		Backup:    true,
+	}
+}
+
+// ask prompts for every option.  It returns false when input ends.
+func (s plainSession) ask(d gm.Options) (gm.Options, bool) {
+	opts := d
+	var ok bool
+
+	if opts.Dir, ok = s.line("Base directory", d.Dir); !ok {
+		return opts, false
+	}
+	opts.Dir = expandHome(opts.Dir)
+
+	for {
+		if opts.Resize, ok = s.line("Resize, width x height", d.Resize); !ok {
+			return opts, false
+		}
+		_, err := geometry.Parse(opts.Resize)
+		if err == nil {
+			break
+		}
+		fmt.Fprintf(s.out, "Please enter a size like 1200x1200, 50%% or longedge:1600: %v.\n", err)
+	}
+
+	fit, ok := s.choice("Resize mode", []string{
+		"Fit inside the box, keep the whole image",
+		"Fill and crop to exactly that size",
+		"Pad with white to exactly that size",
+	}, max(slices.Index(resizeModes, d.ResizeMode), 0))
+	if !ok {
+		return opts, false
+	}
+	opts.ResizeMode = resizeModes[fit]
+	opts.Gravity = ""
+	if opts.ResizeMode != gm.ResizeFit {
+		def := slices.Index(gm.Gravities, d.Gravity)
+		if def < 0 {
+			def = gravityCenter
+		}
+		g, ok := s.choice("Gravity, where to crop or place the image", gm.Gravities, def)
+		if !ok {
+			return opts, false
+		}
+		if g != gravityCenter {
+			opts.Gravity = gm.Gravities[g]
+		}
+	}
+
+	def := strconv.Itoa(d.Quality)
+	if d.SmartQuality {
+		def = gm.QualitySmart
+	}
+	for {
+		q, ok := s.line("JPEG quality, 1 to 100 or smart", def)
+		if !ok {
+			return opts, false
+		}
+		if strings.EqualFold(q, gm.QualitySmart) {
+			opts.SmartQuality = true
+			if !d.SmartQuality {
+				opts.Quality = gm.SmartMaxQuality
+			}
+			break
+		}
+		n, err := strconv.Atoi(q)
+		if err == nil && n >= 1 && n <= 100 {
+			opts.Quality, opts.SmartQuality = n, false
+			break
+		}
+		fmt.Fprintln(s.out, "Please enter a whole number from 1 to 100, or smart.")
+	}
+
+	interlace := 0
+	switch d.Interlace {
+	case gm.InterlaceLine:
+		interlace = 1
+	case gm.InterlacePlane:
+		interlace = 2
+	}
+	interlace, ok = s.choice("JPEG encoding", []string{
+		"Baseline",
+		"Progressive, interlaced by line, best for the web",
+		"Progressive, interlaced by plane",
+	}, interlace)
+	if !ok {
+		return opts, false
+	}
+	opts.Interlace = []string{"", gm.InterlaceLine, gm.InterlacePlane}[interlace]
+
+	orient, ok := s.choice("Orientation", []string{
+		"Keep pixels as stored",
+		"Rotate according to the camera's EXIF orientation",
+	}, boolIndex(d.AutoOrient))
+	if !ok {
+		return opts, false
+	}
+	opts.AutoOrient = orient == 1
+
+	sharpen, ok := s.choice("Sharpening after resize", []string{
+		"None",
+		"Unsharp mask, restores crispness lost by downscaling",
+	}, boolIndex(d.Sharpen != ""))
+	if !ok {
+		return opts, false
+	}
+	switch {
+	case sharpen == 0:
+		opts.Sharpen = ""
+	case d.Sharpen == "":
+		opts.Sharpen = gm.DefaultSharpen
+	}
+
+	// Safe mode does not ask: the originals are always preserved.
+	mode := 0
+	if !s.ui.safeMode {
+		if mode, ok = s.choice("Output mode", []string{
+			"Preserve originals, write to the output folder",
+			"Overwrite files in place",
+		}, boolIndex(d.Overwrite)); !ok {
+			return opts, false
+		}
+	}
+	opts.Overwrite = mode == 1
+	if opts.Overwrite {
+		opts.NameTemplate = "" // keeps the original names in place
+		opts.OutputFormat = ""
+	} else {
+		format, ok := s.choice("Output format", []string{
+			"Keep each file's format",
+			"WebP, with cwebp or else gm",
+			"AVIF, with avifenc or else gm",
+		}, max(slices.Index(outputFormats, d.OutputFormat), 0))
+		if !ok {
+			return opts, false
+		}
+		opts.OutputFormat = outputFormats[format]
+	}
+
+	scope, ok := s.choice("Scope", []string{
+		"This folder and all subfolders",
+		"This folder only",
+	}, boolIndex(!d.Recursive))
+	if !ok {
+		return opts, false
+	}
+	opts.Recursive = scope == 0
+
+	if opts.ModifiedAfter, ok = s.date("Only files modified on or after, a date like 2026-09-15 or 30d for 30 days ago", d.ModifiedAfter); !ok {
+		return opts, false
+	}
+	if opts.ModifiedBefore, ok = s.date("Only files modified before", d.ModifiedBefore); !ok {
+		return opts, false
+	}
+
+	resume, ok := s.choice("Already processed files", []string{
+		"Skip them and resume",
+		"Reprocess everything",
+	}, boolIndex(d.Force))
+	if !ok {
+		return opts, false
+	}
+	opts.Force = resume == 1
+
+	if opts.Overwrite {
+		backup, ok := s.choice("Backups", []string{
+			"Back up originals to " + gm.DefaultBackupDir + " first",
+			"No backup",
+		}, boolIndex(!d.Backup))
+		if !ok {
+			return opts, false
+		}
+		opts.Backup = backup == 0
+	}
+
+	opts.GMPath = s.ui.gmPath
+	opts.GMVersion = cmp.Or(s.ui.gmVersion, d.GMVersion)
+	return opts, true
+}
+
+// line prompts for free text, returning def for an empty answer.
+func (s plainSession) line(prompt, def string) (string, bool) {
+	fmt.Fprintf(s.out, "%s [%s]: ", prompt, def)
+	if !s.in.Scan() {
+		fmt.Fprintln(s.out)
+		return def, false
+	}
+	if v := strings.TrimSpace(s.in.Text()); v != "" {
+		return v, true
+	}
+	return def, true
+}
+
+// date prompts for a date as gm.ParseDate reads it, with "none" for no
+// limit.
+func (s plainSession) date(prompt string, def time.Time) (time.Time, bool) {
+	for {
+		v, ok := s.line(prompt, cmp.Or(gm.FormatDate(def), "none"))
+		if !ok {
+			return def, false
+		}
+		t, err := gm.ParseDate(v, time.Now())
+		if err == nil {
+			return t, true
+		}
+		fmt.Fprintln(s.out, "Please enter a date like 2026-09-15, a number of days ago like 30d, or none.")
+	}
+}
+
+// choice lists numbered options and returns the zero-based index picked.
+func (s plainSession) choice(prompt string, options []string, def int) (int, bool) {
+	fmt.Fprintf(s.out, "%s:\n", prompt)
+	for i, o := range options {
+		fmt.Fprintf(s.out, "  %d. %s\n", i+1, o)
+	}
+	for {
+		v, ok := s.line("Choose a number", strconv.Itoa(def+1))
+		if !ok {
+			return def, false
+		}
+		n, err := strconv.Atoi(v)
+		if err == nil && n >= 1 && n <= len(options) {
+			return n - 1, true
+		}
+		fmt.Fprintf(s.out, "Please enter a number from 1 to %d.\n", len(options))
+	}
+}
+
+// confirm asks a yes/no question.
+func (s plainSession) confirm(prompt string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	fmt.Fprintf(s.out, "%s [%s]: ", prompt, hint)
+	if !s.in.Scan() {
+		fmt.Fprintln(s.out)
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(s.in.Text())) {
+	case "":
+		return def
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// report prints the outcome of a run.
+func (s plainSession) report(r gm.Result) {
+	if r.Err != nil {
+		fmt.Fprintf(s.out, "Error: %v\n", r.Err)
+	} else {
+		fmt.Fprintln(s.out, "Done.")
+	}
+	fmt.Fprintln(s.out, r.Summary())
+	if b := r.Breakdown(); b != "" {
+		fmt.Fprintln(s.out, b)
+	}
+	fmt.Fprintln(s.out, "Command:")
+	fmt.Fprintln(s.out, r.Command)
+	if out := strings.TrimSpace(r.Output()); out != "" {
+		fmt.Fprintln(s.out, "Output:")
+		fmt.Fprintln(s.out, out)
+	}
+	if tips := gm.Suggest(r); len(tips) > 0 {
+		fmt.Fprintln(s.out, "What to try:")
+		for _, t := range tips {
+			fmt.Fprintf(s.out, "- %s\n", t)
+		}
+	}
+}
+
+// boolIndex maps false/true to the option indices 0/1.
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
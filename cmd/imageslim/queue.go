@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+	"github.com/brunovpinheiro/ImageSlim/internal/job"
+	"github.com/brunovpinheiro/ImageSlim/internal/sysload"
+)
+
+// ---------------------------------------------------------------------------
+// Run queue: runs started while another goes on wait their turn, and the
+// queue screen lists them
+// ---------------------------------------------------------------------------
+
+// queuedRun is a run on the queue, waiting, going on or finished.
+type queuedRun struct {
+	label  string     // base directory, or the job's name
+	opts   gm.Options // the form's options when it was queued
+	job    *job.Job   // job file the form was loaded from, if any
+	done   bool
+	result gm.Result // once done
+
+	started time.Time     // when it started, once it has
+	took    time.Duration // once done
+}
+
+// runGroup hands runs a context and stops them all at once, e.g. when the
+// TUI exits while runs go on: their gm processes would otherwise carry on
+// in the background.
+type runGroup struct {
+	mu      sync.Mutex // guards ctx and cancel
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+// tuiRuns are the runs the TUI started; runTUI stops them when it returns.
+var tuiRuns runGroup
+
+// do calls run with the group's context and reports true, or returns false
+// straight away when the group has been stopped.
+func (g *runGroup) do(run func(ctx context.Context)) bool {
+	g.mu.Lock()
+	if g.ctx == nil {
+		g.ctx, g.cancel = context.WithCancel(context.Background())
+	}
+	if g.ctx.Err() != nil {
+		g.mu.Unlock()
+		return false
+	}
+	g.running.Add(1)
+	g.mu.Unlock()
+	defer g.running.Done()
+	run(g.ctx)
+	return true
+}
+
+// stop cancels the runs going on, waits for them to return and makes do
+// refuse any more.
+func (g *runGroup) stop() {
+	g.mu.Lock()
+	if g.ctx == nil {
+		g.ctx, g.cancel = context.WithCancel(context.Background())
+	}
+	g.cancel()
+	g.mu.Unlock()
+	g.running.Wait()
+}
+
+// polls reports whether a run with opts reads the power state as it goes:
+// throttling only changes anything when files run in parallel.
+func polls(opts gm.Options) bool {
+	return opts.PowerAware && opts.Workers != 0 && opts.Workers != 1
+}
+
+// startRun validates the form and puts its run on the queue.  It starts
+// straight away when nothing else runs; otherwise the form comes back, so
+// that more runs can be queued.  Forms opened from a job file run the
+// whole job, hooks included.
+func (m model) startRun() (tea.Model, tea.Cmd) {
+	if err := m.validateForm(); err != nil {
+		m.status = "✗ " + err.Error()
+		return m, nil
+	}
+	m.status = ""
+	r := queuedRun{opts: m.buildOptions()}
+	r.label = r.opts.Dir
+	if m.job != nil {
+		r.job = m.formJob()
+		r.label = r.job.Label()
+	}
+	return m.enqueue(r)
+}
+
+// retryFailed queues the run whose result is shown again, for only the
+// files it failed on.  They are taken from the result rather than found by
+// walking the directory again.
+func (m model) retryFailed() (tea.Model, tea.Cmd) {
+	files := make([]string, len(m.result.Errors))
+	for i, e := range m.result.Errors {
+		files[i] = e.Path
+	}
+	r := m.queue[m.shown]
+	r.done, r.result = false, gm.Result{}
+	r.opts.Files, r.opts.Listed = files, true
+	if r.job != nil {
+		j := *r.job
+		j.Files, j.Listed = files, true
+		r.job = &j
+	}
+	return m.enqueue(r)
+}
+
+// enqueue puts r on the queue and starts it when nothing else runs;
+// otherwise the form comes back.
+func (m model) enqueue(r queuedRun) (tea.Model, tea.Cmd) {
+	m.queue = append(slices.Clip(m.queue), r)
+	if m.running > 0 {
+		m.state = stateForm
+		m.status = "✓ Queued the run in " + r.label + ", next in line"
+		if n := m.waiting() - 1; n > 0 {
+			m.status = fmt.Sprintf("✓ Queued the run in %s, after %s other(s)", r.label, humanize.Count(n))
+		}
+		return m, nil
+	}
+	m.state = stateRunning
+	return m.runNext()
+}
+
+// runNext starts the first run on the queue that has not finished, or
+// notes that none runs when they all have.  m.running is still the run
+// that just finished, if any.
+func (m model) runNext() (model, tea.Cmd) {
+	prev := m.running
+	i := slices.IndexFunc(m.queue, func(r queuedRun) bool { return !r.done })
+	if i < 0 {
+		m.running = 0
+		return m, nil
+	}
+	m.running = i + 1
+	m.power = sysload.Power{}
+	m.live = viewport.New(viewportWidth(m.width), viewportHeight(m.height))
+	m.liveLines, m.pace = nil, gm.Progress{}
+	m.queue = slices.Clone(m.queue)
+	m.queue[i].started = time.Now()
+	r := m.queue[i]
+	out := make(chan runUpdate, outputBuffer)
+	cmds := []tea.Cmd{runCmd(r.opts, out), waitOutputCmd(m.running, out)}
+	if r.job != nil {
+		cmds[0] = runJobCmd(r.job, out)
+	}
+	// The previous run's polling carries on when it polled too.
+	if polls(r.opts) && (prev == 0 || !polls(m.queue[prev-1].opts)) {
+		cmds = append(cmds, checkPowerCmd(0))
+	}
+	if !m.ui.reducedMotion {
+		cmds = append(cmds, m.spinner.Tick)
+	}
+	return m, tea.Batch(cmds...)
+}
+
+// finishRun records the result of the running run and starts the next.
+// Someone watching the running screen sees the result once the queue is
+// empty; otherwise the status line says how the run went.
+func (m model) finishRun(res gm.Result) (tea.Model, tea.Cmd) {
+	if m.running == 0 {
+		return m, nil
+	}
+	m.queue = slices.Clone(m.queue)
+	i := m.running - 1
+	r := &m.queue[i]
+	r.done, r.result, r.took = true, res, time.Since(r.started)
+	watching := m.state == stateRunning
+	m, cmd := m.runNext()
+	cmd = tea.Batch(cmd, tea.SetWindowTitle("ImageSlim: "+m.sessionSummary()))
+	switch {
+	case watching && m.running == 0:
+		m = m.showResult(i)
+	case res.Err != nil:
+		m.status = fmt.Sprintf("✗ The run in %s failed: %s", r.label, strings.SplitN(res.Err.Error(), "\n", 2)[0])
+	default:
+		m.status = fmt.Sprintf("✓ The run in %s finished: %s", r.label, res.Summary())
+	}
+	return m, cmd
+}
+
+// showResult switches to the done or error screen for the i-th run on the
+// queue, which has finished.
+func (m model) showResult(i int) model {
+	res := m.queue[i].result
+	m.result, m.shown, m.status = res, i, ""
+	if res.Err != nil {
+		m.state = stateError
+	} else {
+		m.state = stateDone
+	}
+	// Initialise the scrollable viewport with the combined command output.
+	vp := viewport.New(viewportWidth(m.width), m.resultViewportHeight())
+	vp.SetContent(buildOutputContent(res))
+	m.viewport = vp
+	m.vpReady = true
+	return m
+}
+
+// sessionSummary sums up the finished runs on the queue in one line, e.g.
+// "312 files, 1.4 GB → 410 MB, 3 failed, 4 min 12 s", for the terminal
+// title and for the line runTUI prints on exit.  It returns "" before the
+// first run finishes.
+func (m model) sessionSummary() string {
+	var runs, files, failed int
+	var in, out int64
+	var took time.Duration
+	for _, r := range m.queue {
+		if r.done {
+			runs++
+			files += r.result.Processed
+			failed += r.result.Failed
+			in, out = in+r.result.BytesIn, out+r.result.BytesOut
+			took += r.took
+		}
+	}
+	if runs == 0 {
+		return ""
+	}
+	parts := []string{humanize.Count(files) + " files"}
+	if in > 0 {
+		parts = append(parts, humanize.Bytes(in)+" → "+humanize.Bytes(out))
+	}
+	if failed > 0 {
+		parts = append(parts, humanize.Count(failed)+" failed")
+	}
+	parts = append(parts, humanize.Duration(took))
+	s := strings.Join(parts, ", ")
+	if runs > 1 {
+		s = humanize.Count(runs) + " runs: " + s
+	}
+	return s
+}
+
+// waiting returns how many runs on the queue have not started.
+func (m model) waiting() int {
+	n := 0
+	for i, r := range m.queue {
+		if !r.done && i+1 != m.running {
+			n++
+		}
+	}
+	return n
+}
+
+// handOver gives nm, a fresh form, the queue of m and the terminal size,
+// so that runs carry on when the form is filled again.
+func (m model) handOver(nm model) model {
+	nm.width, nm.height = m.width, m.height
+	nm.queue, nm.running = m.queue, m.running
+	nm.spinner, nm.power = m.spinner, m.power
+	nm.live, nm.liveLines, nm.pace = m.live, m.liveLines, m.pace
+	return nm
+}
+
+// maxLiveLines bounds the lines the running screen keeps; older ones are
+// dropped, and the result screen shows the whole output anyway.
+const maxLiveLines = 1000
+
+// addOutput appends lines to the running screen's output, which follows
+// them unless it has been scrolled up.
+func (m model) addOutput(lines []string) model {
+	follow := m.live.AtBottom()
+	m.liveLines = append(slices.Clip(m.liveLines), lines...)
+	if n := len(m.liveLines) - maxLiveLines; n > 0 {
+		m.liveLines = m.liveLines[n:]
+	}
+	m.setLive()
+	if follow {
+		m.live.GotoBottom()
+	}
+	return m
+}
+
+// setLive fills the running screen's viewport with liveLines, wrapped
+// beforehand so that it scrolls by the lines it shows.
+func (m *model) setLive() {
+	wrap := lipgloss.NewStyle().Width(m.live.Width)
+	m.live.SetContent(wrap.Render(strings.Join(m.liveLines, "\n")))
+}
+
+// queueSummary describes the queue for the form, e.g. "1 running, 2
+// waiting", or returns "" while nothing runs or waits.
+func (m model) queueSummary() string {
+	var parts []string
+	if m.running > 0 {
+		parts = append(parts, "1 running")
+	}
+	if n := m.waiting(); n > 0 {
+		parts = append(parts, humanize.Count(n)+" waiting")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// openQueue switches to the queue screen, which returns to the current
+// screen when closed.
+func (m model) openQueue() (tea.Model, tea.Cmd) {
+	m.queueBack = m.state
+	m.state = stateQueue
+	m.queueCursor = max(m.running-1, 0)
+	return m, nil
+}
+
+// updateQueue handles key events on the queue screen.
+func (m model) updateQueue(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	k := m.keys
+	switch {
+	case key.Matches(msg, k.Back, k.Quit, k.Queue):
+		m.state = m.queueBack
+	case key.Matches(msg, k.Up):
+		m.queueCursor = max(m.queueCursor-1, 0)
+	case key.Matches(msg, k.Down):
+		m.queueCursor = min(m.queueCursor+1, max(len(m.queue)-1, 0))
+	case key.Matches(msg, k.Run) && m.queueCursor < len(m.queue):
+		switch r := m.queue[m.queueCursor]; {
+		case r.done:
+			return m.showResult(m.queueCursor), nil
+		case m.queueCursor+1 == m.running:
+			m.state = stateRunning
+			if !m.ui.reducedMotion {
+				return m, m.spinner.Tick
+			}
+		}
+	}
+	return m, nil
+}
+
+// viewQueue renders the runs of this session, oldest first.
+func (m model) viewQueue() string {
+	var b strings.Builder
+	k := m.keys
+
+	b.WriteString(titleStyle.Render("Run queue"))
+	b.WriteString("\n")
+	if len(m.queue) == 0 {
+		b.WriteString(subtitleStyle.Render("Nothing queued — runs started while another goes on wait here."))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render("[" + keyHelp(k.Back, k.Quit) + "] back"))
+		return b.String()
+	}
+	b.WriteString(subtitleStyle.Render(fmt.Sprintf("%s run(s) this session, oldest first", humanize.Count(len(m.queue)))))
+	b.WriteString("\n\n")
+
+	rows := historyRows(m.height)
+	first := max(m.queueCursor-rows+1, 0)
+	last := min(first+rows, len(m.queue))
+	for i := first; i < last; i++ {
+		b.WriteString(m.renderQueuedRun(i))
+	}
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render(fmt.Sprintf("[%s] select   [%s] show   [%s] back",
+		keysHelp(k.Up, k.Down), keyHelp(k.Run), keyHelp(k.Back, k.Quit))))
+	if m.status != "" {
+		b.WriteString("\n")
+		b.WriteString(subtitleStyle.Render(m.status))
+	}
+	return b.String()
+}
+
+// renderQueuedRun renders the i-th run on the queue: where, its options and
+// how far it got.
+func (m model) renderQueuedRun(i int) string {
+	var b strings.Builder
+	r := m.queue[i]
+	width := viewportWidth(m.width)
+
+	var mark, outcome string
+	switch {
+	case r.done && r.result.Err != nil:
+		mark = errorStyle.Render("✗")
+		outcome = "Failed: " + strings.SplitN(r.result.Err.Error(), "\n", 2)[0]
+	case r.done:
+		mark = successStyle.Render("✓")
+		outcome = r.result.Summary()
+	case i+1 == m.running:
+		mark = m.spinner.Style.Render("●")
+		if !m.ui.reducedMotion {
+			mark = m.spinner.View()
+		}
+		outcome = "Running…"
+	default:
+		mark = helpStyle.Render("○")
+		outcome = "Waiting"
+	}
+	head := truncate(r.label, width-4)
+	if i == m.queueCursor {
+		b.WriteString(selectedModeStyle.Render("› " + head))
+	} else {
+		b.WriteString("  " + head)
+	}
+	b.WriteString(" " + mark + "\n")
+	b.WriteString(helpStyle.Render("    " + truncate(describeOptions(r.opts), width-4)))
+	b.WriteString("\n")
+	b.WriteString(subtitleStyle.Render("    " + truncate(outcome, width-4)))
+	b.WriteString("\n")
+	return b.String()
+}
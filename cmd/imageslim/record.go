@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/config"
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+)
+
+// ---------------------------------------------------------------------------
+// Session recording and replay
+// ---------------------------------------------------------------------------
+
+// traceVersion is bumped whenever the trace format changes incompatibly.
+const traceVersion = 1
+
+// Trace event kinds.  "key", "resize", "result", "formats", "history",
+// "files", "power" and "output" are inputs that replay feeds back into the
+// model; "state" and "run" are outputs it checks.
+const (
+	eventStart   = "start"   // initial form contents and interface options
+	eventKey     = "key"     // a key press
+	eventResize  = "resize"  // a terminal resize
+	eventState   = "state"   // the model moved to another screen
+	eventRun     = "run"     // the gm options a run was started with
+	eventResult  = "result"  // the gm result delivered back to the model
+	eventFormats = "formats" // gm's format list for the formats screen
+	eventHistory = "history" // past runs for the history screen
+	eventFiles   = "files"   // matching files for the file list
+	eventInspect = "inspect" // what gm identify said about them
+	eventPower   = "power"   // battery and thermal state during a run
+	eventOutput  = "output"  // lines the running run printed
+)
+
+// traceEvent is one line of a trace file.
+type traceEvent struct {
+	Kind string `json:"kind"`
+	At   int64  `json:"at_ms"` // milliseconds since the session started
+
+	Version int           `json:"version,omitempty"`
+	Form    *formSnapshot `json:"form,omitempty"`
+	Key     *traceKey     `json:"key,omitempty"`
+	Width   int           `json:"width,omitempty"`
+	Height  int           `json:"height,omitempty"`
+	From    string        `json:"from,omitempty"`
+	To      string        `json:"to,omitempty"`
+	Options *gm.Options   `json:"options,omitempty"`
+	Result  *traceResult  `json:"result,omitempty"`
+	Formats *formatsMsg   `json:"formats,omitempty"`
+	History *historyMsg   `json:"history,omitempty"`
+	Files   *filesMsg     `json:"files,omitempty"`
+	Inspect *inspectMsg   `json:"inspect,omitempty"`
+	Power   *powerMsg     `json:"power,omitempty"`
+	Output  *traceOutput  `json:"output,omitempty"`
+}
+
+// traceOutput is an outputMsg as recorded.
+type traceOutput struct {
+	Run      int          `json:"run"`
+	Lines    []string     `json:"lines,omitempty"`
+	Progress *gm.Progress `json:"progress,omitempty"`
+}
+
+// formSnapshot captures everything replay needs to rebuild the starting
+// model, since initialModel depends on the working directory.
+type formSnapshot struct {
+	Inputs        []string            `json:"inputs"`
+	Focus         int                 `json:"focus"`
+	ResizeMode    int                 `json:"resize_mode,omitempty"`
+	Gravity       string              `json:"gravity,omitempty"` // gm name; empty means center
+	OutputMode    int                 `json:"output_mode"`
+	Scope         int                 `json:"scope"`
+	Resume        int                 `json:"resume"`
+	Backup        int                 `json:"backup"`
+	Interlace     bool                `json:"interlace,omitempty"`
+	AutoOrient    bool                `json:"auto_orient,omitempty"`
+	Sharpen       bool                `json:"sharpen,omitempty"`
+	Watermark     *gm.Watermark       `json:"watermark,omitempty"`
+	NameTemplate  string              `json:"name_template,omitempty"`
+	Workers       int                 `json:"workers,omitempty"`
+	PerDirectory  int                 `json:"per_directory,omitempty"`
+	TargetSize    int64               `json:"target_size,omitempty"`
+	MinQuality    int                 `json:"min_quality,omitempty"`
+	OptimizePNG   string              `json:"optimize_png,omitempty"`
+	Format        string              `json:"format,omitempty"` // gm name; empty keeps each file's format
+	Effort        int                 `json:"effort,omitempty"`
+	HEIC          bool                `json:"heic,omitempty"`
+	AnimatedGIF   string              `json:"animated_gif,omitempty"`
+	Report        string              `json:"report,omitempty"`
+	ReportFormat  string              `json:"report_format,omitempty"`
+	Baseline      string              `json:"baseline,omitempty"`
+	Tolerance     int                 `json:"baseline_tolerance,omitempty"`
+	MinFileSize   int64               `json:"min_file_size,omitempty"`
+	MinWidth      int                 `json:"min_width,omitempty"`
+	MinHeight     int                 `json:"min_height,omitempty"`
+	PowerAware    bool                `json:"power_aware,omitempty"`
+	Preset        int                 `json:"preset,omitempty"`
+	Presets       []config.Preset     `json:"presets,omitempty"`       // from the config file
+	Naming        []config.Naming     `json:"naming,omitempty"`        // from the config file, and the job's template
+	Keys          map[string][]string `json:"keys,omitempty"`          // key bindings the config file changed
+	Output        string              `json:"output,omitempty"`        // the config file's output behaviour
+	ExportFormat  string              `json:"export_format,omitempty"` // the config file's report format
+	Setup         string              `json:"setup,omitempty"`         // config file the first-run setup creates, while shown
+	Helpers       []gm.Helper         `json:"helpers,omitempty"`       // optional programs the setup found
+	ReducedMotion bool                `json:"reduced_motion,omitempty"`
+	Spinner       string              `json:"spinner,omitempty"`
+	SafeMode      bool                `json:"safe_mode,omitempty"`
+	Pager         bool                `json:"pager,omitempty"`
+	GMPath        string              `json:"gm_path,omitempty"`
+	GMVersion     string              `json:"gm_version,omitempty"`     // -gm-version
+	PinnedVersion string              `json:"pinned_version,omitempty"` // the job's gm_version
+	GMError       string              `json:"gm_error,omitempty"`       // why gm was unusable, if it was
+	GMFailure     string              `json:"gm_failure,omitempty"`     // category of GMError
+	Now           *time.Time          `json:"now,omitempty"`            // wall clock, for dates like "30d"
+}
+
+// traceKey is a tea.Key in a form that round-trips through JSON; Name is
+// the human-readable key name and is ignored on replay.
+type traceKey struct {
+	Name  string `json:"name"`
+	Type  int    `json:"type"`
+	Runes string `json:"runes,omitempty"`
+	Alt   bool   `json:"alt,omitempty"`
+	Paste bool   `json:"paste,omitempty"`
+}
+
+// traceResult is gm.Result with the errors flattened to strings.
+type traceResult struct {
+	gm.Result
+	Err    string           `json:"error,omitempty"`
+	Errors []traceFileError `json:"errors,omitempty"`
+
+	// Output is the text recordings made before gm.Result.Log held.
+	Output string `json:"Output,omitempty"`
+}
+
+// traceFileError is gm.FileError with the error flattened to a string.
+type traceFileError struct {
+	Path string `json:"path"`
+	Err  string `json:"error"`
+}
+
+func snapshotForm(m model) *formSnapshot {
+	s := &formSnapshot{
+		Focus:         m.focus,
+		ResizeMode:    m.resizeMode,
+		OutputMode:    m.outputMode,
+		Scope:         m.scope,
+		Resume:        m.resume,
+		Backup:        m.backup,
+		Interlace:     m.interlace,
+		AutoOrient:    m.autoOrient,
+		Sharpen:       m.sharpen,
+		ReducedMotion: m.ui.reducedMotion,
+		Spinner:       m.ui.spinner,
+		SafeMode:      m.ui.safeMode,
+		Pager:         m.ui.pager,
+		GMPath:        m.ui.gmPath,
+		GMVersion:     m.ui.gmVersion,
+		PinnedVersion: m.gmVersion,
+		NameTemplate:  m.nameTmpl,
+		Workers:       m.workers,
+		PerDirectory:  m.perDirectory,
+		TargetSize:    m.targetSize,
+		MinQuality:    m.minQuality,
+		OptimizePNG:   m.optimizePNG,
+		Format:        outputFormats[m.format],
+		Effort:        m.effort,
+		HEIC:          m.heic,
+		AnimatedGIF:   m.animatedGIF,
+		Report:        m.report,
+		ReportFormat:  m.reportFormat,
+		Baseline:      m.baseline,
+		Tolerance:     m.tolerance,
+		MinFileSize:   m.minSize,
+		MinWidth:      m.minWidth,
+		MinHeight:     m.minHeight,
+		PowerAware:    m.powerAware,
+		Preset:        m.preset,
+		Presets:       m.presets,
+		Naming:        m.namings,
+		Keys:          m.keys.changed(),
+	}
+	if m.gravity != gravityCenter {
+		s.Gravity = gm.Gravities[m.gravity]
+	}
+	if m.watermark.Enabled() {
+		w := m.watermark
+		s.Watermark = &w
+	}
+	if m.askOutput {
+		s.Output = config.OutputAsk
+	}
+	if m.exportFormat != config.DefaultReportFormat {
+		s.ExportFormat = m.exportFormat
+	}
+	if m.setup != nil {
+		s.Setup, s.Helpers = m.setup.path, m.setup.helpers
+	}
+	if m.gmErr != nil {
+		s.GMError, s.GMFailure = m.gmErr.Error(), gm.Category(m.gmErr)
+	}
+	now := clock().Truncate(time.Second)
+	s.Now = &now
+	for _, in := range m.inputs {
+		s.Inputs = append(s.Inputs, in.Value())
+	}
+	return s
+}
+
+// restoreForm rebuilds a model from a snapshot.  It also pins gmCheck to the
+// recorded outcome, so the gm warning banner renders as it did on the
+// recording machine for the rest of the replay, including after "r";
+// loadConfig and the first-run setup's findHelpers to what was recorded,
+// for the same reason, and its saveConfig to a no-op; darkBackground to
+// true, so replays never query the terminal; and the clock to the recorded
+// time, so relative dates resolve as they did.
+func restoreForm(s *formSnapshot) model {
+	var recorded error
+	if s.GMError != "" {
+		recorded = errors.New(s.GMError)
+		if s.GMFailure != "" {
+			recorded = gm.WithCategory(s.GMFailure, recorded)
+		}
+	}
+	gmCheck = func(gm.Options) error { return recorded }
+	presets := s.Presets
+	if presets == nil {
+		presets = config.DefaultPresets // recorded before presets existed
+	}
+	naming := s.Naming
+	if naming == nil {
+		naming = config.DefaultNaming // recorded before naming schemes existed
+	}
+	keys, output, export := s.Keys, s.Output, s.ExportFormat
+	loadConfig = func(...string) (config.Config, error) {
+		return config.Config{Output: output, ReportFormat: export, Presets: presets, Naming: naming, Keys: keys}, nil
+	}
+	helpers, path := s.Helpers, s.Setup
+	findHelpers = func() []gm.Helper { return helpers }
+	saveConfig = func(config.Config) (string, error) { return path, nil }
+	darkBackground = func() bool { return true }
+	clock = time.Now
+	if s.Now != nil {
+		now := *s.Now
+		clock = func() time.Time { return now }
+	}
+
+	spin := s.Spinner
+	if _, ok := spinnerStyles[spin]; !ok {
+		spin = defaultSpinner
+	}
+	m := initialModel(uiOptions{reducedMotion: s.ReducedMotion, spinner: spin, gmPath: s.GMPath, gmVersion: s.GMVersion, safeMode: s.SafeMode, pager: s.Pager})
+	for i, v := range s.Inputs {
+		if i < len(m.inputs) {
+			m.inputs[i].SetValue(v)
+		}
+	}
+	for i := range m.inputs {
+		if inputFocus[i] == s.Focus {
+			m.inputs[i].Focus()
+		} else {
+			m.inputs[i].Blur()
+		}
+	}
+	m.focus, m.outputMode, m.scope, m.resume, m.backup = s.Focus, s.OutputMode, s.Scope, s.Resume, s.Backup
+	m.interlace, m.autoOrient, m.sharpen = s.Interlace, s.AutoOrient, s.Sharpen
+	if s.Watermark != nil {
+		m.watermark = *s.Watermark
+	}
+	m.nameTmpl, m.workers, m.perDirectory = s.NameTemplate, s.Workers, s.PerDirectory
+	m.targetSize, m.minQuality = s.TargetSize, s.MinQuality
+	m.optimizePNG = s.OptimizePNG
+	m.effort = s.Effort
+	m.heic = s.HEIC
+	m.animatedGIF = s.AnimatedGIF
+	m.report, m.reportFormat = s.Report, s.ReportFormat
+	m.baseline, m.tolerance = s.Baseline, s.Tolerance
+	m.minSize, m.minWidth, m.minHeight = s.MinFileSize, s.MinWidth, s.MinHeight
+	m.powerAware = s.PowerAware
+	m.gmVersion = s.PinnedVersion
+	if s.Preset >= 0 && s.Preset <= len(m.presets) {
+		m.preset = s.Preset
+	}
+	if s.ResizeMode >= 0 && s.ResizeMode < len(resizeModes) {
+		m.resizeMode = s.ResizeMode
+	}
+	if i := slices.Index(gm.Gravities, s.Gravity); i >= 0 {
+		m.gravity = i
+	}
+	if i := slices.Index(outputFormats, s.Format); i >= 0 {
+		m.format = i
+	}
+	if s.Setup != "" {
+		m = m.startSetup(s.Setup)
+	}
+	return m
+}
+
+// ---------------------------------------------------------------------------
+// Recording
+// ---------------------------------------------------------------------------
+
+// traceWriter appends events to a trace file, one JSON object per line.
+type traceWriter struct {
+	f     *os.File
+	enc   *json.Encoder
+	start time.Time
+	err   error // first write error; recording stops after it
+}
+
+func newTraceWriter(path string) (*traceWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &traceWriter{f: f, enc: json.NewEncoder(f), start: time.Now()}, nil
+}
+
+func (t *traceWriter) write(ev traceEvent) {
+	if t.err != nil {
+		return
+	}
+	ev.At = time.Since(t.start).Milliseconds()
+	t.err = t.enc.Encode(ev)
+}
+
+func (t *traceWriter) Close() error {
+	if err := t.f.Close(); t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
+
+// recorder wraps the model and logs its inputs and state transitions.
+// Spinner ticks and cursor blinks are not recorded: they never change state.
+type recorder struct {
+	model
+	trace *traceWriter
+}
+
+func newRecorder(m model, t *traceWriter) recorder {
+	t.write(traceEvent{Kind: eventStart, Version: traceVersion, Form: snapshotForm(m)})
+	return recorder{model: m, trace: t}
+}
+
+func (r recorder) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if ev, ok := inputEvent(msg); ok {
+		r.trace.write(ev)
+	}
+
+	before, running := r.model.state, r.model.running
+	next, cmd := r.model.Update(msg)
+	r.model = next.(model)
+
+	if r.model.state != before {
+		r.trace.write(traceEvent{Kind: eventState, From: before.String(), To: r.model.state.String()})
+	}
+	if opts, ok := r.model.started(running); ok {
+		r.trace.write(traceEvent{Kind: eventRun, Options: &opts})
+	}
+	return r, cmd
+}
+
+// started returns the options of the run m started, if it started one
+// since running was the running run.
+func (m model) started(running int) (gm.Options, bool) {
+	if m.running == 0 || m.running == running {
+		return gm.Options{}, false
+	}
+	return m.queue[m.running-1].opts, true
+}
+
+// inputEvent converts a message the model reacts to into a trace event.
+func inputEvent(msg tea.Msg) (traceEvent, bool) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		return traceEvent{Kind: eventKey, Key: &traceKey{
+			Name:  msg.String(),
+			Type:  int(msg.Type),
+			Runes: string(msg.Runes),
+			Alt:   msg.Alt,
+			Paste: msg.Paste,
+		}}, true
+	case tea.WindowSizeMsg:
+		return traceEvent{Kind: eventResize, Width: msg.Width, Height: msg.Height}, true
+	case resultMsg:
+		tr := &traceResult{Result: gm.Result(msg)}
+		if msg.Err != nil {
+			tr.Err = msg.Err.Error()
+		}
+		for _, e := range msg.Errors {
+			tr.Errors = append(tr.Errors, traceFileError{Path: e.Path, Err: e.Err.Error()})
+		}
+		tr.Result.Err, tr.Result.Errors = nil, nil
+		return traceEvent{Kind: eventResult, Result: tr}, true
+	case formatsMsg:
+		return traceEvent{Kind: eventFormats, Formats: &msg}, true
+	case historyMsg:
+		return traceEvent{Kind: eventHistory, History: &msg}, true
+	case filesMsg:
+		return traceEvent{Kind: eventFiles, Files: &msg}, true
+	case inspectMsg:
+		return traceEvent{Kind: eventInspect, Inspect: &msg}, true
+	case powerMsg:
+		return traceEvent{Kind: eventPower, Power: &msg}, true
+	case outputMsg:
+		return traceEvent{Kind: eventOutput, Output: &traceOutput{Run: msg.run, Lines: msg.lines, Progress: msg.progress}}, true
+	}
+	return traceEvent{}, false
+}
+
+// ---------------------------------------------------------------------------
+// Replay
+// ---------------------------------------------------------------------------
+
+// errReplayDiverged is returned by replayTrace when the model no longer
+// behaves as recorded.
+var errReplayDiverged = errors.New("replay diverged from the recording")
+
+// replayTrace feeds the inputs recorded in r back into a fresh model and
+// checks that it makes the same state transitions and starts the same runs.
+// GraphicsMagick is never executed: recorded results are delivered instead.
+// A log of the replay is written to w.  When frame is non-nil it is called
+// with the starting model, after every state transition and once more at
+// the end, which is how golden-file checks capture each screen.  The final
+// model is returned so callers can inspect it further.
+func replayTrace(r io.Reader, w io.Writer, frame func(model)) (model, error) {
+	if frame == nil {
+		frame = func(model) {}
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024) // results can be large
+
+	var (
+		m        model
+		started  bool
+		pending  []traceEvent // outputs produced by the model, not yet matched
+		line     int
+		diverged bool
+	)
+
+	for sc.Scan() {
+		line++
+		var ev traceEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			return m, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if !started {
+			if ev.Kind != eventStart || ev.Form == nil {
+				return m, fmt.Errorf("line %d: trace must begin with a %q event", line, eventStart)
+			}
+			if ev.Version != traceVersion {
+				return m, fmt.Errorf("trace version %d is not supported (want %d)", ev.Version, traceVersion)
+			}
+			m = restoreForm(ev.Form)
+			started = true
+			fmt.Fprintf(w, "start  %s\n", m.state)
+			frame(m)
+			continue
+		}
+
+		switch ev.Kind {
+		case eventKey, eventResize, eventResult, eventFormats, eventHistory, eventFiles, eventInspect, eventPower, eventOutput:
+			// Ctrl+S writes a job file; replay must not touch the disk.
+			if ev.Kind == eventKey && m.state == stateForm && key.Matches(eventMsg(ev).(tea.KeyMsg), m.keys.Save) {
+				fmt.Fprintf(w, "key    %s (skipped: writes files)\n", ev.Key.Name)
+				continue
+			}
+			msg := eventMsg(ev)
+			before, running := m.state, m.running
+			next, _ := m.Update(msg)
+			m = next.(model)
+			if ev.Kind == eventKey {
+				fmt.Fprintf(w, "key    %s\n", ev.Key.Name)
+			}
+			if m.state != before {
+				pending = append(pending, traceEvent{Kind: eventState, From: before.String(), To: m.state.String()})
+			}
+			if opts, ok := m.started(running); ok {
+				pending = append(pending, traceEvent{Kind: eventRun, Options: &opts})
+			}
+			if m.state != before {
+				frame(m)
+			} else if ev.Kind != eventKey && ev.Kind != eventResize {
+				frame(m) // the formats, history or file list filled in, the throttled badge changed, output arrived or a queued run finished
+			}
+
+		case eventState, eventRun:
+			if len(pending) == 0 {
+				fmt.Fprintf(w, "line %d: recorded %s not reproduced\n", line, describe(ev))
+				diverged = true
+				continue
+			}
+			got := pending[0]
+			pending = pending[1:]
+			if !sameOutput(got, ev) {
+				fmt.Fprintf(w, "line %d: recorded %s, replay produced %s\n", line, describe(ev), describe(got))
+				diverged = true
+				continue
+			}
+			fmt.Fprintf(w, "ok     %s\n", describe(ev))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return m, err
+	}
+	if !started {
+		return m, fmt.Errorf("empty trace")
+	}
+	frame(m)
+	for _, ev := range pending {
+		fmt.Fprintf(w, "extra  %s (not in recording)\n", describe(ev))
+		diverged = true
+	}
+	if diverged {
+		return m, errReplayDiverged
+	}
+	return m, nil
+}
+
+// eventMsg converts a recorded input event back into a Bubble Tea message.
+func eventMsg(ev traceEvent) tea.Msg {
+	switch ev.Kind {
+	case eventKey:
+		return tea.KeyMsg{
+			Type:  tea.KeyType(ev.Key.Type),
+			Runes: []rune(ev.Key.Runes),
+			Alt:   ev.Key.Alt,
+			Paste: ev.Key.Paste,
+		}
+	case eventResize:
+		return tea.WindowSizeMsg{Width: ev.Width, Height: ev.Height}
+	case eventResult:
+		res := ev.Result.Result
+		if ev.Result.Err != "" {
+			res.Err = errors.New(ev.Result.Err)
+		}
+		for _, e := range ev.Result.Errors {
+			res.Errors = append(res.Errors, gm.FileError{Path: e.Path, Err: errors.New(e.Err)})
+		}
+		if len(res.Log) == 0 {
+			res.Log = gm.TextLog(ev.Result.Output, gm.LogRun, time.Time{})
+		}
+		return resultMsg(res)
+	case eventFormats:
+		return *ev.Formats
+	case eventHistory:
+		return *ev.History
+	case eventFiles:
+		return *ev.Files
+	case eventInspect:
+		return *ev.Inspect
+	case eventPower:
+		return *ev.Power
+	case eventOutput:
+		return outputMsg{run: ev.Output.Run, lines: ev.Output.Lines, progress: ev.Output.Progress}
+	}
+	return nil
+}
+
+// sameOutput compares a produced and a recorded output event.  Run options
+// are compared as they are written to the trace, so that times match by
+// instant and zone offset rather than by their *time.Location.
+func sameOutput(got, want traceEvent) bool {
+	if got.Kind != want.Kind {
+		return false
+	}
+	if got.Kind == eventState {
+		return got.From == want.From && got.To == want.To
+	}
+	g, gerr := json.Marshal(got.Options)
+	w, werr := json.Marshal(want.Options)
+	return gerr == nil && werr == nil && bytes.Equal(g, w)
+}
+
+// describe renders an output event for the replay log.
+func describe(ev traceEvent) string {
+	switch ev.Kind {
+	case eventState:
+		return fmt.Sprintf("state %s → %s", ev.From, ev.To)
+	case eventRun:
+		if ev.Options != nil {
+			return fmt.Sprintf("run in %s (resize %s, quality %d)", ev.Options.Dir, ev.Options.Resize, ev.Options.Quality)
+		}
+	}
+	return ev.Kind
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/config"
+)
+
+// ---------------------------------------------------------------------------
+// serve: presets from the configuration file, reread while the server runs
+// so that a preset tweak needs no restart
+// ---------------------------------------------------------------------------
+
+// fileStamp is what watchConfig compares to notice that a file changed.
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// stampOf returns path's stamp; a file that cannot be read has none.
+func stampOf(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{exists: true, size: fi.Size(), modTime: fi.ModTime()}
+}
+
+// loadServerConfig reads the configuration file for a starting server.  A
+// file that cannot be used is reported, and the server starts with the
+// default presets.
+func loadServerConfig() config.Config {
+	c, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v; using the default presets\n", err)
+	}
+	return c
+}
+
+// watchConfig checks the configuration files at paths every interval until
+// ctx is done, and reloads the configuration whenever one has been
+// written, created or removed since.
+func (s *server) watchConfig(ctx context.Context, paths []string, every time.Duration) {
+	last := make([]fileStamp, len(paths))
+	for i, p := range paths {
+		last[i] = stampOf(p)
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		for i, p := range paths {
+			if now := stampOf(p); now != last[i] {
+				last[i] = now
+				s.reloadConfig(p)
+			}
+		}
+	}
+}
+
+// reloadConfig rereads the configuration file and logs what changed.  A
+// file that cannot be used, say one saved halfway through an edit, leaves
+// the settings as they were until it is fixed.
+func (s *server) reloadConfig(path string) {
+	c, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: not reloaded, keeping the previous settings: %v\n", err)
+		return
+	}
+	s.mu.Lock()
+	changes := s.config.Changes(c)
+	s.config = c
+	s.mu.Unlock()
+	if len(changes) == 0 {
+		fmt.Printf("Reloaded %s: nothing changed\n", path)
+		return
+	}
+	fmt.Printf("Reloaded %s: %s\n", path, strings.Join(changes, "; "))
+}
+
+// presets lists the presets submitted jobs may name, as the configuration
+// file has them now.
+func (s *server) presets(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	presets := s.config.Presets
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, presets)
+}
+
+// withPreset returns the submitted job data with the fields the preset
+// called name sets added, unless the job sets them itself.
+func (s *server) withPreset(data []byte, name string) ([]byte, error) {
+	s.mu.Lock()
+	var p *config.Preset
+	for i := range s.config.Presets {
+		if s.config.Presets[i].Name == name {
+			p = &s.config.Presets[i]
+		}
+	}
+	s.mu.Unlock()
+	if p == nil {
+		return nil, fmt.Errorf("no preset %q; GET /presets lists them", name)
+	}
+	var fields map[string]any
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return nil, errors.New("a job must be a mapping of job file fields")
+	}
+	for k, v := range presetFields(*p) {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	return yaml.Marshal(fields)
+}
+
+// presetFields returns the job file fields p sets, as the form would run
+// them.
+func presetFields(p config.Preset) map[string]any {
+	fields := map[string]any{}
+	if p.Resize != "" {
+		fields["resize"] = p.Resize
+	}
+	if p.Quality != 0 {
+		fields["quality"] = p.Quality
+	}
+	if p.ResizeMode != "" {
+		fields["resize_mode"] = p.ResizeMode
+	}
+	if p.Format != "" {
+		fields["format"] = p.Format
+	}
+	if p.Interlace != nil {
+		fields["interlace"] = "none"
+		if *p.Interlace {
+			fields["interlace"] = "line"
+		}
+	}
+	if p.AutoOrient != nil {
+		fields["auto_orient"] = *p.AutoOrient
+	}
+	if p.Sharpen != nil {
+		fields["sharpen"] = "off"
+		if *p.Sharpen {
+			fields["sharpen"] = "on"
+		}
+	}
+	if len(p.Steps) > 0 {
+		fields["steps"] = p.Steps
+	}
+	return fields
+}
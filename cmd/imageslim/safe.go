@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/config"
+	"github.com/brunovpinheiro/ImageSlim/internal/job"
+)
+
+// ---------------------------------------------------------------------------
+// Safe mode: nothing but converted copies, for shared machines and master
+// archives
+// ---------------------------------------------------------------------------
+
+// registerSafeMode adds the -safe-mode flag to fs.
+func registerSafeMode(fs *flag.FlagSet, p *bool) {
+	fs.BoolVar(p, "safe-mode", false, "refuse overwrite mode, hooks, notify and exec commands, orient, restore and undo, so that originals are only ever read (or safe_mode: true in a configuration file)")
+}
+
+// safeMode reports whether safe mode is on: asked for with -safe-mode, or
+// locked on by a configuration file (see config.Locked).
+func safeMode(flagged bool) bool {
+	_, locked := config.Locked()
+	return flagged || locked
+}
+
+// refuseUnsafe returns an error naming what j does that safe mode forbids,
+// or nil when it does nothing of the kind.
+func refuseUnsafe(j *job.Job) error {
+	if what := j.Destructive(); len(what) > 0 {
+		return fmt.Errorf("%s: safe mode forbids %s", j.Label(), strings.Join(what, ", "))
+	}
+	return nil
+}
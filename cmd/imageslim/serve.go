@@ -0,0 +1,523 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/config"
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+	"github.com/brunovpinheiro/ImageSlim/internal/job"
+)
+
+// ---------------------------------------------------------------------------
+// serve: an HTTP API that queues jobs, so that other machines can start
+// runs on the one holding the images
+// ---------------------------------------------------------------------------
+
+// tokenEnv names the secret clients of "serve" must send, as
+// "Authorization: Bearer TOKEN".  Unset, the API is open to anyone who can
+// reach it.
+const tokenEnv = "IMAGESLIM_TOKEN"
+
+// Job states, as the API reports them.
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobOK      = "ok"
+	jobFailed  = "failed"
+)
+
+// serveQueue is how many submitted jobs may wait for a free slot before
+// the API turns new ones away.
+const serveQueue = 100
+
+// serveMaxBody caps the size of a submitted job.
+const serveMaxBody = 1 << 20
+
+// serveArgs are the flags of "serve".
+type serveArgs struct {
+	addr      string
+	root      string
+	parallel  int
+	reload    time.Duration
+	safeMode  bool
+	gmPath    string
+	gmVersion string
+}
+
+// register adds the flags to fs.
+func (a *serveArgs) register(fs *flag.FlagSet) {
+	fs.StringVar(&a.addr, "addr", "localhost:8080", "`address` to listen on; :8080 also accepts other machines")
+	fs.StringVar(&a.root, "root", ".", "`dir`ectory submitted jobs are confined to; their paths are relative to it")
+	fs.IntVar(&a.parallel, "parallel", 1, "`number` of jobs to run at the same time")
+	fs.DurationVar(&a.reload, "reload", 2*time.Second, "how often to check the configuration file for changed presets; 0 reads it only at start")
+	registerSafeMode(fs, &a.safeMode)
+	registerGMPath(fs, &a.gmPath)
+	registerGMVersion(fs, &a.gmVersion)
+}
+
+// cmdServe runs the HTTP API until the process is stopped.  Jobs are kept
+// in memory, so their status is lost when it stops; their runs are in the
+// history like any other.  The configuration file's presets are reread
+// when it changes, so editing one needs no restart.
+func cmdServe(args []string) int {
+	var a serveArgs
+	fs := newFlagSet("serve", a.register)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprint(os.Stderr, usageText)
+		return 2
+	}
+	if _, err := gm.ParseVersion(a.gmVersion); err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 2
+	}
+	root, err := filepath.Abs(expandHome(a.root))
+	if err == nil {
+		err = isDir(root)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: -root: %v\n", err)
+		return 2
+	}
+	if !checkGM(gm.Options{GMPath: a.gmPath, GMVersion: a.gmVersion}) {
+		return 1
+	}
+
+	s := newServer(root, a, os.Getenv(tokenEnv))
+	s.config = loadServerConfig()
+	ln, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Serving jobs in %s on http://%s\n", root, ln.Addr())
+	if s.safeMode || s.config.SafeMode {
+		fmt.Println("Safe mode: jobs in overwrite mode are refused")
+	}
+	if s.token == "" && !isLoopback(ln.Addr()) {
+		fmt.Fprintf(os.Stderr, "imageslim: warning: %s is not set, so anyone who can reach this address can run jobs\n", tokenEnv)
+	}
+	// Ctrl+C stops the server and the runs going on, killing their gm.
+	ctx, stop := interruptible()
+	defer stop()
+	if a.reload > 0 {
+		go s.watchConfig(ctx, config.Files(), a.reload)
+	}
+	srv := &http.Server{Handler: s}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	err = srv.Serve(ln)
+	s.runs.stop()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "imageslim: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// isDir returns an error unless path is a directory.
+func isDir(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return nil
+}
+
+// isLoopback reports whether addr only accepts connections from this
+// machine.
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// server is the "serve" API: it queues submitted jobs and runs them on
+// parallel workers.
+type server struct {
+	root      string
+	token     string
+	gmPath    string
+	gmVersion string
+	safeMode  bool // refuse overwrite mode too, whatever the configuration says
+	queue     chan *serverJob
+	mux       *http.ServeMux
+	runs      runGroup // stopped with the server
+
+	mu     sync.Mutex // guards jobs, every serverJob's status and files, and config
+	jobs   []*serverJob
+	config config.Config // presets jobs may name, reloaded as the file changes
+}
+
+// serverJob is a submitted job.  Its ID is its position in server.jobs,
+// counted from 1.
+type serverJob struct {
+	job    *job.Job
+	status jobStatus
+	files  []gm.ReportRow // once the run has finished
+}
+
+// jobStatus is a job as the API reports it.
+type jobStatus struct {
+	ID       int        `json:"id"`
+	Name     string     `json:"name,omitempty"`
+	Dir      string     `json:"dir"` // relative to the server's root
+	State    string     `json:"state"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	// Done counts the files dealt with so far, out of Total; both are zero
+	// until the run has found its files.
+	Done  int `json:"done"`
+	Total int `json:"total"`
+
+	Processed int      `json:"processed"`
+	Skipped   int      `json:"skipped"`
+	Failed    int      `json:"failed"`
+	BytesIn   int64    `json:"bytes_in"`
+	BytesOut  int64    `json:"bytes_out"`
+	Summary   string   `json:"summary,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Hints     []string `json:"hints,omitempty"`
+}
+
+// newServer returns the API for jobs under root, with a's workers already
+// waiting for jobs.
+func newServer(root string, a serveArgs, token string) *server {
+	s := &server{
+		root:      root,
+		token:     token,
+		gmPath:    a.gmPath,
+		gmVersion: a.gmVersion,
+		safeMode:  a.safeMode,
+		queue:     make(chan *serverJob, serveQueue),
+		mux:       http.NewServeMux(),
+	}
+	s.mux.HandleFunc("POST /jobs", s.submit)
+	s.mux.HandleFunc("GET /jobs", s.list)
+	s.mux.HandleFunc("GET /jobs/{id}", s.get)
+	s.mux.HandleFunc("GET /jobs/{id}/files", s.files)
+	s.mux.HandleFunc("GET /jobs/{id}/output/{path...}", s.output)
+	s.mux.HandleFunc("GET /presets", s.presets)
+	for range max(a.parallel, 1) {
+		go s.work()
+	}
+	return s
+}
+
+// ServeHTTP checks the token, when there is one, and hands the request on.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong token"))
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// submit queues the job in the request body: a job file's fields as JSON
+// or YAML, with those of the preset ?preset=NAME names filling in the rest.
+func (s *server) submit(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, serveMaxBody))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	j, err := s.parse(data, r.URL.Query().Get("preset"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mu.Lock()
+	sj := &serverJob{job: j, status: jobStatus{
+		ID:      len(s.jobs) + 1,
+		Name:    j.Name,
+		Dir:     s.relative(j.ResolveDir()),
+		State:   jobQueued,
+		Created: time.Now().UTC(),
+	}}
+	select {
+	case s.queue <- sj:
+	default:
+		s.mu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("%d jobs are already waiting; try again later", serveQueue))
+		return
+	}
+	s.jobs = append(s.jobs, sj)
+	status := sj.status
+	s.mu.Unlock()
+
+	w.Header().Set("Location", "/jobs/"+strconv.Itoa(status.ID))
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// parse decodes a submitted job with its paths relative to the root, and
+// the preset called preset, if any, applied.  It refuses what a remote
+// client must not do: reach outside the root or run shell commands, and in
+// safe mode overwrite the originals.
+func (s *server) parse(data []byte, preset string) (*job.Job, error) {
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil, errors.New("empty job; send a job file's fields, e.g. {\"dir\": \"photos\"}")
+	}
+	if preset != "" {
+		var err error
+		if data, err = s.withPreset(data, preset); err != nil {
+			return nil, err
+		}
+	}
+	j, err := job.ParseRemote(data, filepath.Join(s.root, "job.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if what := j.Commands(); len(what) > 0 {
+		return nil, fmt.Errorf("the job runs shell commands (%s), which are not accepted over HTTP; run it with imageslim run", strings.Join(what, ", "))
+	}
+	s.mu.Lock()
+	safe := s.safeMode || s.config.SafeMode
+	s.mu.Unlock()
+	if safe {
+		if err := refuseUnsafe(j); err != nil {
+			return nil, err
+		}
+	}
+	j.GMPath = s.gmPath
+	if s.gmVersion != "" {
+		j.GMVersion = s.gmVersion
+	}
+	opts := j.Options()
+	if opts.FollowSymlinks != "" {
+		return nil, fmt.Errorf("follow_symlinks: not accepted over HTTP, as links may lead outside %s", s.root)
+	}
+	backup := opts.BackupDir
+	if backup != "" && !filepath.IsAbs(backup) {
+		backup = filepath.Join(opts.Dir, backup)
+	}
+	paths := []struct{ name, path string }{
+		{"dir", opts.Dir}, {"watermark", opts.Watermark.Image}, {"report", opts.Report}, {"backup_dir", backup},
+	}
+	if !opts.Overwrite {
+		paths = append(paths, struct{ name, path string }{"dir", filepath.Join(opts.Dir, gm.OutputDir)})
+	}
+	for _, step := range opts.Steps {
+		if step.Op == gm.StepWatermark && step.Value != "" {
+			paths = append(paths, struct{ name, path string }{"steps", step.Value})
+		}
+	}
+	for _, p := range paths {
+		if p.path != "" && !s.contains(p.path) {
+			return nil, fmt.Errorf("%s: %s is outside %s", p.name, p.path, s.root)
+		}
+	}
+	if err := isDir(opts.Dir); err != nil {
+		return nil, fmt.Errorf("dir: %w", err)
+	}
+	return j, nil
+}
+
+// contains reports whether path lies inside the root once the symbolic
+// links in both are followed, so that a link inside the root cannot lead a
+// job out of it.
+func (s *server) contains(path string) bool {
+	root, err := filepath.EvalSymlinks(s.root)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, realPath(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// realPath returns the absolute path with the symbolic links in the part of
+// it that exists followed; the rest, such as a report still to be written,
+// is taken as it is.
+func realPath(path string) string {
+	path = filepath.Clean(path)
+	rest := ""
+	for {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			return filepath.Join(real, rest)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(path, rest)
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
+
+// relative returns path relative to the root, for the API's replies.
+func (s *server) relative(path string) string {
+	if rel, err := filepath.Rel(s.root, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// work runs queued jobs, one at a time, for as long as the server runs.
+func (s *server) work() {
+	for sj := range s.queue {
+		if !s.runs.do(func(ctx context.Context) { s.run(ctx, sj) }) {
+			return // the server is stopping
+		}
+	}
+}
+
+// run runs a job, keeping its status up to date as files finish.
+func (s *server) run(ctx context.Context, sj *serverJob) {
+	started := time.Now().UTC()
+	s.mu.Lock()
+	sj.status.State, sj.status.Started = jobRunning, &started
+	s.mu.Unlock()
+
+	j := sj.job
+	j.Progress = func(p gm.Progress) {
+		s.mu.Lock()
+		sj.status.Done, sj.status.Total = p.Done, p.Total
+		s.mu.Unlock()
+	}
+	o := job.Run(ctx, j, io.Discard) // no hooks, so no hook output
+	recordRun("serve", j.Name, j.Options(), o.Started, o.Duration, o.Result, o.Err)
+
+	finished := time.Now().UTC()
+	s.mu.Lock()
+	st := &sj.status
+	st.Finished = &finished
+	st.Processed, st.Skipped, st.Failed = o.Result.Processed, o.Result.Skipped, o.Result.Failed
+	st.BytesIn, st.BytesOut = o.Result.BytesIn, o.Result.BytesOut
+	st.Summary = o.Result.Summary()
+	st.State = jobOK
+	if o.Err != nil {
+		st.State, st.Error = jobFailed, o.Err.Error()
+		r := o.Result
+		r.Err = o.Err
+		st.Hints = gm.Suggest(r)
+	}
+	sj.files = o.Result.Files
+	s.mu.Unlock()
+
+	label := cmp.Or(j.Name, "job "+strconv.Itoa(st.ID))
+	if o.Err != nil {
+		fmt.Printf("✗ %s failed after %s: %v\n", label, humanize.Duration(o.Duration), o.Err)
+	} else {
+		fmt.Printf("✓ %s finished in %s\n  %s\n", label, humanize.Duration(o.Duration), o.Result.Summary())
+	}
+}
+
+// list returns every job submitted since the server started, oldest first.
+func (s *server) list(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	statuses := make([]jobStatus, len(s.jobs))
+	for i, sj := range s.jobs {
+		statuses[i] = sj.status
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// get returns one job's status and progress.
+func (s *server) get(w http.ResponseWriter, r *http.Request) {
+	sj := s.find(w, r)
+	if sj == nil {
+		return
+	}
+	s.mu.Lock()
+	status := sj.status
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, status)
+}
+
+// files returns a finished job's per-file report rows (see gm.ReportRow).
+func (s *server) files(w http.ResponseWriter, r *http.Request) {
+	sj := s.find(w, r)
+	if sj == nil {
+		return
+	}
+	s.mu.Lock()
+	state, files := sj.status.State, sj.files
+	s.mu.Unlock()
+	if state == jobQueued || state == jobRunning {
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %s", state))
+		return
+	}
+	if files == nil {
+		files = []gm.ReportRow{} // [] rather than null
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// output sends the file a finished job converted the image at path, a
+// source path as in its report rows, into.
+func (s *server) output(w http.ResponseWriter, r *http.Request) {
+	sj := s.find(w, r)
+	if sj == nil {
+		return
+	}
+	path := r.PathValue("path")
+	s.mu.Lock()
+	var row *gm.ReportRow
+	for i := range sj.files {
+		if filepath.ToSlash(sj.files[i].Path) == path && sj.files[i].Output != "" {
+			row = &sj.files[i]
+		}
+	}
+	s.mu.Unlock()
+	if row == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %d has no output for %s", sj.status.ID, path))
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(row.Dir, row.Output))
+}
+
+// find returns the job the request's {id} names, or writes a 404 and
+// returns nil.
+func (s *server) find(w http.ResponseWriter, r *http.Request) *serverJob {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || id < 1 || id > len(s.jobs) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %q", r.PathValue("id")))
+		return nil
+	}
+	return s.jobs[id-1]
+}
+
+// writeJSON replies with v as indented JSON.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// writeError replies with {"error": "..."}.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/config"
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+)
+
+// ---------------------------------------------------------------------------
+// First-run setup: shown instead of the form while there is no
+// configuration file
+// ---------------------------------------------------------------------------
+
+// setupState is the first-run setup's progress.
+type setupState struct {
+	path    string      // configuration file the setup creates
+	step    int         // index into setupSteps
+	helpers []gm.Helper // optional programs, as found at the start
+	output  int         // index into setupOutputs
+	sample  int         // 0 = add samplePreset, 1 = don't
+}
+
+// setupSteps are the setup's pages, by title.
+var setupSteps = []string{"What is installed", "Output", "Presets"}
+
+// setupOutputs are the config file's output values, as offered in step 2.
+var setupOutputs = []string{config.OutputPreserve, config.OutputAsk}
+
+var setupOutputLabels = []string{
+	"Always preserve originals  →  write to output/ folder",
+	"Ask every time  →  preserve or overwrite, before each run",
+}
+
+var setupSampleLabels = []string{
+	"Add a sample preset, to edit in the config file later",
+	"Keep just the built-in presets",
+}
+
+// samplePreset is the preset the setup offers to add, after the built-in
+// ones, as something to copy.
+var samplePreset = config.Preset{Name: "Sample 1600px q82", Resize: "1600x1600", Quality: 82, ResizeMode: "fit"}
+
+// findHelpers looks up the optional programs the setup lists.  Replay
+// swaps it out like gmCheck.
+var findHelpers = gm.Helpers
+
+// saveConfig writes the setup's answers and returns where to.  Replay
+// swaps it out so that sessions never touch the user's file.
+var saveConfig = config.Save
+
+// startSetup shows the first-run setup, which creates the configuration
+// file at path.
+func (m model) startSetup(path string) model {
+	m.state = stateSetup
+	m.setup = &setupState{path: path, helpers: findHelpers()}
+	return m
+}
+
+// updateSetup handles key events on the first-run setup.
+func (m model) updateSetup(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	s := *m.setup
+	m.setup = &s
+	var choice *int // the step's selector, if it has one
+	switch s.step {
+	case 1:
+		choice = &s.output
+	case 2:
+		choice = &s.sample
+	}
+	k := m.keys
+	switch {
+	case key.Matches(msg, k.Back):
+		m.state, m.setup = stateForm, nil
+		m.status = "Setup skipped; it is offered again next time"
+	case key.Matches(msg, k.Up) && choice != nil:
+		*choice = max(*choice-1, 0)
+	case key.Matches(msg, k.Down) && choice != nil:
+		*choice = min(*choice+1, 1)
+	case key.Matches(msg, k.Run):
+		if s.step < len(setupSteps)-1 {
+			s.step++
+			return m, nil
+		}
+		return m.finishSetup(), nil
+	}
+	return m, nil
+}
+
+// finishSetup saves the answers and moves on to the form, which takes them
+// into account straight away.
+func (m model) finishSetup() model {
+	s := m.setup
+	c := config.Config{Output: setupOutputs[s.output]}
+	if s.sample == 0 {
+		c.Presets = append(slices.Clone(config.DefaultPresets), samplePreset)
+	}
+	m.state, m.setup = stateForm, nil
+	path, err := saveConfig(c)
+	if err != nil {
+		m.status = "✗ " + err.Error()
+		return m
+	}
+	if c.Presets != nil {
+		m.presets = c.Presets
+	}
+	m.askOutput = c.Output == config.OutputAsk
+	m.status = "✓ Settings saved to " + path
+	return m
+}
+
+// viewSetup renders the current step of the first-run setup.
+func (m model) viewSetup() string {
+	s := m.setup
+	k := m.keys
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("Welcome to ImageSlim"))
+	b.WriteString("\n")
+	b.WriteString(subtitleStyle.Render("A few questions before the first run; they are only asked once."))
+	b.WriteString("\n\n")
+	b.WriteString(labelStyle.Render(fmt.Sprintf("Step %d of %d — %s", s.step+1, len(setupSteps), setupSteps[s.step])))
+	b.WriteString("\n")
+
+	next := "next"
+	switch s.step {
+	case 0:
+		if m.gmErr != nil {
+			b.WriteString(errorStyle.Render("  ✗  GraphicsMagick  " + m.gmErr.Error()))
+		} else {
+			b.WriteString(successStyle.Render("  ✓  GraphicsMagick"))
+		}
+		b.WriteString("\n")
+		for _, h := range s.helpers {
+			if h.Found {
+				b.WriteString(fmt.Sprintf("  %s  %-13s %s\n", successStyle.Render("✓"), h.Name, h.Use))
+			} else {
+				b.WriteString(fmt.Sprintf("  %s  %-13s %s\n", helpStyle.Render("–"), h.Name, helpStyle.Render(h.Use+"  ("+h.Install+")")))
+			}
+		}
+		b.WriteString("\n")
+		b.WriteString(helpStyle.Render("Only GraphicsMagick is required; the others are used when installed."))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render(fmt.Sprintf("[%s] %s   [%s] skip setup", keyHelp(k.Run), next, keyHelp(k.Back))))
+		return b.String()
+	case 1:
+		b.WriteString(renderOptions(setupOutputLabels, s.output, true))
+	case 2:
+		b.WriteString(renderOptions(setupSampleLabels, s.sample, true))
+		b.WriteString("\n")
+		b.WriteString(helpStyle.Render("Settings are saved to " + s.path))
+		b.WriteString("\n")
+		next = "save"
+	}
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render(fmt.Sprintf("[%s] choose   [%s] %s   [%s] skip setup",
+		keysHelp(k.Up, k.Down), keyHelp(k.Run), next, keyHelp(k.Back))))
+	return b.String()
+}
+
+// ---------------------------------------------------------------------------
+// Output question: with output: ask in the config file, runs started from
+// the form ask whether to preserve or overwrite
+// ---------------------------------------------------------------------------
+
+// askOutputMode puts the output question under the form.
+func (m model) askOutputMode() model {
+	m.asking = true
+	m.status = fmt.Sprintf("Preserve originals [%s] or overwrite in place [%s]?   [%s] cancel",
+		keyHelp(m.keys.Preserve), keyHelp(m.keys.Overwrite), keyHelp(m.keys.Back))
+	return m
+}
+
+// answerOutputMode handles the key that answers the output question.
+func (m model) answerOutputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Preserve):
+		m.outputMode = 0
+	case key.Matches(msg, m.keys.Overwrite):
+		m.outputMode = 1
+	case key.Matches(msg, m.keys.Back):
+		m.asking, m.status = false, ""
+		return m, nil
+	default:
+		return m, nil
+	}
+	m.asking = false
+	return m.confirmRun()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/config"
+)
+
+// ---------------------------------------------------------------------------
+// Theme: the TUI's colours, picked for the terminal's background and
+// overridden by the theme section of the configuration file
+// ---------------------------------------------------------------------------
+
+// palette is the set of colours every style is built from.
+type palette struct {
+	accent, success, error, warn, muted, dim string
+}
+
+// darkPalette and lightPalette are the built-in colours for dark and light
+// terminal backgrounds.
+var (
+	darkPalette = palette{
+		accent:  "#7D56F4",
+		success: "#04B575",
+		error:   "#FF4672",
+		warn:    "#FFAA00",
+		muted:   "#626262",
+		dim:     "#3D3D3D",
+	}
+	lightPalette = palette{
+		accent:  "#5A3FC0",
+		success: "#00875A",
+		error:   "#D1243C",
+		warn:    "#B35C00",
+		muted:   "#767676",
+		dim:     "#C6C6C6",
+	}
+)
+
+// darkBackground reports whether the terminal has a dark background.  It
+// asks the terminal the first time and remembers the answer, so the first
+// call has to happen before Bubble Tea takes over the terminal; replay
+// swaps it out like gmCheck.
+var darkBackground = lipgloss.HasDarkBackground
+
+// themePalette returns the colours for theme: the built-in ones for its
+// background, detected unless set, with its own colours on top.
+func themePalette(t config.Theme) palette {
+	var dark bool
+	switch t.Background {
+	case config.BackgroundDark:
+		dark = true
+	case config.BackgroundLight:
+		dark = false
+	default:
+		dark = darkBackground()
+	}
+	p := lightPalette
+	if dark {
+		p = darkPalette
+	}
+	for _, c := range []struct {
+		set  string
+		into *string
+	}{
+		{t.Accent, &p.accent}, {t.Success, &p.success}, {t.Error, &p.error},
+		{t.Warning, &p.warn}, {t.Muted, &p.muted},
+	} {
+		if c.set != "" {
+			*c.into = c.set
+		}
+	}
+	return p
+}
+
+// Lipgloss styles, set by applyTheme.
+var (
+	titleStyle        lipgloss.Style
+	subtitleStyle     lipgloss.Style
+	labelStyle        lipgloss.Style
+	focusedLabelStyle lipgloss.Style
+
+	// Left-border highlight for the focused text input, and a dim one for
+	// blurred inputs.
+	focusedInputStyle lipgloss.Style
+	blurredInputStyle lipgloss.Style
+
+	selectedModeStyle   lipgloss.Style
+	unselectedModeStyle lipgloss.Style
+
+	successStyle lipgloss.Style
+	errorStyle   lipgloss.Style
+	warningStyle lipgloss.Style
+	helpStyle    lipgloss.Style
+	cmdStyle     lipgloss.Style
+	spinnerStyle lipgloss.Style
+)
+
+// applyTheme builds every style from the colours for theme.
+func applyTheme(t config.Theme) {
+	p := themePalette(t)
+	accent, muted := lipgloss.Color(p.accent), lipgloss.Color(p.muted)
+
+	titleStyle = lipgloss.NewStyle().Bold(true).Foreground(accent)
+	subtitleStyle = lipgloss.NewStyle().Foreground(muted)
+	labelStyle = lipgloss.NewStyle().Foreground(muted)
+	focusedLabelStyle = lipgloss.NewStyle().Bold(true).Foreground(accent)
+
+	focusedInputStyle = lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder(), false, false, false, true).
+		BorderForeground(accent).
+		PaddingLeft(1)
+	blurredInputStyle = lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder(), false, false, false, true).
+		BorderForeground(lipgloss.Color(p.dim)).
+		PaddingLeft(1)
+
+	selectedModeStyle = lipgloss.NewStyle().Bold(true).Foreground(accent)
+	unselectedModeStyle = lipgloss.NewStyle().Foreground(muted)
+
+	successStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(p.success))
+	errorStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(p.error))
+	warningStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(p.warn))
+	helpStyle = lipgloss.NewStyle().Foreground(muted)
+	cmdStyle = lipgloss.NewStyle().Foreground(muted).Italic(true)
+	spinnerStyle = lipgloss.NewStyle().Foreground(accent)
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/config"
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+	"github.com/brunovpinheiro/ImageSlim/internal/job"
+)
+
+// ---------------------------------------------------------------------------
+// Interface options
+// ---------------------------------------------------------------------------
+
+// uiOptions are launch-time settings that change how the TUI looks and
+// behaves, independent of the job being run.
+type uiOptions struct {
+	// reducedMotion replaces the animated spinner with a static status line
+	// and stops the text cursor from blinking, for users with vestibular
+	// sensitivities or on slow SSH links where every repaint costs.
+	reducedMotion bool
+
+	// spinner names the progress animation; see spinnerStyles.
+	spinner string
+
+	// plain replaces the full-screen TUI with line-based prompts; see
+	// runPlain.
+	plain bool
+
+	// pager leaves the full output of finished runs to $PAGER when the TUI
+	// exits, instead of the done screen's viewport; see pager.go.
+	pager bool
+
+	// record is a trace file capturing the session for bug reports; see
+	// record.go.
+	record string
+
+	// gmPath overrides gm binary discovery (see gm.Options.GMPath).
+	gmPath string
+
+	// gmVersion pins the gm version, overriding a job's (see
+	// gm.Options.GMVersion).
+	gmVersion string
+
+	// sets override configuration settings, as "setting=value"; see
+	// config.FlagLayer.
+	sets []string
+
+	// safeMode keeps the originals out of reach: no overwrite mode, hooks
+	// or notify commands; see safe.go.
+	safeMode bool
+}
+
+// defaultSpinner is the braille spinner the TUI has always used.
+const defaultSpinner = "braille"
+
+// spinnerStyles are the selectable progress animations.
+var spinnerStyles = map[string]spinner.Spinner{
+	defaultSpinner: {
+		Frames: []string{"⣾", "⣽", "⣻", "⢿", "⡿", "⣟", "⣯", "⣷"},
+		FPS:    time.Second / 10,
+	},
+	"dot":      spinner.Dot,
+	"line":     spinner.Line,
+	"points":   spinner.Points,
+	"pulse":    spinner.Pulse,
+	"meter":    spinner.Meter,
+	"ellipsis": spinner.Ellipsis,
+}
+
+// spinnerNames returns the spinner style names in alphabetical order.
+func spinnerNames() []string {
+	names := make([]string, 0, len(spinnerStyles))
+	for n := range spinnerStyles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// register adds the interface flags to fs.  Defaults come from the
+// IMAGESLIM_REDUCED_MOTION and IMAGESLIM_SPINNER environment variables so
+// the preference can be set once in a shell profile.
+func (u *uiOptions) register(fs *flag.FlagSet) {
+	reduced := os.Getenv("IMAGESLIM_REDUCED_MOTION")
+	spin := os.Getenv("IMAGESLIM_SPINNER")
+	if spin == "" {
+		spin = defaultSpinner
+	}
+	fs.BoolVar(&u.reducedMotion, "reduced-motion", reduced != "" && reduced != "0",
+		"no animations: static status line instead of a spinner, no cursor blink")
+	fs.StringVar(&u.spinner, "spinner", spin,
+		"progress `animation`: "+strings.Join(spinnerNames(), ", "))
+	fs.BoolVar(&u.plain, "plain", false, "line-based prompts and plain text output instead of the full-screen TUI")
+	fs.BoolVar(&u.pager, "pager", false, "show the full output of finished runs in $PAGER (or less) on exit, instead of scrolling it on the done screen")
+	fs.StringVar(&u.record, "record", "", "record the session to a trace `file` for bug reports (replay with imageslim replay)")
+	registerGMPath(fs, &u.gmPath)
+	registerGMVersion(fs, &u.gmVersion)
+	registerSet(fs, &u.sets)
+	registerSafeMode(fs, &u.safeMode)
+}
+
+// registerGMPath adds the -gm-path flag to fs.  The IMAGESLIM_GM_PATH
+// environment variable is read by the gm package itself, so it also applies
+// when the flag is absent.
+func registerGMPath(fs *flag.FlagSet, p *string) {
+	fs.StringVar(p, "gm-path", "", "gm `executable` to use instead of searching PATH (or "+gm.PathEnv+")")
+}
+
+// registerGMVersion adds the -gm-version flag to fs.  Like
+// IMAGESLIM_GM_PATH, IMAGESLIM_GM_VERSION is read by the gm package.
+func registerGMVersion(fs *flag.FlagSet, p *string) {
+	fs.StringVar(p, "gm-version", "", "refuse to run unless gm is this `version`, e.g. 1.3.42 or 1.3, or any to override a job's (or "+gm.VersionEnv+")")
+}
+
+// jobOverrides are flags of "run" and "batch" that replace a setting of every
+// job being run.  Empty fields leave the jobs' own settings alone.
+type jobOverrides struct {
+	interlace string
+	subsample string
+	sharpen   string
+	watermark string
+	name      string
+	workers   string
+	minSize   string
+	minDims   string
+	after     string
+	before    string
+	target    string
+	smart     bool
+	png       string
+	format    string
+	effort    int
+	perDir    int
+	animated  string
+	report    string
+	repFormat string
+	baseline  string
+	tolerance int
+	timeout   string
+	lossless  bool
+	exec      string
+	dropAlpha bool
+	srgb      bool
+	rotate    string
+	flip      bool
+	flop      bool
+	tone      string
+	colors    int
+	dither    bool
+	noSpace   bool
+	fallback  string
+	sample    int
+	seed      int64
+	symlinks  string
+	verify    string
+	minPSNR   string
+	conflict  string
+	link      string
+	stop      bool
+	hashCache bool
+	trash     bool
+	upscale   bool
+	bg        string
+}
+
+// register adds the override flags to fs.
+func (o *jobOverrides) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.interlace, "interlace", "", "progressive JPEG `mode`: line, plane or none (default: as in the job)")
+	fs.StringVar(&o.subsample, "subsampling", "", "JPEG chroma `subsampling`: 4:4:4 keeps text and lines in screenshots sharp, 4:2:2, 4:2:0, or auto (default: as in the job)")
+	fs.StringVar(&o.sharpen, "sharpen", "", "sharpen after resizing: on, off or an unsharp `geometry` (default: as in the job)")
+	fs.StringVar(&o.png, "png-optimize", "", "PNG optimisation `mode`: lossless (optipng), lossy (pngquant) or off (default: as in the job)")
+	fs.StringVar(&o.animated, "animated-gif", "", "animated GIF `handling`: keep (resize every frame) or skip (default: as in the job)")
+	fs.StringVar(&o.format, "format", "", "convert every file to `format` webp or avif, or original (default: as in the job)")
+	fs.IntVar(&o.effort, "effort", 0, "WebP/AVIF encoding `effort` from 1 (fastest) to 10 (smallest files) (default: as in the job)")
+	fs.BoolVar(&o.upscale, "upscale", false, "enlarge images smaller than the resize box too (default: as in the job)")
+	fs.StringVar(&o.bg, "background", "", "`colour` pad fills the canvas with: a name like white, transparent, or hex like #f5f5f5 (default: as in the job)")
+	fs.StringVar(&o.workers, "workers", "", "files converted at once: a `number` or auto (default: as in the job)")
+	fs.IntVar(&o.perDir, "per-directory", 0, "at most `n` files from the same directory at once (default: as in the job)")
+	fs.StringVar(&o.target, "target-size", "", "lower the quality until each JPEG is at most `size`, e.g. 300KB, or none (default: as in the job)")
+	fs.BoolVar(&o.smart, "smart-quality", false, "pick each JPEG's quality, up to the job's, by comparing it with a lossless rendering (default: as in the job)")
+	fs.StringVar(&o.minSize, "min-size", "", "leave files smaller than `size` alone, e.g. 500KB, or none (default: as in the job)")
+	fs.StringVar(&o.minDims, "min-dimensions", "", "leave images smaller than `WxH` alone, e.g. 2000x, or none (default: as in the job)")
+	fs.StringVar(&o.after, "modified-after", "", "only files modified on or after `date`, e.g. 2026-09-15 or 30d, or none (default: as in the job)")
+	fs.StringVar(&o.before, "modified-before", "", "only files modified before `date`, e.g. 2026-10-01 or 7d, or none (default: as in the job)")
+	fs.StringVar(&o.name, "name-template", "", "output file `template` in preserve mode, e.g. {name}_web.{ext}, or none (default: as in the job)")
+	fs.StringVar(&o.conflict, "on-conflict", "", "what to do when an output file is already there in preserve mode: overwrite, skip, or rename with a numeric suffix (default: as in the job)")
+	fs.StringVar(&o.link, "link-skipped", "", "put the files preserve mode leaves alone in output/ as they are: hardlink, reflink, copy, or none (default: as in the job)")
+	fs.StringVar(&o.report, "report", "", "write a row per file to `file` as each one finishes, in the format -report-format or its extension picks, or none (default: as in the job)")
+	fs.StringVar(&o.repFormat, "report-format", "", "report `format`: csv, tsv, json, markdown, html, junit, or auto to go by the extension (default: as in the job)")
+	fs.StringVar(&o.baseline, "baseline", "", "baseline `mode`: check the run against the directory's baseline, save it as the baseline, or off (default: as in the job)")
+	fs.IntVar(&o.tolerance, "baseline-tolerance", 0, "percentage `points` the savings or failure rate may stray from the baseline (default: as in the job, or 10)")
+	fs.StringVar(&o.watermark, "watermark", "", "overlay `image` stamped onto every file, or none (default: as in the job)")
+	fs.BoolVar(&o.lossless, "lossless", false, "only strip metadata and optimise the coding of JPEGs and PNGs, keeping every pixel (default: as in the job)")
+	fs.StringVar(&o.exec, "exec", "", "shell `command` that converts each file instead of gm, e.g. 'cwebp -q 80 {in} -o {out}', or none (default: as in the job)")
+	fs.IntVar(&o.colors, "max-colors", 0, "reduce every image to at most `n` colours, from 2 to 256 (default: as in the job)")
+	fs.BoolVar(&o.dither, "dither", false, "dither when reducing colours, which hides banding in gradients (default: as in the job)")
+	fs.BoolVar(&o.dropAlpha, "drop-alpha", false, "remove alpha channels in which every pixel is opaque from PNGs and WebPs (default: as in the job)")
+	fs.StringVar(&o.tone, "tone", "", "stylised `variant` of every image: grayscale, sepia, or none (default: as in the job)")
+	fs.StringVar(&o.rotate, "rotate", "", "turn every image clockwise by `degrees` 90, 180 or 270, auto to turn landscape images to portrait, or none (default: as in the job)")
+	fs.BoolVar(&o.flip, "flip", false, "mirror every image top to bottom (default: as in the job)")
+	fs.BoolVar(&o.flop, "flop", false, "mirror every image left to right (default: as in the job)")
+	fs.BoolVar(&o.srgb, "srgb", false, "convert images with another colour profile, such as Adobe RGB or CMYK, to sRGB (default: as in the job)")
+	fs.StringVar(&o.timeout, "timeout", "", "stop the run after `duration`, e.g. 90m or 2h, killing gm, or none (default: as in the job)")
+	fs.BoolVar(&o.noSpace, "ignore-disk-space", false, "run in preserve mode even when the disk seems too full for the output (default: as in the job)")
+	fs.IntVar(&o.sample, "sample-size", 0, "`number` of files the banding check and approval exports look at (default: as in the job)")
+	fs.Int64Var(&o.seed, "seed", 0, "shuffle the files samples are picked from with `number`, the same way every time (default: as in the job)")
+	fs.StringVar(&o.symlinks, "follow-symlinks", "", "what to do with symbolic links: skip, follow, or once to convert each linked file once (default: as in the job)")
+	fs.StringVar(&o.verify, "verify", "", "read every output back: header, full (decode it all), or none (default: as in the job)")
+	fs.StringVar(&o.minPSNR, "min-psnr", "", "warn about outputs whose PSNR against a lossless rendering is below `decibels`, e.g. 35, or none (default: as in the job)")
+	fs.BoolVar(&o.stop, "stop-on-error", false, "stop at the first file that fails rather than converting the others (default: as in the job)")
+	fs.BoolVar(&o.hashCache, "hash-cache", false, "hash sources and outputs, so files whose contents are unchanged are skipped even when touched (default: as in the job)")
+	fs.BoolVar(&o.trash, "trash", false, "in overwrite mode, keep the originals this run replaces so \"imageslim undo\" can put them back (default: as in the job)")
+	fs.StringVar(&o.fallback, "fallback", "", "`program` that retries the files gm fails on: magick, or none (default: as in the job)")
+}
+
+// validate checks the override values before any job is loaded.
+func (o jobOverrides) validate() error {
+	if _, err := gm.ParseInterlace(o.interlace); err != nil {
+		return err
+	}
+	if _, err := gm.ParseSubsampling(o.subsample); err != nil {
+		return err
+	}
+	if _, err := gm.ParseSharpen(o.sharpen); err != nil {
+		return err
+	}
+	if _, err := gm.ParsePNGOptimize(o.png); err != nil {
+		return err
+	}
+	if _, err := gm.ParseOutputFormat(o.format); err != nil {
+		return err
+	}
+	if _, err := gm.ParseBackground(o.bg); err != nil {
+		return err
+	}
+	if _, err := gm.ParseTone(o.tone); err != nil {
+		return err
+	}
+	if _, err := gm.ParseRotate(o.rotate); err != nil {
+		return err
+	}
+	if _, err := gm.ParseAnimatedGIF(o.animated); err != nil {
+		return err
+	}
+	if o.effort < 0 || o.effort > gm.MaxEffort {
+		return fmt.Errorf("effort must be between 1 and %d, got %d", gm.MaxEffort, o.effort)
+	}
+	if _, err := gm.ParseWorkers(o.workers); err != nil {
+		return err
+	}
+	if o.perDir < 0 || o.perDir > gm.MaxWorkers {
+		return fmt.Errorf("files per directory must be a number from 1 to %d, got %d", gm.MaxWorkers, o.perDir)
+	}
+	if o.colors != 0 && (o.colors < 2 || o.colors > gm.MaxColorCount) {
+		return fmt.Errorf("maximum colours must be between 2 and %d, got %d", gm.MaxColorCount, o.colors)
+	}
+	if _, err := gm.ParseBaseline(o.baseline); err != nil {
+		return err
+	}
+	if _, err := gm.ParseTimeout(o.timeout); err != nil {
+		return err
+	}
+	if _, err := gm.ParseFallback(o.fallback); err != nil {
+		return err
+	}
+	if _, err := gm.ParseSymlinks(o.symlinks); err != nil {
+		return err
+	}
+	if _, err := gm.ParseVerify(o.verify); err != nil {
+		return err
+	}
+	if _, err := gm.ParseMinPSNR(o.minPSNR); err != nil {
+		return err
+	}
+	if _, err := gm.ParseConflict(o.conflict); err != nil {
+		return err
+	}
+	if _, err := gm.ParseLinkSkipped(o.link); err != nil {
+		return err
+	}
+	if _, err := gm.ParseReportFormat(o.repFormat); err != nil {
+		return err
+	}
+	if o.sample < 0 {
+		return fmt.Errorf("sample size must be a positive number of files, got %d", o.sample)
+	}
+	if o.tolerance < 0 || o.tolerance > 100 {
+		return fmt.Errorf("baseline tolerance must be between 1 and 100 percentage points, got %d", o.tolerance)
+	}
+	if _, err := gm.ParseFileSize(o.target); err != nil {
+		return fmt.Errorf("target size: %w", err)
+	}
+	if _, err := gm.ParseFileSize(o.minSize); err != nil {
+		return err
+	}
+	if _, _, err := gm.ParseMinDimensions(o.minDims); err != nil {
+		return err
+	}
+	if _, err := gm.ParseDate(o.after, time.Now()); err != nil {
+		return fmt.Errorf("modified after: %w", err)
+	}
+	if _, err := gm.ParseDate(o.before, time.Now()); err != nil {
+		return fmt.Errorf("modified before: %w", err)
+	}
+	if o.watermark != "" && o.watermark != "none" {
+		if _, err := os.Stat(o.watermark); err != nil {
+			return fmt.Errorf("watermark: %w", err)
+		}
+	}
+	return nil
+}
+
+// apply writes the overrides into j.
+func (o jobOverrides) apply(j *job.Job) {
+	if o.interlace != "" {
+		j.Interlace = o.interlace
+	}
+	if o.subsample != "" {
+		j.Subsampling = o.subsample
+	}
+	if o.sharpen != "" {
+		j.Sharpen = o.sharpen
+	}
+	if o.png != "" {
+		j.PNGOptimize = o.png
+	}
+	if o.format != "" {
+		j.Format = o.format
+	}
+	if o.animated != "" {
+		j.AnimatedGIF = o.animated
+	}
+	if o.effort != 0 {
+		j.Effort = o.effort
+	}
+	if o.workers != "" {
+		j.Workers = o.workers
+	}
+	if o.perDir != 0 {
+		j.PerDirectory = o.perDir
+	}
+	if o.target != "" {
+		j.TargetSize = o.target
+	}
+	if o.baseline != "" {
+		j.Baseline = o.baseline
+	}
+	if o.tolerance != 0 {
+		j.BaselineTolerance = o.tolerance
+	}
+	if o.timeout != "" {
+		j.Timeout = o.timeout
+	}
+	if o.lossless {
+		j.Lossless = true
+	}
+	if o.smart {
+		j.SmartQuality = true
+	}
+	switch o.exec {
+	case "":
+	case "none":
+		j.Exec = ""
+	default:
+		j.Exec = o.exec
+	}
+	if o.dropAlpha {
+		j.DropAlpha = true
+	}
+	if o.srgb {
+		j.ConvertToSRGB = true
+	}
+	if o.tone != "" {
+		j.Tone = o.tone
+	}
+	if o.rotate != "" {
+		j.Rotate = o.rotate
+	}
+	if o.flip {
+		j.Flip = true
+	}
+	if o.flop {
+		j.Flop = true
+	}
+	if o.colors != 0 {
+		j.MaxColors = o.colors
+	}
+	if o.dither {
+		j.Dither = true
+	}
+	if o.noSpace {
+		j.IgnoreDiskSpace = true
+	}
+	if o.fallback != "" {
+		j.Fallback = o.fallback
+	}
+	if o.symlinks != "" {
+		j.FollowSymlinks = o.symlinks
+	}
+	if o.verify != "" {
+		j.Verify = o.verify
+	}
+	if o.minPSNR != "" {
+		j.MinPSNR = o.minPSNR
+	}
+	if o.conflict != "" {
+		j.OnConflict = o.conflict
+	}
+	if o.link != "" {
+		j.LinkSkipped = o.link
+	}
+	if o.stop {
+		j.StopOnError = true
+	}
+	if o.hashCache {
+		j.HashCache = true
+	}
+	if o.trash {
+		j.Trash = true
+	}
+	if o.upscale {
+		j.Upscale = true
+	}
+	if o.bg != "" {
+		j.Background = o.bg
+	}
+	if o.sample != 0 {
+		j.SampleSize = o.sample
+	}
+	if o.seed != 0 {
+		j.Seed = o.seed
+	}
+	if o.minSize != "" {
+		j.MinFileSize = o.minSize
+	}
+	if o.minDims != "" {
+		j.MinDimensions = o.minDims
+	}
+	if o.after != "" {
+		j.ModifiedAfter = o.after
+	}
+	if o.before != "" {
+		j.ModifiedBefore = o.before
+	}
+	switch o.name {
+	case "":
+	case "none":
+		j.NameTemplate = ""
+	default:
+		j.NameTemplate = o.name
+	}
+	switch o.report {
+	case "":
+	case "none":
+		j.Report = ""
+	default:
+		// Relative to the working directory, not to the job file.
+		report, _ := filepath.Abs(o.report)
+		j.Report = report
+	}
+	if o.repFormat != "" {
+		j.ReportFormat = o.repFormat
+	}
+	switch o.watermark {
+	case "":
+	case "none":
+		j.Watermark = job.Watermark{}
+	default:
+		// Relative to the working directory, not to the job file.
+		image, _ := filepath.Abs(o.watermark)
+		j.Watermark.Image = image
+	}
+}
+
+// validate checks that the options name known styles.
+func (u uiOptions) validate() error {
+	if _, ok := spinnerStyles[u.spinner]; !ok {
+		return fmt.Errorf("unknown spinner %q (choose from %s)", u.spinner, strings.Join(spinnerNames(), ", "))
+	}
+	if _, err := gm.ParseVersion(u.gmVersion); err != nil {
+		return err
+	}
+	_, err := config.FlagLayer(u.sets)
+	return err
+}
+
+// applyInput configures a text input for the chosen motion preference.
+func (u uiOptions) applyInput(in *textinput.Model) {
+	if u.reducedMotion {
+		in.Cursor.SetMode(cursor.CursorStatic)
+	}
+}
+
+// blink returns the command that starts cursor blinking, or nil when motion
+// is reduced.
+func (u uiOptions) blink() tea.Cmd {
+	if u.reducedMotion {
+		return nil
+	}
+	return textinput.Blink
+}
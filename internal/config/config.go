@@ -0,0 +1,411 @@
+// Package config reads the user's ImageSlim configuration file: settings
+// that belong to the person rather than to a job, such as the named presets
+// offered at the top of the TUI form, its output naming schemes, the format
+// of the reports it saves and the TUI's key bindings.
+//
+// The file is YAML, in the user's configuration directory:
+//
+//	output: ask        # preserve | ask
+//	report_format: html  # csv | tsv | json | markdown | html | junit
+//	presets:
+//	  - name: Web 1200px q80
+//	    resize: 1200x1200
+//	    quality: 80
+//	  - name: Print
+//	    resize: 3000x3000
+//	    quality: 92
+//	    resize_mode: fit     # fit | fill | pad
+//	    format: original     # webp | avif | original
+//	    interlace: false     # the form's checkboxes
+//	    auto_orient: true
+//	    sharpen: true
+//	  - name: Blog pipeline
+//	    steps:               # convert in steps, in this order; see gm.ParseStep
+//	      - auto_orient
+//	      - resize 1600x1600
+//	      - watermark ~/logo.png if landscape
+//	      - optimize lossy
+//	naming:
+//	  - name: Blog
+//	    template: "{taken}-{name}_blog.{ext}"   # see gm.NameVars
+//	theme:
+//	  background: auto   # auto | light | dark
+//	  accent: "#005FAF"  # also success, error, warning and muted
+//	keys:
+//	  up: [k, up]
+//	  down: [j, down]
+//	  quit: [q, x]
+//	safe_mode: true    # no overwrite mode, hooks or orient; see Locked
+//
+// Fields a preset leaves out keep whatever the form shows.  Without a file,
+// or without presets in it, DefaultPresets are offered; naming schemes work
+// the same way, with DefaultNaming.  Keys maps a TUI
+// action to the keys that trigger it, replacing its default keys; an empty
+// list unbinds the action.  The TUI knows which actions there are.  Output
+// says whether runs started from the form ask for the output mode first.
+// ReportFormat is the format of the per-file reports the TUI saves after a
+// run, DefaultReportFormat when empty.
+// Theme changes the TUI's colours, which otherwise suit the background the
+// terminal reports.  SafeMode turns off everything that changes or runs
+// more than a conversion's output, as the -safe-mode flag does; a file
+// that sets it cannot be overruled, see Locked.
+//
+// Settings can also come from a system-wide file, a project's file, the
+// environment and -set flags; see Layers for the order in which they take
+// precedence, and Merge for how they combine.
+//
+// The TUI's first-run setup writes the user's file with Save.  "imageslim serve"
+// applies its presets to submitted jobs and rereads it when it changes;
+// Changes says what differs.
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/geometry"
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+)
+
+// PathEnv names an alternative user configuration file.
+const PathEnv = "IMAGESLIM_CONFIG"
+
+// Config is the contents of the configuration file.
+type Config struct {
+	Output       string              `yaml:"output,omitempty"` // OutputPreserve or OutputAsk; empty is OutputPreserve
+	ReportFormat string              `yaml:"report_format,omitempty"`
+	Presets      []Preset            `yaml:"presets,omitempty"`
+	Naming       []Naming            `yaml:"naming,omitempty"`
+	Theme        Theme               `yaml:"theme,omitempty"`
+	Keys         map[string][]string `yaml:"keys,omitempty"`      // action → keys, as bubbletea names them
+	SafeMode     bool                `yaml:"safe_mode,omitempty"` // see Locked
+}
+
+// DefaultReportFormat is the format of the reports the TUI saves when the
+// file does not pick one.
+const DefaultReportFormat = gm.ReportCSV
+
+// Theme overrides the TUI's colours.  Colours are "#RRGGBB" (or "#RGB") or
+// an ANSI colour number from 0 to 255; empty ones keep the built-in colour
+// for the background.
+type Theme struct {
+	Background string `yaml:"background,omitempty"` // BackgroundAuto, BackgroundLight or BackgroundDark
+	Accent     string `yaml:"accent,omitempty"`
+	Success    string `yaml:"success,omitempty"`
+	Error      string `yaml:"error,omitempty"`
+	Warning    string `yaml:"warning,omitempty"`
+	Muted      string `yaml:"muted,omitempty"`
+}
+
+// Background values: whether the built-in colours are picked for a light
+// or dark terminal.
+const (
+	BackgroundAuto  = "auto" // ask the terminal; the default
+	BackgroundLight = "light"
+	BackgroundDark  = "dark"
+)
+
+// hexColor matches the hex colours a Theme accepts.
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Validate checks the theme's background and colours.
+func (t Theme) Validate() error {
+	switch t.Background {
+	case "", BackgroundAuto, BackgroundLight, BackgroundDark:
+	default:
+		return fmt.Errorf("theme: background must be %s, %s or %s, got %q", BackgroundAuto, BackgroundLight, BackgroundDark, t.Background)
+	}
+	for _, c := range []struct{ name, value string }{
+		{"accent", t.Accent}, {"success", t.Success}, {"error", t.Error}, {"warning", t.Warning}, {"muted", t.Muted},
+	} {
+		if c.value == "" || hexColor.MatchString(c.value) {
+			continue
+		}
+		if n, err := strconv.Atoi(c.value); err == nil && n >= 0 && n <= 255 {
+			continue
+		}
+		return fmt.Errorf("theme: %s must be a colour like \"#7D56F4\" or an ANSI number from 0 to 255, got %q", c.name, c.value)
+	}
+	return nil
+}
+
+// Output values: what happens to the output mode when a run starts.
+const (
+	OutputPreserve = "preserve" // run with the mode the form shows, preserve by default
+	OutputAsk      = "ask"      // ask preserve or overwrite before each run
+)
+
+// Preset is a named set of form settings.  Empty fields leave the form's
+// value alone.
+type Preset struct {
+	Name       string `yaml:"name" json:"name"`
+	Resize     string `yaml:"resize,omitempty" json:"resize,omitempty"`
+	Quality    int    `yaml:"quality,omitempty" json:"quality,omitempty"`
+	ResizeMode string `yaml:"resize_mode,omitempty" json:"resize_mode,omitempty"`
+	Format     string `yaml:"format,omitempty" json:"format,omitempty"`
+	Interlace  *bool  `yaml:"interlace,omitempty" json:"interlace,omitempty"`
+	AutoOrient *bool  `yaml:"auto_orient,omitempty" json:"auto_orient,omitempty"`
+	Sharpen    *bool  `yaml:"sharpen,omitempty" json:"sharpen,omitempty"`
+
+	// Steps converts each file in a pipeline of steps instead of one gm
+	// invocation; see gm.Options.Steps.  Watermark images are relative to
+	// the directory being converted; ~ and $VARS expand.
+	Steps []string `yaml:"steps,omitempty" json:"steps,omitempty"`
+}
+
+// Naming is a named output name template, offered by the form's naming
+// selector so that templates need not be typed into every job.
+type Naming struct {
+	Name     string `yaml:"name" json:"name"`
+	Template string `yaml:"template" json:"template"`
+}
+
+// DefaultNaming is offered when the configuration file defines no naming
+// schemes.
+var DefaultNaming = []Naming{
+	{Name: "Suffix _web", Template: "{name}_web.{ext}"},
+	{Name: "Size in the name", Template: "{name}-{width}x{height}.{ext}"},
+	{Name: "Content hash", Template: "{name}-{hash}.{ext}"},
+	{Name: "Date taken", Template: "{taken}_{name}.{ext}"},
+}
+
+// Validate checks the naming scheme's template.
+func (n Naming) Validate() error {
+	if strings.TrimSpace(n.Name) == "" {
+		return errors.New("naming scheme without a name")
+	}
+	if err := gm.ValidateNameTemplate(n.Template); err != nil {
+		return fmt.Errorf("naming scheme %q: %w", n.Name, err)
+	}
+	return nil
+}
+
+// DefaultPresets are offered when the configuration file defines none.
+var DefaultPresets = []Preset{
+	{Name: "Web 1200px q80", Resize: "1200x1200", Quality: 80},
+	{Name: "Thumbs 400px q70", Resize: "400x400", Quality: 70},
+	{Name: "Archive 3000px q90", Resize: "3000x3000", Quality: 90},
+}
+
+// Validate checks the preset's fields.
+func (p Preset) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("preset without a name")
+	}
+	if p.Resize != "" {
+		if _, err := geometry.Parse(p.Resize); err != nil {
+			return fmt.Errorf("preset %q: %w", p.Name, err)
+		}
+	}
+	if p.Quality != 0 && (p.Quality < 1 || p.Quality > 100) {
+		return fmt.Errorf("preset %q: quality must be between 1 and 100, got %d", p.Name, p.Quality)
+	}
+	if _, err := gm.ParseResizeMode(p.ResizeMode); err != nil {
+		return fmt.Errorf("preset %q: %w", p.Name, err)
+	}
+	if _, err := gm.ParseOutputFormat(p.Format); err != nil {
+		return fmt.Errorf("preset %q: %w", p.Name, err)
+	}
+	if _, err := gm.ParseSteps(p.Steps); err != nil {
+		return fmt.Errorf("preset %q: %w", p.Name, err)
+	}
+	return nil
+}
+
+// Path returns the user's configuration file: $IMAGESLIM_CONFIG, or
+// config.yaml in the user's configuration directory.
+func Path() (string, error) {
+	if p := os.Getenv(PathEnv); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "imageslim", "config.yaml"), nil
+}
+
+// Load reads and validates every layer (see Layers) and merges them (see
+// Merge); sets are the values of -set flags.  Missing files are not an
+// error.  The returned Config always has presets and naming schemes: the
+// defaults unless a layer lists its own, and also when a layer cannot be
+// used.  Output, the report format, the theme and keys are only returned
+// when every layer is usable; SafeMode always is.
+func Load(sets ...string) (Config, error) {
+	layers, err := Layers(sets)
+	if err != nil {
+		_, locked := Locked()
+		return Config{Presets: DefaultPresets, Naming: DefaultNaming, SafeMode: locked}, err
+	}
+	c, _ := Merge(layers)
+	return c, nil
+}
+
+// readFile reads and validates the configuration file at path.
+func readFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true) // a misspelt field would silently do nothing
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// validate checks the settings one layer gives, and spells its report
+// format the way gm.ParseReportFormat does.
+func (c *Config) validate() error {
+	for _, pr := range c.Presets {
+		if err := pr.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, n := range c.Naming {
+		if err := n.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Output != "" && c.Output != OutputPreserve && c.Output != OutputAsk {
+		return fmt.Errorf("output must be %s or %s, got %q", OutputPreserve, OutputAsk, c.Output)
+	}
+	reportFormat, err := gm.ParseReportFormat(c.ReportFormat)
+	if err != nil {
+		return err
+	}
+	c.ReportFormat = reportFormat
+	if err := c.Theme.Validate(); err != nil {
+		return err
+	}
+	for action, keys := range c.Keys {
+		if slices.Contains(keys, "") {
+			return fmt.Errorf("keys: %s: empty key", action)
+		}
+	}
+	return nil
+}
+
+// Locked reports whether one of the Files sets safe_mode, and which.  A
+// file that does locks safe mode on: no later layer can turn it off, and
+// the file is read leniently, so that a mistake elsewhere in it does not
+// unlock what it guards.  An administrator sets it in the system-wide file
+// of a machine that holds a photo archive, or a project in its own file.
+func Locked() (string, bool) {
+	for _, p := range Files() {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		var c struct {
+			SafeMode bool `yaml:"safe_mode"`
+		}
+		if yaml.Unmarshal(data, &c) == nil && c.SafeMode {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// Exists reports whether there is a configuration file, the user's or one
+// of the other Files.  Without a configuration directory it reports true:
+// there is nowhere to create one.
+func Exists() bool {
+	if _, err := Path(); err != nil {
+		return true
+	}
+	for _, p := range Files() {
+		if _, err := os.Stat(p); !errors.Is(err, fs.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
+
+// Save writes c to the configuration file, creating its directory, and
+// returns the file's path.
+func Save(c Config) (string, error) {
+	p, err := Path()
+	if err != nil {
+		return "", err
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	data = append([]byte("# ImageSlim settings: presets, key bindings and output behaviour; see the README.\n"), data...)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// Changes describes how next differs from c, one line per difference, e.g.
+// `preset "Print" changed`: presets and naming schemes by name, in next's
+// order followed by those removed, then the output setting, report
+// format, theme, keys and safe mode.  It is empty when nothing changed.
+func (c Config) Changes(next Config) []string {
+	var lines []string
+	lines = append(lines, changed("preset", c.Presets, next.Presets, func(p Preset) string { return p.Name })...)
+	lines = append(lines, changed("naming scheme", c.Naming, next.Naming, func(n Naming) string { return n.Name })...)
+	if c.Output != next.Output {
+		lines = append(lines, fmt.Sprintf("output %s → %s", cmp.Or(c.Output, OutputPreserve), cmp.Or(next.Output, OutputPreserve)))
+	}
+	if c.ReportFormat != next.ReportFormat {
+		lines = append(lines, fmt.Sprintf("report format %s → %s", cmp.Or(c.ReportFormat, DefaultReportFormat), cmp.Or(next.ReportFormat, DefaultReportFormat)))
+	}
+	if c.Theme != next.Theme {
+		lines = append(lines, "theme changed")
+	}
+	if !reflect.DeepEqual(c.Keys, next.Keys) {
+		lines = append(lines, "key bindings changed")
+	}
+	switch {
+	case next.SafeMode && !c.SafeMode:
+		lines = append(lines, "safe mode on")
+	case c.SafeMode && !next.SafeMode:
+		lines = append(lines, "safe mode off")
+	}
+	return lines
+}
+
+// changed compares two lists of named items, as Changes describes.
+func changed[T any](kind string, old, next []T, name func(T) string) []string {
+	var lines []string
+	for _, n := range next {
+		i := slices.IndexFunc(old, func(o T) bool { return name(o) == name(n) })
+		switch {
+		case i < 0:
+			lines = append(lines, fmt.Sprintf("%s %q added", kind, name(n)))
+		case !reflect.DeepEqual(old[i], n):
+			lines = append(lines, fmt.Sprintf("%s %q changed", kind, name(n)))
+		}
+	}
+	for _, o := range old {
+		if !slices.ContainsFunc(next, func(n T) bool { return name(n) == name(o) }) {
+			lines = append(lines, fmt.Sprintf("%s %q removed", kind, name(o)))
+		}
+	}
+	return lines
+}
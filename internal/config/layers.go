@@ -0,0 +1,245 @@
+package config
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Layers: system, user and project files, the environment and -set flags
+// ---------------------------------------------------------------------------
+
+// SystemPathEnv names an alternative system-wide configuration file.
+const SystemPathEnv = "IMAGESLIM_SYSTEM_CONFIG"
+
+// ProjectName is the configuration file a project keeps in its directory.
+const ProjectName = ".imageslim.yaml"
+
+// Origins of layers that are not files.
+const (
+	OriginDefault = "default"
+	OriginEnv     = "environment"
+	OriginFlag    = "-set"
+)
+
+// Layer is one source of settings.
+type Layer struct {
+	Origin string // the file's path, OriginEnv or OriginFlag
+	Config Config
+}
+
+// Origin says where the effective value of a setting came from.
+type Origin struct {
+	Setting string // e.g. "output", "theme.accent", "preset", "keys.quit"
+	Value   string
+	From    string // a file's path, OriginDefault, OriginFlag, or OriginEnv with the variable
+}
+
+// setting is a setting with a single value, which the environment and -set
+// can also change.
+type setting struct {
+	name  string
+	field func(*Config) *string
+	def   string // shown when no layer sets it
+}
+
+// settings are those settings, in the order Merge reports them.
+var settings = []setting{
+	{"output", func(c *Config) *string { return &c.Output }, OutputPreserve},
+	{"report_format", func(c *Config) *string { return &c.ReportFormat }, DefaultReportFormat},
+	{"theme.background", func(c *Config) *string { return &c.Theme.Background }, BackgroundAuto},
+	{"theme.accent", func(c *Config) *string { return &c.Theme.Accent }, "built-in"},
+	{"theme.success", func(c *Config) *string { return &c.Theme.Success }, "built-in"},
+	{"theme.error", func(c *Config) *string { return &c.Theme.Error }, "built-in"},
+	{"theme.warning", func(c *Config) *string { return &c.Theme.Warning }, "built-in"},
+	{"theme.muted", func(c *Config) *string { return &c.Theme.Muted }, "built-in"},
+}
+
+// envName returns the environment variable for a setting, e.g.
+// IMAGESLIM_THEME_ACCENT for theme.accent.
+func envName(name string) string {
+	return "IMAGESLIM_" + strings.ToUpper(strings.ReplaceAll(name, ".", "_"))
+}
+
+// SystemPath returns the system-wide configuration file:
+// $IMAGESLIM_SYSTEM_CONFIG, or /etc/imageslim/config.yaml.
+func SystemPath() string {
+	return cmp.Or(os.Getenv(SystemPathEnv), "/etc/imageslim/config.yaml")
+}
+
+// ProjectPath returns the ProjectName file in the working directory or the
+// nearest directory above it that has one, or "" when none does.
+func ProjectPath() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		p := filepath.Join(dir, ProjectName)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Files returns the configuration files that are read, from the lowest
+// precedence to the highest: the system-wide file, the user's file (see
+// Path) and the project's, when there is one.  They need not exist.
+func Files() []string {
+	files := []string{SystemPath()}
+	if p, err := Path(); err == nil {
+		files = append(files, p)
+	}
+	if p := ProjectPath(); p != "" {
+		files = append(files, p)
+	}
+	return files
+}
+
+// Layers reads every layer, from the lowest precedence to the highest: the
+// Files, the environment (IMAGESLIM_OUTPUT, IMAGESLIM_REPORT_FORMAT and
+// IMAGESLIM_THEME_*) and sets, the values of -set flags.  Missing files are
+// left out.  A layer that cannot be used is an error naming where it came
+// from.
+func Layers(sets []string) ([]Layer, error) {
+	var layers []Layer
+	for _, p := range Files() {
+		c, err := readFile(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, Layer{Origin: p, Config: c})
+	}
+	var env Config
+	for _, s := range settings {
+		*s.field(&env) = os.Getenv(envName(s.name))
+	}
+	if err := env.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", OriginEnv, err)
+	}
+	layers = append(layers, Layer{Origin: OriginEnv, Config: env})
+	flags, err := FlagLayer(sets)
+	if err != nil {
+		return nil, err
+	}
+	return append(layers, flags), nil
+}
+
+// FlagLayer returns the layer of -set flags, each "setting=value", e.g.
+// "output=ask" or "theme.accent=#005FAF".
+func FlagLayer(sets []string) (Layer, error) {
+	var c Config
+	for _, kv := range sets {
+		name, value, ok := strings.Cut(kv, "=")
+		i := slices.IndexFunc(settings, func(s setting) bool { return s.name == strings.TrimSpace(name) })
+		if !ok || i < 0 {
+			names := make([]string, len(settings))
+			for i, s := range settings {
+				names[i] = s.name
+			}
+			return Layer{}, fmt.Errorf("%s %q: want setting=value with one of %s", OriginFlag, kv, strings.Join(names, ", "))
+		}
+		*settings[i].field(&c) = strings.TrimSpace(value)
+	}
+	if err := c.validate(); err != nil {
+		return Layer{}, fmt.Errorf("%s: %w", OriginFlag, err)
+	}
+	return Layer{Origin: OriginFlag, Config: c}, nil
+}
+
+// Merge combines layers, later ones taking precedence, and says where each
+// effective value came from.  Single values come from the last layer that
+// sets them.  Presets and naming schemes are merged by name: a later layer
+// replaces those of the same name and adds the others, and the defaults
+// apply only when no layer has any.  Key bindings are merged by action.
+// Safe mode stays on once a layer turns it on, as Locked describes.
+func Merge(layers []Layer) (Config, []Origin) {
+	var c Config
+	from := map[string]string{}
+	safeFrom := OriginDefault
+	presetFrom, namingFrom, keyFrom := map[string]string{}, map[string]string{}, map[string]string{}
+	for _, l := range layers {
+		for _, s := range settings {
+			if v := *s.field(&l.Config); v != "" {
+				*s.field(&c) = v
+				from[s.name] = l.Origin
+				if l.Origin == OriginEnv {
+					from[s.name] += " (" + envName(s.name) + ")"
+				}
+			}
+		}
+		for _, p := range l.Config.Presets {
+			c.Presets = mergeNamed(c.Presets, p, func(p Preset) string { return p.Name })
+			presetFrom[p.Name] = l.Origin
+		}
+		for _, n := range l.Config.Naming {
+			c.Naming = mergeNamed(c.Naming, n, func(n Naming) string { return n.Name })
+			namingFrom[n.Name] = l.Origin
+		}
+		for action, keys := range l.Config.Keys {
+			if c.Keys == nil {
+				c.Keys = map[string][]string{}
+			}
+			c.Keys[action] = keys
+			keyFrom[action] = l.Origin
+		}
+		if l.Config.SafeMode && !c.SafeMode {
+			c.SafeMode, safeFrom = true, l.Origin
+		}
+	}
+	if len(c.Presets) == 0 {
+		c.Presets = DefaultPresets
+	}
+	if len(c.Naming) == 0 {
+		c.Naming = DefaultNaming
+	}
+
+	var origins []Origin
+	for _, s := range settings {
+		v := cmp.Or(*s.field(&c), s.def)
+		origins = append(origins, Origin{Setting: s.name, Value: v, From: cmp.Or(from[s.name], OriginDefault)})
+	}
+	origins = append(origins, Origin{Setting: "safe_mode", Value: strconv.FormatBool(c.SafeMode), From: safeFrom})
+	for _, p := range c.Presets {
+		origins = append(origins, Origin{Setting: "preset", Value: p.Name, From: cmp.Or(presetFrom[p.Name], OriginDefault)})
+	}
+	for _, n := range c.Naming {
+		origins = append(origins, Origin{Setting: "naming", Value: n.Name, From: cmp.Or(namingFrom[n.Name], OriginDefault)})
+	}
+	actions := make([]string, 0, len(c.Keys))
+	for action := range c.Keys {
+		actions = append(actions, action)
+	}
+	slices.Sort(actions)
+	for _, action := range actions {
+		origins = append(origins, Origin{Setting: "keys." + action, Value: strings.Join(c.Keys[action], ", "), From: keyFrom[action]})
+	}
+	return c, origins
+}
+
+// mergeNamed replaces the item in list with item's name by item, or
+// appends item when there is none.
+func mergeNamed[T any](list []T, item T, name func(T) string) []T {
+	i := slices.IndexFunc(list, func(o T) bool { return name(o) == name(item) })
+	if i < 0 {
+		return append(list, item)
+	}
+	list = slices.Clone(list)
+	list[i] = item
+	return list
+}
@@ -0,0 +1,305 @@
+// Package demo synthesizes a tree of sample images: photos and graphics in
+// the formats ImageSlim converts, sideways JPEGs, animations, files that
+// only look like images, and awkward file names.  Everything is drawn from
+// a seed, so the same seed always gives the same files.  It is there to
+// try destructive settings on something that does not matter, and for the
+// integration tests.
+package demo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// JobName is the job file Generate writes next to the images.
+const JobName = "imageslim-job.yaml"
+
+// job is the content of JobName.
+const job = `# A job for the sample images "imageslim gen-testdata" wrote.  Run it
+# with "imageslim run imageslim-job.yaml", or open it on the form with
+# "imageslim edit imageslim-job.yaml".  Nothing here matters, so try
+# mode: overwrite too.
+name: testdata
+dir: .
+patterns: ["*.jpg", "*.jpeg", "*.png", "*.gif"]
+mode: preserve
+`
+
+// File is one file Generate wrote.
+type File struct {
+	Path string // relative to the directory
+	Size int64
+	Note string // what it is there for
+}
+
+// sample is a file Generate writes.
+type sample struct {
+	path string
+	note string
+	age  int // days before now it was last modified
+	draw func(r *rand.Rand) ([]byte, error)
+}
+
+// samples are the files Generate writes, in order.
+var samples = []sample{
+	{"photos/beach.jpg", "a large photo", 2, photo(3000, 2000, 92, 1)},
+	{"photos/IMG_0412.JPG", "an upper-case extension", 5, photo(1600, 1200, 90, 1)},
+	{"photos/sky.jpg", "a smooth gradient, which bands at low quality", 12, gradient(2000, 1200)},
+	{"photos/sideways/rotated-90.jpg", "EXIF orientation 6: shown turned clockwise", 20, photo(1600, 1200, 88, 6)},
+	{"photos/sideways/rotated-180.jpg", "EXIF orientation 3: shown upside down", 20, photo(1600, 1200, 88, 3)},
+	{"photos/sideways/rotated-270.jpg", "EXIF orientation 8: shown turned anticlockwise", 20, photo(1600, 1200, 88, 8)},
+	{"photos/sideways/mirrored.jpg", "EXIF orientation 2: shown mirrored", 20, photo(1200, 900, 88, 2)},
+	{"photos/thumbnail.jpg", "a small image that min_file_size and min_dimensions leave alone", 40, photo(160, 120, 85, 1)},
+	{"photos/archive/2019/old.jpeg", "an old photo, for modified_after", 2000, photo(1800, 1200, 95, 1)},
+	{"graphics/logo.png", "a PNG with transparency", 8, logo(800, 800, true)},
+	{"graphics/screenshot.png", "a PNG whose alpha channel is opaque everywhere", 8, logo(1920, 1080, false)},
+	{"graphics/icon.png", "a palette PNG", 8, icon(256)},
+	{"graphics/banner.gif", "a still GIF", 30, banner(1200, 300)},
+	{"graphics/spinner.gif", "an animated GIF", 30, spinner(400, 12)},
+	{"edge cases/with spaces.jpg", "spaces in the name", 3, photo(800, 600, 85, 1)},
+	{"edge cases/ünïcødé фото 写真.jpg", "a non-ASCII name", 3, photo(800, 600, 85, 1)},
+	{"edge cases/-leading-dash.jpg", "a name that looks like a flag", 3, photo(800, 600, 85, 1)},
+	{"edge cases/quote's \"and\" [brackets] & $dollar.jpg", "shell metacharacters in the name", 3, photo(800, 600, 85, 1)},
+	{"edge cases/SHOUTING.JPEG", "an upper-case .JPEG", 3, photo(800, 600, 85, 1)},
+	{"edge cases/.hidden.jpg", "a hidden file", 3, photo(640, 480, 85, 1)},
+	{"edge cases/no-extension", "a JPEG without an extension, which no pattern matches", 3, photo(640, 480, 85, 1)},
+	{"edge cases/broken/empty.jpg", "an empty file", 3, func(*rand.Rand) ([]byte, error) { return nil, nil }},
+	{"edge cases/broken/truncated.jpg", "a JPEG cut off halfway", 3, truncated(photo(1200, 800, 90, 1))},
+	{"edge cases/broken/not-an-image.jpg", "text with a .jpg name", 3, text("This is not an image.\n")},
+	{"deep/a/b/c/d/e/f/g/nested.jpg", "a deeply nested file", 10, photo(640, 480, 85, 1)},
+	{"notes.txt", "a file that is not an image", 1, text("Sample images written by imageslim gen-testdata.\n")},
+}
+
+// Generate writes the sample images into dir with a job file for them,
+// JobName, and returns the files written.  dir must be empty or not exist
+// yet, so that nothing is overwritten.
+func Generate(dir string, seed uint64, now time.Time) ([]File, error) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%s is not empty; pick a new directory", dir)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	r := rand.New(rand.NewPCG(seed, 0))
+	var files []File
+	for _, s := range samples {
+		data, err := s.draw(r)
+		if err != nil {
+			return files, fmt.Errorf("%s: %w", s.path, err)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(s.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return files, err
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return files, err
+		}
+		mod := now.AddDate(0, 0, -s.age)
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			return files, err
+		}
+		files = append(files, File{Path: s.path, Size: int64(len(data)), Note: s.note})
+	}
+	if err := os.WriteFile(filepath.Join(dir, JobName), []byte(job), 0o644); err != nil {
+		return files, err
+	}
+	return files, nil
+}
+
+// photo draws a landscape with noise, like a camera's, and encodes it as a
+// JPEG of width×height at quality.  Orientations other than 1 (upright) go
+// in an EXIF block.
+func photo(width, height, quality, orientation int) func(r *rand.Rand) ([]byte, error) {
+	return func(r *rand.Rand) ([]byte, error) {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		sun := [2]int{r.IntN(width), r.IntN(height / 2)}
+		hue := uint8(r.IntN(64))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				horizon := y > height*3/5+int(float64(height)/12*wave(x, width))
+				c := color.RGBA{R: 90 + hue, G: 150, B: 230, A: 255}
+				if horizon {
+					c = color.RGBA{R: 60, G: 110 + hue, B: 50, A: 255}
+				}
+				if dx, dy := x-sun[0], y-sun[1]; dx*dx+dy*dy < (width/20)*(width/20) {
+					c = color.RGBA{R: 255, G: 220, B: 90, A: 255}
+				}
+				n := uint8(r.IntN(24))
+				c.R, c.G, c.B = clamp(int(c.R)+int(n)-12), clamp(int(c.G)+int(n)-12), clamp(int(c.B)+int(n)-12)
+				img.SetRGBA(x, y, c)
+			}
+		}
+		var b bytes.Buffer
+		if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+		if orientation == 1 {
+			return b.Bytes(), nil
+		}
+		return withOrientation(b.Bytes(), orientation), nil
+	}
+}
+
+// wave is a gentle hill between -1 and 1 across a width.
+func wave(x, width int) float64 {
+	t := float64(x) / float64(width)
+	return 4*t*(1-t)*2 - 1
+}
+
+// clamp limits v to a colour channel's range.
+func clamp(v int) uint8 {
+	return uint8(min(max(v, 0), 255))
+}
+
+// withOrientation inserts an EXIF block with the orientation tag after a
+// JPEG's start-of-image marker.
+func withOrientation(data []byte, orientation int) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(&tiff, binary.BigEndian, uint32(8)) // the first IFD follows
+	binary.Write(&tiff, binary.BigEndian, uint16(1)) // with one entry:
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{uint16(orientation), 0})
+	binary.Write(&tiff, binary.BigEndian, uint32(0)) // and no next IFD
+	seg := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, 0xFF, 0xE1)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(seg)+2))
+	out = append(out, seg...)
+	return append(out, data[2:]...)
+}
+
+// gradient draws a smooth sky with no noise to hide banding behind.
+func gradient(width, height int) func(r *rand.Rand) ([]byte, error) {
+	return func(*rand.Rand) ([]byte, error) {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			t := float64(y) / float64(height)
+			c := color.RGBA{R: uint8(20 + 60*t), G: uint8(60 + 100*t), B: uint8(140 + 100*t), A: 255}
+			for x := 0; x < width; x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+		var b bytes.Buffer
+		err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 95})
+		return b.Bytes(), err
+	}
+}
+
+// logo draws coloured rings on a transparent background, or on white when
+// transparent is false, as an RGBA PNG either way.
+func logo(width, height int, transparent bool) func(r *rand.Rand) ([]byte, error) {
+	return func(r *rand.Rand) ([]byte, error) {
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		bg := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+		if transparent {
+			bg = color.NRGBA{}
+		}
+		ring := color.NRGBA{R: uint8(r.IntN(200)), G: uint8(r.IntN(200)), B: 200, A: 255}
+		cx, cy, radius := width/2, height/2, min(width, height)/2
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				d := (x-cx)*(x-cx) + (y-cy)*(y-cy)
+				if d < radius*radius && (d/(radius*radius/8))%2 == 0 {
+					img.SetNRGBA(x, y, ring)
+				} else {
+					img.SetNRGBA(x, y, bg)
+				}
+			}
+		}
+		var b bytes.Buffer
+		err := png.Encode(&b, img)
+		return b.Bytes(), err
+	}
+}
+
+// icon draws a checkerboard in a few palette colours as a paletted PNG.
+func icon(size int) func(r *rand.Rand) ([]byte, error) {
+	return func(r *rand.Rand) ([]byte, error) {
+		img := image.NewPaletted(image.Rect(0, 0, size, size), palette.Plan9)
+		a, c := uint8(r.IntN(256)), uint8(r.IntN(256))
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if (x/32+y/32)%2 == 0 {
+					img.SetColorIndex(x, y, a)
+				} else {
+					img.SetColorIndex(x, y, c)
+				}
+			}
+		}
+		var b bytes.Buffer
+		err := png.Encode(&b, img)
+		return b.Bytes(), err
+	}
+}
+
+// banner draws stripes as a single-frame GIF.
+func banner(width, height int) func(r *rand.Rand) ([]byte, error) {
+	return func(r *rand.Rand) ([]byte, error) {
+		img := image.NewPaletted(image.Rect(0, 0, width, height), palette.WebSafe)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.SetColorIndex(x, y, uint8((x/40+r.IntN(2))%len(palette.WebSafe)))
+			}
+		}
+		var b bytes.Buffer
+		err := gif.Encode(&b, img, nil)
+		return b.Bytes(), err
+	}
+}
+
+// spinnerSteps are where spinner's dot is in turn, in steps of a third of
+// the image from its centre.
+var spinnerSteps = [][2]int{{0, -1}, {1, -1}, {1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}}
+
+// spinner draws a dot going round in frames frames, as an animated GIF.
+func spinner(size, frames int) func(r *rand.Rand) ([]byte, error) {
+	return func(*rand.Rand) ([]byte, error) {
+		anim := &gif.GIF{}
+		for f := 0; f < frames; f++ {
+			img := image.NewPaletted(image.Rect(0, 0, size, size), palette.WebSafe)
+			step := spinnerSteps[f%len(spinnerSteps)]
+			spot := image.Pt(size/2+size/3*step[0], size/2+size/3*step[1])
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					if dx, dy := x-spot.X, y-spot.Y; dx*dx+dy*dy < size*size/64 {
+						img.SetColorIndex(x, y, 5)
+					} else {
+						img.SetColorIndex(x, y, 215)
+					}
+				}
+			}
+			anim.Image = append(anim.Image, img)
+			anim.Delay = append(anim.Delay, 8)
+		}
+		var b bytes.Buffer
+		err := gif.EncodeAll(&b, anim)
+		return b.Bytes(), err
+	}
+}
+
+// truncated cuts what draw writes off halfway.
+func truncated(draw func(r *rand.Rand) ([]byte, error)) func(r *rand.Rand) ([]byte, error) {
+	return func(r *rand.Rand) ([]byte, error) {
+		data, err := draw(r)
+		return data[:len(data)/2], err
+	}
+}
+
+// text writes s.
+func text(s string) func(r *rand.Rand) ([]byte, error) {
+	return func(*rand.Rand) ([]byte, error) { return []byte(s), nil }
+}
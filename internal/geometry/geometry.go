@@ -0,0 +1,174 @@
+// Package geometry parses and validates GraphicsMagick geometry strings such
+// as "1200x1200", "800x", "x600", "50%" or "1000000@", and ImageSlim's own
+// "longedge:1600".
+//
+// User input is checked here before it reaches gm, so a typo is reported as
+// "resize: width must be a whole number" instead of as gm's "invalid
+// argument" halfway through a batch, and nothing unexpected is ever passed
+// through as an argument.
+package geometry
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxDimension is the largest width or height accepted, in pixels.  It is far
+// beyond any real photo but keeps typos like "12000000" from reaching gm.
+const MaxDimension = 65535
+
+// MaxPercent is the largest scale accepted for percentage geometries.
+const MaxPercent = 1000
+
+// Geometry is a parsed resize geometry.
+type Geometry struct {
+	// Width and Height are the target box.  Zero means the dimension was
+	// left out ("800x", "x600") and follows from the aspect ratio.
+	Width, Height int
+
+	// Percent scales by Width% and Height% instead of fitting a box.
+	Percent bool
+
+	// Area treats Width as a maximum pixel count ("1000000@").
+	Area bool
+
+	// LongEdge treats Width as the length of the image's longer side
+	// ("longedge:1600"), whichever way round the image is.  gm has no such
+	// geometry: it is passed on as the square box "1600x1600".
+	LongEdge bool
+
+	// Flags are gm's resize modifiers, in the order given: "!" ignores the
+	// aspect ratio, "<" only enlarges, ">" only shrinks and "^" fills the
+	// box instead of fitting inside it.
+	Flags string
+}
+
+// longEdgePrefix starts a long-edge geometry.
+const longEdgePrefix = "longedge:"
+
+// Parse parses a geometry string.  Surrounding space is ignored; everything
+// else must be digits, one "x" and the modifiers % @ ! < > ^, or
+// "longedge:" and a number of pixels.
+func Parse(s string) (Geometry, error) {
+	var g Geometry
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return g, fmt.Errorf("geometry is empty")
+	}
+	if len(s) >= len(longEdgePrefix) && strings.EqualFold(s[:len(longEdgePrefix)], longEdgePrefix) {
+		var err error
+		g.LongEdge = true
+		if g.Width, err = dimension(s, "long edge", s[len(longEdgePrefix):]); err != nil {
+			return g, err
+		}
+		switch {
+		case g.Width == 0:
+			return g, fmt.Errorf("%q: give the long edge in pixels, e.g. longedge:1600", s)
+		case g.Width > MaxDimension:
+			return g, fmt.Errorf("%q: dimensions must be at most %d pixels", s, MaxDimension)
+		}
+		return g, nil
+	}
+
+	// Split off trailing modifiers.
+	body := strings.TrimRight(s, "%@!<>^")
+	for _, r := range s[len(body):] {
+		switch r {
+		case '%':
+			if g.Percent {
+				return g, fmt.Errorf("%q: %% given twice", s)
+			}
+			g.Percent = true
+		case '@':
+			if g.Area {
+				return g, fmt.Errorf("%q: @ given twice", s)
+			}
+			g.Area = true
+		default:
+			if strings.ContainsRune(g.Flags, r) {
+				return g, fmt.Errorf("%q: %c given twice", s, r)
+			}
+			g.Flags += string(r)
+		}
+	}
+	if strings.Contains(g.Flags, "<") && strings.Contains(g.Flags, ">") {
+		return g, fmt.Errorf("%q: < (only enlarge) and > (only shrink) exclude each other", s)
+	}
+
+	w, h, hasX := strings.Cut(body, "x")
+	if !hasX {
+		w, h, hasX = strings.Cut(body, "X")
+	}
+	var err error
+	if g.Width, err = dimension(s, "width", w); err != nil {
+		return g, err
+	}
+	if g.Height, err = dimension(s, "height", h); err != nil {
+		return g, err
+	}
+	if g.Width == 0 && g.Height == 0 {
+		return g, fmt.Errorf("%q: give a width, a height or both, e.g. 1200x1200", s)
+	}
+	switch {
+	case g.Area && (g.Percent || hasX):
+		return g, fmt.Errorf("%q: @ takes a single pixel count, e.g. 1000000@", s)
+	case g.Percent && (g.Width > MaxPercent || g.Height > MaxPercent):
+		return g, fmt.Errorf("%q: scale must be at most %d%%", s, MaxPercent)
+	case !g.Percent && !g.Area && (g.Width > MaxDimension || g.Height > MaxDimension):
+		return g, fmt.Errorf("%q: dimensions must be at most %d pixels", s, MaxDimension)
+	}
+	return g, nil
+}
+
+// dimension parses one side of a geometry.  An empty string is zero.
+func dimension(geom, name, s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("%q: %s must be a whole number of pixels, got %q", geom, name, s)
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n > 1<<30 {
+		return 0, fmt.Errorf("%q: %s %s is out of range", geom, name, s)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%q: %s must be greater than zero", geom, name)
+	}
+	return n, nil
+}
+
+// String formats g the way gm expects it, except that a long-edge
+// geometry stays "longedge:N".  Parse(g.String()) returns g.
+func (g Geometry) String() string {
+	if g.LongEdge {
+		return longEdgePrefix + strconv.Itoa(g.Width)
+	}
+	var b strings.Builder
+	if g.Width > 0 {
+		b.WriteString(strconv.Itoa(g.Width))
+	}
+	if g.Height > 0 {
+		b.WriteString("x")
+		b.WriteString(strconv.Itoa(g.Height))
+	}
+	if g.Percent {
+		b.WriteString("%")
+	}
+	if g.Area {
+		b.WriteString("@")
+	}
+	b.WriteString(g.Flags)
+	return b.String()
+}
+
+// WithFlag returns g with modifier f added unless it is already present.
+func (g Geometry) WithFlag(f byte) Geometry {
+	if !strings.ContainsRune(g.Flags, rune(f)) {
+		g.Flags += string(f)
+	}
+	return g
+}
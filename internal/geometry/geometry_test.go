@@ -0,0 +1,114 @@
+package geometry
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// valid is a Geometry that Parse accepts, generated for testing/quick.
+type valid struct{ g Geometry }
+
+// Generate returns a random geometry of each kind: a box, a scale, a pixel
+// count or a long edge, with the modifiers gm allows in any order.
+func (valid) Generate(r *rand.Rand, _ int) reflect.Value {
+	side := func(max int) int {
+		if r.Intn(4) == 0 {
+			return 0
+		}
+		return 1 + r.Intn(max)
+	}
+	var g Geometry
+	switch r.Intn(4) {
+	case 0:
+		g.LongEdge = true
+		g.Width = 1 + r.Intn(MaxDimension)
+		return reflect.ValueOf(valid{g})
+	case 1:
+		g.Percent = true
+		g.Width, g.Height = side(MaxPercent), side(MaxPercent)
+	case 2:
+		g.Area = true
+		g.Width = 1 + r.Intn(1<<30)
+	default:
+		g.Width, g.Height = side(MaxDimension), side(MaxDimension)
+	}
+	if g.Width == 0 && g.Height == 0 {
+		g.Width = 1
+	}
+	for _, i := range r.Perm(4) {
+		f := "!<>^"[i]
+		if r.Intn(2) == 0 || (f == '<' && strings.Contains(g.Flags, ">")) || (f == '>' && strings.Contains(g.Flags, "<")) {
+			continue
+		}
+		g.Flags += string(f)
+	}
+	return reflect.ValueOf(valid{g})
+}
+
+func TestRoundTrip(t *testing.T) {
+	roundTrips := func(v valid) bool {
+		g, err := Parse(v.g.String())
+		return err == nil && g == v.g
+	}
+	if err := quick.Check(roundTrips, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+// invalid is a geometry string with a character Parse never accepts
+// somewhere in it.
+type invalid string
+
+// Generate returns a string of geometry characters with one that is not
+// among them put in at a random place.
+func (invalid) Generate(r *rand.Rand, size int) reflect.Value {
+	const ok = "0123456789xX%@!<>^"
+	const bad = "-+.,;:/ \tabcdefghijkmnopqrstuvwyz()[]{}'\"$&|é日"
+	b := []rune{}
+	for range r.Intn(size + 1) {
+		b = append(b, rune(ok[r.Intn(len(ok))]))
+	}
+	badRunes := []rune(bad)
+	at := r.Intn(len(b) + 1)
+	b = append(b[:at], append([]rune{badRunes[r.Intn(len(badRunes))]}, b[at:]...)...)
+	s := string(b)
+	if strings.TrimSpace(s) != s || strings.TrimSpace(s) == "" {
+		s = "1" + s + "1" // space inside, not around
+	}
+	return reflect.ValueOf(invalid(s))
+}
+
+func TestInvalidRejected(t *testing.T) {
+	rejected := func(s invalid) bool {
+		_, err := Parse(string(s))
+		return err != nil
+	}
+	if err := quick.Check(rejected, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, s := range []string{
+		"1200x1200", "800x", "x600", "50%", "1000000@", "1200x1200>", "800x600!^",
+		"longedge:1600", "LongEdge:20", " 640X480 ", "0x0", "1x1@", "10%%", "<>", "",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		g, err := Parse(s)
+		if err != nil {
+			return
+		}
+		again, err := Parse(g.String())
+		if err != nil {
+			t.Fatalf("Parse(%q) = %+v, whose String %q does not parse: %v", s, g, g.String(), err)
+		}
+		if again != g {
+			t.Fatalf("Parse(%q) = %+v, but Parse(%q) = %+v", s, g, g.String(), again)
+		}
+	})
+}
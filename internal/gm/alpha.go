@@ -0,0 +1,248 @@
+package gm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Transparency audit: PNGs and WebPs whose alpha channel is fully opaque
+// ---------------------------------------------------------------------------
+
+// alphaPatterns are the files AuditAlpha looks at.
+var alphaPatterns = []string{"*.png", "*.webp"}
+
+// alphaUse is what an image does with its alpha channel.
+type alphaUse int
+
+const (
+	alphaNone   alphaUse = iota // no alpha channel at all
+	alphaOpaque                 // an alpha channel, but every pixel is opaque
+	alphaUsed                   // some pixels are (partly) transparent
+)
+
+// AlphaResult is the outcome of AuditAlpha.  Paths are relative to
+// Options.Dir.
+type AlphaResult struct {
+	// Transparent are the files with pixels that are partly or fully
+	// transparent.
+	Transparent []string
+
+	// Opaque are the files with an alpha channel in which every pixel is
+	// opaque: bytes spent on nothing, which Options.DropAlpha saves.
+	Opaque []string
+
+	// NoAlpha counts the files without an alpha channel.
+	NoAlpha int
+}
+
+// AuditAlpha sorts the PNGs and WebPs of opts.Dir by what their alpha
+// channel is for.  opts.Recursive, opts.ModifiedAfter and
+// opts.ModifiedBefore pick the files as for Run.  PNGs are decoded here;
+// WebPs whose header announces an alpha channel are decoded by gm.
+// AuditAlpha stops at the first file that cannot be read.
+func AuditAlpha(opts Options) (AlphaResult, error) {
+	var res AlphaResult
+	opts.Patterns, opts.HEIC = alphaPatterns, false
+	files, err := Scan(opts)
+	if err != nil {
+		return res, err
+	}
+	bin := ""
+	for _, rel := range files {
+		if !isPNG(rel) && bin == "" {
+			if bin, err = Binary(opts); err != nil {
+				return res, err
+			}
+		}
+		use, err := alphaOf(bin, filepath.Join(opts.Dir, rel))
+		if err != nil {
+			return res, fmt.Errorf("%s: %w", rel, err)
+		}
+		switch use {
+		case alphaUsed:
+			res.Transparent = append(res.Transparent, rel)
+		case alphaOpaque:
+			res.Opaque = append(res.Opaque, rel)
+		default:
+			res.NoAlpha++
+		}
+	}
+	return res, nil
+}
+
+// opaqueAlpha reports whether path, a PNG or WebP, has an alpha channel
+// that is fully opaque.  It reports false for other files and for files it
+// cannot read, whose alpha channel is then kept.
+func opaqueAlpha(bin, path string) bool {
+	if !isPNG(path) && !isWebP(path) {
+		return false
+	}
+	use, err := alphaOf(bin, path)
+	return err == nil && use == alphaOpaque
+}
+
+// isWebP reports whether name has a WebP file extension.
+func isWebP(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), "."+OutputWebP)
+}
+
+// alphaOf finds out what the PNG or WebP at path does with its alpha
+// channel.  Only files whose header announces one are decoded: PNGs with
+// the standard library, WebPs with gm at bin.
+func alphaOf(bin, path string) (alphaUse, error) {
+	if isPNG(path) {
+		return pngAlpha(path)
+	}
+	has, err := webpHasAlpha(path)
+	if err != nil || !has {
+		return alphaNone, err
+	}
+	out, err := exec.Command(bin, "convert", path, "PAM:-").Output()
+	if err != nil {
+		return alphaNone, fmt.Errorf("gm convert: %w", err)
+	}
+	return pamAlpha(out)
+}
+
+// pngAlpha decodes the PNG at path if it has an alpha channel or a tRNS
+// chunk, and checks whether any pixel is transparent.
+func pngAlpha(path string) (alphaUse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return alphaNone, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	has, err := pngHasAlpha(r)
+	if err != nil || !has {
+		return alphaNone, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return alphaNone, err
+	}
+	img, err := png.Decode(bufio.NewReader(f))
+	if err != nil {
+		return alphaNone, err
+	}
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return alphaOpaque, nil
+	}
+	return alphaUsed, nil
+}
+
+// pngHasAlpha reads the chunks of a PNG up to its image data and reports
+// whether its colour type has an alpha channel (grey or RGB with alpha) or
+// a tRNS chunk adds transparency to it.
+func pngHasAlpha(r io.Reader) (bool, error) {
+	var sig [8]byte
+	if _, err := io.ReadFull(r, sig[:]); err != nil {
+		return false, err
+	}
+	if string(sig[:]) != "\x89PNG\r\n\x1a\n" {
+		return false, errors.New("not a PNG")
+	}
+	for {
+		var head [8]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return false, err
+		}
+		size := binary.BigEndian.Uint32(head[:4])
+		switch string(head[4:]) {
+		case "IHDR":
+			var ihdr [13]byte
+			if size != 13 {
+				return false, errors.New("bad PNG header")
+			}
+			if _, err := io.ReadFull(r, ihdr[:]); err != nil {
+				return false, err
+			}
+			if ihdr[9]&4 != 0 { // colour types 4 and 6
+				return true, nil
+			}
+			size -= 13
+		case "tRNS":
+			return true, nil
+		case "IDAT":
+			return false, nil
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(size)+4); err != nil { // data and CRC
+			return false, err
+		}
+	}
+}
+
+// webpHasAlpha reports whether the header of the WebP at path announces an
+// alpha channel: the alpha flag of an extended (VP8X) file, or the
+// alpha_is_used bit of a lossless (VP8L) one.  Simple lossy files have none.
+func webpHasAlpha(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var head [30]byte
+	n, _ := io.ReadFull(f, head[:])
+	if n < 21 || string(head[:4]) != "RIFF" || string(head[8:12]) != "WEBP" {
+		return false, errors.New("not a WebP")
+	}
+	switch string(head[12:16]) {
+	case "VP8X":
+		return head[20]&0x10 != 0, nil
+	case "VP8L":
+		if n < 25 {
+			return false, errors.New("bad WebP header")
+		}
+		return binary.LittleEndian.Uint32(head[21:])&(1<<28) != 0, nil
+	}
+	return false, nil
+}
+
+// pamAlpha checks whether any pixel of a PAM image, as "gm convert F
+// PAM:-" writes it, is transparent.  gm leaves the alpha samples out of
+// images without an alpha channel.
+func pamAlpha(data []byte) (alphaUse, error) {
+	hdr, pixels, ok := bytes.Cut(data, []byte("ENDHDR\n"))
+	if !ok || !bytes.HasPrefix(hdr, []byte("P7\n")) {
+		return alphaNone, errors.New("gm wrote no PAM image")
+	}
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(hdr), "\n")[1:] {
+		if k, v, ok := strings.Cut(line, " "); ok {
+			fields[k] = strings.TrimSpace(v)
+		}
+	}
+	if !strings.HasSuffix(fields["TUPLTYPE"], "_ALPHA") {
+		return alphaNone, nil
+	}
+	depth, _ := strconv.Atoi(fields["DEPTH"])
+	maxval, _ := strconv.Atoi(fields["MAXVAL"])
+	if depth < 2 || maxval < 1 {
+		return alphaNone, errors.New("gm wrote a PAM image without depth or maxval")
+	}
+	width := 1
+	if maxval > 255 {
+		width = 2
+	}
+	tuple := depth * width
+	for i := tuple - width; i+width <= len(pixels); i += tuple {
+		a := int(pixels[i])
+		if width == 2 {
+			a = int(binary.BigEndian.Uint16(pixels[i:]))
+		}
+		if a != maxval {
+			return alphaUsed, nil
+		}
+	}
+	return alphaOpaque, nil
+}
@@ -0,0 +1,249 @@
+package gm
+
+import (
+	"archive/zip"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// ApprovalDir is the directory, relative to Options.Dir, that Approve
+// writes its before/after pairs to.  Scans skip it once it is marked as
+// Approve's (see approvalMarker); a folder of the user's of that name is
+// scanned like any other, and never replaced.
+const ApprovalDir = "approval"
+
+// approvalMarker is the file Approve marks its approval directory with.
+const approvalMarker = ".imageslim-approval"
+
+// DefaultApprovalCount is how many pairs Approve picks when asked for none.
+const DefaultApprovalCount = 8
+
+// approvalBuckets are the upper bounds of the size buckets Approve samples
+// across, so that small and large originals are both shown; files of at
+// least the last bound form a bucket of their own.
+var approvalBuckets = []int64{256 << 10, 1 << 20, 4 << 20}
+
+// Approval is the outcome of Approve.
+type Approval struct {
+	// Dir is the approval directory: the picked originals are copied
+	// unchanged to its "before" subdirectory and converted into its
+	// "after" one, under the same relative paths.
+	Dir string
+
+	// Zip is the archive of Dir, or "" when none was asked for.
+	Zip string
+
+	// Files are the picked originals, relative to Options.Dir.
+	Files []string
+
+	// Result is the run that converted them.
+	Result Result
+}
+
+// Approve exports a handful of before/after pairs for a client to approve
+// before the whole of opts.Dir is converted: up to count files (when 0,
+// opts.SampleSize or DefaultApprovalCount) are picked across directories
+// and size buckets, shuffled by opts.Seed,
+// copied unchanged to ApprovalDir/before and converted with the settings
+// of opts, at full size, to ApprovalDir/after.  The originals are copied
+// byte for byte and gm keeps embedded colour profiles, so both sides show
+// the colours the client would get.  With zip the folder is also packed
+// into ApprovalDir.zip next to it.  An earlier export is replaced; a folder
+// or archive of that name that Approve did not write is refused.
+//
+// The conversion always runs in preserve mode, whatever opts.Overwrite
+// says, and neither reports, compares with the baseline nor touches the
+// resume manifest.
+func Approve(ctx context.Context, opts Options, count int, zip bool) (Approval, error) {
+	if count <= 0 {
+		count = cmp.Or(opts.SampleSize, DefaultApprovalCount)
+	}
+	a := Approval{Dir: filepath.Join(opts.Dir, ApprovalDir)}
+	files, err := Scan(opts)
+	if err != nil {
+		return a, err
+	}
+	files = slices.DeleteFunc(files, func(rel string) bool {
+		return opts.Files != nil && !slices.Contains(opts.Files, rel)
+	})
+	if a.Files, err = sampleFiles(opts.Dir, files, count, opts.Seed); err != nil {
+		return a, err
+	}
+	if len(a.Files) == 0 {
+		return a, fmt.Errorf("no files to pick from in %s", opts.Dir)
+	}
+
+	if _, err := os.Lstat(a.Dir); err == nil {
+		if !isApproval(a.Dir) {
+			return a, fmt.Errorf("%s is not an approval export of ImageSlim's; move it away first", a.Dir)
+		}
+		if err := os.RemoveAll(a.Dir); err != nil {
+			return a, err
+		}
+		if err := os.Remove(a.Dir + ".zip"); err != nil && !os.IsNotExist(err) {
+			return a, err
+		}
+	} else if _, err := os.Lstat(a.Dir + ".zip"); err == nil {
+		// Without our folder nothing says the archive is ours, and the
+		// next export would remove it along with the folder.
+		return a, fmt.Errorf("%s.zip is not an approval export of ImageSlim's; move it away first", a.Dir)
+	}
+	if err := os.MkdirAll(a.Dir, 0o755); err != nil {
+		return a, err
+	}
+	if err := os.WriteFile(filepath.Join(a.Dir, approvalMarker), []byte("Before/after pairs exported by \"imageslim approval\"; the next export replaces them.\n"), 0o644); err != nil {
+		return a, err
+	}
+	before := filepath.Join(a.Dir, "before")
+	for _, rel := range a.Files {
+		dst := filepath.Join(before, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return a, err
+		}
+		if err := copyFile(filepath.Join(opts.Dir, rel), dst); err != nil {
+			return a, fmt.Errorf("copy %s: %w", rel, err)
+		}
+	}
+
+	run := opts
+	run.Dir = before
+	run.Recursive = true
+	run.Overwrite, run.Backup, run.Trash, run.Force = false, false, false, true
+	run.Report, run.Baseline = "", ""
+	run.Files, run.Progress = nil, nil
+	run.ModifiedAfter, run.ModifiedBefore = time.Time{}, time.Time{} // the copies are new
+	if run.Watermark.Enabled() {
+		run.Watermark.Image = opts.Watermark.path(opts.Dir)
+	}
+	a.Result = Run(ctx, run)
+	out := filepath.Join(before, OutputDir)
+	if err := os.Remove(filepath.Join(out, ManifestName)); err != nil && !os.IsNotExist(err) {
+		return a, err
+	}
+	if err := os.Rename(out, filepath.Join(a.Dir, "after")); err != nil && !os.IsNotExist(err) {
+		return a, err
+	}
+	if a.Result.Err != nil {
+		return a, a.Result.Err
+	}
+
+	if zip {
+		a.Zip = a.Dir + ".zip"
+		if err := zipDir(a.Dir, a.Zip); err != nil {
+			return a, fmt.Errorf("zip %s: %w", a.Dir, err)
+		}
+	}
+	return a, nil
+}
+
+// isApproval reports whether dir is marked as Approve's approval directory.
+func isApproval(dir string) bool {
+	_, err := os.Lstat(filepath.Join(dir, approvalMarker))
+	return err == nil
+}
+
+// sampleFiles picks up to n of files, relative to dir, so that every
+// directory and size bucket is represented: the files are grouped by both,
+// and the groups take turns giving up their next file, smallest group
+// first, until n are picked.  A seed other than 0 shuffles each group
+// first, the same way every time.
+func sampleFiles(dir string, files []string, n int, seed int64) ([]string, error) {
+	type group struct {
+		dir    string
+		bucket int
+		files  []string
+	}
+	var groups []*group
+	for _, rel := range files {
+		fi, err := os.Stat(filepath.Join(dir, rel))
+		if err != nil {
+			return nil, err
+		}
+		bucket, _ := slices.BinarySearch(approvalBuckets, fi.Size()+1)
+		i := slices.IndexFunc(groups, func(g *group) bool {
+			return g.dir == filepath.Dir(rel) && g.bucket == bucket
+		})
+		if i < 0 {
+			groups = append(groups, &group{dir: filepath.Dir(rel), bucket: bucket})
+			i = len(groups) - 1
+		}
+		groups[i].files = append(groups[i].files, rel)
+	}
+	slices.SortStableFunc(groups, func(a, b *group) int {
+		return cmp.Or(cmp.Compare(len(a.files), len(b.files)), cmp.Compare(a.dir, b.dir), cmp.Compare(a.bucket, b.bucket))
+	})
+	if seed != 0 {
+		r := rand.New(rand.NewPCG(uint64(seed), 0))
+		for _, g := range groups {
+			slices.Sort(g.files) // whatever order the files were found in
+			r.Shuffle(len(g.files), func(i, j int) { g.files[i], g.files[j] = g.files[j], g.files[i] })
+		}
+	}
+
+	var picked []string
+	for round := 0; len(picked) < n; round++ {
+		more := false
+		for _, g := range groups {
+			if round < len(g.files) && len(picked) < n {
+				picked = append(picked, g.files[round])
+				more = true
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	slices.Sort(picked)
+	return picked, nil
+}
+
+// zipDir packs the files under dir into the archive at path, under
+// dir's own name.  The archive is written to a temporary name first, so a
+// failure leaves nothing under path.
+func zipDir(dir, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := zip.NewWriter(f)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == approvalMarker {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.Dir(dir), p)
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := w.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		return err
+	})
+	if err == nil {
+		err = w.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,173 @@
+package gm
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultBackupDir is where overwrite mode copies originals when
+// Options.Backup is set and Options.BackupDir is empty.  It is relative to
+// Options.Dir.
+const DefaultBackupDir = ".imageslim-backup"
+
+// backupMarker is the file a backup directory is marked with, so that
+// scans skip it even once Options.BackupDir names another.
+const backupMarker = ".imageslim-backup-root"
+
+// backupRoot returns the absolute backup directory for opts.
+func backupRoot(opts Options) string {
+	dir := opts.BackupDir
+	if dir == "" {
+		dir = DefaultBackupDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(opts.Dir, dir)
+	}
+	return dir
+}
+
+// backupFile copies src to the mirrored location of rel under root.  An
+// existing backup is never replaced: it holds the untouched original, while
+// src may already be the output of an earlier run.
+func backupFile(root, rel, src string) error {
+	dst := filepath.Join(root, rel)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := markBackup(root); err != nil {
+		return err
+	}
+	return copyFile(src, dst)
+}
+
+// markBackup marks root as a backup directory unless it already is.
+func markBackup(root string) error {
+	marker := filepath.Join(root, backupMarker)
+	if _, err := os.Stat(marker); err == nil {
+		return nil
+	}
+	return os.WriteFile(marker, []byte("Originals backed up by ImageSlim; \"imageslim restore\" puts them back.\n"), 0o644)
+}
+
+// isBackup reports whether dir is marked as a backup directory.
+func isBackup(dir string) bool {
+	_, err := os.Lstat(filepath.Join(dir, backupMarker))
+	return err == nil
+}
+
+// copyFile copies src to dst, preserving permissions and modification time.
+// Where the filesystem can, dst is a clone sharing src's blocks until
+// either changes (see clone), so backups, the trash and other copies cost
+// no space up front; elsewhere the data is copied, by copy_file_range on
+// Linux.
+func copyFile(src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary name first so an interrupted copy never leaves a
+	// truncated file under the final name.
+	tmp := dst + ".tmp"
+	os.Remove(tmp) // left by an interrupted copy
+	if clone(src, tmp) != nil {
+		if err := copyData(src, tmp, fi.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if err := os.Chtimes(tmp, fi.ModTime(), fi.ModTime()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// copyData copies the contents of src to a new file dst with permissions
+// perm.  io.Copy between two files uses copy_file_range where there is one.
+func copyData(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// Restore copies every file from the backup directory of opts (see
+// Options.BackupDir) back over its original in opts.Dir and returns the
+// number of files restored.  Unless keep is true the backup directory is
+// removed afterwards.  Only a directory marked as a backup (see
+// markBackup) that does not hold opts.Dir is restored from: anything else
+// would be copied over itself, then removed.
+func Restore(opts Options, keep bool) (int, error) {
+	root := backupRoot(opts)
+	if _, err := os.Stat(root); err != nil {
+		return 0, fmt.Errorf("no backup found: %w", err)
+	}
+	if !isBackup(root) {
+		return 0, fmt.Errorf("%s is not a backup directory: it has no %s", root, backupMarker)
+	}
+	if holds(root, opts.Dir) {
+		return 0, fmt.Errorf("%s is not a backup directory: it holds %s", root, opts.Dir)
+	}
+
+	n := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == backupMarker {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(opts.Dir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := copyFile(path, dst); err != nil {
+			return fmt.Errorf("restore %s: %w", rel, err)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	if !keep {
+		return n, os.RemoveAll(root)
+	}
+	return n, nil
+}
+
+// holds reports whether path is dir or lies inside it.
+func holds(dir, path string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return true
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
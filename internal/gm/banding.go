@@ -0,0 +1,184 @@
+package gm
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Colour reduction and banding risk
+// ---------------------------------------------------------------------------
+
+// MaxColorCount is the largest Options.MaxColors: what a palette holds.
+const MaxColorCount = 256
+
+// bandingQuality is the quality below which lossy formats turn smooth
+// gradients into visible steps.
+const bandingQuality = 60
+
+// bandingSample is how many files the banding check decodes at most when
+// Options.SampleSize does not say.
+const bandingSample = 12
+
+// bandingBlock is the side of the pixel blocks the banding check looks at,
+// and bandingGrid how many it takes across and down at most.
+const (
+	bandingBlock = 16
+	bandingGrid  = 32
+)
+
+// colorArgs returns the gm arguments for opts.MaxColors: -colors, after
+// +dither unless opts.Dither asks for error diffusion.
+func colorArgs(opts Options) []string {
+	if opts.MaxColors == 0 {
+		return nil
+	}
+	dither := "+dither"
+	if opts.Dither {
+		dither = "-dither"
+	}
+	return []string{dither, "-colors", fmt.Sprint(opts.MaxColors)}
+}
+
+// banding is a folder whose images are likely to band with the run's
+// settings: smooth gradients, such as skies and studio backdrops, broken
+// into visible steps.
+type banding struct {
+	dir     string // relative to Options.Dir; "." for the top level
+	sampled int    // files of the folder decoded
+	smooth  int    // ...and of those, the ones mostly made of gradients
+}
+
+// bandingCauses returns what in opts reduces colours or quality enough to
+// cause banding, each with what to change instead, or nil when nothing
+// does.  rel picks the causes that apply to one file; "" takes them all.
+func bandingCauses(opts Options, rel string) (causes, advice []string) {
+	if opts.Lossless || opts.Exec != "" {
+		return nil, nil
+	}
+	out := withFormat(opts, rel)
+	if opts.MaxColors > 0 && !opts.Dither {
+		causes = append(causes, fmt.Sprintf("%d colours without dithering", opts.MaxColors))
+		advice = append(advice, "dither: true")
+	}
+	if opts.OptimizePNG == PNGLossy && (rel == "" || isPNG(out)) {
+		causes = append(causes, "pngquant's palette")
+		advice = append(advice, "png_optimize: lossless")
+	}
+	if opts.Quality < bandingQuality && (rel == "" || !isPNG(out) && !isGIF(out)) {
+		causes = append(causes, fmt.Sprintf("quality %d", opts.Quality))
+		advice = append(advice, fmt.Sprintf("quality %d or more", bandingQuality+15))
+	}
+	return causes, advice
+}
+
+// checkBanding decodes a sample of files, picked across folders and sizes
+// as for Approve, when opts reduce colours or quality enough to cause
+// banding, and returns the folders where gradient-heavy images were found.
+// It returns nil when opts are not aggressive enough.  Files the standard
+// library cannot decode (WebP, HEIC, TIFF, …) are left out.
+func checkBanding(opts Options, files []string) ([]banding, error) {
+	files = slices.DeleteFunc(slices.Clone(files), func(rel string) bool {
+		causes, _ := bandingCauses(opts, rel)
+		return len(causes) == 0 || !slices.Contains([]string{".jpg", ".jpeg", ".png", ".gif"}, strings.ToLower(filepath.Ext(rel)))
+	})
+	if len(files) == 0 {
+		return nil, nil
+	}
+	sample, err := sampleFiles(opts.Dir, files, cmp.Or(opts.SampleSize, bandingSample), opts.Seed)
+	if err != nil {
+		return nil, err
+	}
+	var found []banding
+	for _, rel := range sample {
+		smooth, err := gradientHeavy(filepath.Join(opts.Dir, rel))
+		if err != nil {
+			continue // gm will say what is wrong with it
+		}
+		dir := filepath.Dir(rel)
+		i := slices.IndexFunc(found, func(b banding) bool { return b.dir == dir })
+		if i < 0 {
+			found = append(found, banding{dir: dir})
+			i = len(found) - 1
+		}
+		found[i].sampled++
+		if smooth {
+			found[i].smooth++
+		}
+	}
+	found = slices.DeleteFunc(found, func(b banding) bool { return b.smooth == 0 })
+	slices.SortFunc(found, func(a, b banding) int { return strings.Compare(a.dir, b.dir) })
+	return found, nil
+}
+
+// bandingWarnings describes what checkBanding found, one line per folder,
+// with what to change for those folders.
+func bandingWarnings(opts Options, found []banding) []string {
+	causes, advice := bandingCauses(opts, "")
+	var lines []string
+	for _, b := range found {
+		lines = append(lines, fmt.Sprintf("banding likely in %s with %s: %d of %d sampled images are smooth gradients; for that folder try %s",
+			b.dir, strings.Join(causes, " and "), b.smooth, b.sampled, strings.Join(advice, " or ")))
+	}
+	return lines
+}
+
+// gradientHeavy decodes the image at path and reports whether at least a
+// quarter of it is smooth gradient: blocks whose neighbouring pixels
+// differ by very little while the block as a whole changes tone.  Flat
+// blocks of one colour and detailed ones do not band.  At most
+// bandingGrid × bandingGrid blocks spread over the image are looked at.
+func gradientHeavy(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return false, err
+	}
+	r := img.Bounds()
+	luma := func(x, y int) int {
+		cr, cg, cb, _ := img.At(x, y).RGBA()
+		return int(299*cr+587*cg+114*cb) / 1000 >> 8
+	}
+	stepX := max((r.Dx()-bandingBlock)/bandingGrid, bandingBlock)
+	stepY := max((r.Dy()-bandingBlock)/bandingGrid, bandingBlock)
+	blocks, smooth := 0, 0
+	for by := r.Min.Y; by+bandingBlock <= r.Max.Y; by += stepY {
+		for bx := r.Min.X; bx+bandingBlock <= r.Max.X; bx += stepX {
+			lo, hi, jump := 255, 0, 0
+			for y := by; y < by+bandingBlock; y++ {
+				for x := bx; x < bx+bandingBlock; x++ {
+					l := luma(x, y)
+					lo, hi = min(lo, l), max(hi, l)
+					if x > bx {
+						jump = max(jump, abs(l-luma(x-1, y)))
+					}
+					if y > by {
+						jump = max(jump, abs(l-luma(x, y-1)))
+					}
+				}
+			}
+			blocks++
+			if jump <= 3 && hi-lo >= 4 {
+				smooth++
+			}
+		}
+	}
+	return blocks > 0 && smooth*4 >= blocks, nil
+}
+
+// abs returns the absolute value of n.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,184 @@
+package gm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Expected-savings baseline
+// ---------------------------------------------------------------------------
+
+// Values of Options.Baseline.  The empty value neither checks nor saves.
+const (
+	BaselineOff   = ""
+	BaselineCheck = "check"
+	BaselineSave  = "save"
+)
+
+// BaselineName is the file, in the image directory, that keeps the
+// directory's baseline.
+const BaselineName = ".imageslim-baseline.json"
+
+// DefaultBaselineTolerance is how many percentage points the savings or
+// failure rate may stray from the baseline when
+// Options.BaselineTolerance is zero.
+const DefaultBaselineTolerance = 10
+
+// baselineMinFiles is the fewest files a run must convert (or fail) for
+// its rates to be compared with the baseline, or to start one: with fewer,
+// a single unusual image moves them too far.
+const baselineMinFiles = 10
+
+// FailBaseline is the category of the error returned when a run strays
+// from its baseline.
+const FailBaseline = "baseline"
+
+// ParseBaseline maps a user-supplied setting to Options.Baseline: "check",
+// "save", or "" / "off" / "none".
+func ParseBaseline(s string) (string, error) {
+	switch t := strings.ToLower(strings.TrimSpace(s)); t {
+	case "", "off", "none":
+		return BaselineOff, nil
+	case BaselineCheck, BaselineSave:
+		return t, nil
+	}
+	return "", fmt.Errorf("baseline must be check, save or off, got %q", s)
+}
+
+// Baseline is what a typical run over a directory achieves, saved so that
+// later runs can be compared with it.
+type Baseline struct {
+	Saved     time.Time `json:"saved"`
+	GMVersion string    `json:"gm_version,omitempty"` // first line of "gm version"
+	Files     int       `json:"files"`                // converted or failed
+	Failed    int       `json:"failed"`
+	BytesIn   int64     `json:"bytes_in"` // of the converted files
+	BytesOut  int64     `json:"bytes_out"`
+}
+
+// baselineOf sums up the files that res converted or failed.
+func baselineOf(res Result) Baseline {
+	b := Baseline{BytesIn: res.BytesIn, BytesOut: res.BytesOut}
+	for _, row := range res.Files {
+		switch row.Status {
+		case ReportFailed:
+			b.Failed++
+			fallthrough
+		case ReportConverted:
+			b.Files++
+		}
+	}
+	return b
+}
+
+// Savings is the percentage of bytes the converted files lost.
+func (b Baseline) Savings() float64 {
+	if b.BytesIn == 0 {
+		return 0
+	}
+	return 100 * (1 - float64(b.BytesOut)/float64(b.BytesIn))
+}
+
+// FailureRate is the percentage of files that failed.
+func (b Baseline) FailureRate() float64 {
+	if b.Files == 0 {
+		return 0
+	}
+	return 100 * float64(b.Failed) / float64(b.Files)
+}
+
+// deviations describes how cur strays from b by more than tolerance
+// percentage points: savings either way, failures only upwards.
+func (b Baseline) deviations(cur Baseline, tolerance int) []string {
+	var out []string
+	if d := cur.Savings() - b.Savings(); d > float64(tolerance) || d < -float64(tolerance) {
+		out = append(out, fmt.Sprintf("saved %.0f%% instead of %.0f%%", cur.Savings(), b.Savings()))
+	}
+	if cur.FailureRate()-b.FailureRate() > float64(tolerance) {
+		out = append(out, fmt.Sprintf("%.0f%% of files failed instead of %.0f%%", cur.FailureRate(), b.FailureRate()))
+	}
+	return out
+}
+
+// loadBaseline reads the baseline at path.
+func loadBaseline(path string) (Baseline, error) {
+	var b Baseline
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return b, err
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return b, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// save writes b to path.
+func (b Baseline) save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// checkBaseline compares the run in res with the baseline of opts.Dir, or
+// saves it as the baseline: on request, or when there is none yet and the
+// run succeeded.  Notes go to log; a deviation is returned as an error with
+// category FailBaseline.
+func checkBaseline(bin string, opts Options, res Result, log io.Writer) error {
+	if opts.Baseline == BaselineOff {
+		return nil
+	}
+	cur := baselineOf(res)
+	if cur.Files == 0 {
+		return nil
+	}
+	cur.Saved = time.Now().Truncate(time.Second)
+	cur.GMVersion, _ = gmVersion(bin)
+	path := filepath.Join(opts.Dir, BaselineName)
+
+	prev, err := loadBaseline(path)
+	missing := errors.Is(err, fs.ErrNotExist)
+	if err != nil && !missing {
+		return fmt.Errorf("baseline: %w", err)
+	}
+	if opts.Baseline == BaselineSave || missing {
+		if opts.Baseline == BaselineCheck && (res.Err != nil || cur.Files < baselineMinFiles) {
+			fmt.Fprintf(log, "note: no baseline yet; this run is too small or failed to start one (%d files needed)\n", baselineMinFiles)
+			return nil
+		}
+		if err := cur.save(path); err != nil {
+			return fmt.Errorf("baseline: %w", err)
+		}
+		fmt.Fprintf(log, "note: saved the baseline: %.0f%% saved, %.0f%% of %d files failed\n", cur.Savings(), cur.FailureRate(), cur.Files)
+		return nil
+	}
+
+	if cur.Files < baselineMinFiles {
+		fmt.Fprintf(log, "note: only %d files converted, too few to compare with the baseline\n", cur.Files)
+		return nil
+	}
+	tolerance := opts.BaselineTolerance
+	if tolerance == 0 {
+		tolerance = DefaultBaselineTolerance
+	}
+	devs := prev.deviations(cur, tolerance)
+	if len(devs) == 0 {
+		fmt.Fprintf(log, "note: within %d points of the baseline of %s (%.0f%% saved)\n", tolerance, prev.Saved.Format("2006-01-02"), prev.Savings())
+		return nil
+	}
+	msg := strings.Join(devs, " and ")
+	if prev.GMVersion != "" && cur.GMVersion != "" && prev.GMVersion != cur.GMVersion {
+		msg += fmt.Sprintf(" (gm was %q, now %q)", prev.GMVersion, cur.GMVersion)
+	}
+	return WithCategory(FailBaseline, fmt.Errorf("results strayed from the baseline of %s: %s", prev.Saved.Format("2006-01-02"), msg))
+}
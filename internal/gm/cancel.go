@@ -0,0 +1,56 @@
+package gm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Cancellation and timeouts
+// ---------------------------------------------------------------------------
+
+// ParseTimeout maps a user-supplied run timeout such as "90m" or "2h" to an
+// Options.Timeout value.  "" and "none" mean no limit.
+func ParseTimeout(s string) (time.Duration, error) {
+	t := strings.ToLower(strings.TrimSpace(s))
+	if t == "" || t == "none" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(t)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("timeout must be a duration such as 90m or 2h, got %q", s)
+	}
+	return d, nil
+}
+
+// FormatTimeout renders d so that ParseTimeout reads it back, without the
+// zero minutes and seconds time.Duration spells out: "2h", "1h30m".  It
+// returns "" for no limit.
+func FormatTimeout(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// stopped returns why a run whose ctx is done ended early, with category
+// FailTimeout or FailCancelled.
+func stopped(ctx context.Context, opts Options) error {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return WithCategory(FailCancelled, errors.New("the run was cancelled"))
+	}
+	if opts.Timeout > 0 {
+		return WithCategory(FailTimeout, fmt.Errorf("the run took longer than its %s timeout and was stopped", FormatTimeout(opts.Timeout)))
+	}
+	return WithCategory(FailTimeout, errors.New("the run took too long and was stopped"))
+}
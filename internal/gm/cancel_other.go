@@ -0,0 +1,15 @@
+//go:build !unix
+
+package gm
+
+import (
+	"context"
+	"os/exec"
+)
+
+// command returns an exec.Cmd for name that is killed once ctx is done.
+// There are no process groups here, so delegate programs gm started may
+// outlive it.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
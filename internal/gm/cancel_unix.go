@@ -0,0 +1,23 @@
+//go:build unix
+
+package gm
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// command returns an exec.Cmd for name that is killed once ctx is done,
+// together with every process it started: gm hands some formats to
+// delegate programs, which would otherwise carry on after the run.  The
+// command gets a process group of its own for that, so a Ctrl+C in the
+// terminal reaches ImageSlim only, whose caller then cancels ctx.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd
+}
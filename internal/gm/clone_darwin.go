@@ -0,0 +1,9 @@
+package gm
+
+import "golang.org/x/sys/unix"
+
+// clone creates dst as a clone of src sharing its blocks (clonefile, on
+// APFS), with src's permissions.  dst must not exist.
+func clone(src, dst string) error {
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
@@ -0,0 +1,36 @@
+package gm
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// clone creates dst as a clone of src sharing its blocks (FICLONE, on
+// Btrfs, XFS and other filesystems that support it), with src's
+// permissions.  dst must not exist.
+func clone(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package gm
+
+import "errors"
+
+// clone reports that files cannot be cloned here; copyFile copies them.
+func clone(src, dst string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,217 @@
+package gm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Step conditions: pipeline steps that only run for some files
+// ---------------------------------------------------------------------------
+
+// Predicates of a step condition: the shape of the source image, or the
+// format of the output.
+var conditionPredicates = []string{
+	"landscape", "portrait", "square",
+	"jpeg", "png", "gif", "webp", "tiff",
+}
+
+// Fields a step condition compares: the source's width and height in
+// pixels, its number of distinct colours and its size in bytes, which may
+// be written like min_file_size, e.g. 500KB.
+var conditionFields = []string{"width", "height", "colors", "size"}
+
+// conditionRE matches a comparison of a step condition, e.g. "width > 1600"
+// or "colors>256".
+var conditionRE = regexp.MustCompile(`^([a-z]+)\s*(>=|<=|!=|=|>|<)\s*(\S+)$`)
+
+// condTerm is one term of a step condition: a predicate, or a field
+// compared with a value.
+type condTerm struct {
+	field string
+	op    string // empty for predicates
+	value int64
+}
+
+// String returns t as parseCondition reads it.
+func (t condTerm) String() string {
+	if t.op == "" {
+		return t.field
+	}
+	v := strconv.FormatInt(t.value, 10)
+	if t.field == "size" && t.value > 0 {
+		v = FormatFileSize(t.value)
+	}
+	return t.field + " " + t.op + " " + v
+}
+
+// parseCondition reads the condition of a step, the terms after its "if"
+// joined by "and", e.g. "landscape and width > 1600" or "png and
+// colors > 256".
+func parseCondition(s string) ([]condTerm, error) {
+	var terms []condTerm
+	for _, t := range strings.Split(strings.ToLower(strings.TrimSpace(s)), " and ") {
+		t = strings.TrimSpace(t)
+		if t == "jpg" {
+			t = "jpeg"
+		}
+		if slices.Contains(conditionPredicates, t) {
+			terms = append(terms, condTerm{field: t})
+			continue
+		}
+		m := conditionRE.FindStringSubmatch(t)
+		if m == nil || !slices.Contains(conditionFields, m[1]) {
+			return nil, fmt.Errorf("condition %q: %q is not one of %s, or a comparison of %s, e.g. width > 1600",
+				s, t, strings.Join(conditionPredicates, ", "), strings.Join(conditionFields, ", "))
+		}
+		term := condTerm{field: m[1], op: m[2]}
+		var err error
+		if term.field == "size" {
+			term.value, err = ParseFileSize(m[3])
+		} else {
+			term.value, err = strconv.ParseInt(strings.TrimSuffix(m[3], "px"), 10, 64)
+			if err == nil && term.value < 0 {
+				err = fmt.Errorf("%s cannot be negative", term.field)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %w", s, err)
+		}
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+// formatCondition returns terms as parseCondition reads them.
+func formatCondition(terms []condTerm) string {
+	var ss []string
+	for _, t := range terms {
+		ss = append(ss, t.String())
+	}
+	return strings.Join(ss, " and ")
+}
+
+// fileFacts is what step conditions know about the file being converted.
+// They are read from the source, not from what earlier steps made of it,
+// and only once a condition needs them.
+type fileFacts struct {
+	bin, src, out string // src is absolute; out decides the format
+
+	width, height int
+	colors        int
+	size          int64
+	sized         bool // width and height are read
+	counted       bool // colors is read
+	stated        bool // size is read
+}
+
+// holds reports whether the condition cond is true of the file.
+func (f *fileFacts) holds(cond string) (bool, error) {
+	terms, err := parseCondition(cond)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range terms {
+		var v int64
+		switch t.field {
+		case "landscape", "portrait", "square", "width", "height":
+			if !f.sized {
+				if f.width, f.height, err = imageSize(f.bin, f.src); err != nil {
+					return false, err
+				}
+				f.sized = true
+			}
+		}
+		switch t.field {
+		case "landscape":
+			if f.width <= f.height {
+				return false, nil
+			}
+			continue
+		case "portrait":
+			if f.height <= f.width {
+				return false, nil
+			}
+			continue
+		case "square":
+			if f.width != f.height {
+				return false, nil
+			}
+			continue
+		case "jpeg":
+			if !isJPEG(f.out) {
+				return false, nil
+			}
+			continue
+		case "png", "gif", "webp", "tiff":
+			ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(f.out)), ".")
+			if ext != t.field && !(t.field == "tiff" && ext == "tif") {
+				return false, nil
+			}
+			continue
+		case "width":
+			v = int64(f.width)
+		case "height":
+			v = int64(f.height)
+		case "colors":
+			if !f.counted {
+				if f.colors, err = countColors(f.bin, f.src); err != nil {
+					return false, err
+				}
+				f.counted = true
+			}
+			v = int64(f.colors)
+		case "size":
+			if !f.stated {
+				fi, err := os.Stat(f.src)
+				if err != nil {
+					return false, err
+				}
+				f.size, f.stated = fi.Size(), true
+			}
+			v = f.size
+		}
+		if !compare(v, t.op, t.value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// compare reports whether "v op w" holds.
+func compare(v int64, op string, w int64) bool {
+	switch op {
+	case ">":
+		return v > w
+	case ">=":
+		return v >= w
+	case "<":
+		return v < w
+	case "<=":
+		return v <= w
+	case "=":
+		return v == w
+	}
+	return v != w
+}
+
+// countColors asks "gm identify" for the number of distinct colours of the
+// image at path.  Animated GIFs print a line per frame; the first one
+// counts.
+func countColors(bin, path string) (int, error) {
+	out, err := exec.Command(bin, "identify", "-format", "%k\n", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("identify: %w", err)
+	}
+	var n int
+	if _, err := fmt.Sscan(string(out), &n); err != nil {
+		return 0, fmt.Errorf("identify: unexpected output %q", strings.TrimSpace(string(out)))
+	}
+	return n, nil
+}
@@ -0,0 +1,155 @@
+package gm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Conflicts: preserve-mode outputs that would land on a file already there
+// ---------------------------------------------------------------------------
+
+// Options.OnConflict values.
+const (
+	// ConflictOverwrite replaces the file already there.  It is the
+	// default, and what the empty string means.
+	ConflictOverwrite = "overwrite"
+	// ConflictSkip leaves the source alone, keeping the file.
+	ConflictSkip = "skip"
+	// ConflictRename writes the output under the first free name with a
+	// numeric suffix, e.g. photo-1.jpg.
+	ConflictRename = "rename"
+)
+
+// ParseConflict converts a job file's on_conflict value to its
+// Options.OnConflict value: ConflictSkip, ConflictRename, or "" for
+// ConflictOverwrite.  Matching is case-insensitive.
+func ParseConflict(s string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(s)); v {
+	case "", ConflictOverwrite:
+		return "", nil
+	case ConflictSkip, ConflictRename:
+		return v, nil
+	}
+	return "", fmt.Errorf("on conflict must be %s, %s or %s, got %q", ConflictOverwrite, ConflictSkip, ConflictRename, s)
+}
+
+// conflict is what became of a file whose output was already there.
+type conflict int
+
+const (
+	noConflict conflict = iota
+	conflictReplaced
+	conflictRenamed
+	conflictSkipped
+)
+
+// errOutputExists is returned by convertFile when ConflictSkip left a file
+// alone only once its output name was known, i.e. for name templates with
+// the image's dimensions.
+var errOutputExists = errors.New("output already exists")
+
+// claims are the outputs the files of a run claimed so far, relative to
+// opts.Dir.  Names are compared ignoring case: IMG.jpg and img.JPG are one
+// file on macOS and Windows, and an output tree is often copied there.
+type claims map[string]claim
+
+// claim is one claimed output: the file claiming it and the name as it
+// claimed it.
+type claim struct {
+	rel, out string
+}
+
+// owner returns the file that claimed out, in any case, or "".
+func (c claims) owner(out string) string {
+	return c[strings.ToLower(out)].rel
+}
+
+// set records that rel claimed out.
+func (c claims) set(out, rel string) {
+	c[strings.ToLower(out)] = claim{rel: rel, out: out}
+}
+
+// caseClash returns the other file of the run whose output is named out
+// but for case, or "".
+func (c claims) caseClash(rel, out string) string {
+	if cl, ok := c[strings.ToLower(out)]; ok && cl.rel != rel && cl.out != out {
+		return cl.rel
+	}
+	return ""
+}
+
+// resolveOutput decides where rel's output goes when out, relative to
+// opts.Dir, is already there.  A file rel's own earlier conversion wrote,
+// and names another file of this run claimed (which claimOutput reports),
+// are no conflict.  A name another file claimed in another case is: the
+// output is renamed whatever the policy, since replacing it would lose the
+// other file's, or left alone with ConflictSkip; clash then names the other
+// file.  The caller holds the lock on written and man.
+func resolveOutput(opts Options, man *manifest, written claims, rel, out string) (name string, outcome conflict, clash string) {
+	if opts.Overwrite {
+		return out, noConflict, ""
+	}
+	if clash = written.caseClash(rel, out); clash != "" {
+		if opts.OnConflict == ConflictSkip {
+			return out, conflictSkipped, clash
+		}
+		return freeName(opts, man, written, rel, out), conflictRenamed, clash
+	}
+	if written.owner(out) != "" || !existsAt(opts, out) || man.owns(rel, out, filepath.Join(opts.Dir, out)) {
+		return out, noConflict, ""
+	}
+	switch opts.OnConflict {
+	case ConflictSkip:
+		return out, conflictSkipped, ""
+	case ConflictRename:
+		return freeName(opts, man, written, rel, out), conflictRenamed, ""
+	}
+	return out, conflictReplaced, ""
+}
+
+// freeName returns the first name for rel's output out with a numeric
+// suffix, e.g. photo-1.jpg, that no other file claimed and that holds no
+// file but rel's own earlier output.
+func freeName(opts Options, man *manifest, written claims, rel, out string) string {
+	ext := filepath.Ext(out)
+	stem := strings.TrimSuffix(out, ext)
+	for n := 1; ; n++ {
+		name := stem + "-" + strconv.Itoa(n) + ext
+		if written.owner(name) == "" && (!existsAt(opts, name) || man.owns(rel, name, filepath.Join(opts.Dir, name))) {
+			return name
+		}
+	}
+}
+
+// existsAt reports whether there is a file at out, relative to opts.Dir.
+func existsAt(opts Options, out string) bool {
+	_, err := os.Lstat(filepath.Join(opts.Dir, out))
+	return err == nil
+}
+
+// resolverKey is the context key of the resolver of the file being
+// converted.
+type resolverKey struct{}
+
+// resolver is resolveOutput for one file, with the run's lock taken.
+type resolver func(out string) (string, conflict)
+
+// withResolver returns ctx carrying r, which finishTemplate asks about the
+// final name.
+func withResolver(ctx context.Context, r resolver) context.Context {
+	return context.WithValue(ctx, resolverKey{}, r)
+}
+
+// resolve asks the resolver in ctx, if any, where out goes.
+func resolve(ctx context.Context, out string) (string, conflict) {
+	if r, ok := ctx.Value(resolverKey{}).(resolver); ok {
+		return r(out)
+	}
+	return out, noConflict
+}
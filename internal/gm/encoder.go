@@ -0,0 +1,145 @@
+package gm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// WebP and AVIF output
+// ---------------------------------------------------------------------------
+
+// Output formats for Options.OutputFormat.
+const (
+	OutputWebP = "webp"
+	OutputAVIF = "avif"
+)
+
+// OutputFormats are the values Options.OutputFormat accepts besides "".
+var OutputFormats = []string{OutputWebP, OutputAVIF}
+
+// MaxEffort is the slowest, most thorough Options.Effort.
+const MaxEffort = 10
+
+// ParseOutputFormat maps a user-supplied format to Options.OutputFormat:
+// "webp", "avif", or "" / "original" to keep each file's own format.
+func ParseOutputFormat(s string) (string, error) {
+	t := strings.ToLower(strings.TrimSpace(s))
+	switch t {
+	case "", "original", "keep":
+		return "", nil
+	case OutputWebP, OutputAVIF:
+		return t, nil
+	}
+	return "", fmt.Errorf("format must be webp, avif or original, got %q", s)
+}
+
+// withFormat replaces the extension of name with opts.OutputFormat, if set.
+// HEIC photos otherwise become JPEGs, since few programs can write HEIC.
+func withFormat(opts Options, name string) string {
+	switch {
+	case opts.OutputFormat != "":
+		return strings.TrimSuffix(name, filepath.Ext(name)) + "." + opts.OutputFormat
+	case isHEIC(name):
+		return strings.TrimSuffix(name, filepath.Ext(name)) + ".jpg"
+	}
+	return name
+}
+
+// encoder is the external program that writes opts.OutputFormat.  gm
+// converts each file to a lossless PNG first, which the encoder compresses.
+// The zero value means gm writes the format itself.
+type encoder struct {
+	path   string
+	format string
+}
+
+// encoderTools are the programs for each output format, and what to say
+// when they are missing.
+var encoderTools = map[string]struct {
+	name, label, install string
+}{
+	OutputWebP: {"cwebp", "WebP", "brew install webp / apt install webp"},
+	OutputAVIF: {"avifenc", "AVIF", "brew install libavif / apt install libavif-bin"},
+}
+
+// findEncoder picks how opts.OutputFormat is written: with cwebp or avifenc
+// when installed, otherwise with gm if this build can write the format.
+// note tells the user about the fallback.
+func findEncoder(opts Options) (enc encoder, note string, err error) {
+	if opts.OutputFormat == "" {
+		return encoder{}, "", nil
+	}
+	tool := encoderTools[opts.OutputFormat]
+	if p, err := exec.LookPath(tool.name); err == nil {
+		return encoder{path: p, format: opts.OutputFormat}, "", nil
+	}
+	formats, err := ListFormats(opts)
+	if err != nil {
+		return encoder{}, "", err
+	}
+	name := strings.ToUpper(opts.OutputFormat)
+	if slices.ContainsFunc(formats, func(f Format) bool { return f.Name == name && f.Write }) {
+		return encoder{}, fmt.Sprintf("%s is not installed; gm writes the %s files (%s)", tool.name, tool.label, tool.install), nil
+	}
+	return encoder{}, "", WithCategory(FailOptions, fmt.Errorf("%s output needs %s (%s) or a GraphicsMagick built with %s support", tool.label, tool.name, tool.install, tool.label))
+}
+
+// args returns the encoder's arguments for turning the PNG in into out.
+func (e encoder) args(opts Options, in, out string) []string {
+	switch e.format {
+	case OutputWebP:
+		args := []string{"-quiet", "-q", strconv.Itoa(opts.Quality)}
+		if opts.Effort > 0 {
+			args = append(args, "-m", strconv.Itoa(webpMethod(opts.Effort)))
+		}
+		return append(args, in, "-o", out)
+	case OutputAVIF:
+		args := []string{"-q", strconv.Itoa(opts.Quality)}
+		if opts.Effort > 0 {
+			args = append(args, "-s", strconv.Itoa(avifSpeed(opts.Effort)))
+		}
+		return append(args, in, out)
+	}
+	return nil
+}
+
+// webpMethod maps an effort of 1–MaxEffort to cwebp's -m 0–6.
+func webpMethod(effort int) int {
+	return int(math.Round(float64(effort-1) * 6 / (MaxEffort - 1)))
+}
+
+// avifSpeed maps an effort of 1–MaxEffort to avifenc's -s 9–0: the more
+// effort, the slower.
+func avifSpeed(effort int) int {
+	return MaxEffort - effort
+}
+
+// encode converts in with gm into a PNG next to out and compresses that
+// into out with the encoder.
+func (e encoder) encode(ctx context.Context, bin string, opts Options, rel, in, out string, log io.Writer) error {
+	png := filepath.Join(filepath.Dir(out), partialPrefix+filepath.Base(out)+".png")
+	defer os.Remove(filepath.Join(opts.Dir, png))
+	o := opts
+	o.Interlace = "" // an interlaced PNG would only be slower to write
+	if err := encode(ctx, bin, o, rel, in, png, log); err != nil {
+		return err
+	}
+	defer timeStage(ctx, stageEncode, time.Now())
+	cmd := command(ctx, e.path, e.args(opts, png, out)...)
+	cmd.Dir = opts.Dir
+	attach(cmd, log)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %s: %w", rel, filepath.Base(e.path), err)
+	}
+	return nil
+}
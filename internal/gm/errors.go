@@ -0,0 +1,61 @@
+package gm
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+)
+
+// Failure categories returned by Category.  They group errors coarsely
+// enough to be counted and explained without looking at their text.
+const (
+	FailNoGM       = "gm-missing"      // gm binary not found or not runnable
+	FailGMVersion  = "gm-version"      // gm is not the version Options.GMVersion pins
+	FailOptions    = "invalid-options" // rejected by Options.Validate
+	FailNotFound   = "not-found"       // a directory or file does not exist
+	FailPermission = "permission"      // the file system refused access
+	FailGM         = "gm-error"        // gm exited with an error for a file
+	FailTimeout    = "timeout"         // the run took longer than Options.Timeout
+	FailCancelled  = "cancelled"       // the caller cancelled the run
+	FailOther      = "other"
+)
+
+// categoryError tags an error with a failure category; its message is the
+// wrapped error's.
+type categoryError struct {
+	category string
+	err      error
+}
+
+func (e *categoryError) Error() string { return e.err.Error() }
+func (e *categoryError) Unwrap() error { return e.err }
+
+// WithCategory tags err with a failure category for Category to report,
+// leaving its message unchanged.  A nil err stays nil.
+func WithCategory(category string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &categoryError{category: category, err: err}
+}
+
+// Category classifies err into one of the Fail* categories, or a category
+// attached with WithCategory.  It returns "" for a nil error.
+func Category(err error) string {
+	if err == nil {
+		return ""
+	}
+	var ce *categoryError
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &ce):
+		return ce.category
+	case errors.As(err, &exit):
+		return FailGM
+	case errors.Is(err, fs.ErrNotExist):
+		return FailNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return FailPermission
+	}
+	return FailOther
+}
@@ -0,0 +1,142 @@
+package gm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Exec mode: any per-file converter, driven like gm
+// ---------------------------------------------------------------------------
+
+// Placeholders of Options.Exec.
+const (
+	ExecIn  = "{in}"
+	ExecOut = "{out}"
+)
+
+// BackendExec is the ReportRow.Backend of files an Options.Exec command
+// converted.
+const BackendExec = "exec"
+
+// validateExec checks that o.Exec names both files and that nothing in o
+// asks for something only gm would do, which the command would silently
+// leave out.
+func (o Options) validateExec() error {
+	if !strings.Contains(o.Exec, ExecIn) || !strings.Contains(o.Exec, ExecOut) {
+		return fmt.Errorf("exec command must contain %s and %s, got %q", ExecIn, ExecOut, o.Exec)
+	}
+	var gmOnly []string
+	if o.Lossless {
+		gmOnly = append(gmOnly, "lossless mode")
+	}
+	if o.AutoOrient {
+		gmOnly = append(gmOnly, "auto-orient")
+	}
+	if o.Rotate != "" {
+		gmOnly = append(gmOnly, "rotation")
+	}
+	if o.Flip || o.Flop {
+		gmOnly = append(gmOnly, "flipping")
+	}
+	if o.Interlace != "" {
+		gmOnly = append(gmOnly, "progressive JPEGs")
+	}
+	if o.Subsampling != "" {
+		gmOnly = append(gmOnly, "chroma subsampling")
+	}
+	if o.Sharpen != "" {
+		gmOnly = append(gmOnly, "sharpening")
+	}
+	if o.TargetSize > 0 {
+		gmOnly = append(gmOnly, "a target size")
+	}
+	if o.SmartQuality {
+		gmOnly = append(gmOnly, "smart quality")
+	}
+	if o.MaxColors > 0 {
+		gmOnly = append(gmOnly, "a maximum colour count")
+	}
+	if o.ConvertToSRGB {
+		gmOnly = append(gmOnly, "sRGB conversion")
+	}
+	if o.Tone != "" {
+		gmOnly = append(gmOnly, "a "+o.Tone+" tone")
+	}
+	if o.DropAlpha {
+		gmOnly = append(gmOnly, "dropping alpha channels")
+	}
+	if o.OptimizePNG != "" {
+		gmOnly = append(gmOnly, "PNG optimisation")
+	}
+	if o.OutputFormat != "" {
+		gmOnly = append(gmOnly, "an output format (name the outputs with a name template instead)")
+	}
+	if o.HEIC {
+		gmOnly = append(gmOnly, "HEIC conversion")
+	}
+	if o.Watermark.Enabled() {
+		gmOnly = append(gmOnly, "a watermark")
+	}
+	if o.Fallback != "" {
+		gmOnly = append(gmOnly, "a fallback")
+	}
+	if len(gmOnly) > 0 {
+		return fmt.Errorf("an exec command converts the files instead of gm, so it cannot be combined with %s", strings.Join(gmOnly, ", "))
+	}
+	return nil
+}
+
+// execLine returns opts.Exec with {in} and {out} replaced by in and out,
+// quoted for the shell with shellQuote.
+func execLine(opts Options, in, out string) string {
+	return strings.NewReplacer(ExecIn, shellQuote(in), ExecOut, shellQuote(out)).Replace(opts.Exec)
+}
+
+// shellQuote quotes s for the shell as one word that nothing in is
+// expanded.  Unlike shellJoin, which leaves words that look plain bare for
+// people to read, it always uses single quotes: a file named a{1,2}.jpg is
+// two files to bash, which is sh on macOS.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// execFile runs opts.Exec through the shell to convert in into out, both
+// relative to opts.Dir, which is its working directory.  The command
+// writes to a partial file beside out, which only takes out's place once
+// the command has succeeded, so that in overwrite mode, where out is the
+// original itself, a failed command leaves it alone.  It has the
+// signature of encode, whose place it takes.
+func execFile(ctx context.Context, bin string, opts Options, rel, in, out string, log io.Writer) error {
+	tmp := filepath.Join(filepath.Dir(out), partialPrefix+filepath.Base(out))
+	abs := filepath.Join(opts.Dir, tmp)
+	defer os.Remove(abs)
+
+	cmd := command(ctx, "sh", "-c", execLine(opts, in, tmp))
+	cmd.Dir = opts.Dir
+	attach(cmd, log)
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: exec: %w", rel, err)
+	}
+	timeStage(ctx, stageEncode, start)
+	defer timeStage(ctx, stageWrite, time.Now())
+
+	if _, err := os.Stat(abs); err != nil {
+		return fmt.Errorf("%s: exec command wrote nothing to %s", rel, ExecOut)
+	}
+	if err := os.Rename(abs, filepath.Join(opts.Dir, out)); err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	return nil
+}
+
+// execCommand describes what exec mode runs, for Result.Command.
+func execCommand(opts Options) string {
+	return fmt.Sprintf("(in %s)\n%s", opts.Dir, strings.NewReplacer(ExecIn, "{file}", ExecOut, displayOutput(opts)).Replace(opts.Exec))
+}
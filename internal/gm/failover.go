@@ -0,0 +1,96 @@
+package gm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Failover: a second program for the files gm cannot convert
+// ---------------------------------------------------------------------------
+
+// FallbackMagick is the Options.Fallback that retries files gm fails on
+// with ImageMagick 7's magick, which takes the same arguments.
+const FallbackMagick = "magick"
+
+// ParseFallback converts a job file's fallback value to its
+// Options.Fallback value: "magick", or "" for none.  Matching is
+// case-insensitive; "none" and "off" mean none.
+func ParseFallback(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "none", "off":
+		return "", nil
+	case FallbackMagick:
+		return FallbackMagick, nil
+	}
+	return "", fmt.Errorf("fallback must be %s or none, got %q", FallbackMagick, s)
+}
+
+// magickInstall says how to get magick.
+const magickInstall = "brew install imagemagick / ImageMagick 7 from imagemagick.org"
+
+// failover is the fallback for the file being converted, and whether it
+// had to be used.  It belongs to one worker, so needs no lock.
+type failover struct {
+	path string // the fallback program; "" when there is none
+	used bool
+}
+
+// failoverKey is the context key of the failover of the file being
+// converted.
+type failoverKey struct{}
+
+// withFailover returns ctx carrying f, which encode turns to when gm fails.
+func withFailover(ctx context.Context, f *failover) context.Context {
+	return context.WithValue(ctx, failoverKey{}, f)
+}
+
+// findFallback looks up opts.Fallback.  When it is not installed the run
+// goes ahead without it, and note says so.
+func findFallback(opts Options) (path, note string) {
+	if opts.Fallback == "" {
+		return "", ""
+	}
+	p, err := exec.LookPath(opts.Fallback)
+	if err != nil {
+		return "", fmt.Sprintf("%s is not installed, so files gm cannot convert fail the run (%s)", opts.Fallback, magickInstall)
+	}
+	return p, ""
+}
+
+// magickArgs turns gm arguments into magick's: "convert" is magick's
+// default, and the +matte GraphicsMagick drops alpha channels with is
+// -alpha off.
+func magickArgs(args []string) []string {
+	args = slices.Clone(args)
+	if args[0] == "convert" {
+		args = args[1:]
+	}
+	if i := slices.Index(args, "+matte"); i >= 0 {
+		args = slices.Replace(args, i, i+1, "-alpha", "off")
+	}
+	return args
+}
+
+// failOver retries the gm call args, which failed with err, with the
+// fallback in ctx, telling log.  It returns the program that succeeded, or
+// err — with the fallback's own failure added when it was tried.
+func failOver(ctx context.Context, opts Options, rel string, args []string, err error, log io.Writer) (string, error) {
+	f, ok := ctx.Value(failoverKey{}).(*failover)
+	if !ok || f.path == "" || ctx.Err() != nil {
+		return "", err
+	}
+	fmt.Fprintf(log, "note: %s: gm failed (%v); trying %s\n", rel, err, opts.Fallback)
+	cmd := command(ctx, f.path, magickArgs(args)...)
+	cmd.Dir = opts.Dir
+	attach(cmd, log)
+	if ferr := cmd.Run(); ferr != nil {
+		return "", fmt.Errorf("%w; %s: %w", err, opts.Fallback, ferr)
+	}
+	f.used = true
+	return f.path, nil
+}
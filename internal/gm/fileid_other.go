@@ -0,0 +1,23 @@
+//go:build !unix
+
+package gm
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// fileID identifies a file or directory however many paths lead to it:
+// here, by its absolute path with every link resolved.
+type fileID struct{ path string }
+
+// idOf returns the ID of the file at path, which fi describes.
+func idOf(path string, fi fs.FileInfo) fileID {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return fileID{path: path}
+}
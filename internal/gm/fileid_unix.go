@@ -0,0 +1,20 @@
+//go:build unix
+
+package gm
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID identifies a file or directory however many paths lead to it.
+type fileID struct{ dev, ino uint64 }
+
+// idOf returns the ID of the file at path, which fi describes.
+func idOf(path string, fi fs.FileInfo) fileID {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}
+	}
+	return fileID{dev: uint64(st.Dev), ino: st.Ino}
+}
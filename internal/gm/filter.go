@@ -0,0 +1,226 @@
+package gm
+
+import (
+	"fmt"
+	_ "image/gif" // register decoders for headerSize
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/geometry"
+)
+
+// ---------------------------------------------------------------------------
+// Minimum-size filter
+// ---------------------------------------------------------------------------
+
+// sizeUnits are the suffixes ParseFileSize accepts, SI and binary.
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9},
+	{"k", 1e3}, {"m", 1e6}, {"g", 1e9},
+	{"b", 1},
+}
+
+// ParseFileSize maps a user-supplied size such as "500KB", "1.5 MB", "2MiB"
+// or "40000" (bytes) to its Options.MinFileSize value.  KB, MB and GB are
+// 1000-based like the sizes ImageSlim prints; KiB, MiB and GiB are
+// 1024-based.  "" and "none" mean no minimum.
+func ParseFileSize(s string) (int64, error) {
+	t := strings.ToLower(strings.TrimSpace(s))
+	if t == "" || t == "none" {
+		return 0, nil
+	}
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(t, u.suffix) {
+			t, factor = strings.TrimSpace(strings.TrimSuffix(t, u.suffix)), u.factor
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(t, 64)
+	if err != nil || v < 0 || math.IsInf(v, 0) || v*float64(factor) > math.MaxInt64/2 {
+		return 0, fmt.Errorf("file size must be a number with an optional unit like 500KB or 2MB, got %q", s)
+	}
+	return int64(math.Round(v * float64(factor))), nil
+}
+
+// FormatFileSize renders n so that ParseFileSize reads it back exactly:
+// "500KB" rather than "500 kB", and plain bytes when no unit fits.
+func FormatFileSize(n int64) string {
+	switch {
+	case n == 0:
+		return ""
+	case n%1e9 == 0:
+		return strconv.FormatInt(n/1e9, 10) + "GB"
+	case n%1e6 == 0:
+		return strconv.FormatInt(n/1e6, 10) + "MB"
+	case n%1e3 == 0:
+		return strconv.FormatInt(n/1e3, 10) + "KB"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// ParseMinDimensions maps a user-supplied "WxH" to Options.MinWidth and
+// Options.MinHeight.  Either side may be left out: "2000x" only asks for a
+// width of at least 2000 pixels, "x1000" only for a height.  "" and "none"
+// mean no minimum.
+func ParseMinDimensions(s string) (width, height int, err error) {
+	if t := strings.ToLower(strings.TrimSpace(s)); t == "" || t == "none" {
+		return 0, 0, nil
+	}
+	g, err := geometry.Parse(s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("minimum dimensions: %w", err)
+	}
+	if g.Percent || g.Area || g.LongEdge || g.Flags != "" {
+		return 0, 0, fmt.Errorf("minimum dimensions must be pixels like 2000x or 2000x1000, got %q", s)
+	}
+	return g.Width, g.Height, nil
+}
+
+// FormatMinDimensions renders a minimum width and height for a job file.
+func FormatMinDimensions(width, height int) string {
+	if width == 0 && height == 0 {
+		return ""
+	}
+	var b strings.Builder
+	if width > 0 {
+		b.WriteString(strconv.Itoa(width))
+	}
+	b.WriteString("x")
+	if height > 0 {
+		b.WriteString(strconv.Itoa(height))
+	}
+	return b.String()
+}
+
+// filtersBySize reports whether opts skips files below a minimum size.
+func filtersBySize(opts Options) bool {
+	return opts.MinFileSize > 0 || opts.MinWidth > 0 || opts.MinHeight > 0
+}
+
+// belowMinimum reports whether src is smaller than opts allows, either in
+// bytes or in pixels.  The file size is checked first since it is free; the
+// dimensions are only read when a minimum is set.  An image whose
+// dimensions cannot be read is not filtered: converting it reports the
+// problem properly.
+func belowMinimum(bin string, opts Options, src string) (bool, error) {
+	if opts.MinFileSize > 0 {
+		fi, err := os.Stat(src)
+		if err != nil {
+			return false, err
+		}
+		if fi.Size() < opts.MinFileSize {
+			return true, nil
+		}
+	}
+	if opts.MinWidth == 0 && opts.MinHeight == 0 {
+		return false, nil
+	}
+	width, height, err := imageSize(bin, src)
+	if err != nil {
+		return false, nil
+	}
+	return width < opts.MinWidth || height < opts.MinHeight, nil
+}
+
+// imageSize returns the width and height of the image at path.  JPEG, PNG
+// and GIF headers are read directly, which is much faster than starting gm;
+// other formats are asked of "gm identify".
+func imageSize(bin, path string) (width, height int, err error) {
+	if width, height := headerSize(path); width > 0 {
+		return width, height, nil
+	}
+	return identifySize(bin, path)
+}
+
+// identifySize asks "gm identify" for the width and height of the image at
+// path.  -ping reads no more of the file than needed.  Animated GIFs print
+// a line per frame; the first one counts.
+func identifySize(bin, path string) (width, height int, err error) {
+	out, err := exec.Command(bin, "identify", "-ping", "-format", "%w %h\n", path).Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("identify: %w", err)
+	}
+	if _, err := fmt.Sscan(string(out), &width, &height); err != nil {
+		return 0, 0, fmt.Errorf("identify: unexpected output %q", strings.TrimSpace(string(out)))
+	}
+	return width, height, nil
+}
+
+// ---------------------------------------------------------------------------
+// Modification date filter
+// ---------------------------------------------------------------------------
+
+// dateLayouts are the absolute dates ParseDate accepts, most specific last.
+var dateLayouts = []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04", time.RFC3339}
+
+// relativeDateRE matches a date relative to today: "30d" or "2w" ago.
+var relativeDateRE = regexp.MustCompile(`^(\d+)\s*(d|w)$`)
+
+// ParseDate maps a user-supplied date to an Options.ModifiedAfter or
+// Options.ModifiedBefore value.  It accepts "2026-09-15", "2026-09-15 18:30",
+// an RFC 3339 time, or a number of days or weeks before today such as "30d"
+// or "2w", which means the start of that day.  Dates without a zone are in
+// now's location.  "" and "none" mean no limit.
+func ParseDate(s string, now time.Time) (time.Time, error) {
+	t := strings.ToLower(strings.TrimSpace(s))
+	if t == "" || t == "none" {
+		return time.Time{}, nil
+	}
+	if m := relativeDateRE.FindStringSubmatch(t); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err == nil && n <= 100*365 {
+			if m[2] == "w" {
+				n *= 7
+			}
+			y, mo, d := now.Date()
+			return time.Date(y, mo, d-n, 0, 0, 0, 0, now.Location()), nil
+		}
+	}
+	for _, layout := range dateLayouts {
+		if d, err := time.ParseInLocation(layout, strings.TrimSpace(s), now.Location()); err == nil {
+			return d, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("date must look like 2026-09-15 or 30d (days ago), got %q", s)
+}
+
+// FormatDate renders t so that ParseDate reads it back: just the day when t
+// is midnight, "2006-01-02 15:04" when it is a whole minute.
+func FormatDate(t time.Time) string {
+	switch {
+	case t.IsZero():
+		return ""
+	case t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0:
+		return t.Format("2006-01-02")
+	case t.Second() == 0 && t.Nanosecond() == 0:
+		return t.Format("2006-01-02 15:04")
+	}
+	return t.Format(time.RFC3339)
+}
+
+// filtersByDate reports whether opts limits the modification dates.
+func filtersByDate(opts Options) bool {
+	return !opts.ModifiedAfter.IsZero() || !opts.ModifiedBefore.IsZero()
+}
+
+// modifiedInRange reports whether a file modified at mod falls in opts'
+// date range.  ModifiedAfter is inclusive, ModifiedBefore exclusive, so
+// "after 2026-09-01, before 2026-10-01" is exactly September.
+func modifiedInRange(opts Options, mod time.Time) bool {
+	if !opts.ModifiedAfter.IsZero() && mod.Before(opts.ModifiedAfter) {
+		return false
+	}
+	return opts.ModifiedBefore.IsZero() || mod.Before(opts.ModifiedBefore)
+}
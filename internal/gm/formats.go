@@ -0,0 +1,105 @@
+package gm
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Supported formats
+// ---------------------------------------------------------------------------
+
+// Format is one entry of "gm convert -list format".
+type Format struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Read        bool   `json:"read,omitempty"`
+	Write       bool   `json:"write,omitempty"`
+}
+
+// formatLineRE matches a row of the format list:
+//
+//	JPEG P  rw-  Joint Photographic Experts Group JFIF format (62)
+//
+// The column between name and mode is empty for some formats.
+var formatLineRE = regexp.MustCompile(`^\s*([A-Z0-9][A-Z0-9_-]*)\s+(?:\S\s+)?([r-][w-][+-])(?:\s+(.*))?$`)
+
+// ListFormats asks the gm binary for opts which formats it can read and
+// write.  Formats whose delegate library was missing when GraphicsMagick was
+// built are absent from the list or lack the read or write flag.
+func ListFormats(opts Options) ([]Format, error) {
+	bin, err := Binary(opts)
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.Command(bin, "convert", "-list", "format").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s convert -list format: %w: %s", bin, err, strings.TrimSpace(string(out)))
+	}
+	formats := parseFormats(string(out))
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("%s convert -list format printed no formats", bin)
+	}
+	return formats, nil
+}
+
+// parseFormats extracts the formats from "gm convert -list format" output.
+func parseFormats(out string) []Format {
+	var formats []Format
+	for _, line := range strings.Split(out, "\n") {
+		m := formatLineRE.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+		formats = append(formats, Format{
+			Name:        m[1],
+			Description: strings.TrimSpace(m[3]),
+			Read:        m[2][0] == 'r',
+			Write:       m[2][1] == 'w',
+		})
+	}
+	return formats
+}
+
+// Capability says whether a commonly used format works with this gm build.
+type Capability struct {
+	Label       string
+	Read, Write bool
+}
+
+// commonFormats are the formats people ask about, with the gm names that
+// provide each one.
+var commonFormats = []struct {
+	label string
+	names []string
+}{
+	{"JPEG", []string{"JPEG", "JPG"}},
+	{"PNG", []string{"PNG"}},
+	{"WebP", []string{"WEBP"}},
+	{"GIF", []string{"GIF"}},
+	{"TIFF", []string{"TIFF", "TIF"}},
+	{"HEIC/HEIF", []string{"HEIC", "HEIF"}},
+	{"AVIF", []string{"AVIF"}},
+	{"JPEG XL", []string{"JXL"}},
+	{"BMP", []string{"BMP"}},
+}
+
+// Capabilities summarises formats for the common image formats, in a fixed
+// order, so users can see at a glance whether e.g. HEIC input or AVIF output
+// will work.
+func Capabilities(formats []Format) []Capability {
+	caps := make([]Capability, len(commonFormats))
+	for i, cf := range commonFormats {
+		caps[i].Label = cf.label
+		for _, f := range formats {
+			if slices.Contains(cf.names, f.Name) {
+				caps[i].Read = caps[i].Read || f.Read
+				caps[i].Write = caps[i].Write || f.Write
+			}
+		}
+	}
+	return caps
+}
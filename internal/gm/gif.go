@@ -0,0 +1,137 @@
+package gm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Animated GIFs
+// ---------------------------------------------------------------------------
+
+// Values of Options.AnimatedGIF.  The empty value keeps animations.
+const (
+	AnimatedKeep = ""
+	AnimatedSkip = "skip"
+)
+
+// ParseAnimatedGIF maps a user-supplied setting to Options.AnimatedGIF:
+// "keep" (or "") resizes every frame, "skip" leaves animated GIFs alone.
+func ParseAnimatedGIF(s string) (string, error) {
+	switch t := strings.ToLower(strings.TrimSpace(s)); t {
+	case "", "keep", "animate":
+		return AnimatedKeep, nil
+	case AnimatedSkip, "exclude":
+		return AnimatedSkip, nil
+	}
+	return "", fmt.Errorf("animated GIFs must be keep or skip, got %q", s)
+}
+
+// isGIF reports whether name has a GIF file extension.
+func isGIF(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".gif")
+}
+
+// skipsAnimation reports whether animated GIFs are left alone: on request,
+// or because they would become a WebP or AVIF still (see findEncoder).
+func skipsAnimation(opts Options) bool {
+	return opts.AnimatedGIF == AnimatedSkip || opts.OutputFormat != ""
+}
+
+// gifArgs wrap the gm arguments for a GIF: -coalesce turns every frame into
+// a full picture so that resizing treats them alike, and -deconstruct
+// stores only what changes between them again.  A GIF with a single frame
+// passes through both unchanged.
+func gifArgs(src string) (before, after []string) {
+	if !isGIF(src) {
+		return nil, nil
+	}
+	return []string{"-coalesce"}, []string{"-deconstruct"}
+}
+
+// isAnimatedGIF reports whether the GIF at path has more than one frame.
+// It walks the file's blocks without decoding any pixels.
+func isAnimatedGIF(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	// Header and logical screen descriptor, then the global colour table.
+	var head [13]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return false, err
+	}
+	if string(head[:3]) != "GIF" {
+		return false, fmt.Errorf("%s: not a GIF", path)
+	}
+	if err := skipColorTable(r, head[10]); err != nil {
+		return false, err
+	}
+
+	frames := 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		switch b {
+		case 0x21: // extension: label, then data sub-blocks
+			if _, err := r.ReadByte(); err != nil {
+				return false, err
+			}
+			if err := skipSubBlocks(r); err != nil {
+				return false, err
+			}
+		case 0x2c: // image descriptor
+			if frames++; frames > 1 {
+				return true, nil
+			}
+			var desc [9]byte
+			if _, err := io.ReadFull(r, desc[:]); err != nil {
+				return false, err
+			}
+			if err := skipColorTable(r, desc[8]); err != nil {
+				return false, err
+			}
+			if _, err := r.ReadByte(); err != nil { // LZW minimum code size
+				return false, err
+			}
+			if err := skipSubBlocks(r); err != nil {
+				return false, err
+			}
+		case 0x3b: // trailer
+			return false, nil
+		default:
+			return false, fmt.Errorf("%s: unexpected GIF block 0x%02x", path, b)
+		}
+	}
+}
+
+// skipColorTable skips the colour table that flags announces, if any.
+func skipColorTable(r *bufio.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+	_, err := r.Discard(3 << (flags&0x07 + 1))
+	return err
+}
+
+// skipSubBlocks skips a sequence of data sub-blocks up to its terminator.
+func skipSubBlocks(r *bufio.Reader) error {
+	for {
+		n, err := r.ReadByte()
+		if err != nil || n == 0 {
+			return err
+		}
+		if _, err := r.Discard(int(n)); err != nil {
+			return err
+		}
+	}
+}
@@ -1,19 +1,32 @@
 // Package gm wraps the GraphicsMagick CLI (the "gm" binary) to perform
 // batch image resize and compression on a directory tree.
 //
-// All commands are executed via "bash -lc" so that:
-//   - Shell constructs like pipes and while-read loops work correctly.
-//   - A login shell PATH is used, which typically includes /usr/local/bin or
-//     /opt/homebrew/bin where gm lives on macOS.
+// Matching files are found by walking the tree in Go and gm is invoked once
+// per file, which lets a run skip files that an earlier (possibly
+// interrupted) run already converted.  The gm binary is looked up on PATH
+// and, failing that, through a login shell ("bash -lc"), whose PATH typically
+// includes /usr/local/bin or /opt/homebrew/bin where gm lives on macOS.
 package gm
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
 )
 
+// DefaultPatterns are the file globs processed when none are configured.
+var DefaultPatterns = []string{"*.jpg", "*.jpeg", "*.png"}
+
 // Options holds all configuration needed for a GraphicsMagick batch run.
 type Options struct {
 	// Dir is the base directory that contains the images.
@@ -22,8 +35,8 @@ type Options struct {
 
 	// Patterns is a list of shell globs used to match image files,
 	// e.g. ["*.jpg", "*.jpeg", "*.png"].
-	// Each pattern is passed to find's -iname flag (case-insensitive);
-	// multiple patterns are combined with -o (OR).
+	// Each pattern is matched against file names case-insensitively, like
+	// find's -iname; a file matching any of the patterns is processed.
 	Patterns []string
 
 	// Resize is the geometry string passed to gm -resize, e.g. "1200x1200".
@@ -31,18 +44,370 @@ type Options struct {
 	// dimension would be exceeded.
 	Resize string
 
+	// ResizeMode decides what happens when the image and the Resize box
+	// have different aspect ratios:
+	//   ""   (ResizeFit)  → fit inside the box; one side may come out shorter
+	//   fill (ResizeFill) → cover the box and crop the overflow: exact size
+	//   pad  (ResizePad)  → fit inside the box and pad with Background: exact size
+	// Fill and pad need a box with both width and height.
+	ResizeMode string
+
+	// Gravity anchors the crop (fill) or the image on its canvas (pad), as
+	// a gm -gravity name such as "North" or "SouthEast".  Empty means
+	// DefaultGravity.
+	Gravity string
+
+	// Background is the colour pad fills the rest of the canvas with, as
+	// ParseBackground accepts it.  Empty means DefaultBackground.
+	// "transparent" only stays transparent in formats that have an alpha
+	// channel; JPEGs have none.
+	Background string
+
+	// Upscale lets images smaller than the Resize box be enlarged to it.
+	// By default they never are: fit and pad only shrink, fill crops a
+	// smaller image to the box without scaling it, and geometries that can
+	// only enlarge, like "150%" or "800x600<", are rejected.
+	Upscale bool
+
 	// Quality is the JPEG quality value (1–100) passed to gm -quality.
+	// With a TargetSize it is the quality tried first, and with
+	// SmartQuality the highest one tried.
 	Quality int
 
+	// SmartQuality picks each JPEG's quality from the image: the lowest
+	// from MinQuality (DefaultMinQuality when zero) up to Quality whose
+	// output keeps an SSIM of SmartSSIM against a lossless rendering, so
+	// that simple images get smaller files and detailed ones keep their
+	// quality (see encodeSmart).  Other formats are encoded at Quality.
+	SmartQuality bool
+
+	// TargetSize asks for JPEGs of at most this many bytes: each one is
+	// encoded again at a quality TargetQualityStep lower until it fits, down
+	// to MinQuality (DefaultMinQuality when zero).  A file that is still too
+	// large is kept at MinQuality and counted in Result.OverTarget.  Zero
+	// encodes every file once at Quality.
+	TargetSize int64
+	MinQuality int
+
+	// Interlace makes JPEGs progressive, so browsers can show a coarse
+	// preview while the rest downloads.  It is passed to gm -interlace:
+	//   ""    → baseline JPEGs (default)
+	//   Line  → progressive, interlaced by scanline (what the web expects)
+	//   Plane → progressive, interlaced by colour plane
+	// Other formats are left alone: an interlaced PNG is usually larger.
+	Interlace string
+
+	// Subsampling is the gm -sampling-factor of JPEG outputs: how much
+	// colour resolution they give up.  Subsampling444 keeps all of it,
+	// which keeps the edges of coloured text and lines in screenshots
+	// sharp at the cost of larger files; Subsampling420 halves it both
+	// ways, which photos rarely show.  Empty leaves it to gm.
+	Subsampling string
+
+	// AutoOrient applies gm -auto-orient before resizing: pixels are rotated
+	// according to the EXIF orientation tag and the tag is reset, so photos
+	// taken with a rotated phone stay upright in viewers that ignore EXIF.
+	AutoOrient bool
+
+	// Rotate turns every image clockwise by RotateRight, Rotate180 or
+	// RotateLeft after AutoOrient and before resizing, or with RotateAuto
+	// turns landscape images to portrait.  Flip then mirrors images top to
+	// bottom (gm -flip) and Flop left to right (gm -flop).  They are meant
+	// for scans fed in the wrong way round, which carry no EXIF
+	// orientation for AutoOrient to go by.
+	Rotate string
+	Flip   bool
+	Flop   bool
+
+	// Sharpen is an unsharp-mask geometry ("radiusxsigma+amount+threshold")
+	// passed to gm -unsharp after resizing, to restore the crispness that
+	// downscaling takes away.  Empty means no sharpening; DefaultSharpen is a
+	// mild setting suited to web-sized photos.
+	Sharpen string
+
+	// OutputFormat converts every file to OutputWebP or OutputAVIF in
+	// preserve mode, changing the extension of its output.  The files are
+	// encoded by cwebp or avifenc when installed, otherwise by gm itself
+	// (see findEncoder).  Empty keeps each file's own format.
+	OutputFormat string
+
+	// Effort trades encoding time for smaller WebP and AVIF files, from 1
+	// (fastest) to MaxEffort (smallest); it becomes cwebp's -m or avifenc's
+	// -s.  Zero uses the encoder's default.
+	Effort int
+
+	// Report is a file to which a ReportRow is written as each file
+	// finishes, or "" for none.  ReportFormat picks the format, one of the
+	// Report* formats; when it is empty the extension does: CSV for .csv,
+	// TSV for .tsv, Markdown for .md, HTML for .html, JUnit XML for .xml
+	// and JSON lines otherwise.  Runs append their rows to CSV, TSV,
+	// Markdown and JSON lines reports, and replace HTML and JUnit ones.
+	Report       string
+	ReportFormat string
+
+	// Baseline compares the run's savings and failure rate with those saved
+	// in the directory's BaselineName file (BaselineCheck), which the first
+	// successful run of enough files writes, or replaces that file with
+	// the run's (BaselineSave).  A run that strays by more than
+	// BaselineTolerance percentage points (DefaultBaselineTolerance when
+	// zero) fails with category FailBaseline, after all its files are done.
+	Baseline          string
+	BaselineTolerance int
+
+	// AnimatedGIF is AnimatedKeep to resize every frame of animated GIFs,
+	// or AnimatedSkip to leave them alone.  They are always left alone
+	// with an OutputFormat, whose encoders write still images.
+	AnimatedGIF string
+
+	// HEIC also processes HEIC and HEIF photos (HEICPatterns), in preserve
+	// mode only.  They are decoded with libheif's heif-convert when
+	// installed, otherwise by gm itself (see findDecoder), and written as
+	// JPEG unless OutputFormat asks for another format.
+	HEIC bool
+
+	// NameTemplate names the converted files in preserve mode, e.g.
+	// "{name}-{width}x{height}.{ext}" or "{name}_web.{ext}"; see NameVars.
+	// Empty keeps the original names.  The extension may differ from the
+	// original's, in which case gm converts to that format.
+	NameTemplate string
+
+	// OptimizePNG runs PNG optimisers over every PNG gm writes, which
+	// compresses far better than gm alone: PNGLossless recompresses with
+	// optipng (or zopflipng), PNGLossy first reduces the colours with
+	// pngquant.  Tools that are not installed are skipped and the run says
+	// so.  Empty leaves gm's PNGs as they are.
+	OptimizePNG string
+
+	// Lossless slims JPEGs and PNGs without touching their pixels: instead
+	// of gm, jpegtran strips the metadata of JPEGs and optimises their
+	// entropy coding, and optipng (or zopflipng) does the same for PNGs.
+	// Resize and Quality do not apply, and options that change pixels are
+	// refused by Validate.  A file that would not get smaller is kept as
+	// it is.
+	Lossless bool
+
+	// Exec is a shell command that converts each file instead of gm, e.g.
+	// "cwebp -q 80 {in} -o {out}", for converters the built-in backends do
+	// not cover.  {in} becomes the file and {out} where its output goes,
+	// both relative to Dir, the command's working directory; a
+	// NameTemplate gives the outputs another extension.  Scanning, resume,
+	// workers, reports and verification work as they do with gm, but
+	// Resize and Quality do not apply, and options only gm carries out
+	// are refused by Validate.  Empty converts with gm.
+	Exec string
+
+	// Steps converts each file in a pipeline of steps run one after the
+	// other, each its own command, instead of one gm invocation: e.g.
+	// auto_orient, resize, sharpen, watermark, strip and then optimize
+	// with jpegtran or an exec command (see ParseStep).  gm steps pass the
+	// image on losslessly, and Quality and the encoding options apply when
+	// it is encoded.  What a single gm invocation would do besides
+	// resizing and encoding must then be a step of its own, so Validate
+	// refuses the options that steps replace.  A step with a condition
+	// (Step.If) is left out for files it does not hold for.  Empty
+	// converts in one go.
+	Steps []Step
+
+	// MaxColors reduces every image to at most this many colours, from 2
+	// to MaxColorCount, with gm's -colors; 0 keeps them all.  Dither
+	// spreads the error of the reduction over neighbouring pixels, which
+	// hides the banding of smooth gradients at some cost in size.  A run
+	// warns before converting when a sample of the images is likely to
+	// band with these settings or a low Quality.
+	MaxColors int
+	Dither    bool
+
+	// SampleSize is how many files the features that look at a sample
+	// rather than every file pick: the banding check decodes at most this
+	// many (12 when 0) and Approve exports this many pairs when asked for
+	// none.  Seed shuffles the files each sample is picked from, so that
+	// another set is looked at while the same seed on the same tree always
+	// picks the same files; 0 picks the first files in scan order.
+	SampleSize int
+	Seed       int64
+
+	// ConvertToSRGB converts images with another colour profile, such as
+	// Adobe RGB or a CMYK press profile, to sRGB and strips the profile
+	// afterwards, so that they look the same in every browser.  Images
+	// without a profile are taken as sRGB already.
+	ConvertToSRGB bool
+
+	// Tone turns every image into a stylised variant after it has been
+	// resized: ToneGrayscale or ToneSepia.  Empty keeps the colours.
+	Tone string
+
+	// DropAlpha removes the alpha channel of PNGs and WebPs in which every
+	// pixel is opaque, which only takes up space (see AuditAlpha).  Files
+	// that use transparency keep it.
+	DropAlpha bool
+
+	// Watermark is stamped onto every image after it has been converted.
+	// The zero value adds no watermark.
+	Watermark Watermark
+
 	// Overwrite controls which gm subcommand is used:
 	//   true  → gm mogrify (modifies files in-place)
 	//   false → gm convert (writes to an "output/" mirror directory)
 	Overwrite bool
 
+	// OnConflict is what preserve mode does when an output would land on a
+	// file that is already there and is not the file's own output from an
+	// earlier run: "" (or ConflictOverwrite) replaces it, ConflictSkip
+	// leaves the source alone, and ConflictRename writes to the first free
+	// name with a numeric suffix.  Overwrite mode ignores it.
+	OnConflict string
+
+	// LinkSkipped puts the files preserve mode leaves alone because they
+	// are below the minimum size or dimensions, or are animated GIFs it
+	// skips, in output/ under their own name, so that output/ mirrors the
+	// whole directory: LinkHardlink and LinkReflink without using the
+	// space twice, LinkCopy as a copy.  Files already there are left
+	// alone.  Empty leaves them out.  Overwrite mode ignores it.
+	LinkSkipped string
+
+	// Workers is how many files are converted at the same time: 0 or 1
+	// converts one at a time, WorkersAuto adapts the number to the system
+	// load while the run goes on (see workerGate).  With more than one,
+	// the biggest files are started first (see largestFirst).
+	Workers int
+
+	// PerDirectory caps how many of those workers convert files from the
+	// same directory at once, so that parallel runs do not make a spinning
+	// disk seek back and forth between neighbouring files; the others
+	// work on other directories meanwhile.  Zero means no cap.
+	PerDirectory int
+
+	// PowerAware drops to one worker while a laptop runs on its battery or
+	// is hot, and goes back to Workers once it is plugged in and cool (see
+	// sysload.ReadPower).  It has no effect on runs with a single worker.
+	PowerAware bool
+
+	// MinFileSize, MinWidth and MinHeight leave small files alone, such as
+	// icons that are already optimised: only files of at least MinFileSize
+	// bytes whose image is at least MinWidth × MinHeight pixels are
+	// converted.  Zero means no minimum.
+	MinFileSize int64
+	MinWidth    int
+	MinHeight   int
+
+	// ModifiedAfter and ModifiedBefore limit a run to files whose
+	// modification time falls in [ModifiedAfter, ModifiedBefore), e.g. the
+	// photos added in the last month.  The zero time leaves that end of the
+	// range open.  Files outside the range are not matched at all (see
+	// Scan); see ParseDate for the dates users may type.
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+
 	// Recursive controls whether subdirectories are traversed.
 	//   true  → search the entire directory tree (default behaviour)
 	//   false → process only files directly inside Dir (-maxdepth 1)
 	Recursive bool
+
+	// FollowSymlinks is what Scan does with symbolic links: "" (or
+	// SymlinksSkip) leaves them alone, SymlinksFollow converts
+	// what they point to, and SymlinksOnce does too but converts each file
+	// only once however many links lead to it.  Links back into a
+	// directory being walked are never followed.
+	FollowSymlinks string
+
+	// Files limits the run to these paths, relative to Dir, among the ones
+	// Scan finds, e.g. those picked on the TUI's file list.  Nil processes
+	// every file Scan finds.
+	Files []string
+
+	// Listed takes Files as they are instead of walking Dir for them, so
+	// that retrying the files a run failed on does not rescan the whole
+	// tree.  They must still match the patterns and dates.
+	Listed bool
+
+	// Force reprocesses every matching file.  By default files recorded in
+	// the resume manifest (see ManifestName) whose source and output are
+	// unchanged since, and which were converted with the same settings, are
+	// skipped.
+	Force bool
+
+	// HashCache also records the SHA-256 of every source and output in the
+	// manifest, so that a file whose modification time changed but whose
+	// contents did not, as after a sync or a restore from backup, is still
+	// skipped.  Hashing reads each converted file once more.
+	HashCache bool
+
+	// Backup copies each original into a mirror under BackupDir before
+	// gm mogrify modifies it.  Only used in overwrite mode; see Restore.
+	Backup bool
+
+	// BackupDir is the backup mirror location.  Relative paths are relative
+	// to Dir; empty means DefaultBackupDir.  The directory is never scanned
+	// for images.
+	BackupDir string
+
+	// Trash copies each original into a directory of its own for the run
+	// under TrashDir before overwrite mode replaces it, so that Undo can
+	// put back what the run changed.  Unlike the backup mirror, every run
+	// keeps the originals it replaced.  Only used in overwrite mode.
+	Trash bool
+
+	// IgnoreDiskSpace starts a preserve-mode run even when the disk holding
+	// output/ seems too full for it, which Run otherwise refuses with
+	// category FailDiskSpace; the run then only warns.
+	IgnoreDiskSpace bool
+
+	// GMPath is the gm executable to run.  When empty the IMAGESLIM_GM_PATH
+	// environment variable is consulted, then PATH and the login-shell PATH
+	// (see Binary).
+	GMPath string
+
+	// GMVersion pins the GraphicsMagick version to run with, e.g. "1.3.42",
+	// or "1.3" for any 1.3.x release: Check and Run refuse any other gm
+	// with category FailGMVersion.  When empty the IMAGESLIM_GM_VERSION
+	// environment variable is consulted; VersionAny accepts every version.
+	GMVersion string
+
+	// Fallback is a second program for the files gm fails on:
+	// FallbackMagick retries each of them with ImageMagick, and only files
+	// both fail on fail the run.  Empty means none.  Lossless runs never
+	// use it.
+	Fallback string
+
+	// MinPSNR compares every output with what it would be if it were
+	// stored losslessly, a PNG gm converts the file to with the same
+	// options, and flags those whose PSNR, in decibels, is below it:
+	// each gets a warning, and Result.LowPSNR counts them.  The files
+	// still count as converted.  Around 40 dB the loss is hard to see,
+	// below 30 it usually shows.  Zero means no comparison; lossless runs
+	// and animated GIFs are never compared.
+	MinPSNR float64
+
+	// Verify reads every output back once it is written: VerifyHeader
+	// reads its header, VerifyFull decodes all of it.  An output that
+	// fails counts as a failed file with category FailCorruptOutput.
+	// Empty means no verification.
+	Verify string
+
+	// StopOnError stops the run at the first file that fails, rather than
+	// converting the remaining files: files already being converted
+	// finish, the rest are counted in Result.Untried, and Result.Err is the
+	// file's error.  Otherwise the files that failed are counted in
+	// Result.Failed and listed in Result.Errors, and Result.Err is left for
+	// failures that concern the whole run.
+	StopOnError bool
+
+	// Timeout bounds the run: once it has passed, the files being
+	// converted are stopped, their gm processes killed, and Run fails with
+	// category FailTimeout.  Files converted by then are kept and skipped
+	// by the next run.  0 means no limit.  See ParseTimeout.
+	Timeout time.Duration
+
+	// Progress, when set, is called each time a file has been dealt with,
+	// converted or not, with how many have been, how many the run
+	// considers and how fast the conversions go.  Calls never overlap.
+	Progress func(p Progress) `json:"-"`
+
+	// Output, when set, is called with every line gm and the helper
+	// programs print, as they print it, and with "converting FILE" as each
+	// file starts.  The lines of files converted at once may interleave;
+	// Result.Log still groups them by file.  Calls never overlap.
+	Output func(line string) `json:"-"`
 }
 
 // Result holds the outcome of a GraphicsMagick run.
@@ -50,118 +415,1171 @@ type Result struct {
 	// Command is a human-readable description of what was executed.
 	Command string
 
-	// Output is the combined stdout + stderr captured from the shell command.
-	Output string
+	// Log is what gm and the helper programs printed, a line per entry
+	// tagged with its stream, file and level, after ImageSlim's own notes
+	// and warnings.  Each file's lines are kept together.  Output returns
+	// it as text.
+	Log []LogEntry
 
-	// Err is non-nil when the command exited with a non-zero status or
-	// could not be started at all.
+	// Err is non-nil when the run as a whole failed, or with
+	// Options.StopOnError when a file did (files already being converted
+	// by other workers still finish).  Rerunning resumes after the files
+	// that already succeeded.
 	Err error
+
+	// Processed counts files converted by this run.
+	Processed int
+
+	// Skipped counts files left alone because the manifest shows they were
+	// already converted with the same settings.
+	Skipped int
+
+	// Small counts files left alone because they are below the minimum file
+	// size or dimensions.
+	Small int
+
+	// OverTarget counts JPEGs still larger than Options.TargetSize at the
+	// minimum quality.
+	OverTarget int
+
+	// LowPSNR counts the processed files whose PSNR was below
+	// Options.MinPSNR.
+	LowPSNR int
+
+	// Animated counts animated GIFs left alone (see Options.AnimatedGIF).
+	Animated int
+
+	// Existing counts files left alone because their output was already
+	// there, and Renamed and Replaced the processed files written under a
+	// new name or over a file that was there (see Options.OnConflict).
+	Existing int
+	Renamed  int
+	Replaced int
+
+	// CaseClashes counts files whose output would have been named like
+	// another file's of the run but for case, which on macOS and Windows
+	// is the same file: they were written under a new name, or left alone
+	// with ConflictSkip and counted in Existing too.
+	CaseClashes int
+
+	// Linked counts the Small and Animated files put in output/ (see
+	// Options.LinkSkipped).
+	Linked int
+
+	// Failed counts files gm or a helper could not convert, and Untried
+	// the matching files left alone because the run stopped at a failure.
+	// Errors says why each of the Failed files failed, in the order they
+	// did.
+	Failed  int
+	Untried int
+	Errors  []FileError
+
+	// FellBack counts the processed files Options.Fallback converted
+	// after gm failed on them.
+	FellBack int
+
+	// Excluded, Unsupported and OutOfRange count files in the scanned
+	// directories that were never considered: image files the patterns
+	// leave out, files of other kinds, and files modified outside the
+	// date range.  NotPicked counts matching files left off Options.Files.
+	Excluded    int
+	Unsupported int
+	OutOfRange  int
+	NotPicked   int
+
+	// Files has a row for every file the run looked at, in the order they
+	// finished, whether or not Options.Report is set (see ExportReport).
+	Files []ReportRow
+
+	// BytesIn and BytesOut total the sizes of the processed files before
+	// and after conversion.  Skipped files are not counted.
+	BytesIn  int64
+	BytesOut int64
+}
+
+// FileError is why one file could not be converted.
+type FileError struct {
+	Path string // relative to Options.Dir
+	Err  error
+}
+
+// maxListedErrors is how many failed files Breakdown names.
+const maxListedErrors = 10
+
+// Summary describes the outcome in one line, e.g.
+// "Processed 1,212 files, failed 3 (skipped 30 already processed) · 48.2 MB → 9.1 MB, saved 81%".
+func (r Result) Summary() string {
+	if r.Processed == 0 && r.Skipped == 0 && r.Small == 0 && r.Animated == 0 && r.Existing == 0 && r.Failed == 0 {
+		return "No matching files found."
+	}
+	s := fmt.Sprintf("Processed %s file(s)", humanize.Count(r.Processed))
+	if r.Failed > 0 {
+		s += ", failed " + humanize.Count(r.Failed)
+	}
+	var skipped []string
+	if r.Skipped > 0 {
+		skipped = append(skipped, humanize.Count(r.Skipped)+" already processed")
+	}
+	if r.Small > 0 {
+		skipped = append(skipped, humanize.Count(r.Small)+" below the minimum size")
+	}
+	if r.Animated > 0 {
+		skipped = append(skipped, humanize.Count(r.Animated)+" animated GIF(s)")
+	}
+	if r.Existing > 0 {
+		skipped = append(skipped, humanize.Count(r.Existing)+" whose output exists")
+	}
+	if len(skipped) > 0 {
+		s += " (skipped " + strings.Join(skipped, ", ") + ")"
+	}
+	if r.BytesIn > 0 {
+		s += fmt.Sprintf(" · %s → %s", humanize.Bytes(r.BytesIn), humanize.Bytes(r.BytesOut))
+		saved := 1 - float64(r.BytesOut)/float64(r.BytesIn)
+		if saved >= 0 {
+			s += ", saved " + humanize.Percent(saved)
+		} else {
+			s += ", grew " + humanize.Percent(-saved)
+		}
+	}
+	if r.OverTarget > 0 {
+		s += fmt.Sprintf(" · %s still above the target size", humanize.Count(r.OverTarget))
+	}
+	if r.LowPSNR > 0 {
+		s += fmt.Sprintf(" · %s below the minimum PSNR", humanize.Count(r.LowPSNR))
+	}
+	if r.FellBack > 0 {
+		s += fmt.Sprintf(" · %s converted by the fallback after gm failed", humanize.Count(r.FellBack))
+	}
+	if r.Renamed > 0 {
+		s += fmt.Sprintf(" · %s renamed since the output existed", humanize.Count(r.Renamed))
+	}
+	if r.Replaced > 0 {
+		s += fmt.Sprintf(" · %s existing output(s) replaced", humanize.Count(r.Replaced))
+	}
+	if r.CaseClashes > 0 {
+		s += fmt.Sprintf(" · %s output name(s) differed from another only in case", humanize.Count(r.CaseClashes))
+	}
+	if r.Linked > 0 {
+		s += fmt.Sprintf(" · %s skipped file(s) put in %s/ as they were", humanize.Count(r.Linked), OutputDir)
+	}
+	return s
+}
+
+// Reason is why some of the files a run came across were not converted.
+type Reason struct {
+	Count int
+	Text  string
+}
+
+// NotProcessed breaks down the files the run came across but did not
+// convert by reason, leaving out reasons without any.
+func (r Result) NotProcessed() []Reason {
+	var reasons []Reason
+	for _, c := range []Reason{
+		{r.Skipped, "already processed"},
+		{r.Small, "below the minimum size"},
+		{r.Animated, "animated GIF(s) left alone"},
+		{r.Existing, "output already exists"},
+		{r.OutOfRange, "modified outside the date range"},
+		{r.Excluded, "image(s) the file patterns leave out"},
+		{r.Unsupported, "not an image format ImageSlim converts"},
+		{r.NotPicked, "not picked from the file list"},
+		{r.Failed, "failed"},
+		{r.Untried, "not tried after the failure"},
+	} {
+		if c.Count > 0 {
+			reasons = append(reasons, c)
+		}
+	}
+	return reasons
 }
 
-// buildInameExpr converts a list of glob patterns into a find -iname expression.
+// Breakdown lists NotProcessed under a heading, one reason per line, and
+// names the files that failed under their count, e.g.
 //
-// Single pattern:  -iname "*.jpg"
-// Multiple:        \( -iname "*.jpg" -o -iname "*.jpeg" -o -iname "*.png" \)
+//	Not processed: 47 of 57 files
+//	  30  already processed
+//	  15  below the minimum size
+//	   2  failed
+//	        broken/empty.jpg
+//	        broken/truncated.jpg
 //
-// The parentheses group the OR conditions so they don't interfere with other
-// find predicates like -type f.
-func buildInameExpr(patterns []string) string {
-	if len(patterns) == 0 {
-		return `-iname "*.jpg"` // safe fallback
+// It returns "" when every file was converted.
+func (r Result) Breakdown() string {
+	reasons := r.NotProcessed()
+	if len(reasons) == 0 {
+		return ""
+	}
+	total, width := 0, 0
+	for _, c := range reasons {
+		total += c.Count
+		width = max(width, len(humanize.Count(c.Count)))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Not processed: %s of %s files", humanize.Count(total), humanize.Count(total+r.Processed))
+	for _, c := range reasons {
+		fmt.Fprintf(&b, "\n  %*s  %s", width, humanize.Count(c.Count), c.Text)
+		if c.Text != "failed" {
+			continue
+		}
+		for i, e := range r.Errors {
+			if i == maxListedErrors {
+				fmt.Fprintf(&b, "\n  %*s    and %s more", width, "", humanize.Count(len(r.Errors)-i))
+				break
+			}
+			fmt.Fprintf(&b, "\n  %*s    %s", width, "", e.Path)
+		}
+	}
+	return b.String()
+}
+
+// OutputDir is the directory, relative to Options.Dir, that preserve mode
+// mirrors the source tree into.
+const OutputDir = "output"
+
+// fileArgs returns the gm arguments that convert src into out.  In overwrite
+// mode src and out are the same file and "gm mogrify" is used.
+//
+// The ">" suffix on the geometry tells GraphicsMagick to only shrink images
+// that are larger than the target dimensions — smaller images are left
+// untouched.  This prevents upscaling unless opts.Upscale allows it; fill
+// mode is kept from it by convertFile (see noUpscale).  -auto-orient,
+// -rotate, -flip and -flop come first so that the target box applies to
+// the image as it is meant to be viewed, and -unsharp follows -resize so
+// it sharpens the downscaled pixels.  Conversion to sRGB and the tone come
+// before colours are reduced, so that the palette is picked from the
+// colours shown.  +matte drops the alpha channel of PNGs and WebPs with
+// opts.DropAlpha; convertFile clears that for files whose alpha channel is
+// in use.
+func fileArgs(opts Options, src, out string) []string {
+	coalesce, deconstruct := gifArgs(src)
+	args := coalesce
+	if opts.DropAlpha && (isPNG(src) || isWebP(src)) {
+		args = append(args, "+matte")
+	}
+	if opts.AutoOrient {
+		args = append(args, "-auto-orient")
+	}
+	args = append(args, rotateArgs(opts)...)
+	args = append(args, resizeArgs(opts)...)
+	if opts.Sharpen != "" {
+		args = append(args, "-unsharp", opts.Sharpen)
+	}
+	args = append(args, srgbArgs(opts)...)
+	args = append(args, toneArgs(opts)...)
+	args = append(args, colorArgs(opts)...)
+	args = append(args, deconstruct...)
+	args = append(args, "-quality", fmt.Sprint(opts.Quality))
+	if opts.Interlace != "" && (isJPEG(src) || isJPEG(out)) {
+		args = append(args, "-interlace", opts.Interlace)
+	}
+	if opts.Subsampling != "" && isJPEG(out) {
+		args = append(args, "-sampling-factor", opts.Subsampling)
+	}
+	if opts.Effort > 0 && strings.EqualFold(filepath.Ext(out), "."+OutputWebP) {
+		args = append(args, "-define", fmt.Sprintf("webp:method=%d", webpMethod(opts.Effort)))
+	}
+	if opts.Overwrite {
+		return append(append([]string{"mogrify"}, args...), src)
+	}
+	return append(append([]string{"convert", src}, args...), out)
+}
+
+// settingsFor returns the fingerprint recorded in the manifest for rel: its
+// gm arguments with the file paths blanked out, plus the name template, so
+// that changing any option that affects rel causes it to be reprocessed.
+func settingsFor(opts Options, rel string) string {
+	if opts.Exec != "" {
+		s := "exec=" + opts.Exec
+		if opts.NameTemplate != "" {
+			s += " name=" + opts.NameTemplate
+		}
+		return s
+	}
+	if len(opts.Steps) > 0 {
+		s := "steps=" + strings.Join(FormatSteps(opts.Steps), "; ")
+		s += " " + shellJoin(encodeArgs(opts, "", withFormat(opts, rel))[2:])
+		if opts.Resize != "" {
+			s += " resize=" + opts.Resize + "@" + opts.ResizeMode
+		}
+		if opts.NameTemplate != "" {
+			s += " name=" + opts.NameTemplate
+		}
+		return s
+	}
+	if opts.Lossless {
+		s := "lossless"
+		if opts.Interlace != "" {
+			s += " progressive"
+		}
+		if opts.NameTemplate != "" {
+			s += " name=" + opts.NameTemplate
+		}
+		return s
+	}
+	args := fileArgs(opts, rel, withFormat(opts, rel))
+	if opts.Overwrite {
+		args[len(args)-1] = ""
+	} else {
+		args[1], args[len(args)-1] = "", ""
+	}
+	if opts.Watermark.Enabled() {
+		wm := watermarkArgs(opts, rel)
+		args = append(args, wm[:len(wm)-2]...)
+	}
+	if opts.NameTemplate != "" {
+		args = append(args, "name="+opts.NameTemplate)
+	}
+	if opts.TargetSize > 0 {
+		args = append(args, fmt.Sprintf("target=%d@%d", opts.TargetSize, minQuality(opts)))
+	}
+	if opts.SmartQuality {
+		args = append(args, fmt.Sprintf("smart=%g@%d", SmartSSIM, minQuality(opts)))
+	}
+	if opts.OptimizePNG != "" {
+		args = append(args, "png="+opts.OptimizePNG)
+	}
+	if opts.OutputFormat != "" {
+		args = append(args, fmt.Sprintf("format=%s@%d", opts.OutputFormat, opts.Effort))
+	}
+	return strings.Join(args, " ")
+}
+
+// convertFile runs gm for one file — conversion, watermark and, for name
+// templates with dimensions, the final rename — writing gm's output to log,
+// then reads the output back when opts.Verify asks for it and compares it
+// with its reference for opts.MinPSNR.
+// With a target size JPEGs may be encoded several times (see
+// encodeToTarget); HEIC photos are decoded first when dec is set.
+// It returns where the output ended up, relative to opts.Dir.
+func convertFile(ctx context.Context, bin string, enc encoder, dec decoder, png pngTools, slim slimmer, opts Options, rel, src, out string, log io.Writer) (string, error) {
+	for _, p := range []string{rel, out} {
+		if strings.HasPrefix(p, "-") {
+			// gm, the helpers and exec commands get paths relative to
+			// opts.Dir, and would read this one as an option.
+			return out, fmt.Errorf("%s: a path starting with \"-\" would be read as an option; rename it", p)
+		}
 	}
-	parts := make([]string, len(patterns))
-	for i, p := range patterns {
-		parts[i] = fmt.Sprintf("-iname %q", p)
+	dst := filepath.Join(opts.Dir, out)
+	start := time.Now()
+	if !opts.Overwrite {
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return out, err
+		}
+		if err := unshare(src, dst); err != nil {
+			return out, err
+		}
+	} else {
+		if opts.Backup {
+			if err := backupFile(backupRoot(opts), rel, src); err != nil {
+				return out, fmt.Errorf("backup %s: %w", rel, err)
+			}
+		}
+		if dir := trashRun(ctx); dir != "" {
+			if err := trashFile(dir, rel, src); err != nil {
+				return out, fmt.Errorf("trash %s: %w", rel, err)
+			}
+		}
+	}
+	timeStage(ctx, stageWrite, start)
+
+	in := rel
+	start = time.Now()
+	if dec.path != "" && isHEIC(rel) {
+		var err error
+		if in, err = dec.decode(ctx, opts, rel, out, log); err != nil {
+			return out, err
+		}
+		defer os.Remove(filepath.Join(opts.Dir, in))
+	}
+
+	if opts.DropAlpha && !opaqueAlpha(bin, filepath.Join(opts.Dir, in)) {
+		opts.DropAlpha = false
+	}
+	if len(opts.Steps) == 0 {
+		// A pipeline's resize step looks at the image as the steps before
+		// it left it.
+		opts = noUpscale(bin, opts, filepath.Join(opts.Dir, in))
+	}
+	timeStage(ctx, stageDecode, start)
+
+	ref := ""
+	if checksPSNR(opts, rel) || smartQuality(opts, out) {
+		var err error
+		if ref, err = psnrReference(ctx, bin, opts, in, out); err != nil {
+			if ctx.Err() != nil {
+				return out, err
+			}
+			if checksPSNR(opts, rel) {
+				fmt.Fprintf(log, "note: %s: PSNR not measured: %v\n", rel, err)
+			}
+		} else {
+			defer os.Remove(filepath.Join(opts.Dir, ref))
+		}
+	}
+	ctx = withReference(ctx, ref)
+
+	encodeFile := encode
+	switch {
+	case opts.Exec != "":
+		encodeFile = execFile
+	case len(opts.Steps) > 0:
+		encodeFile = runSteps
+	case opts.Lossless:
+		encodeFile = slim.slim
+	case targetsSize(opts, out):
+		encodeFile = encodeToTarget
+	case smartQuality(opts, out):
+		encodeFile = encodeSmart
+	case enc.path != "":
+		encodeFile = enc.encode
+	}
+	if err := encodeFile(ctx, bin, opts, rel, in, out, log); err != nil {
+		return out, err
+	}
+	if opts.OptimizePNG != "" && isPNG(out) && !opts.Lossless {
+		start = time.Now()
+		optimizePNG(ctx, png, opts, rel, out, log)
+		timeStage(ctx, stageEncode, start)
+	}
+	if needsDimensions(opts.NameTemplate) {
+		defer timeStage(ctx, stageWrite, time.Now())
+		final, err := finishTemplate(ctx, bin, opts, rel, out)
+		if errors.Is(err, errOutputExists) {
+			return final, err
+		}
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", rel, err)
+		}
+		out = final
+	}
+	if err := verifyOutput(ctx, bin, opts, rel, out); err != nil {
+		return out, err
+	}
+	if ref != "" {
+		checkPSNR(ctx, bin, opts, rel, ref, out, log)
+	}
+	return out, nil
+}
+
+// encode runs the gm conversion of in into out and stamps the watermark,
+// if any.  in is rel itself, or the PNG a HEIC photo was decoded to.  When
+// gm fails and the run has a fallback, the fallback converts the file and
+// stamps the watermark instead.
+func encode(ctx context.Context, bin string, opts Options, rel, in, out string, log io.Writer) error {
+	defer timeStage(ctx, stageResize, time.Now())
+	args := fileArgs(opts, in, out)
+	cmd := command(ctx, bin, args...)
+	cmd.Dir = opts.Dir
+	attach(cmd, log)
+	prog := bin
+	if err := cmd.Run(); err != nil {
+		if prog, err = failOver(ctx, opts, rel, args, err, log); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	if opts.Watermark.Enabled() {
+		args := watermarkArgs(opts, out)
+		if prog != bin {
+			args = magickArgs(args)
+		}
+		cmd := command(ctx, prog, args...)
+		cmd.Dir = opts.Dir
+		attach(cmd, log)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: watermark: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// claimOutput records that rel is written to out, failing when another file
+// already was: a name template that does not tell them apart.
+func claimOutput(written claims, rel, out string) error {
+	if other := written.owner(out); other != "" && other != rel {
+		return WithCategory(FailOptions, fmt.Errorf("%s and %s are both written to %s: add variables to the name template", other, rel, out))
+	}
+	written.set(out, rel)
+	return nil
+}
+
+// clashNote is the warning for rel, whose output would have been named out
+// like other's but for case, and was renamed to name or, for
+// conflictSkipped, left alone.
+func clashNote(rel, other, out, name string, outcome conflict) string {
+	what := "writing " + name
+	if outcome == conflictSkipped {
+		what = "skipped"
+	}
+	return fmt.Sprintf("warning: %s: output %s differs from %s's only in case; %s", rel, out, other, what)
+}
+
+// isJPEG reports whether name has a JPEG file extension.
+func isJPEG(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return true
 	}
-	if len(parts) == 1 {
-		return parts[0]
+	return false
+}
+
+// outputPath returns where the converted version of rel is written, relative
+// to opts.Dir, unless a name template renames it (see templateOutput).
+func outputPath(opts Options, rel string) string {
+	if opts.Overwrite {
+		return rel
 	}
-	// Wrap in escaped parentheses so find treats the group as a unit.
-	return `\( ` + strings.Join(parts, " -o ") + ` \)`
+	return filepath.Join(OutputDir, withFormat(opts, rel))
 }
 
-// Run executes the appropriate GraphicsMagick command for the given Options
-// and returns a Result with the command details and any output or error.
+// displayOutput is the output path shown in Result.Command.
+func displayOutput(opts Options) string {
+	if opts.NameTemplate != "" && !opts.Overwrite {
+		return filepath.Join(OutputDir, "{dir}", withFormat(opts, opts.NameTemplate))
+	}
+	if opts.OutputFormat != "" && !opts.Overwrite {
+		return filepath.Join(OutputDir, "{dir}", "{name}."+opts.OutputFormat)
+	}
+	return outputPath(opts, "{file}")
+}
+
+// manifestPath returns the location of the resume manifest: inside output/
+// in preserve mode (so deleting output/ resets it), in Dir otherwise.
+func manifestPath(opts Options) string {
+	if opts.Overwrite {
+		return filepath.Join(opts.Dir, ManifestName)
+	}
+	return filepath.Join(opts.Dir, OutputDir, ManifestName)
+}
+
+// PathEnv is the environment variable that overrides gm binary discovery
+// when Options.GMPath is empty.
+const PathEnv = "IMAGESLIM_GM_PATH"
+
+// VersionEnv is the environment variable that pins the gm version when
+// Options.GMVersion is empty.
+const VersionEnv = "IMAGESLIM_GM_VERSION"
+
+// VersionAny as Options.GMVersion runs any gm version, even one VersionEnv
+// rules out.
+const VersionAny = "any"
+
+// pinnedVersion returns the gm version opts requires, or "" for any.
+func pinnedVersion(opts Options) string {
+	v := strings.TrimSpace(opts.GMVersion)
+	if v == "" {
+		v = strings.TrimSpace(os.Getenv(VersionEnv))
+	}
+	if strings.EqualFold(v, VersionAny) {
+		return ""
+	}
+	return v
+}
+
+// checkVersion verifies that version, the first line of "gm version" for
+// the gm at bin, is the one opts pins.  A pin also matches the releases it
+// is a prefix of: "1.3" matches 1.3.42 but not 1.30.
+func checkVersion(opts Options, bin, version string) error {
+	want := pinnedVersion(opts)
+	if want == "" {
+		return nil
+	}
+	have := version
+	if f := strings.Fields(version); len(f) >= 2 && f[0] == "GraphicsMagick" {
+		have = f[1]
+	}
+	if have == want || strings.HasPrefix(have, want+".") {
+		return nil
+	}
+	return WithCategory(FailGMVersion, fmt.Errorf("%s is GraphicsMagick %s, but version %s is pinned", bin, have, want))
+}
+
+// Binary locates the gm executable for opts.  An explicit path, from
+// opts.GMPath or the IMAGESLIM_GM_PATH environment variable, is used as-is
+// after checking that it is an executable file.  Otherwise gm is looked up on
+// PATH and then through a login shell, so that Homebrew and /usr/local
+// installs are found even when the program was started from a minimal
+// environment.
+func Binary(opts Options) (string, error) {
+	explicit := opts.GMPath
+	if explicit == "" {
+		explicit = os.Getenv(PathEnv)
+	}
+	if explicit != "" {
+		fi, err := os.Stat(explicit)
+		switch {
+		case err != nil:
+			return "", WithCategory(FailNoGM, fmt.Errorf("gm path %s: %w", explicit, err))
+		case fi.IsDir() || fi.Mode().Perm()&0o111 == 0:
+			return "", WithCategory(FailNoGM, fmt.Errorf("gm path %s is not an executable file", explicit))
+		}
+		return explicit, nil
+	}
+
+	if p, err := exec.LookPath("gm"); err == nil {
+		return p, nil
+	}
+	out, err := exec.Command("bash", "-lc", "command -v gm").Output()
+	if p := strings.TrimSpace(string(out)); err == nil && p != "" {
+		return p, nil
+	}
+	return "", WithCategory(FailNoGM, fmt.Errorf("gm not found in PATH — install GraphicsMagick first"))
+}
+
+// Check resolves the gm binary for opts and verifies that it runs, and that
+// it is the version opts pins, returning its path and the first line of "gm
+// version" (e.g. "GraphicsMagick 1.3.42 2023-09-23 Q16
+// http://www.GraphicsMagick.org/").  It is meant to be called once at startup
+// so a misconfigured path is reported before any work starts.
+func Check(opts Options) (path, version string, err error) {
+	path, err = Binary(opts)
+	if err != nil {
+		return "", "", err
+	}
+	version, err = gmVersion(path)
+	if err != nil {
+		return path, "", WithCategory(FailNoGM, err)
+	}
+	if !strings.Contains(version, "GraphicsMagick") {
+		return path, version, WithCategory(FailNoGM, fmt.Errorf("%s does not look like GraphicsMagick: %q", path, version))
+	}
+	return path, version, checkVersion(opts, path, version)
+}
+
+// gmVersion returns the first line of "gm version" for the gm at bin.
+func gmVersion(bin string) (string, error) {
+	out, err := exec.Command(bin, "version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s version: %w", bin, err)
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return version, nil
+}
+
+// shellJoin renders args as a copy-pasteable shell command line.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n\"'\\$`!*?[]()<>|&;#~") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Run executes GraphicsMagick on every file matched by opts and returns a
+// Result with the command details and any output or error.
 //
 // Overwrite mode (opts.Overwrite == true):
 //
-//	Uses "find … -exec gm mogrify …" to resize and recompress every matching
-//	file in-place, recursively under opts.Dir.
+//	Runs "gm mogrify" on each matching file to resize and recompress it
+//	in-place, first copying the original to the backup mirror when
+//	opts.Backup is set, and to the run's directory in TrashDir when
+//	opts.Trash is.
 //
 // Preserve mode (opts.Overwrite == false):
 //
-//	Creates an "output/" subdirectory inside opts.Dir and mirrors the full
-//	folder structure there, writing each converted file with "gm convert".
-//	Original files are never modified.
-func Run(opts Options) Result {
-	// depth restricts find to the top-level directory only when not recursive.
-	// Placed right after the path so it applies before any other predicates.
-	depth := ""
-	if !opts.Recursive {
-		depth = "-maxdepth 1 "
+//	Mirrors the folder structure into an "output/" subdirectory of opts.Dir,
+//	writing each converted file with "gm convert".  Original files are never
+//	modified.
+//
+// Each successful conversion is recorded in a manifest so that running the
+// same job again only processes new, changed or previously failed files,
+// unless opts.Force is set.
+//
+// Once ctx is done, or opts.Timeout has passed, no more files are started
+// and the processes of those being converted are killed; Run then fails
+// with category FailCancelled or FailTimeout.
+func Run(ctx context.Context, opts Options) Result {
+	res := Result{
+		Command: fmt.Sprintf("(in %s)\ngm %s", opts.Dir,
+			shellJoin(fileArgs(opts, "{file}", displayOutput(opts)))),
+	}
+	if opts.Interlace != "" {
+		res.Command += "\n(JPEG files also get -interlace " + opts.Interlace + ")"
+	}
+	if opts.Subsampling != "" {
+		res.Command += "\n(JPEG outputs also get -sampling-factor " + opts.Subsampling + ")"
+	}
+	if opts.DropAlpha {
+		res.Command += "\n(PNG and WebP files with a fully opaque alpha channel also get +matte)"
+	}
+	if opts.Watermark.Enabled() {
+		res.Command += "\ngm " + shellJoin(watermarkArgs(opts, displayOutput(opts)))
+	}
+	if opts.Lossless {
+		res.Command = losslessCommand(opts)
+	}
+	if opts.Exec != "" {
+		res.Command = execCommand(opts)
+	}
+	if len(opts.Steps) > 0 {
+		res.Command = stepsCommand(opts)
 	}
 
-	iname := buildInameExpr(opts.Patterns)
+	if err := opts.Validate(); err != nil {
+		res.Err = WithCategory(FailOptions, err)
+		return res
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
 
-	// The ">" suffix tells GraphicsMagick to only shrink images that are
-	// larger than the target dimensions — smaller images are left untouched.
-	// This prevents upscaling. The resize value is always quoted in the shell
-	// command so the ">" is not misinterpreted as a shell redirection.
-	resize := strings.TrimSuffix(opts.Resize, ">") + ">"
+	bin, err := Binary(opts)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if pinnedVersion(opts) != "" {
+		// Checked again here: gm may have been upgraded since startup.
+		version, err := gmVersion(bin)
+		if err != nil {
+			res.Err = WithCategory(FailNoGM, err)
+			return res
+		}
+		if err := checkVersion(opts, bin, version); err != nil {
+			res.Err = err
+			return res
+		}
+	}
 
-	var shellCmd string
+	if opts.ConvertToSRGB {
+		if err := writeSRGBProfile(); err != nil {
+			res.Err = fmt.Errorf("sRGB profile: %w", err)
+			return res
+		}
+	}
 
-	if opts.Overwrite {
-		// gm mogrify modifies every matching file in-place.
-		// find + -exec avoids shell glob-expansion limits and handles
-		// arbitrarily deep directory trees.
-		shellCmd = fmt.Sprintf(
-			`find . %s-type f %s -exec gm mogrify -resize "%s" -quality %d {} \;`,
-			depth,
-			iname,
-			resize,
-			opts.Quality,
-		)
-	} else {
-		// gm convert writes output to a mirrored "output/" directory.
-		//
-		// Breakdown of the shell pipeline:
-		//   mkdir -p output          – ensure the output root exists
-		//   find … | while IFS= read -r f
-		//                            – iterate over matching files safely
-		//                              (IFS= and -r preserve whitespace/backslashes)
-		//   out="output/${f#./}"     – strip leading "./" to build the mirrored path
-		//   mkdir -p "$(dirname …)"  – create any missing subdirectories
-		//   gm convert "$f" … "$out" – resize + compress into the output tree
-		shellCmd = fmt.Sprintf(
-			`mkdir -p output && find . %s-type f %s | while IFS= read -r f; do `+
-				`out="output/${f#./}"; `+
-				`mkdir -p "$(dirname "$out")"; `+
-				`gm convert "$f" -resize "%s" -quality %d "$out"; `+
-				`done`,
-			depth,
-			iname,
-			resize,
-			opts.Quality,
-		)
-	}
-
-	// Execute under bash -lc so pipelines and loops work, and so the login
-	// shell PATH (which typically includes the gm binary location) is active.
-	cmd := exec.Command("bash", "-lc", shellCmd)
-	cmd.Dir = opts.Dir
+	fallback, fallbackNote := findFallback(opts)
+	enc, encNote, err := findEncoder(opts)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if enc.path != "" {
+		res.Command += "\n" + shellJoin(append([]string{filepath.Base(enc.path)}, enc.args(opts, "{gm's PNG}", displayOutput(opts))...))
+	}
+
+	files, err := scan(opts, &res)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if opts.Files != nil {
+		picked := make(map[string]bool, len(opts.Files))
+		for _, rel := range opts.Files {
+			picked[filepath.Clean(rel)] = true
+		}
+		n := len(files)
+		files = slices.DeleteFunc(files, func(rel string) bool { return !picked[rel] })
+		res.NotPicked = n - len(files)
+	}
+	dec, decNote, err := findDecoder(opts, files)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	slim, err := findSlimmer(opts, files)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if parallel(opts) {
+		files = largestFirst(opts.Dir, files)
+		if opts.PerDirectory > 0 {
+			files = byDirectory(files)
+		}
+	}
+	dirs := newDirGate(opts.PerDirectory)
+
+	spaceWarning, err := checkSpace(opts, files)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	man, err := openManifest(manifestPath(opts), opts.HashCache)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer man.Close()
+
+	rep, err := openReport(opts)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer func() { rep.Close() }() // closed below unless the run ends early
+
+	// Notes and warnings about the run as a whole.  Each file's output is
+	// collected separately and appended to Result.Log once it is done, so
+	// parallel workers never interleave their lines.
+	runLog := newFileLog(nil, "")
 
-	// Capture both stdout and stderr into a single buffer so that all
-	// diagnostic messages from gm are available in Result.Output.
-	var buf bytes.Buffer
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
+	// An overlay kept next to the images must not be watermarked itself.
+	overlay := ""
+	if opts.Watermark.Enabled() {
+		overlay = filepath.Clean(opts.Watermark.path(opts.Dir))
+	}
+
+	png := findPNGTools(opts)
+	if note := png.missing(opts.OptimizePNG); note != "" {
+		fmt.Fprintf(runLog, "note: %s\n", note)
+	}
+	for _, note := range []string{encNote, decNote, fallbackNote} {
+		if note != "" {
+			fmt.Fprintf(runLog, "note: %s\n", note)
+		}
+	}
+	if spaceWarning != "" {
+		fmt.Fprintf(runLog, "warning: %s\n", spaceWarning)
+	}
+	res.Log = append(res.Log, runLog.take(false)...)
+
+	gate, stopAdapting := workerGate(opts)
+	defer stopAdapting()
+	live := newStream(opts.Output)
+
+	// Warned about before anything is converted, so that someone watching
+	// can stop the run and change the settings.
+	if found, err := checkBanding(opts, files); err == nil {
+		for _, w := range bandingWarnings(opts, found) {
+			fmt.Fprintf(runLog, "warning: %s\n", w)
+			live.line("warning: " + w)
+		}
+	}
+	res.Log = append(res.Log, runLog.take(false)...)
 
-	err := cmd.Run()
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex // guards res, written, failed and the manifest
 
-	return Result{
-		Command: fmt.Sprintf("(in %s)\n%s", opts.Dir, shellCmd),
-		Output:  buf.String(),
-		Err:     err,
+		// Outputs written so far, to catch name templates that map two
+		// files to the same name.
+		written = claims{}
+		failed  bool
+	)
+	fail := func(err error) {
+		if res.Err == nil {
+			res.Err = err
+		}
+		failed = true
+	}
+	considered := len(files)
+	started := time.Now()
+	run := started.Truncate(time.Second)
+	trash := "" // the run's directory in TrashDir
+	if opts.Overwrite && opts.Trash {
+		var err error
+		if trash, err = newTrashRun(opts, started); err != nil {
+			res.Err = err
+			return res
+		}
+		defer func() { // a run that replaced nothing leaves nothing to undo
+			os.Remove(trash)
+			os.Remove(filepath.Dir(trash))
+		}()
+	}
+	var pace Progress // Attempted, Bytes and Busy kept up to date by the workers
+	addRow := func(row ReportRow) error {
+		row.Run, row.Dir = run, opts.Dir
+		res.Files = append(res.Files, row)
+		if opts.Progress != nil {
+			pace.Done, pace.Total, pace.Elapsed = len(res.Files), considered, time.Since(started)
+			opts.Progress(pace)
+		}
+		return rep.add(row)
+	}
+	// failFile records that row's file failed with err, which stops the
+	// run with opts.StopOnError.
+	failFile := func(row ReportRow, err error) {
+		res.Failed++
+		res.Errors = append(res.Errors, FileError{Path: row.Path, Err: err})
+		row.Status, row.Error = ReportFailed, err.Error()
+		if rerr := addRow(row); rerr != nil {
+			fail(rerr)
+		}
+		if opts.StopOnError {
+			fail(err)
+		}
+	}
+
+	for _, rel := range files {
+		src := filepath.Join(opts.Dir, rel)
+		if src == overlay {
+			considered--
+			continue
+		}
+		settings := settingsFor(opts, rel)
+
+		mu.Lock()
+		if ctx.Err() != nil {
+			fail(stopped(ctx, opts))
+		}
+		if failed {
+			mu.Unlock()
+			break
+		}
+		out := outputPath(opts, rel)
+		if prev := man.output(rel); prev != "" {
+			out = prev
+		}
+		if !opts.Force {
+			done, err := man.done(rel, settings, src, filepath.Join(opts.Dir, out))
+			if err != nil {
+				failFile(ReportRow{Path: rel}, err)
+				mu.Unlock()
+				continue
+			}
+			if done {
+				written.set(out, rel)
+				res.Skipped++
+				if err := addRow(ReportRow{Path: rel, Status: ReportDone, Output: out}); err != nil {
+					fail(err)
+				}
+				mu.Unlock()
+				continue
+			}
+		}
+		if filtersBySize(opts) {
+			small, err := belowMinimum(bin, opts, src)
+			if err != nil {
+				failFile(ReportRow{Path: rel}, err)
+				mu.Unlock()
+				continue
+			}
+			if small {
+				row := ReportRow{Path: rel, Status: ReportSmall}
+				if linksSkipped(opts) {
+					if row.Output, err = linkSkipped(opts, rel, src); err != nil {
+						failFile(row, err)
+						mu.Unlock()
+						continue
+					}
+					res.Linked++
+				}
+				res.Small++
+				if err := addRow(row); err != nil {
+					fail(err)
+				}
+				mu.Unlock()
+				continue
+			}
+		}
+		if isGIF(rel) && skipsAnimation(opts) {
+			// A GIF that cannot be read is converted, which reports why.
+			if animated, err := isAnimatedGIF(src); err == nil && animated {
+				row := ReportRow{Path: rel, Status: ReportAnimated}
+				if linksSkipped(opts) {
+					if row.Output, err = linkSkipped(opts, rel, src); err != nil {
+						failFile(row, err)
+						mu.Unlock()
+						continue
+					}
+					res.Linked++
+				}
+				res.Animated++
+				if err := addRow(row); err != nil {
+					fail(err)
+				}
+				mu.Unlock()
+				continue
+			}
+		}
+		out = outputPath(opts, rel)
+		if opts.NameTemplate != "" {
+			var err error
+			if out, err = templateOutput(bin, opts, rel); err != nil {
+				failFile(ReportRow{Path: rel}, err)
+				mu.Unlock()
+				continue
+			}
+		}
+		outcome, clash, note := noConflict, "", ""
+		if !needsDimensions(opts.NameTemplate) {
+			name := out
+			if name, outcome, clash = resolveOutput(opts, man, written, rel, out); clash != "" {
+				note = clashNote(rel, clash, out, name, outcome)
+			}
+			out = name
+			if outcome == conflictSkipped {
+				res.Existing++
+				row := ReportRow{Path: rel, Status: ReportExists, Output: out}
+				if clash != "" {
+					res.CaseClashes++
+					flog := newFileLog(live, rel)
+					fmt.Fprintln(flog, note)
+					lines := flog.take(false)
+					res.Log = append(res.Log, lines...)
+					row.Messages = texts(lines)
+				}
+				if err := addRow(row); err != nil {
+					fail(err)
+				}
+				mu.Unlock()
+				continue
+			}
+		}
+		if !opts.Overwrite {
+			// photo.jpg and photo.png would both become photo.webp,
+			// photo.heic and photo.jpg both photo.jpg, and a renamed
+			// photo.jpg photo-1.jpg.
+			if err := claimOutput(written, rel, out); err != nil {
+				failFile(ReportRow{Path: rel}, err)
+				mu.Unlock()
+				continue
+			}
+		}
+		mu.Unlock()
+
+		// The directory slot comes first: a worker slot taken while
+		// waiting for it would sit idle.
+		dirs.acquire(rel)
+		gate.acquire()
+		wg.Add(1)
+		go func(rel, src, out, settings string, outcome conflict, clash, note string) {
+			defer wg.Done()
+			defer dirs.release(rel)
+			defer gate.release()
+
+			var clock stageClock
+			var psnr psnrMeasure
+			var smart smartChoice
+			backend := failover{path: fallback}
+			log := live.file(rel)
+			if note != "" {
+				fmt.Fprintln(log, note)
+			}
+			t0 := time.Now()
+			width, height := headerSize(src) // before overwrite mode replaces it
+			before, err := stamp(src)
+			if err == nil {
+				fctx := withSmart(withPSNR(withFailover(withClock(ctx, &clock), &backend), &psnr), &smart)
+				if trash != "" {
+					fctx = withTrash(fctx, trash)
+				}
+				fctx = withResolver(fctx, func(out string) (string, conflict) {
+					mu.Lock()
+					defer mu.Unlock()
+					var name string
+					if name, outcome, clash = resolveOutput(opts, man, written, rel, out); clash != "" {
+						fmt.Fprintln(log, clashNote(rel, clash, out, name, outcome))
+					}
+					if outcome == conflictRenamed {
+						written.set(name, rel) // before another worker picks the same suffix
+					}
+					return name, outcome
+				})
+				out, err = convertFile(fctx, bin, enc, dec, png, slim, opts, rel, src, out, log)
+			}
+			var sums fileSums
+			if err == nil {
+				sums, err = man.sums(src, filepath.Join(opts.Dir, out))
+			}
+			lines := log.take(err != nil)
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.Log = append(res.Log, lines...)
+			if err != nil && ctx.Err() != nil {
+				// Killed, not failed: the file counts as untried.
+				fail(stopped(ctx, opts))
+				return
+			}
+			pace.Attempted++
+			pace.Bytes += before.Size
+			pace.Busy += time.Since(t0)
+			if errors.Is(err, errOutputExists) {
+				res.Existing++
+				if clash != "" {
+					res.CaseClashes++
+				}
+				row := ReportRow{Path: rel, Status: ReportExists, Output: out, BytesIn: before.Size, WidthIn: width, HeightIn: height, Messages: texts(lines)}
+				clock.fill(&row)
+				if err := addRow(row); err != nil {
+					fail(err)
+				}
+				return
+			}
+			if err == nil && needsDimensions(opts.NameTemplate) {
+				err = claimOutput(written, rel, out)
+			}
+			if err != nil {
+				row := ReportRow{Path: rel, BytesIn: before.Size, WidthIn: width, HeightIn: height, Messages: texts(lines)}
+				clock.fill(&row)
+				failFile(row, err)
+				return
+			}
+			res.Processed++
+			res.BytesIn += before.Size
+			dst := filepath.Join(opts.Dir, out)
+			row := ReportRow{Path: rel, Status: ReportConverted, Output: out, BytesIn: before.Size, WidthIn: width, HeightIn: height, Messages: texts(lines)}
+			clock.fill(&row)
+			switch {
+			case clash != "":
+				res.CaseClashes++
+			case outcome == conflictRenamed:
+				res.Renamed++
+			case outcome == conflictReplaced:
+				res.Replaced++
+			}
+			switch {
+			case backend.used:
+				res.FellBack++
+				row.Backend = opts.Fallback
+			case opts.Exec != "":
+				row.Backend = BackendExec
+			case !opts.Lossless:
+				row.Backend = BackendGM
+			}
+			row.Quality = smart.quality
+			if psnr.measured {
+				row.PSNR = psnr.db
+				if psnr.db < opts.MinPSNR {
+					res.LowPSNR++
+				}
+			}
+			row.WidthOut, row.HeightOut = headerSize(dst)
+			if after, err := stamp(dst); err == nil {
+				res.BytesOut += after.Size
+				row.BytesOut = after.Size
+				if targetsSize(opts, out) && after.Size > opts.TargetSize {
+					res.OverTarget++
+				}
+			}
+			if err := addRow(row); err != nil {
+				fail(err)
+			}
+
+			recorded := ""
+			if opts.NameTemplate != "" || outcome == conflictRenamed {
+				recorded = out
+			}
+			if err := man.record(rel, recorded, settings, src, dst, sums); err != nil {
+				fail(err)
+			}
+		}(rel, src, out, settings, outcome, clash, note)
+	}
+	wg.Wait()
+	if err := rep.Close(); err != nil && res.Err == nil {
+		res.Err = err
+	}
+	rep = nil
+	if failed {
+		res.Untried = considered - res.Processed - res.Skipped - res.Small - res.Animated - res.Existing - res.Failed
+	}
+
+	if ctx.Err() != nil {
+		// A run cut short says nothing about the usual savings.
+		return res
+	}
+	if err := checkBaseline(bin, opts, res, runLog); err != nil && res.Err == nil {
+		res.Err = err
 	}
+	res.Log = append(res.Log, runLog.take(false)...)
+	return res
 }
@@ -0,0 +1,79 @@
+package gm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// HEIC and HEIF input
+// ---------------------------------------------------------------------------
+
+// HEICPatterns are the file globs Options.HEIC adds to the scan.
+var HEICPatterns = []string{"*.heic", "*.heif"}
+
+// heifDecoders are the libheif programs that turn a HEIC photo into a PNG,
+// newest name first: libheif 1.17 renamed heif-convert to heif-dec.
+var heifDecoders = []string{"heif-dec", "heif-convert"}
+
+// heifInstall tells the user how to get a HEIC decoder.
+const heifInstall = "brew install libheif / apt install libheif-examples"
+
+// isHEIC reports whether name has a HEIC or HEIF file extension.
+func isHEIC(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".heic", ".heif":
+		return true
+	}
+	return false
+}
+
+// decoder is the program that reads HEIC photos for gm.  The zero value
+// means gm reads them itself.
+type decoder struct {
+	path string
+}
+
+// findDecoder picks how the HEIC photos among files are read: with
+// heif-dec or heif-convert when installed, otherwise by gm if this build
+// can read HEIC.  note tells the user about the fallback.  Without any HEIC
+// photos nothing is looked up.
+func findDecoder(opts Options, files []string) (dec decoder, note string, err error) {
+	if !slices.ContainsFunc(files, isHEIC) {
+		return decoder{}, "", nil
+	}
+	for _, name := range heifDecoders {
+		if p, err := exec.LookPath(name); err == nil {
+			return decoder{path: p}, "", nil
+		}
+	}
+	formats, err := ListFormats(opts)
+	if err != nil {
+		return decoder{}, "", err
+	}
+	if slices.ContainsFunc(formats, func(f Format) bool { return f.Name == "HEIC" && f.Read }) {
+		return decoder{}, fmt.Sprintf("heif-convert is not installed; gm reads the HEIC photos (%s)", heifInstall), nil
+	}
+	return decoder{}, "", WithCategory(FailOptions, fmt.Errorf("HEIC photos need heif-convert (%s) or a GraphicsMagick built with HEIF support", heifInstall))
+}
+
+// decode converts the HEIC photo rel into a PNG next to out, relative to
+// opts.Dir, and returns the PNG's path for gm to read.  The caller removes
+// it.  libheif applies the photo's rotation itself.
+func (d decoder) decode(ctx context.Context, opts Options, rel, out string, log io.Writer) (string, error) {
+	png := filepath.Join(filepath.Dir(out), partialPrefix+filepath.Base(rel)+".png")
+	cmd := command(ctx, d.path, rel, png)
+	cmd.Dir = opts.Dir
+	attach(cmd, log)
+	if err := cmd.Run(); err != nil {
+		os.Remove(filepath.Join(opts.Dir, png))
+		return "", fmt.Errorf("%s: %s: %w", rel, filepath.Base(d.path), err)
+	}
+	return png, nil
+}
@@ -0,0 +1,36 @@
+package gm
+
+import (
+	"os/exec"
+	"slices"
+)
+
+// Helper is an optional program a run uses when it is installed.
+type Helper struct {
+	Name    string `json:"name"`
+	Use     string `json:"use"`     // what the program is used for
+	Install string `json:"install"` // how to get it
+	Found   bool   `json:"found"`
+}
+
+// Helpers looks up the optional programs: the WebP and AVIF encoders, a
+// HEIC decoder, the PNG optimisers, jpegtran for Orient and ImageMagick
+// for Options.Fallback.  Without them gm does their work where it can.
+func Helpers() []Helper {
+	found := func(names ...string) bool {
+		return slices.ContainsFunc(names, func(name string) bool {
+			_, err := exec.LookPath(name)
+			return err == nil
+		})
+	}
+	webp, avif := encoderTools[OutputWebP], encoderTools[OutputAVIF]
+	return []Helper{
+		{Name: webp.name, Use: "WebP output", Install: webp.install, Found: found(webp.name)},
+		{Name: avif.name, Use: "AVIF output", Install: avif.install, Found: found(avif.name)},
+		{Name: "heif-convert", Use: "iPhone (HEIC) photos", Install: heifInstall, Found: found(heifDecoders...)},
+		{Name: "pngquant", Use: "lossy PNG optimisation", Install: "brew install pngquant / apt install pngquant", Found: found("pngquant")},
+		{Name: "optipng", Use: "lossless PNG optimisation", Install: "brew install optipng / apt install optipng", Found: found("optipng", "zopflipng")},
+		{Name: "jpegtran", Use: "lossless rotation (orient)", Install: jpegtranInstall, Found: found("jpegtran")},
+		{Name: FallbackMagick, Use: "retrying files gm fails on (fallback: magick)", Install: magickInstall, Found: found(FallbackMagick)},
+	}
+}
@@ -0,0 +1,150 @@
+package gm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Inspect: what gm identify says about each image, before settings are
+// chosen
+// ---------------------------------------------------------------------------
+
+// ImageInfo is what Inspect found out about one image.
+type ImageInfo struct {
+	Path       string `json:"path"` // relative to Options.Dir
+	Size       int64  `json:"size"`
+	Format     string `json:"format,omitempty"` // as gm names it, e.g. JPEG or PNG
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Colorspace string `json:"colorspace,omitempty"` // e.g. RGB, CMYK or Gray
+	Alpha      bool   `json:"alpha,omitempty"`      // has an alpha channel
+
+	// From the EXIF data, when there is any.
+	Camera      string `json:"camera,omitempty"`      // make and model
+	Taken       string `json:"taken,omitempty"`       // as written, e.g. 2024:07:14 10:22:33
+	Orientation int    `json:"orientation,omitempty"` // 1 to 8; 0 when missing
+
+	Err string `json:"error,omitempty"` // why gm could not identify it
+}
+
+// identifyFormat asks gm identify for the fields of an ImageInfo, tab
+// separated.  %r is the storage class and colorspace run together, e.g.
+// DirectClassRGB, with Matte after an image with an alpha channel.
+const identifyFormat = "%m\t%w\t%h\t%r\t%[EXIF:Make]\t%[EXIF:Model]\t%[EXIF:DateTimeOriginal]\t%[EXIF:Orientation]\n"
+
+// Inspect asks gm identify about every file opts picks, as Scan does, in
+// order.  Files gm cannot identify are listed with Err set; the error
+// returned is for a scan or a gm that cannot be used, or ctx being done.
+func Inspect(ctx context.Context, opts Options) ([]ImageInfo, error) {
+	files, err := Scan(opts)
+	if err != nil {
+		return nil, err
+	}
+	bin := ""
+	if len(files) > 0 {
+		if bin, err = Binary(opts); err != nil {
+			return nil, err
+		}
+	}
+	infos := make([]ImageInfo, 0, len(files))
+	for _, rel := range files {
+		if err := ctx.Err(); err != nil {
+			return infos, err
+		}
+		infos = append(infos, identify(ctx, bin, opts.Dir, rel))
+	}
+	return infos, nil
+}
+
+// identify returns the ImageInfo of the file rel in dir.  Only the first
+// frame of an animation is described.
+func identify(ctx context.Context, bin, dir, rel string) ImageInfo {
+	info := ImageInfo{Path: rel}
+	path := filepath.Join(dir, rel)
+	if fi, err := os.Stat(path); err == nil {
+		info.Size = fi.Size()
+	}
+	out, err := exec.CommandContext(ctx, bin, "identify", "-ping", "-format", identifyFormat, path).Output()
+	if err != nil {
+		info.Err = exitMessage(err)
+		return info
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	f := strings.Split(line, "\t")
+	if len(f) < 8 {
+		info.Err = fmt.Sprintf("identify: unexpected output %q", strings.TrimSpace(string(out)))
+		return info
+	}
+	info.Format = f[0]
+	info.Width, _ = strconv.Atoi(f[1])
+	info.Height, _ = strconv.Atoi(f[2])
+	space := strings.TrimPrefix(strings.TrimPrefix(f[3], "DirectClass"), "PseudoClass")
+	info.Colorspace, info.Alpha = strings.CutSuffix(space, "Matte")
+	info.Camera = camera(strings.TrimSpace(f[4]), strings.TrimSpace(f[5]))
+	info.Taken = strings.TrimSpace(f[6])
+	info.Orientation, _ = strconv.Atoi(strings.TrimSpace(f[7]))
+	return info
+}
+
+// exitMessage returns what a failed gm identify printed on stderr, which
+// names the command itself, or else err.
+func exitMessage(err error) string {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		if msg := strings.TrimSpace(string(exit.Stderr)); msg != "" {
+			return msg
+		}
+	}
+	return "gm identify: " + err.Error()
+}
+
+// camera joins an EXIF make and model, leaving the make out when the model
+// already starts with it, as in "Canon" and "Canon EOS 5D".
+func camera(maker, model string) string {
+	switch {
+	case model == "":
+		return maker
+	case maker == "" || strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)):
+		return model
+	}
+	return maker + " " + model
+}
+
+// EXIF returns a short summary of the EXIF data i has, e.g. "Canon EOS 5D,
+// 2024-07-14 10:22, sideways", or "" when there is none.
+func (i ImageInfo) EXIF() string {
+	var parts []string
+	if i.Camera != "" {
+		parts = append(parts, i.Camera)
+	}
+	if i.Taken != "" {
+		// 2024:07:14 10:22:33 → 2024-07-14 10:22
+		taken := i.Taken
+		if len(taken) >= 16 && taken[4] == ':' && taken[7] == ':' {
+			taken = strings.Replace(taken[:16], ":", "-", 2)
+		}
+		parts = append(parts, taken)
+	}
+	if s := orientationNames[i.Orientation]; s != "" {
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// orientationNames describe the EXIF orientations other than upright.
+var orientationNames = map[int]string{
+	2: "mirrored",
+	3: "upside down",
+	4: "upside down, mirrored",
+	5: "sideways, mirrored",
+	6: "sideways",
+	7: "sideways, mirrored",
+	8: "sideways",
+}
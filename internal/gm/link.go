@@ -0,0 +1,79 @@
+package gm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Linking skipped files: a complete output/ mirror without a second copy
+// ---------------------------------------------------------------------------
+
+// Options.LinkSkipped values.
+const (
+	// LinkHardlink hardlinks the source into output/: both names share
+	// one file on disk.
+	LinkHardlink = "hardlink"
+	// LinkReflink clones the source on filesystems that share the blocks
+	// of a copy until either file changes (APFS, Btrfs, XFS).
+	LinkReflink = "reflink"
+	// LinkCopy copies the source.  Like every copy ImageSlim makes, the
+	// copy is a clone where the filesystem supports one, so it is the same
+	// as LinkReflink.
+	LinkCopy = "copy"
+)
+
+// ParseLinkSkipped converts a job file's link_skipped value to its
+// Options.LinkSkipped value: LinkHardlink, LinkReflink, LinkCopy, or ""
+// for "none".  Matching is case-insensitive.
+func ParseLinkSkipped(s string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(s)); v {
+	case "", "none":
+		return "", nil
+	case LinkHardlink, LinkReflink, LinkCopy:
+		return v, nil
+	}
+	return "", fmt.Errorf("link skipped must be %s, %s, %s or none, got %q", LinkHardlink, LinkReflink, LinkCopy, s)
+}
+
+// linksSkipped reports whether files left alone are put in output/.
+func linksSkipped(opts Options) bool {
+	return opts.LinkSkipped != "" && !opts.Overwrite
+}
+
+// linkSkipped puts src, the source of rel that the run left alone, at the
+// mirrored location of rel in output/ and returns that location relative
+// to opts.Dir.  A file already there is left alone, so that a later run
+// never replaces what an earlier one put there.  Where the filesystem
+// cannot hardlink, src is copied instead (see copyFile).
+func linkSkipped(opts Options, rel, src string) (string, error) {
+	out := filepath.Join(OutputDir, rel)
+	dst := filepath.Join(opts.Dir, out)
+	if _, err := os.Lstat(dst); err == nil {
+		return out, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", err
+	}
+	if opts.LinkSkipped == LinkHardlink && os.Link(src, dst) == nil {
+		return out, nil
+	}
+	return out, copyFile(src, dst)
+}
+
+// unshare removes dst when it is a hardlink of src, e.g. put there by an
+// earlier run with LinkHardlink, so that writing an output there cannot
+// change the source.
+func unshare(src, dst string) error {
+	si, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	di, err := os.Lstat(dst)
+	if err != nil || !os.SameFile(si, di) {
+		return nil
+	}
+	return os.Remove(dst)
+}
@@ -0,0 +1,176 @@
+package gm
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Run log: what gm, the helper programs and ImageSlim printed, line by line
+// ---------------------------------------------------------------------------
+
+// LogEntry.Stream values.
+const (
+	LogStdout = "stdout" // a program's standard output
+	LogStderr = "stderr" // a program's standard error
+	LogRun    = "run"    // ImageSlim's own notes and warnings
+)
+
+// LogEntry.Level values.
+const (
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// LogEntry is one line of a run's output.  Programs print warnings and
+// errors alike on standard error, so those lines are LevelError when the
+// file they were converting failed and LevelWarning when it did not.
+type LogEntry struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`         // LogStdout, LogStderr or LogRun
+	File   string    `json:"file,omitempty"` // the file being converted, relative to Options.Dir; empty for the run as a whole
+	Level  string    `json:"level"`          // LevelInfo, LevelWarning or LevelError
+	Text   string    `json:"text"`
+}
+
+// Output returns the run's log as text, a line per entry.
+func (r Result) Output() string {
+	var b strings.Builder
+	for _, e := range r.Log {
+		b.WriteString(e.Text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// TextLog splits text, e.g. what job hooks printed, into entries of stream
+// made at t.
+func TextLog(text, stream string, t time.Time) []LogEntry {
+	var entries []LogEntry
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if text == "" {
+			break
+		}
+		entries = append(entries, LogEntry{Time: t, Stream: stream, Level: lineLevel(line), Text: line})
+	}
+	return entries
+}
+
+// lineLevel is the level of a line before the fate of its file is known.
+func lineLevel(text string) string {
+	if strings.HasPrefix(text, "warning:") {
+		return LevelWarning
+	}
+	return LevelInfo
+}
+
+// fileLog collects the lines printed while one file is converted, or by the
+// run as a whole, and hands each on to a stream as it comes.  Writing to a
+// fileLog itself logs LogRun lines; writer returns the writers for a
+// program's output.
+type fileLog struct {
+	mu      sync.Mutex
+	s       *stream
+	rel     string
+	entries []LogEntry
+	partial map[string][]byte // by stream, the last line so far
+}
+
+// newFileLog returns the log of rel, or of the run for "", which hands its
+// lines on to s.
+func newFileLog(s *stream, rel string) *fileLog {
+	return &fileLog{s: s, rel: rel, partial: map[string][]byte{}}
+}
+
+func (l *fileLog) Write(p []byte) (int, error) {
+	l.add(LogRun, p)
+	return len(p), nil
+}
+
+// streamWriter writes to one stream of a fileLog.
+type streamWriter struct {
+	l      *fileLog
+	stream string
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	w.l.add(w.stream, p)
+	return len(p), nil
+}
+
+// writer returns the writer for stream, LogStdout or LogStderr.
+func (l *fileLog) writer(stream string) io.Writer {
+	return streamWriter{l: l, stream: stream}
+}
+
+// add logs the complete lines in p, after what is left of stream's last
+// line.  A program's standard output and error are written at once.
+func (l *fileLog) add(stream string, p []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	buf := append(l.partial[stream], p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.line(stream, string(bytes.TrimRight(buf[:i], "\r")))
+		buf = buf[i+1:]
+	}
+	l.partial[stream] = buf
+}
+
+// line logs one line and hands it on.  The caller holds l.mu.
+func (l *fileLog) line(stream, text string) {
+	l.entries = append(l.entries, LogEntry{Time: time.Now(), Stream: stream, File: l.rel, Level: lineLevel(text), Text: text})
+	l.s.line(text)
+}
+
+// take logs the lines that did not end in a newline and returns the
+// entries so far, which it forgets; failed says whether the file failed.
+func (l *fileLog) take(failed bool) []LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, stream := range []string{LogRun, LogStdout, LogStderr} {
+		if len(l.partial[stream]) > 0 {
+			l.line(stream, string(l.partial[stream]))
+			l.partial[stream] = nil
+		}
+	}
+	entries := l.entries
+	l.entries = nil
+	for i := range entries {
+		switch {
+		case entries[i].Stream != LogStderr:
+		case failed:
+			entries[i].Level = LevelError
+		default:
+			entries[i].Level = LevelWarning
+		}
+	}
+	return entries
+}
+
+// texts returns the text of each entry.
+func texts(entries []LogEntry) []string {
+	var lines []string
+	for _, e := range entries {
+		lines = append(lines, e.Text)
+	}
+	return lines
+}
+
+// attach sends cmd's standard output and error to log, each to its own
+// stream when log is a fileLog.
+func attach(cmd *exec.Cmd, log io.Writer) {
+	if l, ok := log.(*fileLog); ok {
+		cmd.Stdout, cmd.Stderr = l.writer(LogStdout), l.writer(LogStderr)
+		return
+	}
+	cmd.Stdout, cmd.Stderr = log, log
+}
@@ -0,0 +1,180 @@
+package gm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Lossless slimming: metadata and entropy coding only
+// ---------------------------------------------------------------------------
+
+// losslessExtensions are the file types Options.Lossless can slim.
+var losslessExtensions = []string{".jpg", ".jpeg", ".png"}
+
+// optipngInstall tells users how to get optipng, which lossless mode needs
+// for PNGs.
+const optipngInstall = "brew install optipng / apt install optipng"
+
+// slimmer holds the programs lossless mode runs: jpegtran for JPEGs and a
+// lossless PNG optimiser.
+type slimmer struct {
+	jpegtran string
+	png      pngTools
+}
+
+// validateLossless checks that nothing in o would change pixels, which
+// Options.Lossless promises not to do.
+func (o Options) validateLossless() error {
+	for _, p := range o.Patterns {
+		if !slices.Contains(losslessExtensions, strings.ToLower(filepath.Ext(p))) {
+			return fmt.Errorf("lossless mode only slims JPEG and PNG files, so pattern %q cannot be used with it", p)
+		}
+	}
+	var changes []string
+	if o.AutoOrient {
+		changes = append(changes, "auto-orient (imageslim orient turns JPEGs losslessly)")
+	}
+	if o.Rotate != "" {
+		changes = append(changes, "rotation")
+	}
+	if o.Flip || o.Flop {
+		changes = append(changes, "flipping")
+	}
+	if o.Subsampling != "" {
+		changes = append(changes, "chroma subsampling")
+	}
+	if o.Sharpen != "" {
+		changes = append(changes, "sharpening")
+	}
+	if o.TargetSize > 0 {
+		changes = append(changes, "a target size")
+	}
+	if o.SmartQuality {
+		changes = append(changes, "smart quality")
+	}
+	if o.MaxColors > 0 {
+		changes = append(changes, "a maximum colour count")
+	}
+	if o.ConvertToSRGB {
+		changes = append(changes, "sRGB conversion")
+	}
+	if o.Tone != "" {
+		changes = append(changes, "a "+o.Tone+" tone")
+	}
+	if o.OptimizePNG == PNGLossy {
+		changes = append(changes, "lossy PNG optimisation")
+	}
+	if o.OutputFormat != "" {
+		changes = append(changes, "a different output format")
+	}
+	if o.HEIC {
+		changes = append(changes, "HEIC conversion")
+	}
+	if o.Watermark.Enabled() {
+		changes = append(changes, "a watermark")
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("lossless mode keeps every pixel, so it cannot be combined with %s", strings.Join(changes, ", "))
+	}
+	return nil
+}
+
+// findSlimmer looks up the programs lossless mode needs for files: a run
+// with JPEGs fails without jpegtran, one with PNGs without optipng or
+// zopflipng.
+func findSlimmer(opts Options, files []string) (slimmer, error) {
+	if !opts.Lossless {
+		return slimmer{}, nil
+	}
+	var s slimmer
+	if slices.ContainsFunc(files, isJPEG) {
+		var err error
+		if s.jpegtran, err = exec.LookPath("jpegtran"); err != nil {
+			return s, WithCategory(FailOptions, fmt.Errorf("lossless mode needs jpegtran for JPEGs (%s)", jpegtranInstall))
+		}
+	}
+	if slices.ContainsFunc(files, isPNG) {
+		o := opts
+		o.OptimizePNG = PNGLossless
+		if s.png = findPNGTools(o); s.png.missing(PNGLossless) != "" {
+			return s, WithCategory(FailOptions, fmt.Errorf("lossless mode needs optipng or zopflipng for PNGs (%s)", optipngInstall))
+		}
+	}
+	return s, nil
+}
+
+// slim writes rel to out, both relative to opts.Dir, with its metadata
+// stripped and its entropy coding optimised but its pixels untouched:
+// jpegtran rewrites the Huffman tables of JPEGs (and makes them
+// progressive with opts.Interlace), optipng or zopflipng recompress PNGs.
+// When that does not make the file smaller, the original is kept as it
+// is.  It has the signature of encode, whose place it takes.
+func (s slimmer) slim(ctx context.Context, bin string, opts Options, rel, in, out string, log io.Writer) error {
+	abs := func(p string) string { return filepath.Join(opts.Dir, p) }
+	tmp := filepath.Join(filepath.Dir(out), partialPrefix+filepath.Base(out))
+	defer os.Remove(abs(tmp))
+
+	var cmd *exec.Cmd
+	if isJPEG(rel) {
+		args := []string{"-copy", "none", "-optimize"}
+		if opts.Interlace != "" {
+			args = append(args, "-progressive")
+		}
+		cmd = command(ctx, s.jpegtran, append(args, "-outfile", tmp, in)...)
+	} else {
+		if err := copyFile(abs(in), abs(tmp)); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		if s.png.optipng != "" {
+			cmd = command(ctx, s.png.optipng, "-quiet", "-o2", "-strip", "all", "--", tmp)
+		} else {
+			cmd = command(ctx, s.png.zopflipng, "-y", tmp, tmp)
+		}
+	}
+	cmd.Dir = opts.Dir
+	attach(cmd, log)
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %s: %w", rel, filepath.Base(cmd.Path), err)
+	}
+	timeStage(ctx, stageEncode, start)
+	defer timeStage(ctx, stageWrite, time.Now())
+
+	before, err := os.Stat(abs(in))
+	if err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	after, err := os.Stat(abs(tmp))
+	if err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	switch {
+	case after.Size() < before.Size():
+		err = os.Rename(abs(tmp), abs(out))
+	case out != in:
+		err = copyFile(abs(in), abs(out))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	return nil
+}
+
+// losslessCommand describes what lossless mode runs, for Result.Command.
+func losslessCommand(opts Options) string {
+	jpeg := "jpegtran -copy none -optimize"
+	if opts.Interlace != "" {
+		jpeg += " -progressive"
+	}
+	out := displayOutput(opts)
+	return fmt.Sprintf("(in %s)\n%s -outfile %s {file}\noptipng -o2 -strip all %s",
+		opts.Dir, jpeg, out, out)
+}
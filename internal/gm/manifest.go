@@ -0,0 +1,236 @@
+package gm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestName is the file, inside the output root, that records which
+// images have already been processed so interrupted runs can resume.
+const ManifestName = ".imageslim-manifest"
+
+// fileStamp identifies a particular version of a file on disk.
+type fileStamp struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime"` // UnixNano
+}
+
+// manifestEntry records one successfully processed file.  Src and Out are
+// stamped after gm wrote the output (in overwrite mode they are the same
+// file).  Settings is the gm argument template, so changing resize or
+// quality invalidates earlier entries.  Output is only recorded when a name
+// template or Options.OnConflict chose the output file, since it then
+// cannot be derived from Path.  The hashes are recorded with
+// Options.HashCache.
+type manifestEntry struct {
+	Path     string    `json:"path"`
+	Output   string    `json:"output,omitempty"`
+	Settings string    `json:"settings"`
+	Src      fileStamp `json:"src"`
+	Out      fileStamp `json:"out"`
+	SrcHash  string    `json:"src_sha256,omitempty"`
+	OutHash  string    `json:"out_sha256,omitempty"`
+}
+
+// fileSums are the SHA-256 hashes of a source and its output, or empty
+// when the manifest does not record them.
+type fileSums struct {
+	Src, Out string
+}
+
+// manifest is a JSON-lines log of processed files.  Appending one line per
+// file means an interrupted run still leaves a usable record; Close then
+// rewrites the log with only the latest line for each file.
+type manifest struct {
+	path    string
+	entries map[string]manifestEntry
+	lines   int // lines in the file, stale ones included
+	f       *os.File
+	hash    bool // record and compare hashes; see Options.HashCache
+}
+
+// openManifest loads the manifest at path (a missing file is not an error)
+// and opens it for appending.  With hash it keeps files' hashes too.
+func openManifest(path string, hash bool) (*manifest, error) {
+	m := &manifest{path: path, entries: map[string]manifestEntry{}, hash: hash}
+
+	if f, err := os.Open(path); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			m.lines++
+			var e manifestEntry
+			if json.Unmarshal(sc.Bytes(), &e) == nil {
+				m.entries[e.Path] = e // later lines win
+			}
+		}
+		f.Close()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	m.f = f
+	return m, nil
+}
+
+// done reports whether rel was processed with the same settings and neither
+// the source nor the output has changed since.  A file whose size is the
+// same but whose modification time is not counts as unchanged when the
+// manifest keeps hashes and its contents hash the same; the entry is then
+// recorded again with the new stamps, so the next run need not hash it.
+func (m *manifest) done(rel, settings, src, out string) (bool, error) {
+	e, ok := m.entries[rel]
+	if !ok || e.Settings != settings {
+		return false, nil
+	}
+	s, err1 := stamp(src)
+	o, err2 := stamp(out)
+	if err1 != nil || err2 != nil || s.Size != e.Src.Size || o.Size != e.Out.Size {
+		return false, nil
+	}
+	if s == e.Src && o == e.Out {
+		return true, nil
+	}
+	if !m.hash || e.SrcHash == "" || e.OutHash == "" {
+		return false, nil
+	}
+	sums, err := m.sums(src, out)
+	if err != nil {
+		return false, err
+	}
+	if sums.Src != e.SrcHash || sums.Out != e.OutHash {
+		return false, nil
+	}
+	return true, m.record(rel, e.Output, settings, src, out, sums)
+}
+
+// sums hashes src and its output out when the manifest keeps hashes.  In
+// overwrite mode they are the same file, read once.
+func (m *manifest) sums(src, out string) (fileSums, error) {
+	if !m.hash {
+		return fileSums{}, nil
+	}
+	s, err := sha256File(src)
+	if err != nil {
+		return fileSums{}, fmt.Errorf("hash: %w", err)
+	}
+	if out == src {
+		return fileSums{Src: s, Out: s}, nil
+	}
+	o, err := sha256File(out)
+	if err != nil {
+		return fileSums{}, fmt.Errorf("hash: %w", err)
+	}
+	return fileSums{Src: s, Out: o}, nil
+}
+
+// owns reports whether the file at path, out relative to the base
+// directory, is rel's output from an earlier run, untouched since.
+func (m *manifest) owns(rel, out, path string) bool {
+	e, ok := m.entries[rel]
+	if !ok || e.Output != "" && e.Output != out {
+		return false
+	}
+	o, err := stamp(path)
+	return err == nil && o == e.Out
+}
+
+// output returns the output file recorded for rel by a name template or a
+// rename, or "".
+func (m *manifest) output(rel string) string {
+	return m.entries[rel].Output
+}
+
+// record appends an entry for rel after a successful conversion.  output is
+// the templated or renamed output name relative to the base directory, or
+// "".  sums are the files' hashes, from sums.
+func (m *manifest) record(rel, output, settings, src, out string, sums fileSums) error {
+	s, err := stamp(src)
+	if err != nil {
+		return err
+	}
+	o, err := stamp(out)
+	if err != nil {
+		return err
+	}
+	e := manifestEntry{Path: rel, Output: output, Settings: settings, Src: s, Out: o, SrcHash: sums.Src, OutHash: sums.Out}
+	m.entries[rel] = e
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	m.lines++
+	_, err = m.f.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the underlying file, first compacting it when files were
+// recorded more than once.
+func (m *manifest) Close() error {
+	if err := m.f.Close(); err != nil {
+		return err
+	}
+	if m.lines == len(m.entries) {
+		return nil
+	}
+	return m.compact()
+}
+
+// compact rewrites the manifest with one line per file, sorted by path.
+// It writes a temporary file first so that a failure leaves the full log.
+func (m *manifest) compact() error {
+	paths := make([]string, 0, len(m.entries))
+	for p := range m.entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var buf bytes.Buffer
+	for _, p := range paths {
+		line, err := json.Marshal(m.entries[p])
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// stamp returns the size and modification time of path.
+func stamp(path string) (fileStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{Size: fi.Size(), ModTime: fi.ModTime().UnixNano()}, nil
+}
+
+// sha256File returns the SHA-256 of the file at path in hex.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,470 @@
+package gm
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/geometry"
+)
+
+// ---------------------------------------------------------------------------
+// Option parsing and validation
+// ---------------------------------------------------------------------------
+
+// Interlace schemes accepted by Options.Interlace.
+const (
+	InterlaceLine  = "Line"
+	InterlacePlane = "Plane"
+)
+
+// ParseInterlace maps a user-supplied scheme ("line", "Plane", "none", …) to
+// its Options.Interlace value.  Matching is case-insensitive; "" and "none"
+// mean baseline JPEGs.
+func ParseInterlace(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "none":
+		return "", nil
+	case "line":
+		return InterlaceLine, nil
+	case "plane":
+		return InterlacePlane, nil
+	}
+	return "", fmt.Errorf("interlace must be line, plane or none, got %q", s)
+}
+
+// Options.Subsampling values: gm -sampling-factor geometries for the
+// chroma subsampling of JPEGs.
+const (
+	Subsampling444 = "1x1" // colour at full resolution, for screenshots and text
+	Subsampling422 = "2x1" // colour at half the resolution across
+	Subsampling420 = "2x2" // colour at half the resolution both ways
+)
+
+// ParseSubsampling maps a user-supplied scheme ("4:4:4", "444", "4:2:0", …)
+// to its Options.Subsampling value; "" and "auto" leave it to gm.
+func ParseSubsampling(s string) (string, error) {
+	switch strings.ReplaceAll(strings.ToLower(strings.TrimSpace(s)), ":", "") {
+	case "", "auto":
+		return "", nil
+	case "444":
+		return Subsampling444, nil
+	case "422":
+		return Subsampling422, nil
+	case "420":
+		return Subsampling420, nil
+	}
+	return "", fmt.Errorf("subsampling must be 4:4:4, 4:2:2, 4:2:0 or auto, got %q", s)
+}
+
+// SubsamplingName returns the scheme ParseSubsampling maps to an
+// Options.Subsampling value, e.g. "4:4:4" for Subsampling444, or "" for "".
+func SubsamplingName(v string) string {
+	switch v {
+	case Subsampling444:
+		return "4:4:4"
+	case Subsampling422:
+		return "4:2:2"
+	case Subsampling420:
+		return "4:2:0"
+	}
+	return ""
+}
+
+// DefaultSharpen is the unsharp mask used when sharpening is switched on
+// without parameters: a small radius and moderate amount that counteracts
+// downscaling softness without visible halos.
+const DefaultSharpen = "0x0.75+0.75+0.008"
+
+// sharpenRE matches gm -unsharp geometries: radiusxsigma with optional
+// +amount and +threshold.
+var sharpenRE = regexp.MustCompile(`^\d+(\.\d+)?x\d+(\.\d+)?(\+\d+(\.\d+)?){0,2}$`)
+
+// versionRE matches the gm versions Options.GMVersion can pin: "1", "1.3",
+// "1.3.42" and so on.
+var versionRE = regexp.MustCompile(`^\d+(\.\d+)*$`)
+
+// ParseVersion checks a user-supplied gm version pin for
+// Options.GMVersion: a version number such as "1.3.42" or "1.3", "any", or
+// "" for none.
+func ParseVersion(s string) (string, error) {
+	v := strings.TrimSpace(s)
+	if v == "" || versionRE.MatchString(v) {
+		return v, nil
+	}
+	if strings.EqualFold(v, VersionAny) {
+		return VersionAny, nil
+	}
+	return "", fmt.Errorf("gm version must be a version number such as 1.3.42, or any, got %q", s)
+}
+
+// ParseSharpen maps a user-supplied sharpening setting to its
+// Options.Sharpen value.  "", "none", "off" and "false" disable sharpening;
+// "on", "true" and "default" select DefaultSharpen; anything else must be an
+// unsharp geometry such as "0x1+1+0.05".
+func ParseSharpen(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "", "none", "off", "false":
+		return "", nil
+	case "on", "true", "default":
+		return DefaultSharpen, nil
+	}
+	if !sharpenRE.MatchString(s) {
+		return "", fmt.Errorf("sharpen must be on, off or an unsharp geometry like %s, got %q", DefaultSharpen, s)
+	}
+	return s, nil
+}
+
+// Resize modes accepted by Options.ResizeMode.  Fit is the zero value.
+const (
+	ResizeFit  = ""
+	ResizeFill = "fill"
+	ResizePad  = "pad"
+)
+
+// ParseResizeMode maps a user-supplied resize mode to its Options.ResizeMode
+// value.  Matching is case-insensitive; "fill-crop", "crop" and "cover" are
+// accepted for fill.
+func ParseResizeMode(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "fit":
+		return ResizeFit, nil
+	case "fill", "fill-crop", "crop", "cover":
+		return ResizeFill, nil
+	case "pad":
+		return ResizePad, nil
+	}
+	return "", fmt.Errorf("resize mode must be fit, fill or pad, got %q", s)
+}
+
+// Gravities are the gm -gravity names accepted by Options.Gravity, in
+// compass order: row by row, starting at the top left.
+var Gravities = []string{
+	"NorthWest", "North", "NorthEast",
+	"West", "Center", "East",
+	"SouthWest", "South", "SouthEast",
+}
+
+// DefaultGravity keeps crops and padding centred.
+const DefaultGravity = "Center"
+
+// ParseGravity maps a user-supplied gravity ("north-east", "NE", "centre",
+// …) to its Options.Gravity value.  Empty and center both yield "".
+func ParseGravity(s string) (string, error) {
+	key := strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(s)))
+	switch key {
+	case "", "c", "center", "centre":
+		return "", nil
+	}
+	for _, g := range Gravities {
+		short := strings.Map(func(r rune) rune {
+			if r >= 'A' && r <= 'Z' {
+				return r + 'a' - 'A'
+			}
+			return -1
+		}, g) // "NorthEast" → "ne"
+		if key == strings.ToLower(g) || key == short {
+			return g, nil
+		}
+	}
+	return "", fmt.Errorf("gravity must be a compass direction (north, southeast, …) or center, got %q", s)
+}
+
+// DefaultBackground is the colour pad fills the canvas with.
+const DefaultBackground = "white"
+
+// ParseBackground checks a user-supplied background colour: a colour name
+// such as "white" or "gray90", "transparent", or hex like "#fff" or
+// "#f5f5f5".  Empty and white both yield "".
+func ParseBackground(s string) (string, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	if v == "" || v == DefaultBackground {
+		return "", nil
+	}
+	valid := len(v) <= 32
+	if hex, ok := strings.CutPrefix(v, "#"); ok {
+		switch len(hex) {
+		case 3, 4, 6, 8, 12:
+		default:
+			valid = false
+		}
+		for _, r := range hex {
+			valid = valid && strings.ContainsRune("0123456789abcdef", r)
+		}
+	} else {
+		for i, r := range v {
+			valid = valid && (r >= 'a' && r <= 'z' || i > 0 && r >= '0' && r <= '9')
+		}
+	}
+	if !valid {
+		return "", fmt.Errorf("background must be a colour name like white or gray90, transparent, or hex like #f5f5f5, got %q", s)
+	}
+	return v, nil
+}
+
+// Validate checks everything in o that ends up on the gm command line —
+// geometry, quality, interlace scheme, unsharp mask, watermark — and the
+// file patterns, so that bad input is reported before the first file is
+// touched.  The directory itself is checked when it is scanned.
+func (o Options) Validate() error {
+	if strings.TrimSpace(o.Dir) == "" {
+		return fmt.Errorf("base directory is empty")
+	}
+	g, err := geometry.Parse(o.Resize)
+	if err != nil {
+		return fmt.Errorf("resize: %w", err)
+	}
+	switch o.ResizeMode {
+	case ResizeFit:
+	case ResizeFill, ResizePad:
+		if g.Width == 0 || g.Height == 0 || g.Percent || g.Area {
+			return fmt.Errorf("resize: %s mode needs a width and a height in pixels, e.g. 400x400, got %q", o.ResizeMode, o.Resize)
+		}
+	default:
+		return fmt.Errorf("resize mode must be fit, %s or %s, got %q", ResizeFill, ResizePad, o.ResizeMode)
+	}
+	if !o.Upscale {
+		switch {
+		case strings.Contains(g.Flags, "<"):
+			return fmt.Errorf("resize: %q only enlarges images; set upscale to allow that", o.Resize)
+		case g.Percent && (g.Width > 100 || g.Height > 100):
+			return fmt.Errorf("resize: %q enlarges every image; set upscale to allow that", o.Resize)
+		}
+	}
+	if o.Gravity != "" && !slices.Contains(Gravities, o.Gravity) {
+		return fmt.Errorf("gravity %q is not one of %s", o.Gravity, strings.Join(Gravities, ", "))
+	}
+	if _, err := ParseBackground(o.Background); err != nil {
+		return err
+	}
+	if _, err := ParseTone(o.Tone); err != nil {
+		return err
+	}
+	if _, err := ParseRotate(o.Rotate); err != nil {
+		return err
+	}
+	switch o.Subsampling {
+	case "", Subsampling444, Subsampling422, Subsampling420:
+	default:
+		return fmt.Errorf("subsampling must be %s, %s or %s, got %q", Subsampling444, Subsampling422, Subsampling420, o.Subsampling)
+	}
+	if o.Quality < 1 || o.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100, got %d", o.Quality)
+	}
+	if o.SampleSize < 0 {
+		return fmt.Errorf("sample size must be a positive number of files, got %d", o.SampleSize)
+	}
+	if o.Verify != "" && o.Verify != VerifyHeader && o.Verify != VerifyFull {
+		return fmt.Errorf("verify must be %s, %s or none, got %q", VerifyHeader, VerifyFull, o.Verify)
+	}
+	if _, err := ParseConflict(o.OnConflict); err != nil {
+		return err
+	}
+	if _, err := ParseLinkSkipped(o.LinkSkipped); err != nil {
+		return err
+	}
+	if _, err := ParseReportFormat(o.ReportFormat); err != nil {
+		return err
+	}
+	switch o.FollowSymlinks {
+	case "", SymlinksSkip, SymlinksOnce:
+	case SymlinksFollow:
+		if o.Overwrite {
+			return fmt.Errorf("follow symlinks: %s would compress a file linked from two places twice in overwrite mode; use %s", SymlinksFollow, SymlinksOnce)
+		}
+	default:
+		return fmt.Errorf("follow symlinks must be %s, %s or %s, got %q", SymlinksSkip, SymlinksFollow, SymlinksOnce, o.FollowSymlinks)
+	}
+	if o.Fallback != "" && o.Fallback != FallbackMagick {
+		return fmt.Errorf("fallback must be %s or none, got %q", FallbackMagick, o.Fallback)
+	}
+	for _, p := range o.Patterns {
+		if strings.ContainsAny(p, `/\`) {
+			return fmt.Errorf("pattern %q must match file names, not paths", p)
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("pattern %q: %w", p, err)
+		}
+	}
+	switch o.Interlace {
+	case "", InterlaceLine, InterlacePlane:
+	default:
+		return fmt.Errorf("interlace must be %s or %s, got %q", InterlaceLine, InterlacePlane, o.Interlace)
+	}
+	if o.Sharpen != "" && !sharpenRE.MatchString(o.Sharpen) {
+		return fmt.Errorf("sharpen: %q is not an unsharp geometry like %s", o.Sharpen, DefaultSharpen)
+	}
+	if o.Workers < WorkersAuto || o.Workers > MaxWorkers {
+		return fmt.Errorf("workers must be auto or a number from 1 to %d, got %d", MaxWorkers, o.Workers)
+	}
+	if o.PerDirectory < 0 || o.PerDirectory > MaxWorkers {
+		return fmt.Errorf("files per directory must be a number from 1 to %d, got %d", MaxWorkers, o.PerDirectory)
+	}
+	switch o.OptimizePNG {
+	case "", PNGLossless, PNGLossy:
+	default:
+		return fmt.Errorf("PNG optimisation must be %s or %s, got %q", PNGLossless, PNGLossy, o.OptimizePNG)
+	}
+	if o.OutputFormat != "" {
+		if !slices.Contains(OutputFormats, o.OutputFormat) {
+			return fmt.Errorf("output format must be %s, got %q", strings.Join(OutputFormats, " or "), o.OutputFormat)
+		}
+		if o.Overwrite {
+			return fmt.Errorf("output format only applies in preserve mode, since overwrite mode keeps each file's name")
+		}
+	}
+	if o.AnimatedGIF != AnimatedKeep && o.AnimatedGIF != AnimatedSkip {
+		return fmt.Errorf("animated GIFs must be keep or skip, got %q", o.AnimatedGIF)
+	}
+	if _, err := ParseVersion(o.GMVersion); err != nil {
+		return err
+	}
+	switch o.Baseline {
+	case BaselineOff, BaselineCheck, BaselineSave:
+	default:
+		return fmt.Errorf("baseline must be %s or %s, got %q", BaselineCheck, BaselineSave, o.Baseline)
+	}
+	if o.BaselineTolerance < 0 || o.BaselineTolerance > 100 {
+		return fmt.Errorf("baseline tolerance must be between 1 and 100 percentage points, got %d", o.BaselineTolerance)
+	}
+	if o.HEIC && o.Overwrite {
+		return fmt.Errorf("HEIC photos are converted to JPEG, which needs preserve mode")
+	}
+	if o.Effort < 0 || o.Effort > MaxEffort {
+		return fmt.Errorf("effort must be between 1 and %d, got %d", MaxEffort, o.Effort)
+	}
+	if o.TargetSize < 0 {
+		return fmt.Errorf("target size cannot be negative")
+	}
+	if o.MinQuality < 0 || o.MinQuality > 100 {
+		return fmt.Errorf("minimum quality must be between 1 and 100, got %d", o.MinQuality)
+	}
+	if o.SmartQuality && o.TargetSize > 0 {
+		return fmt.Errorf("smart quality and a target size both choose each JPEG's quality; use one of them")
+	}
+	if o.SmartQuality && o.OutputFormat != "" {
+		return fmt.Errorf("smart quality only chooses the quality of JPEGs, not of %s output", strings.ToUpper(o.OutputFormat))
+	}
+	if o.MaxColors != 0 && (o.MaxColors < 2 || o.MaxColors > MaxColorCount) {
+		return fmt.Errorf("maximum colours must be between 2 and %d, got %d", MaxColorCount, o.MaxColors)
+	}
+	if o.MinPSNR != 0 && (o.MinPSNR < 1 || o.MinPSNR > MaxPSNR) {
+		return fmt.Errorf("minimum PSNR must be between 1 and %d dB, got %s", MaxPSNR, FormatPSNR(o.MinPSNR))
+	}
+	if o.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	if o.MinFileSize < 0 || o.MinWidth < 0 || o.MinHeight < 0 {
+		return fmt.Errorf("minimum file size and dimensions cannot be negative")
+	}
+	if !o.ModifiedAfter.IsZero() && !o.ModifiedBefore.IsZero() && !o.ModifiedAfter.Before(o.ModifiedBefore) {
+		return fmt.Errorf("modified after (%s) must be earlier than modified before (%s)", FormatDate(o.ModifiedAfter), FormatDate(o.ModifiedBefore))
+	}
+	if o.Exec != "" {
+		if err := o.validateExec(); err != nil {
+			return err
+		}
+	}
+	if len(o.Steps) > 0 {
+		if err := o.validateSteps(); err != nil {
+			return err
+		}
+	}
+	if o.Lossless {
+		if err := o.validateLossless(); err != nil {
+			return err
+		}
+	}
+	if o.NameTemplate != "" {
+		if o.Overwrite {
+			return fmt.Errorf("name template only applies in preserve mode")
+		}
+		if err := ValidateNameTemplate(o.NameTemplate); err != nil {
+			return err
+		}
+	}
+	return o.Watermark.validate(o.Dir)
+}
+
+// resizeArgs returns the gm arguments that scale an image for opts' resize
+// mode:
+//
+//	fit   -resize WxH>
+//	fill  -resize WxH^ -gravity G -extent WxH
+//	pad   -resize WxH> -gravity G -background B -extent WxH
+//
+// With opts.Upscale the ">" is left out, so that smaller images are
+// enlarged too.  Fill scales up images smaller than the box unless
+// convertFile first shrank the box to them (see noUpscale); pad centres
+// them on the canvas instead.
+func resizeArgs(opts Options) []string {
+	g, err := geometry.Parse(opts.Resize)
+	if err != nil || opts.ResizeMode == ResizeFit {
+		return []string{"-resize", resizeArg(opts.Resize, opts.Upscale)}
+	}
+	box := geometry.Geometry{Width: g.Width, Height: g.Height}
+	gravity := opts.Gravity
+	if gravity == "" {
+		gravity = DefaultGravity
+	}
+	if opts.ResizeMode == ResizeFill {
+		return []string{"-resize", box.WithFlag('^').String(), "-gravity", gravity, "-extent", box.String()}
+	}
+	scaled := box
+	if !opts.Upscale {
+		scaled = box.WithFlag('>')
+	}
+	background := opts.Background
+	if background == "" {
+		background = DefaultBackground
+	}
+	return []string{"-resize", scaled.String(), "-gravity", gravity, "-background", background, "-extent", box.String()}
+}
+
+// resizeArg returns the -resize argument for geom.  The ">" modifier (only
+// shrink larger images, never upscale) is added unless the geometry already
+// says which way to scale or upscale allows enlarging.  A long edge
+// becomes a square box, which limits the longer side whichever way round
+// the image is, without reading its size first.
+func resizeArg(geom string, upscale bool) string {
+	g, err := geometry.Parse(geom)
+	if err != nil {
+		return geom // rejected by Validate before any file is processed
+	}
+	if g.LongEdge {
+		g = geometry.Geometry{Width: g.Width, Height: g.Width}
+	}
+	if !upscale && !strings.ContainsAny(g.Flags, "<>") {
+		g = g.WithFlag('>')
+	}
+	return g.String()
+}
+
+// noUpscale returns opts for converting the image at src, whose fill box is
+// shrunk to the image on each side where the image is smaller: it is then
+// cropped to the box where it overflows, but never enlarged.  opts is
+// returned as it is when it allows upscaling, does not fill, or the size
+// of the image cannot be read, in which case gm reports the problem.
+func noUpscale(bin string, opts Options, src string) Options {
+	if opts.Upscale || opts.ResizeMode != ResizeFill {
+		return opts
+	}
+	g, err := geometry.Parse(opts.Resize)
+	if err != nil {
+		return opts
+	}
+	width, height, err := imageSize(bin, src)
+	if err != nil {
+		return opts
+	}
+	width, height = turned(opts, width, height)
+	if width >= g.Width && height >= g.Height {
+		return opts
+	}
+	box := geometry.Geometry{Width: min(g.Width, width), Height: min(g.Height, height)}
+	opts.Resize = box.String()
+	return opts
+}
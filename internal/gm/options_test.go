@@ -0,0 +1,117 @@
+package gm
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// baseOptions returns options that Validate accepts, for tests to change
+// one setting of.
+func baseOptions(t *testing.T) Options {
+	t.Helper()
+	o := Options{Dir: t.TempDir(), Resize: "1200x1200", Quality: 80, Patterns: []string{"*.jpg"}}
+	if err := o.Validate(); err != nil {
+		t.Fatalf("base options rejected: %v", err)
+	}
+	return o
+}
+
+// quality is a quality setting as a job file, a flag or the form may give
+// it, in range or not.
+type quality int
+
+// Generate returns a quality around the bounds of 1 to 100, inside them,
+// or anywhere an int reaches.
+func (quality) Generate(r *rand.Rand, _ int) reflect.Value {
+	switch r.Intn(3) {
+	case 0:
+		return reflect.ValueOf(quality(r.Intn(8) - 3 + []int{1, 100}[r.Intn(2)]))
+	case 1:
+		return reflect.ValueOf(quality(1 + r.Intn(100)))
+	}
+	edges := []int{math.MinInt, math.MaxInt, int(r.Int63()), -int(r.Int63())}
+	return reflect.ValueOf(quality(edges[r.Intn(len(edges))]))
+}
+
+func TestQualityRange(t *testing.T) {
+	o := baseOptions(t)
+	checked := func(q quality) bool {
+		o.Quality = int(q)
+		err := o.Validate()
+		if q >= 1 && q <= 100 {
+			return err == nil
+		}
+		return err != nil && strings.Contains(err.Error(), strconv.Itoa(int(q)))
+	}
+	if err := quick.Check(checked, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+
+	o.Quality = 80
+	minChecked := func(q quality) bool {
+		o.MinQuality = int(q)
+		err := o.Validate()
+		if q >= 0 && q <= 100 {
+			return err == nil
+		}
+		return err != nil && strings.Contains(err.Error(), strconv.Itoa(int(q)))
+	}
+	if err := quick.Check(minChecked, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+// qualities are a quality and a minimum quality that Validate accepts.
+type qualities struct{ q, min int }
+
+// Generate returns a quality from 1 to 100 and a minimum from 0 (unset) to
+// 100, above the quality or not.
+func (qualities) Generate(r *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(qualities{1 + r.Intn(100), r.Intn(101)})
+}
+
+func TestMinQualityClamped(t *testing.T) {
+	clamped := func(v qualities) bool {
+		floor := minQuality(Options{Quality: v.q, MinQuality: v.min})
+		switch {
+		case floor < 1 || floor > v.q:
+			return false // the target size loop would start below its floor
+		case v.min == 0:
+			return floor == min(DefaultMinQuality, v.q)
+		default:
+			return floor == min(v.min, v.q)
+		}
+	}
+	if err := quick.Check(clamped, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func FuzzParseSharpen(f *testing.F) {
+	for _, s := range []string{
+		"", "none", "Off", "on", " default ", DefaultSharpen, "0x1+1+0.05", "2x0.5",
+		"0x1+1+0.05+9", "1x1;rm", "0x1 +1", "1.x1", "x1", "０x１",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		v, err := ParseSharpen(s)
+		if err != nil {
+			return
+		}
+		if v == "" {
+			return // off
+		}
+		if strings.ContainsFunc(v, func(r rune) bool { return !strings.ContainsRune("0123456789.x+", r) }) {
+			t.Fatalf("ParseSharpen(%q) = %q, which is not an unsharp geometry", s, v)
+		}
+		if again, err := ParseSharpen(v); err != nil || again != v {
+			t.Fatalf("ParseSharpen(%q) = %q, but ParseSharpen(%q) = %q, %v", s, v, v, again, err)
+		}
+	})
+}
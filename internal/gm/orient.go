@@ -0,0 +1,189 @@
+package gm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Lossless orientation fix
+// ---------------------------------------------------------------------------
+
+// jpegtranInstall tells users how to get jpegtran, which Orient needs.
+const jpegtranInstall = "brew install jpeg-turbo / apt install libjpeg-turbo-progs"
+
+// orientPatterns are the files Orient looks at: only JPEGs can be rotated
+// without decoding them.
+var orientPatterns = []string{"*.jpg", "*.jpeg"}
+
+// orientTransforms are jpegtran's arguments for each EXIF orientation that
+// is not upright, i.e. 2–8; they turn the pixels the way viewers that read
+// the tag would show them.
+var orientTransforms = map[int][]string{
+	2: {"-flip", "horizontal"},
+	3: {"-rotate", "180"},
+	4: {"-flip", "vertical"},
+	5: {"-transpose"},
+	6: {"-rotate", "90"},
+	7: {"-transverse"},
+	8: {"-rotate", "270"},
+}
+
+// OrientResult is the outcome of Orient.
+type OrientResult struct {
+	// Rotated are the files turned upright, relative to Options.Dir; with
+	// a dry run, the files that would be.
+	Rotated []string
+
+	// Upright counts the JPEGs that were upright already, or have no
+	// orientation tag.
+	Upright int
+
+	// Imperfect are the files left alone because their size is not a
+	// whole number of JPEG blocks, so that turning them would lose the
+	// pixels along one edge.
+	Imperfect []string
+}
+
+// Orient turns the JPEGs of opts.Dir upright according to their EXIF
+// orientation tag without re-encoding them: jpegtran moves the compressed
+// blocks around, and the tag is then reset to upright, so no quality is
+// lost.  opts.Recursive, opts.ModifiedAfter and opts.ModifiedBefore pick
+// the files as for Run, and with opts.Backup every original is first
+// copied to the backup directory, from which Restore puts it back.  With
+// dryRun nothing is changed.  Orient stops at the first file jpegtran
+// fails on for any other reason than the block size.
+func Orient(opts Options, dryRun bool) (OrientResult, error) {
+	var res OrientResult
+	opts.Patterns, opts.HEIC = orientPatterns, false
+	files, err := Scan(opts)
+	if err != nil {
+		return res, err
+	}
+	jpegtran := ""
+	if !dryRun {
+		if jpegtran, err = exec.LookPath("jpegtran"); err != nil {
+			return res, fmt.Errorf("jpegtran is not installed (%s)", jpegtranInstall)
+		}
+	}
+
+	for _, rel := range files {
+		src := filepath.Join(opts.Dir, rel)
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return res, err
+		}
+		o, _, _ := exifOrientation(data)
+		transform, ok := orientTransforms[o]
+		if !ok {
+			res.Upright++
+			continue
+		}
+		if dryRun {
+			res.Rotated = append(res.Rotated, rel)
+			continue
+		}
+		tmp := filepath.Join(filepath.Dir(src), partialPrefix+filepath.Base(src))
+		args := append([]string{"-copy", "all", "-perfect"}, transform...)
+		out, err := exec.Command(jpegtran, append(args, "-outfile", tmp, src)...).CombinedOutput()
+		if err != nil {
+			os.Remove(tmp)
+			if bytes.Contains(out, []byte("not perfect")) {
+				res.Imperfect = append(res.Imperfect, rel)
+				continue
+			}
+			return res, fmt.Errorf("jpegtran %s: %v: %s", rel, err, strings.TrimSpace(string(out)))
+		}
+		if err := resetOrientation(tmp); err != nil {
+			os.Remove(tmp)
+			return res, fmt.Errorf("%s: %w", rel, err)
+		}
+		if opts.Backup {
+			if err := backupFile(backupRoot(opts), rel, src); err != nil {
+				os.Remove(tmp)
+				return res, fmt.Errorf("back up %s: %w", rel, err)
+			}
+		}
+		if err := os.Rename(tmp, src); err != nil {
+			os.Remove(tmp)
+			return res, err
+		}
+		res.Rotated = append(res.Rotated, rel)
+	}
+	return res, nil
+}
+
+// resetOrientation sets the EXIF orientation tag of the JPEG at path to
+// upright, in place.
+func resetOrientation(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, at, order := exifOrientation(data)
+	if at < 0 {
+		return errors.New("jpegtran dropped the orientation tag")
+	}
+	order.PutUint16(data[at:], 1)
+	return os.WriteFile(path, data, 0o644)
+}
+
+// exifOrientation returns the orientation tag of a JPEG, where its value
+// is in data and the byte order of the EXIF block, or 0 and -1 when the
+// JPEG has none.  Only the markers before the image data are looked at.
+func exifOrientation(data []byte) (int, int, binary.ByteOrder) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, -1, nil
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || size < 2 || i+2+size > len(data) {
+			break // start of scan: no more metadata
+		}
+		seg := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			o, at, order := tiffOrientation(seg[6:])
+			if at >= 0 {
+				at += i + 4 + 6
+			}
+			return o, at, order
+		}
+		i += 2 + size
+	}
+	return 0, -1, nil
+}
+
+// tiffOrientation reads the orientation tag (0x0112) from the first IFD of
+// the TIFF structure in an EXIF block, as exifOrientation does.
+func tiffOrientation(t []byte) (int, int, binary.ByteOrder) {
+	if len(t) < 8 {
+		return 0, -1, nil
+	}
+	var order binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, -1, nil
+	}
+	ifd := int(order.Uint32(t[4:]))
+	if ifd < 8 || ifd+2 > len(t) {
+		return 0, -1, nil
+	}
+	n := int(order.Uint16(t[ifd:]))
+	for e := ifd + 2; e+12 <= len(t) && n > 0; e, n = e+12, n-1 {
+		if order.Uint16(t[e:]) == 0x0112 && order.Uint16(t[e+2:]) == 3 { // SHORT
+			return int(order.Uint16(t[e+8:])), e + 8, order
+		}
+	}
+	return 0, -1, nil
+}
@@ -0,0 +1,463 @@
+package gm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/geometry"
+)
+
+// ---------------------------------------------------------------------------
+// Pipelines: a file converted in steps, each its own command, instead of
+// one gm invocation with every option
+// ---------------------------------------------------------------------------
+
+// Step operations of Options.Steps.  The first five are carried out by gm,
+// optimize by jpegtran or the PNG optimisers, exec by a command of the
+// user's.
+const (
+	StepAutoOrient = "auto_orient" // gm -auto-orient
+	StepResize     = "resize"      // gm -resize, to the value's geometry or Options.Resize
+	StepSharpen    = "sharpen"     // gm -unsharp, the value's geometry or DefaultSharpen
+	StepWatermark  = "watermark"   // gm composite, the value's image or Options.Watermark
+	StepStrip      = "strip"       // gm +profile "*": drop EXIF data and profiles
+	StepOptimize   = "optimize"    // jpegtran -optimize, optipng or pngquant: lossless or lossy
+	StepExec       = "exec"        // the value, a command with {in} and {out}
+)
+
+// Step is one step of a pipeline: an operation, its setting, which is
+// empty where the operation takes none or uses its default, and the
+// condition under which it runs, empty for every file.
+type Step struct {
+	Op    string
+	Value string
+	If    string
+}
+
+// String returns s as ParseStep reads it, e.g. "resize 1600x1600" or
+// "optimize lossy if png and colors > 256".
+func (s Step) String() string {
+	str := s.Op
+	if s.Value != "" {
+		str += " " + s.Value
+	}
+	if s.If != "" {
+		str += " if " + s.If
+	}
+	return str
+}
+
+// byGM reports whether gm carries s out.  gm steps pass the image on to
+// the next one losslessly; see runSteps.
+func (s Step) byGM() bool {
+	return s.Op != StepOptimize && s.Op != StepExec
+}
+
+// ParseStep reads a step as a job file or preset writes it: the operation,
+// then its setting after a space, e.g. "auto_orient", "resize 1600x1600",
+// "sharpen 0x1+1+0.05", "watermark ./logo.png", "strip", "optimize lossy"
+// or "exec cwebp -q 80 {in} -o {out}".  A condition after the last " if "
+// limits the step to the files it holds for, e.g. "watermark ./logo.png
+// if landscape and width > 1600"; see parseCondition.  An " if " inside
+// quotes, as the shell reads them, is part of the setting, so an exec
+// command keeps its own by quoting it: "exec sh -c 'if …; fi' {in} {out}".
+func ParseStep(s string) (Step, error) {
+	body, cond := strings.TrimSpace(s), ""
+	if i := lastUnquoted(body, " if "); i >= 0 {
+		body, cond = body[:i], body[i+len(" if "):]
+	}
+	op, value, _ := strings.Cut(body, " ")
+	step := Step{Op: strings.ReplaceAll(strings.ToLower(op), "-", "_"), Value: strings.TrimSpace(value)}
+	var err error
+	switch step.Op {
+	case StepAutoOrient, StepStrip:
+		if step.Value != "" {
+			err = fmt.Errorf("%s takes no setting", step.Op)
+		}
+	case StepResize:
+		if step.Value != "" {
+			_, err = geometry.Parse(step.Value)
+		}
+	case StepSharpen:
+		if step.Value, err = ParseSharpen(step.Value); err == nil && step.Value == "" {
+			step.Value = DefaultSharpen
+		}
+	case StepWatermark:
+	case StepOptimize:
+		if step.Value, err = ParsePNGOptimize(step.Value); err == nil && step.Value == "" {
+			step.Value = PNGLossless
+		}
+	case StepExec:
+		if !strings.Contains(step.Value, ExecIn) || !strings.Contains(step.Value, ExecOut) {
+			err = fmt.Errorf("the command must contain %s and %s", ExecIn, ExecOut)
+		}
+	default:
+		err = fmt.Errorf("unknown operation %q; steps are %s", op, strings.Join([]string{
+			StepAutoOrient, StepResize, StepSharpen, StepWatermark, StepStrip, StepOptimize, StepExec,
+		}, ", "))
+	}
+	if err == nil && cond != "" {
+		var terms []condTerm
+		if terms, err = parseCondition(cond); err == nil {
+			step.If = formatCondition(terms)
+		}
+	}
+	if err != nil && cond != "" && step.Op == StepExec {
+		err = fmt.Errorf("%w (the command's \" if \" must be quoted, or it starts a condition)", err)
+	}
+	if err != nil {
+		return Step{}, fmt.Errorf("step %q: %w", strings.TrimSpace(s), err)
+	}
+	return step, nil
+}
+
+// lastUnquoted returns the index of the last sep in s that is outside
+// single and double quotes, or -1.  Quotes and backslashes are read the way
+// sh reads them.
+func lastUnquoted(s, sep string) int {
+	last := -1
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			i++ // the next byte is taken literally, in or out of double quotes
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(s[i:], sep):
+			last = i
+		}
+	}
+	return last
+}
+
+// ParseSteps reads a job file's or preset's list of steps with ParseStep.
+func ParseSteps(ss []string) ([]Step, error) {
+	var steps []Step
+	for _, s := range ss {
+		step, err := ParseStep(s)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// FormatSteps returns steps as ParseSteps reads them.
+func FormatSteps(steps []Step) []string {
+	var ss []string
+	for _, s := range steps {
+		ss = append(ss, s.String())
+	}
+	return ss
+}
+
+// validateSteps checks that the steps' settings can be used, and that o
+// asks for nothing the steps leave out: what a single gm invocation would
+// do besides resizing and encoding must be a step of its own.
+func (o Options) validateSteps() error {
+	bareWatermark := false
+	for _, s := range o.Steps {
+		if s.Op != StepWatermark {
+			continue
+		}
+		if s.Value == "" {
+			if !o.Watermark.Enabled() {
+				return fmt.Errorf("step %q needs an image, or a watermark in the job", s)
+			}
+			bareWatermark = true
+			continue
+		}
+		if err := (Watermark{Image: s.Value}).validate(o.Dir); err != nil {
+			return fmt.Errorf("step %q: %w", s, err)
+		}
+	}
+	var left []string
+	if o.Lossless {
+		left = append(left, "lossless mode")
+	}
+	if o.Exec != "" {
+		left = append(left, "an exec command (make it an exec step)")
+	}
+	if o.AutoOrient {
+		left = append(left, "auto-orient (make it an auto_orient step)")
+	}
+	if o.Rotate != "" || o.Flip || o.Flop {
+		left = append(left, "rotation and flips")
+	}
+	if o.Sharpen != "" {
+		left = append(left, "sharpening (make it a sharpen step)")
+	}
+	if o.Watermark.Enabled() && !bareWatermark {
+		left = append(left, "a watermark (add a watermark step)")
+	}
+	if o.OptimizePNG != "" {
+		left = append(left, "PNG optimisation (make it an optimize step)")
+	}
+	if o.TargetSize > 0 {
+		left = append(left, "a target size")
+	}
+	if o.SmartQuality {
+		left = append(left, "smart quality")
+	}
+	if o.MaxColors > 0 {
+		left = append(left, "a maximum colour count")
+	}
+	if o.ConvertToSRGB {
+		left = append(left, "sRGB conversion")
+	}
+	if o.Tone != "" {
+		left = append(left, "a "+o.Tone+" tone")
+	}
+	if o.DropAlpha {
+		left = append(left, "dropping alpha channels")
+	}
+	if o.OutputFormat != "" {
+		left = append(left, "an output format (name the outputs with a name template instead)")
+	}
+	if o.HEIC {
+		left = append(left, "HEIC conversion")
+	}
+	if o.Fallback != "" {
+		left = append(left, "a fallback")
+	}
+	if o.MinPSNR > 0 {
+		left = append(left, "a minimum PSNR")
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("a pipeline of steps cannot be combined with %s", strings.Join(left, ", "))
+	}
+	return nil
+}
+
+// stepArgs returns the gm arguments of the gm step s, reading in and
+// writing out.
+func stepArgs(opts Options, s Step, in, out string) []string {
+	switch s.Op {
+	case StepAutoOrient:
+		return []string{"convert", in, "-auto-orient", out}
+	case StepResize:
+		if s.Value != "" {
+			opts.Resize = s.Value
+		}
+		return append(append([]string{"convert", in}, resizeArgs(opts)...), out)
+	case StepSharpen:
+		return []string{"convert", in, "-unsharp", s.Value, out}
+	case StepWatermark:
+		if s.Value != "" {
+			opts.Watermark = Watermark{Image: s.Value}
+		}
+		args := watermarkArgs(opts, out)
+		return append(args[:len(args)-2], in, out)
+	case StepStrip:
+		return []string{"convert", in, "+profile", "*", out}
+	}
+	return nil
+}
+
+// encodeArgs returns the gm arguments that write the image in to out in
+// its format, with the run's quality and encoding options.
+func encodeArgs(opts Options, in, out string) []string {
+	args := []string{"convert", in, "-quality", fmt.Sprint(opts.Quality)}
+	if opts.Interlace != "" && isJPEG(out) {
+		args = append(args, "-interlace", opts.Interlace)
+	}
+	if opts.Subsampling != "" && isJPEG(out) {
+		args = append(args, "-sampling-factor", opts.Subsampling)
+	}
+	if opts.Effort > 0 && strings.EqualFold(filepath.Ext(out), "."+OutputWebP) {
+		args = append(args, "-define", fmt.Sprintf("webp:method=%d", webpMethod(opts.Effort)))
+	}
+	return append(args, out)
+}
+
+// runSteps converts in into out, both relative to opts.Dir, with the
+// steps of opts.Steps in order, each reading what the one before wrote.
+// Steps whose condition does not hold for the file are left out.
+// gm steps hand the image on as MIFF, gm's own lossless format, so that
+// it is encoded only once: before a step that works on files of out's
+// format, and at the end.  A source already in out's format that no gm
+// step has touched goes to such a step as it is.  The steps write
+// partial files beside out, which only takes the last one's place once
+// every step has succeeded.  It has the signature of encode, whose place
+// it takes.
+func runSteps(ctx context.Context, bin string, opts Options, rel, in, out string, log io.Writer) error {
+	abs := func(p string) string { return filepath.Join(opts.Dir, p) }
+	partial := filepath.Join(filepath.Dir(out), partialPrefix+filepath.Base(out))
+	var temps []string
+	defer func() {
+		for _, t := range temps {
+			os.Remove(abs(t))
+		}
+	}()
+	next := func(ext string) string {
+		p := fmt.Sprintf("%s.%d%s", partial, len(temps)+1, ext)
+		temps = append(temps, p)
+		return p
+	}
+	run := func(stage stage, name string, args ...string) error {
+		defer timeStage(ctx, stage, time.Now())
+		cmd := command(ctx, name, args...)
+		cmd.Dir = opts.Dir
+		attach(cmd, log)
+		return cmd.Run()
+	}
+	ext := filepath.Ext(out)
+	cur, raw := in, false // raw: cur is MIFF, not yet encoded
+	encode := func() error {
+		if !raw && strings.EqualFold(filepath.Ext(cur), ext) {
+			return nil
+		}
+		dst := next(ext)
+		if err := run(stageEncode, bin, encodeArgs(opts, cur, dst)...); err != nil {
+			return fmt.Errorf("%s: encoding: %w", rel, err)
+		}
+		cur, raw = dst, false
+		return nil
+	}
+
+	facts := fileFacts{bin: bin, src: abs(in), out: out}
+	for i, s := range opts.Steps {
+		if s.If != "" {
+			ok, err := facts.holds(s.If)
+			if err != nil {
+				return fmt.Errorf("%s: step %d (%s): condition: %w", rel, i+1, s.Op, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		if s.byGM() {
+			o := opts
+			if s.Op == StepResize {
+				if s.Value != "" {
+					o.Resize = s.Value
+				}
+				o = noUpscale(bin, o, abs(cur))
+			}
+			dst := next(".miff")
+			if err := run(stageResize, bin, stepArgs(o, s, cur, dst)...); err != nil {
+				return fmt.Errorf("%s: step %d (%s): %w", rel, i+1, s.Op, err)
+			}
+			cur, raw = dst, true
+			continue
+		}
+		if err := encode(); err != nil {
+			return err
+		}
+		dst := next(ext)
+		var err error
+		switch s.Op {
+		case StepOptimize:
+			err = optimizeStep(ctx, opts, s, rel, cur, dst, log)
+		case StepExec:
+			err = run(stageEncode, "sh", "-c", execLine(Options{Exec: s.Value}, cur, dst))
+			if _, serr := os.Stat(abs(dst)); err == nil && serr != nil {
+				err = fmt.Errorf("wrote nothing to %s", ExecOut)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: step %d (%s): %w", rel, i+1, s.Op, err)
+		}
+		cur = dst
+	}
+	if err := encode(); err != nil {
+		return err
+	}
+
+	defer timeStage(ctx, stageWrite, time.Now())
+	if cur == in {
+		// Nothing was written: the steps left the source as it was.
+		if err := copyFile(abs(in), abs(partial)); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		cur = partial
+		temps = append(temps, partial)
+	}
+	if err := os.Rename(abs(cur), abs(out)); err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	return nil
+}
+
+// optimizeStep writes in, a JPEG or PNG relative to opts.Dir, to out with
+// its coding optimised: by jpegtran, which keeps the metadata a strip
+// step has not dropped, or by the PNG optimisers of the step's level.
+// Other formats, and files whose optimiser is not installed, are copied
+// as they are, with a note in log.
+func optimizeStep(ctx context.Context, opts Options, s Step, rel, in, out string, log io.Writer) error {
+	defer timeStage(ctx, stageEncode, time.Now())
+	abs := func(p string) string { return filepath.Join(opts.Dir, p) }
+	switch {
+	case isJPEG(in):
+		jpegtran, err := exec.LookPath("jpegtran")
+		if err != nil {
+			fmt.Fprintf(log, "note: %s: not optimised: install jpegtran (%s)\n", rel, jpegtranInstall)
+			break
+		}
+		args := []string{"-copy", "all", "-optimize"}
+		if opts.Interlace != "" {
+			args = append(args, "-progressive")
+		}
+		cmd := command(ctx, jpegtran, append(args, "-outfile", out, in)...)
+		cmd.Dir = opts.Dir
+		attach(cmd, log)
+		return cmd.Run()
+	case isPNG(in):
+		o := opts
+		o.OptimizePNG = s.Value
+		t := findPNGTools(o)
+		if note := t.missing(s.Value); note != "" {
+			fmt.Fprintf(log, "note: %s: %s\n", rel, note)
+		}
+		if err := copyFile(abs(in), abs(out)); err != nil {
+			return err
+		}
+		optimizePNG(ctx, t, o, rel, out, log)
+		return nil
+	default:
+		fmt.Fprintf(log, "note: %s: not optimised: only JPEGs and PNGs are\n", rel)
+	}
+	return copyFile(abs(in), abs(out))
+}
+
+// stepsCommand describes what a pipeline runs, for Result.Command: a line
+// per step, with {1}, {2}, … for what each step wrote and its condition,
+// and the encoding.
+func stepsCommand(opts Options) string {
+	lines := []string{"(in " + opts.Dir + ")"}
+	cur := "{file}"
+	for i, s := range opts.Steps {
+		dst := fmt.Sprintf("{%d}", i+1)
+		var line string
+		switch s.Op {
+		case StepOptimize:
+			line = fmt.Sprintf("optimize %s: jpegtran -copy all -optimize, or the PNG optimisers, %s → %s", s.Value, cur, dst)
+		case StepExec:
+			line = strings.NewReplacer(ExecIn, cur, ExecOut, dst).Replace(s.Value)
+		default:
+			line = "gm " + shellJoin(stepArgs(opts, s, cur, dst))
+		}
+		if s.If != "" {
+			line += "  (if " + s.If + ")"
+		}
+		lines = append(lines, line)
+		cur = dst
+	}
+	lines = append(lines, "gm "+shellJoin(encodeArgs(opts, "{last}", displayOutput(opts)))+"  (when not already encoded)")
+	return strings.Join(lines, "\n")
+}
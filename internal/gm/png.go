@@ -0,0 +1,115 @@
+package gm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// PNG optimisation
+// ---------------------------------------------------------------------------
+
+// PNG optimisation levels for Options.OptimizePNG.
+const (
+	PNGLossless = "lossless" // optipng, or zopflipng when optipng is missing
+	PNGLossy    = "lossy"    // pngquant to a palette first, then lossless
+)
+
+// ParsePNGOptimize maps a user-supplied level to Options.OptimizePNG:
+// "lossless", "lossy", or "" / "off" / "none" for no optimisation.
+func ParsePNGOptimize(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "off", "none":
+		return "", nil
+	case PNGLossless:
+		return PNGLossless, nil
+	case PNGLossy:
+		return PNGLossy, nil
+	}
+	return "", fmt.Errorf("png_optimize must be lossless, lossy or off, got %q", s)
+}
+
+// pngTools are the PNG optimisers found on PATH; a missing one is "".
+type pngTools struct {
+	pngquant  string
+	optipng   string
+	zopflipng string
+}
+
+// findPNGTools looks up the optimisers that opts.OptimizePNG can use.
+func findPNGTools(opts Options) pngTools {
+	var t pngTools
+	if opts.OptimizePNG == "" {
+		return t
+	}
+	look := func(name string) string {
+		p, _ := exec.LookPath(name)
+		return p
+	}
+	if opts.OptimizePNG == PNGLossy {
+		t.pngquant = look("pngquant")
+	}
+	t.optipng = look("optipng")
+	if t.optipng == "" {
+		t.zopflipng = look("zopflipng")
+	}
+	return t
+}
+
+// missing explains which optimisers level needs but could not be found, or
+// returns "" when everything is there.
+func (t pngTools) missing(level string) string {
+	lossless := t.optipng != "" || t.zopflipng != ""
+	switch {
+	case level == "":
+		return ""
+	case level == PNGLossy && t.pngquant == "" && !lossless:
+		return "PNGs are not optimised: install pngquant and optipng"
+	case level == PNGLossy && t.pngquant == "":
+		return "PNGs are only optimised losslessly: install pngquant for lossy compression"
+	case !lossless:
+		return "PNGs are not optimised losslessly: install optipng or zopflipng"
+	}
+	return ""
+}
+
+// isPNG reports whether name has a PNG file extension.
+func isPNG(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".png")
+}
+
+// optimizePNG shrinks the PNG gm wrote to out, relative to opts.Dir, in
+// place with the optimisers in t.  A failing optimiser is noted in log and
+// otherwise ignored: gm's PNG is still a good file.
+func optimizePNG(ctx context.Context, t pngTools, opts Options, rel, out string, log io.Writer) {
+	run := func(name string, args ...string) {
+		cmd := command(ctx, name, args...)
+		cmd.Dir = opts.Dir
+		attach(cmd, log)
+		err := cmd.Run()
+		// pngquant exits 98 when its result would be larger than the
+		// input and 99 when it cannot keep the quality; it then leaves the
+		// file alone.
+		var exit *exec.ExitError
+		if t.pngquant == name && errors.As(err, &exit) && (exit.ExitCode() == 98 || exit.ExitCode() == 99) {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(log, "%s: %s failed, keeping gm's PNG: %v\n", rel, filepath.Base(name), err)
+		}
+	}
+	if t.pngquant != "" {
+		run(t.pngquant, "--force", "--skip-if-larger", "--output", out, "--", out)
+	}
+	switch {
+	case t.optipng != "":
+		run(t.optipng, "-quiet", "-o2", "--", out)
+	case t.zopflipng != "":
+		run(t.zopflipng, "-y", out, out)
+	}
+}
@@ -0,0 +1,63 @@
+package gm
+
+import "time"
+
+// ---------------------------------------------------------------------------
+// Progress: how far a run has got and how fast it goes
+// ---------------------------------------------------------------------------
+
+// Progress is passed to Options.Progress each time a file has been dealt
+// with.  Files that were skipped take no time, so the rates and the
+// estimate only count the files gm was run on.
+type Progress struct {
+	Done  int `json:"done"`  // files dealt with, converted or not
+	Total int `json:"total"` // files the run considers
+
+	// Attempted counts the files of Done gm was run on, whether it
+	// succeeded or not, and Bytes adds up their sizes before conversion.
+	Attempted int   `json:"attempted"`
+	Bytes     int64 `json:"bytes"`
+
+	// Busy adds up how long the workers took over each attempted file;
+	// with several workers it runs ahead of Elapsed, the time since the
+	// run started converting.
+	Busy    time.Duration `json:"busy"`
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// FilesPerSecond returns how many files gm converts a second, or 0 before
+// the first one is done.
+func (p Progress) FilesPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Attempted) / p.Elapsed.Seconds()
+}
+
+// BytesPerSecond returns how many bytes of originals gm gets through a
+// second, or 0 before the first file is done.
+func (p Progress) BytesPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// Remaining estimates how long the files still to go will take: the mean
+// time per attempted file so far, times the files left, shared among as
+// many workers as have been busy on average — but never more workers than
+// files left.  ok is false until a file has been attempted.  Files left
+// that turn out to need nothing are counted as if converted, so the
+// estimate errs on the long side for resumed runs.
+func (p Progress) Remaining() (d time.Duration, ok bool) {
+	left := p.Total - p.Done
+	if left <= 0 {
+		return 0, p.Total > 0
+	}
+	if p.Attempted == 0 || p.Busy <= 0 || p.Elapsed <= 0 {
+		return 0, false
+	}
+	perFile := float64(p.Busy) / float64(p.Attempted)
+	workers := min(max(float64(p.Busy)/float64(p.Elapsed), 1), float64(left))
+	return time.Duration(perFile * float64(left) / workers), true
+}
@@ -0,0 +1,162 @@
+package gm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// PSNR check: how far encoding took each output from the image it was meant
+// to be
+// ---------------------------------------------------------------------------
+
+// MaxPSNR is the PSNR, in decibels, of an output identical to its
+// reference, for which gm reports an infinite one.
+const MaxPSNR = 100
+
+// ParseMinPSNR converts a user-supplied minimum PSNR such as "35" or
+// "35dB" to an Options.MinPSNR value.  "", "none" and "off" mean no check.
+func ParseMinPSNR(s string) (float64, error) {
+	t := strings.ToLower(strings.TrimSpace(s))
+	switch t {
+	case "", "none", "off":
+		return 0, nil
+	}
+	db, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(t, "db")), 64)
+	if err != nil || db < 1 || db > MaxPSNR {
+		return 0, fmt.Errorf("minimum PSNR must be a number of decibels from 1 to %d, e.g. 35, got %q", MaxPSNR, s)
+	}
+	return db, nil
+}
+
+// FormatMinPSNR returns an Options.MinPSNR value as ParseMinPSNR reads
+// it: "" for none.
+func FormatMinPSNR(db float64) string {
+	if db <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(db, 'f', -1, 64)
+}
+
+// psnrMeasure is what the PSNR check found for the file being converted.
+type psnrMeasure struct {
+	db       float64
+	measured bool
+}
+
+// psnrKey is the context key of the psnrMeasure of the file being
+// converted.
+type psnrKey struct{}
+
+// withPSNR returns ctx carrying p, which checkPSNR fills in.
+func withPSNR(ctx context.Context, p *psnrMeasure) context.Context {
+	return context.WithValue(ctx, psnrKey{}, p)
+}
+
+// checksPSNR reports whether the output of rel is compared with its
+// reference.  Lossless runs keep every pixel, and the frames of animated
+// GIFs do not make one image to compare.
+func checksPSNR(opts Options, rel string) bool {
+	return opts.MinPSNR > 0 && !opts.Lossless && !isGIF(rel)
+}
+
+// psnrReference writes what the output of rel would be if it were stored
+// losslessly: in, converted with every gm option of the run into a PNG
+// beside out.  The loss the PSNR check measures is then only that of
+// encoding, not of resizing or other changes asked for.  An exec command
+// does its own resizing, if any, so its reference is in as it is.  The
+// PNG's path is returned relative to opts.Dir; the caller removes it.
+func psnrReference(ctx context.Context, bin string, opts Options, in, out string) (string, error) {
+	defer timeStage(ctx, stageDecode, time.Now())
+	ref := filepath.Join(filepath.Dir(out), partialPrefix+filepath.Base(out)+".ref.png")
+	args := []string{"convert", in, ref}
+	if opts.Exec == "" {
+		o := opts
+		o.Overwrite = false
+		args = fileArgs(o, in, ref)
+	}
+	cmd := command(ctx, bin, args...)
+	cmd.Dir = opts.Dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return ref, nil
+}
+
+// checkPSNR compares out with ref, both relative to opts.Dir, and records
+// the PSNR in the psnrMeasure of ctx.  An output below opts.MinPSNR gets a
+// warning in log; one gm cannot compare gets a note, and counts as not
+// measured.
+func checkPSNR(ctx context.Context, bin string, opts Options, rel, ref, out string, log io.Writer) {
+	defer timeStage(ctx, stageDecode, time.Now())
+	db, err := comparePSNR(ctx, bin, filepath.Join(opts.Dir, ref), filepath.Join(opts.Dir, out))
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Fprintf(log, "note: %s: PSNR not measured: %v\n", rel, err)
+		}
+		return
+	}
+	if p, ok := ctx.Value(psnrKey{}).(*psnrMeasure); ok {
+		p.db, p.measured = db, true
+	}
+	if db < opts.MinPSNR {
+		fmt.Fprintf(log, "warning: %s: PSNR %s dB is below the minimum of %s dB\n", rel, FormatPSNR(db), FormatPSNR(opts.MinPSNR))
+	}
+}
+
+// comparePSNR returns the PSNR of the image at path against the one at
+// ref, in decibels, from the "Total:" line of gm compare:
+//
+//	Image Difference (PeakSignalToNoiseRatio):
+//	           PSNR
+//	      ==========
+//	     Red: 38.5213064
+//	     ...
+//	   Total: 38.8745291
+func comparePSNR(ctx context.Context, bin, ref, path string) (float64, error) {
+	cmd := command(ctx, bin, "compare", "-metric", "PSNR", ref, path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return 0, fmt.Errorf("%w: %s", err, msg)
+		}
+		return 0, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		v, ok := strings.CutPrefix(strings.TrimSpace(sc.Text()), "Total:")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if strings.EqualFold(v, "inf") {
+			return MaxPSNR, nil
+		}
+		db, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("gm compare: unexpected PSNR %q", v)
+		}
+		return math.Min(db, MaxPSNR), nil
+	}
+	return 0, fmt.Errorf("gm compare printed no PSNR")
+}
+
+// FormatPSNR returns db as shown to users, to one decimal, e.g. "38.9".
+func FormatPSNR(db float64) string {
+	return strconv.FormatFloat(db, 'f', 1, 64)
+}
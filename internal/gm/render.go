@@ -0,0 +1,316 @@
+package gm
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+)
+
+// ---------------------------------------------------------------------------
+// Report renderers: the formats a per-file report is written in
+// ---------------------------------------------------------------------------
+
+// Report formats, for Options.ReportFormat.
+const (
+	ReportCSV      = "csv"
+	ReportTSV      = "tsv"
+	ReportJSON     = "json" // one JSON object per line
+	ReportMarkdown = "markdown"
+	ReportHTML     = "html"
+	ReportJUnit    = "junit" // JUnit XML, which CI systems show as test results
+)
+
+// ReportRenderer writes a per-file report in one format.  A report is
+// Begin, a Row for each file as it finishes, then End.
+type ReportRenderer interface {
+	// Begin writes what comes before the first row of a new report, such
+	// as a header row.
+	Begin(w io.Writer) error
+	// Row writes what happened to one file.
+	Row(w io.Writer, row ReportRow) error
+	// End writes what comes after the last row.
+	End(w io.Writer) error
+	// Appendable reports whether a later run can add its rows to the end
+	// of an existing report, which needs End to write nothing.
+	Appendable() bool
+}
+
+// reportRenderers maps each report format to its renderer.
+var reportRenderers = map[string]ReportRenderer{
+	ReportCSV:      csvRenderer{comma: ','},
+	ReportTSV:      csvRenderer{comma: '\t'},
+	ReportJSON:     jsonRenderer{},
+	ReportMarkdown: markdownRenderer{},
+	ReportHTML:     htmlRenderer{},
+	ReportJUnit:    junitRenderer{},
+}
+
+// reportExts maps each report format to the file extension that picks it,
+// and that ReportExt returns.
+var reportExts = map[string]string{
+	ReportCSV:      ".csv",
+	ReportTSV:      ".tsv",
+	ReportJSON:     ".jsonl",
+	ReportMarkdown: ".md",
+	ReportHTML:     ".html",
+	ReportJUnit:    ".xml",
+}
+
+// ParseReportFormat converts a report_format value to its
+// Options.ReportFormat value: one of the Report* formats, also "jsonl",
+// "md" and "xml", or "" to pick the format by the report's extension.
+// Matching is case-insensitive.
+func ParseReportFormat(s string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(s)); v {
+	case "", "auto":
+		return "", nil
+	case "jsonl":
+		return ReportJSON, nil
+	case "md":
+		return ReportMarkdown, nil
+	case "xml":
+		return ReportJUnit, nil
+	default:
+		if _, ok := reportRenderers[v]; ok {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("report format must be %s, %s, %s, %s, %s or %s, got %q", ReportCSV, ReportTSV, ReportJSON, ReportMarkdown, ReportHTML, ReportJUnit, s)
+}
+
+// ReportExt returns the file extension of reports in format, e.g. ".csv".
+func ReportExt(format string) string {
+	return reportExts[format]
+}
+
+// reportRenderer returns the renderer for a report written to path: the
+// one format names, or else the one path's extension picks, .htm and
+// .markdown included.  Any other extension gets JSON lines.
+func reportRenderer(path, format string) ReportRenderer {
+	if format == "" {
+		switch ext := strings.ToLower(filepath.Ext(path)); ext {
+		case ".htm":
+			format = ReportHTML
+		case ".markdown":
+			format = ReportMarkdown
+		default:
+			for f, e := range reportExts {
+				if e == ext {
+					format = f
+				}
+			}
+		}
+	}
+	if r, ok := reportRenderers[format]; ok {
+		return r
+	}
+	return jsonRenderer{}
+}
+
+// csvRenderer writes the reportColumns under a header row, separated by
+// comma.  Messages are left out.
+type csvRenderer struct{ comma rune }
+
+func (c csvRenderer) Begin(w io.Writer) error { return c.write(w, reportColumns) }
+
+func (c csvRenderer) Row(w io.Writer, row ReportRow) error { return c.write(w, row.record()) }
+
+func (csvRenderer) End(io.Writer) error { return nil }
+
+func (csvRenderer) Appendable() bool { return true }
+
+func (c csvRenderer) write(w io.Writer, record []string) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = c.comma
+	cw.Write(record)
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonRenderer writes each ReportRow as a JSON object on a line of its own.
+type jsonRenderer struct{}
+
+func (jsonRenderer) Begin(io.Writer) error { return nil }
+
+func (jsonRenderer) Row(w io.Writer, row ReportRow) error {
+	line, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+func (jsonRenderer) End(io.Writer) error { return nil }
+
+func (jsonRenderer) Appendable() bool { return true }
+
+// summaryColumns head the Markdown and HTML reports, which are read by
+// people rather than programs and so keep to what happened to each file,
+// in the order of summaryCells.
+var summaryColumns = []string{"File", "Status", "Output", "Before", "After", "Saved", "Error"}
+
+// summaryCells returns row as the cells under summaryColumns, with sizes
+// formatted for the locale.
+func (row ReportRow) summaryCells() []string {
+	var in, out, saved string
+	if row.BytesIn > 0 {
+		in = humanize.Bytes(row.BytesIn)
+	}
+	if row.BytesOut > 0 {
+		out = humanize.Bytes(row.BytesOut)
+		if row.BytesIn > 0 {
+			saved = fmt.Sprintf("%.0f%%", 100*(1-float64(row.BytesOut)/float64(row.BytesIn)))
+		}
+	}
+	return []string{row.Path, row.Status, row.Output, in, out, saved, row.Error}
+}
+
+// markdownRenderer writes a Markdown table of summaryColumns.
+type markdownRenderer struct{}
+
+func (m markdownRenderer) Begin(w io.Writer) error {
+	sep := make([]string, len(summaryColumns))
+	for i := range sep {
+		sep[i] = "---"
+	}
+	if err := m.line(w, summaryColumns); err != nil {
+		return err
+	}
+	return m.line(w, sep)
+}
+
+func (m markdownRenderer) Row(w io.Writer, row ReportRow) error {
+	cells := row.summaryCells()
+	for i, c := range cells {
+		// Pipes would end the cell, and a line break the row.
+		c = strings.ReplaceAll(c, `|`, `\|`)
+		cells[i] = strings.Join(strings.Fields(c), " ")
+	}
+	return m.line(w, cells)
+}
+
+func (markdownRenderer) End(io.Writer) error { return nil }
+
+func (markdownRenderer) Appendable() bool { return true }
+
+func (markdownRenderer) line(w io.Writer, cells []string) error {
+	_, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+	return err
+}
+
+// htmlRenderer writes a page with a table of summaryColumns, failed files
+// in red.  The page ends after the last row, so runs cannot append to it.
+type htmlRenderer struct{}
+
+const htmlReportHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ImageSlim report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 0.6em; border-bottom: 1px solid #ddd; text-align: left; }
+tr.failed td { color: #b00020; }
+</style>
+</head>
+<body>
+<table>
+`
+
+func (htmlRenderer) Begin(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(htmlReportHead)
+	b.WriteString("<tr>")
+	for _, c := range summaryColumns {
+		b.WriteString("<th>" + html.EscapeString(c) + "</th>")
+	}
+	b.WriteString("</tr>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (htmlRenderer) Row(w io.Writer, row ReportRow) error {
+	var b strings.Builder
+	b.WriteString(`<tr class="` + html.EscapeString(row.Status) + `">`)
+	for _, c := range row.summaryCells() {
+		b.WriteString("<td>" + html.EscapeString(c) + "</td>")
+	}
+	b.WriteString("</tr>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (htmlRenderer) End(w io.Writer) error {
+	_, err := io.WriteString(w, "</table>\n</body>\n</html>\n")
+	return err
+}
+
+func (htmlRenderer) Appendable() bool { return false }
+
+// junitRenderer writes a JUnit XML test suite with a test case per file,
+// so that a CI system lists the files a run failed on the way it lists
+// failed tests.  Failed files are failures, with gm's messages as their
+// text; files left alone are skipped.  The suite ends after the last row,
+// so runs cannot append to it.
+type junitRenderer struct{}
+
+// junitCase is a file's <testcase> element.
+type junitCase struct {
+	XMLName   xml.Name      `xml:"testcase"`
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitMessage is a <failure> or <skipped> element.
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (junitRenderer) Begin(w io.Writer) error {
+	_, err := io.WriteString(w, xml.Header+`<testsuites>`+"\n"+`<testsuite name="imageslim">`+"\n")
+	return err
+}
+
+func (junitRenderer) Row(w io.Writer, row ReportRow) error {
+	ms := row.DecodeMS + row.ResizeMS + row.EncodeMS + row.WriteMS
+	c := junitCase{
+		ClassName: row.Dir,
+		Name:      row.Path,
+		Time:      fmt.Sprintf("%.3f", float64(ms)/1000),
+	}
+	switch row.Status {
+	case ReportConverted:
+		c.SystemOut = strings.Join(row.Messages, "\n")
+	case ReportFailed:
+		c.Failure = &junitMessage{Message: row.Error, Text: strings.Join(row.Messages, "\n")}
+	default:
+		c.Skipped = &junitMessage{Message: row.Status}
+	}
+	out, err := xml.MarshalIndent(c, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(out, '\n'))
+	return err
+}
+
+func (junitRenderer) End(w io.Writer) error {
+	_, err := io.WriteString(w, "</testsuite>\n</testsuites>\n")
+	return err
+}
+
+func (junitRenderer) Appendable() bool { return false }
@@ -0,0 +1,211 @@
+package gm
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Per-file report
+// ---------------------------------------------------------------------------
+
+// Statuses of a ReportRow.
+const (
+	ReportConverted = "converted"
+	ReportFailed    = "failed"
+	ReportDone      = "already-processed" // converted by an earlier run
+	ReportSmall     = "below-minimum"     // see Options.MinFileSize
+	ReportAnimated  = "animated-gif"      // see Options.AnimatedGIF
+	ReportExists    = "output-exists"     // see Options.OnConflict
+)
+
+// ReportRow is one row of the report written to Options.Report: what
+// happened to one file.  Sizes are in bytes; dimensions are in pixels and
+// left zero for formats whose header is not read directly (see headerSize).
+type ReportRow struct {
+	Run       time.Time `json:"run"` // when the run started; tells runs apart
+	Dir       string    `json:"dir"`
+	Path      string    `json:"path"` // relative to Dir
+	Status    string    `json:"status"`
+	Output    string    `json:"output,omitempty"` // relative to Dir
+	BytesIn   int64     `json:"bytes_in,omitempty"`
+	BytesOut  int64     `json:"bytes_out,omitempty"`
+	WidthIn   int       `json:"width_in,omitempty"`
+	HeightIn  int       `json:"height_in,omitempty"`
+	WidthOut  int       `json:"width_out,omitempty"`
+	HeightOut int       `json:"height_out,omitempty"`
+	Error     string    `json:"error,omitempty"`
+
+	// Milliseconds spent in each stage of the conversion, for tuning a
+	// slow pipeline: gm's own work counts as resize, separate decoders and
+	// encoders as decode and encode, and backups and moving results into
+	// place as write.
+	DecodeMS int64 `json:"decode_ms,omitempty"`
+	ResizeMS int64 `json:"resize_ms,omitempty"`
+	EncodeMS int64 `json:"encode_ms,omitempty"`
+	WriteMS  int64 `json:"write_ms,omitempty"`
+
+	// Backend is the program that converted the file: BackendGM, or
+	// Options.Fallback when gm failed on it, or BackendExec for an
+	// Options.Exec command.  Lossless runs leave it empty.
+	Backend string `json:"backend,omitempty"`
+
+	// PSNR is how close a converted file is to its lossless reference, in
+	// decibels, when Options.MinPSNR asked for the comparison; MaxPSNR
+	// when they are identical.
+	PSNR float64 `json:"psnr,omitempty"`
+
+	// Quality is the JPEG quality Options.SmartQuality chose for the file.
+	Quality int `json:"quality,omitempty"`
+
+	// Messages are the lines gm and the helper programs printed while
+	// converting the file.  They are left out of CSV, TSV, Markdown and
+	// HTML reports.
+	Messages []string `json:"messages,omitempty"`
+}
+
+// BackendGM is the ReportRow.Backend of files gm converted.
+const BackendGM = "gm"
+
+// reportColumns heads a CSV or TSV report, in the order of record.
+var reportColumns = []string{
+	"run", "dir", "path", "status", "output", "bytes_in", "bytes_out",
+	"width_in", "height_in", "width_out", "height_out", "error",
+	"decode_ms", "resize_ms", "encode_ms", "write_ms", "backend", "psnr", "quality",
+}
+
+// record returns row as CSV fields.  Zero numbers are unknown and left
+// empty, like the JSON fields they mirror.
+func (row ReportRow) record() []string {
+	num := func(n int64) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	psnr := ""
+	if row.PSNR > 0 {
+		psnr = FormatPSNR(row.PSNR)
+	}
+	return []string{
+		row.Run.Format(time.RFC3339), row.Dir, row.Path, row.Status, row.Output,
+		num(row.BytesIn), num(row.BytesOut),
+		num(int64(row.WidthIn)), num(int64(row.HeightIn)),
+		num(int64(row.WidthOut)), num(int64(row.HeightOut)),
+		row.Error,
+		num(row.DecodeMS), num(row.ResizeMS), num(row.EncodeMS), num(row.WriteMS),
+		row.Backend, psnr, num(int64(row.Quality)),
+	}
+}
+
+// report writes rows to a report file with a ReportRenderer.  A nil
+// *report writes nothing.
+type report struct {
+	f    *os.File
+	r    ReportRenderer
+	sync bool // sync every row to disk
+}
+
+// openReport opens opts.Report, or returns nil when no report is wanted.
+// Reports whose format can be appended to are, starting with Begin when the
+// file is empty; others are replaced.  Rows are synced as files finish, so
+// that a run that crashes or loses power still leaves every row up to that
+// point, and the file can be followed with tail -f.
+func openReport(opts Options) (*report, error) {
+	if opts.Report == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Report), 0o755); err != nil {
+		return nil, fmt.Errorf("report: %w", err)
+	}
+	rr := reportRenderer(opts.Report, opts.ReportFormat)
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if rr.Appendable() {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(opts.Report, flag, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("report: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("report: %w", err)
+	}
+	r := &report{f: f, r: rr, sync: true}
+	if fi.Size() == 0 {
+		if err := rr.Begin(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("report: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// ExportReport writes rows, usually Result.Files, to path in format, or in
+// the format path's extension picks when format is "" (see
+// Options.ReportFormat), replacing any earlier file.
+func ExportReport(path, format string, rows []ReportRow) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("report: %w", err)
+	}
+	r := &report{f: f, r: reportRenderer(path, format)}
+	if err := r.r.Begin(f); err != nil {
+		f.Close()
+		return fmt.Errorf("report: %w", err)
+	}
+	for _, row := range rows {
+		if err := r.add(row); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return r.Close()
+}
+
+// add writes row.
+func (r *report) add(row ReportRow) error {
+	if r == nil {
+		return nil
+	}
+	if err := r.r.Row(r.f, row); err != nil {
+		return fmt.Errorf("report: %w", err)
+	}
+	if !r.sync {
+		return nil
+	}
+	return r.f.Sync()
+}
+
+// Close ends the report and closes its file.
+func (r *report) Close() error {
+	if r == nil {
+		return nil
+	}
+	if err := r.r.End(r.f); err != nil {
+		r.f.Close()
+		return fmt.Errorf("report: %w", err)
+	}
+	return r.f.Close()
+}
+
+// headerSize returns the dimensions of a JPEG, PNG or GIF at path from its
+// header, or zeros for other formats and unreadable files.  The report uses
+// it rather than imageSize so that it never starts gm.
+func headerSize(path string) (width, height int) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
@@ -0,0 +1,71 @@
+package gm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Rotation and flips: correcting scans in the same batch
+// ---------------------------------------------------------------------------
+
+// Options.Rotate values: clockwise turns.
+const (
+	RotateRight = "90"
+	Rotate180   = "180"
+	RotateLeft  = "270"
+	// RotateAuto turns landscape images a quarter turn clockwise, so that
+	// pages scanned sideways come out upright; portrait and square images
+	// are left as they are.
+	RotateAuto = "auto"
+)
+
+// ParseRotate converts a job file's rotate value to its Options.Rotate
+// value: RotateRight, Rotate180, RotateLeft (also "-90"), RotateAuto, or ""
+// for "0" and "none".
+func ParseRotate(s string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(s)); v {
+	case "", "0", "none":
+		return "", nil
+	case RotateRight, Rotate180, RotateLeft, RotateAuto:
+		return v, nil
+	case "-90":
+		return RotateLeft, nil
+	}
+	return "", fmt.Errorf("rotate must be %s, %s, %s, %s or none, got %q", RotateRight, Rotate180, RotateLeft, RotateAuto, s)
+}
+
+// rotateArgs returns the gm arguments for opts.Rotate, opts.Flip and
+// opts.Flop.  RotateAuto is -rotate's "90>", which gm only applies to
+// images wider than they are high.
+func rotateArgs(opts Options) []string {
+	var args []string
+	switch opts.Rotate {
+	case "":
+	case RotateAuto:
+		args = append(args, "-rotate", "90>")
+	default:
+		args = append(args, "-rotate", opts.Rotate)
+	}
+	if opts.Flip {
+		args = append(args, "-flip")
+	}
+	if opts.Flop {
+		args = append(args, "-flop")
+	}
+	return args
+}
+
+// turned returns the width and height of a width × height image once
+// opts.Rotate has turned it.
+func turned(opts Options, width, height int) (int, int) {
+	switch opts.Rotate {
+	case RotateRight, RotateLeft:
+		return height, width
+	case RotateAuto:
+		if width > height {
+			return height, width
+		}
+	}
+	return width, height
+}
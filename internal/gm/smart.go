@@ -0,0 +1,236 @@
+package gm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Smart quality: the lowest JPEG quality that still looks like the image
+// ---------------------------------------------------------------------------
+
+// SmartSSIM is the structural similarity to its lossless reference that
+// the quality Options.SmartQuality picks for a JPEG must reach.  1 is
+// identical; below about 0.98 blocks and smeared detail start to show.
+const SmartSSIM = 0.99
+
+// SmartMaxQuality is the highest quality smart quality tries when the job
+// sets none.
+const SmartMaxQuality = 95
+
+// SmartAttempts is how many qualities smart quality tries at most.  The
+// search halves the range each time, so six cover the 56 qualities from
+// DefaultMinQuality to SmartMaxQuality.
+const SmartAttempts = 6
+
+// QualitySmart is what users type for smart quality in the form's quality
+// field.
+const QualitySmart = "smart"
+
+// smartQuality reports whether the quality of out is chosen by its SSIM:
+// only JPEGs' are, as with a target size.
+func smartQuality(opts Options, out string) bool {
+	return opts.SmartQuality && isJPEG(out)
+}
+
+// smartChoice is the quality smart quality chose for the file being
+// converted, and the SSIM it measured for it.
+type smartChoice struct {
+	quality int
+	ssim    float64
+}
+
+// smartKey is the context key of the smartChoice of the file being
+// converted.
+type smartKey struct{}
+
+// withSmart returns ctx carrying c, which encodeSmart fills in.
+func withSmart(ctx context.Context, c *smartChoice) context.Context {
+	return context.WithValue(ctx, smartKey{}, c)
+}
+
+// refKey is the context key of the lossless reference of the file being
+// converted (see psnrReference), relative to Options.Dir.
+type refKey struct{}
+
+// withReference returns ctx carrying ref, which encodeSmart compares its
+// attempts with.
+func withReference(ctx context.Context, ref string) context.Context {
+	return context.WithValue(ctx, refKey{}, ref)
+}
+
+// encodeSmart encodes in like encode, at the lowest quality from the floor
+// (see Options.MinQuality) up to opts.Quality whose SSIM against the
+// reference in ctx reaches SmartSSIM, found in at most SmartAttempts
+// attempts by halving the range.  Simple images, such as skies and
+// screenshots, thus get smaller files, and detailed ones keep their
+// quality.  The attempts leave the watermark out, so that only encoding
+// is measured; the file is encoded again with it, or when the last
+// attempt was not the quality chosen.  Without a reference, or when an
+// attempt cannot be measured, the file is encoded at opts.Quality with a
+// note in log.
+func encodeSmart(ctx context.Context, bin string, opts Options, rel, in, out string, log io.Writer) error {
+	ref, _ := ctx.Value(refKey{}).(string)
+	if ref == "" {
+		fmt.Fprintf(log, "note: %s: quality %d: no lossless reference to compare with\n", rel, opts.Quality)
+		return encode(ctx, bin, opts, rel, in, out, log)
+	}
+
+	o, dst := opts, out
+	o.Watermark = Watermark{}
+	if opts.Overwrite {
+		// mogrify would replace the original on the first attempt.
+		o.Overwrite = false
+		dst = filepath.Join(filepath.Dir(rel), partialPrefix+filepath.Base(rel))
+		defer os.Remove(filepath.Join(opts.Dir, dst))
+	}
+
+	lo, hi := minQuality(opts), opts.Quality
+	best, bestSSIM, last := opts.Quality, 0.0, 0
+	for i := 0; i < SmartAttempts && lo <= hi; i++ {
+		q := lo + (hi-lo)/2
+		o.Quality = q
+		if err := encode(ctx, bin, o, rel, in, dst, log); err != nil {
+			return err
+		}
+		last = q
+		ssim, err := compareSSIM(ctx, bin, filepath.Join(opts.Dir, ref), filepath.Join(opts.Dir, dst))
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			fmt.Fprintf(log, "note: %s: quality %d: SSIM not measured: %v\n", rel, opts.Quality, err)
+			best, bestSSIM = opts.Quality, 0
+			break
+		}
+		if ssim >= SmartSSIM {
+			best, bestSSIM, hi = q, ssim, q-1
+		} else {
+			lo = q + 1
+		}
+	}
+	if bestSSIM > 0 {
+		fmt.Fprintf(log, "%s: quality %d, SSIM %.4f\n", rel, best, bestSSIM)
+		if c, ok := ctx.Value(smartKey{}).(*smartChoice); ok {
+			c.quality, c.ssim = best, bestSSIM
+		}
+	} else if last != 0 {
+		fmt.Fprintf(log, "%s: quality %d, the highest allowed (SSIM below %.2f)\n", rel, best, SmartSSIM)
+		if c, ok := ctx.Value(smartKey{}).(*smartChoice); ok {
+			c.quality = best
+		}
+	}
+
+	if best != last || opts.Watermark.Enabled() {
+		o := opts
+		o.Quality = best
+		return encode(ctx, bin, o, rel, in, out, log)
+	}
+	if opts.Overwrite {
+		if err := os.Rename(filepath.Join(opts.Dir, dst), filepath.Join(opts.Dir, out)); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// compareSSIM returns the structural similarity of the image at path to
+// the one at ref, from 0 to 1, compared in gray.  GraphicsMagick's compare
+// has no SSIM metric, so both are read as gray PGM images and compared
+// here (see ssim).
+func compareSSIM(ctx context.Context, bin, ref, path string) (float64, error) {
+	defer timeStage(ctx, stageDecode, time.Now())
+	a, err := readGray(ctx, bin, ref)
+	if err != nil {
+		return 0, err
+	}
+	b, err := readGray(ctx, bin, path)
+	if err != nil {
+		return 0, err
+	}
+	if a.width != b.width || a.height != b.height {
+		return 0, fmt.Errorf("the output is %d×%d, its reference %d×%d", b.width, b.height, a.width, a.height)
+	}
+	return ssim(a, b), nil
+}
+
+// grayImage is an 8-bit gray image, row by row.
+type grayImage struct {
+	width, height int
+	pix           []byte
+}
+
+// readGray reads the image at path as "gm convert F -colorspace Gray
+// -depth 8 PGM:-" writes it: a binary PGM.
+func readGray(ctx context.Context, bin, path string) (grayImage, error) {
+	cmd := command(ctx, bin, "convert", path, "-colorspace", "Gray", "-depth", "8", "PGM:-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return grayImage{}, fmt.Errorf("%w: %s", err, msg)
+		}
+		return grayImage{}, err
+	}
+	r := bufio.NewReader(bytes.NewReader(out))
+	var magic string
+	var img grayImage
+	var maxval int
+	if _, err := fmt.Fscan(r, &magic, &img.width, &img.height, &maxval); err != nil || magic != "P5" {
+		return grayImage{}, errors.New("gm wrote no PGM image")
+	}
+	if maxval != 255 || img.width < 1 || img.height < 1 {
+		return grayImage{}, fmt.Errorf("gm wrote a %d×%d PGM image with maxval %d", img.width, img.height, maxval)
+	}
+	r.ReadByte() // the whitespace ending the header
+	img.pix = make([]byte, img.width*img.height)
+	if _, err := io.ReadFull(r, img.pix); err != nil {
+		return grayImage{}, errors.New("gm wrote a PGM image cut short")
+	}
+	return img, nil
+}
+
+// ssimBlock is the side of the windows ssim compares.
+const ssimBlock = 8
+
+// ssim returns the mean structural similarity of a and b, which have the
+// same size, over windows of ssimBlock × ssimBlock pixels (smaller at the
+// right and bottom edges), with the constants of Wang et al. for 8-bit
+// images.
+func ssim(a, b grayImage) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	var total float64
+	windows := 0
+	for y0 := 0; y0 < a.height; y0 += ssimBlock {
+		for x0 := 0; x0 < a.width; x0 += ssimBlock {
+			var sa, sb, saa, sbb, sab float64
+			n := 0
+			for y := y0; y < min(y0+ssimBlock, a.height); y++ {
+				for x := x0; x < min(x0+ssimBlock, a.width); x++ {
+					pa, pb := float64(a.pix[y*a.width+x]), float64(b.pix[y*b.width+x])
+					sa, sb = sa+pa, sb+pb
+					saa, sbb, sab = saa+pa*pa, sbb+pb*pb, sab+pa*pb
+					n++
+				}
+			}
+			f := float64(n)
+			ma, mb := sa/f, sb/f
+			va, vb, cov := saa/f-ma*ma, sbb/f-mb*mb, sab/f-ma*mb
+			total += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
@@ -0,0 +1,60 @@
+package gm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+)
+
+// ---------------------------------------------------------------------------
+// Disk space: preserve mode writes a second copy of every image
+// ---------------------------------------------------------------------------
+
+// FailDiskSpace is the category of the error returned when preserve mode
+// would not have room for its output.
+const FailDiskSpace = "disk-space"
+
+// spaceNeeded estimates what preserve mode writes to output/ for files:
+// as much as the originals that have no output yet, since a converted
+// image may come out no smaller.  Files with an output already replace it.
+func spaceNeeded(opts Options, files []string) int64 {
+	var n int64
+	for _, rel := range files {
+		if _, err := os.Stat(filepath.Join(opts.Dir, outputPath(opts, rel))); err == nil {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(opts.Dir, rel)); err == nil {
+			n += fi.Size()
+		}
+	}
+	return n
+}
+
+// checkSpace refuses a preserve-mode run whose output might not fit on
+// the disk holding output/, before anything is converted.  With
+// opts.IgnoreDiskSpace it returns a warning instead.  Overwrite mode
+// replaces the originals and is not checked; neither is a disk whose free
+// space cannot be read.
+func checkSpace(opts Options, files []string) (warning string, err error) {
+	if opts.Overwrite {
+		return "", nil
+	}
+	out := filepath.Join(opts.Dir, OutputDir)
+	free, err := FreeSpace(out)
+	if err != nil {
+		return "", nil
+	}
+	need := spaceNeeded(opts, files)
+	if need <= free {
+		return "", nil
+	}
+	msg := fmt.Sprintf("%s may need up to %s, as much as the originals, but only %s is free on its disk",
+		out, humanize.Bytes(need), humanize.Bytes(free))
+	if opts.IgnoreDiskSpace {
+		return msg + "; going ahead as the space check is off", nil
+	}
+	return "", WithCategory(FailDiskSpace, errors.New(msg))
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package gm
+
+import "errors"
+
+// FreeSpace reports that free space cannot be read here; checkSpace then
+// skips its check.
+func FreeSpace(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package gm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// FreeSpace returns how many bytes an unprivileged user may still write to
+// the file system holding path.  path need not exist yet: the nearest
+// directory above it that does is asked.
+func FreeSpace(path string) (int64, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(path, &st)
+		if err == nil {
+			return int64(st.Bavail) * int64(st.Bsize), nil
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, os.ErrNotExist) || parent == path {
+			return 0, err
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,137 @@
+package gm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// ---------------------------------------------------------------------------
+// sRGB: converting colour profiles for the web
+// ---------------------------------------------------------------------------
+
+// srgbArgs returns the gm arguments that convert an image to sRGB with
+// opts.ConvertToSRGB: -profile converts from the embedded profile (Adobe
+// RGB, a CMYK press profile, …) to the sRGB profile at srgbProfilePath,
+// -colorspace RGB takes CMYK images without a profile along, and
+// +profile icc strips the sRGB profile again, which browsers assume for
+// untagged images anyway.
+func srgbArgs(opts Options) []string {
+	if !opts.ConvertToSRGB {
+		return nil
+	}
+	return []string{"-profile", srgbProfilePath(), "-colorspace", "RGB", "+profile", "icc"}
+}
+
+// srgbProfilePath is where writeSRGBProfile puts the sRGB profile gm
+// converts to: in the user's cache directory, so that the path in the gm
+// arguments, and with it the settings the manifest records, stays the same
+// from run to run.
+func srgbProfilePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "imageslim", "sRGB.icc")
+}
+
+// writeSRGBProfile writes the sRGB profile to srgbProfilePath unless it is
+// already there.
+func writeSRGBProfile() error {
+	path := srgbProfilePath()
+	icc := srgbProfile()
+	if data, err := os.ReadFile(path); err == nil && bytes.Equal(data, icc) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, icc, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// srgbProfile returns an ICC version 2 display profile for sRGB (IEC
+// 61966-2-1): the sRGB primaries adapted to the D50 connection space and
+// the sRGB tone curve, sampled at 1024 points.  It is built here rather
+// than shipped as a file.
+func srgbProfile() []byte {
+	xyz := func(x, y, z float64) []byte {
+		b := append([]byte("XYZ "), 0, 0, 0, 0)
+		for _, v := range []float64{x, y, z} {
+			b = binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+		}
+		return b
+	}
+	text := func(s string) []byte {
+		return append(append([]byte("text\x00\x00\x00\x00"), s...), 0)
+	}
+	desc := func(s string) []byte {
+		b := append([]byte("desc"), 0, 0, 0, 0)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(s)+1))
+		b = append(append(b, s...), 0)
+		b = append(b, make([]byte, 4+4+2+1+67)...) // no Unicode or ScriptCode description
+		return b
+	}
+	const points = 1024
+	curve := append([]byte("curv"), 0, 0, 0, 0)
+	curve = binary.BigEndian.AppendUint32(curve, points)
+	for i := 0; i < points; i++ {
+		v := float64(i) / (points - 1)
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		curve = binary.BigEndian.AppendUint16(curve, uint16(math.Round(v*65535)))
+	}
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc("sRGB IEC61966-2.1")},
+		{"cprt", text("No copyright, use freely")},
+		{"wtpt", xyz(0.9642, 1, 0.8249)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	var data []byte
+	offset := 128 + 4 + 12*len(tags)
+	at := map[string]int{} // the three tone curves share their data
+	for _, t := range tags {
+		pos, ok := at[string(t.data)]
+		if !ok {
+			pos = offset + len(data)
+			at[string(t.data)] = pos
+			data = append(data, t.data...)
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+		}
+		table = append(table, t.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(pos))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(t.data)))
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(offset+len(data)))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // version 2.1
+	copy(header[12:], "mntrRGB XYZ ")
+	for i, v := range []uint16{2026, 1, 1, 0, 0, 0} { // creation date
+		binary.BigEndian.PutUint16(header[24+2*i:], v)
+	}
+	copy(header[36:], "acsp")
+	copy(header[68:], xyz(0.9642, 1, 0.8249)[8:]) // the D50 illuminant
+	return append(append(header, table...), data...)
+}
@@ -0,0 +1,53 @@
+package gm
+
+import (
+	"context"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Stage timings: where each file's conversion time goes, for the report
+// ---------------------------------------------------------------------------
+
+// stage is a step of a file's conversion.  gm decodes, resizes and encodes
+// in one process, so for files gm reads and writes itself all of that is
+// stageResize; decoding and encoding count apart only when another program
+// does them.
+type stage int
+
+const (
+	stageDecode stage = iota // heif-convert, reading the alpha channel, verifying outputs
+	stageResize              // gm: the conversion itself and the watermark
+	stageEncode              // cwebp, avifenc, pngquant, optipng, jpegtran
+	stageWrite               // backups, directories, moving results into place
+	stageCount
+)
+
+// stageClock adds up how long one file spends in each stage.  It belongs
+// to one worker, so needs no lock.
+type stageClock [stageCount]time.Duration
+
+// clockKey is the context key of the stageClock of the file being
+// converted.
+type clockKey struct{}
+
+// withClock returns ctx carrying c, which timeStage adds to.
+func withClock(ctx context.Context, c *stageClock) context.Context {
+	return context.WithValue(ctx, clockKey{}, c)
+}
+
+// timeStage adds the time since start to stage s of the clock in ctx, if
+// any: defer timeStage(ctx, stageEncode, time.Now()).
+func timeStage(ctx context.Context, s stage, start time.Time) {
+	if c, ok := ctx.Value(clockKey{}).(*stageClock); ok {
+		c[s] += time.Since(start)
+	}
+}
+
+// fill copies the stage times to row, in milliseconds.
+func (c *stageClock) fill(row *ReportRow) {
+	row.DecodeMS = c[stageDecode].Milliseconds()
+	row.ResizeMS = c[stageResize].Milliseconds()
+	row.EncodeMS = c[stageEncode].Milliseconds()
+	row.WriteMS = c[stageWrite].Milliseconds()
+}
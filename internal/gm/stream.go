@@ -0,0 +1,39 @@
+package gm
+
+import "sync"
+
+// ---------------------------------------------------------------------------
+// Live output: Options.Output gets what gm prints as it prints it
+// ---------------------------------------------------------------------------
+
+// stream hands the lines the conversions print to Options.Output, one
+// call at a time.  A nil stream hands nothing on.
+type stream struct {
+	mu   sync.Mutex
+	emit func(line string)
+}
+
+// newStream returns the stream for emit, or nil when emit is.
+func newStream(emit func(line string)) *stream {
+	if emit == nil {
+		return nil
+	}
+	return &stream{emit: emit}
+}
+
+// line hands one line on.
+func (s *stream) line(line string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emit(line)
+}
+
+// file announces that rel is being converted and returns the log of its
+// output, which hands every line on to the stream.
+func (s *stream) file(rel string) *fileLog {
+	s.line("converting " + rel)
+	return newFileLog(s, rel)
+}
@@ -0,0 +1,122 @@
+package gm
+
+import "strings"
+
+// ---------------------------------------------------------------------------
+// Remediation suggestions for failed runs
+// ---------------------------------------------------------------------------
+
+// suggestion maps gm error messages to advice.  A rule matches when any of
+// its patterns occurs in the gm output or error text (case-insensitively),
+// or when the error carries the given category.
+type suggestion struct {
+	patterns []string
+	category string
+	text     string
+}
+
+// suggestions is checked in order; more specific rules come first.
+var suggestions = []suggestion{
+	{
+		category: FailCorruptOutput,
+		text:     "gm wrote an image that cannot be read back, so the file was marked failed.  Check the disk for errors and that it did not fill up, then run again; if the same file keeps failing, re-export its original or leave it out of the run.",
+	},
+	{
+		patterns: []string{"no decode delegate", "no encode delegate", "nodecodedelegate", "noencodedelegate", "unknown delegate", "delegate library support not built"},
+		text:     "gm was built without support for this format: install its delegate library (libjpeg, libpng, libwebp…) and reinstall GraphicsMagick, set fallback: magick in the job to have ImageMagick convert what gm cannot, or leave the format out of the file patterns.",
+	},
+	{
+		patterns: []string{"improper image header", "not a jpeg file", "corrupt image", "premature end of", "negative or zero image size", "unexpected end-of-file", "insufficient image data"},
+		text:     "The named file is damaged or is not really an image.  Open it in an image viewer, re-export or remove it, then run again — files already converted are skipped.",
+	},
+	{
+		patterns: []string{"no space left on device", "disk full", "enospc"},
+		text:     "The disk is full.  Free up space or choose a smaller resize; preserve mode needs room for a second copy of every image, overwrite mode does not.",
+	},
+	{
+		patterns: []string{"memory allocation failed", "resource limit", "resourcelimit", "cache resources exhausted"},
+		text:     "gm ran out of memory on a very large image.  Close other programs or raise MAGICK_LIMIT_MEMORY; very large panoramas may need to be resized on their own.",
+	},
+	{
+		category: FailUnreadable,
+		text:     "ImageSlim may not read this folder.  Check its permissions, or those of the folders above it, and that the disk is still mounted.",
+	},
+	{
+		patterns: []string{"permission denied", "operation not permitted", "read-only file system"},
+		category: FailPermission,
+		text:     "ImageSlim may not write here.  Check the folder's permissions and that the disk is not mounted read-only, or use preserve mode with a writable folder.",
+	},
+	{
+		patterns: []string{"unable to open file", "no such file or directory"},
+		category: FailNotFound,
+		text:     "A file disappeared while the run was in progress.  Make sure nothing else is moving or syncing the folder, then run again.",
+	},
+	{
+		patterns: []string{"command not found", "executable file not found", "not look like graphicsmagick"},
+		category: FailNoGM,
+		text:     "GraphicsMagick is not installed or not on PATH.  Install it (brew install graphicsmagick, apt install graphicsmagick) or point -gm-path at the binary.",
+	},
+	{
+		category: FailGMVersion,
+		text:     "GraphicsMagick was upgraded or replaced since the version was pinned.  Point -gm-path (or IMAGESLIM_GM_PATH) at the pinned build, or check that the new version gives the same results and update gm_version; -gm-version any runs once regardless.",
+	},
+	{
+		category: FailBaseline,
+		text:     "The run went through its files, but saved much more or less than usual, or more of them failed.  Check whether gm, its delegate libraries or the job's settings changed; if the new results are expected, run once with -baseline save to make them the baseline.",
+	},
+	{
+		category: FailDiskSpace,
+		text:     "Nothing was converted.  Free up space, write to a bigger disk, or use overwrite mode, which needs no second copy; if the converted images will be much smaller than the originals, set ignore_disk_space: true in the job or pass -ignore-disk-space to run anyway.",
+	},
+	{
+		category: FailTimeout,
+		text:     "The run was stopped before its files were done.  Run again to carry on after the files already converted, or raise the timeout.",
+	},
+	{
+		category: FailOptions,
+		text:     "Fix the setting named above and run again; nothing was processed.",
+	},
+}
+
+// Suggest returns advice for the failure in r, most relevant first, or nil
+// when the run succeeded or the error is not recognised.  For a run that
+// went on past failed files the first of them is advised on.  Rules made
+// for the error's category are all that is advised when there are any;
+// otherwise rules whose patterns occur in the error or in the log, leaving
+// out its warnings, which are about files that did not fail.
+func Suggest(r Result) []string {
+	err := r.Err
+	if err == nil && len(r.Errors) > 0 {
+		err = r.Errors[0].Err
+	}
+	if err == nil {
+		return nil
+	}
+	category := Category(err)
+
+	var out []string
+	for _, s := range suggestions {
+		if s.category != "" && s.category == category {
+			out = append(out, s.text)
+		}
+	}
+	if len(out) > 0 {
+		return out
+	}
+	var b strings.Builder
+	for _, e := range r.Log {
+		if e.Level != LevelWarning {
+			b.WriteString(e.Text + "\n")
+		}
+	}
+	text := strings.ToLower(b.String() + err.Error())
+	for _, s := range suggestions {
+		for _, p := range s.patterns {
+			if strings.Contains(text, p) {
+				out = append(out, s.text)
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package gm
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+)
+
+// ---------------------------------------------------------------------------
+// Target file size
+// ---------------------------------------------------------------------------
+
+// DefaultMinQuality is the lowest quality tried to reach Options.TargetSize
+// when Options.MinQuality is not set.  Below it JPEG artefacts show on most
+// photos.
+const DefaultMinQuality = 40
+
+// TargetQualityStep is how much the quality drops between two attempts to
+// reach Options.TargetSize.
+const TargetQualityStep = 5
+
+// targetsSize reports whether out is encoded to a target size: only JPEGs
+// are, since for other formats the quality is not a size trade-off.
+func targetsSize(opts Options, out string) bool {
+	return opts.TargetSize > 0 && isJPEG(out)
+}
+
+// minQuality returns the quality floor for opts' target size.
+func minQuality(opts Options) int {
+	return min(cmp.Or(opts.MinQuality, DefaultMinQuality), opts.Quality)
+}
+
+// encodeToTarget encodes in like encode, lowering the quality by
+// TargetQualityStep until out is at most opts.TargetSize bytes or the floor
+// (see Options.MinQuality) is reached.  Every attempt starts again from the
+// original, so the image only loses quality once.  In overwrite mode the
+// attempts are written next to the original, which is replaced by the last
+// one at the end.
+func encodeToTarget(ctx context.Context, bin string, opts Options, rel, in, out string, log io.Writer) error {
+	o, dst := opts, out
+	if opts.Overwrite {
+		// mogrify would replace the original on the first attempt.
+		o.Overwrite = false
+		dst = filepath.Join(filepath.Dir(rel), partialPrefix+filepath.Base(rel))
+		defer os.Remove(filepath.Join(opts.Dir, dst))
+	}
+
+	floor := minQuality(opts)
+	for q := opts.Quality; ; q = max(q-TargetQualityStep, floor) {
+		o.Quality = q
+		if err := encode(ctx, bin, o, rel, in, dst, log); err != nil {
+			return err
+		}
+		fi, err := os.Stat(filepath.Join(opts.Dir, dst))
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		if fi.Size() <= opts.TargetSize {
+			fmt.Fprintf(log, "%s: quality %d, %s (target %s)\n", rel, q, humanize.Bytes(fi.Size()), humanize.Bytes(opts.TargetSize))
+			break
+		}
+		if q == floor {
+			fmt.Fprintf(log, "%s: still %s at the minimum quality %d (target %s)\n", rel, humanize.Bytes(fi.Size()), q, humanize.Bytes(opts.TargetSize))
+			break
+		}
+	}
+
+	if opts.Overwrite {
+		if err := os.Rename(filepath.Join(opts.Dir, dst), filepath.Join(opts.Dir, out)); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package gm
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Output name templates
+// ---------------------------------------------------------------------------
+
+// NameVars are the variables an output name template may use:
+//
+//	{name}     original file name without extension ("IMG_0042")
+//	{ext}      original extension without the dot ("jpg")
+//	{width}    width of the converted image in pixels
+//	{height}   height of the converted image in pixels
+//	{date}     modification date of the original (2006-01-02)
+//	{taken}    date the photo was taken, from its EXIF data; the
+//	           modification date when it has none
+//	{hash}     first 8 hex digits of the SHA-256 of the original's content
+//	{quality}  the quality setting
+var NameVars = []string{"name", "ext", "width", "height", "date", "taken", "hash", "quality"}
+
+// templateVarRE matches a {variable} in a name template.
+var templateVarRE = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// partialPrefix marks a converted file that is not in its final place yet:
+// its name depends on the image's dimensions, or it is an attempt at a
+// target size that will replace the original (see encodeToTarget).
+const partialPrefix = ".imageslim-partial-"
+
+// ValidateNameTemplate checks that t is usable as a file name template.
+func ValidateNameTemplate(t string) error {
+	if strings.ContainsAny(t, `/\`) {
+		return fmt.Errorf("name template %q must be a file name, not a path", t)
+	}
+	for _, m := range templateVarRE.FindAllStringSubmatch(t, -1) {
+		if !slices.Contains(NameVars, m[1]) {
+			return fmt.Errorf("name template %q: unknown variable {%s} (use %s)", t, m[1], "{"+strings.Join(NameVars, "}, {")+"}")
+		}
+	}
+	rest := templateVarRE.ReplaceAllString(t, "")
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("name template %q has an unmatched brace", t)
+	}
+	if !strings.Contains(t, "{name}") {
+		return fmt.Errorf("name template %q must contain {name}, or every file would get the same name", t)
+	}
+	if filepath.Ext(templateVarRE.ReplaceAllString(t, "x")) == "" {
+		return fmt.Errorf("name template %q needs an extension, e.g. {name}_web.{ext}", t)
+	}
+	return nil
+}
+
+// needsDimensions reports whether t uses {width} or {height}, which are only
+// known after conversion.
+func needsDimensions(t string) bool {
+	return strings.Contains(t, "{width}") || strings.Contains(t, "{height}")
+}
+
+// expandName fills in the template for rel.  width and height are used for
+// {width} and {height}; {taken} asks bin.
+func expandName(bin string, opts Options, rel string, width, height int) (string, error) {
+	path := filepath.Join(opts.Dir, rel)
+	src, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	var hash, taken string
+	if strings.Contains(opts.NameTemplate, "{hash}") {
+		if hash, err = contentHash(path); err != nil {
+			return "", err
+		}
+	}
+	if strings.Contains(opts.NameTemplate, "{taken}") {
+		if taken, err = dateTaken(bin, path); err != nil {
+			return "", err
+		}
+		taken = cmp.Or(taken, src.ModTime().Format("2006-01-02"))
+	}
+	base := filepath.Base(rel)
+	ext := filepath.Ext(base)
+	return templateVarRE.ReplaceAllStringFunc(opts.NameTemplate, func(v string) string {
+		switch v {
+		case "{name}":
+			return strings.TrimSuffix(base, ext)
+		case "{ext}":
+			return strings.TrimPrefix(ext, ".")
+		case "{width}":
+			return strconv.Itoa(width)
+		case "{height}":
+			return strconv.Itoa(height)
+		case "{date}":
+			return src.ModTime().Format("2006-01-02")
+		case "{taken}":
+			return taken
+		case "{hash}":
+			return hash
+		case "{quality}":
+			return strconv.Itoa(opts.Quality)
+		}
+		return v
+	}), nil
+}
+
+// contentHash returns the first 8 hex digits of the SHA-256 of the file at
+// path, for {hash}.
+func contentHash(path string) (string, error) {
+	sum, err := sha256File(path)
+	if err != nil {
+		return "", err
+	}
+	return sum[:8], nil
+}
+
+// dateTaken asks "gm identify" when the photo at path was taken, for
+// {taken}, and returns it as 2006-01-02, or "" when its EXIF data does not
+// say.
+func dateTaken(bin, path string) (string, error) {
+	out, err := exec.Command(bin, "identify", "-format", "%[EXIF:DateTimeOriginal]\n", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("identify: %w", err)
+	}
+	// EXIF writes dates as "2006:01:02 15:04:05".
+	t, err := time.Parse("2006:01:02 15:04:05", strings.TrimSpace(string(out)))
+	if err != nil {
+		return "", nil
+	}
+	return t.Format("2006-01-02"), nil
+}
+
+// templateOutput returns where rel is converted to when a name template is
+// set, relative to opts.Dir.  When the name depends on the image's
+// dimensions this is a partial file that finishTemplate renames.
+func templateOutput(bin string, opts Options, rel string) (string, error) {
+	name, err := expandName(bin, opts, rel, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	name = withFormat(opts, name)
+	if needsDimensions(opts.NameTemplate) {
+		// Unique per source, since parallel workers may convert a.jpg and
+		// a.png at the same time.
+		name = partialPrefix + filepath.Base(rel) + "." + name
+	}
+	return filepath.Join(OutputDir, filepath.Dir(rel), name), nil
+}
+
+// finishTemplate gives a partial output its final name once gm identify has
+// reported its dimensions, and returns that name relative to opts.Dir.  A
+// file already at that name is dealt with as opts.OnConflict says, which
+// for ConflictSkip means removing the output and returning errOutputExists.
+func finishTemplate(ctx context.Context, bin string, opts Options, rel, partial string) (string, error) {
+	path := filepath.Join(opts.Dir, partial)
+	width, height, err := identifySize(bin, path)
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	name, err := expandName(bin, opts, rel, width, height)
+	if err != nil {
+		return "", err
+	}
+	final, c := resolve(ctx, filepath.Join(filepath.Dir(partial), withFormat(opts, name)))
+	if c == conflictSkipped {
+		os.Remove(path)
+		return final, errOutputExists
+	}
+	if err := os.Rename(path, filepath.Join(opts.Dir, final)); err != nil {
+		return "", err
+	}
+	return final, nil
+}
@@ -0,0 +1,99 @@
+package gm
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// awkwardRunes are what awkwardName builds names from: shell and glob
+// punctuation, spaces, non-ASCII letters, a combining accent and an emoji,
+// all of which every file system ImageSlim runs on accepts.
+var awkwardRunes = []rune(" '{},$;&!#%@()[]+=~`-_.aZ9éß日本語́🙂")
+
+// awkwardName is a file name a shell or a template could trip over.
+type awkwardName string
+
+// Generate returns a name of 1 to 20 awkward runes, sometimes starting with
+// a dash as an option would.
+func (awkwardName) Generate(r *rand.Rand, _ int) reflect.Value {
+	b := make([]rune, 1+r.Intn(20))
+	for i := range b {
+		b[i] = awkwardRunes[r.Intn(len(awkwardRunes))]
+	}
+	if r.Intn(8) == 0 {
+		b[0] = '-'
+	}
+	return reflect.ValueOf(awkwardName(b))
+}
+
+// dirName returns n as a directory name that no scan skips and every file
+// system keeps as given.
+func (n awkwardName) dirName() string {
+	return "d" + string(n) + "d"
+}
+
+// fileName returns n as the name of a JPEG.
+func (n awkwardName) fileName() string {
+	return string(n) + ".jpg"
+}
+
+// templateOutputOK reports whether templateOutput names the output of the
+// file rel, created under dir, inside rel's own output folder, with the
+// file's name and extension kept verbatim for t = "{name}_{quality}.{ext}".
+func templateOutputOK(t *testing.T, dir, rel string) bool {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(rel)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, rel), []byte("original\n"), 0o644); err != nil {
+		t.Skipf("cannot create %q: %v", rel, err)
+	}
+	opts := Options{Dir: dir, Quality: 80, NameTemplate: "{name}_{quality}.{ext}"}
+	out, err := templateOutput("gm", opts, rel)
+	if err != nil {
+		t.Logf("templateOutput(%q): %v", rel, err)
+		return false
+	}
+	base := filepath.Base(rel)
+	ext := filepath.Ext(base)
+	want := filepath.Join(OutputDir, filepath.Dir(rel), strings.TrimSuffix(base, ext)+"_80."+strings.TrimPrefix(ext, "."))
+	if out != want {
+		t.Logf("templateOutput(%q) = %q, want %q", rel, out, want)
+		return false
+	}
+	return true
+}
+
+func TestTemplateAwkwardNames(t *testing.T) {
+	dir := t.TempDir()
+	named := func(d, f awkwardName) bool {
+		return templateOutputOK(t, dir, filepath.Join(d.dirName(), f.fileName()))
+	}
+	if err := quick.Check(named, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func FuzzTemplateName(f *testing.F) {
+	for _, s := range []string{
+		"IMG_0042.jpg", "a{1,2}.jpg", "{name}.jpg", "{ext}", "$(touch x).jpg", "-rf .jpg",
+		"it's.jpg", "été 日本.jpeg", "🙂.png", "no extension", ".hidden", "a.b.c", "{{}}.jpg",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00"+string(filepath.Separator)) ||
+			slices.Contains([]string{OutputDir, ApprovalDir}, name) || strings.HasPrefix(name, ".imageslim") {
+			t.Skip()
+		}
+		if !templateOutputOK(t, t.TempDir(), name) {
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package gm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Tone: grayscale and sepia variants of a folder
+// ---------------------------------------------------------------------------
+
+// Options.Tone values.
+const (
+	// ToneGrayscale converts images to shades of grey.  JPEGs are written
+	// with a single channel, which also makes them smaller.
+	ToneGrayscale = "grayscale"
+	// ToneSepia gives images the brown tint of old photographs.
+	ToneSepia = "sepia"
+)
+
+// sepiaMatrix is the colour matrix -recolor applies for ToneSepia: each
+// row makes one of red, green and blue from the original red, green and
+// blue.
+const sepiaMatrix = "0.393 0.769 0.189 0.349 0.686 0.168 0.272 0.534 0.131"
+
+// ParseTone converts a job file's tone value to its Options.Tone value:
+// ToneGrayscale (also "greyscale", "gray" or "grey"), ToneSepia, or "" for
+// "none".  Matching is case-insensitive.
+func ParseTone(s string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(s)); v {
+	case "", "none":
+		return "", nil
+	case ToneGrayscale, "greyscale", "gray", "grey":
+		return ToneGrayscale, nil
+	case ToneSepia:
+		return ToneSepia, nil
+	}
+	return "", fmt.Errorf("tone must be %s, %s or none, got %q", ToneGrayscale, ToneSepia, s)
+}
+
+// toneArgs returns the gm arguments for opts.Tone.
+func toneArgs(opts Options) []string {
+	switch opts.Tone {
+	case ToneGrayscale:
+		return []string{"-colorspace", "GRAY"}
+	case ToneSepia:
+		return []string{"-recolor", sepiaMatrix}
+	}
+	return nil
+}
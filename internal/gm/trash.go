@@ -0,0 +1,124 @@
+package gm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Trash: each overwrite-mode run's originals, kept so the run can be undone
+// ---------------------------------------------------------------------------
+
+// TrashDir is where overwrite mode keeps the originals it replaced when
+// Options.Trash is set, relative to Options.Dir: a directory per run,
+// named after the time the run started.
+const TrashDir = ".imageslim-trash"
+
+// trashLayout names a run's directory in TrashDir, to the nanosecond so
+// that runs started within the same second get one each.  Names sort in the
+// order the runs started.
+const trashLayout = "2006-01-02T150405.000000000"
+
+// trashParseLayout reads the names trashLayout writes and those of earlier
+// versions, which stopped at the second.
+const trashParseLayout = "2006-01-02T150405"
+
+// trashKey is the context key of the run's directory in TrashDir.
+type trashKey struct{}
+
+// withTrash returns ctx carrying dir, the directory the originals of the
+// run go to.
+func withTrash(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, trashKey{}, dir)
+}
+
+// trashRun returns the directory the run in ctx keeps originals in, or "".
+func trashRun(ctx context.Context) string {
+	dir, _ := ctx.Value(trashKey{}).(string)
+	return dir
+}
+
+// newTrashRun creates the directory in opts.Dir's trash for the run that
+// started at started and returns it.  It is never one another run has
+// already made: should the name be taken, the next nanosecond's is tried.
+func newTrashRun(opts Options, started time.Time) (string, error) {
+	root := filepath.Join(opts.Dir, TrashDir)
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", err
+	}
+	for t := started; ; t = t.Add(time.Nanosecond) {
+		dir := filepath.Join(root, t.Format(trashLayout))
+		if err := os.Mkdir(dir, 0o755); !errors.Is(err, fs.ErrExist) {
+			return dir, err
+		}
+	}
+}
+
+// trashFile copies src, the original of rel, to the mirrored location of
+// rel under dir before it is replaced.
+func trashFile(dir, rel, src string) error {
+	dst := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return copyFile(src, dst)
+}
+
+// Undo puts the originals of the last run of opts.Dir that kept them in
+// the trash (see Options.Trash) back over the files it replaced, removes
+// that run from the trash and returns when it started and the number of
+// files restored.  Undoing again undoes the run before.
+func Undo(opts Options) (time.Time, int, error) {
+	root := filepath.Join(opts.Dir, TrashDir)
+	entries, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return time.Time{}, 0, err
+	}
+	var runs []string
+	for _, e := range entries {
+		if _, err := time.ParseInLocation(trashParseLayout, e.Name(), time.Local); e.IsDir() && err == nil {
+			runs = append(runs, e.Name())
+		}
+	}
+	if len(runs) == 0 {
+		return time.Time{}, 0, fmt.Errorf("nothing to undo: no run in %s kept its originals", opts.Dir)
+	}
+	slices.Sort(runs)
+	last := runs[len(runs)-1]
+	started, _ := time.ParseInLocation(trashParseLayout, last, time.Local)
+	dir := filepath.Join(root, last)
+
+	n := 0
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(opts.Dir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := copyFile(path, dst); err != nil {
+			return fmt.Errorf("undo %s: %w", rel, err)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return started, n, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return started, n, err
+	}
+	os.Remove(root) // only once the last run is undone
+	return started, n, nil
+}
@@ -0,0 +1,109 @@
+package gm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Verification: reading each output back before the file counts as done
+// ---------------------------------------------------------------------------
+
+// Options.Verify values.
+const (
+	// VerifyHeader reads each output's header: a file whose width and
+	// height cannot be read, say one that is empty, fails.
+	VerifyHeader = "header"
+	// VerifyFull decodes each output completely with gm identify, which
+	// also catches files cut short after a good header.
+	VerifyFull = "full"
+)
+
+// FailCorruptOutput is the category of the error returned when an output
+// fails Options.Verify.
+const FailCorruptOutput = "corrupt-output"
+
+// ParseVerify converts a job file's verify value to its Options.Verify
+// value: VerifyHeader, VerifyFull, or "" for no verification.  Matching is
+// case-insensitive; "none" and "off" mean no verification.
+func ParseVerify(s string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(s)); v {
+	case "", "none", "off":
+		return "", nil
+	case VerifyHeader, VerifyFull:
+		return v, nil
+	}
+	return "", fmt.Errorf("verify must be %s, %s or none, got %q", VerifyHeader, VerifyFull, s)
+}
+
+// verifyOutput reads back the output convertFile wrote for rel to out, as
+// opts.Verify says.  An output that fails is not left to pass for a good
+// one: preserve mode removes it, and overwrite mode puts the original back
+// from the backup when there is one.
+func verifyOutput(ctx context.Context, bin string, opts Options, rel, out string) error {
+	if opts.Verify == "" {
+		return nil
+	}
+	defer timeStage(ctx, stageDecode, time.Now())
+	dst := filepath.Join(opts.Dir, out)
+	err := readBack(ctx, bin, opts.Verify, dst)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	undo := "removed it"
+	switch {
+	case !opts.Overwrite:
+		os.Remove(dst)
+	case trashRun(ctx) != "":
+		if rerr := copyFile(filepath.Join(trashRun(ctx), rel), dst); rerr != nil {
+			undo = fmt.Sprintf("could not restore the original: %v", rerr)
+		} else {
+			undo = "restored the original from the trash"
+		}
+	case opts.Backup:
+		if rerr := copyFile(filepath.Join(backupRoot(opts), rel), dst); rerr != nil {
+			undo = fmt.Sprintf("could not restore the original: %v", rerr)
+		} else {
+			undo = "restored the original from the backup"
+		}
+	default:
+		undo = "the original is lost, since there is no backup or trash"
+	}
+	return WithCategory(FailCorruptOutput, fmt.Errorf("%s: output failed verification (%w); %s", rel, err, undo))
+}
+
+// readBack reads the image at path as mode says.
+func readBack(ctx context.Context, bin, mode, path string) error {
+	if mode == VerifyHeader {
+		width, height, err := imageSize(bin, path)
+		if err != nil {
+			return err
+		}
+		if width <= 0 || height <= 0 {
+			return fmt.Errorf("size %dx%d", width, height)
+		}
+		return nil
+	}
+	// Without -ping gm reads every pixel.  A file cut short only draws a
+	// warning, so anything gm says counts as a failure.
+	var stderr bytes.Buffer
+	cmd := command(ctx, bin, "identify", "-format", "%w %h\n", path)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	msg := strings.TrimSpace(stderr.String())
+	switch {
+	case err != nil && msg != "":
+		return fmt.Errorf("%w: %s", err, msg)
+	case err != nil:
+		return err
+	case msg != "":
+		return errors.New(msg)
+	}
+	return nil
+}
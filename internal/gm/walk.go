@@ -0,0 +1,264 @@
+package gm
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scan walks opts.Dir and returns the paths of all regular files matching
+// opts.Patterns, plus HEICPatterns with opts.HEIC, relative to opts.Dir.
+// Patterns are matched against the file name case-insensitively, like
+// find's -iname.  When opts.Recursive is false only files directly inside
+// opts.Dir are considered, and with opts.ModifiedAfter or
+// opts.ModifiedBefore set only files modified in that range.  Symbolic
+// links are skipped unless opts.FollowSymlinks says otherwise.  OutputDir
+// is always skipped, so that running preserve mode again does not convert
+// its own output, and so are backup directories, so that backed-up
+// originals are never processed: the current one, DefaultBackupDir, and any
+// an earlier run with another Options.BackupDir left.  TrashDir, Approve's
+// ApprovalDir once marked as its, and partial files left by an interrupted
+// run are skipped too.  Folders and files inside opts.Dir that cannot be
+// read are left out, like find does; scan warns about each.
+// With opts.Listed only opts.Files are looked at.
+func Scan(opts Options) ([]string, error) {
+	return scan(opts, &Result{})
+}
+
+// imageExtensions are the formats ImageSlim converts with the right
+// patterns; other files a scan passes count as Result.Unsupported.
+var imageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif", ".heic", ".heif", ".tif", ".tiff", ".bmp"}
+
+// FailUnreadable is the failure category of a run whose directory cannot
+// be read.  Folders inside it that cannot be read are only warned about.
+const FailUnreadable = "unreadable"
+
+// FollowSymlinks values: what Scan does with symbolic links.
+const (
+	// SymlinksSkip leaves linked files and directories alone.  It is the
+	// default; ParseSymlinks turns it into "".
+	SymlinksSkip = "skip"
+	// SymlinksFollow treats links as the files and directories they point
+	// to, so a file linked from two places is converted twice.  A link to
+	// a directory the walk is already inside is not followed.
+	SymlinksFollow = "follow"
+	// SymlinksOnce follows links like SymlinksFollow, but converts every
+	// file, and walks every directory, only at the first path the scan
+	// finds it under; real paths are walked before linked ones.
+	SymlinksOnce = "once"
+)
+
+// ParseSymlinks converts a job file's follow_symlinks value to its
+// Options.FollowSymlinks value: SymlinksFollow, SymlinksOnce, or "" for
+// SymlinksSkip.  Matching is case-insensitive; "process-target-once" is
+// SymlinksOnce.
+func ParseSymlinks(s string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(s)); v {
+	case "", SymlinksSkip:
+		return "", nil
+	case SymlinksFollow, SymlinksOnce:
+		return v, nil
+	case "process-target-once":
+		return SymlinksOnce, nil
+	}
+	return "", fmt.Errorf("follow symlinks must be %s, %s or %s, got %q", SymlinksSkip, SymlinksFollow, SymlinksOnce, s)
+}
+
+// link is a symbolic link a walk put off until the real files were walked.
+type link struct {
+	path    string
+	parents []fileID // the directories the link is inside
+}
+
+// walker walks a tree for scan, following links as opts.FollowSymlinks
+// says.
+type walker struct {
+	opts  Options
+	skip  map[fileID]bool // the output, backup and approval directories
+	seen  map[fileID]bool // what SymlinksOnce has walked already
+	links []link
+	file  func(path string, info fs.FileInfo) error
+	warn  func(path string, err error) // a folder or file left out as it cannot be read
+}
+
+// walk calls w.file for every regular file under dir: the real ones first,
+// in lexical order like filepath.WalkDir, then those links lead to.
+func (w *walker) walk(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	id := idOf(dir, info)
+	w.seen[id] = true
+	if err := w.dir(dir, []fileID{id}); err != nil {
+		return err
+	}
+	for len(w.links) > 0 {
+		l := w.links[0]
+		w.links = w.links[1:]
+		info, err := os.Stat(l.path)
+		if err != nil {
+			continue // a dangling link
+		}
+		if err := w.entry(l.path, info, l.parents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dir walks the directory at path, which is inside parents.  Only the
+// directory the walk started at must be readable.
+func (w *walker) dir(path string, parents []fileID) error {
+	entries, err := os.ReadDir(path)
+	if err != nil && len(parents) == 1 {
+		return WithCategory(FailUnreadable, fmt.Errorf("cannot read %s: %w", path, err))
+	}
+	if err != nil {
+		w.warn(path, err)
+		return nil
+	}
+	for _, e := range entries {
+		p := filepath.Join(path, e.Name())
+		if e.Type()&fs.ModeSymlink != 0 {
+			if w.opts.FollowSymlinks == SymlinksFollow || w.opts.FollowSymlinks == SymlinksOnce {
+				w.links = append(w.links, link{path: p, parents: parents})
+			}
+			continue
+		}
+		if !e.IsDir() && !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			w.warn(p, err) // e.g. removed since the directory was read
+			continue
+		}
+		if err := w.entry(p, info, parents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry walks the directory or passes on the regular file at path.
+func (w *walker) entry(path string, info fs.FileInfo, parents []fileID) error {
+	id := idOf(path, info)
+	if w.opts.FollowSymlinks == SymlinksOnce {
+		if w.seen[id] {
+			return nil
+		}
+		w.seen[id] = true
+	}
+	switch {
+	case info.IsDir():
+		if !w.opts.Recursive || w.skip[id] || slices.Contains(parents, id) || isBackup(path) {
+			return nil
+		}
+		return w.dir(path, append(slices.Clip(parents), id))
+	case info.Mode().IsRegular():
+		return w.file(path, info)
+	}
+	return nil
+}
+
+// scan is Scan, counting the files it leaves out in res.Excluded,
+// res.Unsupported and res.OutOfRange.
+func scan(opts Options, res *Result) ([]string, error) {
+	patterns := opts.Patterns
+	if len(patterns) == 0 {
+		patterns = []string{"*.jpg"} // safe fallback
+	}
+	if opts.HEIC {
+		patterns = append(slices.Clip(patterns), HEICPatterns...)
+	}
+
+	w := &walker{opts: opts, skip: map[fileID]bool{}, seen: map[fileID]bool{}}
+	for _, dir := range []string{
+		filepath.Join(opts.Dir, OutputDir),
+		backupRoot(opts),
+		filepath.Join(opts.Dir, DefaultBackupDir),
+		filepath.Join(opts.Dir, TrashDir),
+	} {
+		if info, err := os.Stat(dir); err == nil {
+			w.skip[idOf(dir, info)] = true
+		}
+	}
+	if dir := filepath.Join(opts.Dir, ApprovalDir); isApproval(dir) {
+		if info, err := os.Stat(dir); err == nil {
+			w.skip[idOf(dir, info)] = true
+		}
+	}
+
+	w.warn = func(path string, err error) {
+		rel, _ := filepath.Rel(opts.Dir, path)
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err // the path is named already
+		}
+		res.Log = append(res.Log, LogEntry{Time: time.Now(), Stream: LogRun, Level: LevelWarning,
+			Text: fmt.Sprintf("warning: left out %s, which cannot be read: %v", rel, err)})
+	}
+
+	var files []string
+	w.file = func(path string, info fs.FileInfo) error {
+		name := filepath.Base(path)
+		if strings.HasPrefix(name, partialPrefix) {
+			return nil
+		}
+		if !matchAny(patterns, name) {
+			switch {
+			case strings.HasPrefix(name, ".imageslim"): // the manifest or baseline
+			case slices.Contains(imageExtensions, strings.ToLower(filepath.Ext(name))):
+				res.Excluded++
+			default:
+				res.Unsupported++
+			}
+			return nil
+		}
+		if filtersByDate(opts) && !modifiedInRange(opts, info.ModTime()) {
+			res.OutOfRange++
+			return nil
+		}
+		rel, err := filepath.Rel(opts.Dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	}
+	if opts.Listed {
+		for _, rel := range opts.Files {
+			path := filepath.Join(opts.Dir, rel)
+			info, err := os.Stat(path)
+			if err != nil {
+				// Converting it says what became of it.
+				files = append(files, filepath.Clean(rel))
+				continue
+			}
+			if err := w.file(path, info); err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+	err := w.walk(opts.Dir)
+	return files, err
+}
+
+// matchAny reports whether name matches at least one of the glob patterns,
+// ignoring case.
+func matchAny(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(strings.ToLower(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package gm
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestScanAwkwardNames(t *testing.T) {
+	// Every name is kept byte for byte, whatever it holds.
+	scanned := func(d awkwardName, names []awkwardName) bool {
+		dir := t.TempDir()
+		sub := filepath.Join(dir, d.dirName())
+		if err := os.Mkdir(sub, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		seen := map[string]bool{} // names that only differ in case are one file on some systems
+		for _, n := range names {
+			name := n.fileName()
+			if seen[strings.ToLower(name)] {
+				continue
+			}
+			seen[strings.ToLower(name)] = true
+			for _, rel := range []string{name, filepath.Join(d.dirName(), name)} {
+				if err := os.WriteFile(filepath.Join(dir, rel), []byte("original\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, rel)
+			}
+		}
+		got, err := Scan(Options{Dir: dir, Patterns: []string{"*.jpg"}, Recursive: true})
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Logf("Scan found %q, want %q", got, want)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(scanned, &quick.Config{MaxCount: 200}); err != nil {
+		t.Error(err)
+	}
+}
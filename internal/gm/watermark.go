@@ -0,0 +1,108 @@
+package gm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Watermarks
+// ---------------------------------------------------------------------------
+
+// Watermark describes an overlay image, such as a logo, that "gm composite"
+// stamps onto every converted file after it has been resized.
+type Watermark struct {
+	// Image is the overlay file; a PNG with transparency works best.
+	// Relative paths are relative to Options.Dir.  Empty means no watermark.
+	Image string
+
+	// Gravity is the corner or edge the overlay is placed against, as a gm
+	// -gravity name.  Empty means DefaultWatermarkGravity.
+	Gravity string
+
+	// Opacity is the overlay's opacity in percent (1–100).  Zero means fully
+	// opaque.
+	Opacity int
+
+	// Scale resizes the overlay, in percent of its own size.  Zero means
+	// 100, i.e. the overlay is used as it is.
+	Scale int
+}
+
+// DefaultWatermarkGravity puts watermarks in the bottom right corner.
+const DefaultWatermarkGravity = "SouthEast"
+
+// WatermarkMargin is the distance in pixels between the overlay and the
+// image edges it is placed against.
+const WatermarkMargin = 10
+
+// MaxWatermarkScale is the largest Watermark.Scale accepted.
+const MaxWatermarkScale = 1000
+
+// Enabled reports whether an overlay is configured.
+func (w Watermark) Enabled() bool {
+	return w.Image != ""
+}
+
+// path returns the overlay location for a run in dir.
+func (w Watermark) path(dir string) string {
+	if filepath.IsAbs(w.Image) {
+		return w.Image
+	}
+	return filepath.Join(dir, w.Image)
+}
+
+// validate checks the overlay settings and that the overlay file exists.
+func (w Watermark) validate(dir string) error {
+	if !w.Enabled() {
+		return nil
+	}
+	fi, err := os.Stat(w.path(dir))
+	switch {
+	case err != nil:
+		return fmt.Errorf("watermark: %w", err)
+	case !fi.Mode().IsRegular():
+		return fmt.Errorf("watermark: %s is not a file", w.Image)
+	}
+	if w.Gravity != "" && !slices.Contains(Gravities, w.Gravity) {
+		return fmt.Errorf("watermark: position %q is not one of %s", w.Gravity, strings.Join(Gravities, ", "))
+	}
+	if w.Opacity < 0 || w.Opacity > 100 {
+		return fmt.Errorf("watermark: opacity must be between 1 and 100 percent, got %d", w.Opacity)
+	}
+	if w.Scale < 0 || w.Scale > MaxWatermarkScale {
+		return fmt.Errorf("watermark: scale must be between 1 and %d percent, got %d", MaxWatermarkScale, w.Scale)
+	}
+	return nil
+}
+
+// watermarkArgs returns the "gm composite" arguments that stamp the overlay
+// onto out in place:
+//
+//	composite -gravity G -geometry [S%]+M+M [-dissolve O] -quality Q OVERLAY OUT OUT
+//
+// composite re-encodes out, so the quality and interlace settings of the
+// conversion are repeated.
+func watermarkArgs(opts Options, out string) []string {
+	w := opts.Watermark
+	gravity := w.Gravity
+	if gravity == "" {
+		gravity = DefaultWatermarkGravity
+	}
+	geom := fmt.Sprintf("+%d+%d", WatermarkMargin, WatermarkMargin)
+	if w.Scale != 0 && w.Scale != 100 {
+		geom = fmt.Sprintf("%d%%", w.Scale) + geom
+	}
+	args := []string{"composite", "-gravity", gravity, "-geometry", geom}
+	if w.Opacity != 0 && w.Opacity != 100 {
+		args = append(args, "-dissolve", fmt.Sprint(w.Opacity))
+	}
+	args = append(args, "-quality", fmt.Sprint(opts.Quality))
+	if opts.Interlace != "" && isJPEG(out) {
+		args = append(args, "-interlace", opts.Interlace)
+	}
+	return append(args, w.Image, out, out)
+}
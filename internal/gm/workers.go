@@ -0,0 +1,306 @@
+package gm
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/sysload"
+)
+
+// ---------------------------------------------------------------------------
+// Parallel conversion
+// ---------------------------------------------------------------------------
+
+// WorkersAuto selects adaptive concurrency for Options.Workers: the number of
+// gm processes running at once follows the system load during the run.
+const WorkersAuto = -1
+
+// MaxWorkers is the largest fixed worker count accepted.
+const MaxWorkers = 64
+
+// Thresholds of the adaptive controller.  It adds a worker while the CPUs
+// have headroom and the disk keeps up, and removes one as soon as either is
+// saturated, so other programs stay responsive.
+const (
+	adaptInterval = time.Second
+	adaptBusyLow  = 0.70 // add a worker below this CPU usage...
+	adaptIOLow    = 0.10 // ...if I/O wait is also below this
+	adaptBusyHigh = 0.90 // remove one above this CPU usage
+	adaptIOHigh   = 0.25 // or above this I/O wait
+)
+
+// PowerInterval is how often a power-aware run checks the battery and
+// temperature.  Both change slowly, and on macOS each check starts pmset.
+const PowerInterval = 5 * time.Second
+
+// ParseWorkers maps a user-supplied worker setting to its Options.Workers
+// value: "auto" for adaptive concurrency, or a number of gm processes to
+// run at once.  "" means one at a time.
+func ParseWorkers(s string) (int, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "":
+		return 0, nil
+	case "auto", "adaptive":
+		return WorkersAuto, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > MaxWorkers {
+		return 0, fmt.Errorf("workers must be auto or a number from 1 to %d, got %q", MaxWorkers, s)
+	}
+	return n, nil
+}
+
+// parallel reports whether opts may convert more than one file at a time.
+func parallel(opts Options) bool {
+	return opts.Workers == WorkersAuto || opts.Workers > 1
+}
+
+// largestFirst orders files by decreasing size, keeping the scan order
+// among files of the same size.  Workers take the next file from this one
+// queue whenever they finish one, so starting with the big files leaves
+// the small ones to fill the gaps at the end, instead of a single worker
+// still grinding through a huge TIFF while the others sit idle.  Files
+// that cannot be read go last; converting them reports the error.
+func largestFirst(dir string, files []string) []string {
+	sizes := make(map[string]int64, len(files))
+	for _, rel := range files {
+		sizes[rel] = -1
+		if fi, err := os.Stat(filepath.Join(dir, rel)); err == nil {
+			sizes[rel] = fi.Size()
+		}
+	}
+	sorted := slices.Clone(files)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		return cmp.Compare(sizes[b], sizes[a])
+	})
+	return sorted
+}
+
+// byDirectory interleaves files from different directories, round-robin in
+// the order each directory first appears, so that with
+// Options.PerDirectory the next file in the queue can usually start while
+// its predecessors' directories are busy.
+func byDirectory(files []string) []string {
+	var dirs []string
+	groups := map[string][]string{}
+	for _, rel := range files {
+		dir := filepath.Dir(rel)
+		if _, ok := groups[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		groups[dir] = append(groups[dir], rel)
+	}
+	sorted := make([]string, 0, len(files))
+	for len(sorted) < len(files) {
+		for _, dir := range dirs {
+			if g := groups[dir]; len(g) > 0 {
+				sorted = append(sorted, g[0])
+				groups[dir] = g[1:]
+			}
+		}
+	}
+	return sorted
+}
+
+// dirGate limits how many files of the same directory are converted at
+// once.  A limit of 0 lets everything through.
+type dirGate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active map[string]int
+}
+
+func newDirGate(limit int) *dirGate {
+	g := &dirGate{limit: limit, active: map[string]int{}}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// acquire blocks until a slot for the directory of rel is free.
+func (g *dirGate) acquire(rel string) {
+	if g.limit == 0 {
+		return
+	}
+	dir := filepath.Dir(rel)
+	g.mu.Lock()
+	for g.active[dir] >= g.limit {
+		g.cond.Wait()
+	}
+	g.active[dir]++
+	g.mu.Unlock()
+}
+
+// release frees the slot taken for rel.
+func (g *dirGate) release(rel string) {
+	if g.limit == 0 {
+		return
+	}
+	g.mu.Lock()
+	g.active[filepath.Dir(rel)]--
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// maxAdaptiveWorkers is the ceiling of adaptive mode: one gm per CPU.
+func maxAdaptiveWorkers() int {
+	return runtime.NumCPU()
+}
+
+// gate limits how many files are converted at once.  Unlike a buffered
+// channel its limit can change while work is in flight.
+type gate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	capped int // lower limit while throttled for power; 0 means none
+	active int
+}
+
+func newGate(limit int) *gate {
+	g := &gate{limit: max(limit, 1)}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// allowed is the number of workers that may run now.  Callers hold g.mu.
+func (g *gate) allowed() int {
+	if g.capped > 0 {
+		return min(g.limit, g.capped)
+	}
+	return g.limit
+}
+
+// acquire blocks until a worker slot is free.
+func (g *gate) acquire() {
+	g.mu.Lock()
+	for g.active >= g.allowed() {
+		g.cond.Wait()
+	}
+	g.active++
+	g.mu.Unlock()
+}
+
+// release frees a worker slot.
+func (g *gate) release() {
+	g.mu.Lock()
+	g.active--
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// adjust moves the limit by delta within [1, ceiling].  Workers above a
+// lowered limit finish their current file; no new ones start until the
+// active count has dropped below it.  The limit does not grow while the
+// gate is throttled, since the extra workers could not run anyway.
+func (g *gate) adjust(delta, ceiling int) {
+	g.mu.Lock()
+	saturated := g.active >= g.limit
+	switch {
+	case delta > 0 && saturated && g.capped == 0 && g.limit < ceiling:
+		g.limit++
+	case delta < 0 && g.limit > 1:
+		g.limit--
+	}
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// throttle caps the gate at n workers, or lifts the cap when n is 0.
+func (g *gate) throttle(n int) {
+	g.mu.Lock()
+	g.capped = n
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// workerGate returns the gate for opts and a function that stops the
+// controllers started for it: the adaptive one for WorkersAuto and the
+// power watcher for opts.PowerAware.
+func workerGate(opts Options) (*gate, func()) {
+	done := make(chan struct{})
+	stop := func() { close(done) }
+
+	var g *gate
+	if opts.Workers == WorkersAuto {
+		g = adaptiveGate(done)
+	} else {
+		g = newGate(opts.Workers)
+	}
+	if opts.PowerAware {
+		checkPower(g) // before the first file starts
+		go watchPower(g, done)
+	}
+	return g, stop
+}
+
+// checkPower throttles g to a single worker while the machine runs on its
+// battery or is hot, and lifts the throttle otherwise.  Without power
+// figures g is left as it is.
+func checkPower(g *gate) {
+	p, err := sysload.ReadPower()
+	if err != nil {
+		return
+	}
+	if p.Throttle() {
+		g.throttle(1)
+	} else {
+		g.throttle(0)
+	}
+}
+
+// watchPower repeats checkPower every PowerInterval until done is closed.
+func watchPower(g *gate, done <-chan struct{}) {
+	t := time.NewTicker(PowerInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			checkPower(g)
+		}
+	}
+}
+
+// adaptiveGate returns a gate for WorkersAuto that starts with one worker
+// and follows the system load until done is closed.
+func adaptiveGate(done <-chan struct{}) *gate {
+	ceiling := maxAdaptiveWorkers()
+	var s sysload.Sampler
+	if _, err := s.Read(); err != nil {
+		// No load figures: settle for half the CPUs, which leaves room
+		// for everything else.
+		return newGate(max(ceiling/2, 1))
+	}
+
+	g := newGate(1)
+	go func() {
+		t := time.NewTicker(adaptInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				r, err := s.Read()
+				switch {
+				case err != nil:
+				case r.Busy > adaptBusyHigh || r.IOWait > adaptIOHigh:
+					g.adjust(-1, ceiling)
+				case r.Busy < adaptBusyLow && r.IOWait < adaptIOLow:
+					g.adjust(+1, ceiling)
+				}
+			}
+		}
+	}()
+	return g
+}
@@ -0,0 +1,177 @@
+// Package history remembers the most recent ImageSlim runs — what was
+// converted, with which options, and how much was saved — so that the TUI
+// can list them and run a past configuration again.
+//
+// Entries are kept in a JSON-lines file in the user's configuration
+// directory.  Unlike usage metrics they include paths, and they never leave
+// the machine.  Setting IMAGESLIM_HISTORY_FILE=off stops recording.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+)
+
+// PathEnv names an alternative history file, or "off" to keep no history.
+const PathEnv = "IMAGESLIM_HISTORY_FILE"
+
+// MaxEntries is how many runs the history keeps; older ones are dropped.
+const MaxEntries = 100
+
+// Entry describes one finished run.
+type Entry struct {
+	Time       time.Time  `json:"time"`
+	Command    string     `json:"command"`        // how ImageSlim was used: tui, plain, run, batch or serve
+	Name       string     `json:"name,omitempty"` // job name, for runs of a job file
+	Options    gm.Options `json:"options"`
+	Duration   int64      `json:"duration_ms"`
+	Processed  int        `json:"processed"`
+	Skipped    int        `json:"skipped"`
+	Small      int        `json:"small,omitempty"`
+	OverTarget int        `json:"over_target,omitempty"`
+	LowPSNR    int        `json:"low_psnr,omitempty"`
+	Animated   int        `json:"animated,omitempty"`
+	BytesIn    int64      `json:"bytes_in"`
+	BytesOut   int64      `json:"bytes_out"`
+	Err        string     `json:"error,omitempty"`
+}
+
+// Failed reports whether the run ended with an error.
+func (e Entry) Failed() bool {
+	return e.Err != ""
+}
+
+// Path returns the history file: $IMAGESLIM_HISTORY_FILE, or history.jsonl in
+// the user's configuration directory.  It returns "" when history is off.
+func Path() (string, error) {
+	switch p := os.Getenv(PathEnv); p {
+	case "off":
+		return "", nil
+	case "":
+	default:
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "imageslim", "history.jsonl"), nil
+}
+
+// compacting serialises the rewrites of Add in this process.
+var compacting sync.Mutex
+
+// Add records e.  It is appended to the file in a single write with
+// O_APPEND, so runs that finish at once, such as the jobs of "serve
+// -parallel" or two imageslim processes, never lose each other's entries.
+// Once the file holds twice MaxEntries entries it is rewritten with the
+// newest MaxEntries; an entry another process appends while that happens
+// may be lost.
+func Add(e Entry) error {
+	p, err := Path()
+	if err != nil || p == "" {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	compacting.Lock()
+	defer compacting.Unlock()
+	entries, err := load(p)
+	if err != nil || len(entries) < 2*MaxEntries {
+		return err
+	}
+	return write(p, entries[len(entries)-MaxEntries:])
+}
+
+// Load returns the recorded runs, oldest first, at most MaxEntries of
+// them.  A missing file is an empty history.
+func Load() ([]Entry, error) {
+	p, err := Path()
+	if err != nil || p == "" {
+		return nil, err
+	}
+	entries, err := load(p)
+	if len(entries) > MaxEntries {
+		entries = entries[len(entries)-MaxEntries:]
+	}
+	return entries, err
+}
+
+// load reads the entries in p.  Lines that cannot be parsed, e.g. one cut
+// short by a crash, are skipped.
+func load(p string) ([]Entry, error) {
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var e Entry
+		if json.Unmarshal(sc.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, sc.Err()
+}
+
+// write replaces p with entries.  The new file is written next to it and
+// renamed into place, so an interrupted write never loses the history.
+func write(p string, entries []Entry) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".history-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
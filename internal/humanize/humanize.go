@@ -0,0 +1,205 @@
+// Package humanize formats byte counts, counts, rates, percentages and
+// durations for people: "1.4 GB", "3,482", "2.4", "81 %", "3 min 12 s".
+//
+// Separators follow the user's locale, taken from LC_ALL, LC_NUMERIC or LANG
+// (in that order), so a German user sees "1,4 GB" and "3.482".  Unit names
+// are SI symbols and therefore the same in every locale.
+package humanize
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Locale holds the number formatting conventions of a locale.
+type Locale struct {
+	Decimal string // decimal separator, e.g. "." or ","
+	Group   string // thousands separator, e.g. "," or "."
+
+	// PercentSpace puts a space between the number and the "%" sign.
+	PercentSpace bool
+}
+
+// Well-known conventions, keyed by language (and region where it matters).
+var (
+	english = Locale{Decimal: ".", Group: ","}
+	german  = Locale{Decimal: ",", Group: ".", PercentSpace: true}
+	french  = Locale{Decimal: ",", Group: " ", PercentSpace: true}
+	swiss   = Locale{Decimal: ".", Group: "’", PercentSpace: true}
+	comma   = Locale{Decimal: ",", Group: "."} // es, it, nl, pt, …
+	spaced  = Locale{Decimal: ",", Group: " "}
+)
+
+var locales = map[string]Locale{
+	"en": english, "ja": english, "zh": english, "ko": english, "he": english, "th": english,
+	"de":    german,
+	"fr":    french,
+	"de_ch": swiss, "fr_ch": swiss, "it_ch": swiss,
+	"es": comma, "it": comma, "nl": comma, "pt": comma, "id": comma, "da": comma, "tr": comma, "el": comma,
+	"ru": spaced, "pl": spaced, "cs": spaced, "sk": spaced, "sv": spaced, "nb": spaced, "fi": spaced,
+	"uk": spaced, "hu": spaced, "bg": spaced, "pt_br": comma,
+}
+
+// current is the locale used by the package-level functions.
+var current = Detect()
+
+// Detect returns the conventions for the locale named in the environment,
+// falling back to English for "C", "POSIX" and unknown locales.
+func Detect() Locale {
+	for _, key := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if v := os.Getenv(key); v != "" {
+			return Lookup(v)
+		}
+	}
+	return english
+}
+
+// Lookup returns the conventions for a POSIX locale name such as
+// "de_DE.UTF-8" or "fr_CH".
+func Lookup(name string) Locale {
+	name = strings.ToLower(name)
+	if i := strings.IndexAny(name, ".@"); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.ReplaceAll(name, "-", "_")
+	if l, ok := locales[name]; ok {
+		return l
+	}
+	lang, _, _ := strings.Cut(name, "_")
+	if l, ok := locales[lang]; ok {
+		return l
+	}
+	return english
+}
+
+// SetLocale overrides the detected locale for the package-level functions.
+func SetLocale(l Locale) {
+	current = l
+}
+
+// Bytes formats n as a size with SI units: "512 B", "1.4 GB", "412 MB".
+func Bytes(n int64) string { return current.Bytes(n) }
+
+// Count formats n with thousands separators: "3,482".
+func Count(n int) string { return current.Count(n) }
+
+// Percent formats a fraction (0.81) as a percentage ("81%").
+func Percent(f float64) string { return current.Percent(f) }
+
+// Duration formats d compactly: "850 ms", "12.4 s", "3 min 12 s", "1 h 04 min".
+func Duration(d time.Duration) string { return current.Duration(d) }
+
+// Number formats a rate or other fractional quantity: "2.4", "312".
+func Number(f float64) string { return current.Number(f) }
+
+// Bytes formats n as a size with SI (1000-based) units.  One decimal is
+// shown below 100 of a unit so small values keep useful precision.
+func (l Locale) Bytes(n int64) string {
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	if n < 1000 {
+		return fmt.Sprintf("%s%d B", sign, n)
+	}
+	units := []string{"kB", "MB", "GB", "TB", "PB", "EB"}
+	v := float64(n)
+	u := -1
+	for v >= 1000 && u < len(units)-1 {
+		v /= 1000
+		u++
+	}
+	// Rounding may carry into the next unit (999.96 kB → 1.0 MB).
+	if math.Round(v*10)/10 >= 1000 && u < len(units)-1 {
+		v /= 1000
+		u++
+	}
+	if v < 100 {
+		return sign + l.decimal(v, 1) + " " + units[u]
+	}
+	return sign + l.decimal(v, 0) + " " + units[u]
+}
+
+// Count formats n with the locale's thousands separator.
+func (l Locale) Count(n int) string {
+	return l.group(fmt.Sprint(n))
+}
+
+// Percent formats a fraction as a percentage without decimals, or with one
+// decimal for values between -10 % and 10 %.
+func (l Locale) Percent(f float64) string {
+	p := f * 100
+	prec := 0
+	if math.Abs(p) < 10 && p != math.Trunc(p) {
+		prec = 1
+	}
+	s := l.decimal(p, prec)
+	if l.PercentSpace {
+		return s + " %"
+	}
+	return s + "%"
+}
+
+// Number formats f with one decimal below 100 and none from there on.
+func (l Locale) Number(f float64) string {
+	if math.Abs(f) < 100 {
+		return l.decimal(f, 1)
+	}
+	return l.decimal(f, 0)
+}
+
+// Duration formats d with at most two units.
+func (l Locale) Duration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return fmt.Sprintf("%d ms", d.Milliseconds())
+	case d < 10*time.Second:
+		return l.decimal(d.Seconds(), 1) + " s"
+	case d < time.Minute:
+		return fmt.Sprintf("%d s", int(d.Round(time.Second).Seconds()))
+	case d < time.Hour:
+		d = d.Round(time.Second)
+		return fmt.Sprintf("%d min %d s", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		d = d.Round(time.Minute)
+		return fmt.Sprintf("%d h %02d min", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
+
+// decimal formats v with prec decimals using the locale's separators.
+func (l Locale) decimal(v float64, prec int) string {
+	s := fmt.Sprintf("%.*f", prec, v)
+	intPart, frac, hasFrac := strings.Cut(s, ".")
+	intPart = l.group(intPart)
+	if hasFrac {
+		return intPart + l.Decimal + frac
+	}
+	return intPart
+}
+
+// group inserts thousands separators into a string of digits with an
+// optional leading minus sign.
+func (l Locale) group(digits string) string {
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if len(digits) <= 3 {
+		return sign + digits
+	}
+	var b strings.Builder
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(l.Group)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return sign + b.String()
+}
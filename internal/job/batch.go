@@ -0,0 +1,127 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+)
+
+// BatchOptions controls how RunBatch schedules jobs.
+type BatchOptions struct {
+	// Parallel is the number of jobs run at the same time; values below 1
+	// mean sequential execution.
+	Parallel int
+
+	// FailFast stops scheduling new jobs once one has failed.  Jobs that are
+	// already running are allowed to finish; the rest are reported as skipped.
+	FailFast bool
+}
+
+// BatchOutcome is the outcome of one job in a batch.  Skipped is true when
+// the job never started because of FailFast or a cancelled batch.
+type BatchOutcome struct {
+	Outcome
+	Skipped bool
+}
+
+// RunBatch runs jobs in order with up to opts.Parallel at a time.  Each
+// job's hook and gm output is buffered and written to log as one block when
+// the job finishes, so parallel jobs never interleave their lines.  The
+// returned slice is in the same order as jobs.  Once ctx is done the
+// running jobs are stopped and no more are started.
+func RunBatch(ctx context.Context, jobs []*Job, opts BatchOptions, log io.Writer) []BatchOutcome {
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]BatchOutcome, len(jobs))
+	sem := make(chan struct{}, parallel)
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex // guards log and failed
+		failed bool
+	)
+
+	for i, j := range jobs {
+		sem <- struct{}{}
+
+		mu.Lock()
+		stop := opts.FailFast && failed || ctx.Err() != nil
+		mu.Unlock()
+		if stop {
+			<-sem
+			results[i] = BatchOutcome{Outcome: Outcome{Job: j}, Skipped: true}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, j *Job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var buf bytes.Buffer
+			o := Run(ctx, j, &buf)
+			buf.WriteString(o.Result.Output())
+			results[i] = BatchOutcome{Outcome: o}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if o.Status() == "failed" {
+				failed = true
+			}
+			fmt.Fprintf(log, "=== %s (%s)\n", j.Label(), o.Status())
+			log.Write(buf.Bytes())
+			if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+				fmt.Fprintln(log)
+			}
+		}(i, j)
+	}
+	wg.Wait()
+	return results
+}
+
+// WriteReport prints an aggregate table of batch outcomes to w and returns
+// the number of failed jobs.
+func WriteReport(w io.Writer, results []BatchOutcome) int {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tSTATUS\tDURATION\tFILES\tBEFORE\tAFTER\tERROR")
+
+	failed := 0
+	var (
+		total   time.Duration
+		in, out int64
+	)
+	for _, r := range results {
+		status := r.Status()
+		errMsg := ""
+		switch {
+		case r.Skipped:
+			status = "skipped"
+		case r.Err != nil:
+			failed++
+			errMsg = r.Err.Error()
+		case r.Result.Failed > 0:
+			failed++
+			errMsg = humanize.Count(r.Result.Failed) + " file(s) failed"
+		}
+		total += r.Duration
+		in += r.Result.BytesIn
+		out += r.Result.BytesOut
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Job.Label(), status,
+			humanize.Duration(r.Duration), humanize.Count(r.Result.Processed),
+			humanize.Bytes(r.Result.BytesIn), humanize.Bytes(r.Result.BytesOut), errMsg)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n%s jobs, %s failed, %s total job time, %s → %s\n",
+		humanize.Count(len(results)), humanize.Count(failed), humanize.Duration(total),
+		humanize.Bytes(in), humanize.Bytes(out))
+	return failed
+}
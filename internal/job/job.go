@@ -0,0 +1,1018 @@
+// Package job defines shareable job files: a complete ImageSlim run stored as
+// YAML so it can be committed next to a project, handed to a teammate and
+// executed unattended with "imageslim run job.yaml".
+//
+// A job file captures everything the TUI form does (directory, resize,
+// quality, output mode, scope) plus the things a scripted workflow needs
+// around it: shell hooks that run before and after processing, and
+// notifications sent once the run has finished.
+//
+//	name: web-export
+//	dir: ./photos            # relative to the job file; ~ and $VARS expand
+//	upload: s3://bucket/web  # where results go when dir is an s3:// prefix
+//	resize: 1200x1200        # or 50%, longedge:1600
+//	resize_mode: fit         # fit | fill | pad
+//	gravity: center          # where fill crops and pad places the image
+//	background: "#f5f5f5"    # what pad fills the canvas with; default white
+//	upscale: true            # enlarge images smaller than the box too
+//	quality: 80
+//	target_size: 300KB       # lower the quality until each JPEG fits...
+//	min_quality: 50          # ...but not below this
+//	smart_quality: true      # or pick each JPEG's quality by how it looks (SSIM)
+//	interlace: line          # progressive JPEGs: line | plane | none
+//	subsampling: "4:4:4"     # JPEG colour resolution: 4:4:4 | 4:2:2 | 4:2:0 | auto
+//	auto_orient: true        # rotate pixels according to EXIF orientation
+//	rotate: auto             # 90 | 180 | 270 clockwise | auto (landscape to portrait)
+//	flip: true               # mirror top to bottom; flop mirrors left to right
+//	sharpen: on              # on | off | unsharp geometry, e.g. 0x1+1+0.05
+//	png_optimize: lossy      # lossless (optipng) | lossy (+pngquant) | off
+//	max_colors: 64           # reduce every image to at most 64 colours...
+//	dither: true             # ...spreading the error to hide banding
+//	sample_size: 20          # files the banding check and approval look at
+//	seed: 42                 # pick another, but repeatable, sample
+//	lossless: false          # only strip metadata and optimise the coding
+//	exec: "cwebp -q 80 {in} -o {out}"   # convert with this command instead of gm
+//	steps:                   # or convert in steps, one command each, in this order
+//	  - auto_orient
+//	  - resize 1600x1600     # the job's resize when left out
+//	  - sharpen 0x1+1+0.05
+//	  - watermark ./logo.png if landscape and width > 1600
+//	  - strip                # drop EXIF data and profiles
+//	  - optimize lossless    # jpegtran or optipng | lossy (+pngquant)
+//	  - exec mycompressor {in} {out}
+//	drop_alpha: true         # drop fully opaque alpha channels
+//	srgb: true               # convert Adobe RGB, CMYK, … to sRGB for the web
+//	tone: sepia              # grayscale | sepia | none
+//	format: webp             # webp | avif | original; preserve mode only
+//	heic: true               # also convert iPhone HEIC/HEIF photos
+//	animated_gif: keep       # keep (resize every frame) | skip
+//	effort: 6                # 1 (fastest) to 10 (smallest files)
+//	name_template: "{name}-{width}x{height}.{ext}"   # preserve mode only
+//	on_conflict: rename      # overwrite | skip | rename (photo-1.jpg)
+//	link_skipped: hardlink   # put files left alone in output/ too: hardlink | reflink | copy | none
+//	watermark:
+//	  image: ./logo.png      # relative to the job file
+//	  position: southeast    # compass direction or center
+//	  opacity: 40            # percent; default 100
+//	  scale: 50              # percent of the logo's size; default 100
+//	mode: preserve           # preserve | overwrite
+//	trash: true              # overwrite mode: keep each run's originals for "imageslim undo"
+//	scope: recursive         # recursive | flat
+//	follow_symlinks: once    # skip | follow | once (each linked file once)
+//	min_file_size: 500KB     # leave smaller files alone
+//	min_dimensions: 2000x    # ...and images narrower than 2000 px
+//	modified_after: 30d      # only files modified in the last 30 days
+//	modified_before: 2026-10-01
+//	workers: auto            # files converted at once: a number or auto
+//	timeout: 2h              # stop the run, killing gm, after this long
+//	per_directory: 1         # ...but only this many from the same folder
+//	power_aware: true        # one at a time on battery or when hot
+//	report: ./report.jsonl   # a JSON line per file, written as it finishes
+//	report_format: junit     # csv | tsv | json | markdown | html | junit; default: by extension
+//	baseline: check          # fail runs whose savings stray from the usual
+//	baseline_tolerance: 10   # ...by more than this many percentage points
+//	gm_version: "1.3.42"     # refuse to run with any other GraphicsMagick
+//	fallback: magick         # retry files gm fails on with ImageMagick
+//	verify: header           # read every output back: header | full | none
+//	min_psnr: 35             # warn about outputs further than this from lossless, in dB
+//	stop_on_error: true      # stop at the first file that fails
+//	hash_cache: true         # skip files whose contents are unchanged, even if touched
+//	hooks:
+//	  before: ["git pull --ff-only"]
+//	  after:  ["rsync -a output/ web:/srv/img/"]
+//	notify:
+//	  webhook: https://hooks.example.com/imageslim
+package job
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+)
+
+// Output mode and scope values as they appear in job files.  They mirror the
+// labels of the TUI selectors so a job file reads like the form it came from.
+const (
+	ModePreserve  = "preserve"
+	ModeOverwrite = "overwrite"
+
+	ScopeRecursive = "recursive"
+	ScopeFlat      = "flat"
+)
+
+// Defaults applied to fields left empty in a job file.  They match the
+// defaults of the TUI form.
+const (
+	DefaultResize  = "1200x1200"
+	DefaultQuality = 80
+)
+
+// Job is a complete, self-contained description of one ImageSlim run.
+type Job struct {
+	// Name is a short human-readable label used in logs and notifications.
+	Name string `yaml:"name,omitempty"`
+
+	// Dir is the base directory containing the images.  It may contain
+	// placeholders: a leading "~/" expands to the home directory and
+	// $VAR / ${VAR} expand from the environment.  Relative paths are
+	// resolved against the directory holding the job file, so a job can be
+	// committed next to the images it processes.
+	//
+	// An S3 prefix such as "s3://bucket/photos" runs on objects instead:
+	// those matching the patterns and scope are downloaded to a temporary
+	// directory, in which the hooks run too, and the results are uploaded
+	// to Upload once the run has succeeded.  The AWS CLI does the
+	// transfers, with credentials from its usual environment variables,
+	// ~/.aws files or instance role.
+	Dir string `yaml:"dir"`
+
+	// Upload is the S3 prefix the results of a job on S3 go to; empty
+	// means the output/ prefix under Dir in preserve mode and Dir itself
+	// in overwrite mode.  See UploadURL.
+	Upload string `yaml:"upload,omitempty"`
+
+	// Patterns are the file globs to match; empty means gm.DefaultPatterns.
+	Patterns []string `yaml:"patterns,omitempty"`
+
+	// Resize is the gm geometry string, e.g. "1200x1200".
+	Resize string `yaml:"resize,omitempty"`
+
+	// ResizeMode is "fit" (default), "fill" (crop to exactly the resize box)
+	// or "pad" (pad to exactly the resize box).  See gm.Options.ResizeMode.
+	ResizeMode string `yaml:"resize_mode,omitempty"`
+
+	// Gravity is where fill crops and pad places the image: center
+	// (default) or a compass direction such as north or southeast.
+	Gravity string `yaml:"gravity,omitempty"`
+
+	// Background is the colour pad fills the canvas with: a name such as
+	// white (default) or gray90, transparent, or hex like "#f5f5f5".  See
+	// gm.Options.Background.
+	Background string `yaml:"background,omitempty"`
+
+	// Upscale enlarges images smaller than the resize box, which by
+	// default are never enlarged.  See gm.Options.Upscale.
+	Upscale bool `yaml:"upscale,omitempty"`
+
+	// Quality is the JPEG quality (1–100).
+	Quality int `yaml:"quality,omitempty"`
+
+	// TargetSize lowers the quality of each JPEG, starting from Quality,
+	// until it is at most this size, e.g. "300KB"; MinQuality is the lowest
+	// quality tried.  See gm.Options.TargetSize.
+	TargetSize string `yaml:"target_size,omitempty"`
+	MinQuality int    `yaml:"min_quality,omitempty"`
+
+	// SmartQuality picks the quality of each JPEG from MinQuality up to
+	// Quality, gm.SmartMaxQuality when not set, by comparing its output
+	// with a lossless rendering.  See gm.Options.SmartQuality.
+	SmartQuality bool `yaml:"smart_quality,omitempty"`
+
+	// Interlace is "line" or "plane" for progressive JPEGs; empty or "none"
+	// writes baseline JPEGs.  See gm.Options.Interlace.
+	Interlace string `yaml:"interlace,omitempty"`
+
+	// Subsampling is the chroma subsampling of JPEG outputs: "4:4:4",
+	// "4:2:2", "4:2:0", or empty or "auto" for gm's default.  See
+	// gm.Options.Subsampling.
+	Subsampling string `yaml:"subsampling,omitempty"`
+
+	// AutoOrient rotates pixels according to the EXIF orientation tag
+	// before resizing.
+	AutoOrient bool `yaml:"auto_orient,omitempty"`
+
+	// Rotate turns every image clockwise by "90", "180" or "270" degrees,
+	// or with "auto" turns landscape images to portrait; Flip and Flop
+	// mirror them.  See gm.Options.Rotate.
+	Rotate string `yaml:"rotate,omitempty"`
+	Flip   bool   `yaml:"flip,omitempty"`
+	Flop   bool   `yaml:"flop,omitempty"`
+
+	// Sharpen applies an unsharp mask after resizing: "on" for
+	// gm.DefaultSharpen, or an explicit geometry.  See gm.Options.Sharpen.
+	Sharpen string `yaml:"sharpen,omitempty"`
+
+	// PNGOptimize runs optipng, and for "lossy" pngquant, over every PNG:
+	// "lossless", "lossy" or "off".  See gm.Options.OptimizePNG.
+	PNGOptimize string `yaml:"png_optimize,omitempty"`
+
+	// MaxColors reduces every image to at most this many colours (2–256),
+	// and Dither hides the banding that causes in gradients.  See
+	// gm.Options.MaxColors.
+	MaxColors int  `yaml:"max_colors,omitempty"`
+	Dither    bool `yaml:"dither,omitempty"`
+
+	// SampleSize is how many files the banding check and approval exports
+	// look at, and Seed shuffles the files they pick from so that another
+	// set comes up, the same one every time.  See gm.Options.SampleSize.
+	SampleSize int   `yaml:"sample_size,omitempty"`
+	Seed       int64 `yaml:"seed,omitempty"`
+
+	// Lossless only strips metadata and optimises the entropy coding of
+	// JPEGs and PNGs, leaving every pixel as it is; resize and quality do
+	// not apply.  See gm.Options.Lossless.
+	Lossless bool `yaml:"lossless,omitempty"`
+
+	// Exec is a shell command that converts each file instead of gm, with
+	// {in} and {out} standing for the file and its output; resize and
+	// quality do not apply.  See gm.Options.Exec.
+	Exec string `yaml:"exec,omitempty"`
+
+	// Steps converts each file in a pipeline of steps, e.g. "auto_orient",
+	// "resize 1600x1600" or "optimize lossy if png", run one after the
+	// other; a step ending in a condition only runs for the files it holds
+	// for.  Watermark images are relative to the job file.  See
+	// gm.Options.Steps.
+	Steps []string `yaml:"steps,omitempty"`
+
+	// DropAlpha removes alpha channels in which every pixel is opaque from
+	// PNGs and WebPs.  See gm.Options.DropAlpha.
+	DropAlpha bool `yaml:"drop_alpha,omitempty"`
+
+	// ConvertToSRGB converts images with another colour profile to sRGB
+	// and strips the profile.  See gm.Options.ConvertToSRGB.
+	ConvertToSRGB bool `yaml:"srgb,omitempty"`
+
+	// Tone is "grayscale", "sepia" or "none" (default): a stylised variant
+	// of every image.  See gm.Options.Tone.
+	Tone string `yaml:"tone,omitempty"`
+
+	// Format converts every file to "webp" or "avif" with cwebp or
+	// avifenc (gm when they are missing); empty or "original" keeps each
+	// file's format.  Effort (1–10) trades encoding time for smaller
+	// files.  See gm.Options.OutputFormat.
+	Format string `yaml:"format,omitempty"`
+	Effort int    `yaml:"effort,omitempty"`
+
+	// HEIC also converts HEIC and HEIF photos, to JPEG unless Format says
+	// otherwise.  See gm.Options.HEIC.
+	HEIC bool `yaml:"heic,omitempty"`
+
+	// AnimatedGIF is "keep" (default) to resize every frame of animated
+	// GIFs or "skip" to leave them alone.  See gm.Options.AnimatedGIF.
+	AnimatedGIF string `yaml:"animated_gif,omitempty"`
+
+	// NameTemplate names the files written to output/, e.g.
+	// "{name}_web.{ext}".  See gm.Options.NameTemplate.
+	NameTemplate string `yaml:"name_template,omitempty"`
+
+	// OnConflict is "overwrite" (default), "skip" or "rename": what
+	// happens when a file is already where an output goes in output/.
+	// See gm.Options.OnConflict.
+	OnConflict string `yaml:"on_conflict,omitempty"`
+
+	// LinkSkipped is "hardlink", "reflink", "copy" or "none" (default):
+	// how the files preserve mode leaves alone are put in output/ so that
+	// it mirrors the whole directory.  See gm.Options.LinkSkipped.
+	LinkSkipped string `yaml:"link_skipped,omitempty"`
+
+	// Watermark stamps an overlay image onto every converted file.
+	Watermark Watermark `yaml:"watermark,omitempty"`
+
+	// Mode is "preserve" (write to output/) or "overwrite" (in-place).
+	Mode string `yaml:"mode,omitempty"`
+
+	// Scope is "recursive" (default) or "flat" (top-level directory only).
+	Scope string `yaml:"scope,omitempty"`
+
+	// FollowSymlinks is "skip" (default), "follow" or "once": whether the
+	// files and directories symbolic links point to are converted, and
+	// whether a file linked from several places is converted only once.
+	// See gm.Options.FollowSymlinks.
+	FollowSymlinks string `yaml:"follow_symlinks,omitempty"`
+
+	// MinFileSize and MinDimensions leave small files alone, e.g. "500KB"
+	// and "2000x" (at least 2000 pixels wide) or "x1000".  See
+	// gm.ParseFileSize and gm.ParseMinDimensions.
+	MinFileSize   string `yaml:"min_file_size,omitempty"`
+	MinDimensions string `yaml:"min_dimensions,omitempty"`
+
+	// ModifiedAfter and ModifiedBefore only process files modified in that
+	// range: a date such as "2026-09-15", or "30d" for 30 days before the
+	// day the job runs.  See gm.ParseDate.
+	ModifiedAfter  string `yaml:"modified_after,omitempty"`
+	ModifiedBefore string `yaml:"modified_before,omitempty"`
+
+	// Workers is how many files are converted at once: a number, or "auto"
+	// to follow the system load.  Empty means one at a time.
+	Workers string `yaml:"workers,omitempty"`
+
+	// PerDirectory caps how many of the workers convert files from the
+	// same directory at once, e.g. 1 for spinning disks.  See
+	// gm.Options.PerDirectory.
+	PerDirectory int `yaml:"per_directory,omitempty"`
+
+	// PowerAware converts one file at a time while a laptop is on battery
+	// or hot.  See gm.Options.PowerAware.
+	PowerAware bool `yaml:"power_aware,omitempty"`
+
+	// Timeout stops the run once it has taken this long, e.g. "90m" or
+	// "2h"; empty means no limit.  See gm.Options.Timeout.
+	Timeout string `yaml:"timeout,omitempty"`
+
+	// Report is a file, relative to the job file, to which a row per file
+	// is appended during the run.  See gm.Options.Report for the formats.
+	Report string `yaml:"report,omitempty"`
+
+	// ReportFormat is the report's format, or empty to go by its
+	// extension.  See gm.ParseReportFormat.
+	ReportFormat string `yaml:"report_format,omitempty"`
+
+	// Baseline is "check" to compare each run's savings and failure rate
+	// with the directory's baseline, or "save" to make this run's the
+	// baseline.  BaselineTolerance is the allowed difference in percentage
+	// points.  See gm.Options.Baseline.
+	Baseline          string `yaml:"baseline,omitempty"`
+	BaselineTolerance int    `yaml:"baseline_tolerance,omitempty"`
+
+	// Force reprocesses files that an earlier run already converted.
+	Force bool `yaml:"force,omitempty"`
+
+	// HashCache keeps the hashes of sources and outputs in the manifest,
+	// so that files touched but not changed are still skipped.  See
+	// gm.Options.HashCache.
+	HashCache bool `yaml:"hash_cache,omitempty"`
+
+	// IgnoreDiskSpace runs in preserve mode even when the disk seems too
+	// full for the output.  See gm.Options.IgnoreDiskSpace.
+	IgnoreDiskSpace bool `yaml:"ignore_disk_space,omitempty"`
+
+	// Backup copies originals aside before overwrite mode modifies them.
+	Backup bool `yaml:"backup,omitempty"`
+
+	// BackupDir overrides where backups go; relative paths are relative to
+	// the base directory.  Defaults to gm.DefaultBackupDir.
+	BackupDir string `yaml:"backup_dir,omitempty"`
+
+	// Trash keeps the originals each overwrite-mode run replaced, so that
+	// "imageslim undo" can put them back.  See gm.Options.Trash.
+	Trash bool `yaml:"trash,omitempty"`
+
+	// Hooks are shell commands run around the batch.
+	Hooks Hooks `yaml:"hooks,omitempty"`
+
+	// Notify configures how the outcome of the run is reported.
+	Notify Notify `yaml:"notify,omitempty"`
+
+	// GMPath overrides the gm binary for this machine (e.g. from the
+	// -gm-path flag).  It is deliberately not part of the file: binary
+	// locations differ between the machines a job is shared with.
+	GMPath string `yaml:"-"`
+
+	// GMVersion pins the GraphicsMagick release the job runs with, e.g.
+	// "1.3.42" or "1.3" for any 1.3.x, so that a teammate's different gm
+	// cannot quietly change the results.  See gm.Options.GMVersion.
+	GMVersion string `yaml:"gm_version,omitempty"`
+
+	// Fallback is "magick" to retry the files gm fails on with ImageMagick
+	// rather than fail the run; empty or "none" for no fallback.  See
+	// gm.Options.Fallback.
+	Fallback string `yaml:"fallback,omitempty"`
+
+	// Verify is "header" or "full" to read every output back once it is
+	// written, failing files whose output cannot be read; empty or "none"
+	// for no check.  See gm.Options.Verify.
+	Verify string `yaml:"verify,omitempty"`
+
+	// MinPSNR compares every output with a lossless rendering of it and
+	// warns about those whose PSNR is lower, e.g. "35" (decibels); empty
+	// or "none" for no comparison.  See gm.Options.MinPSNR.
+	MinPSNR string `yaml:"min_psnr,omitempty"`
+
+	// StopOnError stops the run at the first file that fails, rather than
+	// converting the others.  See gm.Options.StopOnError.
+	StopOnError bool `yaml:"stop_on_error,omitempty"`
+
+	// Files limits a run to some of the matching files, relative to the
+	// base directory, as picked on the TUI's file list.  It is not part of
+	// the file either.  See gm.Options.Files.
+	Files []string `yaml:"-"`
+
+	// Listed converts Files without walking the base directory for them.
+	// See gm.Options.Listed.
+	Listed bool `yaml:"-"`
+
+	// Progress is passed on to gm.Options.Progress; "imageslim serve"
+	// reports it to clients polling a job.
+	Progress func(p gm.Progress) `yaml:"-"`
+
+	// Output is passed on to gm.Options.Output; the TUI shows the lines on
+	// the running screen.
+	Output func(line string) `yaml:"-"`
+
+	// path is the file the job was loaded from; used to resolve relative
+	// directories.  Empty for jobs built in memory.
+	path string
+
+	// literal keeps paths as written; see ParseRemote.
+	literal bool
+}
+
+// Hooks are shell commands executed with "bash -lc" in the job's base
+// directory.  A failing Before hook aborts the run; After hooks only run when
+// processing succeeded, OnError hooks only when it failed, in the job
+// file's directory when an S3 download failed and left no base directory.
+type Hooks struct {
+	Before  []string `yaml:"before,omitempty"`
+	After   []string `yaml:"after,omitempty"`
+	OnError []string `yaml:"on_error,omitempty"`
+}
+
+// IsZero reports whether no hooks are configured (used by yaml omitempty).
+func (h Hooks) IsZero() bool {
+	return len(h.Before) == 0 && len(h.After) == 0 && len(h.OnError) == 0
+}
+
+// Watermark is the job file form of gm.Watermark.
+type Watermark struct {
+	// Image is the overlay file.  Like Dir it may contain ~ and $VARS, and
+	// relative paths are resolved against the directory holding the job
+	// file.
+	Image string `yaml:"image"`
+
+	// Position is where the overlay goes: southeast (default), center or
+	// another compass direction.
+	Position string `yaml:"position,omitempty"`
+
+	// Opacity is in percent, 1–100; empty means fully opaque.
+	Opacity int `yaml:"opacity,omitempty"`
+
+	// Scale resizes the overlay, in percent of its own size; empty means
+	// 100.
+	Scale int `yaml:"scale,omitempty"`
+}
+
+// IsZero reports whether no watermark is configured.
+func (w Watermark) IsZero() bool {
+	return w == Watermark{}
+}
+
+// Notify describes where the result of a run is announced.
+type Notify struct {
+	// Webhook is a URL that receives a JSON summary via HTTP POST.
+	Webhook string `yaml:"webhook,omitempty"`
+
+	// Command is a shell command run after the job finishes, with the
+	// outcome exposed as IMAGESLIM_JOB, IMAGESLIM_STATUS and IMAGESLIM_ERROR
+	// environment variables.
+	Command string `yaml:"command,omitempty"`
+}
+
+// IsZero reports whether no notification is configured.
+func (n Notify) IsZero() bool {
+	return n.Webhook == "" && n.Command == ""
+}
+
+// Destructive lists what j does beyond writing converted copies, which
+// safe mode forbids: overwriting the originals in place, and running the
+// shell commands of hooks, notify and exec.  It is empty for a job safe
+// mode allows.
+func (j *Job) Destructive() []string {
+	var what []string
+	if j.Mode == ModeOverwrite {
+		what = append(what, "overwrite mode")
+	}
+	return append(what, j.Commands()...)
+}
+
+// Commands lists the shell commands j runs: hooks, a notify command, an
+// exec command and exec steps.  It is empty for a job that runs only gm
+// and the optimisers.
+func (j *Job) Commands() []string {
+	var what []string
+	if !j.Hooks.IsZero() {
+		what = append(what, "hooks")
+	}
+	if j.Notify.Command != "" {
+		what = append(what, "a notify command")
+	}
+	if strings.TrimSpace(j.Exec) != "" {
+		what = append(what, "an exec command")
+	}
+	for _, s := range j.Steps {
+		if step, err := gm.ParseStep(s); err != nil || step.Op == gm.StepExec {
+			what = append(what, "exec steps")
+			break
+		}
+	}
+	return what
+}
+
+// Load reads and validates a job file.
+func Load(path string) (*Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	j, err := Parse(data, path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return j, nil
+}
+
+// Parse decodes and validates a job from data, YAML or JSON, as if it had
+// been loaded from path: relative paths in it are resolved against path's
+// directory.  The file need not exist.
+func Parse(data []byte, path string) (*Job, error) {
+	return parse(data, path, false)
+}
+
+// ParseRemote is Parse for a job sent from another machine, as to
+// "imageslim serve": its paths are taken as written, without $VARS or ~
+// expanded, so that the job cannot read the environment of the process
+// running it.
+func ParseRemote(data []byte, path string) (*Job, error) {
+	return parse(data, path, true)
+}
+
+// parse is Parse, keeping paths as written when literal is set.
+func parse(data []byte, path string, literal bool) (*Job, error) {
+	var j Job
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true) // typos in a shared file should fail loudly
+	if err := dec.Decode(&j); err != nil {
+		return nil, err
+	}
+	j.path, j.literal = path, literal
+	if err := j.Validate(); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Save writes the job to path as YAML.  An absolute Dir that lies inside the
+// file's own directory is written relative to it, so the file keeps working
+// when the project is checked out somewhere else.
+func (j *Job) Save(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	base := filepath.Dir(absPath)
+	out := *j
+	out.Dir = relativeTo(base, out.Dir)
+	out.Watermark.Image = relativeTo(base, out.Watermark.Image)
+	out.Steps = stepImages(out.Steps, func(p string) string { return relativeTo(base, p) })
+	data, err := yaml.Marshal(&out)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// stepImages returns steps with the image of each watermark step passed
+// through f, which resolves or relativises it.  Steps that do not parse
+// are left for Validate.
+func stepImages(steps []string, f func(string) string) []string {
+	if steps == nil {
+		return nil
+	}
+	out := make([]string, len(steps))
+	for i, s := range steps {
+		out[i] = s
+		if step, err := gm.ParseStep(s); err == nil && step.Op == gm.StepWatermark && step.Value != "" {
+			step.Value = f(step.Value)
+			out[i] = step.String()
+		}
+	}
+	return out
+}
+
+// relativeTo rewrites an absolute path inside base relative to it; other
+// paths are returned unchanged.
+func relativeTo(base, path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	rel, err := filepath.Rel(base, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
+}
+
+// Path returns the file the job was loaded from, or "" for in-memory jobs.
+func (j *Job) Path() string {
+	return j.path
+}
+
+// Label names the job in logs and reports, falling back to its file path.
+func (j *Job) Label() string {
+	if j.Name != "" {
+		return j.Name
+	}
+	return j.path
+}
+
+// Validate checks the enumerated fields, then everything gm.Options.Validate
+// checks (geometry, quality, patterns) with defaults applied.
+func (j *Job) Validate() error {
+	if strings.TrimSpace(j.Dir) == "" {
+		return fmt.Errorf("dir is required")
+	}
+	switch j.Mode {
+	case "", ModePreserve, ModeOverwrite:
+	default:
+		return fmt.Errorf("mode must be %q or %q, got %q", ModePreserve, ModeOverwrite, j.Mode)
+	}
+	switch j.Scope {
+	case "", ScopeRecursive, ScopeFlat:
+	default:
+		return fmt.Errorf("scope must be %q or %q, got %q", ScopeRecursive, ScopeFlat, j.Scope)
+	}
+	if _, err := gm.ParseSymlinks(j.FollowSymlinks); err != nil {
+		return err
+	}
+	if _, err := gm.ParseResizeMode(j.ResizeMode); err != nil {
+		return err
+	}
+	if _, err := gm.ParseGravity(j.Gravity); err != nil {
+		return err
+	}
+	if _, err := gm.ParseBackground(j.Background); err != nil {
+		return err
+	}
+	if _, err := gm.ParseTone(j.Tone); err != nil {
+		return err
+	}
+	if _, err := gm.ParseInterlace(j.Interlace); err != nil {
+		return err
+	}
+	if _, err := gm.ParseSubsampling(j.Subsampling); err != nil {
+		return err
+	}
+	if _, err := gm.ParseSharpen(j.Sharpen); err != nil {
+		return err
+	}
+	if _, err := gm.ParsePNGOptimize(j.PNGOptimize); err != nil {
+		return err
+	}
+	if _, err := gm.ParseOutputFormat(j.Format); err != nil {
+		return err
+	}
+	if _, err := gm.ParseAnimatedGIF(j.AnimatedGIF); err != nil {
+		return err
+	}
+	if _, err := gm.ParseWorkers(j.Workers); err != nil {
+		return err
+	}
+	if _, err := gm.ParseBaseline(j.Baseline); err != nil {
+		return err
+	}
+	if _, err := gm.ParseTimeout(j.Timeout); err != nil {
+		return err
+	}
+	if _, err := gm.ParseFallback(j.Fallback); err != nil {
+		return err
+	}
+	if _, err := gm.ParseVerify(j.Verify); err != nil {
+		return err
+	}
+	if _, err := gm.ParseMinPSNR(j.MinPSNR); err != nil {
+		return err
+	}
+	if _, err := gm.ParseSteps(j.Steps); err != nil {
+		return err
+	}
+	if _, err := gm.ParseConflict(j.OnConflict); err != nil {
+		return err
+	}
+	if _, err := gm.ParseLinkSkipped(j.LinkSkipped); err != nil {
+		return err
+	}
+	if _, err := gm.ParseRotate(j.Rotate); err != nil {
+		return err
+	}
+	if _, err := gm.ParseReportFormat(j.ReportFormat); err != nil {
+		return err
+	}
+	if j.SampleSize < 0 {
+		return fmt.Errorf("sample_size must be a positive number of files, got %d", j.SampleSize)
+	}
+	if _, err := gm.ParseFileSize(j.TargetSize); err != nil {
+		return fmt.Errorf("target_size: %w", err)
+	}
+	if _, err := gm.ParseFileSize(j.MinFileSize); err != nil {
+		return fmt.Errorf("min_file_size: %w", err)
+	}
+	if _, _, err := gm.ParseMinDimensions(j.MinDimensions); err != nil {
+		return err
+	}
+	if _, err := gm.ParseDate(j.ModifiedAfter, time.Now()); err != nil {
+		return fmt.Errorf("modified_after: %w", err)
+	}
+	if _, err := gm.ParseDate(j.ModifiedBefore, time.Now()); err != nil {
+		return fmt.Errorf("modified_before: %w", err)
+	}
+	if IsS3(j.Dir) && j.Backup {
+		return fmt.Errorf("backup: not available for S3 directories; turn on versioning for the bucket to keep originals")
+	}
+	if IsS3(j.Dir) && j.Trash {
+		return fmt.Errorf("trash: not available for S3 directories; turn on versioning for the bucket to keep originals")
+	}
+	if strings.TrimSpace(j.Upload) != "" {
+		if !IsS3(j.Dir) {
+			return fmt.Errorf("upload: only for jobs whose dir is an s3:// prefix")
+		}
+		if !IsS3(j.Upload) {
+			return fmt.Errorf("upload must be an s3:// prefix, got %q", j.Upload)
+		}
+	}
+	if !j.Watermark.IsZero() {
+		if strings.TrimSpace(j.Watermark.Image) == "" {
+			return fmt.Errorf("watermark: image is required")
+		}
+		if _, err := gm.ParseGravity(j.Watermark.Position); err != nil {
+			return fmt.Errorf("watermark: %w", err)
+		}
+	}
+	return j.Options().Validate()
+}
+
+// ResolveDir expands placeholders in Dir and makes it absolute relative to
+// the job file's directory.  S3 prefixes only have their $VARS expanded.
+func (j *Job) ResolveDir() string {
+	return j.resolve(j.Dir)
+}
+
+// resolve expands placeholders in a path from the job file, unless the job
+// came from ParseRemote, and makes it absolute relative to the job file's
+// directory.
+func (j *Job) resolve(path string) string {
+	path = strings.TrimSpace(path)
+	if !j.literal {
+		path = os.ExpandEnv(path)
+	}
+	if IsS3(path) {
+		return path
+	}
+	if !j.literal && (path == "~" || strings.HasPrefix(path, "~/")) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, strings.TrimPrefix(path[1:], "/"))
+		}
+	}
+	if !filepath.IsAbs(path) && j.path != "" {
+		path = filepath.Join(filepath.Dir(j.path), path)
+	}
+	return path
+}
+
+// Options converts the job into gm.Options, applying defaults for any field
+// left empty.
+func (j *Job) Options() gm.Options {
+	patterns := j.Patterns
+	if len(patterns) == 0 {
+		patterns = gm.DefaultPatterns
+	}
+	resize := strings.TrimSpace(j.Resize)
+	if resize == "" {
+		resize = DefaultResize
+	}
+	quality := j.Quality
+	if quality == 0 {
+		quality = DefaultQuality
+		if j.SmartQuality {
+			quality = gm.SmartMaxQuality
+		}
+	}
+	resizeMode, _ := gm.ParseResizeMode(j.ResizeMode) // checked by Validate
+	gravity, _ := gm.ParseGravity(j.Gravity)
+	background, _ := gm.ParseBackground(j.Background)
+	tone, _ := gm.ParseTone(j.Tone)
+	rotate, _ := gm.ParseRotate(j.Rotate)
+	interlace, _ := gm.ParseInterlace(j.Interlace)
+	subsampling, _ := gm.ParseSubsampling(j.Subsampling)
+	sharpen, _ := gm.ParseSharpen(j.Sharpen)
+	png, _ := gm.ParsePNGOptimize(j.PNGOptimize)
+	fallback, _ := gm.ParseFallback(j.Fallback)
+	symlinks, _ := gm.ParseSymlinks(j.FollowSymlinks)
+	verify, _ := gm.ParseVerify(j.Verify)
+	minPSNR, _ := gm.ParseMinPSNR(j.MinPSNR)
+	steps, _ := gm.ParseSteps(stepImages(j.Steps, j.resolve))
+	conflict, _ := gm.ParseConflict(j.OnConflict)
+	link, _ := gm.ParseLinkSkipped(j.LinkSkipped)
+	reportFormat, _ := gm.ParseReportFormat(j.ReportFormat)
+	format, _ := gm.ParseOutputFormat(j.Format)
+	animated, _ := gm.ParseAnimatedGIF(j.AnimatedGIF)
+	workers, _ := gm.ParseWorkers(j.Workers)
+	baseline, _ := gm.ParseBaseline(j.Baseline)
+	timeout, _ := gm.ParseTimeout(j.Timeout)
+	minSize, _ := gm.ParseFileSize(j.MinFileSize)
+	target, _ := gm.ParseFileSize(j.TargetSize)
+	minWidth, minHeight, _ := gm.ParseMinDimensions(j.MinDimensions)
+	report := ""
+	if strings.TrimSpace(j.Report) != "" {
+		report = j.resolve(j.Report)
+	}
+	now := time.Now()
+	after, _ := gm.ParseDate(j.ModifiedAfter, now)
+	before, _ := gm.ParseDate(j.ModifiedBefore, now)
+	var watermark gm.Watermark
+	if j.Watermark.Image != "" {
+		// ParseGravity maps center to "", which for watermarks means the
+		// bottom right corner.
+		gravity := ""
+		if strings.TrimSpace(j.Watermark.Position) != "" {
+			gravity, _ = gm.ParseGravity(j.Watermark.Position)
+			gravity = cmp.Or(gravity, gm.DefaultGravity)
+		}
+		if gravity == gm.DefaultWatermarkGravity {
+			gravity = ""
+		}
+		watermark = gm.Watermark{
+			Image:   j.resolve(j.Watermark.Image),
+			Gravity: gravity,
+			Opacity: j.Watermark.Opacity,
+			Scale:   j.Watermark.Scale,
+		}
+	}
+	return gm.Options{
+		Dir:               j.ResolveDir(),
+		Patterns:          patterns,
+		Resize:            resize,
+		ResizeMode:        resizeMode,
+		Gravity:           gravity,
+		Background:        background,
+		Upscale:           j.Upscale,
+		Quality:           quality,
+		TargetSize:        target,
+		SmartQuality:      j.SmartQuality,
+		MinQuality:        j.MinQuality,
+		Interlace:         interlace,
+		Subsampling:       subsampling,
+		AutoOrient:        j.AutoOrient,
+		Rotate:            rotate,
+		Flip:              j.Flip,
+		Flop:              j.Flop,
+		Sharpen:           sharpen,
+		OptimizePNG:       png,
+		Lossless:          j.Lossless,
+		Exec:              strings.TrimSpace(j.Exec),
+		Steps:             steps,
+		MaxColors:         j.MaxColors,
+		Dither:            j.Dither,
+		DropAlpha:         j.DropAlpha,
+		ConvertToSRGB:     j.ConvertToSRGB,
+		Tone:              tone,
+		OutputFormat:      format,
+		Effort:            j.Effort,
+		HEIC:              j.HEIC,
+		AnimatedGIF:       animated,
+		Report:            report,
+		ReportFormat:      reportFormat,
+		Baseline:          baseline,
+		BaselineTolerance: j.BaselineTolerance,
+		NameTemplate:      strings.TrimSpace(j.NameTemplate),
+		OnConflict:        conflict,
+		LinkSkipped:       link,
+		Watermark:         watermark,
+		Overwrite:         j.Mode == ModeOverwrite,
+		Recursive:         j.Scope != ScopeFlat,
+		FollowSymlinks:    symlinks,
+		Workers:           workers,
+		PerDirectory:      j.PerDirectory,
+		PowerAware:        j.PowerAware,
+		Timeout:           timeout,
+		MinFileSize:       minSize,
+		MinWidth:          minWidth,
+		MinHeight:         minHeight,
+		ModifiedAfter:     after,
+		ModifiedBefore:    before,
+		Force:             j.Force,
+		HashCache:         j.HashCache,
+		IgnoreDiskSpace:   j.IgnoreDiskSpace,
+		Backup:            j.Backup,
+		BackupDir:         j.BackupDir,
+		Trash:             j.Trash,
+		Files:             j.Files,
+		Listed:            j.Listed,
+		Progress:          j.Progress,
+		Output:            j.Output,
+		GMPath:            j.GMPath,
+		GMVersion:         strings.TrimSpace(j.GMVersion),
+		Fallback:          fallback,
+		Verify:            verify,
+		MinPSNR:           minPSNR,
+		StopOnError:       j.StopOnError,
+		SampleSize:        j.SampleSize,
+		Seed:              j.Seed,
+	}
+}
+
+// FromOptions builds a job from gm.Options, e.g. to export the current TUI
+// form.  Patterns equal to the defaults are omitted to keep the file short.
+func FromOptions(name string, opts gm.Options) *Job {
+	j := &Job{
+		Name:              name,
+		Dir:               opts.Dir,
+		Resize:            opts.Resize,
+		ResizeMode:        opts.ResizeMode,
+		Gravity:           strings.ToLower(opts.Gravity),
+		Background:        opts.Background,
+		Upscale:           opts.Upscale,
+		Quality:           opts.Quality,
+		TargetSize:        gm.FormatFileSize(opts.TargetSize),
+		SmartQuality:      opts.SmartQuality,
+		MinQuality:        opts.MinQuality,
+		Interlace:         strings.ToLower(opts.Interlace),
+		Subsampling:       gm.SubsamplingName(opts.Subsampling),
+		AutoOrient:        opts.AutoOrient,
+		Rotate:            opts.Rotate,
+		Flip:              opts.Flip,
+		Flop:              opts.Flop,
+		Sharpen:           opts.Sharpen,
+		PNGOptimize:       opts.OptimizePNG,
+		Lossless:          opts.Lossless,
+		Exec:              opts.Exec,
+		MaxColors:         opts.MaxColors,
+		Dither:            opts.Dither,
+		DropAlpha:         opts.DropAlpha,
+		ConvertToSRGB:     opts.ConvertToSRGB,
+		Tone:              opts.Tone,
+		Format:            opts.OutputFormat,
+		Effort:            opts.Effort,
+		HEIC:              opts.HEIC,
+		AnimatedGIF:       opts.AnimatedGIF,
+		Report:            opts.Report,
+		ReportFormat:      opts.ReportFormat,
+		Baseline:          opts.Baseline,
+		BaselineTolerance: opts.BaselineTolerance,
+		NameTemplate:      opts.NameTemplate,
+		OnConflict:        opts.OnConflict,
+		LinkSkipped:       opts.LinkSkipped,
+		MinFileSize:       gm.FormatFileSize(opts.MinFileSize),
+		MinDimensions:     gm.FormatMinDimensions(opts.MinWidth, opts.MinHeight),
+		ModifiedAfter:     gm.FormatDate(opts.ModifiedAfter),
+		ModifiedBefore:    gm.FormatDate(opts.ModifiedBefore),
+		Mode:              ModePreserve,
+		Scope:             ScopeRecursive,
+		Force:             opts.Force,
+		HashCache:         opts.HashCache,
+		IgnoreDiskSpace:   opts.IgnoreDiskSpace,
+		Files:             opts.Files,
+		Listed:            opts.Listed,
+		PowerAware:        opts.PowerAware,
+		Timeout:           gm.FormatTimeout(opts.Timeout),
+		GMVersion:         opts.GMVersion,
+		Fallback:          opts.Fallback,
+		Verify:            opts.Verify,
+		MinPSNR:           gm.FormatMinPSNR(opts.MinPSNR),
+		StopOnError:       opts.StopOnError,
+		FollowSymlinks:    opts.FollowSymlinks,
+		SampleSize:        opts.SampleSize,
+		Seed:              opts.Seed,
+	}
+	if opts.Overwrite {
+		j.Backup, j.BackupDir, j.Trash = opts.Backup, opts.BackupDir, opts.Trash
+	}
+	if strings.Join(opts.Patterns, ",") != strings.Join(gm.DefaultPatterns, ",") {
+		j.Patterns = opts.Patterns
+	}
+	if opts.Sharpen == gm.DefaultSharpen {
+		j.Sharpen = "on"
+	}
+	j.Steps = stepImages(gm.FormatSteps(opts.Steps), func(image string) string {
+		if filepath.IsAbs(image) {
+			return image
+		}
+		return filepath.Join(opts.Dir, image)
+	})
+	if w := opts.Watermark; w.Enabled() {
+		image := w.Image
+		if !filepath.IsAbs(image) {
+			image = filepath.Join(opts.Dir, image)
+		}
+		j.Watermark = Watermark{
+			Image:    image,
+			Position: strings.ToLower(w.Gravity),
+			Opacity:  w.Opacity,
+			Scale:    w.Scale,
+		}
+	}
+	if opts.Overwrite {
+		j.Mode = ModeOverwrite
+	}
+	if !opts.Recursive {
+		j.Scope = ScopeFlat
+	}
+	switch {
+	case opts.Workers == gm.WorkersAuto:
+		j.Workers = "auto"
+	case opts.Workers > 1:
+		j.Workers = strconv.Itoa(opts.Workers)
+	}
+	if j.Workers != "" {
+		j.PerDirectory = opts.PerDirectory
+	}
+	return j
+}
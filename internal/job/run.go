@@ -0,0 +1,176 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+)
+
+// Failure categories for errors outside GraphicsMagick, reported by
+// gm.Category next to the gm package's own.
+const (
+	FailHook   = "hook"   // a Before or After hook failed
+	FailNotify = "notify" // the webhook or notify command failed
+	FailS3     = "s3"     // downloading from or uploading to S3 failed
+)
+
+// Outcome is the result of running a job, including its hooks.
+type Outcome struct {
+	// Job is the job that was executed.
+	Job *Job
+
+	// Result is the GraphicsMagick result.  It is zero when a Before hook
+	// or the download from S3 failed and processing never started.
+	Result gm.Result
+
+	// Started and Duration time the whole job, hooks included.
+	Started  time.Time
+	Duration time.Duration
+
+	// Err is the first error encountered: a failing hook, an S3 transfer,
+	// the gm run itself or a failed notification.
+	Err error
+}
+
+// Status returns "ok" or "failed", as reported to notifications.  A run
+// that went on past failed files has failed too.
+func (o Outcome) Status() string {
+	if o.Err != nil || o.Result.Failed > 0 {
+		return "failed"
+	}
+	return "ok"
+}
+
+// Run executes the job: Before hooks, the GraphicsMagick batch, then After or
+// OnError hooks and finally the configured notifications.  Jobs on S3 first
+// download their objects and upload the results before the After hooks
+// run.  Hook and transfer output is written to log as it happens; gm
+// output is returned in Outcome.Result.  Cancelling ctx stops the gm run
+// (see gm.Run); OnError hooks and notifications still follow.
+func Run(ctx context.Context, j *Job, log io.Writer) Outcome {
+	o := Outcome{Job: j, Started: time.Now()}
+	opts := j.Options()
+
+	if IsS3(opts.Dir) {
+		// Downloads are new files, so only hashes tell what an earlier
+		// run converted (see putS3).
+		opts.HashCache = true
+		var dir string
+		if dir, o.Err = fetchS3(opts.Dir, opts, log); o.Err == nil {
+			defer os.RemoveAll(dir)
+		}
+		opts.Dir = dir
+	}
+	if o.Err == nil {
+		o.Err = runHooks("before", j.Hooks.Before, opts.Dir, log)
+	}
+	if o.Err == nil {
+		o.Result = gm.Run(ctx, opts)
+		o.Err = o.Result.Err
+		if o.Err == nil && IsS3(j.ResolveDir()) {
+			o.Err = putS3(opts, j.UploadURL(), log)
+		}
+		if o.Err == nil {
+			o.Err = runHooks("after", j.Hooks.After, opts.Dir, log)
+		}
+	}
+	if o.Err != nil {
+		// OnError hooks are best-effort; the original error is what matters.
+		// Without a download of the S3 objects they run beside the job file.
+		dir := opts.Dir
+		if dir == "" && j.path != "" {
+			dir = filepath.Dir(j.path)
+		}
+		if dir != "" {
+			_ = runHooks("on_error", j.Hooks.OnError, dir, log)
+		} else if len(j.Hooks.OnError) > 0 {
+			fmt.Fprintln(log, "[on_error] skipped: the job has no directory to run them in")
+		}
+	}
+
+	o.Duration = time.Since(o.Started)
+
+	if err := notify(j.Notify, o); err != nil && o.Err == nil {
+		o.Err = gm.WithCategory(FailNotify, err)
+	}
+	return o
+}
+
+// runHooks runs each command with "bash -lc" in dir, stopping at the first
+// failure.  stage names the hook list in log lines and errors.
+func runHooks(stage string, cmds []string, dir string, log io.Writer) error {
+	for _, c := range cmds {
+		fmt.Fprintf(log, "[%s] %s\n", stage, c)
+		cmd := exec.Command("bash", "-lc", c)
+		cmd.Dir = dir
+		cmd.Stdout = log
+		cmd.Stderr = log
+		if err := cmd.Run(); err != nil {
+			return gm.WithCategory(FailHook, fmt.Errorf("%s hook %q: %w", stage, c, err))
+		}
+	}
+	return nil
+}
+
+// notification is the JSON body posted to Notify.Webhook.
+type notification struct {
+	Job      string  `json:"job"`
+	Dir      string  `json:"dir"`
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Started  string  `json:"started"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// notify delivers the outcome to the webhook and/or command configured in n.
+func notify(n Notify, o Outcome) error {
+	errMsg := ""
+	if o.Err != nil {
+		errMsg = o.Err.Error()
+	}
+
+	if n.Webhook != "" {
+		body, err := json.Marshal(notification{
+			Job:      o.Job.Name,
+			Dir:      o.Job.ResolveDir(),
+			Status:   o.Status(),
+			Error:    errMsg,
+			Started:  o.Started.Format(time.RFC3339),
+			Duration: o.Duration.Seconds(),
+		})
+		if err != nil {
+			return err
+		}
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(n.Webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("notify webhook: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("notify webhook: %s", resp.Status)
+		}
+	}
+
+	if n.Command != "" {
+		cmd := exec.Command("bash", "-lc", n.Command)
+		cmd.Env = append(os.Environ(),
+			"IMAGESLIM_JOB="+o.Job.Name,
+			"IMAGESLIM_STATUS="+o.Status(),
+			"IMAGESLIM_ERROR="+errMsg,
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("notify command: %w: %s", err, bytes.TrimSpace(out))
+		}
+	}
+	return nil
+}
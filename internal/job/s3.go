@@ -0,0 +1,136 @@
+package job
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/gm"
+)
+
+// ---------------------------------------------------------------------------
+// S3 directories: a job whose dir is an s3:// prefix downloads the matching
+// objects to a temporary directory, runs there and uploads the results
+// ---------------------------------------------------------------------------
+
+// awsInstall tells users how to get the AWS CLI, which S3 directories need.
+const awsInstall = "brew install awscli / pip install awscli"
+
+// IsS3 reports whether dir is an S3 prefix such as "s3://bucket/photos"
+// rather than a local directory.
+func IsS3(dir string) bool {
+	return strings.HasPrefix(strings.TrimSpace(dir), "s3://")
+}
+
+// s3Prefix returns url with a trailing slash, so that "s3://b/photos" stands
+// for the objects under "photos/" and not also those under "photos2/".
+func s3Prefix(url string) string {
+	return strings.TrimSuffix(strings.TrimSpace(url), "/") + "/"
+}
+
+// UploadURL returns where the results of a job on S3 go: Upload, or else
+// the output/ prefix under Dir in preserve mode and Dir itself in
+// overwrite mode.  It returns "" for jobs on a local directory.
+func (j *Job) UploadURL() string {
+	dir := j.ResolveDir()
+	switch {
+	case !IsS3(dir):
+		return ""
+	case strings.TrimSpace(j.Upload) != "":
+		return s3Prefix(j.resolve(j.Upload))
+	case j.Mode == ModeOverwrite:
+		return s3Prefix(dir)
+	}
+	return s3Prefix(dir) + gm.OutputDir + "/"
+}
+
+// s3Filters returns the "aws s3 cp" filters that pick the objects a run
+// with opts would scan.  aws matches them against keys relative to the
+// prefix, case-sensitively and with * matching slashes too, so every
+// pattern is given in lower and upper case, for the top level and below.
+func s3Filters(opts gm.Options) []string {
+	patterns := opts.Patterns
+	if opts.HEIC {
+		patterns = append(slices.Clip(patterns), gm.HEICPatterns...)
+	}
+	args := []string{"--exclude", "*"}
+	for _, p := range patterns {
+		for _, q := range []string{strings.ToLower(p), strings.ToUpper(p)} {
+			args = append(args, "--include", q, "--include", "*/"+q)
+		}
+	}
+	if !opts.Recursive {
+		args = append(args, "--exclude", "*/*")
+	}
+	if opts.Overwrite {
+		// The manifest of the runs before, uploaded by putS3.
+		args = append(args, "--include", gm.ManifestName)
+	} else {
+		// What an earlier run uploaded is no original to convert again.
+		args = append(args, "--exclude", gm.OutputDir+"/*")
+	}
+	return args
+}
+
+// fetchS3 downloads the objects under the S3 prefix url that a run with
+// opts would pick to a new temporary directory, and returns it.
+func fetchS3(url string, opts gm.Options, log io.Writer) (string, error) {
+	dir, err := os.MkdirTemp("", "imageslim-s3-")
+	if err != nil {
+		return "", err
+	}
+	if err := awsCopy(log, "download", s3Prefix(url), dir, s3Filters(opts)...); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// putS3 uploads the results of a run with opts to the S3 prefix url: the
+// output/ directory in preserve mode, the whole directory in overwrite
+// mode.  ImageSlim's own files stay behind, but for the resume manifest in
+// overwrite mode: fetchS3 downloads it with the objects, so that the next
+// run leaves alone what this one converted rather than encoding it again
+// with every run.  Preserve mode converts the originals each time, which
+// costs uploads but no quality.
+func putS3(opts gm.Options, url string, log io.Writer) error {
+	src := opts.Dir
+	filters := []string{"--exclude", ".imageslim*", "--exclude", "*/.imageslim*"}
+	if opts.Overwrite {
+		filters = append(filters, "--include", gm.ManifestName)
+	} else {
+		src = filepath.Join(src, gm.OutputDir)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			return nil // nothing was converted
+		}
+	}
+	return awsCopy(log, "upload", src, url, filters...)
+}
+
+// awsCopy runs "aws s3 cp --recursive" from src to dst with the given
+// filters, writing what aws reports to log.  stage names the transfer in
+// log lines.  Credentials come from wherever aws finds them: its
+// environment variables, ~/.aws or an instance role.
+func awsCopy(log io.Writer, stage, src, dst string, filters ...string) error {
+	remote := src
+	if IsS3(dst) {
+		remote = dst
+	}
+	fmt.Fprintf(log, "[s3] %s %s\n", stage, remote)
+	aws, err := exec.LookPath("aws")
+	if err != nil {
+		return gm.WithCategory(FailS3, fmt.Errorf("the AWS CLI is not installed (%s)", awsInstall))
+	}
+	args := append([]string{"s3", "cp", src, dst, "--recursive", "--only-show-errors"}, filters...)
+	cmd := exec.Command(aws, args...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	if err := cmd.Run(); err != nil {
+		return gm.WithCategory(FailS3, fmt.Errorf("%s %s: %w", stage, remote, err))
+	}
+	return nil
+}
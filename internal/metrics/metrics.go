@@ -0,0 +1,241 @@
+// Package metrics keeps an opt-in, local-only log of ImageSlim runs so that
+// a person or team can see how they use the tool: how often it runs, how
+// long runs take, which GraphicsMagick builds are in use and why runs fail.
+//
+// Nothing is recorded until the user opts in with "imageslim metrics
+// enable", which creates the metrics file; "imageslim metrics disable"
+// deletes it again.  Records contain counts, sizes, durations and failure
+// categories only — never paths, file names or error messages — and are
+// never sent anywhere.
+package metrics
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brunovpinheiro/ImageSlim/internal/humanize"
+)
+
+// PathEnv names an alternative metrics file, e.g. on a share that a team
+// aggregates from.
+const PathEnv = "IMAGESLIM_METRICS_FILE"
+
+// Record describes one finished run.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Command   string    `json:"command"` // how ImageSlim was used: tui, plain, run, batch or serve
+	Backend   string    `json:"backend"` // e.g. "GraphicsMagick 1.3.42"
+	Duration  int64     `json:"duration_ms"`
+	Processed int       `json:"processed"`
+	Skipped   int       `json:"skipped"`
+	BytesIn   int64     `json:"bytes_in"`
+	BytesOut  int64     `json:"bytes_out"`
+	Status    string    `json:"status"`            // ok or failed
+	Failure   string    `json:"failure,omitempty"` // gm.Category of the error
+}
+
+// Path returns the metrics file: $IMAGESLIM_METRICS_FILE, or metrics.jsonl
+// in the user's configuration directory (~/.config/imageslim on Linux,
+// ~/Library/Application Support/imageslim on macOS).
+func Path() (string, error) {
+	if p := os.Getenv(PathEnv); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "imageslim", "metrics.jsonl"), nil
+}
+
+// Enabled reports whether the user opted in, i.e. the metrics file exists.
+func Enabled() bool {
+	p, err := Path()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+// Enable opts in by creating an empty metrics file.  An existing file is
+// kept.
+func Enable() (string, error) {
+	p, err := Path()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return "", err
+	}
+	return p, f.Close()
+}
+
+// Disable opts out by deleting the metrics file and everything recorded.
+func Disable() (string, error) {
+	p, err := Path()
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	return p, nil
+}
+
+// Add appends r to the metrics file.  It does nothing when metrics are not
+// enabled: the file is never created here.
+func Add(r Record) error {
+	p, err := Path()
+	if err != nil {
+		return nil
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Load reads every record from the metrics file.  Lines that cannot be
+// parsed, e.g. one cut short by a crash, are skipped.
+func Load() ([]Record, error) {
+	p, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("metrics are not enabled (run: imageslim metrics enable)")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var r Record
+		if json.Unmarshal(sc.Bytes(), &r) == nil {
+			records = append(records, r)
+		}
+	}
+	return records, sc.Err()
+}
+
+// Backend shortens the first line of "gm version" to the product and release,
+// e.g. "GraphicsMagick 1.3.42", dropping build dates and URLs.
+func Backend(version string) string {
+	f := strings.Fields(version)
+	if len(f) > 2 {
+		f = f[:2]
+	}
+	return strings.Join(f, " ")
+}
+
+// WriteSummary prints totals for records followed by breakdowns by command,
+// backend and failure category.
+func WriteSummary(w io.Writer, records []Record) {
+	if len(records) == 0 {
+		fmt.Fprintln(w, "No runs recorded yet.")
+		return
+	}
+
+	var (
+		failed         int
+		processed      int
+		total, longest time.Duration
+		in, out        int64
+		first, last    = records[0].Time, records[0].Time
+		byCommand      = map[string]int{}
+		byBackend      = map[string]int{}
+		byFailure      = map[string]int{}
+	)
+	for _, r := range records {
+		d := time.Duration(r.Duration) * time.Millisecond
+		total += d
+		longest = max(longest, d)
+		processed += r.Processed
+		in += r.BytesIn
+		out += r.BytesOut
+		if r.Time.Before(first) {
+			first = r.Time
+		}
+		if r.Time.After(last) {
+			last = r.Time
+		}
+		byCommand[r.Command]++
+		byBackend[cmp.Or(r.Backend, "unknown")]++
+		if r.Status != "ok" {
+			failed++
+			byFailure[cmp.Or(r.Failure, "other")]++
+		}
+	}
+
+	n := len(records)
+	fmt.Fprintf(w, "%s run(s) between %s and %s\n", humanize.Count(n),
+		first.Local().Format("2006-01-02"), last.Local().Format("2006-01-02"))
+	fmt.Fprintf(w, "  succeeded   %s (%s)\n", humanize.Count(n-failed),
+		humanize.Percent(float64(n-failed)/float64(n)))
+	fmt.Fprintf(w, "  duration    %s total, %s average, %s longest\n", humanize.Duration(total),
+		humanize.Duration(total/time.Duration(n)), humanize.Duration(longest))
+	fmt.Fprintf(w, "  files       %s processed, %s → %s\n", humanize.Count(processed),
+		humanize.Bytes(in), humanize.Bytes(out))
+
+	writeCounts(w, "COMMAND", byCommand, n)
+	writeCounts(w, "BACKEND", byBackend, n)
+	if failed > 0 {
+		writeCounts(w, "FAILURE", byFailure, failed)
+	}
+}
+
+// writeCounts prints a two-column table of counts, most frequent first.
+func writeCounts(w io.Writer, title string, counts map[string]int, total int) {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tRUNS\tSHARE\n", title)
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", k, humanize.Count(counts[k]),
+			humanize.Percent(float64(counts[k])/float64(total)))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,143 @@
+package sysload
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Power describes whether a laptop should be spared: running on its battery,
+// or hot enough that the firmware is (or is about to start) slowing it down.
+type Power struct {
+	OnBattery bool
+	Hot       bool
+}
+
+// Throttle reports whether work should be scaled back.
+func (p Power) Throttle() bool {
+	return p.OnBattery || p.Hot
+}
+
+// Reason says why work is throttled, e.g. "on battery power", or "" when it
+// is not.
+func (p Power) Reason() string {
+	switch {
+	case p.OnBattery && p.Hot:
+		return "on battery power and running hot"
+	case p.OnBattery:
+		return "on battery power"
+	case p.Hot:
+		return "running hot"
+	}
+	return ""
+}
+
+// sysfsRoot is where Linux exposes power supplies and thermal zones.
+const sysfsRoot = "/sys/class"
+
+// hotFallback is the temperature, in degrees Celsius, treated as hot for a
+// thermal zone that reports no passive trip point of its own.
+const hotFallback = 90
+
+// ReadPower reports the machine's power source and thermal state.  Linux
+// reads /sys/class/power_supply and /sys/class/thermal; macOS asks pmset.
+// Elsewhere it returns ErrUnsupported.  Desktops without a battery simply
+// never report OnBattery.
+func ReadPower() (Power, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return readLinuxPower(sysfsRoot)
+	case "darwin":
+		return readDarwinPower()
+	}
+	return Power{}, ErrUnsupported
+}
+
+// readLinuxPower reads the power supplies and thermal zones under root.
+func readLinuxPower(root string) (Power, error) {
+	var p Power
+
+	// On battery: a battery is discharging and no mains adapter is online.
+	supplies, _ := filepath.Glob(filepath.Join(root, "power_supply", "*"))
+	var discharging, mains bool
+	for _, s := range supplies {
+		switch readString(filepath.Join(s, "type")) {
+		case "Battery":
+			if readString(filepath.Join(s, "status")) == "Discharging" {
+				discharging = true
+			}
+		case "Mains", "USB":
+			if readString(filepath.Join(s, "online")) == "1" {
+				mains = true
+			}
+		}
+	}
+	p.OnBattery = discharging && !mains
+
+	// Hot: a zone has reached its lowest passive trip point, where the
+	// kernel starts cooling the CPU down by slowing it.
+	zones, _ := filepath.Glob(filepath.Join(root, "thermal", "thermal_zone*"))
+	for _, z := range zones {
+		temp, ok := readMilliCelsius(filepath.Join(z, "temp"))
+		if !ok {
+			continue
+		}
+		limit := hotFallback * 1000
+		trips, _ := filepath.Glob(filepath.Join(z, "trip_point_*_type"))
+		for _, t := range trips {
+			if readString(t) != "passive" {
+				continue
+			}
+			if v, ok := readMilliCelsius(strings.TrimSuffix(t, "_type") + "_temp"); ok && v > 0 {
+				limit = min(limit, v)
+			}
+		}
+		if temp >= limit {
+			p.Hot = true
+		}
+	}
+	return p, nil
+}
+
+// pmsetSpeedRE finds the CPU speed limit in "pmset -g therm" output, which
+// drops below 100 while macOS throttles for heat.
+var pmsetSpeedRE = regexp.MustCompile(`CPU_Speed_Limit\s*=\s*(\d+)`)
+
+// readDarwinPower asks pmset for the power source and thermal state.
+func readDarwinPower() (Power, error) {
+	var p Power
+	out, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return p, err
+	}
+	p.OnBattery = strings.Contains(string(out), "'Battery Power'")
+
+	out, err = exec.Command("pmset", "-g", "therm").Output()
+	if err == nil {
+		if m := pmsetSpeedRE.FindSubmatch(out); m != nil {
+			if n, err := strconv.Atoi(string(m[1])); err == nil && n < 100 {
+				p.Hot = true
+			}
+		}
+	}
+	return p, nil
+}
+
+// readString returns the trimmed contents of a sysfs attribute, or "".
+func readString(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// readMilliCelsius reads a sysfs temperature in thousandths of a degree.
+func readMilliCelsius(path string) (int, bool) {
+	n, err := strconv.Atoi(readString(path))
+	return n, err == nil
+}
@@ -0,0 +1,110 @@
+// Package sysload measures how busy the machine is, so that ImageSlim can
+// use idle CPUs without making the rest of the system sluggish, and whether
+// a laptop is on battery or hot (see ReadPower).
+//
+// Load readings come from /proc/stat and are therefore only available on
+// Linux; elsewhere Sampler.Read returns ErrUnsupported and callers fall back
+// to a fixed setting.
+package sysload
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported is returned when the platform offers no load figures.
+var ErrUnsupported = errors.New("system load is not available on this platform")
+
+// statPath is the kernel's CPU accounting file.
+const statPath = "/proc/stat"
+
+// Reading is the share of CPU time spent between two samples.
+type Reading struct {
+	// Busy is the fraction (0–1) of time all CPUs spent running anything.
+	Busy float64
+
+	// IOWait is the fraction (0–1) of time CPUs sat idle waiting for disk
+	// I/O: a high value means more parallel work would only queue up on the
+	// disk.
+	IOWait float64
+}
+
+// cpuTimes are the aggregate counters of the "cpu" line in /proc/stat.
+type cpuTimes struct {
+	total, idle, iowait uint64
+}
+
+// Sampler turns the ever-increasing kernel counters into readings over the
+// interval between calls.  The zero value is ready to use.
+type Sampler struct {
+	prev cpuTimes
+	ok   bool
+}
+
+// Read returns the load since the previous call.  The first call only
+// primes the sampler and reports zero load.
+func (s *Sampler) Read() (Reading, error) {
+	cur, err := readStat()
+	if err != nil {
+		return Reading{}, err
+	}
+	prev, ok := s.prev, s.ok
+	s.prev, s.ok = cur, true
+	if !ok || cur.total <= prev.total {
+		return Reading{}, nil
+	}
+	total := float64(cur.total - prev.total)
+	idle := float64(cur.idle - prev.idle)
+	iowait := float64(cur.iowait - prev.iowait)
+	return Reading{
+		Busy:   max(0, 1-(idle+iowait)/total),
+		IOWait: iowait / total,
+	}, nil
+}
+
+// readStat parses the "cpu" line of /proc/stat:
+//
+//	cpu  user nice system idle iowait irq softirq steal guest guest_nice
+//
+// Guest time is already included in user and nice, so it is not added.
+func readStat() (cpuTimes, error) {
+	f, err := os.Open(statPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return cpuTimes{}, ErrUnsupported
+	}
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 6 || fields[0] != "cpu" {
+			continue
+		}
+		var t cpuTimes
+		for i, field := range fields[1:min(len(fields), 9)] {
+			n, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return cpuTimes{}, fmt.Errorf("%s: %w", statPath, err)
+			}
+			t.total += n
+			switch i {
+			case 3:
+				t.idle = n
+			case 4:
+				t.iowait = n
+			}
+		}
+		return t, nil
+	}
+	if err := sc.Err(); err != nil {
+		return cpuTimes{}, err
+	}
+	return cpuTimes{}, fmt.Errorf("%s: no cpu line", statPath)
+}
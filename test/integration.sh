@@ -0,0 +1,2060 @@
+#!/usr/bin/env bash
+# End-to-end tests for imageslim that run without GraphicsMagick.
+#
+# The binary is built from the working tree and pointed at the fake gm in
+# test/fakegm, which records every call and writes synthetic outputs.  Each
+# test builds a small directory tree in a temporary directory, runs a job
+# headless and checks the files on disk and the gm calls that were made.
+#
+#   test/integration.sh            # run every test
+#   test/integration.sh overwrite  # run tests whose name contains "overwrite"
+#
+# Exits non-zero if any check fails.
+
+set -uo pipefail
+
+root=$(cd "$(dirname "$0")/.." && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+# Build once; the fake gm goes first on PATH so a real one is never used.
+if ! (cd "$root" && go build -o "$work/bin/imageslim" ./cmd/imageslim); then
+	echo "integration: build failed" >&2
+	exit 1
+fi
+export PATH="$root/test/fakegm:$work/bin:$PATH"
+export LC_ALL=C # stable number formatting in summaries
+unset IMAGESLIM_GM_PATH FAKEGM_DELAY FAKEGM_EXIF_DATE FAKEGM_FAIL FAKEGM_SLOW FAKEGM_QUALITY_BYTES FAKEGM_VERSION FAKEMAGICK_FAIL FAKEPNG_FAIL FAKEENC_FAIL FAKEJPEG_IMPERFECT FAKEAWS_ROOT
+export IMAGESLIM_METRICS_FILE="$work/metrics.jsonl" # never touch the user's own
+export IMAGESLIM_HISTORY_FILE="$work/history.jsonl"
+export IMAGESLIM_SYSTEM_CONFIG="$work/system-config.yaml" # nor /etc/imageslim
+unset IMAGESLIM_OUTPUT IMAGESLIM_REPORT_FORMAT IMAGESLIM_THEME_BACKGROUND IMAGESLIM_THEME_ACCENT
+
+failed=0
+checks=0
+
+# ---------------------------------------------------------------------------
+# Helpers
+# ---------------------------------------------------------------------------
+
+# fail reports a failed check in the current test.
+fail() {
+	echo "    FAIL: $*"
+	failed=$((failed + 1))
+}
+
+# check runs a command and reports a failure with the given message when it
+# exits non-zero: check "message" cmd args...
+check() {
+	local msg=$1
+	shift
+	checks=$((checks + 1))
+	"$@" || fail "$msg"
+}
+
+# setup creates a fresh image tree in $dir and resets the gm call log:
+#
+#   photos/a.jpg  photos/B.JPG  photos/notes.txt
+#   photos/sub/c.png  photos/sub/deep/d.jpeg
+setup() {
+	dir="$work/$1"
+	rm -rf "$dir"
+	mkdir -p "$dir/photos/sub/deep"
+	for f in a.jpg B.JPG notes.txt sub/c.png sub/deep/d.jpeg; do
+		printf 'original %s %0200d\n' "$f" 0 >"$dir/photos/$f"
+	done
+	export FAKEGM_LOG="$dir/gm.log"
+	: >"$FAKEGM_LOG"
+}
+
+# job writes $dir/job.yaml for the photos tree with extra YAML lines.
+job() {
+	{
+		echo "name: $(basename "$dir")"
+		echo "dir: ./photos"
+		printf '%s\n' "$@"
+	} >"$dir/job.yaml"
+}
+
+is_original() { grep -q '^original ' "$1"; }
+is_converted() { grep -q '^fake-gm ' "$1"; }
+has_call() { grep -qxF -- "$1" "$FAKEGM_LOG"; }
+not_call() { ! has_call "$1"; }
+not() { ! "$@"; }
+count_calls() { [ "$(grep -c "^$1 " "$FAKEGM_LOG")" -eq "$2" ]; }
+# unprivileged runs a command without the capabilities that let root read
+# any file, so that permissions apply to it as to anyone else.
+unprivileged() {
+	if [ "$(id -u)" -eq 0 ]; then
+		setpriv --bounding-set -dac_override,-dac_read_search "$@"
+	else
+		"$@"
+	fi
+}
+gone() { ! ps -o stat= -p "$1" 2>/dev/null | grep -qv Z; } # exited, if not yet reaped
+
+# ---------------------------------------------------------------------------
+# Tests
+# ---------------------------------------------------------------------------
+
+test_preserve_recursive() {
+	setup preserve_recursive
+	job
+	check "run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	for f in a.jpg B.JPG sub/c.png sub/deep/d.jpeg; do
+		check "output/$f converted" is_converted "$dir/photos/output/$f"
+		check "$f untouched" is_original "$dir/photos/$f"
+	done
+	check "notes.txt not matched" test ! -e "$dir/photos/output/notes.txt"
+	check "gm convert called per file" count_calls convert 4
+	check "convert arguments" has_call "convert sub/c.png -resize 1200x1200> -quality 80 output/sub/c.png"
+	check "summary printed" grep -q "Processed 4 file(s)" "$dir/out.txt"
+}
+
+test_preserve_flat() {
+	setup preserve_flat
+	job "scope: flat"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "top-level file converted" is_converted "$dir/photos/output/a.jpg"
+	check "subfolders skipped" test ! -e "$dir/photos/output/sub"
+	check "only top-level files" count_calls convert 2
+}
+
+test_skip_own_dirs() {
+	setup skip_own_dirs
+	job
+	check "first run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "second run succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "output/ not converted again" test ! -e "$dir/photos/output/output"
+	check "same files both times" count_calls convert 8
+
+	rm -rf "$dir/photos/output"
+	job "mode: overwrite" "backup: true" "backup_dir: ./old-originals"
+	check "backup run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "backup directory marked" test -f "$dir/photos/old-originals/.imageslim-backup-root"
+	: >"$FAKEGM_LOG"
+	job "mode: overwrite" "backup: false"
+	check "run with another backup_dir succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "earlier backups left alone" not grep -q old-originals "$FAKEGM_LOG"
+	check "originals still backed up" is_original "$dir/photos/old-originals/a.jpg"
+	check "restore succeeds" imageslim restore -backup-dir ./old-originals "$dir/photos" >/dev/null
+	check "marker not restored" test ! -e "$dir/photos/.imageslim-backup-root"
+}
+
+test_overwrite_backup_restore() {
+	setup overwrite_backup_restore
+	job "mode: overwrite" "backup: true"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "file modified in place" is_converted "$dir/photos/sub/c.png"
+	check "no output folder" test ! -e "$dir/photos/output"
+	check "original backed up" is_original "$dir/photos/.imageslim-backup/sub/c.png"
+	check "mogrify arguments" has_call "mogrify -resize 1200x1200> -quality 80 sub/c.png"
+	check "restore succeeds" imageslim restore "$dir/photos" >/dev/null
+	check "original restored" is_original "$dir/photos/sub/c.png"
+	check "backup removed" test ! -e "$dir/photos/.imageslim-backup"
+	check "unmarked backup refused" not imageslim restore -backup-dir sub "$dir/photos" 2>"$dir/err.txt"
+	check "marker named" grep -q "it has no .imageslim-backup-root" "$dir/err.txt"
+	check "unmarked directory kept" test -f "$dir/photos/sub/c.png"
+	touch "$dir/photos/.imageslim-backup-root"
+	check "photo directory refused as its own backup" not imageslim restore -backup-dir . "$dir/photos" 2>"$dir/err.txt"
+	check "reason given" grep -q "it holds $dir/photos" "$dir/err.txt"
+	check "photo directory kept" test -f "$dir/photos/a.jpg"
+}
+
+test_trash_undo() {
+	setup trash_undo
+	job "mode: overwrite" "trash: true"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "file modified in place" is_converted "$dir/photos/sub/c.png"
+	check "one run in the trash" test "$(ls "$dir/photos/.imageslim-trash" | wc -l)" -eq 1
+	check "original trashed" is_original "$dir/photos/.imageslim-trash/"*/sub/c.png
+
+	: >"$FAKEGM_LOG"
+	check "second run succeeds" imageslim run -force "$dir/job.yaml" >/dev/null # most likely within the same second
+	check "trash not scanned" count_calls mogrify 4
+	check "two runs in the trash" test "$(ls "$dir/photos/.imageslim-trash" | wc -l)" -eq 2
+	check "first run's originals kept" is_original "$(ls -d "$dir/photos/.imageslim-trash/"* | head -1)/sub/c.png"
+
+	check "undo succeeds" imageslim undo "$dir/photos" >"$dir/out.txt"
+	check "undo reported" grep -q "Undid the run of .*: restored 4 file(s)" "$dir/out.txt"
+	check "last run undone first" is_converted "$dir/photos/sub/c.png"
+	check "one run left" test "$(ls "$dir/photos/.imageslim-trash" | wc -l)" -eq 1
+	check "undo again succeeds" imageslim undo "$dir/photos" >/dev/null
+	check "original back" is_original "$dir/photos/sub/c.png"
+	check "other originals back" is_original "$dir/photos/a.jpg"
+	check "empty trash removed" test ! -e "$dir/photos/.imageslim-trash"
+	check "nothing left to undo" not imageslim undo "$dir/photos" 2>/dev/null
+	check "rerun converts the restored files" sh -c "imageslim run '$dir/job.yaml' | grep -q 'Processed 4 file(s)'"
+	check "run that replaced nothing" imageslim run "$dir/job.yaml" >/dev/null
+	check "leaves nothing to undo" sh -c "imageslim undo '$dir/photos' | grep -q 'restored 4 file(s)'"
+
+	rm -rf "$dir/photos/.imageslim-trash"
+	mkdir -p "$dir/photos/.imageslim-trash/2024-01-02T030405/sub"
+	printf 'original old\n' >"$dir/photos/.imageslim-trash/2024-01-02T030405/sub/c.png"
+	check "run trashed by an earlier version undone" imageslim undo "$dir/photos" >/dev/null
+	check "its original back" grep -q "^original old" "$dir/photos/sub/c.png"
+}
+
+test_overwrite_resume() {
+	setup overwrite_resume
+	job "mode: overwrite"
+	check "first run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	: >"$FAKEGM_LOG"
+	check "second run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "nothing reprocessed" count_calls mogrify 0
+	check "skips reported" grep -q "skipped 4 already processed" "$dir/out.txt"
+
+	printf 'changed\n' >"$dir/photos/a.jpg"
+	: >"$FAKEGM_LOG"
+	check "third run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "changed file reprocessed" has_call "mogrify -resize 1200x1200> -quality 80 a.jpg"
+	check "only the changed file" count_calls mogrify 1
+
+	: >"$FAKEGM_LOG"
+	check "forced run succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "-force reprocesses all" count_calls mogrify 4
+	check "manifest keeps one line per file" test "$(wc -l <"$dir/photos/.imageslim-manifest")" -eq 4
+	check "a file listed once" test "$(grep -c '"path":"sub/c.png"' "$dir/photos/.imageslim-manifest")" -eq 1
+	check "no temporary manifest left" test ! -e "$dir/photos/.imageslim-manifest.tmp"
+}
+
+test_hash_cache() {
+	setup hash_cache
+	job "hash_cache: true"
+	check "first run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "manifest keeps hashes" grep -q '"src_sha256":"[0-9a-f]\{64\}","out_sha256":"[0-9a-f]\{64\}"' "$dir/photos/output/.imageslim-manifest"
+
+	touch -d '2020-01-01 12:00' "$dir/photos/a.jpg" "$dir/photos/output/sub/c.png"
+	: >"$FAKEGM_LOG"
+	check "touched run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "touched files skipped" count_calls convert 0
+	check "all skipped" grep -q "skipped 4 already processed" "$dir/out.txt"
+
+	sed -i 's/^original/ORIGINAL/' "$dir/photos/B.JPG"
+	: >"$FAKEGM_LOG"
+	check "edited run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "same-size edit reconverted" has_call "convert B.JPG -resize 1200x1200> -quality 80 output/B.JPG"
+	check "only the edited file" count_calls convert 1
+
+	touch -d '2020-01-01 12:00' "$dir/photos/sub/deep/d.jpeg"
+	job
+	: >"$FAKEGM_LOG"
+	check "run without the cache succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "touched file reconverted without it" has_call "convert sub/deep/d.jpeg -resize 1200x1200> -quality 80 output/sub/deep/d.jpeg"
+}
+
+test_failure_stops_run() {
+	setup failure_stops_run
+	job "mode: overwrite"
+	if FAKEGM_FAIL='c.png' imageslim run "$dir/job.yaml" >"$dir/out.txt" 2>"$dir/err.txt"; then
+		fail "run with a corrupt image should fail"
+	fi
+	check "failing file named" grep -q "sub/c.png" "$dir/err.txt"
+	check "gm error captured" grep -q "Improper image header" "$dir/out.txt"
+	check "damaged-file hint printed" grep -q "hint: The named file is damaged" "$dir/err.txt"
+}
+
+test_continue_past_failures() {
+	setup continue_past_failures
+	job "workers: 1"
+	check "run with failures exits non-zero" not env FAKEGM_FAIL='[Bc].*' imageslim run "$dir/job.yaml" >"$dir/out.txt" 2>"$dir/err.txt"
+	check "other files converted" is_converted "$dir/photos/output/a.jpg"
+	check "files after the failure converted" is_converted "$dir/photos/output/sub/deep/d.jpeg"
+	check "failed files left out" test ! -e "$dir/photos/output/sub/c.png"
+	check "summary counts failures" grep -q "Processed 2 file(s), failed 2" "$dir/out.txt"
+	check "failing paths listed" sh -c "grep -qx '         B.JPG' '$dir/out.txt' && grep -qx '         sub/c.png' '$dir/out.txt'"
+	check "no run error" test "$(grep -c "imageslim:" "$dir/err.txt")" -eq 2
+	check "nothing left untried" not grep -q "not tried" "$dir/out.txt"
+
+	check "rerun converts the fixed files" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "only the failed files redone" grep -q "Processed 2 file(s) (skipped 2 already processed)" "$dir/out.txt"
+
+	check "failed files' errors printed" sh -c "grep -q 'imageslim: continue_past_failures: B.JPG: exit status 1' '$dir/err.txt' && grep -q 'imageslim: continue_past_failures: sub/c.png: exit status 1' '$dir/err.txt'"
+	check "hint for the failures" grep -q "hint: The named file is damaged" "$dir/err.txt"
+
+	check "-stop-on-error stops the run" not env FAKEGM_FAIL='a.jpg' imageslim run -force -stop-on-error "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "files after the failure left" grep -q "1  not tried after the failure" "$dir/err.txt"
+	job "workers: 1" "stop_on_error: true"
+	check "stop_on_error stops the run" not env FAKEGM_FAIL='a.jpg' imageslim run -force "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "job's setting honoured" grep -q "1  not tried after the failure" "$dir/err.txt"
+
+	job "workers: 1" "report: $dir/report.jsonl"
+	check "reported run" not env FAKEGM_FAIL='a.jpg' imageslim run -force "$dir/job.yaml" >/dev/null 2>&1
+	check "report keeps the failed file's messages" grep -q '"path":"a.jpg","status":"failed".*"messages":\["gm convert: Improper image header (a.jpg)."\]' "$dir/report.jsonl"
+	check "converted files have no messages" not grep -q '"status":"converted".*"messages"' "$dir/report.jsonl"
+}
+
+test_encoding_options() {
+	setup encoding_options
+	job "interlace: line" "auto_orient: true"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "JPEG gets -interlace" has_call "convert a.jpg -auto-orient -resize 1200x1200> -quality 80 -interlace Line output/a.jpg"
+	check "PNG is not interlaced" has_call "convert sub/c.png -auto-orient -resize 1200x1200> -quality 80 output/sub/c.png"
+
+	job 'subsampling: "4:4:4"'
+	: >"$FAKEGM_LOG"
+	check "subsampling run succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "JPEG keeps full colour resolution" has_call "convert a.jpg -resize 1200x1200> -quality 80 -sampling-factor 1x1 output/a.jpg"
+	check "PNG has no sampling factor" has_call "convert sub/c.png -resize 1200x1200> -quality 80 output/sub/c.png"
+	: >"$FAKEGM_LOG"
+	check "-subsampling override succeeds" imageslim run -force -subsampling 420 "$dir/job.yaml" >/dev/null
+	check "-subsampling overrides the job" has_call "convert a.jpg -resize 1200x1200> -quality 80 -sampling-factor 2x2 output/a.jpg"
+	job "subsampling: 4:1:1"
+	check "unknown subsampling rejected" not imageslim run "$dir/job.yaml" 2>/dev/null
+}
+
+test_resize_modes() {
+	setup resize_modes
+	job "resize: 400x300" "resize_mode: fill" "gravity: north"
+	check "fill run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "fill crops to the box" has_call "convert a.jpg -resize 400x300^ -gravity North -extent 400x300 -quality 80 output/a.jpg"
+	job "resize: 400x300" "resize_mode: pad"
+	: >"$FAKEGM_LOG"
+	check "pad run succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "pad extends the canvas" has_call "convert a.jpg -resize 400x300> -gravity Center -background white -extent 400x300 -quality 80 output/a.jpg"
+	job "resize: 1000x1000" "resize_mode: pad" "background: '#F5F5F5'"
+	: >"$FAKEGM_LOG"
+	check "pad with a background succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "pad uses the background" has_call "convert a.jpg -resize 1000x1000> -gravity Center -background #f5f5f5 -extent 1000x1000 -quality 80 output/a.jpg"
+	: >"$FAKEGM_LOG"
+	check "-background override succeeds" imageslim run -force -background transparent "$dir/job.yaml" >/dev/null
+	check "-background overrides the job" has_call "convert a.jpg -resize 1000x1000> -gravity Center -background transparent -extent 1000x1000 -quality 80 output/a.jpg"
+
+	# The fake gm says every image is 640x480.
+	job "resize: 800x400" "resize_mode: fill"
+	: >"$FAKEGM_LOG"
+	check "fill of a narrower image succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "fill crops but never enlarges" has_call "convert a.jpg -resize 640x400^ -gravity Center -extent 640x400 -quality 80 output/a.jpg"
+	job "resize: 800x400" "resize_mode: fill" "upscale: true"
+	: >"$FAKEGM_LOG"
+	check "upscaling fill succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "upscale fills the box" has_call "convert a.jpg -resize 800x400^ -gravity Center -extent 800x400 -quality 80 output/a.jpg"
+	job "resize: 800x400"
+	: >"$FAKEGM_LOG"
+	check "upscaling fit succeeds" imageslim run -force -upscale "$dir/job.yaml" >/dev/null
+	check "upscale drops the shrink-only flag" has_call "convert a.jpg -resize 800x400 -quality 80 output/a.jpg"
+	job "resize: 150%"
+	check "enlarging geometry rejected" not imageslim run "$dir/job.yaml" 2>/dev/null
+	job "resize: 800x600<"
+	check "enlarge-only geometry rejected" not imageslim run "$dir/job.yaml" 2>/dev/null
+	job "resize: 800x600<" "upscale: true"
+	check "...unless upscaling is allowed" imageslim run -force "$dir/job.yaml" >/dev/null
+	for bad in "resize: longedge:" "resize: longedge:0" "resize: longedge:16x9" "resize: longedge:1600>"; do
+		job "$bad"
+		checks=$((checks + 1))
+		if imageslim run "$dir/job.yaml" >/dev/null 2>&1; then
+			fail "job with '$bad' should be rejected"
+		fi
+	done
+	printf '\x89\x50\x4e\x47\x0d\x0a\x1a\x0a\x00\x00\x00\x0d\x49\x48\x44\x52\x00\x00\x01\xe0\x00\x00\x02\x80\x08\x02\x00\x00\x00\xcb\xaa\x3c\x05' >"$dir/photos/sub/c.png" # 480x640
+	job "resize: longedge:1600"
+	: >"$FAKEGM_LOG"
+	check "long-edge run succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "landscape long edge limited" has_call "convert a.jpg -resize 1600x1600> -quality 80 output/a.jpg"
+	check "portrait long edge limited" has_call "convert sub/c.png -resize 1600x1600> -quality 80 output/sub/c.png"
+	check "sizes not read" count_calls identify 0
+	job "resize: 50%"
+	: >"$FAKEGM_LOG"
+	check "percentage run succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "percentage passed on" has_call "convert a.jpg -resize 50%> -quality 80 output/a.jpg"
+	for bad in "resize: 400x" "resize: 50%" "resize: longedge:1600" "gravity: upwards" "background: '#ff'" "background: 'white; rm'"; do
+		job "resize_mode: fill" "$bad"
+		checks=$((checks + 1))
+		if imageslim run "$dir/job.yaml" >/dev/null 2>&1; then
+			fail "fill with '$bad' should be rejected"
+		fi
+	done
+}
+
+test_srgb() {
+	setup srgb
+	job "srgb: true"
+	export XDG_CACHE_HOME="$dir/cache"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "profile converted, then stripped" has_call "convert a.jpg -resize 1200x1200> -profile $dir/cache/imageslim/sRGB.icc -colorspace RGB +profile icc -quality 80 output/a.jpg"
+	check "sRGB profile written" test "$(head -c 40 "$dir/cache/imageslim/sRGB.icc" | tail -c 4)" = acsp
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	job
+	check "-srgb override succeeds" imageslim run -srgb "$dir/job.yaml" >/dev/null
+	check "-srgb converts" has_call "convert B.JPG -resize 1200x1200> -profile $dir/cache/imageslim/sRGB.icc -colorspace RGB +profile icc -quality 80 output/B.JPG"
+	job "srgb: true" "lossless: true"
+	check "lossless refuses it" not imageslim run "$dir/job.yaml" 2>/dev/null
+	unset XDG_CACHE_HOME
+}
+
+test_tone() {
+	setup tone
+	job "tone: Greyscale"
+	check "grayscale run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "grayscale colorspace" has_call "convert a.jpg -resize 1200x1200> -colorspace GRAY -quality 80 output/a.jpg"
+	: >"$FAKEGM_LOG"
+	check "-tone override succeeds" imageslim run -force -tone sepia "$dir/job.yaml" >/dev/null
+	check "sepia recolor matrix" has_call "convert a.jpg -resize 1200x1200> -recolor 0.393 0.769 0.189 0.349 0.686 0.168 0.272 0.534 0.131 -quality 80 output/a.jpg"
+	job "tone: sepia" "max_colors: 16"
+	: >"$FAKEGM_LOG"
+	check "tone with colour reduction succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "tone before colours are reduced" grep -q "^convert a.jpg -resize 1200x1200> -recolor .* -colors 16 " "$FAKEGM_LOG"
+	job "tone: vintage"
+	check "unknown tone rejected" not imageslim run "$dir/job.yaml" 2>/dev/null
+	job "tone: sepia" "lossless: true"
+	check "lossless refuses a tone" not imageslim run "$dir/job.yaml" 2>/dev/null
+}
+
+test_rotate() {
+	setup rotate
+	job "rotate: 90" "flip: true"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "rotation and flip before the resize" has_call "convert a.jpg -rotate 90 -flip -resize 1200x1200> -quality 80 output/a.jpg"
+	job "auto_orient: true" "rotate: auto"
+	: >"$FAKEGM_LOG"
+	check "auto rotation succeeds" imageslim run -force -flop "$dir/job.yaml" >/dev/null
+	check "only landscape images turned, after auto-orient" has_call "convert a.jpg -auto-orient -rotate 90> -flop -resize 1200x1200> -quality 80 output/a.jpg"
+	job "rotate: -90" "resize: longedge:1600"
+	: >"$FAKEGM_LOG"
+	check "long edge run succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "long edge of the turned image" has_call "convert a.jpg -rotate 270 -resize 1600x1600> -quality 80 output/a.jpg"
+	job "rotate: 45"
+	check "other angles rejected" not imageslim run "$dir/job.yaml" 2>/dev/null
+	job "flop: true" "lossless: true"
+	check "lossless refuses flipping" not imageslim run "$dir/job.yaml" 2>/dev/null
+}
+
+test_sharpen() {
+	setup sharpen
+	job "sharpen: on"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "default unsharp after resize" has_call "convert a.jpg -resize 1200x1200> -unsharp 0x0.75+0.75+0.008 -quality 80 output/a.jpg"
+	: >"$FAKEGM_LOG"
+	check "override succeeds" imageslim run -force -sharpen 0x1+1.5 "$dir/job.yaml" >/dev/null
+	check "-sharpen overrides the job" has_call "convert a.jpg -resize 1200x1200> -unsharp 0x1+1.5 -quality 80 output/a.jpg"
+	if imageslim run -sharpen blurry "$dir/job.yaml" >/dev/null 2>&1; then
+		fail "an invalid -sharpen should be rejected"
+	fi
+}
+
+test_watermark() {
+	setup watermark
+	printf 'logo\n' >"$dir/photos/logo.png"
+	job "watermark: {image: ./photos/logo.png, position: northwest, opacity: 40, scale: 50}"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "watermark stamped after conversion" grep -q "^fake-gm composite .*logo.png" "$dir/photos/output/a.jpg"
+	check "composite arguments" has_call "composite -gravity NorthWest -geometry 50%+10+10 -dissolve 40 -quality 80 $dir/photos/logo.png output/sub/c.png output/sub/c.png"
+	check "overlay not processed itself" test ! -e "$dir/photos/output/logo.png"
+	check "one composite per image" count_calls composite 4
+
+	job "watermark: {image: ./photos/logo.png}"
+	: >"$FAKEGM_LOG"
+	check "settings change reprocesses" imageslim run "$dir/job.yaml" >/dev/null
+	check "default bottom right, opaque" has_call "composite -gravity SouthEast -geometry +10+10 -quality 80 $dir/photos/logo.png output/a.jpg output/a.jpg"
+	: >"$FAKEGM_LOG"
+	check "-watermark none succeeds" imageslim run -force -watermark none "$dir/job.yaml" >/dev/null
+	check "-watermark none disables it" count_calls composite 0
+
+	job "watermark: {image: ./missing.png}"
+	checks=$((checks + 1))
+	if imageslim run "$dir/job.yaml" >/dev/null 2>&1; then
+		fail "a missing watermark image should be rejected"
+	fi
+}
+
+test_name_template() {
+	setup name_template
+	job 'name_template: "{name}-{width}x{height}_q{quality}.{ext}"'
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "dimensions in the name" is_converted "$dir/photos/output/sub/c-640x480_q80.png"
+	check "original name not written" test ! -e "$dir/photos/output/a.jpg"
+	check "no partial files left" test -z "$(find "$dir/photos/output" -name '.imageslim-partial-*')"
+	: >"$FAKEGM_LOG"
+	check "second run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "templated outputs resume" grep -q "skipped 4 already processed" "$dir/out.txt"
+
+	job 'name_template: "{name}_web.webp"'
+	: >"$FAKEGM_LOG"
+	check "format change succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "extension picks the format" has_call "convert sub/c.png -resize 1200x1200> -quality 80 output/sub/c_web.webp"
+	check "no identify without dimensions" count_calls identify 0
+
+	job 'name_template: "{name}-{hash}.{ext}"' "scope: flat"
+	check "hash template succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	hash=$(sha256sum "$dir/photos/a.jpg" | cut -c1-8)
+	check "content hash in the name" is_converted "$dir/photos/output/a-$hash.jpg"
+
+	job 'name_template: "{taken}_{name}.{ext}"' "scope: flat"
+	check "EXIF date template succeeds" env FAKEGM_EXIF_DATE="2024:07:14 10:22:33" imageslim run "$dir/job.yaml" >/dev/null
+	check "date taken in the name" is_converted "$dir/photos/output/2024-07-14_a.jpg"
+	rm -rf "$dir/photos/output" "$dir/photos/.imageslim-manifest"
+	check "template without EXIF succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "modification date without EXIF" is_converted "$dir/photos/output/$(date -r "$dir/photos/a.jpg" +%F)_a.jpg"
+
+	printf 'original\n' >"$dir/photos/a.png"
+	job 'name_template: "{name}.jpg"'
+	if imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"; then
+		fail "colliding output names should fail"
+	fi
+	check "collision explained" grep -q "both written to output/a.jpg" "$dir/err.txt"
+
+	for bad in "web.{ext}" "{name}/{ext}" "{name}.{size}" "{name}" "{name.jpg"; do
+		job "name_template: \"$bad\""
+		checks=$((checks + 1))
+		if imageslim run "$dir/job.yaml" >/dev/null 2>&1; then
+			fail "template '$bad' should be rejected"
+		fi
+	done
+	job "mode: overwrite" 'name_template: "{name}_web.{ext}"'
+	checks=$((checks + 1))
+	if imageslim run "$dir/job.yaml" >/dev/null 2>&1; then
+		fail "a template in overwrite mode should be rejected"
+	fi
+}
+
+test_workers() {
+	setup workers
+	for w in 4 auto; do
+		job "workers: $w"
+		rm -rf "$dir/photos/output"
+		: >"$FAKEGM_LOG"
+		check "workers: $w succeeds" imageslim run "$dir/job.yaml" >/dev/null
+		check "workers: $w converts every file" count_calls convert 4
+		check "workers: $w output" is_converted "$dir/photos/output/sub/c.png"
+	done
+	job "workers: 4" "power_aware: true"
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "power-aware run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "power-aware run converts every file" count_calls convert 4
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "-workers override succeeds" imageslim run -workers 2 "$dir/job.yaml" >/dev/null
+	check "-workers override converts every file" count_calls convert 4
+
+	# The two largest files take the two workers first, so one of them is
+	# the first call whichever process starts faster.  In scan order B.JPG
+	# and a.jpg would.
+	printf 'original big %05000d\n' 0 >"$dir/photos/sub/deep/d.jpeg"
+	printf 'original big %04000d\n' 0 >"$dir/photos/sub/c.png"
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "largest-first run succeeds" imageslim run -workers 2 "$dir/job.yaml" >/dev/null
+	first=$(grep -m 1 '^convert ' "$FAKEGM_LOG" | awk '{print $2}')
+	check "largest files started first" grep -qxE 'sub/c\.png|sub/deep/d\.jpeg' <<<"$first"
+	job "workers: 4" "per_directory: 1"
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "per-directory run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "per-directory run converts every file" count_calls convert 4
+	check "-per-directory override succeeds" imageslim run -force -per-directory 2 "$dir/job.yaml" >/dev/null
+	for bad in "workers: 0" "workers: 99" "workers: many" "per_directory: -1" "per_directory: 99"; do
+		job "$bad"
+		checks=$((checks + 1))
+		if imageslim run "$dir/job.yaml" >/dev/null 2>&1; then
+			fail "job with '$bad' should be rejected"
+		fi
+	done
+}
+
+test_min_size() {
+	setup min_size
+	printf 'original big %02000d\n' 0 >"$dir/photos/a.jpg"
+	job "min_file_size: 1KB"
+	check "run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "only the large file converted" count_calls convert 1
+	check "large file output" is_converted "$dir/photos/output/a.jpg"
+	check "small files reported" grep -q "3 below the minimum size" "$dir/out.txt"
+	check "no dimensions read for a size-only filter" count_calls identify 0
+
+	rm -rf "$dir/photos/output"
+	job "min_dimensions: 641x"
+	: >"$FAKEGM_LOG"
+	check "dimension filter run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "narrow images left alone" count_calls convert 0
+	check "dimensions read with gm identify" count_calls identify 4
+	: >"$FAKEGM_LOG"
+	check "-min-dimensions override succeeds" imageslim run -min-dimensions 640x480 "$dir/job.yaml" >/dev/null
+	check "images at the minimum converted" count_calls convert 4
+
+	for bad in "min_file_size: lots" "min_file_size: -5KB" "min_dimensions: 50%" "min_dimensions: 2000x>"; do
+		job "$bad"
+		checks=$((checks + 1))
+		if imageslim run "$dir/job.yaml" >/dev/null 2>&1; then
+			fail "job with '$bad' should be rejected"
+		fi
+	done
+}
+
+same_file() { [ "$(stat -c %d:%i "$1")" = "$(stat -c %d:%i "$2")" ]; }
+
+test_link_skipped() {
+	setup link_skipped
+	printf 'original big %02000d\n' 0 >"$dir/photos/a.jpg"
+	job "min_file_size: 1KB" "link_skipped: hardlink"
+	check "run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "large file converted" is_converted "$dir/photos/output/a.jpg"
+	check "small file hardlinked" same_file "$dir/photos/sub/c.png" "$dir/photos/output/sub/c.png"
+	check "deep small file hardlinked" same_file "$dir/photos/sub/deep/d.jpeg" "$dir/photos/output/sub/deep/d.jpeg"
+	check "other files left out" not test -e "$dir/photos/output/notes.txt"
+	check "linked files reported" grep -q "3 skipped file(s) put in output/ as they were" "$dir/out.txt"
+
+	: >"$FAKEGM_LOG"
+	check "second run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "nothing reconverted" count_calls convert 0
+
+	job "link_skipped: hardlink"
+	check "run without the minimum succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "linked file now converted" is_converted "$dir/photos/output/sub/c.png"
+	check "its source untouched" is_original "$dir/photos/sub/c.png"
+	check "link broken first" not same_file "$dir/photos/sub/c.png" "$dir/photos/output/sub/c.png"
+
+	rm -rf "$dir/photos/output"
+	for mode in reflink copy; do
+		job "min_file_size: 1KB" "link_skipped: $mode"
+		check "$mode run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+		check "$mode puts the file in output/" cmp -s "$dir/photos/B.JPG" "$dir/photos/output/B.JPG"
+		check "$mode makes a separate file" not same_file "$dir/photos/B.JPG" "$dir/photos/output/B.JPG"
+		rm -rf "$dir/photos/output"
+	done
+
+	job "link_skipped: symlink"
+	check "unknown mode rejected" not imageslim run "$dir/job.yaml" >/dev/null 2>&1
+}
+
+test_not_processed() {
+	setup not_processed
+	printf 'original scan %0200d\n' 0 >"$dir/photos/scan.tiff"
+	job
+	check "run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "left-out files counted" grep -q "Not processed: 2 of 6 files" "$dir/out.txt"
+	check "pattern exclusion reported" grep -q "1  image(s) the file patterns leave out" "$dir/out.txt"
+	check "unsupported file reported" grep -q "1  not an image format ImageSlim converts" "$dir/out.txt"
+
+	rm -rf "$dir/photos/output"
+	job "workers: 1" "stop_on_error: true"
+	FAKEGM_FAIL='B.JPG' imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "failure counted" grep -q "1  failed" "$dir/err.txt"
+	check "files after the failure counted" grep -q "2  not tried after the failure" "$dir/err.txt"
+}
+
+test_png_optimize() {
+	setup png_optimize
+	job "png_optimize: lossy"
+	check "run without optimisers succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "missing optimisers reported" grep -q "note: PNGs are not optimised: install pngquant and optipng" "$dir/out.txt"
+	check "gm's PNG kept" is_converted "$dir/photos/output/sub/c.png"
+
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "lossy run succeeds" env PATH="$root/test/fakepng:$PATH" imageslim run "$dir/job.yaml" >/dev/null
+	check "pngquant run on the output" has_call "pngquant --force --skip-if-larger --output output/sub/c.png -- output/sub/c.png"
+	check "optipng run after pngquant" has_call "optipng -quiet -o2 -- output/sub/c.png"
+	check "JPEGs left to gm" count_calls optipng 1
+	check "PNG optimised" grep -q fake-optipng "$dir/photos/output/sub/c.png"
+
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "lossless override succeeds" env PATH="$root/test/fakepng:$PATH" imageslim run -png-optimize lossless "$dir/job.yaml" >/dev/null
+	check "no pngquant when lossless" count_calls pngquant 0
+	check "optipng still run" count_calls optipng 1
+
+	rm -rf "$dir/photos/output"
+	check "failing optimiser does not fail the run" env PATH="$root/test/fakepng:$PATH" FAKEPNG_FAIL=1 imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "failure reported" grep -q "optipng failed, keeping gm's PNG" "$dir/out.txt"
+
+	job "png_optimize: extreme"
+	checks=$((checks + 1))
+	if imageslim run "$dir/job.yaml" >/dev/null 2>&1; then
+		fail "job with an unknown PNG optimisation should be rejected"
+	fi
+}
+
+test_output_format() {
+	setup output_format
+	job "format: webp" "effort: 10"
+	check "webp run succeeds" env PATH="$root/test/fakecodec:$PATH" imageslim run "$dir/job.yaml" >/dev/null
+	check "gm writes a PNG for cwebp" has_call "convert a.jpg -resize 1200x1200> -quality 80 output/.imageslim-partial-a.webp.png"
+	check "cwebp encodes it" has_call "cwebp -quiet -q 80 -m 6 output/.imageslim-partial-a.webp.png -o output/a.webp"
+	check "WebP written" grep -q fake-cwebp "$dir/photos/output/sub/deep/d.webp"
+	check "original extension not written" test ! -e "$dir/photos/output/a.jpg"
+	check "intermediate PNGs removed" test -z "$(find "$dir/photos/output" -name '.imageslim-partial-*')"
+
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "run without cwebp succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "fallback reported" grep -q "note: cwebp is not installed; gm writes the WebP files" "$dir/out.txt"
+	check "gm writes the WebP" has_call "convert a.jpg -resize 1200x1200> -quality 80 -define webp:method=6 output/a.webp"
+	check "gm's WebP kept" is_converted "$dir/photos/output/a.webp"
+
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	checks=$((checks + 1))
+	if imageslim run -format avif "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"; then
+		fail "AVIF without avifenc should fail when gm cannot write it"
+	fi
+	check "avifenc suggested" grep -q "AVIF output needs avifenc" "$dir/err.txt"
+	check "nothing converted" test ! -e "$dir/photos/output"
+	check "avif override succeeds" env PATH="$root/test/fakecodec:$PATH" imageslim run -format avif "$dir/job.yaml" >/dev/null
+	check "avifenc encodes the PNG" has_call "avifenc -q 80 -s 0 output/sub/.imageslim-partial-c.avif.png output/sub/c.avif"
+
+	job "format: webp" "mode: overwrite"
+	checks=$((checks + 1))
+	if imageslim run "$dir/job.yaml" >/dev/null 2>&1; then
+		fail "format in overwrite mode should be rejected"
+	fi
+
+	job "format: webp"
+	rm -rf "$dir/photos/output"
+	cp "$dir/photos/sub/c.png" "$dir/photos/a.png"
+	checks=$((checks + 1))
+	if imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"; then
+		fail "a.jpg and a.png should not both become a.webp"
+	fi
+	check "collision explained" grep -q "are both written to output/a.webp" "$dir/err.txt"
+}
+
+test_heic() {
+	setup heic
+	printf 'original %s %0200d\n' IMG_1.heic 0 >"$dir/photos/IMG_1.heic"
+	job
+	check "run without heic succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "HEIC left alone by default" not_call "convert IMG_1.heic -resize 1200x1200> -quality 80 output/IMG_1.jpg"
+
+	job "heic: true"
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "run without heif-convert succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "fallback reported" grep -q "note: heif-convert is not installed; gm reads the HEIC photos" "$dir/out.txt"
+	check "gm reads the HEIC" has_call "convert IMG_1.heic -resize 1200x1200> -quality 80 output/IMG_1.jpg"
+	check "other files still converted" count_calls convert 6
+
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "run with heif-convert succeeds" env PATH="$root/test/fakecodec:$PATH" imageslim run "$dir/job.yaml" >/dev/null
+	check "heif-convert decodes to PNG" has_call "heif-convert IMG_1.heic output/.imageslim-partial-IMG_1.heic.png"
+	check "gm converts the PNG to JPEG" has_call "convert output/.imageslim-partial-IMG_1.heic.png -resize 1200x1200> -quality 80 output/IMG_1.jpg"
+	check "JPEG written" is_converted "$dir/photos/output/IMG_1.jpg"
+	check "decoded PNG removed" test -z "$(find "$dir/photos/output" -name '.imageslim-partial-*')"
+
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "heic to webp succeeds" env PATH="$root/test/fakecodec:$PATH" imageslim run -format webp "$dir/job.yaml" >/dev/null
+	check "cwebp encodes the decoded photo" grep -q fake-cwebp "$dir/photos/output/IMG_1.webp"
+
+	rm -rf "$dir/photos/output"
+	check "failing decoder fails the file" not env PATH="$root/test/fakecodec:$PATH" FAKEENC_FAIL=1 imageslim run "$dir/job.yaml" >/dev/null 2>&1
+
+	job "heic: true" "mode: overwrite"
+	check "overwrite mode rejected" not imageslim run "$dir/job.yaml" >/dev/null 2>&1
+
+	job "heic: true"
+	rm -rf "$dir/photos/output"
+	cp "$dir/photos/a.jpg" "$dir/photos/IMG_1.jpg"
+	check "collision rejected" not imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "collision explained" grep -q "are both written to output/IMG_1.jpg" "$dir/err.txt"
+}
+
+# gif writes a 1×1 GIF with $2 frames to $1.
+gif() {
+	{
+		printf 'GIF89a\001\000\001\000\000\000\000'
+		for _ in $(seq "$2"); do
+			printf '\054\000\000\000\000\001\000\001\000\000\002\002\104\001\000'
+		done
+		printf '\073'
+	} >"$1"
+}
+
+test_animated_gif() {
+	setup animated_gif
+	gif "$dir/photos/anim.gif" 2
+	gif "$dir/photos/still.gif" 1
+	job 'patterns: ["*.gif"]'
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "animated GIF resized frame by frame" has_call "convert anim.gif -coalesce -resize 1200x1200> -deconstruct -quality 80 output/anim.gif"
+	check "still GIF converted the same way" has_call "convert still.gif -coalesce -resize 1200x1200> -deconstruct -quality 80 output/still.gif"
+
+	job 'patterns: ["*.gif"]' "animated_gif: skip"
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "skip run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "animated GIF left alone" not_call "convert anim.gif -coalesce -resize 1200x1200> -deconstruct -quality 80 output/anim.gif"
+	check "still GIF converted" is_converted "$dir/photos/output/still.gif"
+	check "skip counted" grep -q "skipped 1 animated GIF(s)" "$dir/out.txt"
+
+	job 'patterns: ["*.gif"]' "format: webp"
+	rm -rf "$dir/photos/output"
+	check "webp run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "animated GIF not turned into a still" test ! -e "$dir/photos/output/anim.webp"
+	check "still GIF converted to WebP" is_converted "$dir/photos/output/still.webp"
+
+	job "animated_gif: sometimes"
+	check "unknown setting rejected" not imageslim run "$dir/job.yaml" >/dev/null 2>&1
+}
+
+test_report() {
+	setup report
+	job "report: ./report.jsonl"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "a row per file" test "$(wc -l <"$dir/report.jsonl")" -eq 4
+	check "converted row" grep -q '"path":"sub/c.png","status":"converted","output":"output/sub/c.png","bytes_in":' "$dir/report.jsonl"
+	check "rerun succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "rows appended" test "$(grep -c '"path":"a.jpg"' "$dir/report.jsonl")" -eq 2
+	check "already processed rows" test "$(grep -c '"status":"already-processed"' "$dir/report.jsonl")" -eq 4
+
+	rm -rf "$dir/photos/output"
+	cp "$dir/report.jsonl" "$dir/before.jsonl"
+	FAKEGM_FAIL='a.jpg' imageslim run -report "$dir/failed.jsonl" "$dir/job.yaml" >/dev/null 2>&1
+	check "failure row" grep -q '"path":"a.jpg","status":"failed",.*"error":"a.jpg: exit status 1"' "$dir/failed.jsonl"
+	check "-report override replaces the job's file" cmp -s "$dir/before.jsonl" "$dir/report.jsonl"
+
+	rm -rf "$dir/photos/output"
+	# A 3×2 PNG's signature and header chunk, all a report reads.
+	printf '\211PNG\r\n\032\n\000\000\000\rIHDR\000\000\000\003\000\000\000\002\010\000\000\000\000\270\037\071\306' >"$dir/photos/sub/c.png"
+	check "CSV report run succeeds" imageslim run -report "$dir/report.csv" "$dir/job.yaml" >/dev/null
+	check "CSV header" test "$(head -n 1 "$dir/report.csv")" = "run,dir,path,status,output,bytes_in,bytes_out,width_in,height_in,width_out,height_out,error,decode_ms,resize_ms,encode_ms,write_ms,backend,psnr,quality"
+	check "CSV converted row" grep -q ",sub/c.png,converted,output/sub/c.png,[0-9]*,[0-9]*,3,2,,," "$dir/report.csv"
+	rm -rf "$dir/photos/output"
+	check "CSV rerun succeeds" imageslim run -report "$dir/report.csv" "$dir/job.yaml" >/dev/null
+	check "CSV header written once" test "$(grep -c '^run,' "$dir/report.csv")" -eq 1
+	check "CSV rows appended" test "$(wc -l <"$dir/report.csv")" -eq 9
+	check "TSV report run succeeds" imageslim run -report "$dir/report.tsv" "$dir/job.yaml" >/dev/null
+	check "TSV columns" grep -q "$(printf '\tpath\tstatus\t')" "$dir/report.tsv"
+
+	# Time spent in gm counts as resize, none of it as a separate encoder.
+	rm -rf "$dir/photos/output"
+	FAKEGM_DELAY=0.3 imageslim run -report "$dir/stages.jsonl" "$dir/job.yaml" >/dev/null
+	check "resize time reported" sh -c "grep '\"path\":\"a.jpg\"' '$dir/stages.jsonl' | grep -Eq '\"resize_ms\":(29[0-9]|[3-9][0-9]{2}|[0-9]{4,})'"
+	check "no encoder time without one" not grep -q '"encode_ms"' "$dir/stages.jsonl"
+	check "CSV stage columns" sh -c "rm -rf '$dir/photos/output' && FAKEGM_DELAY=0.3 imageslim run -report '$dir/stages.csv' '$dir/job.yaml' >/dev/null && grep ',a.jpg,converted,' '$dir/stages.csv' | grep -Eq ',,[0-9]{3,},,[0-9]*,gm,,\$'"
+}
+
+test_report_formats() {
+	setup report-formats
+	job "workers: 1"
+	FAKEGM_FAIL='a.jpg' imageslim run -report "$dir/report.xml" "$dir/job.yaml" >/dev/null 2>&1
+	check "JUnit suite" grep -q '^<testsuite name="imageslim">$' "$dir/report.xml"
+	check "JUnit failure" sh -c "grep -A1 'name=\"a.jpg\"' '$dir/report.xml' | grep -q '<failure message=\"a.jpg: exit status 1\">gm convert: Improper image header (a.jpg).</failure>'"
+	check "JUnit passes" test "$(grep -c '<testcase ' "$dir/report.xml")" -eq 4
+	check "JUnit closed" test "$(tail -n 1 "$dir/report.xml")" = "</testsuites>"
+	imageslim run -report "$dir/report.xml" "$dir/job.yaml" >/dev/null 2>&1
+	check "JUnit replaced by the next run" test "$(grep -c '<testsuites>' "$dir/report.xml")" -eq 1
+	check "skipped files" test "$(grep -c '<skipped message="already-processed">' "$dir/report.xml")" -eq 3
+
+	rm -rf "$dir/photos/output"
+	check "HTML report run succeeds" imageslim run -report "$dir/report.html" "$dir/job.yaml" >/dev/null
+	check "HTML row" grep -q '<tr class="converted"><td>sub/c.png</td><td>converted</td><td>output/sub/c.png</td>' "$dir/report.html"
+	check "HTML closed" test "$(tail -n 1 "$dir/report.html")" = "</html>"
+
+	rm -rf "$dir/photos/output"
+	check "Markdown report run succeeds" imageslim run -report "$dir/report.md" "$dir/job.yaml" >/dev/null
+	check "Markdown header" test "$(head -n 1 "$dir/report.md")" = "| File | Status | Output | Before | After | Saved | Error |"
+	check "Markdown row" grep -q '^| sub/c.png | converted | output/sub/c.png |' "$dir/report.md"
+
+	rm -rf "$dir/photos/output"
+	check "-report-format run succeeds" imageslim run -report "$dir/report.txt" -report-format csv "$dir/job.yaml" >/dev/null
+	check "format from the flag" grep -q '^run,dir,path,status,' "$dir/report.txt"
+	check "bad format rejected" not imageslim run -report-format pdf "$dir/job.yaml" >/dev/null 2>&1
+}
+
+test_baseline() {
+	setup baseline
+	job "baseline: check"
+	check "small run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "too few files to start a baseline" grep -q "too small or failed to start one" "$dir/out.txt"
+	check "no baseline saved" test ! -e "$dir/photos/.imageslim-baseline.json"
+
+	for i in $(seq 10); do
+		printf 'original %0200d\n' 0 >"$dir/photos/sub/e$i.jpg"
+	done
+	rm -rf "$dir/photos/output"
+	check "first full run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "baseline saved" grep -q '"files": 14' "$dir/photos/.imageslim-baseline.json"
+	check "saving reported" grep -q "saved the baseline" "$dir/out.txt"
+	rm -rf "$dir/photos/output"
+	check "similar run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "within the baseline" grep -q "within 10 points of the baseline" "$dir/out.txt"
+
+	# Two bytes per quality point make the outputs barely smaller.
+	rm -rf "$dir/photos/output"
+	check "smaller savings fail the run" not env FAKEGM_QUALITY_BYTES=2 FAKEGM_VERSION="GraphicsMagick 1.3.45" \
+		imageslim run "$dir/job.yaml" >"$dir/out.txt" 2>"$dir/err.txt"
+	check "deviation reported" grep -q "strayed from the baseline of .*: saved [0-9]*% instead of [0-9]*%" "$dir/err.txt"
+	check "gm version change named" grep -q 'gm was "GraphicsMagick 1.3.42.*now "GraphicsMagick 1.3.45"' "$dir/err.txt"
+	check "hint to save a new baseline" grep -q "baseline save" "$dir/err.txt"
+	check "files still converted" is_converted "$dir/photos/output/sub/e10.jpg"
+	rm -rf "$dir/photos/output"
+	check "wider tolerance succeeds" env FAKEGM_QUALITY_BYTES=2 imageslim run -baseline-tolerance 90 "$dir/job.yaml" >/dev/null
+	rm -rf "$dir/photos/output"
+	check "-baseline save succeeds" env FAKEGM_QUALITY_BYTES=2 imageslim run -baseline save "$dir/job.yaml" >/dev/null
+	rm -rf "$dir/photos/output"
+	check "new baseline holds" env FAKEGM_QUALITY_BYTES=2 imageslim run "$dir/job.yaml" >/dev/null
+	rm -rf "$dir/photos/output"
+	check "-baseline off skips the check" imageslim run -baseline off "$dir/job.yaml" >/dev/null
+
+	job "baseline: sometimes"
+	check "invalid baseline rejected" not imageslim run "$dir/job.yaml" 2>/dev/null
+}
+
+test_smart_quality() {
+	setup smart_quality
+	# The fake gm reads a JPEG's quality from its size, and its noise from
+	# the quality: d.jpeg is detailed, so it needs a higher one.
+	job "smart_quality: true" "report: ./report.csv"
+	check "run succeeds" env FAKEGM_QUALITY_BYTES=10 FAKEGM_DETAILED=d.jpeg imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "compared with a lossless reference" has_call "convert a.jpg -resize 1200x1200> -quality 95 output/.imageslim-partial-a.jpg.ref.png"
+	check "first attempt halfway" has_call "convert a.jpg -resize 1200x1200> -quality 67 output/a.jpg"
+	check "simple image gets a low quality" grep -q "^a.jpg: quality 53, SSIM 0.99" "$dir/out.txt"
+	check "detailed image keeps a high one" grep -q "^sub/deep/d.jpeg: quality 89, SSIM 0.99" "$dir/out.txt"
+	check "at most six attempts and the final encode" count_calls "convert a.jpg -resize" 8
+	check "output at the chosen quality" [ "$(wc -c <"$dir/photos/output/a.jpg")" -eq $((22 + 530)) ]
+	check "PNGs encoded once at the highest quality" has_call "convert sub/c.png -resize 1200x1200> -quality 95 output/sub/c.png"
+	check "quality reported" grep -q ",a.jpg,converted,.*,53\$" "$dir/report.csv"
+	check "no references left" test -z "$(find "$dir/photos" -name '.imageslim-partial-*')"
+
+	job "mode: overwrite" "smart_quality: true" "quality: 70"
+	: >"$FAKEGM_LOG"
+	check "overwrite run succeeds" env FAKEGM_QUALITY_BYTES=10 imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "job's quality is the highest tried" has_call "convert a.jpg -resize 1200x1200> -quality 55 .imageslim-partial-a.jpg"
+	check "original replaced" is_converted "$dir/photos/a.jpg"
+	check "no partial files left" test -z "$(find "$dir/photos" -name '.imageslim-partial-*')"
+
+	job "smart_quality: true" "target_size: 300KB"
+	check "target size refused" not imageslim run "$dir/job.yaml" 2>"$dir/err.txt"
+	check "reason given" grep -q "smart quality and a target size" "$dir/err.txt"
+	job
+	check "-smart-quality overrides the job" not imageslim run -smart-quality -format webp "$dir/job.yaml" 2>"$dir/err.txt"
+	check "only for JPEGs" grep -q "chooses the quality of JPEGs, not of WEBP output" "$dir/err.txt"
+}
+
+test_target_size() {
+	setup target_size
+	# The fake gm writes quality × 1000 bytes: 80 is 80 kB, 50 is 50 kB.
+	job "target_size: 52KB"
+	check "run succeeds" env FAKEGM_QUALITY_BYTES=1000 imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "quality lowered in steps of 5" has_call "convert a.jpg -resize 1200x1200> -quality 75 output/a.jpg"
+	check "stops at the first quality that fits" has_call "convert a.jpg -resize 1200x1200> -quality 50 output/a.jpg"
+	check "no attempt below the fit" not_call "convert a.jpg -resize 1200x1200> -quality 45 output/a.jpg"
+	check "PNGs encoded once" count_calls "convert sub/c.png" 1
+	check "JPEGs encoded until they fit" count_calls convert 22
+	check "output under the target" [ "$(wc -c <"$dir/photos/output/a.jpg")" -le 52000 ]
+	check "chosen quality reported" grep -q "a.jpg: quality 50" "$dir/out.txt"
+
+	rm -rf "$dir/photos/output"
+	job "target_size: 30KB" "min_quality: 60"
+	: >"$FAKEGM_LOG"
+	check "unreachable target still succeeds" env FAKEGM_QUALITY_BYTES=1000 imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "stops at the minimum quality" count_calls convert 16
+	check "files above the target reported" grep -q "3 still above the target size" "$dir/out.txt"
+
+	job "mode: overwrite" "backup: true" "target_size: 52KB"
+	: >"$FAKEGM_LOG"
+	check "overwrite run succeeds" env FAKEGM_QUALITY_BYTES=1000 imageslim run "$dir/job.yaml" >/dev/null
+	check "originals re-encoded from the original" has_call "convert a.jpg -resize 1200x1200> -quality 50 .imageslim-partial-a.jpg"
+	check "original replaced" is_converted "$dir/photos/a.jpg"
+	check "no partial files left" [ -z "$(find "$dir/photos" -name '.imageslim-partial-*')" ]
+	check "original backed up" is_original "$dir/photos/.imageslim-backup/a.jpg"
+
+	job "target_size: lots"
+	checks=$((checks + 1))
+	if imageslim run "$dir/job.yaml" >/dev/null 2>&1; then
+		fail "job with an invalid target size should be rejected"
+	fi
+}
+
+test_modified_dates() {
+	setup modified_dates
+	touch -d 2020-01-15 "$dir/photos/a.jpg" "$dir/photos/B.JPG"
+	touch -d 2020-03-01 "$dir/photos/sub/c.png"
+	job "modified_after: 30d"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "only the recent file converted" count_calls convert 1
+	check "recent file output" is_converted "$dir/photos/output/sub/deep/d.jpeg"
+
+	rm -rf "$dir/photos/output"
+	job "modified_after: 2020-01-01" "modified_before: 2020-02-01"
+	: >"$FAKEGM_LOG"
+	check "date range run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "files from January converted" count_calls convert 2
+	check "January file output" is_converted "$dir/photos/output/B.JPG"
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	check "override run succeeds" imageslim run -modified-after none -modified-before 2020-03-02 "$dir/job.yaml" >/dev/null
+	check "-modified-after none lifts the lower limit" count_calls convert 3
+
+	for bad in "modified_after: yesterday" "modified_before: 2026-13-01" "modified_after: 2020-02-01
+modified_before: 2020-01-01"; do
+		job "$bad"
+		checks=$((checks + 1))
+		if imageslim run "$dir/job.yaml" >/dev/null 2>&1; then
+			fail "job with '$bad' should be rejected"
+		fi
+	done
+}
+
+test_invalid_input() {
+	setup invalid_input
+	for bad in "resize: 12OOx800" "resize: 0x0" "resize: 100x100<>" "resize: '-flatten'" \
+		"resize: 999999x1" "quality: 101" "quality: -5" "patterns: ['sub/*.jpg']" "patterns: ['[a-']"; do
+		job "$bad"
+		checks=$((checks + 1))
+		if imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"; then
+			fail "job with '$bad' should be rejected"
+		fi
+	done
+	check "nothing reached gm" count_calls convert 0
+	for good in "resize: 800x" "resize: x600" "resize: 50%" "resize: 1000000@" "resize: 640x480!" \
+		"quality: 1" "quality: 100"; do
+		job "$good"
+		check "job with '$good' accepted" imageslim run -force "$dir/job.yaml" >/dev/null
+	done
+	job "resize: 4000x4000<" "upscale: true"
+	: >"$FAKEGM_LOG"
+	check "enlarge-only run succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "explicit < is not combined with >" has_call "convert a.jpg -resize 4000x4000< -quality 80 output/a.jpg"
+}
+
+test_unicode_paths() {
+	setup unicode_paths
+	mkdir -p "$dir/photos/fötos/日本 旅行"
+	printf 'original\n' >"$dir/photos/fötos/日本 旅行/café 1.jpg"
+	printf "original\n" >"$dir/photos/it's \$HOME.jpg"
+	job
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "unicode path converted" is_converted "$dir/photos/output/fötos/日本 旅行/café 1.jpg"
+	check "shell characters kept literal" is_converted "$dir/photos/output/it's \$HOME.jpg"
+	check "unicode path passed as one argument" has_call "convert fötos/日本 旅行/café 1.jpg -resize 1200x1200> -quality 80 output/fötos/日本 旅行/café 1.jpg"
+}
+
+test_dash_names() {
+	setup dash_names
+	printf 'original dash\n' >"$dir/photos/-write.jpg"
+	mkdir "$dir/photos/-dir"
+	printf 'original dash\n' >"$dir/photos/-dir/e.jpg"
+	printf 'original dash\n' >"$dir/photos/sub/-f.jpg"
+	job "mode: overwrite" "workers: 1"
+	check "run fails" not imageslim run "$dir/job.yaml" >"$dir/out.txt" 2>"$dir/err.txt"
+	check "file refused" grep -q -- '-write.jpg: a path starting with "-" would be read as an option' "$dir/err.txt"
+	check "folder refused" grep -q -- '-dir/e.jpg: a path starting with "-"' "$dir/err.txt"
+	check "never handed to gm" not grep -q -- " -write.jpg\| -dir/" "$FAKEGM_LOG"
+	check "files left alone" is_original "$dir/photos/-write.jpg"
+	check "deeper names converted" has_call "mogrify -resize 1200x1200> -quality 80 sub/-f.jpg"
+	check "others converted" is_converted "$dir/photos/a.jpg"
+}
+
+test_long_paths() {
+	setup long_paths
+	local deep="" part
+	part=$(printf 'folder-%043d' 0)
+	for _ in 1 2 3 4 5 6; do deep="$deep/$part"; done
+	deep="${deep#/}"
+	mkdir -p "$dir/photos/$deep"
+	printf 'original photo\n' >"$dir/photos/$deep/photo.jpg"
+	check "path beyond 260 characters" test "${#deep}" -gt 260
+	job
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "long path converted" is_converted "$dir/photos/output/$deep/photo.jpg"
+	check "long path passed whole" has_call "convert $deep/photo.jpg -resize 1200x1200> -quality 80 output/$deep/photo.jpg"
+	job "mode: overwrite" "backup: true"
+	check "overwrite run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "long path backed up" is_original "$dir/photos/.imageslim-backup/$deep/photo.jpg"
+	check "restore succeeds" imageslim restore "$dir/photos" >/dev/null
+	check "long path restored" is_original "$dir/photos/$deep/photo.jpg"
+}
+
+test_gm_path_flag() {
+	setup gm_path_flag
+	job
+	mkdir -p "$dir/bin"
+	cp "$root/test/fakegm/gm" "$dir/bin/fake-gm"
+	check "run with -gm-path succeeds" env PATH="$work/bin:/usr/bin:/bin" \
+		imageslim run -gm-path "$dir/bin/fake-gm" "$dir/job.yaml" >/dev/null
+	check "files converted" is_converted "$dir/photos/output/a.jpg"
+	if imageslim run -gm-path "$dir/missing-gm" "$dir/job.yaml" >/dev/null 2>&1; then
+		fail "a missing -gm-path should fail before running"
+	fi
+}
+
+test_gm_version() {
+	setup gm_version
+	job 'gm_version: "1.3.42"'
+	check "pinned version runs" imageslim run "$dir/job.yaml" >/dev/null
+	rm -rf "$dir/photos/output"
+	check "other version refused" not env FAKEGM_VERSION="GraphicsMagick 1.3.45 2024-08-27 Q16" \
+		imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "mismatch named" grep -q "is GraphicsMagick 1.3.45, but version 1.3.42 is pinned" "$dir/err.txt"
+	check "hint to override" grep -q -- "-gm-version any" "$dir/err.txt"
+	check "nothing converted" test ! -e "$dir/photos/output/a.jpg"
+	check "-gm-version any overrides the job" env FAKEGM_VERSION="GraphicsMagick 1.3.45 2024-08-27 Q16" \
+		imageslim run -gm-version any "$dir/job.yaml" >/dev/null
+	rm -rf "$dir/photos/output"
+	check "-gm-version prefix accepted" env FAKEGM_VERSION="GraphicsMagick 1.3.45 2024-08-27 Q16" \
+		imageslim run -gm-version 1.3 "$dir/job.yaml" >/dev/null
+	rm -rf "$dir/photos/output"
+	check "prefix stops at a dot" not env FAKEGM_VERSION="GraphicsMagick 1.30 2030-01-01 Q16" \
+		imageslim run -gm-version 1.3 "$dir/job.yaml" >/dev/null 2>&1
+
+	job
+	check "environment pin applies" not env IMAGESLIM_GM_VERSION=1.4 imageslim run "$dir/job.yaml" >/dev/null 2>&1
+	check "batch honours the pin" not imageslim batch -gm-version 1.4 "$dir/job.yaml" >/dev/null 2>&1
+	check "invalid pin rejected" not imageslim run -gm-version latest "$dir/job.yaml" >/dev/null 2>&1
+}
+
+test_batch_report() {
+	setup batch_report
+	job
+	cp "$dir/job.yaml" "$dir/one.yaml"
+	job "scope: flat" "mode: overwrite"
+	cp "$dir/job.yaml" "$dir/two.yaml"
+	check "batch succeeds" imageslim batch -parallel 2 "$dir/one.yaml" "$dir/two.yaml" >"$dir/out.txt"
+	check "report lists both jobs" test "$(grep -c '  ok  ' "$dir/out.txt")" -eq 2
+	check "failed files fail the batch" not env FAKEGM_FAIL='a.jpg' imageslim batch -force "$dir/one.yaml" >"$dir/out.txt"
+	check "failed files counted" grep -q "failed .*1 file(s) failed" "$dir/out.txt"
+}
+
+# exif_jpeg writes a tiny JPEG to $1 whose EXIF orientation tag is $2
+# (1–8), stored big-endian at byte 30.
+exif_jpeg() {
+	printf '\377\330\377\341\000\042Exif\000\000MM\000\052\000\000\000\010\000\001\001\022\000\003\000\000\000\001\000'"$(printf '\\%03o' "$2")"'\000\000\000\000\000\000\377\332\000\002fake\377\331' >"$1"
+}
+
+# orientation prints the EXIF orientation tag exif_jpeg wrote to $1.
+orientation() { od -An -tu1 -j31 -N1 "$1" | tr -d ' '; }
+
+test_orient() {
+	setup orient
+	exif_jpeg "$dir/photos/a.jpg" 6
+	exif_jpeg "$dir/photos/sub/deep/d.jpeg" 3
+	exif_jpeg "$dir/photos/B.JPG" 1
+	exif_jpeg "$dir/photos/sub/odd.jpg" 8
+	cp "$dir/photos/a.jpg" "$dir/a.orig"
+	export PATH="$root/test/fakejpeg:$PATH"
+
+	check "dry run succeeds" imageslim orient -dry-run "$dir/photos" >"$dir/out.txt"
+	check "dry run lists the file" grep -qx "  a.jpg" "$dir/out.txt"
+	check "dry run changes nothing" cmp -s "$dir/photos/a.jpg" "$dir/a.orig"
+	check "dry run runs no jpegtran" count_calls jpegtran 0
+
+	check "orient succeeds" env FAKEJPEG_IMPERFECT='odd.*' imageslim orient "$dir/photos" >"$dir/out.txt"
+	check "rotated by 90" has_call "jpegtran -copy all -perfect -rotate 90 -outfile $dir/photos/.imageslim-partial-a.jpg $dir/photos/a.jpg"
+	check "rotated by 180" has_call "jpegtran -copy all -perfect -rotate 180 -outfile $dir/photos/sub/deep/.imageslim-partial-d.jpeg $dir/photos/sub/deep/d.jpeg"
+	check "tag reset" test "$(orientation "$dir/photos/a.jpg")" = 1
+	check "upright file left alone" count_calls jpegtran 3
+	check "original backed up" cmp -s "$dir/photos/.imageslim-backup/a.jpg" "$dir/a.orig"
+	check "imperfect file left alone" test "$(orientation "$dir/photos/sub/odd.jpg")" = 8
+	check "imperfect file reported" grep -q "sub/odd.jpg  left alone" "$dir/out.txt"
+	check "summary printed" grep -q "Turned 2 file(s) upright" "$dir/out.txt"
+	check "no gm calls" count_calls convert 0
+
+	check "restore succeeds" imageslim restore "$dir/photos" >/dev/null
+	check "original restored" cmp -s "$dir/photos/a.jpg" "$dir/a.orig"
+	check "without jpegtran" not env PATH="$work/bin:/usr/bin:/bin" imageslim orient "$dir/photos" 2>"$dir/err.txt"
+	check "install hint" grep -q "jpegtran is not installed" "$dir/err.txt"
+	export PATH="${PATH#"$root/test/fakejpeg:"}"
+}
+
+test_s3() {
+	setup s3
+	mkdir -p "$dir/bucket/photos/output" "$dir/tmp"
+	mv "$dir/photos"/* "$dir/bucket/photos/"
+	printf 'original old\n' >"$dir/bucket/photos/output/old.jpg"
+	export FAKEAWS_ROOT="$dir" PATH="$root/test/fakeaws:$PATH"
+	printf 'name: s3\ndir: s3://bucket/photos\n' >"$dir/job.yaml"
+
+	check "run succeeds" env TMPDIR="$dir/tmp" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	for f in a.jpg B.JPG sub/c.png sub/deep/d.jpeg; do
+		check "output/$f uploaded" is_converted "$dir/bucket/photos/output/$f"
+		check "$f untouched" is_original "$dir/bucket/photos/$f"
+	done
+	check "only matching objects converted" count_calls convert 4
+	check "earlier output left alone" test ! -e "$dir/bucket/photos/output/output"
+	check "manifest not uploaded" test ! -e "$dir/bucket/photos/output/.imageslim-manifest"
+	check "download logged" grep -q "^\[s3\] download s3://bucket/photos/$" "$dir/out.txt"
+	check "upload logged" grep -q "^\[s3\] upload s3://bucket/photos/output/$" "$dir/out.txt"
+	check "temporary directory removed" test -z "$(ls "$dir/tmp")"
+
+	printf 'dir: s3://bucket/photos\nmode: overwrite\nscope: flat\nupload: s3://bucket/web\n' >"$dir/job.yaml"
+	: >"$FAKEGM_LOG"
+	check "overwrite run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "top-level files only" count_calls mogrify 2
+	check "uploaded to the other prefix" is_converted "$dir/bucket/web/a.jpg"
+	check "upper-case extension matched" is_converted "$dir/bucket/web/B.JPG"
+	check "subfolders not uploaded" test ! -e "$dir/bucket/web/sub"
+	check "source prefix untouched" is_original "$dir/bucket/photos/a.jpg"
+
+	printf 'dir: s3://bucket/photos\nmode: overwrite\n' >"$dir/job.yaml"
+	: >"$FAKEGM_LOG"
+	check "in-place run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "objects replaced" is_converted "$dir/bucket/photos/sub/c.png"
+	check "manifest kept with them" test -s "$dir/bucket/photos/.imageslim-manifest"
+	: >"$FAKEGM_LOG"
+	check "second in-place run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "nothing encoded again" count_calls mogrify 0
+	printf 'original new\n' >"$dir/bucket/photos/a.jpg"
+	check "run after a change succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "changed object converted" has_call "mogrify -resize 1200x1200> -quality 80 a.jpg"
+	check "only that one" count_calls mogrify 1
+
+	printf 'dir: s3://nobucket/photos\nhooks:\n  on_error: ["pwd >on_error.txt"]\n' >"$dir/job.yaml"
+	: >"$FAKEGM_LOG"
+	check "missing bucket fails" not imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "transfer named" grep -q "download s3://nobucket/photos/" "$dir/err.txt"
+	check "nothing converted" count_calls convert 0
+	check "on_error run beside the job file" test "$(cat "$dir/on_error.txt")" = "$dir"
+
+	printf 'dir: s3://bucket/photos\nmode: overwrite\nbackup: true\n' >"$dir/job.yaml"
+	check "backup refused" not imageslim run "$dir/job.yaml" 2>"$dir/err.txt"
+	check "versioning suggested" grep -q "versioning" "$dir/err.txt"
+	printf 'dir: ./photos\nupload: s3://bucket/web\n' >"$dir/job.yaml"
+	check "upload without S3 dir refused" not imageslim run "$dir/job.yaml" 2>/dev/null
+	export PATH="${PATH#"$root/test/fakeaws:"}"
+	unset FAKEAWS_ROOT
+}
+
+test_timeout() {
+	setup timeout
+	job "mode: overwrite" "timeout: 1s"
+	export FAKEGM_SLOW='c.png'
+	started=$(date +%s)
+	check "run fails" not imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "stopped in time" test $(($(date +%s) - started)) -lt 10
+	check "timeout reported" grep -q "took longer than its 1s timeout" "$dir/err.txt"
+	check "delegate killed" gone "$(head -1 "$FAKEGM_LOG.slow")"
+	check "earlier files kept" is_converted "$dir/photos/a.jpg"
+	check "later files untried" is_original "$dir/photos/sub/deep/d.jpeg"
+
+	job "mode: overwrite"
+	imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt" &
+	pid=$!
+	for _ in $(seq 50); do
+		[ "$(wc -l <"$FAKEGM_LOG.slow")" -ge 2 ] && break
+		sleep 0.1
+	done
+	kill -TERM "$pid"
+	wait "$pid"
+	check "interrupted run fails" test $? -eq 1
+	check "cancellation reported" grep -q "the run was cancelled" "$dir/err.txt"
+	check "delegate killed on interrupt" gone "$(sed -n 2p "$FAKEGM_LOG.slow")"
+	unset FAKEGM_SLOW
+
+	: >"$FAKEGM_LOG"
+	check "rerun carries on" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "converted files skipped" grep -q "skipped 2 already processed" "$dir/out.txt"
+	check "bad timeout refused" not imageslim run -timeout soon "$dir/job.yaml" 2>/dev/null
+}
+
+test_disk_space() {
+	setup disk_space
+	job
+	# A sparse file: far larger than any disk, yet it takes up no room.
+	truncate -s 10T "$dir/photos/huge.jpg"
+	check "run refused" not imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "space shortfall explained" grep -q "output may need up to 11.0 TB, as much as the originals, but only .* is free on its disk" "$dir/err.txt"
+	check "override suggested" grep -q "hint: .*-ignore-disk-space" "$dir/err.txt"
+	check "nothing converted" test ! -e "$dir/photos/output/a.jpg"
+
+	check "override runs" imageslim run -ignore-disk-space "$dir/job.yaml" >"$dir/out.txt" 2>&1
+	check "override warns" grep -q "warning: .*going ahead as the space check is off" "$dir/out.txt"
+	check "files converted" is_converted "$dir/photos/output/a.jpg"
+
+	job "ignore_disk_space: true"
+	rm -rf "$dir/photos/output"
+	check "job setting runs" imageslim run "$dir/job.yaml" >/dev/null 2>&1
+	job "mode: overwrite" "backup: false"
+	check "overwrite mode not checked" imageslim run "$dir/job.yaml" >/dev/null 2>&1
+}
+
+test_fallback() {
+	setup fallback
+	job "fallback: magick" "report: ./report.jsonl"
+	local with_magick="$root/test/fakemagick:$PATH"
+	check "run succeeds" env PATH="$with_magick" FAKEGM_FAIL='a.jpg' imageslim run "$dir/job.yaml" >"$dir/out.txt" 2>&1
+	check "magick retries the file" has_call "magick a.jpg -resize 1200x1200> -quality 80 output/a.jpg"
+	check "magick's output kept" grep -q '^fake-magick a.jpg' "$dir/photos/output/a.jpg"
+	check "other files by gm" is_converted "$dir/photos/output/B.JPG"
+	check "failover noted" grep -q "note: a.jpg: gm failed (exit status 1); trying magick" "$dir/out.txt"
+	check "summary counts it" grep -q "1 converted by the fallback after gm failed" "$dir/out.txt"
+	check "report names magick" grep -q '"path":"a.jpg",.*"backend":"magick"' "$dir/report.jsonl"
+	check "report names gm" grep -q '"path":"B.JPG",.*"backend":"gm"' "$dir/report.jsonl"
+
+	check "both failing fails the run" not env PATH="$with_magick" FAKEGM_FAIL='a.jpg' FAKEMAGICK_FAIL='a.jpg' imageslim run -force "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "both errors reported" grep -q "a.jpg: exit status 1; magick: exit status 1" "$dir/err.txt"
+	check "missing magick noted" sh -c "FAKEGM_FAIL='a.jpg' imageslim run -force '$dir/job.yaml' 2>&1 | grep -q 'magick is not installed'"
+
+	job "mode: overwrite" "backup: false"
+	check "-fallback override runs" env PATH="$with_magick" FAKEGM_FAIL='a.jpg' imageslim run -fallback magick "$dir/job.yaml" >/dev/null 2>&1
+	check "magick mogrify in overwrite mode" has_call "magick mogrify -resize 1200x1200> -quality 80 a.jpg"
+	check "unknown fallback rejected" not imageslim run -fallback convert "$dir/job.yaml" 2>/dev/null
+}
+
+test_on_conflict() {
+	setup on_conflict
+	mkdir -p "$dir/photos/output"
+	echo foreign >"$dir/photos/output/a.jpg"
+	job "on_conflict: skip"
+	check "skip run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "existing file kept" grep -qx foreign "$dir/photos/output/a.jpg"
+	check "others converted" is_converted "$dir/photos/output/B.JPG"
+	check "skip counted" grep -q "skipped 1 whose output exists" "$dir/out.txt"
+	if [ -w /dev/full ]; then
+		job "on_conflict: skip" 'patterns: ["a.jpg"]' "report: /dev/full"
+		check "unwritable report row fails the run" not imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+		check "report error named" grep -q "report: write /dev/full: no space left on device" "$dir/err.txt"
+	fi
+
+	job "on_conflict: rename"
+	check "rename run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "output renamed" is_converted "$dir/photos/output/a-1.jpg"
+	check "existing file still kept" grep -qx foreign "$dir/photos/output/a.jpg"
+	check "rename counted" grep -q "1 renamed since the output existed" "$dir/out.txt"
+	check "renamed output resumed" sh -c "imageslim run '$dir/job.yaml' | grep -q 'skipped 4 already processed'"
+	check "forced run reuses the name" imageslim run -force "$dir/job.yaml" >/dev/null
+	check "no second suffix" test ! -e "$dir/photos/output/a-2.jpg"
+
+	job
+	check "overwrite run succeeds" imageslim run -force "$dir/job.yaml" >"$dir/out.txt"
+	check "existing file replaced" is_converted "$dir/photos/output/a.jpg"
+	check "replace counted" grep -q "1 existing output(s) replaced" "$dir/out.txt"
+	check "unknown policy rejected" not imageslim run -on-conflict keep "$dir/job.yaml" 2>/dev/null
+}
+
+test_case_clash() {
+	setup case_clash
+	printf 'original A.jpg %0200d\n' 0 >"$dir/photos/A.jpg"
+	job "workers: 1"
+	check "run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "first output keeps its name" has_call "convert A.jpg -resize 1200x1200> -quality 80 output/A.jpg"
+	check "clashing output renamed" has_call "convert a.jpg -resize 1200x1200> -quality 80 output/a-1.jpg"
+	check "no output named a.jpg" test ! -e "$dir/photos/output/a.jpg"
+	check "clash warned about" grep -q "warning: a.jpg: output output/a.jpg differs from A.jpg's only in case; writing output/a-1.jpg" "$dir/out.txt"
+	check "clash counted" grep -q "1 output name(s) differed from another only in case" "$dir/out.txt"
+	check "renamed output resumed" sh -c "imageslim run '$dir/job.yaml' | grep -q 'skipped 5 already processed'"
+
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	job "workers: 1" "on_conflict: skip" "report: $dir/report.jsonl"
+	check "skip run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "clashing file left alone" not_call "convert a.jpg -resize 1200x1200> -quality 80 output/a.jpg"
+	check "the others converted" count_calls convert 4
+	check "skip counted" grep -q "skipped 1 whose output exists" "$dir/out.txt"
+	check "report names the clash" grep -q '"path":"a.jpg","status":"output-exists".*"messages":\[".*differs from A.jpg.s only in case; skipped"\]' "$dir/report.jsonl"
+}
+
+test_gen_testdata() {
+	setup gen_testdata
+	check "generate succeeds" imageslim gen-testdata -quiet "$dir/gen" >"$dir/out.txt"
+	check "summary printed" grep -q "Wrote 26 files" "$dir/out.txt"
+	check "job written" test -f "$dir/gen/imageslim-job.yaml"
+	check "awkward names written" test -f "$dir/gen/edge cases/-leading-dash.jpg"
+	check "non-empty directory refused" not imageslim gen-testdata "$dir/gen" 2>/dev/null
+	check "same seed, same files" imageslim gen-testdata -quiet "$dir/again" >/dev/null
+	check "files identical" cmp -s "$dir/gen/photos/beach.jpg" "$dir/again/photos/beach.jpg"
+	check "other seed, other files" imageslim gen-testdata -quiet -seed 2 "$dir/other" >/dev/null
+	check "files differ" not cmp -s "$dir/gen/photos/beach.jpg" "$dir/other/photos/beach.jpg"
+
+	check "generated job runs" sh -c "cd '$dir/gen' && imageslim run imageslim-job.yaml >'$dir/run.txt'"
+	check "every image converted" count_calls convert 24
+	check "awkward name converted" is_converted "$dir/gen/output/edge cases/quote's \"and\" [brackets] & \$dollar.jpg"
+	check "unmatched files counted" grep -q "Not processed: 3 of 27 files" "$dir/run.txt"
+}
+
+test_verify() {
+	setup verify
+	job "verify: header" "workers: 1"
+	check "truncated output fails the run" not env FAKEGM_TRUNCATE='a.jpg' imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "failure explained" grep -q "a.jpg: output failed verification (identify: exit status 1); removed it" "$dir/err.txt"
+	check "broken output removed" test ! -e "$dir/photos/output/a.jpg"
+	check "header check passes a cut-short file" env FAKEGM_CORRUPT='a.jpg' imageslim run -force "$dir/job.yaml" >/dev/null
+
+	rm -rf "$dir/photos/output"
+	job "verify: full" "workers: 1"
+	check "full decode fails the run" not env FAKEGM_CORRUPT='a.jpg' imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "gm's warning reported" grep -q "a.jpg: output failed verification (gm identify: Corrupt JPEG data" "$dir/err.txt"
+	check "good outputs kept" is_converted "$dir/photos/output/B.JPG"
+
+	job "mode: overwrite" "backup: true" "verify: full"
+	check "overwrite run fails" not env FAKEGM_TRUNCATE='a.jpg' imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "original restored" is_original "$dir/photos/a.jpg"
+	check "restore reported" grep -q "restored the original from the backup" "$dir/err.txt"
+	check "-verify none skips the check" env FAKEGM_TRUNCATE='a.jpg' imageslim run -verify none "$dir/job.yaml" >/dev/null
+	check "unknown verify rejected" not imageslim run -verify deep "$dir/job.yaml" 2>/dev/null
+}
+
+test_min_psnr() {
+	setup min_psnr
+	job "min_psnr: 35" "sharpen: on" "report: ./report.csv"
+	check "run with a blurry output succeeds" env FAKEGM_BLURRY=a.jpg imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "reference rendered with the run's options" grep -q "^convert a.jpg -resize 1200x1200> -unsharp .* output/.imageslim-partial-a.jpg.ref.png\$" "$FAKEGM_LOG"
+	check "output compared" has_call "compare -metric PSNR $dir/photos/output/.imageslim-partial-a.jpg.ref.png $dir/photos/output/a.jpg"
+	check "blurry file warned about" grep -q "warning: a.jpg: PSNR 28.4 dB is below the minimum of 35.0 dB" "$dir/out.txt"
+	check "others not warned about" not grep -q "warning: B.JPG" "$dir/out.txt"
+	check "summary counts it" grep -q "1 below the minimum PSNR" "$dir/out.txt"
+	check "still converted" is_converted "$dir/photos/output/a.jpg"
+	check "report column" grep -q ",psnr,quality\$" "$dir/report.csv"
+	check "report value" grep -q ",a.jpg,converted,.*,28.4,\$" "$dir/report.csv"
+	check "no references left" test -z "$(find "$dir/photos" -name '*.ref.png')"
+
+	rm -rf "$dir/photos/output"
+	job "min_psnr: 35" 'patterns: ["*.gif", "*.jpg"]'
+	printf 'GIF89a\n' >"$dir/photos/anim.gif"
+	: >"$FAKEGM_LOG"
+	check "overridden off" imageslim run -min-psnr none "$dir/job.yaml" >/dev/null
+	check "nothing compared" count_calls compare 0
+	rm -rf "$dir/photos/output"
+	imageslim run "$dir/job.yaml" >/dev/null
+	check "JPEGs compared" grep -q "^compare .*/a.jpg\$" "$FAKEGM_LOG"
+	check "GIFs not compared" not grep -q "compare .*anim.gif" "$FAKEGM_LOG"
+	job "min_psnr: 0.5dB"
+	check "bad threshold refused" not imageslim run "$dir/job.yaml" >/dev/null 2>&1
+	job "min_psnr: loud"
+	check "nonsense refused" not imageslim run "$dir/job.yaml" 2>"$dir/err.txt"
+	check "reason given" grep -q "minimum PSNR must be a number of decibels" "$dir/err.txt"
+}
+
+test_symlinks() {
+	setup symlinks
+	mkdir -p "$dir/shared"
+	printf 'original e.jpg %0200d\n' 0 >"$dir/shared/e.jpg"
+	ln -s ../shared "$dir/photos/albums"
+	ln -s ../../shared "$dir/photos/sub/again"
+	ln -s .. "$dir/photos/sub/loop"
+	ln -s a.jpg "$dir/photos/z.jpg"
+	job
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "links skipped by default" count_calls convert 4
+	check "linked directory not walked" test ! -e "$dir/photos/output/albums"
+
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	job "follow_symlinks: follow"
+	check "follow run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "linked directory converted" is_converted "$dir/photos/output/albums/e.jpg"
+	check "linked file converted" is_converted "$dir/photos/output/z.jpg"
+	check "every link followed but the loop" count_calls convert 7
+	check "target untouched" is_original "$dir/shared/e.jpg"
+
+	rm -rf "$dir/photos/output"
+	: >"$FAKEGM_LOG"
+	job "follow_symlinks: process-target-once"
+	check "once run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "each target converted once" count_calls convert 5
+	check "first link kept" is_converted "$dir/photos/output/albums/e.jpg"
+	check "second link left alone" test ! -e "$dir/photos/output/sub/again"
+	check "real path preferred" test ! -e "$dir/photos/output/z.jpg"
+
+	job "mode: overwrite" "backup: false"
+	check "follow refused in overwrite mode" not imageslim run -follow-symlinks follow "$dir/job.yaml" 2>/dev/null
+	check "unknown policy rejected" not imageslim run -follow-symlinks always "$dir/job.yaml" 2>/dev/null
+	: >"$FAKEGM_LOG"
+	check "-follow-symlinks override runs" imageslim run -follow-symlinks once "$dir/job.yaml" >/dev/null
+	check "overwrite converts each target once" count_calls mogrify 5
+}
+
+test_unreadable_folders() {
+	setup unreadable_folders
+	mkdir "$dir/photos/locked"
+	printf 'original e.jpg\n' >"$dir/photos/locked/e.jpg"
+	chmod 000 "$dir/photos/locked"
+	job
+	check "run succeeds" unprivileged imageslim run "$dir/job.yaml" >"$dir/out.txt" 2>&1
+	check "readable files converted" count_calls convert 4
+	check "deeper files converted" is_converted "$dir/photos/output/sub/deep/d.jpeg"
+	check "locked folder warned about" grep -qxF "warning: left out locked, which cannot be read: permission denied" "$dir/out.txt"
+	check "no hint about writing" not grep -q "may not write" "$dir/out.txt"
+
+	chmod 000 "$dir/photos"
+	check "unreadable folder refused" not unprivileged imageslim run "$dir/job.yaml" >/dev/null 2>"$dir/err.txt"
+	check "reading named" grep -q "hint: ImageSlim may not read this folder" "$dir/err.txt"
+	check "writing not" not grep -q "may not write" "$dir/err.txt"
+	chmod 755 "$dir/photos" "$dir/photos/locked"
+}
+
+test_lossless() {
+	setup lossless
+	printf 'exif: camera\n' >>"$dir/photos/a.jpg"
+	cp "$dir/photos/B.JPG" "$dir/B.orig"
+	job "lossless: true"
+	export PATH="$root/test/fakejpeg:$root/test/fakepng:$PATH"
+	check "run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "jpegtran arguments" has_call "jpegtran -copy none -optimize -outfile output/.imageslim-partial-a.jpg a.jpg"
+	check "optipng strips PNGs" has_call "optipng -quiet -o2 -strip all -- output/sub/.imageslim-partial-c.png"
+	check "metadata stripped" not grep -q exif "$dir/photos/output/a.jpg"
+	check "pixels kept" grep -q "^original a.jpg" "$dir/photos/output/a.jpg"
+	check "file that would not shrink copied" cmp -s "$dir/photos/output/B.JPG" "$dir/B.orig"
+	check "PNG optimised" grep -q fake-optipng "$dir/photos/output/sub/c.png"
+	check "no gm conversions" count_calls convert 0
+	check "no partial files left" test -z "$(find "$dir/photos" -name '.imageslim-partial-*')"
+
+	rm -rf "$dir/photos/output"
+	job "mode: overwrite" "lossless: true" "backup: true"
+	check "overwrite run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "stripped in place" not grep -q exif "$dir/photos/a.jpg"
+	check "original backed up" grep -q exif "$dir/photos/.imageslim-backup/a.jpg"
+	: >"$FAKEGM_LOG"
+	check "rerun succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "nothing redone" count_calls jpegtran 0
+
+	job "lossless: true" "sharpen: on"
+	check "sharpening refused" not imageslim run "$dir/job.yaml" 2>"$dir/err.txt"
+	check "reason given" grep -q "lossless mode keeps every pixel" "$dir/err.txt"
+	job "lossless: true" 'patterns: ["*.gif"]'
+	check "other formats refused" not imageslim run "$dir/job.yaml" 2>/dev/null
+	check "without jpegtran" not env PATH="$work/bin:$root/test/fakegm:/usr/bin:/bin" imageslim run -force "$dir/job.yaml" 2>/dev/null
+	export PATH="${PATH#"$root/test/fakejpeg:$root/test/fakepng:"}"
+}
+
+test_exec() {
+	setup exec
+	job "exec: \"echo {in} >>../exec.log; tr a-z A-Z <{in} >{out}\"" "report: ./report.jsonl"
+	check "run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "command run on each file" test "$(wc -l <"$dir/exec.log")" -eq 4
+	check "file paths given" grep -qx "sub/deep/d.jpeg" "$dir/exec.log"
+	check "output written" grep -q "^ORIGINAL A.JPG" "$dir/photos/output/a.jpg"
+	check "tree mirrored" grep -q "^ORIGINAL SUB/C.PNG" "$dir/photos/output/sub/c.png"
+	check "no gm conversions" count_calls convert 0
+	check "no partial files left" test -z "$(find "$dir/photos" -name '.imageslim-partial-*')"
+	check "backend reported" grep -q '"backend":"exec"' "$dir/report.jsonl"
+	check "command shown" grep -qF "tr a-z A-Z <{file} >output/{file}" "$dir/out.txt"
+	: >"$dir/exec.log"
+	check "rerun succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "nothing redone" test ! -s "$dir/exec.log"
+
+	rm -rf "$dir/photos/output"
+	job "exec: \"cp {in} {out}\"" "name_template: \"{name}.webp\""
+	check "named run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "name template applied" is_original "$dir/photos/output/a.webp"
+
+	rm -rf "$dir/photos/output"
+	job "exec: \"test {in} != sub/c.png && cp {in} {out}\""
+	check "failing command fails the run" not imageslim run "$dir/job.yaml" >"$dir/out.txt" 2>&1
+	check "failure listed" grep -qx "         sub/c.png" "$dir/out.txt"
+	check "no output for the failure" test ! -e "$dir/photos/output/sub/c.png"
+	check "others converted" is_original "$dir/photos/output/sub/deep/d.jpeg"
+
+	# bash, which is sh on macOS, expands braces that dash leaves alone.
+	rm -rf "$dir/photos/output" "$dir/photos/sub/c.png"
+	mkdir -p "$dir/bash"
+	ln -s "$(command -v bash)" "$dir/bash/sh"
+	for f in "a{1,2}.jpg" "b,c.jpg" "d{e}.jpg"; do
+		printf 'original %s\n' "$f" >"$dir/photos/$f"
+	done
+	job "exec: \"cp {in} {out}\""
+	check "awkward names run succeeds under bash" env PATH="$dir/bash:$PATH" imageslim run "$dir/job.yaml" >/dev/null
+	check "braces kept" is_original "$dir/photos/output/a{1,2}.jpg"
+	check "comma kept" is_original "$dir/photos/output/b,c.jpg"
+	check "lone braces kept" is_original "$dir/photos/output/d{e}.jpg"
+	check "nothing expanded" test ! -e "$dir/photos/output/a1.jpg"
+	rm "$dir/photos/a{1,2}.jpg" "$dir/photos/b,c.jpg" "$dir/photos/d{e}.jpg"
+
+	job "mode: overwrite" "exec: \"tr a-z A-Z <{in} >{out}\""
+	check "overwrite run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "replaced in place" grep -q "^ORIGINAL B.JPG" "$dir/photos/B.JPG"
+
+	job "exec: \"cp {in} /tmp/x\""
+	check "command without {out} refused" not imageslim run "$dir/job.yaml" 2>"$dir/err.txt"
+	check "placeholders named" grep -q "must contain {in} and {out}" "$dir/err.txt"
+	job "exec: \"cp {in} {out}\"" "sharpen: on"
+	check "gm options refused" not imageslim run "$dir/job.yaml" 2>"$dir/err.txt"
+	check "reason given" grep -q "cannot be combined with sharpening" "$dir/err.txt"
+	job "exec: \"cp {in} {out}\""
+	check "refused in safe mode" not imageslim run -safe-mode "$dir/job.yaml" 2>"$dir/err.txt"
+	check "safe mode names it" grep -q "safe mode forbids an exec command" "$dir/err.txt"
+}
+
+test_steps() {
+	setup steps
+	printf 'logo\n' >"$dir/logo.png"
+	job "steps: [auto_orient, resize 800x800, watermark ./logo.png, strip, optimize]" "quality: 70" "report: ./report.jsonl"
+	check "run succeeds" env PATH="$root/test/fakejpeg:$root/test/fakepng:$PATH" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "orients first" grep -q "^convert a.jpg -auto-orient output/.imageslim-partial-a.jpg.1.miff\$" "$FAKEGM_LOG"
+	check "then resizes" has_call "convert output/.imageslim-partial-a.jpg.1.miff -resize 800x800> output/.imageslim-partial-a.jpg.2.miff"
+	check "then watermarks" grep -q "^composite .* $dir/logo.png output/.imageslim-partial-a.jpg.2.miff output/.imageslim-partial-a.jpg.3.miff\$" "$FAKEGM_LOG"
+	check "then strips" has_call "convert output/.imageslim-partial-a.jpg.3.miff +profile * output/.imageslim-partial-a.jpg.4.miff"
+	check "encoded once" has_call "convert output/.imageslim-partial-a.jpg.4.miff -quality 70 output/.imageslim-partial-a.jpg.5.jpg"
+	check "then optimised" grep -q "^jpegtran -copy all -optimize -outfile output/.imageslim-partial-a.jpg.6.jpg output/.imageslim-partial-a.jpg.5.jpg\$" "$FAKEGM_LOG"
+	check "PNGs optimised" grep -q "^optipng .*output/sub/.imageslim-partial-c.png.6.png\$" "$FAKEGM_LOG"
+	check "last step's file kept" grep -qx fake-optipng "$dir/photos/output/sub/c.png"
+	check "tree mirrored" is_converted "$dir/photos/output/sub/deep/d.jpeg"
+	check "no partial files left" test -z "$(find "$dir/photos" -name '.imageslim-partial-*')"
+	check "steps shown" grep -qF "gm convert {1} -resize '800x800>' {2}" "$dir/out.txt"
+	: >"$FAKEGM_LOG"
+	check "rerun succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "nothing redone" count_calls convert 0
+
+	rm -rf "$dir/photos/output"
+	job "steps: [\"exec echo {in} >>../exec.log; tr a-z A-Z <{in} >{out}\", strip]" "resize: 640x640"
+	check "exec step run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "source handed to the command" grep -qx "sub/deep/d.jpeg" "$dir/exec.log"
+	check "gm reads the command's output" has_call "convert output/.imageslim-partial-a.jpg.1.jpg +profile * output/.imageslim-partial-a.jpg.2.miff"
+	check "job's resize not applied" not grep -q "^convert a.jpg -resize" "$FAKEGM_LOG"
+
+	check "exec steps refused in safe mode" not imageslim run -safe-mode "$dir/job.yaml" 2>"$dir/err.txt"
+	check "safe mode names them" grep -q "safe mode forbids exec steps" "$dir/err.txt"
+
+	job "steps: [auto_orient]" "sharpen: on"
+	check "options a step replaces refused" not imageslim run "$dir/job.yaml" 2>"$dir/err.txt"
+	check "step named" grep -q "cannot be combined with sharpening (make it a sharpen step)" "$dir/err.txt"
+	job "steps: [blur]"
+	check "unknown step refused" not imageslim run "$dir/job.yaml" 2>"$dir/err.txt"
+	check "steps listed" grep -q 'step "blur": unknown operation "blur"; steps are auto_orient, resize' "$dir/err.txt"
+	job "steps: [watermark]"
+	check "bare watermark without one refused" not imageslim run "$dir/job.yaml" 2>"$dir/err.txt"
+}
+
+test_step_conditions() {
+	setup step_conditions
+	printf 'logo\n' >"$dir/logo.png"
+	printf 'original flat\n' >"$dir/photos/sub/flat.png"
+	job "steps:" "  - watermark ./logo.png if landscape and width > 600" "  - strip" "  - optimize lossy if png and colors>256"
+	check "run succeeds" env PATH="$root/test/fakepng:$PATH" FAKEGM_PORTRAIT=d.jpeg FAKEGM_COLORFUL=c.png imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "landscape watermarked" grep -q "^composite .* a.jpg output/.imageslim-partial-a.jpg.1.miff\$" "$FAKEGM_LOG"
+	check "portrait left alone" not grep -q "^composite .* sub/deep/d.jpeg " "$FAKEGM_LOG"
+	check "other steps still run" has_call "convert sub/deep/d.jpeg +profile * output/sub/deep/.imageslim-partial-d.jpeg.1.miff"
+	check "colourful PNG quantised" grep -q "^pngquant .*output/sub/.imageslim-partial-c.png.4.png" "$FAKEGM_LOG"
+	check "flat PNG left alone" not grep -q "^pngquant .*flat.png" "$FAKEGM_LOG"
+	check "JPEGs left alone" not grep -q "^pngquant .*a.jpg" "$FAKEGM_LOG"
+	check "colours only counted for PNGs" test "$(grep -c "^identify -format %k" "$FAKEGM_LOG")" -eq 2
+	check "conditions shown" grep -qF "(if landscape and width > 600)" "$dir/out.txt"
+	: >"$FAKEGM_LOG"
+	check "rerun succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "nothing redone" count_calls convert 0
+	job "steps: [\"strip if landscape and width > 700\"]"
+	check "changed condition succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "step skipped for every file" not grep -q "+profile" "$FAKEGM_LOG"
+	check "changed condition converts again" is_original "$dir/photos/output/a.jpg"
+
+	rm -rf "$dir/photos/output"
+	job "steps:" "  - exec sh -c 'true copy it if it is there; cp \"\$0\" \"\$1\"' {in} {out}"
+	check "command ending in a quoted if succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "its if is no condition" is_original "$dir/photos/output/a.jpg"
+	rm -rf "$dir/photos/output"
+	job "steps:" "  - exec sh -c 'test -s \"\$0\" && if true; then cp \"\$0\" \"\$1\"; fi; echo \"\$0\" >>../if.log' {in} {out} if png"
+	check "quoted if left in the command" imageslim run "$dir/job.yaml" >/dev/null
+	check "condition after it applied" test "$(sort "$dir/if.log" | tr '\n' ' ')" = "sub/c.png sub/flat.png "
+	check "command ran its if" is_original "$dir/photos/output/sub/c.png"
+	job "steps: [\"exec convert-it if {in} {out}\"]"
+	check "unquoted if refused" not imageslim run "$dir/job.yaml" 2>"$dir/err.txt"
+	check "quoting suggested" grep -qF "the command's \" if \" must be quoted" "$dir/err.txt"
+
+	job "steps: [\"strip if wide\"]"
+	check "unknown condition refused" not imageslim run "$dir/job.yaml" 2>"$dir/err.txt"
+	check "conditions listed" grep -q '"wide" is not one of landscape, portrait' "$dir/err.txt"
+	job "steps: [\"strip if size > lots\"]"
+	check "bad value refused" not imageslim run "$dir/job.yaml" 2>/dev/null
+}
+
+test_inspect() {
+	setup inspect
+	: >"$dir/photos/sub/deep/d.jpeg"
+	FAKEGM_EXIF_DATE="2024:07:14 10:22:33" FAKEGM_ORIENTATION=6 imageslim inspect "$dir/photos" >"$dir/out.txt" 2>"$dir/err.txt"
+	check "unreadable file fails the command" test $? -eq 1
+	check "header printed" grep -Eq "^FILE +SIZE +PIXELS +FORMAT +COLOUR +EXIF" "$dir/out.txt"
+	check "JPEG described" grep -Eq "^a.jpg +[0-9]+ B +640×480 +JPEG +RGB +Canon EOS 5D, 2024-07-14 10:22, sideways\$" "$dir/out.txt"
+	check "PNG alpha channel shown" grep -Eq "^sub/c.png .* PNG +RGB\+alpha" "$dir/out.txt"
+	check "other files left out" not grep -q "notes.txt" "$dir/out.txt"
+	check "unreadable file marked" grep -Eq "^sub/deep/d.jpeg +0 B +unreadable" "$dir/out.txt"
+	check "its error printed" grep -q "sub/deep/d.jpeg: gm identify: Improper image header" "$dir/err.txt"
+	check "identify only pings" grep -q "^identify -ping -format %m" "$FAKEGM_LOG"
+	check "flat stays in the folder" not sh -c "imageslim inspect -flat '$dir/photos' | grep -q sub/"
+	check "nothing converted" count_calls convert 0
+}
+
+test_alpha() {
+	setup alpha
+	rm "$dir/photos/sub/c.png"
+	b64() { printf '%s' "$1" | base64 -d >"$dir/photos/$2"; }
+	b64 iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR4nGP4z8DwHwAFAAH/iZk9HQAAAABJRU5ErkJggg== opaque.png
+	b64 iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR4nGP4z8DAAAAEAQEARwbK3gAAAABJRU5ErkJggg== sub/logo.png
+	b64 iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAIAAACQd1PeAAAADElEQVR4nGP4z8AAAAMBAQDJ/pLvAAAAAElFTkSuQmCC sub/rgb.png
+	# WebPs: an extended one with the alpha flag, and a simple lossy one.
+	printf 'RIFF\000\000\000\000WEBPVP8X\012\000\000\000\020\000\000\000 opaque\n' >"$dir/photos/banner.webp"
+	printf 'RIFF\000\000\000\000WEBPVP8X\012\000\000\000\020\000\000\000 transparent\n' >"$dir/photos/sub/icon.webp"
+	printf 'RIFF\000\000\000\000WEBPVP8 \012\000\000\000\000\000\000\000 photo\n' >"$dir/photos/sub/deep/photo.webp"
+
+	check "audit succeeds" imageslim alpha -all "$dir/photos" >"$dir/out.txt"
+	check "opaque PNG listed" grep -qxF "  opaque.png  opaque alpha channel" "$dir/out.txt"
+	check "opaque WebP listed" grep -qxF "  banner.webp  opaque alpha channel" "$dir/out.txt"
+	check "transparent PNG listed" grep -qxF "  sub/logo.png  uses transparency" "$dir/out.txt"
+	check "transparent WebP listed" grep -qxF "  sub/icon.webp  uses transparency" "$dir/out.txt"
+	check "summary" grep -q "2 file(s) in .* have an alpha channel that is fully opaque; 2 use transparency, 2 have none" "$dir/out.txt"
+	check "only WebPs with alpha decoded" count_calls convert 2
+	check "flat audit" imageslim alpha -flat "$dir/photos" >"$dir/out.txt"
+	check "flat summary" grep -q "2 file(s) .* 0 use transparency, 0 have none" "$dir/out.txt"
+
+	: >"$FAKEGM_LOG"
+	job 'patterns: ["*.png", "*.webp"]' "drop_alpha: true"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "opaque alpha dropped" has_call "convert opaque.png +matte -resize 1200x1200> -quality 80 output/opaque.png"
+	check "opaque WebP alpha dropped" has_call "convert banner.webp +matte -resize 1200x1200> -quality 80 output/banner.webp"
+	check "transparency kept" has_call "convert sub/logo.png -resize 1200x1200> -quality 80 output/sub/logo.png"
+	check "no alpha left alone" has_call "convert sub/rgb.png -resize 1200x1200> -quality 80 output/sub/rgb.png"
+	check "override flag" imageslim run -drop-alpha -force "$dir/job.yaml" >/dev/null
+}
+
+test_banding() {
+	setup banding
+	mkdir -p "$dir/photos/sky" "$dir/photos/docs"
+	printf '%s' iVBORw0KGgoAAAANSUhEUgAAAEAAAABACAAAAACPAi4CAAAAa0lEQVR42u3MBxpCAAAGUAcjKWQUSkLRMpMRsi/vHr7/HeARJLWi18xmy3L8ThAleX9QVO140s/GxbTs681x74/n6+35QRjFn+SbZnnxK6v637RdP4wTgQABAgQIECBAgAABAgQIECBYejADdBy4TErOK3EAAAAASUVORK5CYII= | base64 -d >"$dir/photos/sky/dusk.png"
+	printf '%s' iVBORw0KGgoAAAANSUhEUgAAAEAAAABACAAAAACPAi4CAAAAKklEQVR42u3MQREAAAwCIPsnM5Yh9ttBANKjCAQCgUAgEAgEAoFAIPgeDAQKgLWRjkNPAAAAAElFTkSuQmCC | base64 -d >"$dir/photos/docs/page.png"
+
+	job "max_colors: 32" "mode: overwrite"
+	check "run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "colours reduced without dithering" has_call "mogrify -resize 1200x1200> +dither -colors 32 -quality 80 sky/dusk.png"
+	check "gradient folder warned about" grep -qxF "warning: banding likely in sky with 32 colours without dithering: 1 of 1 sampled images are smooth gradients; for that folder try dither: true" "$dir/out.txt"
+	check "flat folder not warned about" not grep -q "banding likely in docs" "$dir/out.txt"
+
+	job "max_colors: 32" "dither: true" "mode: overwrite" "force: true"
+	check "dithered run succeeds" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "dithering asked of gm" has_call "mogrify -resize 1200x1200> -dither -colors 32 -quality 80 sub/c.png"
+	check "no warning with dithering" not grep -q "banding likely" "$dir/out.txt"
+
+	rm "$dir/photos/sky/dusk.png"
+	printf '%s' iVBORw0KGgoAAAANSUhEUgAAAEAAAABACAAAAACPAi4CAAAAa0lEQVR42u3MBxpCAAAGUAcjKWQUSkLRMpMRsi/vHr7/HeARJLWi18xmy3L8ThAleX9QVO140s/GxbTs681x74/n6+35QRjFn+SbZnnxK6v637RdP4wTgQABAgQIECBAgAABAgQIECBYejADdBy4TErOK3EAAAAASUVORK5CYII= | base64 -d >"$dir/photos/sky/dusk.png"
+	job "quality: 50" "format: webp"
+	check "low quality run succeeds" env PATH="$root/test/fakecodec:$PATH" imageslim run "$dir/job.yaml" >"$dir/out.txt"
+	check "low quality warned about" grep -q "banding likely in sky with quality 50: .* try quality 75 or more" "$dir/out.txt"
+	job "quality: 50"
+	check "PNG output not warned about" imageslim run -force "$dir/job.yaml" >"$dir/out.txt"
+	check "no quality warning for PNGs" not grep -q "banding likely" "$dir/out.txt"
+	check "colour count checked" not imageslim run -max-colors 1 "$dir/job.yaml" 2>/dev/null
+}
+
+test_approval() {
+	setup approval
+	job "mode: overwrite" "backup: true"
+	check "approval succeeds" imageslim approval -count 3 -zip "$dir/job.yaml" >"$dir/out.txt"
+	for f in B.JPG sub/c.png sub/deep/d.jpeg; do
+		check "$f copied unchanged" cmp -s "$dir/photos/$f" "$dir/photos/approval/before/$f"
+		check "$f converted" is_converted "$dir/photos/approval/after/$f"
+	done
+	check "one file per folder first" test ! -e "$dir/photos/approval/before/a.jpg"
+	check "originals untouched" is_original "$dir/photos/a.jpg"
+	check "no backup made" test ! -e "$dir/photos/.imageslim-backup"
+	check "no manifest among the pairs" test ! -e "$dir/photos/approval/after/.imageslim-manifest"
+	check "converted with convert" count_calls convert 3
+	check "zip holds the pairs" sh -c "unzip -l '$dir/photos/approval.zip' | grep -q 'approval/after/sub/deep/d.jpeg'"
+	check "pairs listed" grep -q "3 before/after pair(s)" "$dir/out.txt"
+
+	job
+	: >"$FAKEGM_LOG"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "approval folder not scanned" count_calls convert 4
+	check "approval export replaced" sh -c "imageslim approval -count 1 '$dir/job.yaml' >/dev/null"
+	check "earlier zip removed" test ! -e "$dir/photos/approval.zip"
+	check "earlier pairs removed" test ! -e "$dir/photos/approval/before/B.JPG"
+	check "outputs never picked" test ! -e "$dir/photos/approval/before/output"
+	check "marker not zipped" sh -c "imageslim approval -count 1 -zip '$dir/job.yaml' >/dev/null && ! unzip -l '$dir/photos/approval.zip' | grep -q .imageslim-approval"
+
+	rm -rf "$dir/photos/approval" "$dir/photos/approval.zip" "$dir/photos/output"
+	mkdir "$dir/photos/approval"
+	printf 'original contract\n' >"$dir/photos/approval/signed-contract.jpg"
+	check "user's approval folder not replaced" not imageslim approval "$dir/job.yaml" 2>"$dir/err.txt"
+	check "reason given" grep -q "approval is not an approval export of ImageSlim's" "$dir/err.txt"
+	check "user's file kept" is_original "$dir/photos/approval/signed-contract.jpg"
+	: >"$FAKEGM_LOG"
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "user's approval folder scanned" has_call "convert approval/signed-contract.jpg -resize 1200x1200> -quality 80 output/approval/signed-contract.jpg"
+
+	rm -rf "$dir/photos/approval" "$dir/photos/output"
+	printf 'signed contracts\n' >"$dir/photos/approval.zip"
+	check "user's approval zip not replaced" not imageslim approval -zip "$dir/job.yaml" 2>"$dir/err.txt"
+	check "zip refusal explained" grep -q "approval.zip is not an approval export of ImageSlim's; move it away first" "$dir/err.txt"
+	check "user's zip kept" grep -q "^signed contracts$" "$dir/photos/approval.zip"
+	check "refused without -zip too" not imageslim approval "$dir/job.yaml" 2>/dev/null
+	check "no folder left to vouch for it" test ! -e "$dir/photos/approval"
+}
+
+test_sampling() {
+	setup sampling
+	mkdir -p "$dir/photos/many"
+	for i in 0 1 2 3 4 5 6 7 8 9; do
+		printf 'original %s %0200d\n' "many/$i.jpg" 0 >"$dir/photos/many/$i.jpg"
+	done
+	job "scope: recursive" "sample_size: 6"
+	check "job's sample size used" sh -c "imageslim approval '$dir/job.yaml' | grep -q '6 before/after pair(s)'"
+	imageslim approval "$dir/job.yaml" | grep '^  ' >"$dir/unseeded.txt"
+	imageslim approval -seed 7 "$dir/job.yaml" | grep '^  ' >"$dir/seed7.txt"
+	imageslim approval -seed 7 "$dir/job.yaml" | grep '^  ' >"$dir/seed7-again.txt"
+	check "same seed, same files" cmp -s "$dir/seed7.txt" "$dir/seed7-again.txt"
+	check "seed picks other files" not cmp -s "$dir/unseeded.txt" "$dir/seed7.txt"
+	check "still one file per folder first" grep -q '^  a.jpg$\|^  B.JPG$' "$dir/seed7.txt"
+	check "-count beats the job" sh -c "imageslim approval -count 2 '$dir/job.yaml' | grep -q '2 before/after pair(s)'"
+	job "sample_size: -1"
+	check "negative sample size rejected" not imageslim run "$dir/job.yaml" 2>/dev/null
+}
+
+test_serve() {
+	setup serve
+	if ! command -v curl >/dev/null; then
+		echo "    skipped: needs curl"
+		return
+	fi
+	mkdir -p "$work/serve-outside" "$dir/linked"
+	printf 'original x %0200d\n' 0 >"$work/serve-outside/x.jpg"
+	cp "$work/serve-outside/x.jpg" "$dir/linked/"
+	ln -s "$work/serve-outside" "$dir/escape"
+	ln -s "$work/serve-outside" "$dir/linked/output"
+	IMAGESLIM_TOKEN=secret PROBE=photos imageslim serve -root "$dir" -addr 127.0.0.1:0 >"$dir/serve.log" 2>&1 &
+	local pid=$! url=""
+	for _ in $(seq 50); do
+		url=$(sed -n 's/^Serving jobs in .* on //p' "$dir/serve.log")
+		[ -n "$url" ] && break
+		sleep 0.1
+	done
+	api() { curl -s -H "Authorization: Bearer secret" "$@"; }
+
+	check "server starts" test -n "$url"
+	check "token required" test "$(curl -s -o /dev/null -w '%{http_code}' "$url/jobs")" = 401
+	api -X POST -d '{"dir": "photos", "resize": "800x800", "quality": 70, "scope": "flat"}' "$url/jobs" >"$dir/submit.json"
+	check "job accepted" grep -q '"id": 1' "$dir/submit.json"
+	for _ in $(seq 50); do
+		api "$url/jobs/1" >"$dir/status.json"
+		grep -q '"finished"' "$dir/status.json" && break
+		sleep 0.1
+	done
+	check "job finishes" grep -q '"state": "ok"' "$dir/status.json"
+	check "progress complete" grep -q '"done": 2' "$dir/status.json"
+	check "flat scope honoured" test ! -e "$dir/photos/output/sub/c.png"
+	check "settings passed to gm" has_call "convert a.jpg -resize 800x800> -quality 70 output/a.jpg"
+	api "$url/jobs/1/files" >"$dir/files.json"
+	check "report rows returned" grep -q '"output": "output/a.jpg"' "$dir/files.json"
+	check "output downloadable" sh -c "curl -s -H 'Authorization: Bearer secret' '$url/jobs/1/output/a.jpg' | grep -q '^fake-gm '"
+	check "outside root rejected" sh -c "curl -s -H 'Authorization: Bearer secret' -d '{\"dir\": \"/\"}' '$url/jobs' | grep -q 'outside'"
+	check "hooks rejected" sh -c "curl -s -H 'Authorization: Bearer secret' -d '{\"dir\": \"photos\", \"hooks\": {\"before\": [\"true\"]}}' '$url/jobs' | grep -q 'not accepted'"
+	check "exec rejected" test "$(api -o "$dir/exec.json" -w '%{http_code}' -d '{"dir": "photos", "exec": "touch ../pwned; cp {in} {out}"}' "$url/jobs")" = 400
+	check "exec named" grep -q 'shell commands (an exec command)' "$dir/exec.json"
+	check "exec not run" test ! -e "$dir/pwned"
+	check "exec steps rejected" test "$(api -o "$dir/exec.json" -w '%{http_code}' -d '{"dir": "photos", "steps": ["exec touch ../pwned; cp {in} {out}"]}' "$url/jobs")" = 400
+	check "exec steps named" grep -q 'shell commands (exec steps)' "$dir/exec.json"
+	check "exec step not run" test ! -e "$dir/pwned"
+	check "watermark step outside root rejected" sh -c "curl -s -H 'Authorization: Bearer secret' -d '{\"dir\": \"photos\", \"steps\": [\"watermark /etc/hostname\"]}' '$url/jobs' | grep -q 'steps: /etc/hostname is outside'"
+	check "linked dir outside root rejected" test "$(api -o "$dir/link.json" -w '%{http_code}' -d '{"dir": "escape"}' "$url/jobs")" = 400
+	check "link named" grep -q "dir: $dir/escape is outside" "$dir/link.json"
+	check "report through a link rejected" test "$(api -o "$dir/link.json" -w '%{http_code}' -d '{"dir": "photos", "report": "escape/report.jsonl"}' "$url/jobs")" = 400
+	check "output linked outside rejected" test "$(api -o /dev/null -w '%{http_code}' -d '{"dir": "linked"}' "$url/jobs")" = 400
+	check "follow_symlinks rejected" test "$(api -o "$dir/link.json" -w '%{http_code}' -d '{"dir": "photos", "follow_symlinks": "once"}' "$url/jobs")" = 400
+	check "follow_symlinks named" grep -q "follow_symlinks: not accepted over HTTP" "$dir/link.json"
+	check "outside left alone" sh -c "ls '$work/serve-outside' | grep -qx x.jpg && test \"\$(ls '$work/serve-outside' | wc -l)\" -eq 1"
+	check "environment not expanded" test "$(api -o "$dir/env.json" -w '%{http_code}' -d '{"dir": "${PROBE}"}' "$url/jobs")" = 400
+	check "path kept as sent" grep -qF '${PROBE}' "$dir/env.json"
+	check "unknown job is 404" test "$(api -o /dev/null -w '%{http_code}' "$url/jobs/9")" = 404
+	kill "$pid"
+	wait "$pid" 2>/dev/null
+}
+
+test_config_layers() {
+	setup config-layers
+	mkdir -p "$dir/project/sub"
+	printf 'output: ask\nreport_format: html\npresets:\n  - name: Team\n    quality: 70\n' >"$dir/system.yaml"
+	printf 'report_format: junit\npresets:\n  - name: Team\n    quality: 75\n  - name: Mine\n    quality: 90\n' >"$dir/user.yaml"
+	printf 'theme:\n  accent: "#112233"\n' >"$dir/project/.imageslim.yaml"
+	show() {
+		(cd "$dir/project/sub" && IMAGESLIM_SYSTEM_CONFIG="$dir/system.yaml" IMAGESLIM_CONFIG="$dir/user.yaml" imageslim config show "$@")
+	}
+	check "show succeeds" show -origin >"$dir/out.txt"
+	check "system value" grep -Eq "^output +ask +$dir/system.yaml\$" "$dir/out.txt"
+	check "user file over the system's" grep -Eq "^report_format +junit +$dir/user.yaml\$" "$dir/out.txt"
+	check "project file found above the working directory" grep -Eq "^theme.accent +#112233 +$dir/project/.imageslim.yaml\$" "$dir/out.txt"
+	check "presets merged by name" grep -Eq "^preset +Team +$dir/user.yaml\$" "$dir/out.txt"
+	check "defaults left out once a layer has presets" not grep -q "Web 1200px" "$dir/out.txt"
+	check "unset values are defaults" grep -Eq "^theme.background +auto +default\$" "$dir/out.txt"
+	IMAGESLIM_REPORT_FORMAT=md show -origin -set output=preserve >"$dir/out.txt"
+	check "environment over files" grep -Eq "^report_format +markdown +environment \(IMAGESLIM_REPORT_FORMAT\)\$" "$dir/out.txt"
+	check "-set over everything" grep -Eq "^output +preserve +-set\$" "$dir/out.txt"
+	check "YAML without -origin" sh -c "cd '$dir/project' && IMAGESLIM_SYSTEM_CONFIG='$dir/system.yaml' IMAGESLIM_CONFIG='$dir/user.yaml' imageslim config show | grep -q '^report_format: junit\$'"
+	check "unknown setting rejected" not show -set colour=red 2>/dev/null
+	printf 'output: sometimes\n' >"$dir/system.yaml"
+	show -origin >/dev/null 2>"$dir/err.txt"
+	check "bad file named" grep -q "$dir/system.yaml: output must be" "$dir/err.txt"
+}
+
+test_safe_mode() {
+	setup safe-mode
+	job "mode: overwrite"
+	check "overwrite job refused" not imageslim run -safe-mode "$dir/job.yaml" 2>"$dir/err.txt"
+	check "reason given" grep -q "safe-mode: safe mode forbids overwrite mode" "$dir/err.txt"
+	check "original untouched" is_original "$dir/photos/a.jpg"
+	check "no gm calls" count_calls convert 0
+	job "hooks:" "  before: [\"touch $dir/ran\"]" "notify:" "  command: touch $dir/notified"
+	check "hooks refused in a batch" not imageslim batch -safe-mode "$dir/job.yaml" 2>"$dir/err.txt"
+	check "hooks and notify named" grep -q "safe mode forbids hooks, a notify command" "$dir/err.txt"
+	check "hook not run" test ! -e "$dir/ran"
+	job "scope: flat"
+	check "preserve job runs" imageslim run -safe-mode "$dir/job.yaml" >/dev/null
+	check "output written" is_converted "$dir/photos/output/a.jpg"
+
+	printf 'safe_mode: true\n' >"$work/system-config.yaml"
+	job "mode: overwrite"
+	check "system file locks safe mode on" not imageslim run "$dir/job.yaml" 2>/dev/null
+	check "edit refuses the job" not imageslim edit "$dir/job.yaml" </dev/null 2>"$dir/err.txt"
+	check "edit says why" grep -q "safe mode forbids overwrite mode" "$dir/err.txt"
+	check "orient refused" not imageslim orient "$dir/photos" 2>"$dir/err.txt"
+	check "orient points at -dry-run" grep -q "safe mode forbids; -dry-run lists them" "$dir/err.txt"
+	check "lock shown with its file" sh -c "imageslim config show -origin | grep -Eq '^safe_mode +true +$work/system-config.yaml\$'"
+	printf 'safe_mode: true\noutput: sometimes\n' >"$work/system-config.yaml"
+	check "a mistake elsewhere in the file keeps the lock" not imageslim run "$dir/job.yaml" 2>/dev/null
+	check "originals still untouched" is_original "$dir/photos/a.jpg"
+	rm "$work/system-config.yaml"
+
+	job "mode: overwrite" "backup: true" "trash: true"
+	check "overwrite run without safe mode" imageslim run "$dir/job.yaml" >/dev/null
+	check "restore refused" not imageslim restore -safe-mode "$dir/photos" 2>"$dir/err.txt"
+	check "restore says why" grep -q "restore writes over the files in DIR and removes the backup, which safe mode forbids" "$dir/err.txt"
+	check "undo refused" not imageslim undo -safe-mode "$dir/photos" 2>"$dir/err.txt"
+	check "undo says why" grep -q "undo writes over the files in DIR and removes the run from the trash, which safe mode forbids" "$dir/err.txt"
+	printf 'safe_mode: true\n' >"$work/system-config.yaml"
+	check "restore refused when locked" not imageslim restore -keep "$dir/photos" 2>/dev/null
+	check "undo refused when locked" not imageslim undo "$dir/photos" 2>/dev/null
+	rm "$work/system-config.yaml"
+	check "files left converted" is_converted "$dir/photos/a.jpg"
+	check "backup kept" is_original "$dir/photos/.imageslim-backup/a.jpg"
+	check "trash kept" is_original "$dir/photos/.imageslim-trash/"*/a.jpg
+	check "restore runs without safe mode" imageslim restore "$dir/photos" >/dev/null
+	check "original back" is_original "$dir/photos/a.jpg"
+}
+
+test_serve_reload() {
+	setup serve-reload
+	if ! command -v curl >/dev/null; then
+		echo "    skipped: needs curl"
+		return
+	fi
+	printf 'presets:\n  - name: Small\n    resize: 400x400\n    quality: 60\n' >"$dir/config.yaml"
+	IMAGESLIM_CONFIG="$dir/config.yaml" imageslim serve -root "$dir" -addr 127.0.0.1:0 -reload 100ms >"$dir/serve.log" 2>&1 &
+	local pid=$! url=""
+	for _ in $(seq 50); do
+		url=$(sed -n 's/^Serving jobs in .* on //p' "$dir/serve.log")
+		[ -n "$url" ] && break
+		sleep 0.1
+	done
+	wait_job() {
+		for _ in $(seq 50); do
+			curl -s "$url/jobs/$1" | grep -q '"finished"' && return
+			sleep 0.1
+		done
+	}
+
+	check "server starts" test -n "$url"
+	check "presets listed" sh -c "curl -s '$url/presets' | grep -q '\"name\": \"Small\"'"
+	curl -s -d '{"dir": "photos", "scope": "flat"}' "$url/jobs?preset=Small" >/dev/null
+	wait_job 1
+	check "preset applied" has_call "convert a.jpg -resize 400x400> -quality 60 output/a.jpg"
+	check "unknown preset rejected" sh -c "curl -s -d '{\"dir\": \"photos\"}' '$url/jobs?preset=Huge' | grep -q 'no preset .*Huge'"
+
+	printf 'presets:\n  - name: Small\n    resize: 400x400\n    quality: 55\n  - name: Huge\n    resize: 4000x4000\n' >"$dir/config.yaml"
+	for _ in $(seq 50); do
+		grep -q '^Reloaded' "$dir/serve.log" && break
+		sleep 0.1
+	done
+	check "reload logged" grep -q "^Reloaded $dir/config.yaml: preset \"Small\" changed; preset \"Huge\" added$" "$dir/serve.log"
+	curl -s -d '{"dir": "photos", "scope": "flat", "quality": 90}' "$url/jobs?preset=Huge" >/dev/null
+	wait_job 2
+	check "new preset used without restart" has_call "convert a.jpg -resize 4000x4000> -quality 90 output/a.jpg"
+
+	echo 'presets: [' >"$dir/config.yaml"
+	for _ in $(seq 50); do
+		grep -q 'not reloaded' "$dir/serve.log" && break
+		sleep 0.1
+	done
+	check "broken file reported" grep -q 'not reloaded, keeping the previous settings' "$dir/serve.log"
+	check "previous presets kept" sh -c "curl -s '$url/presets' | grep -q '\"name\": \"Huge\"'"
+	kill "$pid"
+	wait "$pid" 2>/dev/null
+}
+
+test_plain() {
+	setup plain
+	# The directory, then the default for every other question.
+	{
+		echo "$dir/photos"
+		yes "" | head -40
+	} | IMAGESLIM_CONFIG="$dir/config.yaml" imageslim -plain >"$dir/out.txt" 2>&1
+	check "files counted before the run" grep -q "Found 4 files, 8[0-9][0-9] B in $dir/photos\." "$dir/out.txt"
+	check "count comes before the question" sh -c "grep -A1 'Found 4 files' '$dir/out.txt' | grep -q '^Start processing?'"
+	check "run went ahead" is_converted "$dir/photos/output/a.jpg"
+}
+
+test_formats() {
+	setup formats
+	check "formats succeeds" imageslim formats >"$dir/out.txt"
+	check "JPEG read and write" grep -Eq "^JPEG +yes +yes$" "$dir/out.txt"
+	check "HEIC read only" grep -Eq "^HEIC/HEIF +yes +no$" "$dir/out.txt"
+	check "AVIF unavailable" grep -Eq "^AVIF +no +no$" "$dir/out.txt"
+	check "-all lists descriptions" sh -c "imageslim formats -all | grep -q 'Google WebP image format'"
+}
+
+test_help() {
+	setup help
+	check "--help succeeds" imageslim --help 2>"$dir/help.txt"
+	check "overrides listed" grep -q -- "-interlace MODE" "$dir/help.txt"
+	check "environment listed" grep -q "IMAGESLIM_GM_VERSION" "$dir/help.txt"
+	check "help run succeeds" imageslim help run >"$dir/run.txt"
+	check "run flags listed" grep -q -- "-force  " "$dir/run.txt"
+	check "run -h succeeds" imageslim run -h 2>/dev/null
+	check "help metrics shows both forms" test "$(imageslim help metrics | grep -c '^Usage:')" -eq 2
+	check "unknown command rejected" not imageslim help nonsense 2>/dev/null
+	check "man succeeds" imageslim man >"$dir/imageslim.1"
+	check "man page header" grep -q '^\.TH IMAGESLIM 1' "$dir/imageslim.1"
+	check "man page flags" grep -q 'fB\\-baseline\\-tolerance\\fR \\fIPOINTS' "$dir/imageslim.1"
+	check "man page environment" grep -q '^\\fBIMAGESLIM_GM_PATH' "$dir/imageslim.1"
+}
+
+test_history() {
+	setup history
+	rm -f "$IMAGESLIM_HISTORY_FILE"
+	job
+	check "run succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	FAKEGM_FAIL='a.jpg' imageslim run -force "$dir/job.yaml" >/dev/null 2>&1
+	check "one entry per run" test "$(wc -l <"$IMAGESLIM_HISTORY_FILE")" -eq 2
+	check "job name recorded" grep -q '"name":"history"' "$IMAGESLIM_HISTORY_FILE"
+	check "absolute directory recorded" grep -q "\"Dir\":\"$dir/photos\"" "$IMAGESLIM_HISTORY_FILE"
+	check "failure recorded" grep -q '"error":' "$IMAGESLIM_HISTORY_FILE"
+	IMAGESLIM_HISTORY_FILE=off imageslim run -force "$dir/job.yaml" >/dev/null
+	check "nothing recorded when off" test "$(wc -l <"$IMAGESLIM_HISTORY_FILE")" -eq 2
+	local pids=""
+	for _ in 1 2 3 4 5 6 7 8; do
+		imageslim run -force "$dir/job.yaml" >/dev/null 2>&1 &
+		pids="$pids $!"
+	done
+	wait $pids
+	check "runs finishing at once all recorded" test "$(wc -l <"$IMAGESLIM_HISTORY_FILE")" -eq 10
+}
+
+test_metrics() {
+	setup metrics
+	rm -f "$IMAGESLIM_METRICS_FILE"
+	job
+	check "run without opt-in succeeds" imageslim run "$dir/job.yaml" >/dev/null
+	check "nothing recorded before opt-in" test ! -e "$IMAGESLIM_METRICS_FILE"
+	check "enable succeeds" imageslim metrics enable >/dev/null
+	check "run succeeds" imageslim run -force "$dir/job.yaml" >/dev/null
+	FAKEGM_FAIL='a.jpg' imageslim run -force "$dir/job.yaml" >/dev/null 2>&1
+	check "one record per run" test "$(wc -l <"$IMAGESLIM_METRICS_FILE")" -eq 2
+	check "no paths recorded" test -z "$(grep -e photos -e a.jpg "$IMAGESLIM_METRICS_FILE")"
+	check "show succeeds" imageslim metrics show >"$dir/out.txt"
+	check "success rate shown" grep -q "succeeded   1 (50%)" "$dir/out.txt"
+	check "failure category shown" grep -q "^gm-error " "$dir/out.txt"
+	check "disable succeeds" imageslim metrics disable >/dev/null
+	check "file deleted" test ! -e "$IMAGESLIM_METRICS_FILE"
+}
+
+# ---------------------------------------------------------------------------
+# Runner
+# ---------------------------------------------------------------------------
+
+filter=${1:-}
+ran=0
+for t in $(declare -F | awk '{print $3}' | grep '^test_'); do
+	case $t in *"$filter"*) ;; *) continue ;; esac
+	before=$failed
+	echo "--- ${t#test_}"
+	$t
+	ran=$((ran + 1))
+	if [ "$failed" -eq "$before" ]; then
+		echo "    ok"
+	fi
+done
+
+echo
+if [ "$failed" -gt 0 ]; then
+	echo "FAIL: $failed of $checks check(s) failed in $ran test(s)"
+	exit 1
+fi
+echo "PASS: $checks check(s) in $ran test(s)"